@@ -0,0 +1,268 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// PaymentAllocation is part of a payment allocated to one invoice
+type PaymentAllocation struct {
+	InvoiceID string       `json:"invoice_id"`
+	Amount    money.Amount `json:"amount"`
+}
+
+// sumAllocated returns what is allocated by allocations: those that are not
+// void
+func sumAllocated(allocations []CreditApplication) money.Amount {
+	var allocated money.Amount
+	for _, a := range allocations {
+		if a.VoidedAt == "" {
+			allocated = allocated.Add(a.Amount)
+		}
+	}
+	return allocated
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+// allocated returns what is allocated of a payment
+func allocated(q queryer, paymentID string) (money.Amount, error) {
+	var amount money.Amount
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM credit_applications
+		WHERE payment_id = $1 AND voided_at IS NULL`, paymentID).Scan(&amount)
+	return amount, err
+}
+
+// keepAllocated returns ErrPaymentAllocated if a receipt, as it is about to
+// be written, keeps less than what was allocated of it
+func keepAllocated(q queryer, p *Payment) error {
+	if p.InvoiceID != "" {
+		return nil
+	}
+	amount, err := allocated(q, p.ID)
+	if err != nil {
+		return err
+	}
+	if amount.Sign() > 0 && (p.VoidedAt != "" || p.Amount.Sub(p.RefundedAmount).Cmp(amount) < 0) {
+		return fmt.Errorf("%w: %s of it is allocated to invoices; void its allocations first", ErrPaymentAllocated, amount)
+	}
+	return nil
+}
+
+// withAllocations reads the allocations of a payment into it
+func withAllocations(q queryer, p *Payment) error {
+	rows, err := q.Query(`SELECT `+creditApplicationColumns+` FROM credit_applications WHERE payment_id = $1 ORDER BY created_at, id`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.Allocations = nil
+	for rows.Next() {
+		a, err := scanCreditApplication(rows)
+		if err != nil {
+			return err
+		}
+		p.Allocations = append(p.Allocations, *a)
+	}
+	p.AllocatedAmount = sumAllocated(p.Allocations)
+	return rows.Err()
+}
+
+// lockAllocationInvoices locks the invoices a payment of a customer in a
+// currency is allocated to: those of allocations, in order, or without any
+// all of the customer's with a balance in the currency, oldest due first.
+// They are locked before the customer, as a payment on an invoice locks
+// them.
+func (s *SQLStore) lockAllocationInvoices(tx *sql.Tx, customerID, currency string, allocations []PaymentAllocation) ([]*Invoice, error) {
+	var invoices []*Invoice
+	for _, a := range allocations {
+		inv, err := scanInvoice(tx.QueryRow(`
+			SELECT `+invoiceColumns+` FROM invoices
+			WHERE id = $1 AND org_id = $2 AND customer_id = $3 AND COALESCE(currency, 'USD') = $4`+s.dialect.forUpdate(),
+			a.InvoiceID, s.org, customerID, currency))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invoice %s of the customer in %s", err, a.InvoiceID, currency)
+		}
+		invoices = append(invoices, inv)
+	}
+	if len(allocations) > 0 {
+		return invoices, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+invoiceColumns+` FROM invoices
+		WHERE org_id = $1 AND customer_id = $2 AND COALESCE(currency, 'USD') = $3
+			AND total - paid_amount - credited_amount > 0
+		ORDER BY due_date IS NULL, due_date, created_at`+s.dialect.forUpdate(),
+		s.org, customerID, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// allocate allocates what is left of a locked receipt to locked invoices of
+// its customer: allocations[i] to invoices[i], or with no allocations as
+// much as is owed on each invoice that accepts payments in turn, until
+// nothing is left
+func (s *SQLStore) allocate(tx *sql.Tx, p *Payment, invoices []*Invoice, allocations []PaymentAllocation, createdBy string) error {
+	if p.InvoiceID != "" {
+		return fmt.Errorf("%w: it was recorded on invoice %s", ErrPaymentAllocated, p.InvoiceID)
+	}
+	if p.VoidedAt != "" {
+		return ErrPaymentVoided
+	}
+	taken, err := allocated(tx, p.ID)
+	if err != nil {
+		return err
+	}
+	left := p.Amount.Sub(p.RefundedAmount).Sub(taken)
+
+	for i, inv := range invoices {
+		owed := balance(inv)
+		amount := owed
+		if len(allocations) > 0 {
+			if err := lifecycle.Payable(inv.Status); err != nil {
+				return fmt.Errorf("invoice %s: %w", inv.InvoiceNumber, err)
+			}
+			amount = allocations[i].Amount.Round(p.Currency)
+			if amount.Cmp(owed) > 0 {
+				return fmt.Errorf("%w: %s is owed on invoice %s", ErrOverallocated, owed, inv.InvoiceNumber)
+			}
+			if amount.Cmp(left) > 0 {
+				if left.Sign() < 0 {
+					left = 0
+				}
+				return fmt.Errorf("%w: %s of the payment is left to allocate", ErrOverallocated, left)
+			}
+		} else {
+			if left.Sign() <= 0 {
+				break
+			}
+			if lifecycle.Payable(inv.Status) != nil {
+				continue
+			}
+			if left.Cmp(amount) < 0 {
+				amount = left
+			}
+		}
+		if amount.Sign() <= 0 {
+			continue
+		}
+
+		if err := s.allocateTo(tx, p, inv, amount, createdBy); err != nil {
+			return err
+		}
+		left = left.Sub(amount)
+	}
+	return nil
+}
+
+// allocateTo allocates amount of a locked receipt to a locked invoice, with a
+// credit application of the receipt dated like it
+func (s *SQLStore) allocateTo(tx *sql.Tx, p *Payment, inv *Invoice, amount money.Amount, createdBy string) error {
+	amount, err := creditAmount(tx, inv, amount)
+	if err != nil {
+		return err
+	}
+	_, err = s.insertCreditApplication(tx, inv, p.ID, amount, p.PaymentDate, createdBy)
+	return err
+}
+
+// AllocatePayment allocates what is left of a receipt, under the locks of
+// the invoices it is allocated to and then its customer's
+func (s *SQLStore) AllocatePayment(id string, allocations []PaymentAllocation, createdBy string) (*Payment, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invoiceID, customerID, currency string
+	err = tx.QueryRow(`SELECT invoice_id, customer_id, currency FROM payments WHERE id = $1 AND org_id = $2`, id, s.org).
+		Scan(text(&invoiceID), text(&customerID), text(&currency))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if invoiceID != "" {
+		return nil, fmt.Errorf("%w: it was recorded on invoice %s", ErrPaymentAllocated, invoiceID)
+	}
+
+	invoices, err := s.lockAllocationInvoices(tx, customerID, currency, allocations)
+	if err != nil {
+		return nil, err
+	}
+	p, _, err := s.lockPayment(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.allocate(tx, p, invoices, allocations, createdBy); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return s.GetPayment(id)
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// AllocatePayment allocates the payment through the allocate_payment
+// database function, which locks the invoices and the customer, checks what
+// is left and owed and records each allocation as a credit application of
+// its invoice, with payment_id set to the payment, in one transaction (see
+// migration 0020_payment_allocations)
+func (c *SupabaseStore) AllocatePayment(id string, allocations []PaymentAllocation, createdBy string) (*Payment, error) {
+	args := map[string]interface{}{
+		"p_payment_id": id,
+	}
+	if len(allocations) > 0 {
+		args["p_allocations"] = allocations
+	}
+	if createdBy != "" {
+		args["p_created_by"] = createdBy
+	}
+
+	var result Payment
+	if err := c.rpc("allocate_payment", args, &result); err != nil {
+		return nil, err
+	}
+	return c.GetPayment(id)
+}
+
+// withAllocations reads the allocations of a payment into it
+func (c *SupabaseStore) withAllocations(p *Payment) error {
+	var allocations []CreditApplication
+	_, err := c.from("credit_applications").Select("*", "", false).Eq("payment_id", p.ID).ExecuteTo(&allocations)
+	if err != nil {
+		return err
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].CreatedAt != allocations[j].CreatedAt {
+			return allocations[i].CreatedAt < allocations[j].CreatedAt
+		}
+		return allocations[i].ID < allocations[j].ID
+	})
+	p.Allocations = allocations
+	p.AllocatedAmount = sumAllocated(allocations)
+	return nil
+}
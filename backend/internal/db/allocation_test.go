@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// TestAllocatePayment checks that allocating a receipt pays invoices from
+// the customer's credit, and that voiding an allocation gives it back
+func TestAllocatePayment(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	first := testInvoice(t, s, c.ID, money.FromInt(60), lifecycle.Issued)
+	second := testInvoice(t, s, c.ID, money.FromInt(30), lifecycle.Issued)
+	later := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	if _, err := s.UpdateInvoice(second.ID, 0, "", "", later); err != nil {
+		t.Fatalf("UpdateInvoice: %v", err)
+	}
+	r := receipt(t, s, c.ID, money.FromInt(100))
+	checkCredit(t, s, c.ID, money.FromInt(100))
+
+	if _, err := s.AllocatePayment(r.ID, []PaymentAllocation{{InvoiceID: first.ID, Amount: money.FromInt(70)}}, ""); !errors.Is(err, ErrOverallocated) {
+		t.Errorf("allocating more than is owed: err = %v, want ErrOverallocated", err)
+	}
+	allocated, err := s.AllocatePayment(r.ID, []PaymentAllocation{{InvoiceID: first.ID, Amount: money.FromInt(60)}}, "")
+	if err != nil {
+		t.Fatalf("AllocatePayment: %v", err)
+	}
+	if allocated.AllocatedAmount != money.FromInt(60) || len(allocated.Allocations) != 1 {
+		t.Fatalf("payment allocated %s in %d allocations, want 60 in 1", allocated.AllocatedAmount, len(allocated.Allocations))
+	}
+	checkPaid(t, s, first.ID, money.FromInt(60), lifecycle.Paid)
+	checkCredit(t, s, c.ID, money.FromInt(40))
+
+	// Without allocations the rest goes to the invoices due first
+	if _, err := s.AllocatePayment(r.ID, nil, ""); err != nil {
+		t.Fatalf("AllocatePayment by due date: %v", err)
+	}
+	checkPaid(t, s, second.ID, money.FromInt(30), lifecycle.Paid)
+	checkCredit(t, s, c.ID, money.FromInt(10))
+
+	// Voiding an allocation returns it to the credit
+	if _, err := s.VoidCreditApplication(allocated.Allocations[0].ID, 0, "Wrong invoice"); err != nil {
+		t.Fatalf("VoidCreditApplication: %v", err)
+	}
+	checkPaid(t, s, first.ID, 0, lifecycle.Issued)
+	checkCredit(t, s, c.ID, money.FromInt(70))
+	p, err := s.GetPayment(r.ID)
+	if err != nil {
+		t.Fatalf("GetPayment: %v", err)
+	}
+	if p.AllocatedAmount != money.FromInt(30) {
+		t.Errorf("payment allocated %s after the void, want 30", p.AllocatedAmount)
+	}
+
+	// A payment recorded on an invoice cannot be allocated
+	paid := pay(t, s, first.ID, money.FromInt(10))
+	if _, err := s.AllocatePayment(paid.ID, nil, ""); !errors.Is(err, ErrPaymentAllocated) {
+		t.Errorf("allocating an invoice payment: err = %v, want ErrPaymentAllocated", err)
+	}
+}
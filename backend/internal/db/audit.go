@@ -0,0 +1,191 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Entity types recorded in the audit log
+const (
+	AuditCustomer       = "customer"
+	AuditInvoice        = "invoice"
+	AuditInvoiceItem    = "invoice_item" // Recorded under the invoice's ID
+	AuditPayment        = "payment"
+	AuditRefund         = "refund" // Recorded under the payment's ID
+	AuditNumberSeries   = "number_series"
+	AuditProduct        = "product"
+	AuditProductPrice   = "product_price" // Recorded under the product's ID
+	AuditPriceList      = "price_list"
+	AuditPriceListRule  = "price_list_rule" // Recorded under the price list's ID
+	AuditCompany        = "company_profile"
+	AuditCreditNote     = "credit_note"
+	AuditCreditNoteItem = "credit_note_item" // Recorded under the credit note's ID
+	// AuditCreditApplication is recorded under the invoice's ID
+	AuditCreditApplication = "credit_application"
+)
+
+// AuditEntry is one change recorded in the audit log. The log is written by
+// database triggers in the same transaction as the change, so every write is
+// recorded whichever backend or service made it.
+type AuditEntry struct {
+	ID         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	Action     string `json:"action"` // create, update or delete
+	Actor      string `json:"actor"`
+	// Changes maps each changed field to its value before and after the
+	// change: {"status": {"from": "draft", "to": "sent"}}
+	Changes   json.RawMessage `json:"changes"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+// setAuditContext makes the audit triggers attribute the transaction's
+// changes to actor and record them in the organization org; empty values
+// clear them. Postgres keeps them in the transaction-local app.actor and
+// app.org_id settings. SQLite has no session settings and keeps them in the
+// single-row audit_actor table, which is safe because SQLite runs one write
+// transaction at a time.
+func (d dialect) setAuditContext(tx *sql.Tx, actor, org string) error {
+	if d == dialectSQLite {
+		if actor == "" && org == "" {
+			_, err := tx.Exec(`DELETE FROM audit_actor`)
+			return err
+		}
+		if actor == "" {
+			actor = "system"
+		}
+		_, err := tx.Exec(`
+			INSERT INTO audit_actor (id, actor, org_id) VALUES (1, $1, $2)
+			ON CONFLICT (id) DO UPDATE SET actor = excluded.actor, org_id = excluded.org_id`, actor, nullIfEmpty(org))
+		return err
+	}
+	_, err := tx.Exec(`SELECT set_config('app.actor', $1, true), set_config('app.org_id', $2, true)`, actor, org)
+	return err
+}
+
+// begin opens a write transaction whose changes are attributed to the
+// store's actor and organization. It must be committed with commit.
+func (s *SQLStore) begin() (*sql.Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dialect.setAuditContext(tx, s.actor, s.org); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// commit clears the transaction's actor and organization and commits it
+func (s *SQLStore) commit(tx *sql.Tx) error {
+	if err := s.dialect.setAuditContext(tx, "", ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeRow runs a single INSERT, UPDATE or DELETE ... RETURNING statement in
+// an audited transaction and scans the returned row
+func writeRow[T any](s *SQLStore, scan func(rowScanner) (*T, error), query string, args ...interface{}) (*T, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	v, err := scan(tx.QueryRow(query, args...))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WithActor returns a view of the store whose changes are attributed to actor
+func (s *SQLStore) WithActor(actor string) Store {
+	c := *s
+	c.actor = actor
+	return &c
+}
+
+// GetHistory returns the entries of organization records only when they
+// were written in the store's organization
+func (s *SQLStore) GetHistory(entityID string, entityTypes ...string) ([]AuditEntry, error) {
+	args := []interface{}{entityID}
+	placeholders := make([]string, len(entityTypes))
+	scoped := false
+	for i, entityType := range entityTypes {
+		args = append(args, entityType)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+		scoped = scoped || tenantEntities[entityType]
+	}
+	conds := []string{"entity_id = $1", "entity_type IN (" + strings.Join(placeholders, ", ") + ")"}
+	if scoped {
+		args = append(args, s.org)
+		conds = append(conds, fmt.Sprintf("org_id = $%d", len(args)))
+	}
+	rows, err := s.db.Query(`
+		SELECT id, entity_type, entity_id, action, actor, changes, created_at
+		FROM audit_log`+whereClause(conds)+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var changes string
+		if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityID, &e.Action, &e.Actor, &changes, text(&e.CreatedAt)); err != nil {
+			return nil, err
+		}
+		e.Changes = json.RawMessage(changes)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// WithActor returns a view of the store whose requests carry actor in the
+// X-Actor header, which the audit triggers read from PostgREST's
+// request.headers setting
+func (c *SupabaseStore) WithActor(actor string) Store {
+	return c.with(actor, c.org)
+}
+
+func (c *SupabaseStore) GetHistory(entityID string, entityTypes ...string) ([]AuditEntry, error) {
+	query := c.supabase.From("audit_log").
+		Select("*", "", false).
+		Eq("entity_id", entityID).
+		In("entity_type", entityTypes)
+	for _, entityType := range entityTypes {
+		if tenantEntities[entityType] {
+			query = query.Eq("org_id", c.org)
+			break
+		}
+	}
+
+	entries := []AuditEntry{}
+	_, err := query.
+		Order("id", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
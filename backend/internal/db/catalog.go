@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"invoice-backend/internal/money"
+)
+
+// ErrSKUTaken is returned when saving a product with the SKU of another
+// product
+var ErrSKUTaken = errors.New("SKU is already used by another product")
+
+// Product is an entry of the product and service catalog. An invoice line
+// that references a product copies the product's details when the invoice
+// is created, so later catalog changes never alter an issued invoice.
+type Product struct {
+	ID          string `json:"id"`
+	SKU         string `json:"sku"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	// TaxRate is the default tax percentage of the product's lines; nil
+	// applies the invoice's tax
+	TaxRate *float64 `json:"tax_rate,omitempty"`
+	// Prices are the default unit prices, one per currency
+	Prices    []ProductPrice `json:"prices"`
+	Active    bool           `json:"active"` // Inactive products cannot be invoiced
+	CreatedAt string         `json:"created_at,omitempty"`
+	Version   int            `json:"version"`
+}
+
+// ProductPrice is the default unit price of a product in one currency
+type ProductPrice struct {
+	Currency  string       `json:"currency"`
+	UnitPrice money.Amount `json:"unit_price"`
+}
+
+// Price returns the product's unit price in currency
+func (p Product) Price(currency string) (money.Amount, bool) {
+	for _, price := range p.Prices {
+		if strings.EqualFold(price.Currency, currency) {
+			return price.UnitPrice, true
+		}
+	}
+	return 0, false
+}
+
+// ErrUnknownProduct is returned when saving a price list with a rule for a
+// product that is not in the catalog
+var ErrUnknownProduct = errors.New("product not found")
+
+// ErrUnknownPriceList is returned when assigning a customer a price list
+// that does not exist
+var ErrUnknownPriceList = errors.New("price list not found")
+
+// PriceList holds the prices negotiated with customers for catalog
+// products. Its rules apply to the invoices of the customers it is assigned
+// to, in the price list's currency only.
+type PriceList struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Currency    string      `json:"currency"`
+	Rules       []PriceRule `json:"rules"`
+	CreatedAt   string      `json:"created_at,omitempty"`
+	Version     int         `json:"version"`
+}
+
+// PriceRule prices a product on a price list from a minimum quantity up,
+// at either a fixed unit price or a percentage off the catalog price. Rules
+// for the same product at higher minimum quantities are volume breaks.
+type PriceRule struct {
+	ProductID   string       `json:"product_id"`
+	MinQuantity money.Amount `json:"min_quantity"` // Zero applies to any quantity
+	// UnitPrice is the fixed unit price, in the price list's currency
+	UnitPrice *money.Amount `json:"unit_price,omitempty"`
+	// DiscountPercent is the percentage off the product's catalog price
+	DiscountPercent *float64 `json:"discount_percent,omitempty"`
+}
+
+// Rule returns the rule of the price list that prices quantity of a
+// product: the one with the highest minimum quantity the quantity reaches,
+// or nil if there is none
+func (l PriceList) Rule(productID string, quantity money.Amount) *PriceRule {
+	var best *PriceRule
+	for i := range l.Rules {
+		rule := &l.Rules[i]
+		if rule.ProductID != productID || rule.MinQuantity.Cmp(quantity) > 0 {
+			continue
+		}
+		if best == nil || rule.MinQuantity.Cmp(best.MinQuantity) > 0 {
+			best = rule
+		}
+	}
+	return best
+}
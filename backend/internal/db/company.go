@@ -0,0 +1,106 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Logo formats
+	_ "image/png"
+	"strings"
+)
+
+var (
+	// ErrDefaultCompany is returned when deleting the default company
+	// profile; make another profile the default first
+	ErrDefaultCompany = errors.New("the default company profile cannot be deleted")
+	// ErrCompanyHasInvoices is returned when deleting a company profile that
+	// issued invoices
+	ErrCompanyHasInvoices = errors.New("company profile has issued invoices")
+	// ErrPrefixTaken is returned when creating a company profile with an
+	// invoice number prefix another company already uses
+	ErrPrefixTaken = errors.New("invoice number prefix is already used by another company")
+)
+
+// MaxLogoSize is the largest logo image a company profile accepts, in bytes
+const MaxLogoSize = 256 << 10
+
+// CompanyProfile is a company invoices are issued from. Its details, bank
+// account and logo are printed on the invoices it issues and sign the emails
+// about them, and its invoices are numbered from its own series. One profile
+// is the default, which issues the invoices that do not name a company.
+type CompanyProfile struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"` // Trading name, in the invoice header
+	LegalName  string `json:"legal_name,omitempty"`
+	TaxID      string `json:"tax_id,omitempty"`
+	Address    string `json:"address,omitempty"`
+	City       string `json:"city,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Phone      string `json:"phone,omitempty"`
+	Website    string `json:"website,omitempty"`
+	// Bank account invoices are paid into
+	BankName          string `json:"bank_name,omitempty"`
+	BankAccountName   string `json:"bank_account_name,omitempty"`
+	BankAccountNumber string `json:"bank_account_number,omitempty"`
+	BankSwift         string `json:"bank_swift,omitempty"`
+	// Logo is a PNG or JPEG image as a data URL (data:image/png;base64,...)
+	Logo string `json:"logo,omitempty"`
+	// InvoiceSeries is the numbering series of the company's invoices
+	InvoiceSeries string `json:"invoice_series"`
+	IsDefault     bool   `json:"is_default"`
+	CreatedAt     string `json:"created_at,omitempty"`
+	Version       int    `json:"version"`
+}
+
+// CompanyInvoiceSeries returns the key of the invoice numbering series
+// created for a new company profile
+func CompanyInvoiceSeries(companyID string) string {
+	return SeriesInvoice + ":" + companyID
+}
+
+// Validate checks that the profile can be printed on an invoice
+func (p CompanyProfile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, _, err := p.LogoImage(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LogoImage decodes the profile's logo, returning the image and its type as
+// gofpdf names it ("PNG" or "JPG"), or no image if the profile has no logo
+func (p CompanyProfile) LogoImage() ([]byte, string, error) {
+	if p.Logo == "" {
+		return nil, "", nil
+	}
+	header, data, ok := strings.Cut(p.Logo, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, "", fmt.Errorf("logo must be a base64 data URL")
+	}
+	var imageType string
+	switch strings.TrimSuffix(header, ";base64") {
+	case "data:image/png":
+		imageType = "PNG"
+	case "data:image/jpeg", "data:image/jpg":
+		imageType = "JPG"
+	default:
+		return nil, "", fmt.Errorf("logo must be a PNG or JPEG image")
+	}
+	img, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("logo is not valid base64")
+	}
+	if len(img) > MaxLogoSize {
+		return nil, "", fmt.Errorf("logo must be at most %d KB", MaxLogoSize>>10)
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(img)); err != nil {
+		return nil, "", fmt.Errorf("logo is not a valid image: %v", err)
+	}
+	return img, imageType, nil
+}
@@ -0,0 +1,485 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// PaymentMethodCredit is reserved: credit is applied with credit
+// applications, so payments may not use it
+const PaymentMethodCredit = "credit"
+
+// CreditBalance is what a customer has in credit in one currency: what was
+// paid beyond the balance of their invoices and received from them without
+// an invoice, less what was applied of it to invoices
+type CreditBalance struct {
+	Currency string       `json:"currency"`
+	Balance  money.Amount `json:"balance"`
+}
+
+// CreditApplication applies part of a customer's credit to one of their
+// invoices. Nothing is received when credit is applied, so it is not a
+// payment: it has no receipt number and is not listed among payments, but it
+// settles the invoice as a payment does. A wrong one is voided, which gives
+// the credit back. An allocation of a receipt is a credit application with
+// the receipt's ID in PaymentID, dated like the receipt.
+type CreditApplication struct {
+	ID         string       `json:"id"`
+	InvoiceID  string       `json:"invoice_id"`
+	PaymentID  string       `json:"payment_id,omitempty"`
+	CustomerID string       `json:"customer_id"`
+	Currency   string       `json:"currency"`
+	Amount     money.Amount `json:"amount"`
+	AppliedAt  string       `json:"applied_at"`
+	CreatedAt  string       `json:"created_at,omitempty"`
+	CreatedBy  string       `json:"created_by,omitempty"`
+	VoidedAt   string       `json:"voided_at,omitempty"`
+	VoidReason string       `json:"void_reason,omitempty"`
+	Version    int          `json:"version"`
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+// creditSQL computes a customer's ($1) credit in a currency ($2) from the
+// payment rows that are not void, less their refunds, and the credit
+// applications that are not void: the overpayments of their invoices and
+// their receipts, less their credit applications
+const creditSQL = `
+	SELECT
+		COALESCE((SELECT SUM(received - (total - credited_amount)) FROM (
+			SELECT i.total, i.credited_amount, SUM(r.amount) AS received
+			FROM (
+				SELECT invoice_id, amount - refunded_amount AS amount FROM payments
+				WHERE customer_id = $1 AND currency = $2 AND voided_at IS NULL
+				UNION ALL
+				SELECT invoice_id, amount FROM credit_applications
+				WHERE customer_id = $1 AND currency = $2 AND voided_at IS NULL
+			) r JOIN invoices i ON i.id = r.invoice_id
+			GROUP BY i.id, i.total, i.credited_amount
+		) paid WHERE received > total - credited_amount), 0)
+		+ COALESCE((SELECT SUM(amount - refunded_amount) FROM payments
+			WHERE customer_id = $1 AND currency = $2 AND invoice_id IS NULL AND voided_at IS NULL), 0)
+		- COALESCE((SELECT SUM(amount) FROM credit_applications
+			WHERE customer_id = $1 AND currency = $2 AND voided_at IS NULL), 0)`
+
+// credit returns a customer's credit in currency, computed from their
+// payments; it is negative when more was applied than there is
+func credit(q queryer, customerID, currency string) (money.Amount, error) {
+	var balance money.Amount
+	err := q.QueryRow(creditSQL, customerID, currency).Scan(&balance)
+	return balance, err
+}
+
+// lockCustomer locks a customer of the store's organization, so their credit
+// changes one payment at a time
+func (s *SQLStore) lockCustomer(tx *sql.Tx, id string) error {
+	var locked string
+	err := tx.QueryRow(`SELECT id FROM customers WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(), id, s.org).Scan(&locked)
+	return notFound(err)
+}
+
+// settleCredit recomputes a customer's credit in currency and stores it,
+// returning ErrInsufficientCredit if more was applied of it than there is
+func (s *SQLStore) settleCredit(tx *sql.Tx, customerID, currency string) error {
+	if customerID == "" {
+		return nil
+	}
+	if err := s.lockCustomer(tx, customerID); err != nil {
+		return err
+	}
+	balance, err := credit(tx, customerID, currency)
+	if err != nil {
+		return err
+	}
+	if balance.Sign() < 0 {
+		return fmt.Errorf("%w: %s %s of it was already applied; void what was applied from it first",
+			ErrInsufficientCredit, balance.Neg(), currency)
+	}
+
+	if balance.IsZero() {
+		_, err = tx.Exec(`DELETE FROM customer_credits WHERE customer_id = $1 AND currency = $2`, customerID, currency)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO customer_credits (org_id, customer_id, currency, balance, updated_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+			ON CONFLICT (customer_id, currency) DO UPDATE SET balance = excluded.balance, updated_at = CURRENT_TIMESTAMP`,
+			s.org, customerID, currency, balance)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update customer credit: %v", err)
+	}
+	return nil
+}
+
+// recordReceipt records a payment without an invoice, all of which goes to
+// its customer's credit unless it is allocated to their invoices
+func (s *SQLStore) recordReceipt(payment PaymentCreate) (*Payment, error) {
+	if payment.Currency == "" {
+		payment.Currency = "USD"
+	}
+	payment.Amount = payment.Amount.Round(payment.Currency)
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	allocating := payment.AutoAllocate || len(payment.Allocations) > 0
+	var invoices []*Invoice
+	if allocating {
+		invoices, err = s.lockAllocationInvoices(tx, payment.CustomerID, payment.Currency, payment.Allocations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.lockCustomer(tx, payment.CustomerID); err != nil {
+		return nil, err
+	}
+
+	if payment.IdempotencyKey != "" {
+		existing, err := findPaymentByIdempotencyKey(tx, s.org, payment.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if existing.InvoiceID != "" || existing.CustomerID != payment.CustomerID || existing.Amount != payment.Amount {
+				return nil, ErrIdempotencyConflict
+			}
+			if err := withAllocations(tx, existing); err != nil {
+				return nil, err
+			}
+			return existing, nil
+		}
+	}
+
+	p, err := s.insertPayment(tx, payment, payment.CustomerID, payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.settlePayment(tx, p, nil); err != nil {
+		return nil, err
+	}
+	if allocating {
+		if err := s.allocate(tx, p, invoices, payment.Allocations, payment.CreatedBy); err != nil {
+			return nil, err
+		}
+		if err := withAllocations(tx, p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+const creditApplicationColumns = `id, invoice_id, payment_id, customer_id, currency, amount, applied_at, created_at,
+	created_by, voided_at, void_reason, version`
+
+func scanCreditApplication(row rowScanner) (*CreditApplication, error) {
+	var a CreditApplication
+	err := row.Scan(&a.ID, &a.InvoiceID, text(&a.PaymentID), &a.CustomerID, &a.Currency, &a.Amount, text(&a.AppliedAt), text(&a.CreatedAt),
+		text(&a.CreatedBy), text(&a.VoidedAt), text(&a.VoidReason), &a.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
+
+// ApplyCredit pays an invoice from its customer's credit, under the
+// invoice's lock
+func (s *SQLStore) ApplyCredit(invoiceID string, amount money.Amount, createdBy string) (*CreditApplication, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(),
+		invoiceID, s.org))
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.applyCredit(tx, inv, amount, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// creditAmount checks that amount of its customer's credit can be applied
+// to a locked invoice, or for a zero amount returns as much as there is
+// credit and is owed
+func creditAmount(tx *sql.Tx, inv *Invoice, amount money.Amount) (money.Amount, error) {
+	if err := lifecycle.Payable(inv.Status); err != nil {
+		return 0, err
+	}
+
+	available, err := credit(tx, inv.CustomerID, inv.Currency)
+	if err != nil {
+		return 0, err
+	}
+	owed := balance(inv)
+	if amount.IsZero() {
+		amount = available
+		if owed.Cmp(amount) < 0 {
+			amount = owed
+		}
+	} else {
+		amount = amount.Round(inv.Currency)
+		if amount.Cmp(owed) > 0 {
+			return 0, fmt.Errorf("%w: %s is owed on the invoice", ErrCreditExceedsBalance, owed)
+		}
+	}
+	if amount.Sign() <= 0 || amount.Cmp(available) > 0 {
+		if available.Sign() < 0 {
+			available = 0
+		}
+		return 0, fmt.Errorf("%w: the customer has %s %s of credit", ErrInsufficientCredit, available, inv.Currency)
+	}
+	return amount, nil
+}
+
+// applyCredit records a credit application of amount of a locked invoice's
+// customer's credit, see creditAmount, and settles the invoice and the credit
+func (s *SQLStore) applyCredit(tx *sql.Tx, inv *Invoice, amount money.Amount, createdBy string) (*CreditApplication, error) {
+	amount, err := creditAmount(tx, inv, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.insertCreditApplication(tx, inv, "", amount, "", createdBy)
+}
+
+// insertCreditApplication records a credit application of amount to a
+// locked invoice, allocated from the receipt paymentID if it is not empty
+// and applied at appliedAt, or now, and settles the invoice and the credit
+func (s *SQLStore) insertCreditApplication(tx *sql.Tx, inv *Invoice, paymentID string, amount money.Amount, appliedAt, createdBy string) (*CreditApplication, error) {
+	a, err := scanCreditApplication(tx.QueryRow(`
+		INSERT INTO credit_applications (id, org_id, invoice_id, payment_id, customer_id, currency, amount, applied_at,
+			created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, CURRENT_TIMESTAMP), $9)
+		RETURNING `+creditApplicationColumns,
+		uuid.NewString(), s.org, inv.ID, nullIfEmpty(paymentID), inv.CustomerID, inv.Currency, amount,
+		nullIfEmpty(appliedAt), createdBy))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := settlePayments(tx, inv); err != nil {
+		return nil, err
+	}
+	if err := s.settleCredit(tx, inv.CustomerID, inv.Currency); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *SQLStore) GetCreditApplications(invoiceID string) ([]CreditApplication, error) {
+	rows, err := s.db.Query(`
+		SELECT `+creditApplicationColumns+` FROM credit_applications
+		WHERE invoice_id = $1 AND org_id = $2
+		ORDER BY applied_at, created_at`, invoiceID, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applications := []CreditApplication{}
+	for rows.Next() {
+		a, err := scanCreditApplication(rows)
+		if err != nil {
+			return nil, err
+		}
+		applications = append(applications, *a)
+	}
+	return applications, rows.Err()
+}
+
+// VoidCreditApplication voids a credit application at version and settles
+// its invoice and its customer's credit, in one transaction under the
+// invoice's lock
+func (s *SQLStore) VoidCreditApplication(id string, version int, reason string) (*CreditApplication, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invoiceID string
+	err = tx.QueryRow(`SELECT invoice_id FROM credit_applications WHERE id = $1 AND org_id = $2`, id, s.org).Scan(&invoiceID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	inv, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1`+s.dialect.forUpdate(), invoiceID))
+	if err != nil {
+		return nil, err
+	}
+	a, err := scanCreditApplication(tx.QueryRow(`SELECT `+creditApplicationColumns+` FROM credit_applications WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && a.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if a.VoidedAt != "" {
+		return nil, ErrCreditApplicationVoided
+	}
+
+	a, err = scanCreditApplication(tx.QueryRow(`
+		UPDATE credit_applications SET voided_at = $2, void_reason = $3, version = version + 1
+		WHERE id = $1
+		RETURNING `+creditApplicationColumns,
+		id, time.Now().UTC().Format(time.RFC3339), nullIfEmpty(reason)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := settlePayments(tx, inv); err != nil {
+		return nil, err
+	}
+	if err := s.settleCredit(tx, a.CustomerID, a.Currency); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// autoApplyCredit pays a locked invoice from its customer's credit as far as
+// it goes, if the invoice accepts payments, reporting whether any was applied
+func (s *SQLStore) autoApplyCredit(tx *sql.Tx, inv *Invoice) (bool, error) {
+	if lifecycle.Payable(inv.Status) != nil || balance(inv).Sign() <= 0 {
+		return false, nil
+	}
+	available, err := credit(tx, inv.CustomerID, inv.Currency)
+	if err != nil || available.Sign() <= 0 {
+		return false, err
+	}
+	if _, err := s.applyCredit(tx, inv, 0, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadCredits reads the credit balances of customers
+func loadCredits(q queryer, customers []Customer) error {
+	if len(customers) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Customer, len(customers))
+	placeholders := make([]string, len(customers))
+	args := make([]interface{}, len(customers))
+	for i := range customers {
+		byID[customers[i].ID] = &customers[i]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = customers[i].ID
+	}
+
+	rows, err := q.Query(`
+		SELECT customer_id, currency, balance FROM customer_credits
+		WHERE customer_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY customer_id, currency`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var customerID string
+		var b CreditBalance
+		if err := rows.Scan(&customerID, &b.Currency, &b.Balance); err != nil {
+			return err
+		}
+		if c := byID[customerID]; c != nil {
+			c.CreditBalances = append(c.CreditBalances, b)
+		}
+	}
+	return rows.Err()
+}
+
+// withCredits reads the credit balances of a customer read with err
+func withCredits(q queryer, c *Customer, err error) (*Customer, error) {
+	if err != nil {
+		return nil, err
+	}
+	customers := []Customer{*c}
+	if err := loadCredits(q, customers); err != nil {
+		return nil, err
+	}
+	return &customers[0], nil
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// ApplyCredit pays the invoice through the apply_customer_credit database
+// function, which locks the invoice, checks the credit and records the
+// credit application in one transaction (see migration
+// 0019_customer_credit)
+func (c *SupabaseStore) ApplyCredit(invoiceID string, amount money.Amount, createdBy string) (*CreditApplication, error) {
+	args := map[string]interface{}{
+		"p_invoice_id": invoiceID,
+	}
+	if !amount.IsZero() {
+		args["p_amount"] = amount
+	}
+	if createdBy != "" {
+		args["p_created_by"] = createdBy
+	}
+
+	var result CreditApplication
+	if err := c.rpc("apply_customer_credit", args, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *SupabaseStore) GetCreditApplications(invoiceID string) ([]CreditApplication, error) {
+	applications := []CreditApplication{}
+	_, err := c.from("credit_applications").
+		Select("*", "", false).
+		Eq("invoice_id", invoiceID).
+		Order("applied_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&applications)
+	return applications, err
+}
+
+// VoidCreditApplication voids the credit application through the
+// void_credit_application database function, which locks the invoice and
+// settles it and the customer's credit in one transaction
+func (c *SupabaseStore) VoidCreditApplication(id string, version int, reason string) (*CreditApplication, error) {
+	args := map[string]interface{}{
+		"p_id":     id,
+		"p_reason": reason,
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var result CreditApplication
+	if err := c.rpc("void_credit_application", args, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// testCreditNote credits total of an invoice at its current version
+func testCreditNote(t *testing.T, s Store, invoiceID string, total money.Amount) *CreditNote {
+	t.Helper()
+	note, err := s.CreateCreditNote(0, CreditNote{InvoiceID: invoiceID, Reason: "Price correction", Subtotal: total, Total: total})
+	if err != nil {
+		t.Fatalf("CreateCreditNote: %v", err)
+	}
+	return note
+}
+
+// TestCreditSettlement checks that what is paid on an invoice is its
+// payments plus the credit applied to it
+func TestCreditSettlement(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	first := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	pay(t, s, first.ID, money.FromInt(150))
+	checkCredit(t, s, c.ID, money.FromInt(50))
+
+	// An invoice created issued takes the customer's credit by itself
+	second := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	checkPaid(t, s, second.ID, money.FromInt(50), lifecycle.PartiallyPaid)
+	checkCredit(t, s, c.ID, 0)
+
+	pay(t, s, second.ID, money.FromInt(30))
+	checkPaid(t, s, second.ID, money.FromInt(80), lifecycle.PartiallyPaid)
+
+	applications, err := s.GetCreditApplications(second.ID)
+	if err != nil {
+		t.Fatalf("GetCreditApplications: %v", err)
+	}
+	if len(applications) != 1 || applications[0].Amount != money.FromInt(50) {
+		t.Fatalf("credit applications = %+v, want one of 50", applications)
+	}
+
+	// Voiding the application gives the credit back
+	if _, err := s.VoidCreditApplication(applications[0].ID, 0, "Applied by mistake"); err != nil {
+		t.Fatalf("VoidCreditApplication: %v", err)
+	}
+	checkPaid(t, s, second.ID, money.FromInt(30), lifecycle.PartiallyPaid)
+	checkCredit(t, s, c.ID, money.FromInt(50))
+	if _, err := s.VoidCreditApplication(applications[0].ID, 0, "Again"); !errors.Is(err, ErrCreditApplicationVoided) {
+		t.Errorf("voiding a void application: err = %v, want ErrCreditApplicationVoided", err)
+	}
+
+	// Only what is owed and what there is of the credit can be applied
+	if _, err := s.ApplyCredit(second.ID, money.FromInt(80), ""); !errors.Is(err, ErrCreditExceedsBalance) {
+		t.Errorf("applying more than is owed: err = %v, want ErrCreditExceedsBalance", err)
+	}
+	if _, err := s.ApplyCredit(second.ID, money.FromInt(60), ""); !errors.Is(err, ErrInsufficientCredit) {
+		t.Errorf("applying more than the credit: err = %v, want ErrInsufficientCredit", err)
+	}
+	applied, err := s.ApplyCredit(second.ID, 0, "")
+	if err != nil {
+		t.Fatalf("ApplyCredit: %v", err)
+	}
+	if applied.Amount != money.FromInt(50) {
+		t.Errorf("applied %s, want all 50 of the credit", applied.Amount)
+	}
+	checkPaid(t, s, second.ID, money.FromInt(80), lifecycle.PartiallyPaid)
+	checkCredit(t, s, c.ID, 0)
+}
+
+// TestCreditNoteCapsPaid checks that what is paid on an invoice counts up
+// to its total less what was credited, the rest going to the customer's
+// credit
+func TestCreditNoteCapsPaid(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	pay(t, s, inv.ID, money.FromInt(50))
+
+	testCreditNote(t, s, inv.ID, money.FromInt(30))
+	checkPaid(t, s, inv.ID, money.FromInt(50), lifecycle.PartiallyPaid)
+	if _, err := s.CreateCreditNote(0, CreditNote{InvoiceID: inv.ID, Reason: "Too much", Subtotal: money.FromInt(30), Total: money.FromInt(30)}); !errors.Is(err, lifecycle.ErrNotAllowed) {
+		t.Errorf("crediting more than the balance: err = %v, want lifecycle.ErrNotAllowed", err)
+	}
+
+	pay(t, s, inv.ID, money.FromInt(40))
+	checkPaid(t, s, inv.ID, money.FromInt(70), lifecycle.Paid)
+	checkCredit(t, s, c.ID, money.FromInt(20))
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// CreditNote credits part or all of an invoice back to the customer. It is a
+// document of its own, numbered from the credit_note series, and lowers the
+// balance of the invoice it credits by its total. Credit notes are never
+// changed once issued; a wrong one is corrected by crediting the invoice
+// again or by a new invoice.
+type CreditNote struct {
+	ID               string `json:"id"`
+	InvoiceID        string `json:"invoice_id"`
+	CreditNoteNumber string `json:"credit_note_number,omitempty"`
+	Reason           string `json:"reason"`
+	// Subtotal is the sum of the credited lines and Tax the tax on them, at
+	// the rates of the invoice lines
+	Subtotal money.Amount `json:"subtotal"`
+	Tax      money.Amount `json:"tax"`
+	// Discount is what the lines and their tax come to beyond the invoice's
+	// balance, which a credit note never exceeds
+	Discount  money.Amount     `json:"discount,omitempty"`
+	Total     money.Amount     `json:"total"`
+	Currency  string           `json:"currency"`
+	Items     []CreditNoteItem `json:"items"`
+	CreatedAt string           `json:"created_at,omitempty"`
+	CreatedBy string           `json:"created_by,omitempty"`
+}
+
+// CreditNoteItem is one credited line of an invoice, in the
+// credit_note_items table
+type CreditNoteItem struct {
+	ID            string `json:"id,omitempty"`
+	Position      int    `json:"position"` // Line number, from 1
+	InvoiceItemID string `json:"invoice_item_id"`
+	SKU           string `json:"sku,omitempty"`
+	Description   string `json:"description"`
+	// Quantity is how much of the invoice line is credited; zero for a line
+	// credited by amount, such as a price correction
+	Quantity  money.Amount `json:"quantity,omitempty"`
+	Unit      string       `json:"unit,omitempty"`
+	UnitPrice money.Amount `json:"unit_price"`
+	// TaxRate is the invoice line's tax percentage; nil for the invoice's
+	TaxRate *float64     `json:"tax_rate,omitempty"`
+	Total   money.Amount `json:"total"` // Amount credited, before tax
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+const creditNoteColumns = `id, invoice_id, credit_note_number, reason, subtotal, tax, discount, total, currency, created_at, created_by`
+
+func scanCreditNote(row rowScanner) (*CreditNote, error) {
+	var n CreditNote
+	err := row.Scan(&n.ID, &n.InvoiceID, text(&n.CreditNoteNumber), &n.Reason, &n.Subtotal, &n.Tax, &n.Discount,
+		&n.Total, &n.Currency, text(&n.CreatedAt), text(&n.CreatedBy))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &n, nil
+}
+
+const creditNoteItemColumns = `id, position, invoice_item_id, sku, description, quantity, unit, unit_price, tax_rate, total`
+
+func scanCreditNoteItem(row rowScanner, dest ...interface{}) (*CreditNoteItem, error) {
+	var item CreditNoteItem
+	var taxRate sql.NullFloat64
+	err := row.Scan(append(dest, &item.ID, &item.Position, &item.InvoiceItemID, text(&item.SKU), &item.Description,
+		&item.Quantity, text(&item.Unit), &item.UnitPrice, &taxRate, &item.Total)...)
+	if err != nil {
+		return nil, err
+	}
+	if taxRate.Valid {
+		item.TaxRate = &taxRate.Float64
+	}
+	return &item, nil
+}
+
+func (s *SQLStore) queryCreditNotes(query string, args ...interface{}) ([]CreditNote, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []CreditNote
+	for rows.Next() {
+		n, err := scanCreditNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadCreditNoteItems(s.db, notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// loadCreditNoteItems reads the lines of credit notes, in position order,
+// with one query
+func loadCreditNoteItems(q queryer, notes []CreditNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	byID := make(map[string]*CreditNote, len(notes))
+	placeholders := make([]string, len(notes))
+	args := make([]interface{}, len(notes))
+	for i := range notes {
+		notes[i].Items = []CreditNoteItem{}
+		byID[notes[i].ID] = &notes[i]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = notes[i].ID
+	}
+
+	rows, err := q.Query(`
+		SELECT credit_note_id, `+creditNoteItemColumns+` FROM credit_note_items
+		WHERE credit_note_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY credit_note_id, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var noteID string
+		item, err := scanCreditNoteItem(rows, &noteID)
+		if err != nil {
+			return err
+		}
+		if n := byID[noteID]; n != nil {
+			n.Items = append(n.Items, *item)
+		}
+	}
+	return rows.Err()
+}
+
+// CreateCreditNote inserts the credit note and its lines and lowers the
+// invoice's balance in one transaction, under the invoice's lock
+func (s *SQLStore) CreateCreditNote(version int, note CreditNote) (*CreditNote, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(),
+		note.InvoiceID, s.org))
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && inv.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if err := lifecycle.Creditable(inv.Status); err != nil {
+		return nil, err
+	}
+	if owed := balance(inv); note.Total.Cmp(owed) > 0 {
+		return nil, fmt.Errorf("%w: the credit of %s exceeds the invoice balance of %s", lifecycle.ErrNotAllowed, note.Total, owed)
+	}
+
+	id := uuid.NewString()
+	number, err := nextDocumentNumber(tx, s.org, SeriesCreditNote, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate credit note number: %v", err)
+	}
+
+	created, err := scanCreditNote(tx.QueryRow(`
+		INSERT INTO credit_notes (id, org_id, invoice_id, credit_note_number, reason, subtotal, tax, discount, total,
+			currency, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+creditNoteColumns,
+		id, s.org, inv.ID, number, note.Reason, note.Subtotal, note.Tax, note.Discount, note.Total,
+		inv.Currency, nullIfEmpty(note.CreatedBy)))
+	if err != nil {
+		return nil, err
+	}
+
+	created.Items = make([]CreditNoteItem, 0, len(note.Items))
+	for _, item := range note.Items {
+		var quantity, taxRate interface{}
+		if item.Quantity.Sign() > 0 {
+			quantity = item.Quantity
+		}
+		if item.TaxRate != nil {
+			taxRate = *item.TaxRate
+		}
+		line, err := scanCreditNoteItem(tx.QueryRow(`
+			INSERT INTO credit_note_items (id, credit_note_id, position, invoice_item_id, sku, description, quantity,
+				unit, unit_price, tax_rate, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING `+creditNoteItemColumns,
+			uuid.NewString(), id, item.Position, item.InvoiceItemID, nullIfEmpty(item.SKU), item.Description, quantity,
+			nullIfEmpty(item.Unit), item.UnitPrice, taxRate, item.Total))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store credit note item %d: %v", item.Position, err)
+		}
+		created.Items = append(created.Items, *line)
+	}
+
+	inv.CreditedAmount = inv.CreditedAmount.Add(note.Total)
+	settle(inv)
+	_, err = tx.Exec(`
+		UPDATE invoices SET credited_amount = $2, status = $3, payment_status = $4, version = version + 1
+		WHERE id = $1`,
+		inv.ID, inv.CreditedAmount, inv.Status, inv.PaymentStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update invoice: %v", err)
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *SQLStore) GetCreditNote(id string) (*CreditNote, error) {
+	notes, err := s.queryCreditNotes(`SELECT `+creditNoteColumns+` FROM credit_notes WHERE id = $1 AND org_id = $2`, id, s.org)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, ErrNotFound
+	}
+	return &notes[0], nil
+}
+
+func (s *SQLStore) GetCreditNotesByInvoice(invoiceID string) ([]CreditNote, error) {
+	return s.queryCreditNotes(`SELECT `+creditNoteColumns+` FROM credit_notes WHERE invoice_id = $1 AND org_id = $2 ORDER BY created_at, credit_note_number`,
+		invoiceID, s.org)
+}
+
+func (s *SQLStore) ListCreditNotes(where *filter.Expr, page PageRequest) (*Page[CreditNote], error) {
+	page, c, err := page.normalize(creditNoteSortFields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	cond, args := filterSQL(where, nil)
+	query, args, total, err := s.pageQuery("credit_notes", creditNoteColumns, creditNoteSortFields, page, c, cond, args)
+	if err != nil {
+		return nil, err
+	}
+	notes, values, err := queryPage(s.db, query, args, scanCreditNote)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCreditNoteItems(s.db, notes); err != nil {
+		return nil, err
+	}
+	return newPage(notes, values, total, page, func(n CreditNote) string { return n.ID }), nil
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// creditNoteSelect reads credit notes with their lines embedded as items
+const creditNoteSelect = "*, items:credit_note_items(*)"
+
+// sortCreditNoteItems puts the lines embedded by creditNoteSelect in
+// position order
+func sortCreditNoteItems(notes []CreditNote) {
+	for _, n := range notes {
+		sort.Slice(n.Items, func(i, j int) bool { return n.Items[i].Position < n.Items[j].Position })
+	}
+}
+
+// CreateCreditNote issues the credit note through the create_credit_note
+// database function, which locks the invoice, numbers and inserts the note
+// and its lines and lowers the invoice's balance in one transaction (see
+// migration 0016_credit_notes)
+func (c *SupabaseStore) CreateCreditNote(version int, note CreditNote) (*CreditNote, error) {
+	args := map[string]interface{}{
+		"p_invoice_id": note.InvoiceID,
+		"p_credit_note": map[string]interface{}{
+			"reason":     note.Reason,
+			"subtotal":   note.Subtotal,
+			"tax":        note.Tax,
+			"discount":   note.Discount,
+			"total":      note.Total,
+			"created_by": nullIfEmpty(note.CreatedBy),
+		},
+		"p_items": note.Items,
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var created CreditNote
+	if err := c.rpc("create_credit_note", args, &created); err != nil {
+		return nil, err
+	}
+	return c.GetCreditNote(created.ID)
+}
+
+func (c *SupabaseStore) GetCreditNote(id string) (*CreditNote, error) {
+	var notes []CreditNote
+	_, err := c.from("credit_notes").Select(creditNoteSelect, "", false).Eq("id", id).ExecuteTo(&notes)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, ErrNotFound
+	}
+	sortCreditNoteItems(notes)
+	return &notes[0], nil
+}
+
+func (c *SupabaseStore) GetCreditNotesByInvoice(invoiceID string) ([]CreditNote, error) {
+	notes := []CreditNote{}
+	_, err := c.from("credit_notes").
+		Select(creditNoteSelect, "", false).
+		Eq("invoice_id", invoiceID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&notes)
+	sortCreditNoteItems(notes)
+	return notes, err
+}
+
+func (c *SupabaseStore) ListCreditNotes(where *filter.Expr, page PageRequest) (*Page[CreditNote], error) {
+	page, cur, err := page.normalize(creditNoteSortFields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	var notes []CreditNote
+	total, values, err := c.readPage("credit_notes", creditNoteSelect, page, cur, filterPostgREST(where, nil), &notes)
+	if err != nil {
+		return nil, err
+	}
+	sortCreditNoteItems(notes)
+	return newPage(notes, values, total, page, func(n CreditNote) string { return n.ID }), nil
+}
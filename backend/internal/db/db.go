@@ -1,15 +1,60 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
 
-	"github.com/supabase-community/supabase-go"
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/money"
 )
 
-type Client struct {
-	supabase *supabase.Client
-}
+// ErrNotFound is returned by a Store when the requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrIdempotencyConflict is returned when an idempotency key is reused for a
+// request that differs from the one it was first used with
+var ErrIdempotencyConflict = errors.New("idempotency key already used for a different request")
+
+// ErrVersionConflict is returned when a write names the version of the record
+// it was based on and the record has changed since
+var ErrVersionConflict = errors.New("record was changed since it was read")
+
+// ErrCustomerHasInvoices is returned when deleting a customer that still has
+// invoices without forcing the delete
+var ErrCustomerHasInvoices = errors.New("customer has invoices")
+
+// ErrRefundExceedsPayment is returned when a refund would return more of a
+// payment than is left of it after its earlier refunds
+var ErrRefundExceedsPayment = errors.New("refund exceeds what is left of the payment")
+
+// ErrPaymentVoided is returned when correcting, voiding or refunding a
+// payment that was voided
+var ErrPaymentVoided = errors.New("payment is void")
+
+// ErrInsufficientCredit is returned when applying more of a customer's credit
+// than they have, or when a change to their payments would take back credit
+// that was already applied
+var ErrInsufficientCredit = errors.New("not enough customer credit")
+
+// ErrCreditExceedsBalance is returned when applying more credit to an
+// invoice than is owed on it
+var ErrCreditExceedsBalance = errors.New("credit exceeds what is owed on the invoice")
+
+// ErrOverallocated is returned when allocating more of a payment than is
+// left of it, or more to an invoice than is owed on it
+var ErrOverallocated = errors.New("allocation exceeds what is left of the payment or owed on the invoice")
+
+// ErrPaymentAllocated is returned when allocating a payment that was
+// recorded on an invoice, or when voiding, refunding or correcting a payment
+// would take back what was allocated of it
+var ErrPaymentAllocated = errors.New("payment is allocated to invoices")
+
+// ErrCreditApplicationVoided is returned when voiding a credit application
+// that was voided
+var ErrCreditApplicationVoided = errors.New("credit application is void")
 
 type Customer struct {
 	ID          string `json:"id"`
@@ -22,7 +67,23 @@ type Customer struct {
 	Country     string `json:"country,omitempty"`
 	CompanyName string `json:"company_name,omitempty"`
 	CreatedAt   string `json:"created_at,omitempty"`
-}
+	ArchivedAt  string `json:"archived_at,omitempty"` // Set while the customer is archived
+	// PriceListID is the price list applied to the customer's invoices
+	PriceListID string `json:"price_list_id,omitempty"`
+	Version     int    `json:"version"`
+	// CreditBalances is the customer's credit, per currency, where the
+	// customer is read with it
+	CreditBalances []CreditBalance `json:"credit_balances,omitempty"`
+}
+
+// CustomerScope selects customers by archive state in ListCustomers
+type CustomerScope string
+
+const (
+	ActiveCustomers   CustomerScope = "active"
+	ArchivedCustomers CustomerScope = "archived"
+	AllCustomers      CustomerScope = "all"
+)
 
 type CustomerCreate struct {
 	Name        string `json:"name"`
@@ -36,248 +97,183 @@ type CustomerCreate struct {
 }
 
 type Invoice struct {
-	ID            string  `json:"id"`
-	CustomerID    string  `json:"customer_id"`
-	InvoiceNumber string  `json:"invoice_number,omitempty"`
-	Subtotal      float64 `json:"subtotal"`
-	Tax           float64 `json:"tax,omitempty"`
-	Discount      float64 `json:"discount,omitempty"`
-	Total         float64 `json:"total"`
-	Items         []Item  `json:"items"`
-	PDFURL        string  `json:"pdf_url,omitempty"`
-	Status        string  `json:"status,omitempty"`
-	Notes         string  `json:"notes,omitempty"`
-	DueDate       string  `json:"due_date,omitempty"`
-	Currency      string  `json:"currency,omitempty"`
-	PaymentStatus string  `json:"payment_status,omitempty"`
-	PaidAmount    float64 `json:"paid_amount,omitempty"`
-	PaymentDate   string  `json:"payment_date,omitempty"`
-	CreatedAt     string  `json:"created_at,omitempty"`
+	ID            string       `json:"id"`
+	CompanyID     string       `json:"company_id,omitempty"` // Company profile the invoice was issued from
+	CustomerID    string       `json:"customer_id"`
+	InvoiceNumber string       `json:"invoice_number,omitempty"`
+	Subtotal      money.Amount `json:"subtotal"`
+	Tax           float64      `json:"tax,omitempty"` // Tax percentage
+	Discount      money.Amount `json:"discount,omitempty"`
+	Total         money.Amount `json:"total"`
+	Items         []Item       `json:"items"`
+	PDFURL        string       `json:"pdf_url,omitempty"`
+	Status        string       `json:"status,omitempty"` // Lifecycle status (see package lifecycle)
+	Notes         string       `json:"notes,omitempty"`
+	DueDate       string       `json:"due_date,omitempty"`
+	Currency      string       `json:"currency,omitempty"`
+	PaymentStatus string       `json:"payment_status,omitempty"` // unpaid, partially_paid, paid or overdue, following Status
+	PaidAmount    money.Amount `json:"paid_amount,omitempty"`
+	// CreditedAmount is the total of the invoice's credit notes; the balance
+	// is the total less what was paid and credited
+	CreditedAmount money.Amount `json:"credited_amount,omitempty"`
+	PaymentDate    string       `json:"payment_date,omitempty"`
+	IssuedAt       string       `json:"issued_at,omitempty"`
+	SentAt         string       `json:"sent_at,omitempty"`
+	StatusReason   string       `json:"status_reason,omitempty"` // Why the invoice was voided or written off
+	CreatedAt      string       `json:"created_at,omitempty"`
+	Version        int          `json:"version"`
 }
 
 type InvoiceCreate struct {
-	CustomerID string  `json:"customer_id"`
-	Subtotal   float64 `json:"subtotal"`
-	Tax        float64 `json:"tax,omitempty"`
-	Discount   float64 `json:"discount,omitempty"`
-	Total      float64 `json:"total"`
-	Items      []Item  `json:"items"`
-	Status     string  `json:"status,omitempty"`
-	Notes      string  `json:"notes,omitempty"`
-	DueDate    string  `json:"due_date,omitempty"`
-	Currency   string  `json:"currency,omitempty"`
-}
-
+	// CompanyID is the company profile issuing the invoice; empty issues it
+	// from the default profile
+	CompanyID  string       `json:"company_id,omitempty"`
+	CustomerID string       `json:"customer_id"`
+	Subtotal   money.Amount `json:"subtotal"`
+	Tax        float64      `json:"tax,omitempty"` // Tax percentage
+	Discount   money.Amount `json:"discount,omitempty"`
+	Total      money.Amount `json:"total"`
+	Items      []Item       `json:"items"`
+	Status     string       `json:"status,omitempty"`
+	Notes      string       `json:"notes,omitempty"`
+	DueDate    string       `json:"due_date,omitempty"`
+	Currency   string       `json:"currency,omitempty"`
+}
+
+// Item is one line of an invoice, stored in the invoice_items table
 type Item struct {
-	Description string  `json:"description"`
-	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-}
-
-// Payment represents a payment record for an invoice
+	ID          string       `json:"id,omitempty"`
+	Position    int          `json:"position"`             // Line number, from 1
+	ProductID   string       `json:"product_id,omitempty"` // Catalog product the line was taken from
+	SKU         string       `json:"sku,omitempty"`
+	Description string       `json:"description"`
+	Quantity    money.Amount `json:"quantity"` // Decimal, up to four places
+	// Unit is the unit of measure: hour, day, kg, pcs or a custom unit
+	Unit      string       `json:"unit,omitempty"`
+	UnitPrice money.Amount `json:"unit_price"`
+	// PriceRule describes the price list rule that set the unit price
+	PriceRule string `json:"price_rule,omitempty"`
+	// TaxRate is the line's tax percentage; nil applies the invoice's tax
+	TaxRate  *float64     `json:"tax_rate,omitempty"`
+	Discount money.Amount `json:"discount,omitempty"` // Line discount amount
+	// Total is quantity x unit price less the discount, rounded to the
+	// invoice currency
+	Total money.Amount `json:"total"`
+}
+
+// Payment represents a payment record for an invoice. A payment recorded by
+// mistake is voided rather than deleted, and a wrong one corrected; either
+// way the invoice's paid amount is recomputed from its payments that are not
+// void.
 type Payment struct {
-	ID              string  `json:"id"`
-	InvoiceID       string  `json:"invoice_id"`
-	Amount          float64 `json:"amount"`
-	PaymentMethod   string  `json:"payment_method"`
-	PaymentDate     string  `json:"payment_date"`
-	ReferenceNumber string  `json:"reference_number,omitempty"`
-	Notes           string  `json:"notes,omitempty"`
-	CreatedAt       string  `json:"created_at,omitempty"`
-	CreatedBy       string  `json:"created_by,omitempty"`
-}
-
-// PaymentCreate is the struct for creating a new payment
+	ID        string `json:"id"`
+	InvoiceID string `json:"invoice_id"` // Empty for a receipt kept as credit
+	// CustomerID is who paid, and Currency what in: the invoice's, or the
+	// receipt's
+	CustomerID      string       `json:"customer_id,omitempty"`
+	Currency        string       `json:"currency,omitempty"`
+	ReceiptNumber   string       `json:"receipt_number,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	CreatedAt       string       `json:"created_at,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+	// RefundedAmount is what was returned of the payment; Refunds lists the
+	// refunds where a payment is read with them
+	RefundedAmount money.Amount `json:"refunded_amount,omitempty"`
+	Refunds        []Refund     `json:"refunds,omitempty"`
+	// AllocatedAmount is what was allocated of a receipt to invoices, not
+	// void; Allocations lists the allocations, credit applications of the
+	// receipt, where a payment is read with them
+	AllocatedAmount money.Amount        `json:"allocated_amount,omitempty"`
+	Allocations     []CreditApplication `json:"allocations,omitempty"`
+	// VoidedAt is set once the payment is voided, with why in VoidReason;
+	// CorrectionReason tells why it was last corrected
+	VoidedAt         string `json:"voided_at,omitempty"`
+	VoidReason       string `json:"void_reason,omitempty"`
+	CorrectionReason string `json:"correction_reason,omitempty"`
+	Version          int    `json:"version"`
+}
+
+// PaymentCreate is the struct for creating a new payment. A payment on an
+// invoice is the invoice customer's, in its currency; without InvoiceID it is
+// a receipt of CustomerID in Currency (USD if empty), all of which goes to
+// the customer's credit unless it is allocated to their invoices, by
+// Allocations or, with AutoAllocate, oldest due first.
 type PaymentCreate struct {
-	InvoiceID       string  `json:"invoice_id"`
-	Amount          float64 `json:"amount"`
-	PaymentMethod   string  `json:"payment_method"`
-	PaymentDate     string  `json:"payment_date"`
-	ReferenceNumber string  `json:"reference_number,omitempty"`
-	Notes           string  `json:"notes,omitempty"`
-	CreatedBy       string  `json:"created_by,omitempty"`
+	InvoiceID       string       `json:"invoice_id,omitempty"`
+	CustomerID      string       `json:"customer_id,omitempty"`
+	Currency        string       `json:"currency,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+
+	Allocations  []PaymentAllocation `json:"allocations,omitempty"`
+	AutoAllocate bool                `json:"auto_allocate,omitempty"`
+
+	// IdempotencyKey comes from the Idempotency-Key request header. Recording
+	// the same key twice returns the original payment instead of a new one.
+	IdempotencyKey string `json:"-"`
+}
+
+// PaymentCorrection replaces the details of a payment that was recorded
+// wrong. An empty PaymentDate keeps the payment's date.
+type PaymentCorrection struct {
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date,omitempty"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	Reason          string       `json:"reason"` // Why the payment is corrected
 }
 
 // DashboardStats represents dashboard analytics data
 type DashboardStats struct {
-	TotalRevenue     float64 `json:"total_revenue"`
-	PaidAmount       float64 `json:"paid_amount"`
-	UnpaidAmount     float64 `json:"unpaid_amount"`
-	OverdueAmount    float64 `json:"overdue_amount"`
-	TotalInvoices    int     `json:"total_invoices"`
-	PaidInvoices     int     `json:"paid_invoices"`
-	UnpaidInvoices   int     `json:"unpaid_invoices"`
-	OverdueInvoices  int     `json:"overdue_invoices"`
-	PartiallyPaid    int     `json:"partially_paid"`
-	Currency         string  `json:"currency"`
+	TotalRevenue    money.Amount `json:"total_revenue"`
+	PaidAmount      money.Amount `json:"paid_amount"`
+	CreditedAmount  money.Amount `json:"credited_amount"` // Credited on invoices, already left out of revenue
+	UnpaidAmount    money.Amount `json:"unpaid_amount"`
+	OverdueAmount   money.Amount `json:"overdue_amount"`
+	TotalInvoices   int          `json:"total_invoices"`
+	PaidInvoices    int          `json:"paid_invoices"`
+	UnpaidInvoices  int          `json:"unpaid_invoices"`
+	OverdueInvoices int          `json:"overdue_invoices"`
+	PartiallyPaid   int          `json:"partially_paid"`
+	Currency        string       `json:"currency"`
 }
 
 // RevenueByPeriod represents revenue grouped by time period
 type RevenueByPeriod struct {
-	Date         string  `json:"date"`
-	Revenue      float64 `json:"revenue"`
-	InvoiceCount int     `json:"invoice_count"`
-	Currency     string  `json:"currency"`
+	Date         string       `json:"date"`
+	Revenue      money.Amount `json:"revenue"`
+	InvoiceCount int          `json:"invoice_count"`
+	Currency     string       `json:"currency"`
 }
 
 // TopCustomer represents customer with highest revenue
 type TopCustomer struct {
-	CustomerID    string  `json:"customer_id"`
-	CustomerName  string  `json:"customer_name"`
-	TotalRevenue  float64 `json:"total_revenue"`
-	InvoiceCount  int     `json:"invoice_count"`
-	Currency      string  `json:"currency"`
-}
-
-func New() (*Client, error) {
-	url := os.Getenv("SUPABASE_URL")
-	key := os.Getenv("SUPABASE_KEY")
-	if url == "" || key == "" {
-		return nil, fmt.Errorf("SUPABASE_URL and SUPABASE_KEY must be set")
-	}
-
-	supabase, err := supabase.NewClient(url, key, nil)
-	if err != nil {
-		return nil, err
-	}
-	return &Client{supabase: supabase}, nil
-}
-
-// Customer operations
-func (c *Client) ListCustomers() ([]Customer, error) {
-	var customers []Customer
-	_, err := c.supabase.From("customers").Select("*", "", false).ExecuteTo(&customers)
-	return customers, err
-}
-
-func (c *Client) CreateCustomer(name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
-	customerCreate := map[string]interface{}{
-		"name":         name,
-		"email":        email,
-		"phone":        phone,
-		"address":      address,
-		"city":         city,
-		"postal_code":  postalCode,
-		"country":      country,
-		"company_name": companyName,
-	}
-	var result []Customer
-	_, err := c.supabase.From("customers").Insert(customerCreate, false, "", "", "").ExecuteTo(&result)
-	if err != nil {
-		return nil, err
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no customer created")
-	}
-	return &result[0], nil
-}
-
-func (c *Client) GetCustomer(id string) (*Customer, error) {
-	var customer Customer
-	_, err := c.supabase.From("customers").Select("*", "", false).Eq("id", id).Single().ExecuteTo(&customer)
-	if err != nil {
-		return nil, err
-	}
-	return &customer, nil
+	CustomerID   string       `json:"customer_id"`
+	CustomerName string       `json:"customer_name"`
+	TotalRevenue money.Amount `json:"total_revenue"`
+	InvoiceCount int          `json:"invoice_count"`
+	Currency     string       `json:"currency"`
 }
 
-func (c *Client) UpdateCustomer(id, name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
-	updates := map[string]interface{}{
-		"name":         name,
-		"email":        email,
-		"phone":        phone,
-		"address":      address,
-		"city":         city,
-		"postal_code":  postalCode,
-		"country":      country,
-		"company_name": companyName,
-	}
-	var result []Customer
-	_, err := c.supabase.From("customers").Update(updates, "", "").Eq("id", id).ExecuteTo(&result)
-	if err != nil {
-		return nil, err
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no customer updated")
-	}
-	return &result[0], nil
-}
-
-func (c *Client) DeleteCustomer(id string) error {
-	_, _, err := c.supabase.From("customers").Delete("", "").Eq("id", id).ExecuteString()
-	return err
-}
-
-// Invoice operations CREATE
-func (c *Client) CreateInvoice(customerID string, subtotal, tax, discount, total float64, items []Item, status, notes, dueDate, currency string) (*Invoice, error) {
-	if currency == "" {
-		currency = "USD"
-	}
-	
-	// Generate invoice number
-	invoiceNumber, err := c.GenerateInvoiceNumber()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate invoice number: %v", err)
-	}
-	
-	// Create invoice with generated number
-	invoiceData := map[string]interface{}{
-		"customer_id":    customerID,
-		"invoice_number": invoiceNumber,
-		"subtotal":       subtotal,
-		"tax":            tax,
-		"discount":       discount,
-		"total":          total,
-		"items":          items,
-		"status":         status,
-		"notes":          notes,
-		"due_date":       dueDate,
-		"currency":       currency,
-		"payment_status": "unpaid",
-		"paid_amount":    0,
-	}
-	
-	var result []Invoice
-	_, err = c.supabase.From("invoices").Insert(invoiceData, false, "", "", "").ExecuteTo(&result)
-	if err != nil {
-		return nil, err
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no invoice created")
-	}
-	return &result[0], nil
-}
-
-func (c *Client) ListInvoices() ([]Invoice, error) {
-	var invoices []Invoice
-	_, err := c.supabase.From("invoices").Select("*", "", false).ExecuteTo(&invoices)
-	return invoices, err
-}
-
-func (c *Client) GetInvoice(id string) (*Invoice, error) {
-	var invoice Invoice
-	_, err := c.supabase.From("invoices").Select("*", "", false).Eq("id", id).Single().ExecuteTo(&invoice)
-	if err != nil {
-		return nil, err
-	}
-	return &invoice, nil
-}
-
-func (c *Client) UpdateInvoice(id string, status, notes, dueDate string) (*Invoice, error) {
-	updates := map[string]interface{}{
-		"status":   status,
-		"notes":    notes,
-		"due_date": dueDate,
-	}
-	var result []Invoice
-	_, err := c.supabase.From("invoices").Update(updates, "", "").Eq("id", id).ExecuteTo(&result)
-	if err != nil {
-		return nil, err
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no invoice updated")
-	}
-	return &result[0], nil
+// TopItem represents an item with what it brought in. Items are grouped by
+// SKU, or by description when they have none, and by unit of measure.
+type TopItem struct {
+	SKU          string       `json:"sku,omitempty"`
+	Description  string       `json:"description"`
+	Quantity     money.Amount `json:"quantity"`
+	Unit         string       `json:"unit,omitempty"` // Quantities are summed per unit
+	Revenue      money.Amount `json:"revenue"`
+	InvoiceCount int          `json:"invoice_count"`
+	Currency     string       `json:"currency"`
 }
 
 // CurrencyRate represents exchange rate data
@@ -289,313 +285,293 @@ type CurrencyRate struct {
 	UpdatedAt    string  `json:"updated_at"`
 }
 
-// GetCurrencyRate gets exchange rate between two currencies
-func (c *Client) GetCurrencyRate(fromCurrency, toCurrency string) (*CurrencyRate, error) {
-	var rate CurrencyRate
-	_, err := c.supabase.From("currency_rates").
-		Select("*", "", false).
-		Eq("from_currency", fromCurrency).
-		Eq("to_currency", toCurrency).
-		Single().
-		ExecuteTo(&rate)
-	if err != nil {
-		return nil, err
-	}
-	return &rate, nil
-}
-
-// ListCurrencyRates gets all currency rates
-func (c *Client) ListCurrencyRates() ([]CurrencyRate, error) {
-	var rates []CurrencyRate
-	_, err := c.supabase.From("currency_rates").Select("*", "", false).ExecuteTo(&rates)
-	return rates, err
-}
-
-// FilterInvoices filters invoices based on status, date range, and search term
-func (c *Client) FilterInvoices(status, searchTerm, startDate, endDate string) ([]Invoice, error) {
-	var invoices []Invoice
-	
-	query := c.supabase.From("invoices").Select("*", "", false)
-	
-	// Filter by status
-	if status != "" && status != "all" {
-		query = query.Eq("status", status)
-	}
-	
-	// Filter by date range
-	if startDate != "" {
-		query = query.Gte("created_at", startDate)
-	}
-	if endDate != "" {
-		query = query.Lte("created_at", endDate)
-	}
-	
-	_, err := query.ExecuteTo(&invoices)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Client-side search filtering (for customer_id or notes)
-	if searchTerm != "" && len(invoices) > 0 {
-		var filtered []Invoice
-		for _, inv := range invoices {
-			// Search in customer_id, notes, or invoice id
-			if containsIgnoreCase(inv.ID, searchTerm) ||
-				containsIgnoreCase(inv.CustomerID, searchTerm) ||
-				containsIgnoreCase(inv.Notes, searchTerm) {
-				filtered = append(filtered, inv)
-			}
-		}
-		return filtered, nil
-	}
-	
-	return invoices, nil
-}
-
-// Helper function for case-insensitive string matching
-func containsIgnoreCase(str, substr string) bool {
-	return len(str) >= len(substr) && 
-		(str == substr || 
-		 len(substr) == 0 || 
-		 stringContains(str, substr))
-}
-
-func stringContains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
-// ============================================
-// PAYMENT RECORDING FUNCTIONS
-// ============================================
-
-// RecordPayment creates a payment record and updates invoice status
-func (c *Client) RecordPayment(payment PaymentCreate) (*Payment, error) {
-	// Create payment record
-	var result []Payment
-	_, err := c.supabase.From("payments").Insert(payment, false, "", "", "").ExecuteTo(&result)
-	if err != nil {
-		return nil, err
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no payment created")
-	}
-
-	// Get invoice to update payment status
-	invoice, err := c.GetInvoice(payment.InvoiceID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Calculate new paid amount
-	newPaidAmount := invoice.PaidAmount + payment.Amount
-	
-	// Determine payment status
-	paymentStatus := "partially_paid"
-	if newPaidAmount >= invoice.Total {
-		paymentStatus = "paid"
-		newPaidAmount = invoice.Total // Cap at total
-	}
-
-	// Update invoice
-	updateData := map[string]interface{}{
-		"paid_amount":    newPaidAmount,
-		"payment_status": paymentStatus,
-		"payment_date":   payment.PaymentDate,
-	}
-
-	var updated []Invoice
-	_, err = c.supabase.From("invoices").
-		Update(updateData, "", "").
-		Eq("id", payment.InvoiceID).
-		ExecuteTo(&updated)
-	
-	if err != nil {
-		return nil, fmt.Errorf("failed to update invoice: %v", err)
-	}
-
-	return &result[0], nil
-}
-
-// GetPaymentsByInvoice returns all payments for an invoice
-func (c *Client) GetPaymentsByInvoice(invoiceID string) ([]Payment, error) {
-	var payments []Payment
-	_, err := c.supabase.From("payments").
-		Select("*", "", false).
-		Eq("invoice_id", invoiceID).
-		ExecuteTo(&payments)
-	return payments, err
-}
-
-// GetAllPayments returns all payments with optional filtering
-func (c *Client) GetAllPayments() ([]Payment, error) {
-	var payments []Payment
-	_, err := c.supabase.From("payments").
-		Select("*", "", false).
-		ExecuteTo(&payments)
-	return payments, err
-}
-
-// ============================================
-// DASHBOARD & ANALYTICS FUNCTIONS
-// ============================================
-
-// GetDashboardStats returns overall statistics for dashboard
-func (c *Client) GetDashboardStats(currency string) (*DashboardStats, error) {
-	var invoices []Invoice
-	query := c.supabase.From("invoices").Select("*", "", false)
-	
-	if currency != "" && currency != "ALL" {
-		query = query.Eq("currency", currency)
-	}
-	
-	_, err := query.ExecuteTo(&invoices)
-	if err != nil {
-		return nil, err
-	}
-
-	stats := &DashboardStats{Currency: currency}
-	
-	for _, inv := range invoices {
-		stats.TotalRevenue += inv.Total
-		stats.TotalInvoices++
-		
-		switch inv.PaymentStatus {
-		case "paid":
-			stats.PaidAmount += inv.Total
-			stats.PaidInvoices++
-		case "partially_paid":
-			stats.PaidAmount += inv.PaidAmount
-			stats.UnpaidAmount += (inv.Total - inv.PaidAmount)
-			stats.PartiallyPaid++
-		case "overdue":
-			stats.OverdueAmount += inv.Total
-			stats.OverdueInvoices++
-		case "unpaid":
-			stats.UnpaidAmount += inv.Total
-			stats.UnpaidInvoices++
+// Store is the persistence layer used by the HTTP handlers. It is implemented
+// by SupabaseStore (PostgREST over HTTP) and SQLStore (direct SQL connection).
+// List methods return one page and take an optional filter expression, parsed
+// against the list's FilterFields; nil matches every row.
+type Store interface {
+	// Organizations
+	//
+	// A store reads and writes the customers, invoices, payments, credit
+	// notes, currency rates, product catalog, price lists, company profiles
+	// and numbering series of one organization, the default one unless
+	// WithOrg names another; records of other organizations are not found.
+	WithOrg(orgID string) Store
+	// GetOrganization returns the store's organization
+	GetOrganization() (*Organization, error)
+
+	// Customers
+	//
+	// Writes to an existing customer or invoice take the version the caller
+	// read and return ErrVersionConflict if the record has changed since; a
+	// version of 0 skips the check.
+	ListCustomers(scope CustomerScope, where *filter.Expr, page PageRequest) (*Page[Customer], error)
+	CreateCustomer(name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error)
+	// GetCustomer returns archived customers too, so old invoices still
+	// resolve their customer
+	GetCustomer(id string) (*Customer, error)
+	UpdateCustomer(id string, version int, name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error)
+	ArchiveCustomer(id string) (*Customer, error)
+	RestoreCustomer(id string) (*Customer, error)
+	// SetCustomerPriceList assigns a customer a price list, or none for an
+	// empty priceListID, returning ErrUnknownPriceList if there is no such
+	// price list
+	SetCustomerPriceList(customerID, priceListID string) (*Customer, error)
+	// DeleteCustomer deletes a customer. It returns ErrCustomerHasInvoices
+	// when the customer has invoices unless force is set, in which case the
+	// invoices and their payments are deleted with it.
+	DeleteCustomer(id string, version int, force bool) error
+
+	// Invoices
+	// CreateInvoice stores an invoice and its lines, priced by the caller,
+	// numbering it from the invoice series of the issuing company; tax is
+	// the tax percentage. status is draft, issued or sent (see
+	// lifecycle.Initial).
+	CreateInvoice(companyID, customerID string, subtotal money.Amount, tax float64, discount, total money.Amount, items []Item, status, notes, dueDate, currency string) (*Invoice, error)
+	ListInvoices(where *filter.Expr, page PageRequest) (*Page[Invoice], error)
+	GetInvoice(id string) (*Invoice, error)
+	// UpdateInvoice replaces an invoice's notes and due date and, for a
+	// status other than "" and the current one, moves it to that status as
+	// TransitionInvoice does
+	UpdateInvoice(id string, version int, status, notes, dueDate string) (*Invoice, error)
+	// TransitionInvoice moves an invoice to issued, sent, void or
+	// written_off by hand, returning lifecycle.ErrNotAllowed if its status
+	// does not allow it. reason is kept for void and written_off.
+	TransitionInvoice(id string, version int, status, reason string) (*Invoice, error)
+	// MarkOverdueInvoices marks the open invoices of every organization
+	// whose due date has passed as overdue, returning how many it marked
+	MarkOverdueInvoices() (int, error)
+	FilterInvoices(status, searchTerm, startDate, endDate string, where *filter.Expr, page PageRequest) (*Page[Invoice], error)
+
+	// Currency rates
+	GetCurrencyRate(fromCurrency, toCurrency string) (*CurrencyRate, error)
+	ListCurrencyRates() ([]CurrencyRate, error)
+
+	// Payments
+	// RecordPayment inserts the payment and updates the invoice's paid amount
+	// and status atomically, returning lifecycle.ErrNotAllowed if the
+	// invoice does not accept payments. What is paid beyond the invoice's
+	// balance, and all of a payment without an invoice, goes to the
+	// customer's credit.
+	RecordPayment(payment PaymentCreate) (*Payment, error)
+	// GetPayment returns a payment with its refunds and allocations
+	GetPayment(id string) (*Payment, error)
+	// CorrectPayment replaces the details of a payment and VoidPayment voids
+	// it, recomputing the invoice's paid amount and status from its payments
+	// that are not void. Both return ErrPaymentVoided for a void payment;
+	// CorrectPayment returns ErrRefundExceedsPayment for an amount below what
+	// was refunded of the payment. Both, like RecordRefund, return
+	// ErrPaymentAllocated if they would take back what was allocated of the
+	// payment, and ErrInsufficientCredit if the customer's credit would fall
+	// below zero.
+	CorrectPayment(id string, version int, correction PaymentCorrection) (*Payment, error)
+	VoidPayment(id string, version int, reason string) (*Payment, error)
+	// GetPaymentsByInvoice returns an invoice's payments with their refunds
+	GetPaymentsByInvoice(invoiceID string) ([]Payment, error)
+	GetAllPayments(where *filter.Expr, page PageRequest) (*Page[Payment], error)
+
+	// Refunds
+	// RecordRefund returns part or all of a payment to the customer and
+	// recomputes the invoice's paid amount and status from its payments,
+	// returning ErrRefundExceedsPayment if more would be returned than is
+	// left of the payment and ErrPaymentVoided for a void payment
+	RecordRefund(refund RefundCreate) (*Refund, error)
+	GetRefundsByPayment(paymentID string) ([]Refund, error)
+
+	// Customer credit
+	// ApplyCredit pays an invoice from its customer's credit in the
+	// invoice's currency with a credit application: amount of it, or for a
+	// zero amount as much as there is credit and is owed. It returns
+	// lifecycle.ErrNotAllowed if the invoice does not accept payments,
+	// ErrCreditExceedsBalance if more is applied than is owed and
+	// ErrInsufficientCredit if more is applied than there is credit. Credit
+	// is also applied by itself to an invoice created issued or sent, or
+	// issued from a draft.
+	ApplyCredit(invoiceID string, amount money.Amount, createdBy string) (*CreditApplication, error)
+	GetCreditApplications(invoiceID string) ([]CreditApplication, error)
+	// VoidCreditApplication voids a credit application at version, giving
+	// the credit back and recomputing the invoice's paid amount and status.
+	// It returns ErrCreditApplicationVoided if it was voided.
+	VoidCreditApplication(id string, version int, reason string) (*CreditApplication, error)
+
+	// Payment allocations
+	// AllocatePayment allocates what is left of a payment without an invoice
+	// to invoices of its customer in its currency, by allocations or, with
+	// none, oldest due first, returning the payment with its allocations. It
+	// returns ErrNotFound for an invoice that is not the customer's in the
+	// currency, lifecycle.ErrNotAllowed for one that does not accept
+	// payments, ErrOverallocated if more is allocated than is left or owed,
+	// and ErrPaymentAllocated for a payment recorded on an invoice.
+	AllocatePayment(id string, allocations []PaymentAllocation, createdBy string) (*Payment, error)
+
+	// Credit notes
+	// CreateCreditNote issues a credit note priced by pricing.Credit from
+	// the invoice at version, numbering it from the credit_note series, and
+	// lowers the invoice's balance by its total. It returns
+	// lifecycle.ErrNotAllowed if the invoice's status does not allow credit
+	// notes (see lifecycle.Creditable) or the note exceeds the balance.
+	CreateCreditNote(version int, note CreditNote) (*CreditNote, error)
+	GetCreditNote(id string) (*CreditNote, error)
+	GetCreditNotesByInvoice(invoiceID string) ([]CreditNote, error)
+	ListCreditNotes(where *filter.Expr, page PageRequest) (*Page[CreditNote], error)
+
+	// Dashboard
+	GetDashboardStats(currency string) (*DashboardStats, error)
+	GetRevenueByPeriod(period string, limit int) ([]RevenueByPeriod, error)
+	GetTopCustomers(limit int) ([]TopCustomer, error)
+	GetTopItems(limit int) ([]TopItem, error)
+	GetOverdueInvoices() ([]Invoice, error)
+
+	// Product catalog
+	// ListProducts lists active (true) or inactive (false) products, or both
+	// when active is nil
+	ListProducts(active *bool, where *filter.Expr, page PageRequest) (*Page[Product], error)
+	GetProduct(id string) (*Product, error)
+	// CreateProduct and UpdateProduct save a product with its prices,
+	// returning ErrSKUTaken if another product has its SKU. UpdateProduct
+	// replaces every field and price.
+	CreateProduct(product Product) (*Product, error)
+	UpdateProduct(id string, version int, product Product) (*Product, error)
+	// DeleteProduct removes a product from the catalog. Invoice lines taken
+	// from it keep their details but no longer reference it.
+	DeleteProduct(id string, version int) error
+
+	// Price lists
+	ListPriceLists() ([]PriceList, error)
+	GetPriceList(id string) (*PriceList, error)
+	// CreatePriceList and UpdatePriceList save a price list with its rules,
+	// returning ErrUnknownProduct if a rule is for a product not in the
+	// catalog. UpdatePriceList replaces every field and rule.
+	CreatePriceList(list PriceList) (*PriceList, error)
+	UpdatePriceList(id string, version int, list PriceList) (*PriceList, error)
+	// DeletePriceList deletes a price list and unassigns it from its
+	// customers. Invoices keep the prices it set.
+	DeletePriceList(id string, version int) error
+
+	// Company profiles
+	ListCompanyProfiles() ([]CompanyProfile, error)
+	// GetCompanyProfile returns a company profile, or the default profile
+	// for an empty id
+	GetCompanyProfile(id string) (*CompanyProfile, error)
+	// CreateCompanyProfile saves a new profile with its own invoice
+	// numbering series, whose numbers start with invoicePrefix; it returns
+	// ErrPrefixTaken if another company's series uses the prefix. Saving a
+	// profile as the default makes it the only default.
+	CreateCompanyProfile(profile CompanyProfile, invoicePrefix string) (*CompanyProfile, error)
+	// UpdateCompanyProfile replaces every field of a profile except its
+	// series. The default profile stays the default until another one is
+	// made the default.
+	UpdateCompanyProfile(id string, version int, profile CompanyProfile) (*CompanyProfile, error)
+	// DeleteCompanyProfile deletes a profile, returning ErrDefaultCompany
+	// for the default profile and ErrCompanyHasInvoices for one that issued
+	// invoices
+	DeleteCompanyProfile(id string, version int) error
+
+	// Document numbering
+	ListNumberSeries() ([]NumberSeries, error)
+	UpdateNumberSeries(series NumberSeries) (*NumberSeries, error)
+	// AuditNumberSeries checks every counter period of the series for
+	// numbers that were allocated but never issued
+	AuditNumberSeries(key string) (*NumberingAudit, error)
+
+	// Audit log
+	// WithActor returns a view of the store whose changes the audit log
+	// attributes to actor
+	WithActor(actor string) Store
+	// GetHistory returns the audit log entries recorded under an entity ID
+	// for any of the entity types, oldest first
+	GetHistory(entityID string, entityTypes ...string) ([]AuditEntry, error)
+}
+
+// driver returns the database backend selected by the environment.
+//
+// DB_DRIVER chooses the backend explicitly ("supabase", "postgres" or
+// "sqlite"). When it is empty, DATABASE_URL selects the native Postgres
+// driver, SUPABASE_URL/SUPABASE_KEY select Supabase, and otherwise the API
+// falls back to the embedded SQLite database at SQLITE_PATH (default
+// invoice.db) so it can run with no external services.
+func driver() string {
+	if d := os.Getenv("DB_DRIVER"); d != "" {
+		return d
+	}
+	switch {
+	case os.Getenv("DATABASE_URL") != "":
+		return "postgres"
+	case os.Getenv("SUPABASE_URL") != "" && os.Getenv("SUPABASE_KEY") != "":
+		return "supabase"
+	default:
+		return "sqlite"
+	}
+}
+
+// autoMigrate reports whether pending migrations should be applied on
+// startup. DB_AUTO_MIGRATE=true/false decides; when unset only the embedded
+// SQLite database is migrated automatically.
+func autoMigrate(d string) bool {
+	if v, err := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE")); err == nil {
+		return v
+	}
+	return d == "sqlite"
+}
+
+// New opens the Store selected by the environment (see driver) and, when
+// configured, brings its schema up to date.
+func New() (Store, error) {
+	d := driver()
+	switch d {
+	case "supabase":
+		store, err := NewSupabase()
+		if err != nil {
+			return nil, err
 		}
-	}
-	
-	return stats, nil
-}
-
-// GetRevenueByPeriod returns revenue grouped by period (daily, weekly, monthly)
-func (c *Client) GetRevenueByPeriod(period string, limit int) ([]RevenueByPeriod, error) {
-	// For simplicity, we'll aggregate in Go
-	var invoices []Invoice
-	_, err := c.supabase.From("invoices").
-		Select("*", "", false).
-		ExecuteTo(&invoices)
-	
-	if err != nil {
-		return nil, err
-	}
-
-	// Group by date (simplified - daily grouping)
-	revenueMap := make(map[string]*RevenueByPeriod)
-	
-	for _, inv := range invoices {
-		date := inv.CreatedAt[:10] // Get YYYY-MM-DD
-		
-		if _, exists := revenueMap[date]; !exists {
-			revenueMap[date] = &RevenueByPeriod{
-				Date:     date,
-				Currency: inv.Currency,
+		if autoMigrate(d) {
+			// PostgREST cannot run DDL; migrate through the project's
+			// Postgres connection string instead
+			if os.Getenv("DATABASE_URL") == "" {
+				log.Printf("Warning: DB_AUTO_MIGRATE needs DATABASE_URL with the supabase driver; skipping migrations")
+				return store, nil
 			}
-		}
-		
-		revenueMap[date].Revenue += inv.Total
-		revenueMap[date].InvoiceCount++
-	}
-	
-	// Convert map to slice
-	var result []RevenueByPeriod
-	for _, v := range revenueMap {
-		result = append(result, *v)
-	}
-	
-	return result, nil
-}
-
-// GetTopCustomers returns customers with highest revenue
-func (c *Client) GetTopCustomers(limit int) ([]TopCustomer, error) {
-	var invoices []Invoice
-	_, err := c.supabase.From("invoices").
-		Select("*", "", false).
-		ExecuteTo(&invoices)
-	
-	if err != nil {
-		return nil, err
-	}
-
-	// Group by customer
-	customerMap := make(map[string]*TopCustomer)
-	
-	for _, inv := range invoices {
-		if _, exists := customerMap[inv.CustomerID]; !exists {
-			// Get customer name
-			customer, err := c.GetCustomer(inv.CustomerID)
+			sqlStore, err := NewPostgres(os.Getenv("DATABASE_URL"))
 			if err != nil {
-				continue
+				return nil, err
 			}
-			
-			customerMap[inv.CustomerID] = &TopCustomer{
-				CustomerID:   inv.CustomerID,
-				CustomerName: customer.Name,
-				Currency:     inv.Currency,
+			defer sqlStore.Close()
+			if err := sqlStore.migrateUp(); err != nil {
+				return nil, err
 			}
 		}
-		
-		customerMap[inv.CustomerID].TotalRevenue += inv.Total
-		customerMap[inv.CustomerID].InvoiceCount++
-	}
-	
-	// Convert to slice and sort (simplified)
-	var result []TopCustomer
-	for _, v := range customerMap {
-		result = append(result, *v)
-	}
-	
-	// Return first N customers (should sort by revenue first)
-	if len(result) > limit {
-		result = result[:limit]
+		return store, nil
+	case "postgres", "sqlite":
+		store, err := OpenSQL()
+		if err != nil {
+			return nil, err
+		}
+		if autoMigrate(d) {
+			if err := store.migrateUp(); err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+		if d == "sqlite" {
+			log.Printf("Using embedded SQLite database (offline mode)")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", d)
 	}
-	
-	return result, nil
-}
-
-// GetOverdueInvoices returns all overdue invoices
-func (c *Client) GetOverdueInvoices() ([]Invoice, error) {
-	var invoices []Invoice
-	_, err := c.supabase.From("invoices").
-		Select("*", "", false).
-		Eq("payment_status", "overdue").
-		ExecuteTo(&invoices)
-	return invoices, err
 }
 
-// ============================================
-// INVOICE NUMBERING FUNCTIONS
-// ============================================
-
-// GenerateInvoiceNumber generates a new invoice number using database function
-func (c *Client) GenerateInvoiceNumber() (string, error) {
-	// Simple fallback: generate based on count + timestamp
-	var count []Invoice
-	_, err := c.supabase.From("invoices").
-		Select("id", "", false).
-		ExecuteTo(&count)
-	
-	if err != nil {
-		return "", err
+// OpenSQL opens a direct SQL connection to the database selected by the
+// environment, for maintenance such as migrations. With the supabase driver
+// it connects to DATABASE_URL, which must hold the project's Postgres
+// connection string.
+func OpenSQL() (*SQLStore, error) {
+	switch d := driver(); d {
+	case "sqlite":
+		return NewSQLite(os.Getenv("SQLITE_PATH"))
+	case "postgres", "supabase":
+		return NewPostgres(os.Getenv("DATABASE_URL"))
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", d)
 	}
-	
-	// Format: INV-2026-0001
-	invoiceNum := fmt.Sprintf("INV-2026-%04d", len(count)+1)
-	return invoiceNum, nil
 }
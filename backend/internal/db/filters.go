@@ -0,0 +1,76 @@
+package db
+
+import (
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/lifecycle"
+)
+
+// Fields accepted by the filter parameter of the customer, invoice, payment,
+// credit note and product lists (see package filter)
+var (
+	CustomerFilterFields = filter.Schema{
+		"name":         {Column: "name", Type: filter.String},
+		"email":        {Column: "email", Type: filter.String},
+		"phone":        {Column: "phone", Type: filter.String},
+		"address":      {Column: "address", Type: filter.String},
+		"city":         {Column: "city", Type: filter.String},
+		"postal_code":  {Column: "postal_code", Type: filter.String},
+		"country":      {Column: "country", Type: filter.String},
+		"company_name": {Column: "company_name", Type: filter.String},
+		"created_at":   {Column: "created_at", Type: filter.Timestamp},
+		"archived_at":  {Column: "archived_at", Type: filter.Timestamp},
+	}
+
+	InvoiceFilterFields = filter.Schema{
+		"invoice_number":  {Column: "invoice_number", Type: filter.String},
+		"customer_id":     {Column: "customer_id", Type: filter.ID},
+		"status":          {Column: "status", Type: filter.Enum, Values: lifecycle.Statuses},
+		"payment_status":  {Column: "payment_status", Type: filter.Enum, Values: []string{"unpaid", "partially_paid", "paid", "overdue"}},
+		"currency":        {Column: "currency", Type: filter.String},
+		"subtotal":        {Column: "subtotal", Type: filter.Number},
+		"tax":             {Column: "tax", Type: filter.Number},
+		"discount":        {Column: "discount", Type: filter.Number},
+		"total":           {Column: "total", Type: filter.Number},
+		"paid_amount":     {Column: "paid_amount", Type: filter.Number},
+		"credited_amount": {Column: "credited_amount", Type: filter.Number},
+		"notes":           {Column: "notes", Type: filter.String},
+		"due_date":        {Column: "due_date", Type: filter.Date},
+		"payment_date":    {Column: "payment_date", Type: filter.Timestamp},
+		"created_at":      {Column: "created_at", Type: filter.Timestamp},
+	}
+
+	PaymentFilterFields = filter.Schema{
+		"invoice_id":       {Column: "invoice_id", Type: filter.ID},
+		"customer_id":      {Column: "customer_id", Type: filter.ID},
+		"currency":         {Column: "currency", Type: filter.String},
+		"receipt_number":   {Column: "receipt_number", Type: filter.String},
+		"amount":           {Column: "amount", Type: filter.Number},
+		"refunded_amount":  {Column: "refunded_amount", Type: filter.Number},
+		"payment_method":   {Column: "payment_method", Type: filter.String},
+		"reference_number": {Column: "reference_number", Type: filter.String},
+		"notes":            {Column: "notes", Type: filter.String},
+		"payment_date":     {Column: "payment_date", Type: filter.Timestamp},
+		"voided_at":        {Column: "voided_at", Type: filter.Timestamp},
+		"created_at":       {Column: "created_at", Type: filter.Timestamp},
+	}
+
+	CreditNoteFilterFields = filter.Schema{
+		"invoice_id":         {Column: "invoice_id", Type: filter.ID},
+		"credit_note_number": {Column: "credit_note_number", Type: filter.String},
+		"reason":             {Column: "reason", Type: filter.String},
+		"currency":           {Column: "currency", Type: filter.String},
+		"subtotal":           {Column: "subtotal", Type: filter.Number},
+		"tax":                {Column: "tax", Type: filter.Number},
+		"total":              {Column: "total", Type: filter.Number},
+		"created_at":         {Column: "created_at", Type: filter.Timestamp},
+	}
+
+	ProductFilterFields = filter.Schema{
+		"sku":         {Column: "sku", Type: filter.String},
+		"name":        {Column: "name", Type: filter.String},
+		"description": {Column: "description", Type: filter.String},
+		"unit":        {Column: "unit", Type: filter.String},
+		"tax_rate":    {Column: "tax_rate", Type: filter.Number},
+		"created_at":  {Column: "created_at", Type: filter.Timestamp},
+	}
+)
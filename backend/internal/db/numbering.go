@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Document numbering series. Each series keeps one counter per period (year,
+// month or a single never-resetting period) that is incremented inside the
+// same transaction that inserts the document, so numbers are never reused and
+// never skipped. Every issued number is also logged in document_numbers,
+// which is what the gap audit checks against.
+const (
+	SeriesInvoice    = "invoice"
+	SeriesCreditNote = "credit_note"
+	SeriesReceipt    = "receipt"
+)
+
+// Counter reset policies
+const (
+	ResetNever   = "never"
+	ResetYearly  = "yearly"
+	ResetMonthly = "monthly"
+)
+
+// NumberSeries is the configuration of a document numbering series.
+// Pattern understands the tokens {PREFIX}, {YEAR}, {YY}, {MONTH} and {NUMBER}.
+type NumberSeries struct {
+	Key       string `json:"series_key"`
+	Prefix    string `json:"prefix"`
+	Pattern   string `json:"pattern"`
+	Reset     string `json:"reset"`
+	Padding   int    `json:"padding"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// NumberingAudit reports, per counter period, whether every number up to the
+// counter's current value was issued
+type NumberingAudit struct {
+	Series  string            `json:"series"`
+	Periods []NumberingPeriod `json:"periods"`
+	Gaps    int               `json:"gaps"`
+	OK      bool              `json:"ok"`
+}
+
+// NumberingPeriod is the audit result for one counter period
+type NumberingPeriod struct {
+	Period    string `json:"period"`
+	LastValue int    `json:"last_value"`
+	Issued    int    `json:"issued"`
+	Missing   []int  `json:"missing"`
+}
+
+// Validate checks that the series configuration produces unique numbers
+func (s NumberSeries) Validate() error {
+	if !strings.Contains(s.Pattern, "{NUMBER}") {
+		return fmt.Errorf("pattern must contain {NUMBER}")
+	}
+	if s.Padding < 1 || s.Padding > 12 {
+		return fmt.Errorf("padding must be between 1 and 12")
+	}
+	hasYear := strings.Contains(s.Pattern, "{YEAR}") || strings.Contains(s.Pattern, "{YY}")
+	switch s.Reset {
+	case ResetNever:
+	case ResetYearly:
+		if !hasYear {
+			return fmt.Errorf("a yearly series pattern must contain {YEAR} or {YY}")
+		}
+	case ResetMonthly:
+		if !hasYear || !strings.Contains(s.Pattern, "{MONTH}") {
+			return fmt.Errorf("a monthly series pattern must contain {MONTH} and {YEAR} or {YY}")
+		}
+	default:
+		return fmt.Errorf("reset must be one of never, yearly, monthly")
+	}
+	return nil
+}
+
+// Period returns the counter period a document issued at t belongs to
+func (s NumberSeries) Period(t time.Time) string {
+	switch s.Reset {
+	case ResetYearly:
+		return t.Format("2006")
+	case ResetMonthly:
+		return t.Format("2006-01")
+	default:
+		return ""
+	}
+}
+
+// Format renders the number for sequence value seq issued at t
+func (s NumberSeries) Format(seq int, t time.Time) string {
+	return strings.NewReplacer(
+		"{PREFIX}", s.Prefix,
+		"{YEAR}", t.Format("2006"),
+		"{YY}", t.Format("06"),
+		"{MONTH}", t.Format("01"),
+		"{NUMBER}", fmt.Sprintf("%0*d", s.Padding, seq),
+	).Replace(s.Pattern)
+}
+
+// auditPeriod compares a counter's last value with the sequences that were
+// actually logged for that period. issued must be sorted ascending.
+func auditPeriod(period string, lastValue int, issued []int) NumberingPeriod {
+	result := NumberingPeriod{Period: period, LastValue: lastValue, Issued: len(issued), Missing: []int{}}
+	next := 1
+	for _, seq := range issued {
+		for ; next < seq && next <= lastValue; next++ {
+			result.Missing = append(result.Missing, next)
+		}
+		if seq >= next {
+			next = seq + 1
+		}
+	}
+	for ; next <= lastValue; next++ {
+		result.Missing = append(result.Missing, next)
+	}
+	return result
+}
+
+// newNumberingAudit builds the audit summary from per-period results
+func newNumberingAudit(series string, periods []NumberingPeriod) *NumberingAudit {
+	audit := &NumberingAudit{Series: series, Periods: periods}
+	if audit.Periods == nil {
+		audit.Periods = []NumberingPeriod{}
+	}
+	for _, p := range periods {
+		audit.Gaps += len(p.Missing)
+	}
+	audit.OK = audit.Gaps == 0
+	return audit
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// testSeries returns the series of the store with key
+func testSeries(t *testing.T, s Store, key string) NumberSeries {
+	t.Helper()
+	series, err := s.ListNumberSeries()
+	if err != nil {
+		t.Fatalf("ListNumberSeries: %v", err)
+	}
+	for _, ns := range series {
+		if ns.Key == key {
+			return ns
+		}
+	}
+	t.Fatalf("no %s series", key)
+	return NumberSeries{}
+}
+
+// TestNextDocumentNumber checks that each series counts on its own, one
+// counter per period, without gaps
+func TestNextDocumentNumber(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	now := time.Now()
+	invoices := testSeries(t, s, SeriesInvoice)
+	receipts := testSeries(t, s, SeriesReceipt)
+
+	// Last year's counter does not carry over into this year's
+	lastYear := strconv.Itoa(now.Year() - 1)
+	_, err := s.db.Exec(`INSERT INTO number_series_counters (org_id, series_key, period, last_value) VALUES ($1, $2, $3, 41)`,
+		DefaultOrg, SeriesInvoice, lastYear)
+	if err != nil {
+		t.Fatalf("insert counter: %v", err)
+	}
+
+	for seq := 1; seq <= 3; seq++ {
+		inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+		if want := invoices.Format(seq, now); inv.InvoiceNumber != want {
+			t.Errorf("invoice %d numbered %s, want %s", seq, inv.InvoiceNumber, want)
+		}
+		p := pay(t, s, inv.ID, money.FromInt(10))
+		if want := receipts.Format(seq, now); p.ReceiptNumber != want {
+			t.Errorf("receipt %d numbered %s, want %s", seq, p.ReceiptNumber, want)
+		}
+	}
+
+	var last int
+	err = s.db.QueryRow(`SELECT last_value FROM number_series_counters WHERE org_id = $1 AND series_key = $2 AND period = $3`,
+		DefaultOrg, SeriesInvoice, lastYear).Scan(&last)
+	if err != nil {
+		t.Fatalf("read counter: %v", err)
+	}
+	if last != 41 {
+		t.Errorf("last year's counter = %d, want 41", last)
+	}
+}
+
+// TestNextDocumentNumberRollback checks that an invoice that fails to be
+// stored gives its number back
+func TestNextDocumentNumberRollback(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	now := time.Now()
+	invoices := testSeries(t, s, SeriesInvoice)
+
+	// Positions start at 1, so the line is refused after the number was taken
+	bad := []Item{{Position: 0, Description: "Consulting", Quantity: money.FromInt(1), UnitPrice: money.FromInt(100), Total: money.FromInt(100)}}
+	if _, err := s.CreateInvoice("", c.ID, money.FromInt(100), 0, 0, money.FromInt(100), bad, lifecycle.Issued, "", "", "USD"); err == nil {
+		t.Fatal("CreateInvoice stored a line at position 0")
+	}
+
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	if want := invoices.Format(1, now); inv.InvoiceNumber != want {
+		t.Errorf("invoice numbered %s, want %s", inv.InvoiceNumber, want)
+	}
+	audit, err := s.AuditNumberSeries(SeriesInvoice)
+	if err != nil {
+		t.Fatalf("AuditNumberSeries: %v", err)
+	}
+	if !audit.OK {
+		t.Errorf("audit found %d gaps, want none", audit.Gaps)
+	}
+}
+
+func TestAuditNumberSeries(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	period := testSeries(t, s, SeriesInvoice).Period(time.Now())
+	for i := 0; i < 3; i++ {
+		testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	}
+
+	// Numbers 4 and 5 are taken but never issued
+	_, err := s.db.Exec(`UPDATE number_series_counters SET last_value = last_value + 2 WHERE org_id = $1 AND series_key = $2`,
+		DefaultOrg, SeriesInvoice)
+	if err != nil {
+		t.Fatalf("update counter: %v", err)
+	}
+
+	audit, err := s.AuditNumberSeries(SeriesInvoice)
+	if err != nil {
+		t.Fatalf("AuditNumberSeries: %v", err)
+	}
+	if audit.OK || audit.Gaps != 2 || len(audit.Periods) != 1 {
+		t.Fatalf("audit = %+v, want 2 gaps in one period", audit)
+	}
+	got := audit.Periods[0]
+	if got.Period != period || got.LastValue != 5 || got.Issued != 3 || len(got.Missing) != 2 || got.Missing[0] != 4 || got.Missing[1] != 5 {
+		t.Errorf("period = %+v, want %s up to 5 with 3 issued and 4, 5 missing", got, period)
+	}
+}
+
+func TestAuditPeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		lastValue int
+		issued    []int
+		missing   []int
+	}{
+		{"never used", 0, nil, []int{}},
+		{"all issued", 3, []int{1, 2, 3}, []int{}},
+		{"gap in the middle", 4, []int{1, 2, 4}, []int{3}},
+		{"gaps at both ends", 5, []int{2, 3}, []int{1, 4, 5}},
+		{"nothing issued", 2, nil, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		got := auditPeriod("2026", tt.lastValue, tt.issued)
+		if got.Issued != len(tt.issued) || len(got.Missing) != len(tt.missing) {
+			t.Errorf("%s: %+v, want %d issued and %v missing", tt.name, got, len(tt.issued), tt.missing)
+			continue
+		}
+		for i := range tt.missing {
+			if got.Missing[i] != tt.missing[i] {
+				t.Errorf("%s: missing %v, want %v", tt.name, got.Missing, tt.missing)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+// DefaultOrg is the organization created by migration 0014_organizations,
+// which owns every record that existed before organizations did
+const DefaultOrg = "00000000-0000-0000-0000-000000000001"
+
+// Organization is a tenant: a business whose customers, invoices, payments,
+// refunds, credit notes, currency rates, products, price lists, company
+// profiles and numbering series are kept apart from every other
+// organization's
+type Organization struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// tenantTables are the tables (and views) whose rows belong to an
+// organization, in their org_id column
+var tenantTables = map[string]bool{
+	"customers":      true,
+	"invoices":       true,
+	"payments":       true,
+	"currency_rates": true,
+	"credit_notes":   true,
+	"refunds":        true,
+	"item_sales":     true,
+
+	"credit_applications":    true,
+	"products":               true,
+	"price_lists":            true,
+	"company_info":           true,
+	"number_series":          true,
+	"number_series_counters": true,
+	"document_numbers":       true,
+}
+
+// tenantEntities are the audit log entity types of organization records
+var tenantEntities = map[string]bool{
+	AuditCustomer:       true,
+	AuditInvoice:        true,
+	AuditInvoiceItem:    true,
+	AuditPayment:        true,
+	AuditCreditNote:     true,
+	AuditCreditNoteItem: true,
+	AuditRefund:         true,
+
+	AuditCreditApplication: true,
+	AuditNumberSeries:      true,
+	AuditProduct:           true,
+	AuditProductPrice:      true,
+	AuditPriceList:         true,
+	AuditPriceListRule:     true,
+	AuditCompany:           true,
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+// WithOrg returns a view of the store scoped to the organization orgID
+func (s *SQLStore) WithOrg(orgID string) Store {
+	c := *s
+	c.org = orgID
+	return &c
+}
+
+func (s *SQLStore) GetOrganization() (*Organization, error) {
+	var o Organization
+	err := s.db.QueryRow(`SELECT id, name, created_at FROM organizations WHERE id = $1`, s.org).
+		Scan(&o.ID, &o.Name, text(&o.CreatedAt))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &o, nil
+}
+
+// ListOrganizations returns every organization, oldest first. It is not part
+// of Store: organizations are managed from the command line, not the API.
+func (s *SQLStore) ListOrganizations() ([]Organization, error) {
+	rows, err := s.db.Query(`SELECT id, name, created_at FROM organizations ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []Organization
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, text(&o.CreatedAt)); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, rows.Err()
+}
+
+// CreateOrganization creates an organization, starting its currency rates
+// and base numbering series from copies of the default organization's, with
+// a default company profile named after it
+func (s *SQLStore) CreateOrganization(name string) (*Organization, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var o Organization
+	err = tx.QueryRow(`INSERT INTO organizations (id, name) VALUES ($1, $2) RETURNING id, name, created_at`,
+		uuid.NewString(), name).Scan(&o.ID, &o.Name, text(&o.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT from_currency, to_currency, rate FROM currency_rates WHERE org_id = $1`, DefaultOrg)
+	if err != nil {
+		return nil, err
+	}
+	var rates []CurrencyRate
+	for rows.Next() {
+		var r CurrencyRate
+		if err := rows.Scan(&r.FromCurrency, &r.ToCurrency, &r.Rate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, r := range rates {
+		_, err := tx.Exec(`INSERT INTO currency_rates (id, org_id, from_currency, to_currency, rate) VALUES ($1, $2, $3, $4, $5)`,
+			uuid.NewString(), o.ID, r.FromCurrency, r.ToCurrency, r.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy currency rate %s/%s: %v", r.FromCurrency, r.ToCurrency, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO number_series (org_id, series_key, prefix, pattern, reset, padding)
+		SELECT $1, series_key, prefix, pattern, reset, padding FROM number_series
+		WHERE org_id = $2 AND series_key IN ($3, $4, $5)`,
+		o.ID, DefaultOrg, SeriesInvoice, SeriesCreditNote, SeriesReceipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy numbering series: %v", err)
+	}
+	_, err = tx.Exec(`INSERT INTO company_info (id, org_id, name, invoice_series, is_default) VALUES ($1, $2, $3, $4, TRUE)`,
+		uuid.NewString(), o.ID, o.Name, SeriesInvoice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create company profile: %v", err)
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// WithOrg returns a view of the store scoped to the organization orgID. Its
+// requests carry orgID in the X-Org-ID header, which the row level security
+// policies and the org_id column defaults read through current_org().
+func (c *SupabaseStore) WithOrg(orgID string) Store {
+	return c.with(c.actor, orgID)
+}
+
+// with returns a view of the store whose requests carry actor and org in
+// their headers
+func (c *SupabaseStore) with(actor, org string) *SupabaseStore {
+	headers := map[string]string{"X-Org-ID": org}
+	if actor != "" {
+		headers["X-Actor"] = actor
+	}
+	client, err := supabase.NewClient(c.url, c.key, &supabase.ClientOptions{Headers: headers})
+	if err != nil {
+		log.Printf("Warning: cannot scope requests to organization %s as %q: %v", org, actor, err)
+		return c
+	}
+	return &SupabaseStore{supabase: client, url: c.url, key: c.key, actor: actor, org: org}
+}
+
+func (c *SupabaseStore) GetOrganization() (*Organization, error) {
+	var orgs []Organization
+	_, err := c.supabase.From("organizations").Select("id, name, created_at", "", false).Eq("id", c.org).ExecuteTo(&orgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &orgs[0], nil
+}
+
+// table is a PostgREST table whose reads, updates and deletes are limited to
+// the store's organization when its rows belong to one. Inserted rows take
+// the organization from the X-Org-ID header.
+type table struct {
+	*postgrest.QueryBuilder
+	org string
+}
+
+// from starts a query on a table, scoped to the store's organization
+func (c *SupabaseStore) from(name string) table {
+	t := table{QueryBuilder: c.supabase.From(name)}
+	if tenantTables[name] {
+		t.org = c.org
+	}
+	return t
+}
+
+func (t table) scope(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	if t.org == "" {
+		return query
+	}
+	return query.Eq("org_id", t.org)
+}
+
+func (t table) Select(columns, count string, head bool) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Select(columns, count, head))
+}
+
+func (t table) Update(value interface{}, returning, count string) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Update(value, returning, count))
+}
+
+func (t table) Delete(returning, count string) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Delete(returning, count))
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// testOrg creates an organization and returns the store scoped to it
+func testOrg(t *testing.T, s *SQLStore, name string) Store {
+	t.Helper()
+	o, err := s.CreateOrganization(name)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	return s.WithOrg(o.ID)
+}
+
+// TestOrgIsolation checks that an organization can neither see nor change
+// another organization's customers, invoices and payments
+func TestOrgIsolation(t *testing.T) {
+	s := newTestStore(t)
+	other := testOrg(t, s, "Other Co")
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	p := pay(t, s, inv.ID, money.FromInt(40))
+
+	notFound := func(what string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of another organization: err = %v, want ErrNotFound", what, err)
+		}
+	}
+	_, err := other.GetCustomer(c.ID)
+	notFound("GetCustomer", err)
+	_, err = other.UpdateCustomer(c.ID, 0, "Mallory", c.Email, "", "", "", "", "", "")
+	notFound("UpdateCustomer", err)
+	notFound("DeleteCustomer", other.DeleteCustomer(c.ID, 0, true))
+	_, err = other.GetInvoice(inv.ID)
+	notFound("GetInvoice", err)
+	_, err = other.UpdateInvoice(inv.ID, 0, "", "Changed", "")
+	notFound("UpdateInvoice", err)
+	_, err = other.TransitionInvoice(inv.ID, 0, lifecycle.Void, "Not ours")
+	notFound("TransitionInvoice", err)
+	_, err = other.RecordPayment(PaymentCreate{InvoiceID: inv.ID, Amount: money.FromInt(10), PaymentMethod: "cash"})
+	notFound("RecordPayment", err)
+	_, err = other.GetPayment(p.ID)
+	notFound("GetPayment", err)
+	_, err = other.VoidPayment(p.ID, 0, "Not ours")
+	notFound("VoidPayment", err)
+	_, err = other.CreateInvoice("", c.ID, money.FromInt(100), 0, 0, money.FromInt(100), nil, lifecycle.Issued, "", "", "USD")
+	notFound("CreateInvoice for a customer", err)
+
+	customers, err := other.ListCustomers(AllCustomers, nil, PageRequest{})
+	if err != nil {
+		t.Fatalf("ListCustomers: %v", err)
+	}
+	invoices, err := other.ListInvoices(nil, PageRequest{})
+	if err != nil {
+		t.Fatalf("ListInvoices: %v", err)
+	}
+	if customers.Total != 0 || invoices.Total != 0 {
+		t.Errorf("other organization lists %d customers and %d invoices, want none", customers.Total, invoices.Total)
+	}
+
+	// Nothing of the first organization's changed
+	got, err := s.GetCustomer(c.ID)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	if got.Name != c.Name || got.Version != c.Version {
+		t.Errorf("customer = %s at version %d, want %s at %d", got.Name, got.Version, c.Name, c.Version)
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(40), lifecycle.PartiallyPaid)
+}
+
+// TestOrgNumbering checks that each organization numbers its documents on
+// its own
+func TestOrgNumbering(t *testing.T) {
+	s := newTestStore(t)
+	other := testOrg(t, s, "Other Co")
+	now := time.Now()
+	format := testSeries(t, s, SeriesInvoice)
+	if got := testSeries(t, other, SeriesInvoice); got.Format(1, now) != format.Format(1, now) {
+		t.Errorf("new organization's series formats %s, want a copy of the default's %s", got.Format(1, now), format.Format(1, now))
+	}
+
+	c := testCustomer(t, s)
+	for i := 0; i < 2; i++ {
+		testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	}
+	oc := testCustomer(t, other)
+	for seq := 1; seq <= 2; seq++ {
+		inv := testInvoice(t, other, oc.ID, money.FromInt(100), lifecycle.Issued)
+		if want := format.Format(seq, now); inv.InvoiceNumber != want {
+			t.Errorf("other organization's invoice %d numbered %s, want %s", seq, inv.InvoiceNumber, want)
+		}
+	}
+	if inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued); inv.InvoiceNumber != format.Format(3, now) {
+		t.Errorf("default organization's invoice 3 numbered %s, want %s", inv.InvoiceNumber, format.Format(3, now))
+	}
+
+	for _, store := range []Store{s, other} {
+		audit, err := store.AuditNumberSeries(SeriesInvoice)
+		if err != nil {
+			t.Fatalf("AuditNumberSeries: %v", err)
+		}
+		if !audit.OK {
+			t.Errorf("audit found %d gaps, want none", audit.Gaps)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Sort orders
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Page sizes used when a request does not ask for one, and the largest page
+// a client may ask for
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// ErrInvalidPage is returned for a page request with an unknown sort field or
+// order, an out-of-range limit, or a cursor that cannot be used
+var ErrInvalidPage = errors.New("invalid page request")
+
+// PageRequest selects one page of a list. Cursor is the NextCursor of the
+// previous page; it is only valid with the same Sort and Order.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Order  string
+}
+
+// Page is one page of a list, sorted by the requested field and then by id.
+// Total counts every row matching the list's filters, not just this page.
+type Page[T any] struct {
+	Items      []T
+	Total      int
+	Limit      int
+	Sort       string
+	Order      string
+	NextCursor string
+}
+
+// sortFields maps the sort names a list accepts to the SQL expression it
+// orders by. Expressions must never be NULL, so keyset comparisons hold.
+type sortFields map[string]string
+
+var (
+	customerSortFields = sortFields{
+		"created_at": "created_at",
+		"name":       "name",
+		"email":      "email",
+	}
+	invoiceSortFields = sortFields{
+		"created_at":     "created_at",
+		"invoice_number": "COALESCE(invoice_number, '')",
+		"total":          "total",
+	}
+	paymentSortFields = sortFields{
+		"payment_date": "payment_date",
+		"amount":       "amount",
+		"created_at":   "created_at",
+	}
+	creditNoteSortFields = sortFields{
+		"created_at": "created_at",
+		"total":      "total",
+	}
+	productSortFields = sortFields{
+		"sku":        "sku",
+		"name":       "name",
+		"created_at": "created_at",
+	}
+)
+
+// newestFirst lists the date sort fields, which default to descending order
+// so that a truncated list drops the oldest rows rather than the newest
+var newestFirst = map[string]bool{"created_at": true, "payment_date": true}
+
+func (f sortFields) names() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// cursor is the decoded form of PageRequest.Cursor: the ordering the
+// previous page was read in, and the sort value and id of the row it ended
+// on. The next page starts after that position, so it holds even when the
+// row itself has since been deleted.
+type cursor struct {
+	Sort  string          `json:"s"`
+	Order string          `json:"o"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+// arg returns the cursor's sort value as a query argument: a string, or a
+// number as an int64 or float64
+func (c *cursor) arg() (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(c.Value))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	}
+	return nil, fmt.Errorf("unexpected sort value %s", c.Value)
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	if _, err := c.arg(); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	}
+	return &c, nil
+}
+
+// normalize fills in defaults, validates the request against the sort
+// fields of a list and decodes its cursor (nil on the first page)
+func (p PageRequest) normalize(fields sortFields, defaultSort string) (PageRequest, *cursor, error) {
+	switch {
+	case p.Limit == 0:
+		p.Limit = DefaultPageLimit
+	case p.Limit < 0 || p.Limit > MaxPageLimit:
+		return p, nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, MaxPageLimit)
+	}
+
+	if p.Sort == "" {
+		p.Sort = defaultSort
+	}
+	if _, ok := fields[p.Sort]; !ok {
+		return p, nil, fmt.Errorf("%w: cannot sort by %q (use one of %s)", ErrInvalidPage, p.Sort, fields.names())
+	}
+
+	p.Order = strings.ToLower(p.Order)
+	if p.Order == "" {
+		p.Order = OrderAsc
+		if newestFirst[p.Sort] {
+			p.Order = OrderDesc
+		}
+	}
+	if p.Order != OrderAsc && p.Order != OrderDesc {
+		return p, nil, fmt.Errorf("%w: order must be %q or %q", ErrInvalidPage, OrderAsc, OrderDesc)
+	}
+
+	if p.Cursor == "" {
+		return p, nil, nil
+	}
+	c, err := decodeCursor(p.Cursor)
+	if err != nil {
+		return p, nil, err
+	}
+	if c.Sort != p.Sort || c.Order != p.Order {
+		return p, nil, fmt.Errorf("%w: cursor was issued for sort=%s&order=%s", ErrInvalidPage, c.Sort, c.Order)
+	}
+	return p, c, nil
+}
+
+// newPage builds a Page from up to Limit+1 rows and their sort values, as
+// JSON; the extra row only signals that another page follows
+func newPage[T any](rows []T, values []json.RawMessage, total int, p PageRequest, id func(T) string) *Page[T] {
+	page := &Page[T]{Items: rows, Total: total, Limit: p.Limit, Sort: p.Sort, Order: p.Order}
+	if len(rows) > p.Limit {
+		page.Items = rows[:p.Limit]
+		last := p.Limit - 1
+		page.NextCursor = encodeCursor(cursor{Sort: p.Sort, Order: p.Order, Value: values[last], ID: id(page.Items[last])})
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	return page
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"invoice-backend/internal/money"
+)
+
+func TestNormalize(t *testing.T) {
+	nameCursor := func(order string) string {
+		return encodeCursor(cursor{Sort: "name", Order: order, Value: json.RawMessage(`"Acme"`), ID: "6f1c1b4e-3f0a-4a55-9c53-5b0e8f7a2d10"})
+	}
+	tests := []struct {
+		name        string
+		page        PageRequest
+		defaultSort string
+		want        PageRequest // Limit, Sort and Order after normalizing
+		cursor      bool
+		invalid     bool
+	}{
+		{"defaults", PageRequest{}, "name", PageRequest{Limit: DefaultPageLimit, Sort: "name", Order: OrderAsc}, false, false},
+		{"created_at newest first", PageRequest{}, "created_at", PageRequest{Limit: DefaultPageLimit, Sort: "created_at", Order: OrderDesc}, false, false},
+		{"created_at ascending", PageRequest{Order: "ASC"}, "created_at", PageRequest{Limit: DefaultPageLimit, Sort: "created_at", Order: OrderAsc}, false, false},
+		{"explicit", PageRequest{Limit: 10, Sort: "email", Order: "desc"}, "name", PageRequest{Limit: 10, Sort: "email", Order: OrderDesc}, false, false},
+		{"largest limit", PageRequest{Limit: MaxPageLimit}, "name", PageRequest{Limit: MaxPageLimit, Sort: "name", Order: OrderAsc}, false, false},
+		{"cursor", PageRequest{Sort: "name", Cursor: nameCursor(OrderAsc)}, "name", PageRequest{Limit: DefaultPageLimit, Sort: "name", Order: OrderAsc}, true, false},
+		{"limit too large", PageRequest{Limit: MaxPageLimit + 1}, "name", PageRequest{}, false, true},
+		{"negative limit", PageRequest{Limit: -1}, "name", PageRequest{}, false, true},
+		{"unknown sort", PageRequest{Sort: "phone"}, "name", PageRequest{}, false, true},
+		{"unknown order", PageRequest{Order: "up"}, "name", PageRequest{}, false, true},
+		{"malformed cursor", PageRequest{Cursor: "not a cursor"}, "name", PageRequest{}, false, true},
+		{"cursor of another order", PageRequest{Sort: "name", Order: OrderDesc, Cursor: nameCursor(OrderAsc)}, "name", PageRequest{}, false, true},
+		{"cursor of another sort", PageRequest{Sort: "email", Cursor: nameCursor(OrderAsc)}, "name", PageRequest{}, false, true},
+	}
+	for _, tt := range tests {
+		got, c, err := tt.page.normalize(customerSortFields, tt.defaultSort)
+		if tt.invalid {
+			if !errors.Is(err, ErrInvalidPage) {
+				t.Errorf("%s: err = %v, want ErrInvalidPage", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Limit != tt.want.Limit || got.Sort != tt.want.Sort || got.Order != tt.want.Order {
+			t.Errorf("%s: limit %d, sort %s, order %s; want %d, %s, %s", tt.name, got.Limit, got.Sort, got.Order,
+				tt.want.Limit, tt.want.Sort, tt.want.Order)
+		}
+		if (c != nil) != tt.cursor {
+			t.Errorf("%s: cursor = %v, want one: %v", tt.name, c, tt.cursor)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := "6f1c1b4e-3f0a-4a55-9c53-5b0e8f7a2d10"
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{`"Acme"`, "Acme"},
+		{`"2026-01-31 10:00:00"`, "2026-01-31 10:00:00"},
+		{`1250000`, int64(1250000)},
+		{`12.5`, 12.5},
+	}
+	for _, order := range []string{OrderAsc, OrderDesc} {
+		for _, tt := range tests {
+			encoded := encodeCursor(cursor{Sort: "total", Order: order, Value: json.RawMessage(tt.value), ID: id})
+			c, err := decodeCursor(encoded)
+			if err != nil {
+				t.Errorf("%s %s: %v", order, tt.value, err)
+				continue
+			}
+			if c.Sort != "total" || c.Order != order || c.ID != id {
+				t.Errorf("%s %s: decoded %+v", order, tt.value, c)
+			}
+			if got, err := c.arg(); err != nil || got != tt.want {
+				t.Errorf("%s %s: arg = %v (%T), %v; want %v (%T)", order, tt.value, got, got, err, tt.want, tt.want)
+			}
+		}
+	}
+
+	for _, bad := range []string{
+		"!!",
+		encodeCursor(cursor{Sort: "name", Order: OrderAsc, Value: json.RawMessage(`"Acme"`), ID: "not-a-uuid"}),
+		encodeCursor(cursor{Sort: "name", Order: OrderAsc, Value: json.RawMessage(`{"a": 1}`), ID: id}),
+	} {
+		if _, err := decodeCursor(bad); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("decodeCursor(%q): err = %v, want ErrInvalidPage", bad, err)
+		}
+	}
+}
+
+// TestPageTies pages through rows whose sort values are all equal, which
+// only the id tie-breaker tells apart, in both orders
+func TestPageTies(t *testing.T) {
+	s := newTestStore(t)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		c := testCustomer(t, s)
+		testInvoice(t, s, c.ID, money.FromInt(100), "issued")
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+
+	for _, order := range []string{OrderAsc, OrderDesc} {
+		var got []string
+		page := PageRequest{Limit: 2, Sort: "name", Order: order}
+		for pages := 0; ; pages++ {
+			if pages == 5 {
+				t.Fatalf("%s: still paging after %d pages", order, pages)
+			}
+			result, err := s.ListCustomers(AllCustomers, nil, page)
+			if err != nil {
+				t.Fatalf("%s: ListCustomers: %v", order, err)
+			}
+			if result.Total != len(ids) {
+				t.Errorf("%s: total = %d, want %d", order, result.Total, len(ids))
+			}
+			for _, c := range result.Items {
+				got = append(got, c.ID)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			page.Cursor = result.NextCursor
+		}
+
+		want := append([]string(nil), ids...)
+		if order == OrderDesc {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: paged through %v, want %v", order, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: paged through %v, want %v", order, got, want)
+				break
+			}
+		}
+	}
+
+	// Equal numeric sort values are told apart the same way
+	result, err := s.ListInvoices(nil, PageRequest{Limit: 4, Sort: "total"})
+	if err != nil {
+		t.Fatalf("ListInvoices: %v", err)
+	}
+	rest, err := s.ListInvoices(nil, PageRequest{Limit: 4, Sort: "total", Cursor: result.NextCursor})
+	if err != nil {
+		t.Fatalf("ListInvoices: %v", err)
+	}
+	if len(result.Items) != 4 || len(rest.Items) != 1 || rest.NextCursor != "" {
+		t.Errorf("invoices by total: pages of %d and %d, want 4 and 1", len(result.Items), len(rest.Items))
+	}
+}
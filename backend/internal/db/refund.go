@@ -0,0 +1,201 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+
+	"invoice-backend/internal/money"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Refund returns part or all of a payment to the customer. It lowers what was
+// paid on the payment's invoice, so a paid invoice may be owed again, or the
+// customer's credit for a receipt.
+type Refund struct {
+	ID              string       `json:"id"`
+	PaymentID       string       `json:"payment_id"`
+	InvoiceID       string       `json:"invoice_id,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	RefundMethod    string       `json:"refund_method"`
+	RefundDate      string       `json:"refund_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Reason          string       `json:"reason,omitempty"`
+	CreatedAt       string       `json:"created_at,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+}
+
+// RefundCreate is the struct for refunding a payment
+type RefundCreate struct {
+	PaymentID string       `json:"-"` // The refunded payment, from the URL
+	Amount    money.Amount `json:"amount"`
+	// RefundMethod is how the money was returned; empty returns it the way
+	// it was paid
+	RefundMethod    string `json:"refund_method,omitempty"`
+	RefundDate      string `json:"refund_date,omitempty"`
+	ReferenceNumber string `json:"reference_number,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	CreatedBy       string `json:"created_by,omitempty"`
+}
+
+// attachRefunds adds refunds to the payments they return money of
+func attachRefunds(payments []Payment, refunds []Refund) {
+	byID := make(map[string]*Payment, len(payments))
+	for i := range payments {
+		byID[payments[i].ID] = &payments[i]
+	}
+	for _, r := range refunds {
+		if p := byID[r.PaymentID]; p != nil {
+			p.Refunds = append(p.Refunds, r)
+		}
+	}
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+const refundColumns = `id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number, reason, created_at, created_by`
+
+func scanRefund(row rowScanner) (*Refund, error) {
+	var r Refund
+	err := row.Scan(&r.ID, &r.PaymentID, text(&r.InvoiceID), &r.Amount, &r.RefundMethod, text(&r.RefundDate),
+		text(&r.ReferenceNumber), text(&r.Reason), text(&r.CreatedAt), text(&r.CreatedBy))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (s *SQLStore) queryRefunds(query string, args ...interface{}) ([]Refund, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		r, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *r)
+	}
+	return refunds, rows.Err()
+}
+
+// RecordRefund inserts the refund, adds it to the payment's refunded amount
+// and recomputes the invoice's paid amount and status, and the customer's
+// credit, in one transaction under the invoice's lock
+func (s *SQLStore) RecordRefund(refund RefundCreate) (*Refund, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, inv, err := s.lockPayment(tx, refund.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.VoidedAt != "" {
+		return nil, ErrPaymentVoided
+	}
+
+	refund.Amount = refund.Amount.Round(payment.Currency)
+	if left := payment.Amount.Sub(payment.RefundedAmount); refund.Amount.Cmp(left) > 0 {
+		return nil, fmt.Errorf("%w: %s of the payment of %s is left to refund", ErrRefundExceedsPayment, left, payment.Amount)
+	}
+	refunded := *payment
+	refunded.RefundedAmount = refunded.RefundedAmount.Add(refund.Amount)
+	if err := keepAllocated(tx, &refunded); err != nil {
+		return nil, err
+	}
+	if refund.RefundMethod == "" {
+		refund.RefundMethod = payment.PaymentMethod
+	}
+
+	created, err := scanRefund(tx.QueryRow(`
+		INSERT INTO refunds (id, org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number,
+			reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, CURRENT_TIMESTAMP), $8, $9, $10)
+		RETURNING `+refundColumns,
+		uuid.NewString(), s.org, payment.ID, nullIfEmpty(payment.InvoiceID), refund.Amount, refund.RefundMethod,
+		nullIfEmpty(refund.RefundDate), nullIfEmpty(refund.ReferenceNumber), nullIfEmpty(refund.Reason), nullIfEmpty(refund.CreatedBy)))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE payments SET refunded_amount = refunded_amount + $2, version = version + 1 WHERE id = $1`, payment.ID, created.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update payment: %v", err)
+	}
+
+	if err := s.settlePayment(tx, payment, inv); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *SQLStore) GetRefundsByPayment(paymentID string) ([]Refund, error) {
+	return s.queryRefunds(`SELECT `+refundColumns+` FROM refunds WHERE payment_id = $1 AND org_id = $2 ORDER BY refund_date, created_at`,
+		paymentID, s.org)
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// RecordRefund records the refund through the record_refund database
+// function, which locks the invoice, inserts the refund and recomputes the
+// invoice's paid amount in one transaction (see migration 0017_refunds)
+func (c *SupabaseStore) RecordRefund(refund RefundCreate) (*Refund, error) {
+	args := map[string]interface{}{
+		"p_payment_id": refund.PaymentID,
+		"p_amount":     refund.Amount,
+	}
+	if refund.RefundMethod != "" {
+		args["p_refund_method"] = refund.RefundMethod
+	}
+	if refund.RefundDate != "" {
+		args["p_refund_date"] = refund.RefundDate
+	}
+	if refund.ReferenceNumber != "" {
+		args["p_reference_number"] = refund.ReferenceNumber
+	}
+	if refund.Reason != "" {
+		args["p_reason"] = refund.Reason
+	}
+	if refund.CreatedBy != "" {
+		args["p_created_by"] = refund.CreatedBy
+	}
+
+	var result Refund
+	if err := c.rpc("record_refund", args, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *SupabaseStore) GetRefundsByPayment(paymentID string) ([]Refund, error) {
+	refunds := []Refund{}
+	_, err := c.from("refunds").
+		Select("*", "", false).
+		Eq("payment_id", paymentID).
+		Order("refund_date", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&refunds)
+	return refunds, err
+}
+
+// sortRefunds puts the refunds embedded in payments in date order
+func sortRefunds(payments []Payment) {
+	for _, p := range payments {
+		sort.Slice(p.Refunds, func(i, j int) bool { return p.Refunds[i].RefundDate < p.Refunds[j].RefundDate })
+	}
+}
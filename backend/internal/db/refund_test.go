@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// refund returns amount of a payment
+func refund(s Store, paymentID string, amount money.Amount) error {
+	_, err := s.RecordRefund(RefundCreate{PaymentID: paymentID, Amount: amount, Reason: "Returned goods"})
+	return err
+}
+
+// TestRefundInvoicePayment checks that refunds are limited to what is left
+// of a payment and take back what they return from the invoice
+func TestRefundInvoicePayment(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	p := pay(t, s, inv.ID, money.FromInt(100))
+
+	if err := refund(s, p.ID, money.FromInt(30)); err != nil {
+		t.Fatalf("RecordRefund: %v", err)
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(70), lifecycle.PartiallyPaid)
+
+	if err := refund(s, p.ID, money.FromInt(80)); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("refunding more than is left: err = %v, want ErrRefundExceedsPayment", err)
+	}
+	if err := refund(s, p.ID, money.FromInt(70)); err != nil {
+		t.Fatalf("RecordRefund of the rest: %v", err)
+	}
+	checkPaid(t, s, inv.ID, 0, lifecycle.Issued)
+
+	refunds, err := s.GetRefundsByPayment(p.ID)
+	if err != nil {
+		t.Fatalf("GetRefundsByPayment: %v", err)
+	}
+	if len(refunds) != 2 {
+		t.Errorf("payment has %d refunds, want 2", len(refunds))
+	}
+
+	void := pay(t, s, inv.ID, money.FromInt(10))
+	if _, err := s.VoidPayment(void.ID, 0, "Bounced"); err != nil {
+		t.Fatalf("VoidPayment: %v", err)
+	}
+	if err := refund(s, void.ID, money.FromInt(10)); !errors.Is(err, ErrPaymentVoided) {
+		t.Errorf("refunding a void payment: err = %v, want ErrPaymentVoided", err)
+	}
+}
+
+// TestRefundReceipt checks that only what is left unallocated of a receipt
+// can be refunded, taking it from the customer's credit
+func TestRefundReceipt(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(60), lifecycle.Issued)
+	r := receipt(t, s, c.ID, money.FromInt(100))
+	if _, err := s.AllocatePayment(r.ID, []PaymentAllocation{{InvoiceID: inv.ID, Amount: money.FromInt(60)}}, ""); err != nil {
+		t.Fatalf("AllocatePayment: %v", err)
+	}
+	checkCredit(t, s, c.ID, money.FromInt(40))
+
+	if err := refund(s, r.ID, money.FromInt(50)); !errors.Is(err, ErrPaymentAllocated) {
+		t.Errorf("refunding what was allocated: err = %v, want ErrPaymentAllocated", err)
+	}
+	if err := refund(s, r.ID, money.FromInt(40)); err != nil {
+		t.Fatalf("RecordRefund of the unallocated rest: %v", err)
+	}
+	checkCredit(t, s, c.ID, 0)
+	checkPaid(t, s, inv.ID, money.FromInt(60), lifecycle.Paid)
+}
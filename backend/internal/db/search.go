@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SortRelevance orders invoice search results by how well they match. It is
+// only accepted (and is the default) when a search term is given.
+const SortRelevance = "relevance"
+
+// searchTokens splits a search term into lower-case words with accents
+// removed. Punctuation separates words, so "INV-2026" matches the invoice
+// number INV-202610-0001 by its "inv" and "2026..." parts.
+func searchTokens(term string) []string {
+	plain, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), term)
+	if err != nil {
+		plain = term
+	}
+	return strings.FieldsFunc(strings.ToLower(plain), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// tsquery builds a Postgres tsquery that matches documents containing every
+// token as a word prefix
+func tsquery(tokens []string) string {
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// invoiceSearch returns the condition matching invoices against the search
+// query in placeholder $n, the relevance expression for that query (higher
+// is better), and the query argument itself
+func (d dialect) invoiceSearch(tokens []string, n int) (match, rank string, arg string) {
+	if d == dialectSQLite {
+		terms := make([]string, len(tokens))
+		for i, t := range tokens {
+			terms[i] = `"` + t + `"*`
+		}
+		// bm25 weights follow the invoice_search columns: invoice_id,
+		// invoice_number, customer, items, notes. Lower bm25 is better.
+		match = fmt.Sprintf("id IN (SELECT invoice_id FROM invoice_search WHERE invoice_search MATCH $%d)", n)
+		rank = fmt.Sprintf("COALESCE((SELECT -bm25(invoice_search, 0, 8, 4, 2, 1) FROM invoice_search"+
+			" WHERE invoice_search MATCH $%d AND invoice_id = invoices.id), 0)", n)
+		return match, rank, strings.Join(terms, " ")
+	}
+	match = fmt.Sprintf("search_vector @@ to_tsquery('simple', $%d)", n)
+	rank = fmt.Sprintf("ts_rank(search_vector, to_tsquery('simple', $%d))", n)
+	return match, rank, tsquery(tokens)
+}
+
+// withRelevance returns the invoice sort fields plus relevance, ordered by
+// rank, and makes relevance (best first) the default ordering
+func withRelevance(page PageRequest, rank string) (sortFields, PageRequest) {
+	fields := sortFields{SortRelevance: rank}
+	for name, expr := range invoiceSortFields {
+		fields[name] = expr
+	}
+	if page.Sort == "" {
+		page.Sort = SortRelevance
+		if page.Order == "" {
+			page.Order = OrderDesc
+		}
+	}
+	return fields, page
+}
@@ -0,0 +1,2022 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/migrate"
+	"invoice-backend/internal/money"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore implements Store on top of database/sql, talking to Postgres (or
+// the embedded SQLite database) directly so that writes can run in real
+// transactions and the dashboard can be computed with SQL aggregates.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+	// actor is who the audit log attributes this store's changes to
+	actor string
+	// org is the organization whose records the store reads and writes
+	org string
+}
+
+// dialect captures the few places where Postgres and SQLite SQL differ. Both
+// accept $N placeholders, RETURNING and CURRENT_TIMESTAMP.
+type dialect int
+
+const (
+	dialectPostgres dialect = iota
+	dialectSQLite
+)
+
+// truncDate returns an expression that truncates column to the start of the
+// given period ("day", "week" or "month"), formatted as YYYY-MM-DD
+func (d dialect) truncDate(unit, column string) string {
+	if d == dialectSQLite {
+		switch unit {
+		case "week":
+			return fmt.Sprintf("date(%s, '-6 days', 'weekday 1')", column)
+		case "month":
+			return fmt.Sprintf("strftime('%%Y-%%m-01', %s)", column)
+		default:
+			return fmt.Sprintf("date(%s)", column)
+		}
+	}
+	return fmt.Sprintf("to_char(date_trunc('%s', %s), 'YYYY-MM-DD')", unit, column)
+}
+
+// sortValue returns the expression that selects a row's sort value for its
+// page cursor. SQLite would hand back columns declared as timestamps parsed
+// into times, which no longer compare equal to the text they are stored as;
+// the no-op unary plus makes it an expression and keeps the stored text.
+func (d dialect) sortValue(expr string) string {
+	if d == dialectSQLite {
+		return "+(" + expr + ")"
+	}
+	return expr
+}
+
+// forUpdate returns the row-locking clause for SELECTs inside a transaction.
+// SQLite has no row locks; its transactions already hold the database write
+// lock because they are opened with BEGIN IMMEDIATE.
+func (d dialect) forUpdate() string {
+	if d == dialectSQLite {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// NewPostgres opens a native Postgres connection pool for the given DSN
+func NewPostgres(dsn string) (*SQLStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("DATABASE_URL must be set")
+	}
+
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	return &SQLStore{db: conn, dialect: dialectPostgres, org: DefaultOrg}, nil
+}
+
+// NewSQLite opens (creating if needed) the embedded SQLite database at path,
+// so the API can run without any external database service. The schema is
+// created by the migrations (see Migrator).
+func NewSQLite(path string) (*SQLStore, error) {
+	if path == "" {
+		path = "invoice.db"
+	}
+
+	// BEGIN IMMEDIATE takes the write lock up front so concurrent
+	// transactions wait on busy_timeout instead of failing to upgrade.
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: conn, dialect: dialectSQLite, org: DefaultOrg}, nil
+}
+
+// Close releases the underlying connection pool
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Migrator returns the schema migrator for this database
+func (s *SQLStore) Migrator() (*migrate.Migrator, error) {
+	if s.dialect == dialectSQLite {
+		return migrate.New(s.db, migrate.SQLite)
+	}
+	return migrate.New(s.db, migrate.Postgres)
+}
+
+// migrateUp applies pending migrations and logs what it did
+func (s *SQLStore) migrateUp() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(0)
+	for _, mig := range applied {
+		log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
+	}
+	return nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// text scans a nullable column into a string, leaving it empty for NULL.
+// Timestamps are formatted as RFC 3339 by database/sql.
+func text(dst *string) sql.Scanner {
+	return &textScanner{dst: dst}
+}
+
+type textScanner struct {
+	dst *string
+}
+
+func (t *textScanner) Scan(src interface{}) error {
+	var ns sql.NullString
+	if err := ns.Scan(src); err != nil {
+		return err
+	}
+	*t.dst = ns.String
+	return nil
+}
+
+// nullIfEmpty maps empty strings to SQL NULL, for optional columns such as
+// dates
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// pageQuery builds the keyset-paginated SELECT for one page of table. where
+// is the list's filter (empty for none) with $N placeholders over args. It
+// also returns the number of rows matching the filter across all pages.
+func (s *SQLStore) pageQuery(table, columns string, fields sortFields, p PageRequest, c *cursor, where string, args []interface{}) (string, []interface{}, int, error) {
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	if cond, scoped := s.inOrg(table, args); cond != "" {
+		conds, args = append(conds, cond), scoped
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM `+table+whereClause(conds), args...).Scan(&total); err != nil {
+		return "", nil, 0, err
+	}
+
+	expr := fields[p.Sort]
+	op, dir := ">", "ASC"
+	if p.Order == OrderDesc {
+		op, dir = "<", "DESC"
+	}
+
+	if c != nil {
+		value, err := c.arg()
+		if err != nil {
+			return "", nil, 0, err
+		}
+		args = append(args, value, c.ID)
+		conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d, $%d)", expr, op, len(args)-1, len(args)))
+	}
+
+	args = append(args, p.Limit+1)
+	query := fmt.Sprintf("SELECT %s, %s FROM %s%s ORDER BY %s %s, id %s LIMIT $%d",
+		columns, s.dialect.sortValue(expr), table, whereClause(conds), expr, dir, dir, len(args))
+	return query, args, total, nil
+}
+
+// pageRows reads the result of a page query: Scan fills dest with the list's
+// columns and keeps the sort value pageQuery selects after them
+type pageRows struct {
+	*sql.Rows
+	value interface{}
+}
+
+func (r *pageRows) Scan(dest ...interface{}) error {
+	return r.Rows.Scan(append(dest, &r.value)...)
+}
+
+// queryPage runs a page query from pageQuery, reading each row with scan,
+// and returns the rows and their sort values for newPage
+func queryPage[T any](q queryer, query string, args []interface{}, scan func(rowScanner) (*T, error)) ([]T, []json.RawMessage, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	r := &pageRows{Rows: rows}
+	var items []T
+	var values []json.RawMessage
+	for rows.Next() {
+		item, err := scan(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b, ok := r.value.([]byte); ok {
+			r.value = string(b)
+		}
+		value, err := json.Marshal(r.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		items, values = append(items, *item), append(values, value)
+	}
+	return items, values, rows.Err()
+}
+
+// inOrg returns the condition limiting table to the store's organization,
+// with its placeholder numbered after args, and args extended with its
+// value; "" and args for a table whose rows belong to no organization
+func (s *SQLStore) inOrg(table string, args []interface{}) (string, []interface{}) {
+	if !tenantTables[table] {
+		return "", args
+	}
+	args = append(args, s.org)
+	return fmt.Sprintf("org_id = $%d", len(args)), args
+}
+
+// filterSQL renders an optional filter expression after args, returning the
+// condition ("" for none) and the combined arguments
+func filterSQL(where *filter.Expr, args []interface{}) (string, []interface{}) {
+	if where == nil {
+		return "", args
+	}
+	cond, filterArgs := where.SQL(len(args))
+	return cond, append(args, filterArgs...)
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// ============================================
+// CUSTOMERS
+// ============================================
+
+const customerColumns = `id, name, email, phone, address, city, postal_code, country, company_name, created_at, archived_at, price_list_id, version`
+
+func scanCustomer(row rowScanner) (*Customer, error) {
+	var c Customer
+	err := row.Scan(&c.ID, &c.Name, &c.Email, text(&c.Phone), text(&c.Address), text(&c.City),
+		text(&c.PostalCode), text(&c.Country), text(&c.CompanyName), text(&c.CreatedAt), text(&c.ArchivedAt),
+		text(&c.PriceListID), &c.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &c, nil
+}
+
+// customerScopeSQL is the condition selecting the customers in scope ("" for
+// all of them)
+func customerScopeSQL(scope CustomerScope) string {
+	switch scope {
+	case AllCustomers:
+		return ""
+	case ArchivedCustomers:
+		return "archived_at IS NOT NULL"
+	}
+	return "archived_at IS NULL"
+}
+
+func (s *SQLStore) ListCustomers(scope CustomerScope, where *filter.Expr, page PageRequest) (*Page[Customer], error) {
+	page, c, err := page.normalize(customerSortFields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	var conds []string
+	if cond := customerScopeSQL(scope); cond != "" {
+		conds = append(conds, cond)
+	}
+	cond, args := filterSQL(where, nil)
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	query, args, total, err := s.pageQuery("customers", customerColumns, customerSortFields, page, c, strings.Join(conds, " AND "), args)
+	if err != nil {
+		return nil, err
+	}
+	customers, values, err := queryPage(s.db, query, args, scanCustomer)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCredits(s.db, customers); err != nil {
+		return nil, err
+	}
+	return newPage(customers, values, total, page, func(c Customer) string { return c.ID }), nil
+}
+
+func (s *SQLStore) CreateCustomer(name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
+	return writeRow(s, scanCustomer, `
+		INSERT INTO customers (id, org_id, name, email, phone, address, city, postal_code, country, company_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+customerColumns,
+		uuid.NewString(), s.org, name, email, phone, address, city, postalCode, country, companyName)
+}
+
+func (s *SQLStore) GetCustomer(id string) (*Customer, error) {
+	c, err := scanCustomer(s.db.QueryRow(`SELECT `+customerColumns+` FROM customers WHERE id = $1 AND org_id = $2`, id, s.org))
+	return withCredits(s.db, c, err)
+}
+
+func (s *SQLStore) UpdateCustomer(id string, version int, name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
+	c, err := writeRow(s, scanCustomer, `
+		UPDATE customers
+		SET name = $3, email = $4, phone = $5, address = $6, city = $7, postal_code = $8,
+			country = $9, company_name = $10, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $11
+		RETURNING `+customerColumns,
+		id, version, name, email, phone, address, city, postalCode, country, companyName, s.org)
+	if err != nil {
+		return nil, s.versionError("customers", id, err)
+	}
+	return c, nil
+}
+
+// ArchiveCustomer archives a customer. Archiving an archived customer keeps
+// its original archive time.
+func (s *SQLStore) ArchiveCustomer(id string) (*Customer, error) {
+	return writeRow(s, scanCustomer, `
+		UPDATE customers
+		SET archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP), version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND org_id = $2
+		RETURNING `+customerColumns, id, s.org)
+}
+
+func (s *SQLStore) RestoreCustomer(id string) (*Customer, error) {
+	return writeRow(s, scanCustomer, `
+		UPDATE customers
+		SET archived_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND org_id = $2
+		RETURNING `+customerColumns, id, s.org)
+}
+
+func (s *SQLStore) SetCustomerPriceList(customerID, priceListID string) (*Customer, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if priceListID != "" {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM price_lists WHERE id = $1 AND org_id = $2`, priceListID, s.org).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPriceList, priceListID)
+		}
+	}
+
+	c, err := scanCustomer(tx.QueryRow(`
+		UPDATE customers
+		SET price_list_id = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND org_id = $3
+		RETURNING `+customerColumns, customerID, nullIfEmpty(priceListID), s.org))
+	if err != nil {
+		return nil, err
+	}
+	return c, s.commit(tx)
+}
+
+func (s *SQLStore) DeleteCustomer(id string, version int, force bool) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow(`SELECT version FROM customers WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(), id, s.org).Scan(&current)
+	if err != nil {
+		return notFound(err)
+	}
+	if version != 0 && version != current {
+		return ErrVersionConflict
+	}
+
+	var invoices int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM invoices WHERE customer_id = $1`, id).Scan(&invoices); err != nil {
+		return err
+	}
+	if invoices > 0 && !force {
+		return fmt.Errorf("%w (%d)", ErrCustomerHasInvoices, invoices)
+	}
+
+	// Payments and credit notes go with their invoices (ON DELETE CASCADE),
+	// receipts and credit with the customer
+	if _, err := tx.Exec(`DELETE FROM invoices WHERE customer_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM payments WHERE customer_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM customer_credits WHERE customer_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM customers WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return s.commit(tx)
+}
+
+// ============================================
+// INVOICES
+// ============================================
+
+const invoiceColumns = `id, company_id, customer_id, invoice_number, subtotal, tax, discount, total, pdf_url, status,
+	notes, due_date, currency, payment_status, paid_amount, credited_amount, payment_date, issued_at, sent_at, status_reason,
+	created_at, version`
+
+func scanInvoice(row rowScanner) (*Invoice, error) {
+	var inv Invoice
+	var tax sql.NullFloat64
+	err := row.Scan(&inv.ID, text(&inv.CompanyID), &inv.CustomerID, text(&inv.InvoiceNumber), &inv.Subtotal, &tax, &inv.Discount,
+		&inv.Total, text(&inv.PDFURL), text(&inv.Status), text(&inv.Notes),
+		text(&inv.DueDate), text(&inv.Currency), text(&inv.PaymentStatus), &inv.PaidAmount, &inv.CreditedAmount,
+		text(&inv.PaymentDate), text(&inv.IssuedAt), text(&inv.SentAt), text(&inv.StatusReason), text(&inv.CreatedAt), &inv.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	inv.Tax = tax.Float64
+	return &inv, nil
+}
+
+func (s *SQLStore) queryInvoices(query string, args ...interface{}) ([]Invoice, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, *inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadItems(s.db, invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+const itemColumns = `id, position, product_id, sku, description, quantity, unit, unit_price, price_rule, tax_rate, discount, total`
+
+func scanItem(row rowScanner, dest ...interface{}) (*Item, error) {
+	var item Item
+	var taxRate sql.NullFloat64
+	err := row.Scan(append(dest, &item.ID, &item.Position, text(&item.ProductID), text(&item.SKU), &item.Description, &item.Quantity,
+		text(&item.Unit), &item.UnitPrice, text(&item.PriceRule), &taxRate, &item.Discount, &item.Total)...)
+	if err != nil {
+		return nil, err
+	}
+	if taxRate.Valid {
+		item.TaxRate = &taxRate.Float64
+	}
+	return &item, nil
+}
+
+// loadItems reads the lines of invoices, in position order, with one query
+func loadItems(q queryer, invoices []Invoice) error {
+	if len(invoices) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Invoice, len(invoices))
+	placeholders := make([]string, len(invoices))
+	args := make([]interface{}, len(invoices))
+	for i := range invoices {
+		invoices[i].Items = []Item{}
+		byID[invoices[i].ID] = &invoices[i]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = invoices[i].ID
+	}
+
+	rows, err := q.Query(`
+		SELECT invoice_id, `+itemColumns+` FROM invoice_items
+		WHERE invoice_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY invoice_id, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var invoiceID string
+		item, err := scanItem(rows, &invoiceID)
+		if err != nil {
+			return err
+		}
+		if inv := byID[invoiceID]; inv != nil {
+			inv.Items = append(inv.Items, *item)
+		}
+	}
+	return rows.Err()
+}
+
+// withItems loads the lines of a single invoice read by scanInvoice
+func withItems(q queryer, inv *Invoice, err error) (*Invoice, error) {
+	if err != nil {
+		return nil, err
+	}
+	invoices := []Invoice{*inv}
+	if err := loadItems(q, invoices); err != nil {
+		return nil, err
+	}
+	return &invoices[0], nil
+}
+
+// CreateInvoice inserts the invoice and its lines in one transaction
+func (s *SQLStore) CreateInvoice(companyID, customerID string, subtotal money.Amount, tax float64, discount, total money.Amount, items []Item, status, notes, dueDate, currency string) (*Invoice, error) {
+	if currency == "" {
+		currency = "USD"
+	}
+	status, err := lifecycle.Initial(status)
+	if err != nil {
+		return nil, err
+	}
+	// An invoice issued straight away is settled like any other: with a zero
+	// total it is paid, and after its due date overdue
+	created := Invoice{Status: lifecycle.Draft, Total: total, DueDate: dueDate}
+	if status != lifecycle.Draft {
+		if err := transition(&created, status, ""); err != nil {
+			return nil, err
+		}
+	}
+	settle(&created)
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var customers int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM customers WHERE id = $1 AND org_id = $2`, customerID, s.org).Scan(&customers); err != nil {
+		return nil, err
+	}
+	if customers == 0 {
+		return nil, fmt.Errorf("customer %s: %w", customerID, ErrNotFound)
+	}
+
+	company, err := getCompany(tx, s.org, companyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read company profile: %w", err)
+	}
+
+	id := uuid.NewString()
+	invoiceNumber, err := nextDocumentNumber(tx, s.org, company.InvoiceSeries, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invoice number: %v", err)
+	}
+
+	inv, err := scanInvoice(tx.QueryRow(`
+		INSERT INTO invoices (id, org_id, company_id, customer_id, invoice_number, subtotal, tax, discount, total,
+			status, notes, due_date, currency, payment_status, paid_amount, issued_at, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, 0, $15, $16)
+		RETURNING `+invoiceColumns,
+		id, s.org, company.ID, customerID, invoiceNumber, subtotal, tax, discount, total,
+		created.Status, notes, nullIfEmpty(dueDate), currency, created.PaymentStatus,
+		nullIfEmpty(created.IssuedAt), nullIfEmpty(created.SentAt)))
+	if err != nil {
+		return nil, err
+	}
+
+	inv.Items = make([]Item, 0, len(items))
+	for _, item := range items {
+		var taxRate interface{}
+		if item.TaxRate != nil {
+			taxRate = *item.TaxRate
+		}
+		line, err := scanItem(tx.QueryRow(`
+			INSERT INTO invoice_items (id, invoice_id, position, product_id, sku, description, quantity, unit,
+				unit_price, price_rule, tax_rate, discount, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING `+itemColumns,
+			uuid.NewString(), id, item.Position, nullIfEmpty(item.ProductID), nullIfEmpty(item.SKU), item.Description, item.Quantity,
+			nullIfEmpty(item.Unit), item.UnitPrice, nullIfEmpty(item.PriceRule), taxRate, item.Discount, item.Total))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store item %d: %v", item.Position, err)
+		}
+		inv.Items = append(inv.Items, *line)
+	}
+
+	// An invoice created issued is paid from its customer's credit
+	applied, err := s.autoApplyCredit(tx, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	if applied {
+		return s.GetInvoice(id)
+	}
+	return inv, nil
+}
+
+func (s *SQLStore) ListInvoices(where *filter.Expr, page PageRequest) (*Page[Invoice], error) {
+	cond, args := filterSQL(where, nil)
+	return s.pageInvoices(page, invoiceSortFields, cond, args)
+}
+
+// pageInvoices reads one page of the invoices matching where
+func (s *SQLStore) pageInvoices(page PageRequest, fields sortFields, where string, args []interface{}) (*Page[Invoice], error) {
+	page, c, err := page.normalize(fields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	query, args, total, err := s.pageQuery("invoices", invoiceColumns, fields, page, c, where, args)
+	if err != nil {
+		return nil, err
+	}
+	invoices, values, err := queryPage(s.db, query, args, scanInvoice)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadItems(s.db, invoices); err != nil {
+		return nil, err
+	}
+	return newPage(invoices, values, total, page, func(inv Invoice) string { return inv.ID }), nil
+}
+
+func (s *SQLStore) GetInvoice(id string) (*Invoice, error) {
+	inv, err := scanInvoice(s.db.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 AND org_id = $2`, id, s.org))
+	return withItems(s.db, inv, err)
+}
+
+func (s *SQLStore) UpdateInvoice(id string, version int, status, notes, dueDate string) (*Invoice, error) {
+	return s.changeInvoice(id, version, func(inv *Invoice) error {
+		if status != "" && status != inv.Status {
+			if err := transition(inv, status, ""); err != nil {
+				return err
+			}
+		}
+		inv.Notes, inv.DueDate = notes, dueDate
+		return nil
+	})
+}
+
+// versionError returns ErrVersionConflict for a conditional write that matched
+// no row because the row exists at another version, and err otherwise
+func (s *SQLStore) versionError(table, id string, err error) error {
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	query, args := `SELECT COUNT(*) FROM `+table+` WHERE id = $1`, []interface{}{id}
+	if cond, scoped := s.inOrg(table, args); cond != "" {
+		query, args = query+" AND "+cond, scoped
+	}
+	var n int
+	if s.db.QueryRow(query, args...).Scan(&n) == nil && n > 0 {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// FilterInvoices filters invoices by status and creation date and, with a
+// search term, by full-text search over the invoice number, customer name,
+// company and email, item descriptions and notes. Search results are ranked
+// and sorted by relevance unless the page asks for another sort.
+func (s *SQLStore) FilterInvoices(status, searchTerm, startDate, endDate string, where *filter.Expr, page PageRequest) (*Page[Invoice], error) {
+	fields := invoiceSortFields
+	var conds []string
+	var args []interface{}
+
+	if status != "" && status != "all" {
+		args = append(args, status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if startDate != "" {
+		args = append(args, startDate)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if endDate != "" {
+		args = append(args, endDate)
+		conds = append(conds, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+	if tokens := searchTokens(searchTerm); len(tokens) > 0 {
+		match, rank, query := s.dialect.invoiceSearch(tokens, len(args)+1)
+		args = append(args, query)
+		conds = append(conds, match)
+		fields, page = withRelevance(page, rank)
+	}
+	if cond, filterArgs := filterSQL(where, args); cond != "" {
+		args = filterArgs
+		conds = append(conds, cond)
+	}
+
+	return s.pageInvoices(page, fields, strings.Join(conds, " AND "), args)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ============================================
+// CURRENCY RATES
+// ============================================
+
+const currencyRateColumns = `id, from_currency, to_currency, rate, updated_at`
+
+func scanCurrencyRate(row rowScanner) (*CurrencyRate, error) {
+	var r CurrencyRate
+	if err := row.Scan(&r.ID, &r.FromCurrency, &r.ToCurrency, &r.Rate, text(&r.UpdatedAt)); err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (s *SQLStore) GetCurrencyRate(fromCurrency, toCurrency string) (*CurrencyRate, error) {
+	return scanCurrencyRate(s.db.QueryRow(`
+		SELECT `+currencyRateColumns+` FROM currency_rates
+		WHERE from_currency = $1 AND to_currency = $2 AND org_id = $3`,
+		fromCurrency, toCurrency, s.org))
+}
+
+func (s *SQLStore) ListCurrencyRates() ([]CurrencyRate, error) {
+	rows, err := s.db.Query(`SELECT `+currencyRateColumns+` FROM currency_rates WHERE org_id = $1 ORDER BY from_currency, to_currency`, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []CurrencyRate
+	for rows.Next() {
+		r, err := scanCurrencyRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, *r)
+	}
+	return rates, rows.Err()
+}
+
+// ============================================
+// PAYMENTS
+// ============================================
+
+const paymentColumns = `id, invoice_id, customer_id, currency, receipt_number, amount, payment_method, payment_date, reference_number,
+	notes, created_at, created_by, refunded_amount, voided_at, void_reason, correction_reason, version`
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, text(&p.InvoiceID), text(&p.CustomerID), text(&p.Currency), text(&p.ReceiptNumber), &p.Amount,
+		&p.PaymentMethod, text(&p.PaymentDate), text(&p.ReferenceNumber), text(&p.Notes), text(&p.CreatedAt), text(&p.CreatedBy),
+		&p.RefundedAmount, text(&p.VoidedAt), text(&p.VoidReason), text(&p.CorrectionReason), &p.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *SQLStore) queryPayments(query string, args ...interface{}) ([]Payment, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+	return payments, rows.Err()
+}
+
+// RecordPayment inserts the payment and updates the invoice's paid amount and
+// payment status in a single transaction. The invoice row is locked first so
+// concurrent payments on the same invoice cannot overwrite each other, and a
+// repeated idempotency key returns the payment recorded the first time. A
+// payment without an invoice is recorded by recordReceipt.
+func (s *SQLStore) RecordPayment(payment PaymentCreate) (*Payment, error) {
+	if payment.InvoiceID == "" {
+		return s.recordReceipt(payment)
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(),
+		payment.InvoiceID, s.org))
+	if err != nil {
+		return nil, err
+	}
+	payment.Amount = payment.Amount.Round(inv.Currency)
+
+	if payment.IdempotencyKey != "" {
+		existing, err := findPaymentByIdempotencyKey(tx, s.org, payment.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if existing.InvoiceID != payment.InvoiceID || existing.Amount != payment.Amount {
+				return nil, ErrIdempotencyConflict
+			}
+			return existing, nil
+		}
+	}
+	if err := lifecycle.Payable(inv.Status); err != nil {
+		return nil, err
+	}
+
+	p, err := s.insertPayment(tx, payment, inv.CustomerID, inv.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.settlePayment(tx, p, inv); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// insertPayment numbers and inserts a payment of customerID in currency
+func (s *SQLStore) insertPayment(tx *sql.Tx, payment PaymentCreate, customerID, currency string) (*Payment, error) {
+	id := uuid.NewString()
+	receiptNumber, err := nextDocumentNumber(tx, s.org, SeriesReceipt, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate receipt number: %v", err)
+	}
+
+	p, err := scanPayment(tx.QueryRow(`
+		INSERT INTO payments (id, org_id, invoice_id, customer_id, currency, receipt_number, amount, payment_method,
+			payment_date, reference_number, notes, created_by, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, CURRENT_TIMESTAMP), $10, $11, $12, $13)
+		RETURNING `+paymentColumns,
+		id, s.org, nullIfEmpty(payment.InvoiceID), customerID, currency, receiptNumber, payment.Amount, payment.PaymentMethod,
+		nullIfEmpty(payment.PaymentDate), payment.ReferenceNumber, payment.Notes, payment.CreatedBy,
+		nullIfEmpty(payment.IdempotencyKey)))
+	if err != nil {
+		// The key is unique; a concurrent request for another payment won the race
+		if payment.IdempotencyKey != "" {
+			if existing, _ := findPaymentByIdempotencyKey(s.db, s.org, payment.IdempotencyKey); existing != nil {
+				return nil, ErrIdempotencyConflict
+			}
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// findPaymentByIdempotencyKey returns the payment the organization org
+// recorded with key, or nil
+func findPaymentByIdempotencyKey(q queryer, org, key string) (*Payment, error) {
+	p, err := scanPayment(q.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE org_id = $1 AND idempotency_key = $2`, org, key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// settlePayments recomputes what is paid on inv from its payment rows and
+// credit applications and writes it back with the status it settles to.
+// What is paid is the payments that are not void less what was refunded of
+// them and the credit applications that are not void, up to what was not
+// credited; the invoice's payment date is the latest of theirs.
+func settlePayments(tx *sql.Tx, inv *Invoice) error {
+	var paid money.Amount
+	var lastPaid string
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0), MAX(paid_at) FROM (
+			SELECT amount - refunded_amount AS amount, payment_date AS paid_at FROM payments
+			WHERE invoice_id = $1 AND voided_at IS NULL
+			UNION ALL
+			SELECT amount, applied_at FROM credit_applications
+			WHERE invoice_id = $1 AND voided_at IS NULL
+		) r`, inv.ID).Scan(&paid, text(&lastPaid))
+	if err != nil {
+		return err
+	}
+	if owed := inv.Total.Sub(inv.CreditedAmount); paid.Cmp(owed) > 0 {
+		paid = owed
+	}
+	inv.PaidAmount = paid
+	settle(inv)
+
+	_, err = tx.Exec(`
+		UPDATE invoices SET paid_amount = $2, status = $3, payment_status = $4, payment_date = $5, version = version + 1
+		WHERE id = $1`,
+		inv.ID, inv.PaidAmount, inv.Status, inv.PaymentStatus, nullIfEmpty(lastPaid))
+	if err != nil {
+		return fmt.Errorf("failed to update invoice: %v", err)
+	}
+	return nil
+}
+
+// settlePayment settles the invoice of p, if it has one, and its customer's
+// credit in its currency
+func (s *SQLStore) settlePayment(tx *sql.Tx, p *Payment, inv *Invoice) error {
+	if inv != nil {
+		if err := settlePayments(tx, inv); err != nil {
+			return err
+		}
+	}
+	return s.settleCredit(tx, p.CustomerID, p.Currency)
+}
+
+// lockPayment locks the invoice of a payment of the store's organization and
+// reads the payment under the lock, so payments, refunds and corrections on
+// an invoice are made one at a time. A receipt has no invoice; its customer
+// is locked instead, and the invoice returned is nil.
+func (s *SQLStore) lockPayment(tx *sql.Tx, id string) (*Payment, *Invoice, error) {
+	var invoiceID, customerID string
+	err := tx.QueryRow(`SELECT invoice_id, customer_id FROM payments WHERE id = $1 AND org_id = $2`, id, s.org).
+		Scan(text(&invoiceID), text(&customerID))
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+
+	var inv *Invoice
+	if invoiceID != "" {
+		inv, err = scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1`+s.dialect.forUpdate(), invoiceID))
+	} else {
+		err = s.lockCustomer(tx, customerID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := scanPayment(tx.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = $1`, id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, inv, nil
+}
+
+func (s *SQLStore) GetPayment(id string) (*Payment, error) {
+	p, err := scanPayment(s.db.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = $1 AND org_id = $2`, id, s.org))
+	if err != nil {
+		return nil, err
+	}
+	if p.Refunds, err = s.GetRefundsByPayment(id); err != nil {
+		return nil, err
+	}
+	if err := withAllocations(s.db, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CorrectPayment replaces the details of a payment at version and settles
+// its invoice, in one transaction under the invoice's lock
+func (s *SQLStore) CorrectPayment(id string, version int, correction PaymentCorrection) (*Payment, error) {
+	return s.changePayment(id, version, func(p *Payment) error {
+		amount := correction.Amount.Round(p.Currency)
+		if amount.Cmp(p.RefundedAmount) < 0 {
+			return fmt.Errorf("%w: %s of the payment was refunded; correct it to at least that", ErrRefundExceedsPayment, p.RefundedAmount)
+		}
+		p.Amount = amount
+		p.PaymentMethod = correction.PaymentMethod
+		if correction.PaymentDate != "" {
+			p.PaymentDate = correction.PaymentDate
+		}
+		p.ReferenceNumber = correction.ReferenceNumber
+		p.Notes = correction.Notes
+		p.CorrectionReason = correction.Reason
+		return nil
+	})
+}
+
+// VoidPayment voids a payment at version and settles its invoice, in one
+// transaction under the invoice's lock. What was refunded of the payment no
+// longer counts either.
+func (s *SQLStore) VoidPayment(id string, version int, reason string) (*Payment, error) {
+	return s.changePayment(id, version, func(p *Payment) error {
+		p.VoidedAt = time.Now().UTC().Format(time.RFC3339)
+		p.VoidReason = reason
+		return nil
+	})
+}
+
+// changePayment locks a payment at version, lets change edit it, writes it
+// back and settles its invoice and its customer's credit, in one transaction
+func (s *SQLStore) changePayment(id string, version int, change func(p *Payment) error) (*Payment, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, inv, err := s.lockPayment(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && p.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if p.VoidedAt != "" {
+		return nil, ErrPaymentVoided
+	}
+	paidOn := p.PaymentDate
+	if err := change(p); err != nil {
+		return nil, err
+	}
+	if err := keepAllocated(tx, p); err != nil {
+		return nil, err
+	}
+	// An unchanged date is left as stored
+	var date interface{}
+	if p.PaymentDate != paidOn {
+		date = p.PaymentDate
+	}
+
+	updated, err := scanPayment(tx.QueryRow(`
+		UPDATE payments SET amount = $2, payment_method = $3, payment_date = COALESCE($4, payment_date),
+			reference_number = $5, notes = $6, voided_at = $7, void_reason = $8, correction_reason = $9,
+			version = version + 1
+		WHERE id = $1
+		RETURNING `+paymentColumns,
+		p.ID, p.Amount, p.PaymentMethod, date, nullIfEmpty(p.ReferenceNumber), nullIfEmpty(p.Notes),
+		nullIfEmpty(p.VoidedAt), nullIfEmpty(p.VoidReason), nullIfEmpty(p.CorrectionReason)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.settlePayment(tx, updated, inv); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	if updated.Refunds, err = s.GetRefundsByPayment(id); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *SQLStore) GetPaymentsByInvoice(invoiceID string) ([]Payment, error) {
+	payments, err := s.queryPayments(`SELECT `+paymentColumns+` FROM payments WHERE invoice_id = $1 AND org_id = $2 ORDER BY payment_date`, invoiceID, s.org)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := s.queryRefunds(`SELECT `+refundColumns+` FROM refunds WHERE invoice_id = $1 AND org_id = $2 ORDER BY refund_date, created_at`,
+		invoiceID, s.org)
+	if err != nil {
+		return nil, err
+	}
+	attachRefunds(payments, refunds)
+	return payments, nil
+}
+
+func (s *SQLStore) GetAllPayments(where *filter.Expr, page PageRequest) (*Page[Payment], error) {
+	page, c, err := page.normalize(paymentSortFields, "payment_date")
+	if err != nil {
+		return nil, err
+	}
+	cond, args := filterSQL(where, nil)
+	query, args, total, err := s.pageQuery("payments", paymentColumns, paymentSortFields, page, c, cond, args)
+	if err != nil {
+		return nil, err
+	}
+	payments, values, err := queryPage(s.db, query, args, scanPayment)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(payments, values, total, page, func(p Payment) string { return p.ID }), nil
+}
+
+// ============================================
+// PRODUCT CATALOG
+// ============================================
+
+const productColumns = `id, sku, name, description, unit, tax_rate, active, created_at, version`
+
+func scanProduct(row rowScanner) (*Product, error) {
+	var p Product
+	var taxRate sql.NullFloat64
+	err := row.Scan(&p.ID, &p.SKU, &p.Name, text(&p.Description), text(&p.Unit), &taxRate, &p.Active,
+		text(&p.CreatedAt), &p.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if taxRate.Valid {
+		p.TaxRate = &taxRate.Float64
+	}
+	return &p, nil
+}
+
+func (s *SQLStore) queryProducts(query string, args ...interface{}) ([]Product, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadPrices(s.db, products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// loadPrices reads the prices of products, by currency, with one query
+func loadPrices(q queryer, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Product, len(products))
+	placeholders := make([]string, len(products))
+	args := make([]interface{}, len(products))
+	for i := range products {
+		products[i].Prices = []ProductPrice{}
+		byID[products[i].ID] = &products[i]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = products[i].ID
+	}
+
+	rows, err := q.Query(`
+		SELECT product_id, currency, unit_price FROM product_prices
+		WHERE product_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY product_id, currency`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID string
+		var price ProductPrice
+		if err := rows.Scan(&productID, &price.Currency, &price.UnitPrice); err != nil {
+			return err
+		}
+		if p := byID[productID]; p != nil {
+			p.Prices = append(p.Prices, price)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) ListProducts(active *bool, where *filter.Expr, page PageRequest) (*Page[Product], error) {
+	page, c, err := page.normalize(productSortFields, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var conds []string
+	var args []interface{}
+	if active != nil {
+		args = append(args, *active)
+		conds = append(conds, "active = $1")
+	}
+	cond, args := filterSQL(where, args)
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	query, args, total, err := s.pageQuery("products", productColumns, productSortFields, page, c, strings.Join(conds, " AND "), args)
+	if err != nil {
+		return nil, err
+	}
+	products, values, err := queryPage(s.db, query, args, scanProduct)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadPrices(s.db, products); err != nil {
+		return nil, err
+	}
+	return newPage(products, values, total, page, func(p Product) string { return p.ID }), nil
+}
+
+func (s *SQLStore) GetProduct(id string) (*Product, error) {
+	products, err := s.queryProducts(`SELECT `+productColumns+` FROM products WHERE id = $1 AND org_id = $2`, id, s.org)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrNotFound
+	}
+	return &products[0], nil
+}
+
+func (s *SQLStore) CreateProduct(product Product) (*Product, error) {
+	return s.saveProduct("", 0, product)
+}
+
+func (s *SQLStore) UpdateProduct(id string, version int, product Product) (*Product, error) {
+	return s.saveProduct(id, version, product)
+}
+
+// saveProduct inserts (id "") or updates a product and brings its prices in
+// line with product.Prices in one transaction. Only prices that change are
+// written, so the audit log records just those.
+func (s *SQLStore) saveProduct(id string, version int, product Product) (*Product, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM products WHERE sku = $1 AND CAST(id AS TEXT) <> $2 AND org_id = $3`, product.SKU, id, s.org).Scan(&taken); err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSKUTaken, product.SKU)
+	}
+
+	var taxRate interface{}
+	if product.TaxRate != nil {
+		taxRate = *product.TaxRate
+	}
+
+	var saved *Product
+	if id == "" {
+		saved, err = scanProduct(tx.QueryRow(`
+			INSERT INTO products (id, org_id, sku, name, description, unit, tax_rate, active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING `+productColumns,
+			uuid.NewString(), s.org, product.SKU, product.Name, nullIfEmpty(product.Description),
+			nullIfEmpty(product.Unit), taxRate, product.Active))
+	} else {
+		saved, err = scanProduct(tx.QueryRow(`
+			UPDATE products
+			SET sku = $3, name = $4, description = $5, unit = $6, tax_rate = $7, active = $8,
+				version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $9
+			RETURNING `+productColumns,
+			id, version, product.SKU, product.Name, nullIfEmpty(product.Description),
+			nullIfEmpty(product.Unit), taxRate, product.Active, s.org))
+		if err != nil {
+			return nil, s.versionError("products", id, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{saved.ID}
+	placeholders := make([]string, 0, len(product.Prices))
+	for _, price := range product.Prices {
+		_, err := tx.Exec(`
+			INSERT INTO product_prices (product_id, currency, unit_price)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (product_id, currency) DO UPDATE SET unit_price = excluded.unit_price
+			WHERE product_prices.unit_price <> excluded.unit_price`,
+			saved.ID, price.Currency, price.UnitPrice)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store %s price: %v", price.Currency, err)
+		}
+		args = append(args, price.Currency)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	stale := `DELETE FROM product_prices WHERE product_id = $1`
+	if len(placeholders) > 0 {
+		stale += ` AND currency NOT IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	if _, err := tx.Exec(stale, args...); err != nil {
+		return nil, err
+	}
+
+	products := []Product{*saved}
+	if err := loadPrices(tx, products); err != nil {
+		return nil, err
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+func (s *SQLStore) DeleteProduct(id string, version int) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM products WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $3`, id, version, s.org)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return s.versionError("products", id, ErrNotFound)
+	}
+	return s.commit(tx)
+}
+
+// ============================================
+// PRICE LISTS
+// ============================================
+
+const priceListColumns = `id, name, description, currency, created_at, version`
+
+func scanPriceList(row rowScanner) (*PriceList, error) {
+	var l PriceList
+	err := row.Scan(&l.ID, &l.Name, text(&l.Description), &l.Currency, text(&l.CreatedAt), &l.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &l, nil
+}
+
+func (s *SQLStore) queryPriceLists(query string, args ...interface{}) ([]PriceList, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []PriceList
+	for rows.Next() {
+		l, err := scanPriceList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, *l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadRules(s.db, lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// loadRules reads the rules of price lists, by product and minimum
+// quantity, with one query
+func loadRules(q queryer, lists []PriceList) error {
+	if len(lists) == 0 {
+		return nil
+	}
+	byID := make(map[string]*PriceList, len(lists))
+	placeholders := make([]string, len(lists))
+	args := make([]interface{}, len(lists))
+	for i := range lists {
+		lists[i].Rules = []PriceRule{}
+		byID[lists[i].ID] = &lists[i]
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = lists[i].ID
+	}
+
+	rows, err := q.Query(`
+		SELECT price_list_id, product_id, min_quantity, unit_price, discount_percent FROM price_list_rules
+		WHERE price_list_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY price_list_id, product_id, min_quantity`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var listID string
+		var rule PriceRule
+		var unitPrice interface{}
+		var discount sql.NullFloat64
+		if err := rows.Scan(&listID, &rule.ProductID, &rule.MinQuantity, &unitPrice, &discount); err != nil {
+			return err
+		}
+		if unitPrice != nil {
+			var price money.Amount
+			if err := price.Scan(unitPrice); err != nil {
+				return err
+			}
+			rule.UnitPrice = &price
+		}
+		if discount.Valid {
+			rule.DiscountPercent = &discount.Float64
+		}
+		if l := byID[listID]; l != nil {
+			l.Rules = append(l.Rules, rule)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) ListPriceLists() ([]PriceList, error) {
+	return s.queryPriceLists(`SELECT `+priceListColumns+` FROM price_lists WHERE org_id = $1 ORDER BY name, id`, s.org)
+}
+
+func (s *SQLStore) GetPriceList(id string) (*PriceList, error) {
+	lists, err := s.queryPriceLists(`SELECT `+priceListColumns+` FROM price_lists WHERE id = $1 AND org_id = $2`, id, s.org)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, ErrNotFound
+	}
+	return &lists[0], nil
+}
+
+func (s *SQLStore) CreatePriceList(list PriceList) (*PriceList, error) {
+	return s.savePriceList("", 0, list)
+}
+
+func (s *SQLStore) UpdatePriceList(id string, version int, list PriceList) (*PriceList, error) {
+	return s.savePriceList(id, version, list)
+}
+
+// savePriceList inserts (id "") or updates a price list and brings its
+// rules in line with list.Rules in one transaction. Only rules that change
+// are written, so the audit log records just those.
+func (s *SQLStore) savePriceList(id string, version int, list PriceList) (*PriceList, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, rule := range list.Rules {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM products WHERE id = $1 AND org_id = $2`, rule.ProductID, s.org).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProduct, rule.ProductID)
+		}
+	}
+
+	var saved *PriceList
+	if id == "" {
+		saved, err = scanPriceList(tx.QueryRow(`
+			INSERT INTO price_lists (id, org_id, name, description, currency)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+priceListColumns,
+			uuid.NewString(), s.org, list.Name, nullIfEmpty(list.Description), list.Currency))
+	} else {
+		saved, err = scanPriceList(tx.QueryRow(`
+			UPDATE price_lists
+			SET name = $3, description = $4, currency = $5, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $6
+			RETURNING `+priceListColumns,
+			id, version, list.Name, nullIfEmpty(list.Description), list.Currency, s.org))
+		if err != nil {
+			return nil, s.versionError("price_lists", id, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{saved.ID}
+	keys := make([]string, 0, len(list.Rules))
+	for _, rule := range list.Rules {
+		var unitPrice, discount interface{}
+		if rule.UnitPrice != nil {
+			unitPrice = *rule.UnitPrice
+		}
+		if rule.DiscountPercent != nil {
+			discount = *rule.DiscountPercent
+		}
+		_, err := tx.Exec(`
+			INSERT INTO price_list_rules (price_list_id, product_id, min_quantity, unit_price, discount_percent)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (price_list_id, product_id, min_quantity) DO UPDATE
+			SET unit_price = excluded.unit_price, discount_percent = excluded.discount_percent
+			WHERE price_list_rules.unit_price IS DISTINCT FROM excluded.unit_price
+				OR price_list_rules.discount_percent IS DISTINCT FROM excluded.discount_percent`,
+			saved.ID, rule.ProductID, rule.MinQuantity, unitPrice, discount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store rule for product %s: %v", rule.ProductID, err)
+		}
+		args = append(args, rule.ProductID, rule.MinQuantity)
+		keys = append(keys, fmt.Sprintf("(product_id = $%d AND min_quantity = $%d)", len(args)-1, len(args)))
+	}
+	stale := `DELETE FROM price_list_rules WHERE price_list_id = $1`
+	if len(keys) > 0 {
+		stale += ` AND NOT (` + strings.Join(keys, " OR ") + `)`
+	}
+	if _, err := tx.Exec(stale, args...); err != nil {
+		return nil, err
+	}
+
+	lists := []PriceList{*saved}
+	if err := loadRules(tx, lists); err != nil {
+		return nil, err
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return &lists[0], nil
+}
+
+func (s *SQLStore) DeletePriceList(id string, version int) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Unassign the price list first, so customers are versioned and audited
+	// like any other change
+	_, err = tx.Exec(`
+		UPDATE customers SET price_list_id = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE price_list_id = $1 AND org_id = $3
+			AND EXISTS (SELECT 1 FROM price_lists WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $3)`,
+		id, version, s.org)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM price_lists WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $3`, id, version, s.org)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return s.versionError("price_lists", id, ErrNotFound)
+	}
+	return s.commit(tx)
+}
+
+// ============================================
+// COMPANY PROFILES
+// ============================================
+
+const companyColumns = `id, name, legal_name, tax_id, address, city, postal_code, country, email, phone, website,
+	bank_name, bank_account_name, bank_account_number, bank_swift, logo, invoice_series, is_default, created_at, version`
+
+func scanCompany(row rowScanner) (*CompanyProfile, error) {
+	var p CompanyProfile
+	err := row.Scan(&p.ID, &p.Name, text(&p.LegalName), text(&p.TaxID), text(&p.Address), text(&p.City),
+		text(&p.PostalCode), text(&p.Country), text(&p.Email), text(&p.Phone), text(&p.Website),
+		text(&p.BankName), text(&p.BankAccountName), text(&p.BankAccountNumber), text(&p.BankSwift),
+		text(&p.Logo), &p.InvoiceSeries, &p.IsDefault, text(&p.CreatedAt), &p.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *SQLStore) ListCompanyProfiles() ([]CompanyProfile, error) {
+	rows, err := s.db.Query(`SELECT `+companyColumns+` FROM company_info WHERE org_id = $1 ORDER BY is_default DESC, name, id`, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []CompanyProfile{}
+	for rows.Next() {
+		p, err := scanCompany(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+	return profiles, rows.Err()
+}
+
+func (s *SQLStore) GetCompanyProfile(id string) (*CompanyProfile, error) {
+	return getCompany(s.db, s.org, id)
+}
+
+// getCompany reads a company profile of org, or its default profile for an
+// empty id
+func getCompany(q queryer, org, id string) (*CompanyProfile, error) {
+	if id == "" {
+		return scanCompany(q.QueryRow(`SELECT `+companyColumns+` FROM company_info WHERE is_default AND org_id = $1`, org))
+	}
+	return scanCompany(q.QueryRow(`SELECT `+companyColumns+` FROM company_info WHERE id = $1 AND org_id = $2`, id, org))
+}
+
+func (s *SQLStore) CreateCompanyProfile(profile CompanyProfile, invoicePrefix string) (*CompanyProfile, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM number_series
+		WHERE (series_key = $1 OR series_key LIKE $2) AND UPPER(prefix) = UPPER($3) AND org_id = $4`,
+		SeriesInvoice, SeriesInvoice+":%", invoicePrefix, s.org).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPrefixTaken, invoicePrefix)
+	}
+
+	id := uuid.NewString()
+	series := CompanyInvoiceSeries(id)
+	_, err = tx.Exec(`
+		INSERT INTO number_series (org_id, series_key, prefix, pattern, reset, padding)
+		VALUES ($1, $2, $3, '{PREFIX}-{YEAR}-{NUMBER}', $4, 4)`,
+		s.org, series, invoicePrefix, ResetYearly)
+	if err != nil {
+		return nil, err
+	}
+	if profile.IsDefault {
+		if err := clearDefaultCompany(tx, s.org, id); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := scanCompany(tx.QueryRow(`
+		INSERT INTO company_info (id, name, legal_name, tax_id, address, city, postal_code, country, email, phone,
+			website, bank_name, bank_account_name, bank_account_number, bank_swift, logo, invoice_series, is_default,
+			org_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		RETURNING `+companyColumns,
+		append([]interface{}{id}, append(companyValues(profile), series, profile.IsDefault, s.org)...)...))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (s *SQLStore) UpdateCompanyProfile(id string, version int, profile CompanyProfile) (*CompanyProfile, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if profile.IsDefault {
+		if err := clearDefaultCompany(tx, s.org, id); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := scanCompany(tx.QueryRow(`
+		UPDATE company_info
+		SET name = $3, legal_name = $4, tax_id = $5, address = $6, city = $7, postal_code = $8, country = $9,
+			email = $10, phone = $11, website = $12, bank_name = $13, bank_account_name = $14,
+			bank_account_number = $15, bank_swift = $16, logo = $17, is_default = (is_default OR $18),
+			version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $19
+		RETURNING `+companyColumns,
+		append([]interface{}{id, version}, append(companyValues(profile), profile.IsDefault, s.org)...)...))
+	if err != nil {
+		return nil, s.versionError("company_info", id, err)
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// companyValues returns the written fields of a company profile in column
+// order, from name to logo
+func companyValues(p CompanyProfile) []interface{} {
+	return []interface{}{p.Name, nullIfEmpty(p.LegalName), nullIfEmpty(p.TaxID), nullIfEmpty(p.Address),
+		nullIfEmpty(p.City), nullIfEmpty(p.PostalCode), nullIfEmpty(p.Country), nullIfEmpty(p.Email),
+		nullIfEmpty(p.Phone), nullIfEmpty(p.Website), nullIfEmpty(p.BankName), nullIfEmpty(p.BankAccountName),
+		nullIfEmpty(p.BankAccountNumber), nullIfEmpty(p.BankSwift), nullIfEmpty(p.Logo)}
+}
+
+// clearDefaultCompany takes the default from org's current default profile
+// before the profile id becomes the default
+func clearDefaultCompany(tx *sql.Tx, org, id string) error {
+	_, err := tx.Exec(`
+		UPDATE company_info SET is_default = FALSE, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE is_default AND id <> $1 AND org_id = $2`, id, org)
+	return err
+}
+
+// DeleteCompanyProfile deletes a profile that never issued an invoice. Its
+// numbering series is kept.
+func (s *SQLStore) DeleteCompanyProfile(id string, version int) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	profile, err := getCompany(tx, s.org, id)
+	if err != nil {
+		return err
+	}
+	if profile.IsDefault {
+		return ErrDefaultCompany
+	}
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM invoices WHERE company_id = $1 AND org_id = $2`, id, s.org).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrCompanyHasInvoices
+	}
+
+	res, err := tx.Exec(`DELETE FROM company_info WHERE id = $1 AND ($2 = 0 OR version = $2) AND org_id = $3`, id, version, s.org)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return s.versionError("company_info", id, ErrNotFound)
+	}
+	return s.commit(tx)
+}
+
+// ============================================
+// DOCUMENT NUMBERING
+// ============================================
+
+const numberSeriesColumns = `series_key, prefix, pattern, reset, padding, updated_at`
+
+func scanNumberSeries(row rowScanner) (*NumberSeries, error) {
+	var ns NumberSeries
+	err := row.Scan(&ns.Key, &ns.Prefix, &ns.Pattern, &ns.Reset, &ns.Padding, text(&ns.UpdatedAt))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &ns, nil
+}
+
+// nextDocumentNumber allocates the next number of org's series key for
+// documentID. It must be called inside the transaction that inserts the document: the
+// counter upsert holds the counter row until commit, so concurrent callers
+// are serialized, and a rollback releases the number instead of skipping it.
+func nextDocumentNumber(tx *sql.Tx, org, key, documentID string) (string, error) {
+	series, err := scanNumberSeries(tx.QueryRow(`SELECT `+numberSeriesColumns+` FROM number_series WHERE series_key = $1 AND org_id = $2`, key, org))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	period := series.Period(now)
+
+	var seq int
+	err = tx.QueryRow(`
+		INSERT INTO number_series_counters (org_id, series_key, period, last_value)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (org_id, series_key, period)
+		DO UPDATE SET last_value = number_series_counters.last_value + 1
+		RETURNING last_value`, org, key, period).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+
+	number := series.Format(seq, now)
+	_, err = tx.Exec(`
+		INSERT INTO document_numbers (org_id, series_key, period, sequence, number, document_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		org, key, period, seq, number, documentID)
+	if err != nil {
+		return "", err
+	}
+	return number, nil
+}
+
+func (s *SQLStore) ListNumberSeries() ([]NumberSeries, error) {
+	rows, err := s.db.Query(`SELECT `+numberSeriesColumns+` FROM number_series WHERE org_id = $1 ORDER BY series_key`, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []NumberSeries
+	for rows.Next() {
+		ns, err := scanNumberSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, *ns)
+	}
+	return series, rows.Err()
+}
+
+// UpdateNumberSeries changes the format of a series. Existing counters are
+// kept, so numbering continues where it left off.
+func (s *SQLStore) UpdateNumberSeries(series NumberSeries) (*NumberSeries, error) {
+	return writeRow(s, scanNumberSeries, `
+		UPDATE number_series
+		SET prefix = $2, pattern = $3, reset = $4, padding = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE series_key = $1 AND org_id = $6
+		RETURNING `+numberSeriesColumns,
+		series.Key, series.Prefix, series.Pattern, series.Reset, series.Padding, s.org)
+}
+
+func (s *SQLStore) AuditNumberSeries(key string) (*NumberingAudit, error) {
+	if _, err := scanNumberSeries(s.db.QueryRow(`SELECT `+numberSeriesColumns+` FROM number_series WHERE series_key = $1 AND org_id = $2`, key, s.org)); err != nil {
+		return nil, err
+	}
+
+	issued := make(map[string][]int)
+	rows, err := s.db.Query(`SELECT period, sequence FROM document_numbers WHERE series_key = $1 AND org_id = $2 ORDER BY period, sequence`, key, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var period string
+		var seq int
+		if err := rows.Scan(&period, &seq); err != nil {
+			return nil, err
+		}
+		issued[period] = append(issued[period], seq)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counters, err := s.db.Query(`SELECT period, last_value FROM number_series_counters WHERE series_key = $1 AND org_id = $2 ORDER BY period`, key, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer counters.Close()
+
+	var periods []NumberingPeriod
+	for counters.Next() {
+		var period string
+		var lastValue int
+		if err := counters.Scan(&period, &lastValue); err != nil {
+			return nil, err
+		}
+		periods = append(periods, auditPeriod(period, lastValue, issued[period]))
+	}
+	if err := counters.Err(); err != nil {
+		return nil, err
+	}
+	return newNumberingAudit(key, periods), nil
+}
+
+// ============================================
+// DASHBOARD & ANALYTICS
+// ============================================
+
+func (s *SQLStore) GetDashboardStats(currency string) (*DashboardStats, error) {
+	query := `
+		SELECT status, COUNT(*), COALESCE(SUM(total), 0), COALESCE(SUM(paid_amount), 0), COALESCE(SUM(credited_amount), 0)
+		FROM invoices
+		WHERE org_id = $1`
+	args := []interface{}{s.org}
+	if currency != "" && currency != "ALL" {
+		query += ` AND currency = $2`
+		args = append(args, currency)
+	}
+	query += ` GROUP BY status`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &DashboardStats{Currency: currency}
+	for rows.Next() {
+		var status string
+		var count int
+		var total, paid, credited money.Amount
+		if err := rows.Scan(&status, &count, &total, &paid, &credited); err != nil {
+			return nil, err
+		}
+		stats.count(status, count, total, paid, credited)
+	}
+	return stats, rows.Err()
+}
+
+// GetRevenueByPeriod aggregates invoice totals less their credit notes per
+// day, week or month and currency, newest period first
+func (s *SQLStore) GetRevenueByPeriod(period string, limit int) ([]RevenueByPeriod, error) {
+	unit := "day"
+	switch period {
+	case "weekly":
+		unit = "week"
+	case "monthly":
+		unit = "month"
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+s.dialect.truncDate(unit, "created_at")+` AS period, currency,
+			COALESCE(SUM(total - credited_amount), 0), COUNT(*)
+		FROM invoices
+		WHERE org_id = $2 AND status NOT IN ($3, $4)
+		GROUP BY period, currency
+		ORDER BY period DESC
+		LIMIT $1`, limit, s.org, lifecycle.Draft, lifecycle.Void)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []RevenueByPeriod
+	for rows.Next() {
+		var r RevenueByPeriod
+		if err := rows.Scan(&r.Date, text(&r.Currency), &r.Revenue, &r.InvoiceCount); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// GetTopCustomers returns the customers with the highest invoiced total, less
+// credit notes
+func (s *SQLStore) GetTopCustomers(limit int) ([]TopCustomer, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id, c.name, i.currency, COALESCE(SUM(i.total - i.credited_amount), 0) AS revenue, COUNT(i.id)
+		FROM invoices i
+		JOIN customers c ON c.id = i.customer_id
+		WHERE i.org_id = $2 AND i.status NOT IN ($3, $4)
+		GROUP BY c.id, c.name, i.currency
+		ORDER BY revenue DESC
+		LIMIT $1`, limit, s.org, lifecycle.Draft, lifecycle.Void)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TopCustomer
+	for rows.Next() {
+		var t TopCustomer
+		if err := rows.Scan(&t.CustomerID, &t.CustomerName, text(&t.Currency), &t.TotalRevenue, &t.InvoiceCount); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLStore) GetOverdueInvoices() ([]Invoice, error) {
+	return s.queryInvoices(`SELECT `+invoiceColumns+` FROM invoices WHERE status = $1 AND org_id = $2 ORDER BY due_date`, lifecycle.Overdue, s.org)
+}
+
+// GetTopItems returns the items that brought in the most, from the
+// item_sales view
+func (s *SQLStore) GetTopItems(limit int) ([]TopItem, error) {
+	rows, err := s.db.Query(`
+		SELECT sku, description, unit, currency, quantity, revenue, invoice_count
+		FROM item_sales
+		WHERE org_id = $2
+		ORDER BY revenue DESC
+		LIMIT $1`, limit, s.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TopItem
+	for rows.Next() {
+		var t TopItem
+		if err := rows.Scan(text(&t.SKU), &t.Description, text(&t.Unit), text(&t.Currency), &t.Quantity, &t.Revenue, &t.InvoiceCount); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
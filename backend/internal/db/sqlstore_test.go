@@ -0,0 +1,195 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+
+	"github.com/google/uuid"
+)
+
+// newTestStore opens a fresh SQLite database with every migration applied
+func newTestStore(t *testing.T) *SQLStore {
+	t.Helper()
+	s, err := NewSQLite(filepath.Join(t.TempDir(), "invoice.db"))
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	m, err := s.Migrator()
+	if err != nil {
+		t.Fatalf("Migrator: %v", err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return s
+}
+
+// testCustomer creates a customer with a unique e-mail address
+func testCustomer(t *testing.T, s Store) *Customer {
+	t.Helper()
+	c, err := s.CreateCustomer("Acme", uuid.NewString()+"@example.com", "", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateCustomer: %v", err)
+	}
+	return c
+}
+
+// testInvoice creates a USD invoice of one line for total
+func testInvoice(t *testing.T, s Store, customerID string, total money.Amount, status string) *Invoice {
+	t.Helper()
+	items := []Item{{Position: 1, Description: "Consulting", Quantity: money.FromInt(1), UnitPrice: total, Total: total}}
+	inv, err := s.CreateInvoice("", customerID, total, 0, 0, total, items, status, "", "", "USD")
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	return inv
+}
+
+// pay records a cash payment of amount on an invoice
+func pay(t *testing.T, s Store, invoiceID string, amount money.Amount) *Payment {
+	t.Helper()
+	p, err := s.RecordPayment(PaymentCreate{InvoiceID: invoiceID, Amount: amount, PaymentMethod: "cash"})
+	if err != nil {
+		t.Fatalf("RecordPayment: %v", err)
+	}
+	return p
+}
+
+// receipt records a USD cash payment of amount without an invoice, kept as
+// the customer's credit
+func receipt(t *testing.T, s Store, customerID string, amount money.Amount) *Payment {
+	t.Helper()
+	p, err := s.RecordPayment(PaymentCreate{CustomerID: customerID, Currency: "USD", Amount: amount, PaymentMethod: "cash"})
+	if err != nil {
+		t.Fatalf("RecordPayment: %v", err)
+	}
+	return p
+}
+
+// checkPaid reads an invoice and checks what is paid on it and its status
+func checkPaid(t *testing.T, s Store, invoiceID string, paid money.Amount, status string) {
+	t.Helper()
+	inv, err := s.GetInvoice(invoiceID)
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if inv.PaidAmount != paid || inv.Status != status {
+		t.Errorf("invoice paid %s, %s; want %s, %s", inv.PaidAmount, inv.Status, paid, status)
+	}
+}
+
+// checkCredit reads a customer and checks their USD credit
+func checkCredit(t *testing.T, s Store, customerID string, want money.Amount) {
+	t.Helper()
+	c, err := s.GetCustomer(customerID)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	var got money.Amount
+	for _, b := range c.CreditBalances {
+		if b.Currency == "USD" {
+			got = b.Balance
+		}
+	}
+	if got != want {
+		t.Errorf("credit = %s, want %s", got, want)
+	}
+}
+
+func TestRecordPaymentIdempotency(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	other := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+
+	payment := PaymentCreate{InvoiceID: inv.ID, Amount: money.FromInt(40), PaymentMethod: "cash", IdempotencyKey: "key-1"}
+	first, err := s.RecordPayment(payment)
+	if err != nil {
+		t.Fatalf("RecordPayment: %v", err)
+	}
+	replay, err := s.RecordPayment(payment)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if replay.ID != first.ID {
+		t.Errorf("replay recorded payment %s, want the original %s", replay.ID, first.ID)
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(40), lifecycle.PartiallyPaid)
+
+	for _, conflict := range []PaymentCreate{
+		{InvoiceID: inv.ID, Amount: money.FromInt(50), PaymentMethod: "cash", IdempotencyKey: "key-1"},
+		{InvoiceID: other.ID, Amount: money.FromInt(40), PaymentMethod: "cash", IdempotencyKey: "key-1"},
+	} {
+		if _, err := s.RecordPayment(conflict); !errors.Is(err, ErrIdempotencyConflict) {
+			t.Errorf("invoice %s amount %s: err = %v, want ErrIdempotencyConflict", conflict.InvoiceID, conflict.Amount, err)
+		}
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(40), lifecycle.PartiallyPaid)
+	checkPaid(t, s, other.ID, 0, lifecycle.Issued)
+}
+
+func TestRecordPaymentNotPayable(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	draft := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Draft)
+	void := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	if _, err := s.TransitionInvoice(void.ID, 0, lifecycle.Void, "Issued by mistake"); err != nil {
+		t.Fatalf("TransitionInvoice: %v", err)
+	}
+
+	for _, inv := range []*Invoice{draft, void} {
+		_, err := s.RecordPayment(PaymentCreate{InvoiceID: inv.ID, Amount: money.FromInt(10), PaymentMethod: "cash"})
+		if !errors.Is(err, lifecycle.ErrNotAllowed) {
+			t.Errorf("%s invoice: err = %v, want lifecycle.ErrNotAllowed", inv.Status, err)
+		}
+		payments, err := s.GetPaymentsByInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("GetPaymentsByInvoice: %v", err)
+		}
+		if len(payments) != 0 {
+			t.Errorf("%s invoice has %d payments, want none", inv.Status, len(payments))
+		}
+	}
+}
+
+func TestRecordPaymentOverpayment(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+
+	pay(t, s, inv.ID, money.FromInt(150))
+	checkPaid(t, s, inv.ID, money.FromInt(100), lifecycle.Paid)
+	checkCredit(t, s, c.ID, money.FromInt(50))
+}
+
+// TestSettlePayments checks that what is paid is recomputed from the
+// payments that are not void whenever one of them changes
+func TestSettlePayments(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+
+	first := pay(t, s, inv.ID, money.FromInt(30))
+	second := pay(t, s, inv.ID, money.FromInt(70))
+	checkPaid(t, s, inv.ID, money.FromInt(100), lifecycle.Paid)
+
+	if _, err := s.VoidPayment(first.ID, 0, "Bounced"); err != nil {
+		t.Fatalf("VoidPayment: %v", err)
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(70), lifecycle.PartiallyPaid)
+
+	correction := PaymentCorrection{Amount: money.FromInt(20), PaymentMethod: "cash", Reason: "Typo in amount"}
+	if _, err := s.CorrectPayment(second.ID, 0, correction); err != nil {
+		t.Fatalf("CorrectPayment: %v", err)
+	}
+	checkPaid(t, s, inv.ID, money.FromInt(20), lifecycle.PartiallyPaid)
+
+	if _, err := s.VoidPayment(first.ID, 0, "Again"); !errors.Is(err, ErrPaymentVoided) {
+		t.Errorf("voiding a void payment: err = %v, want ErrPaymentVoided", err)
+	}
+}
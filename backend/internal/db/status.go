@@ -0,0 +1,283 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// today returns the current date in UTC, the day invoice due dates are
+// compared with
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// settle sets an invoice's status and payment status from its payments,
+// credits and due date (see lifecycle.Settle)
+func settle(inv *Invoice) {
+	inv.Status = lifecycle.Settle(lifecycle.Invoice{
+		Status:   inv.Status,
+		Total:    inv.Total,
+		Paid:     inv.PaidAmount,
+		Credited: inv.CreditedAmount,
+		DueDate:  inv.DueDate,
+		Sent:     inv.SentAt != "",
+	}, today())
+	inv.PaymentStatus = lifecycle.PaymentStatus(inv.Status, inv.PaidAmount)
+}
+
+// transition moves an invoice to status by hand, recording when it was
+// issued and sent, or why it was voided or written off
+func transition(inv *Invoice, status, reason string) error {
+	err := lifecycle.Transition(lifecycle.Invoice{
+		Status:   inv.Status,
+		Total:    inv.Total,
+		Paid:     inv.PaidAmount,
+		Credited: inv.CreditedAmount,
+	}, status)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if inv.Status == lifecycle.Draft {
+		inv.IssuedAt = now
+	}
+	switch status {
+	case lifecycle.Sent:
+		inv.SentAt = now
+	case lifecycle.Void, lifecycle.WrittenOff:
+		inv.StatusReason = reason
+	}
+	inv.Status = status
+	return nil
+}
+
+// balance returns what is still owed on an invoice: its total less what was
+// paid and credited
+func balance(inv *Invoice) money.Amount {
+	return inv.Total.Sub(inv.PaidAmount).Sub(inv.CreditedAmount)
+}
+
+// billed reports whether an invoice in status counts as revenue: drafts
+// were never issued and void invoices were cancelled
+func billed(status string) bool {
+	return status != lifecycle.Draft && status != lifecycle.Void
+}
+
+// count adds n invoices in status, totalling total of which paid was paid
+// and credited was credited, to the stats. Drafts and void invoices are left
+// out. Credits come off revenue and what is owed, and a written-off invoice
+// only counts what was paid on it.
+func (st *DashboardStats) count(status string, n int, total, paid, credited money.Amount) {
+	if !billed(status) {
+		return
+	}
+	st.TotalInvoices += n
+	st.CreditedAmount = st.CreditedAmount.Add(credited)
+	if status == lifecycle.WrittenOff {
+		st.TotalRevenue = st.TotalRevenue.Add(paid)
+		st.PaidAmount = st.PaidAmount.Add(paid)
+		return
+	}
+	st.TotalRevenue = st.TotalRevenue.Add(total.Sub(credited))
+
+	owed := total.Sub(paid).Sub(credited)
+	switch status {
+	case lifecycle.Paid:
+		st.PaidAmount = st.PaidAmount.Add(total.Sub(credited))
+		st.PaidInvoices += n
+	case lifecycle.PartiallyPaid:
+		st.PaidAmount = st.PaidAmount.Add(paid)
+		st.UnpaidAmount = st.UnpaidAmount.Add(owed)
+		st.PartiallyPaid += n
+	case lifecycle.Overdue:
+		st.PaidAmount = st.PaidAmount.Add(paid)
+		st.OverdueAmount = st.OverdueAmount.Add(owed)
+		st.OverdueInvoices += n
+	default:
+		st.UnpaidAmount = st.UnpaidAmount.Add(owed)
+		st.UnpaidInvoices += n
+	}
+}
+
+// ============================================
+// SQLSTORE
+// ============================================
+
+// TransitionInvoice moves an invoice to status by hand, checking the
+// transition under the invoice's lock
+func (s *SQLStore) TransitionInvoice(id string, version int, status, reason string) (*Invoice, error) {
+	return s.changeInvoice(id, version, func(inv *Invoice) error {
+		return transition(inv, status, reason)
+	})
+}
+
+// changeInvoice locks an invoice at version, lets change edit it, settles
+// its status and writes it back, in one transaction
+func (s *SQLStore) changeInvoice(id string, version int, change func(inv *Invoice) error) (*Invoice, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1 AND org_id = $2`+s.dialect.forUpdate(), id, s.org))
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && inv.Version != version {
+		return nil, ErrVersionConflict
+	}
+	wasDraft := inv.Status == lifecycle.Draft
+	if err := change(inv); err != nil {
+		return nil, err
+	}
+	settle(inv)
+
+	inv, err = scanInvoice(tx.QueryRow(`
+		UPDATE invoices SET status = $2, payment_status = $3, notes = $4, due_date = $5,
+			issued_at = $6, sent_at = $7, status_reason = $8, version = version + 1
+		WHERE id = $1
+		RETURNING `+invoiceColumns,
+		id, inv.Status, inv.PaymentStatus, inv.Notes, nullIfEmpty(inv.DueDate),
+		nullIfEmpty(inv.IssuedAt), nullIfEmpty(inv.SentAt), nullIfEmpty(inv.StatusReason)))
+	if err != nil {
+		return nil, err
+	}
+	// An invoice issued from a draft is paid from its customer's credit
+	if wasDraft {
+		applied, err := s.autoApplyCredit(tx, inv)
+		if err != nil {
+			return nil, err
+		}
+		if applied {
+			if inv, err = scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1`, id)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	return withItems(s.db, inv, nil)
+}
+
+// MarkOverdueInvoices marks the open invoices of every organization whose
+// due date has passed as overdue, one organization at a time so the audit
+// log records each change under its organization
+func (s *SQLStore) MarkOverdueInvoices() (int, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT org_id FROM invoices
+		WHERE status IN ($1, $2, $3) AND due_date < $4`,
+		lifecycle.Issued, lifecycle.Sent, lifecycle.PartiallyPaid, today())
+	if err != nil {
+		return 0, err
+	}
+	var orgs []string
+	for rows.Next() {
+		var org string
+		if err := rows.Scan(&org); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		orgs = append(orgs, org)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, org := range orgs {
+		n, err := s.WithOrg(org).(*SQLStore).markOverdue()
+		if err != nil {
+			return marked, fmt.Errorf("organization %s: %w", org, err)
+		}
+		marked += n
+	}
+	return marked, nil
+}
+
+// markOverdue marks the store's organization's open invoices past their
+// due date as overdue
+func (s *SQLStore) markOverdue() (int, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE invoices SET status = $1, payment_status = $2, version = version + 1
+		WHERE org_id = $3 AND status IN ($4, $5, $6) AND due_date < $7 AND paid_amount + credited_amount < total`,
+		lifecycle.Overdue, lifecycle.PaymentStatus(lifecycle.Overdue, money.Zero), s.org,
+		lifecycle.Issued, lifecycle.Sent, lifecycle.PartiallyPaid, today())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), s.commit(tx)
+}
+
+// ============================================
+// SUPABASE
+// ============================================
+
+// TransitionInvoice moves an invoice to status by hand. The invoice_status
+// trigger checks the transition and settles the status (see migration
+// 0015_invoice_status).
+func (c *SupabaseStore) TransitionInvoice(id string, version int, status, reason string) (*Invoice, error) {
+	if !lifecycle.Valid(status) {
+		return nil, fmt.Errorf("%w: %s", lifecycle.ErrUnknownStatus, status)
+	}
+	updates := map[string]interface{}{"status": status}
+	switch status {
+	case lifecycle.Sent:
+		updates["sent_at"] = time.Now().UTC().Format(time.RFC3339)
+	case lifecycle.Void, lifecycle.WrittenOff:
+		updates["status_reason"] = nullIfEmpty(reason)
+	}
+	return c.writeInvoice(id, version, updates)
+}
+
+// writeInvoice applies updates to an invoice at version. The invoice is read
+// back afterwards, since credit applied to it on issue (see migration
+// 0019_customer_credit) is paid after the update returns the row.
+func (c *SupabaseStore) writeInvoice(id string, version int, updates map[string]interface{}) (*Invoice, error) {
+	var result []Invoice
+	_, err := versioned(c.from("invoices").Update(updates, "", "").Eq("id", id), version).ExecuteTo(&result)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if len(result) == 0 {
+		return nil, c.versionError("invoices", id)
+	}
+	return c.GetInvoice(id)
+}
+
+// MarkOverdueInvoices marks the open invoices of every organization whose
+// due date has passed as overdue through the mark_overdue_invoices database
+// function
+func (c *SupabaseStore) MarkOverdueInvoices() (int, error) {
+	var marked int
+	if err := c.rpc("mark_overdue_invoices", map[string]interface{}{}, &marked); err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+// statusError returns lifecycle.ErrNotAllowed for a write the invoice_status
+// trigger refused, which PostgREST reports with the PT422 code, and err
+// otherwise
+func statusError(err error) error {
+	if msg, ok := strings.CutPrefix(err.Error(), "(PT422) "); ok {
+		return fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, msg)
+	}
+	return err
+}
@@ -0,0 +1,1132 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+// SupabaseStore implements Store through the Supabase PostgREST API
+type SupabaseStore struct {
+	supabase *supabase.Client
+	url, key string
+	// actor and org are sent in the X-Actor and X-Org-ID headers
+	actor, org string
+}
+
+var _ Store = (*SupabaseStore)(nil)
+
+// NewSupabase connects to Supabase using SUPABASE_URL and SUPABASE_KEY
+func NewSupabase() (*SupabaseStore, error) {
+	url := os.Getenv("SUPABASE_URL")
+	key := os.Getenv("SUPABASE_KEY")
+	if url == "" || key == "" {
+		return nil, fmt.Errorf("SUPABASE_URL and SUPABASE_KEY must be set")
+	}
+
+	supabase, err := supabase.NewClient(url, key, &supabase.ClientOptions{Headers: map[string]string{"X-Org-ID": DefaultOrg}})
+	if err != nil {
+		return nil, err
+	}
+	return &SupabaseStore{supabase: supabase, url: url, key: key, org: DefaultOrg}, nil
+}
+
+// rpc calls a Postgres function through PostgREST and decodes its JSON result
+// into out. PostgREST errors raised with the PT402, PT404, PT409, PT410, PT412,
+// PT413, PT422 and PT423 codes map to ErrInsufficientCredit, ErrNotFound, the
+// function's conflict in rpcConflicts, ErrPaymentVoided, ErrVersionConflict,
+// ErrOverallocated, lifecycle.ErrNotAllowed and ErrPaymentAllocated.
+func (c *SupabaseStore) rpc(name string, args, out interface{}) error {
+	body := c.supabase.Rpc(name, "", args)
+	if body == "" {
+		return fmt.Errorf("rpc %s failed", name)
+	}
+
+	var apiErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(body), &apiErr); err == nil && apiErr.Code != "" && apiErr.Message != "" {
+		switch apiErr.Code {
+		case "PT402":
+			return fmt.Errorf("%w: %s", ErrInsufficientCredit, apiErr.Message)
+		case "PT404":
+			return fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+		case "PT409":
+			if conflict, ok := rpcConflicts[name]; ok {
+				return fmt.Errorf("%w: %s", conflict, apiErr.Message)
+			}
+		case "PT410":
+			return fmt.Errorf("%w: %s", ErrPaymentVoided, apiErr.Message)
+		case "PT412":
+			return fmt.Errorf("%w: %s", ErrVersionConflict, apiErr.Message)
+		case "PT413":
+			return fmt.Errorf("%w: %s", ErrOverallocated, apiErr.Message)
+		case "PT422":
+			return fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, apiErr.Message)
+		case "PT423":
+			return fmt.Errorf("%w: %s", ErrPaymentAllocated, apiErr.Message)
+		}
+		return fmt.Errorf("rpc %s: %s", name, apiErr.Message)
+	}
+
+	return json.Unmarshal([]byte(body), out)
+}
+
+// rpcConflicts maps each function that raises PT409 to the error it means
+var rpcConflicts = map[string]error{
+	"record_payment":          ErrIdempotencyConflict,
+	"record_receipt":          ErrIdempotencyConflict,
+	"apply_customer_credit":   ErrCreditExceedsBalance,
+	"void_credit_application": ErrCreditApplicationVoided,
+	"record_refund":           ErrRefundExceedsPayment,
+	"correct_payment":         ErrRefundExceedsPayment,
+	"delete_customer":         ErrCustomerHasInvoices,
+	"save_product":            ErrSKUTaken,
+	"save_price_list":         ErrUnknownProduct,
+	"save_company_profile":    ErrPrefixTaken,
+}
+
+// versioned restricts a write to the version of the row the caller read; a
+// version of 0 skips the check
+func versioned(query *postgrest.FilterBuilder, version int) *postgrest.FilterBuilder {
+	if version == 0 {
+		return query
+	}
+	return query.Eq("version", strconv.Itoa(version))
+}
+
+// versionError explains a versioned write to table that matched no row:
+// ErrVersionConflict if the row exists, ErrNotFound if it does not
+func (c *SupabaseStore) versionError(table, id string) error {
+	var rows []struct {
+		ID string `json:"id"`
+	}
+	_, err := c.from(table).Select("id", "", false).Eq("id", id).ExecuteTo(&rows)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
+}
+
+// readPage reads one keyset page of table, selecting columns, into dst, a
+// pointer to a slice, and returns the number of rows matching the list's
+// filters, which apply (nil for none) adds to both queries, and the sort
+// values of the rows read
+func (c *SupabaseStore) readPage(table, columns string, p PageRequest, cur *cursor, apply func(*postgrest.FilterBuilder) *postgrest.FilterBuilder, dst interface{}) (int, []json.RawMessage, error) {
+	if apply == nil {
+		apply = func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder { return query }
+	}
+
+	_, total, err := apply(c.from(table).Select("id", "exact", true)).Execute()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	column := p.Sort
+	op := "gt"
+	if p.Order == OrderDesc {
+		op = "lt"
+	}
+
+	query := apply(c.from(table).Select(columns, "", false))
+	if cur != nil {
+		v := postgrestValue(rawText(cur.Value))
+		query = query.Or(fmt.Sprintf("%s.%s.%s,and(%s.eq.%s,id.%s.%s)", column, op, v, column, v, op, cur.ID), "")
+	}
+
+	ascending := p.Order == OrderAsc
+	body, _, err := query.
+		Order(column, &postgrest.OrderOpts{Ascending: ascending}).
+		Order("id", &postgrest.OrderOpts{Ascending: ascending}).
+		Limit(p.Limit+1, "").
+		Execute()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := json.Unmarshal(body, dst); err != nil {
+		return 0, nil, err
+	}
+
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return 0, nil, err
+	}
+	values := make([]json.RawMessage, len(rows))
+	for i, row := range rows {
+		values[i] = row[column]
+		if len(values[i]) == 0 || string(values[i]) == "null" {
+			// A missing invoice number sorts as the empty string, as in SQL
+			values[i] = json.RawMessage(`""`)
+		}
+	}
+	return int(total), values, nil
+}
+
+// filterPostgREST extends apply (which may be nil) with an optional filter
+// expression
+func filterPostgREST(where *filter.Expr, apply func(*postgrest.FilterBuilder) *postgrest.FilterBuilder) func(*postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	if where == nil {
+		return apply
+	}
+	return func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if apply != nil {
+			query = apply(query)
+		}
+		return query.And(where.PostgREST(), "")
+	}
+}
+
+// rawText returns a JSON scalar as text: strings unquoted, numbers as written
+func rawText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// postgrestValue double-quotes a value for use inside a PostgREST logic tree,
+// where commas, dots and parentheses are otherwise reserved
+func postgrestValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+// customerSelect reads customers with their credit balances embedded
+const customerSelect = "*, credit_balances:customer_credits(currency, balance)"
+
+// Customer operations
+func (c *SupabaseStore) ListCustomers(scope CustomerScope, where *filter.Expr, page PageRequest) (*Page[Customer], error) {
+	page, cur, err := page.normalize(customerSortFields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	inScope := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		switch scope {
+		case AllCustomers:
+			return query
+		case ArchivedCustomers:
+			return query.Not("archived_at", "is", "null")
+		}
+		return query.Is("archived_at", "null")
+	}
+	var customers []Customer
+	total, values, err := c.readPage("customers", customerSelect, page, cur, filterPostgREST(where, inScope), &customers)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(customers, values, total, page, func(c Customer) string { return c.ID }), nil
+}
+
+func (c *SupabaseStore) CreateCustomer(name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
+	customerCreate := map[string]interface{}{
+		"name":         name,
+		"email":        email,
+		"phone":        phone,
+		"address":      address,
+		"city":         city,
+		"postal_code":  postalCode,
+		"country":      country,
+		"company_name": companyName,
+	}
+	var result []Customer
+	_, err := c.from("customers").Insert(customerCreate, false, "", "", "").ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no customer created")
+	}
+	return &result[0], nil
+}
+
+func (c *SupabaseStore) GetCustomer(id string) (*Customer, error) {
+	var customer Customer
+	_, err := c.from("customers").Select(customerSelect, "", false).Eq("id", id).Single().ExecuteTo(&customer)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (c *SupabaseStore) UpdateCustomer(id string, version int, name, email, phone, address, city, postalCode, country, companyName string) (*Customer, error) {
+	updates := map[string]interface{}{
+		"name":         name,
+		"email":        email,
+		"phone":        phone,
+		"address":      address,
+		"city":         city,
+		"postal_code":  postalCode,
+		"country":      country,
+		"company_name": companyName,
+	}
+	var result []Customer
+	_, err := versioned(c.from("customers").Update(updates, "", "").Eq("id", id), version).ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, c.versionError("customers", id)
+	}
+	return &result[0], nil
+}
+
+// ArchiveCustomer archives a customer. Archiving an archived customer keeps
+// its original archive time.
+func (c *SupabaseStore) ArchiveCustomer(id string) (*Customer, error) {
+	updates := map[string]interface{}{"archived_at": time.Now().UTC().Format(time.RFC3339)}
+	var result []Customer
+	_, err := c.from("customers").Update(updates, "", "").Eq("id", id).Is("archived_at", "null").ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		// Already archived, or no such customer
+		return c.GetCustomer(id)
+	}
+	return &result[0], nil
+}
+
+func (c *SupabaseStore) RestoreCustomer(id string) (*Customer, error) {
+	updates := map[string]interface{}{"archived_at": nil}
+	var result []Customer
+	_, err := c.from("customers").Update(updates, "", "").Eq("id", id).ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	return &result[0], nil
+}
+
+func (c *SupabaseStore) SetCustomerPriceList(customerID, priceListID string) (*Customer, error) {
+	if priceListID != "" {
+		if _, err := c.GetPriceList(priceListID); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownPriceList, priceListID)
+			}
+			return nil, err
+		}
+	}
+	updates := map[string]interface{}{"price_list_id": nullIfEmpty(priceListID)}
+	var result []Customer
+	_, err := c.from("customers").Update(updates, "", "").Eq("id", customerID).ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	return &result[0], nil
+}
+
+func (c *SupabaseStore) DeleteCustomer(id string, version int, force bool) error {
+	args := map[string]interface{}{"p_customer_id": id, "p_force": force}
+	if version != 0 {
+		args["p_version"] = version
+	}
+	var deleted int
+	return c.rpc("delete_customer", args, &deleted)
+}
+
+// invoiceSelect reads invoices with their lines embedded as items
+const invoiceSelect = "*, items:invoice_items(*)"
+
+// sortItems puts the lines embedded by invoiceSelect in position order
+func sortItems(invoices []Invoice) {
+	for _, inv := range invoices {
+		sort.Slice(inv.Items, func(i, j int) bool { return inv.Items[i].Position < inv.Items[j].Position })
+	}
+}
+
+// CreateInvoice stores the invoice and its lines through the create_invoice
+// database function, which inserts them in one transaction (see migration
+// 0009_invoice_items)
+func (c *SupabaseStore) CreateInvoice(companyID, customerID string, subtotal money.Amount, tax float64, discount, total money.Amount, items []Item, status, notes, dueDate, currency string) (*Invoice, error) {
+	if currency == "" {
+		currency = "USD"
+	}
+	status, err := lifecycle.Initial(status)
+	if err != nil {
+		return nil, err
+	}
+
+	// invoice_number is left out: the assign_invoice_number trigger allocates
+	// it from the company's invoice series in the same transaction as the
+	// insert, and issues the invoice from the default company if companyID
+	// is empty
+	invoiceData := map[string]interface{}{
+		"company_id":  nullIfEmpty(companyID),
+		"customer_id": customerID,
+		"subtotal":    subtotal,
+		"tax":         tax,
+		"discount":    discount,
+		"total":       total,
+		"status":      status,
+		"notes":       notes,
+		"due_date":    nullIfEmpty(dueDate),
+		"currency":    currency,
+	}
+
+	var created Invoice
+	err = c.rpc("create_invoice", map[string]interface{}{"p_invoice": invoiceData, "p_items": items}, &created)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetInvoice(created.ID)
+}
+
+func (c *SupabaseStore) ListInvoices(where *filter.Expr, page PageRequest) (*Page[Invoice], error) {
+	return c.pageInvoices(page, filterPostgREST(where, nil))
+}
+
+// pageInvoices reads one page of the invoices matching apply (see readPage)
+func (c *SupabaseStore) pageInvoices(page PageRequest, apply func(*postgrest.FilterBuilder) *postgrest.FilterBuilder) (*Page[Invoice], error) {
+	page, cur, err := page.normalize(invoiceSortFields, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	var invoices []Invoice
+	total, values, err := c.readPage("invoices", invoiceSelect, page, cur, apply, &invoices)
+	if err != nil {
+		return nil, err
+	}
+	sortItems(invoices)
+	return newPage(invoices, values, total, page, func(inv Invoice) string { return inv.ID }), nil
+}
+
+func (c *SupabaseStore) GetInvoice(id string) (*Invoice, error) {
+	var invoice Invoice
+	_, err := c.from("invoices").Select(invoiceSelect, "", false).Eq("id", id).Single().ExecuteTo(&invoice)
+	if err != nil {
+		return nil, err
+	}
+	sortItems([]Invoice{invoice})
+	return &invoice, nil
+}
+
+// UpdateInvoice replaces the invoice's notes and due date and sets its
+// status, which the invoice_status trigger checks and settles (see
+// migration 0015_invoice_status)
+func (c *SupabaseStore) UpdateInvoice(id string, version int, status, notes, dueDate string) (*Invoice, error) {
+	updates := map[string]interface{}{
+		"notes":    notes,
+		"due_date": nullIfEmpty(dueDate),
+	}
+	if status != "" {
+		if !lifecycle.Valid(status) {
+			return nil, fmt.Errorf("%w: %s", lifecycle.ErrUnknownStatus, status)
+		}
+		updates["status"] = status
+	}
+	return c.writeInvoice(id, version, updates)
+}
+
+// GetCurrencyRate gets exchange rate between two currencies
+func (c *SupabaseStore) GetCurrencyRate(fromCurrency, toCurrency string) (*CurrencyRate, error) {
+	var rate CurrencyRate
+	_, err := c.from("currency_rates").
+		Select("*", "", false).
+		Eq("from_currency", fromCurrency).
+		Eq("to_currency", toCurrency).
+		Single().
+		ExecuteTo(&rate)
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// ListCurrencyRates gets all currency rates
+func (c *SupabaseStore) ListCurrencyRates() ([]CurrencyRate, error) {
+	var rates []CurrencyRate
+	_, err := c.from("currency_rates").Select("*", "", false).ExecuteTo(&rates)
+	return rates, err
+}
+
+// FilterInvoices filters invoices based on status, date range, and search
+// term. PostgREST cannot order by the search rank, so results keep the
+// requested sort rather than being ranked.
+func (c *SupabaseStore) FilterInvoices(status, searchTerm, startDate, endDate string, where *filter.Expr, page PageRequest) (*Page[Invoice], error) {
+	apply := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		// Filter by status
+		if status != "" && status != "all" {
+			query = query.Eq("status", status)
+		}
+
+		// Filter by date range
+		if startDate != "" {
+			query = query.Gte("created_at", startDate)
+		}
+		if endDate != "" {
+			query = query.Lte("created_at", endDate)
+		}
+
+		// Full-text search over the invoice's search_vector
+		if tokens := searchTokens(searchTerm); len(tokens) > 0 {
+			query = query.TextSearch("search_vector", tsquery(tokens), "simple", "")
+		}
+		return query
+	}
+
+	return c.pageInvoices(page, filterPostgREST(where, apply))
+}
+
+// ============================================
+// PAYMENT RECORDING FUNCTIONS
+// ============================================
+
+// RecordPayment records the payment through the record_payment database
+// function, which locks the invoice, inserts the payment and updates the
+// invoice's paid amount in one transaction (see migration 0002_payment_idempotency),
+// and a payment without an invoice through record_receipt (see migration
+// 0019_customer_credit)
+func (c *SupabaseStore) RecordPayment(payment PaymentCreate) (*Payment, error) {
+	function := "record_payment"
+	args := map[string]interface{}{
+		"p_amount":         payment.Amount,
+		"p_payment_method": payment.PaymentMethod,
+	}
+	if payment.InvoiceID != "" {
+		args["p_invoice_id"] = payment.InvoiceID
+	} else {
+		function = "record_receipt"
+		args["p_customer_id"] = payment.CustomerID
+		if payment.Currency != "" {
+			args["p_currency"] = payment.Currency
+		}
+		if len(payment.Allocations) > 0 {
+			args["p_allocations"] = payment.Allocations
+		}
+		if payment.AutoAllocate {
+			args["p_auto_allocate"] = true
+		}
+	}
+	if payment.PaymentDate != "" {
+		args["p_payment_date"] = payment.PaymentDate
+	}
+	if payment.ReferenceNumber != "" {
+		args["p_reference_number"] = payment.ReferenceNumber
+	}
+	if payment.Notes != "" {
+		args["p_notes"] = payment.Notes
+	}
+	if payment.CreatedBy != "" {
+		args["p_created_by"] = payment.CreatedBy
+	}
+	if payment.IdempotencyKey != "" {
+		args["p_idempotency_key"] = payment.IdempotencyKey
+	}
+
+	var result Payment
+	if err := c.rpc(function, args, &result); err != nil {
+		return nil, err
+	}
+	if function == "record_receipt" {
+		if err := c.withAllocations(&result); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// GetPayment returns a payment with its refunds embedded and its allocations
+func (c *SupabaseStore) GetPayment(id string) (*Payment, error) {
+	var payments []Payment
+	_, err := c.from("payments").Select("*, refunds(*)", "", false).Eq("id", id).ExecuteTo(&payments)
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, ErrNotFound
+	}
+	sortRefunds(payments)
+	if err := c.withAllocations(&payments[0]); err != nil {
+		return nil, err
+	}
+	return &payments[0], nil
+}
+
+// CorrectPayment corrects the payment through the correct_payment database
+// function, which locks the invoice, rewrites the payment and recomputes the
+// invoice's paid amount in one transaction (see migration
+// 0018_payment_corrections)
+func (c *SupabaseStore) CorrectPayment(id string, version int, correction PaymentCorrection) (*Payment, error) {
+	args := map[string]interface{}{
+		"p_payment_id": id,
+		"p_payment": map[string]interface{}{
+			"amount":            correction.Amount,
+			"payment_method":    correction.PaymentMethod,
+			"payment_date":      nullIfEmpty(correction.PaymentDate),
+			"reference_number":  correction.ReferenceNumber,
+			"notes":             correction.Notes,
+			"correction_reason": correction.Reason,
+		},
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var result Payment
+	if err := c.rpc("correct_payment", args, &result); err != nil {
+		return nil, err
+	}
+	return c.GetPayment(result.ID)
+}
+
+// VoidPayment voids the payment through the void_payment database function,
+// which settles the invoice in the same transaction
+func (c *SupabaseStore) VoidPayment(id string, version int, reason string) (*Payment, error) {
+	args := map[string]interface{}{
+		"p_payment_id": id,
+		"p_reason":     reason,
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var result Payment
+	if err := c.rpc("void_payment", args, &result); err != nil {
+		return nil, err
+	}
+	return c.GetPayment(result.ID)
+}
+
+// GetPaymentsByInvoice returns all payments for an invoice, with their
+// refunds embedded
+func (c *SupabaseStore) GetPaymentsByInvoice(invoiceID string) ([]Payment, error) {
+	var payments []Payment
+	_, err := c.from("payments").
+		Select("*, refunds(*)", "", false).
+		Eq("invoice_id", invoiceID).
+		ExecuteTo(&payments)
+	sortRefunds(payments)
+	return payments, err
+}
+
+// GetAllPayments returns one page of all payments
+func (c *SupabaseStore) GetAllPayments(where *filter.Expr, page PageRequest) (*Page[Payment], error) {
+	page, cur, err := page.normalize(paymentSortFields, "payment_date")
+	if err != nil {
+		return nil, err
+	}
+	var payments []Payment
+	total, values, err := c.readPage("payments", "*", page, cur, filterPostgREST(where, nil), &payments)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(payments, values, total, page, func(p Payment) string { return p.ID }), nil
+}
+
+// ============================================
+// DASHBOARD & ANALYTICS FUNCTIONS
+// ============================================
+
+// GetDashboardStats returns overall statistics for dashboard
+func (c *SupabaseStore) GetDashboardStats(currency string) (*DashboardStats, error) {
+	var invoices []Invoice
+	query := c.from("invoices").Select("*", "", false)
+
+	if currency != "" && currency != "ALL" {
+		query = query.Eq("currency", currency)
+	}
+
+	_, err := query.ExecuteTo(&invoices)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DashboardStats{Currency: currency}
+
+	for _, inv := range invoices {
+		stats.count(inv.Status, 1, inv.Total, inv.PaidAmount, inv.CreditedAmount)
+	}
+
+	return stats, nil
+}
+
+// GetRevenueByPeriod returns revenue grouped by period (daily, weekly, monthly)
+func (c *SupabaseStore) GetRevenueByPeriod(period string, limit int) ([]RevenueByPeriod, error) {
+	// For simplicity, we'll aggregate in Go
+	var invoices []Invoice
+	_, err := c.from("invoices").
+		Select("*", "", false).
+		ExecuteTo(&invoices)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Group by date (simplified - daily grouping)
+	revenueMap := make(map[string]*RevenueByPeriod)
+
+	for _, inv := range invoices {
+		if !billed(inv.Status) {
+			continue
+		}
+		date := inv.CreatedAt[:10] // Get YYYY-MM-DD
+
+		if _, exists := revenueMap[date]; !exists {
+			revenueMap[date] = &RevenueByPeriod{
+				Date:     date,
+				Currency: inv.Currency,
+			}
+		}
+
+		revenueMap[date].Revenue += inv.Total - inv.CreditedAmount
+		revenueMap[date].InvoiceCount++
+	}
+
+	// Convert map to slice
+	var result []RevenueByPeriod
+	for _, v := range revenueMap {
+		result = append(result, *v)
+	}
+
+	return result, nil
+}
+
+// GetTopCustomers returns customers with highest revenue
+func (c *SupabaseStore) GetTopCustomers(limit int) ([]TopCustomer, error) {
+	var invoices []Invoice
+	_, err := c.from("invoices").
+		Select("*", "", false).
+		ExecuteTo(&invoices)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Group by customer
+	customerMap := make(map[string]*TopCustomer)
+
+	for _, inv := range invoices {
+		if !billed(inv.Status) {
+			continue
+		}
+		if _, exists := customerMap[inv.CustomerID]; !exists {
+			// Get customer name
+			customer, err := c.GetCustomer(inv.CustomerID)
+			if err != nil {
+				continue
+			}
+
+			customerMap[inv.CustomerID] = &TopCustomer{
+				CustomerID:   inv.CustomerID,
+				CustomerName: customer.Name,
+				Currency:     inv.Currency,
+			}
+		}
+
+		customerMap[inv.CustomerID].TotalRevenue += inv.Total - inv.CreditedAmount
+		customerMap[inv.CustomerID].InvoiceCount++
+	}
+
+	// Convert to slice and sort (simplified)
+	var result []TopCustomer
+	for _, v := range customerMap {
+		result = append(result, *v)
+	}
+
+	// Return first N customers (should sort by revenue first)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// GetOverdueInvoices returns all overdue invoices
+func (c *SupabaseStore) GetOverdueInvoices() ([]Invoice, error) {
+	var invoices []Invoice
+	_, err := c.from("invoices").
+		Select(invoiceSelect, "", false).
+		Eq("status", lifecycle.Overdue).
+		ExecuteTo(&invoices)
+	sortItems(invoices)
+	return invoices, err
+}
+
+// GetTopItems returns the items that brought in the most, from the
+// item_sales view
+func (c *SupabaseStore) GetTopItems(limit int) ([]TopItem, error) {
+	var items []TopItem
+	_, err := c.from("item_sales").
+		Select("*", "", false).
+		Order("revenue", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		ExecuteTo(&items)
+	return items, err
+}
+
+// ============================================
+// PRODUCT CATALOG
+// ============================================
+
+// productSelect reads products with their prices embedded
+const productSelect = "*, prices:product_prices(currency, unit_price)"
+
+func (c *SupabaseStore) ListProducts(active *bool, where *filter.Expr, page PageRequest) (*Page[Product], error) {
+	page, cur, err := page.normalize(productSortFields, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var inScope func(*postgrest.FilterBuilder) *postgrest.FilterBuilder
+	if active != nil {
+		inScope = func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+			return query.Eq("active", strconv.FormatBool(*active))
+		}
+	}
+	var products []Product
+	total, values, err := c.readPage("products", productSelect, page, cur, filterPostgREST(where, inScope), &products)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(products, values, total, page, func(p Product) string { return p.ID }), nil
+}
+
+func (c *SupabaseStore) GetProduct(id string) (*Product, error) {
+	var products []Product
+	_, err := c.from("products").Select(productSelect, "", false).Eq("id", id).ExecuteTo(&products)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrNotFound
+	}
+	return &products[0], nil
+}
+
+func (c *SupabaseStore) CreateProduct(product Product) (*Product, error) {
+	return c.saveProduct("", 0, product)
+}
+
+func (c *SupabaseStore) UpdateProduct(id string, version int, product Product) (*Product, error) {
+	return c.saveProduct(id, version, product)
+}
+
+// saveProduct saves a product and its prices through the save_product
+// database function, which writes them in one transaction (see migration
+// 0011_product_catalog)
+func (c *SupabaseStore) saveProduct(id string, version int, product Product) (*Product, error) {
+	prices := product.Prices
+	if prices == nil {
+		prices = []ProductPrice{}
+	}
+	args := map[string]interface{}{
+		"p_id": nullIfEmpty(id),
+		"p_product": map[string]interface{}{
+			"sku":         product.SKU,
+			"name":        product.Name,
+			"description": product.Description,
+			"unit":        product.Unit,
+			"tax_rate":    product.TaxRate,
+			"active":      product.Active,
+		},
+		"p_prices": prices,
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var saved Product
+	if err := c.rpc("save_product", args, &saved); err != nil {
+		return nil, err
+	}
+	return c.GetProduct(saved.ID)
+}
+
+func (c *SupabaseStore) DeleteProduct(id string, version int) error {
+	var deleted []Product
+	_, err := versioned(c.from("products").Delete("", "").Eq("id", id), version).ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return c.versionError("products", id)
+	}
+	return nil
+}
+
+// ============================================
+// PRICE LISTS
+// ============================================
+
+// priceListSelect reads price lists with their rules embedded
+const priceListSelect = "*, rules:price_list_rules(product_id, min_quantity, unit_price, discount_percent)"
+
+func (c *SupabaseStore) ListPriceLists() ([]PriceList, error) {
+	var lists []PriceList
+	_, err := c.from("price_lists").
+		Select(priceListSelect, "", false).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&lists)
+	return lists, err
+}
+
+func (c *SupabaseStore) GetPriceList(id string) (*PriceList, error) {
+	var lists []PriceList
+	_, err := c.from("price_lists").Select(priceListSelect, "", false).Eq("id", id).ExecuteTo(&lists)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, ErrNotFound
+	}
+	return &lists[0], nil
+}
+
+func (c *SupabaseStore) CreatePriceList(list PriceList) (*PriceList, error) {
+	return c.savePriceList("", 0, list)
+}
+
+func (c *SupabaseStore) UpdatePriceList(id string, version int, list PriceList) (*PriceList, error) {
+	return c.savePriceList(id, version, list)
+}
+
+// savePriceList saves a price list and its rules through the
+// save_price_list database function, which writes them in one transaction
+// (see migration 0012_price_lists)
+func (c *SupabaseStore) savePriceList(id string, version int, list PriceList) (*PriceList, error) {
+	rules := list.Rules
+	if rules == nil {
+		rules = []PriceRule{}
+	}
+	args := map[string]interface{}{
+		"p_id": nullIfEmpty(id),
+		"p_list": map[string]interface{}{
+			"name":        list.Name,
+			"description": list.Description,
+			"currency":    list.Currency,
+		},
+		"p_rules": rules,
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var saved PriceList
+	if err := c.rpc("save_price_list", args, &saved); err != nil {
+		return nil, err
+	}
+	return c.GetPriceList(saved.ID)
+}
+
+// DeletePriceList deletes a price list; the foreign key unassigns it from
+// its customers
+func (c *SupabaseStore) DeletePriceList(id string, version int) error {
+	var deleted []PriceList
+	_, err := versioned(c.from("price_lists").Delete("", "").Eq("id", id), version).ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return c.versionError("price_lists", id)
+	}
+	return nil
+}
+
+// ============================================
+// COMPANY PROFILES
+// ============================================
+
+func (c *SupabaseStore) ListCompanyProfiles() ([]CompanyProfile, error) {
+	profiles := []CompanyProfile{}
+	_, err := c.from("company_info").
+		Select("*", "", false).
+		Order("is_default", &postgrest.OrderOpts{Ascending: false}).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&profiles)
+	return profiles, err
+}
+
+func (c *SupabaseStore) GetCompanyProfile(id string) (*CompanyProfile, error) {
+	query := c.from("company_info").Select("*", "", false)
+	if id == "" {
+		query = query.Eq("is_default", "true")
+	} else {
+		query = query.Eq("id", id)
+	}
+	var profiles []CompanyProfile
+	if _, err := query.ExecuteTo(&profiles); err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &profiles[0], nil
+}
+
+func (c *SupabaseStore) CreateCompanyProfile(profile CompanyProfile, invoicePrefix string) (*CompanyProfile, error) {
+	return c.saveCompanyProfile("", 0, profile, invoicePrefix)
+}
+
+func (c *SupabaseStore) UpdateCompanyProfile(id string, version int, profile CompanyProfile) (*CompanyProfile, error) {
+	return c.saveCompanyProfile(id, version, profile, "")
+}
+
+// saveCompanyProfile saves a company profile, and the numbering series of a
+// new one, through the save_company_profile database function (see
+// migration 0013_company_profiles)
+func (c *SupabaseStore) saveCompanyProfile(id string, version int, profile CompanyProfile, invoicePrefix string) (*CompanyProfile, error) {
+	args := map[string]interface{}{
+		"p_id": nullIfEmpty(id),
+		"p_profile": map[string]interface{}{
+			"name":                profile.Name,
+			"legal_name":          profile.LegalName,
+			"tax_id":              profile.TaxID,
+			"address":             profile.Address,
+			"city":                profile.City,
+			"postal_code":         profile.PostalCode,
+			"country":             profile.Country,
+			"email":               profile.Email,
+			"phone":               profile.Phone,
+			"website":             profile.Website,
+			"bank_name":           profile.BankName,
+			"bank_account_name":   profile.BankAccountName,
+			"bank_account_number": profile.BankAccountNumber,
+			"bank_swift":          profile.BankSwift,
+			"logo":                profile.Logo,
+			"is_default":          profile.IsDefault,
+		},
+		"p_prefix": nullIfEmpty(invoicePrefix),
+	}
+	if version != 0 {
+		args["p_version"] = version
+	}
+
+	var saved CompanyProfile
+	if err := c.rpc("save_company_profile", args, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteCompanyProfile deletes a profile that never issued an invoice; the
+// foreign key from invoices backs up the check
+func (c *SupabaseStore) DeleteCompanyProfile(id string, version int) error {
+	profile, err := c.GetCompanyProfile(id)
+	if err != nil {
+		return err
+	}
+	if profile.IsDefault {
+		return ErrDefaultCompany
+	}
+	var invoices []Invoice
+	_, err = c.from("invoices").Select("id", "", false).Eq("company_id", id).Limit(1, "").ExecuteTo(&invoices)
+	if err != nil {
+		return err
+	}
+	if len(invoices) > 0 {
+		return ErrCompanyHasInvoices
+	}
+
+	var deleted []CompanyProfile
+	_, err = versioned(c.from("company_info").Delete("", "").Eq("id", id), version).ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return c.versionError("company_info", id)
+	}
+	return nil
+}
+
+// ============================================
+// DOCUMENT NUMBERING
+// ============================================
+
+func (c *SupabaseStore) ListNumberSeries() ([]NumberSeries, error) {
+	var series []NumberSeries
+	_, err := c.from("number_series").
+		Select("*", "", false).
+		Order("series_key", nil).
+		ExecuteTo(&series)
+	return series, err
+}
+
+func (c *SupabaseStore) UpdateNumberSeries(series NumberSeries) (*NumberSeries, error) {
+	updateData := map[string]interface{}{
+		"prefix":     series.Prefix,
+		"pattern":    series.Pattern,
+		"reset":      series.Reset,
+		"padding":    series.Padding,
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+
+	var result []NumberSeries
+	_, err := c.from("number_series").Update(updateData, "", "").Eq("series_key", series.Key).ExecuteTo(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	return &result[0], nil
+}
+
+func (c *SupabaseStore) AuditNumberSeries(key string) (*NumberingAudit, error) {
+	var series []NumberSeries
+	_, err := c.from("number_series").Select("series_key", "", false).Eq("series_key", key).ExecuteTo(&series)
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var counters []struct {
+		Period    string `json:"period"`
+		LastValue int    `json:"last_value"`
+	}
+	_, err = c.from("number_series_counters").
+		Select("period, last_value", "", false).
+		Eq("series_key", key).
+		Order("period", nil).
+		ExecuteTo(&counters)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []struct {
+		Period   string `json:"period"`
+		Sequence int    `json:"sequence"`
+	}
+	_, err = c.from("document_numbers").
+		Select("period, sequence", "", false).
+		Eq("series_key", key).
+		Order("sequence", nil).
+		ExecuteTo(&numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	issued := make(map[string][]int)
+	for _, n := range numbers {
+		issued[n.Period] = append(issued[n.Period], n.Sequence)
+	}
+
+	var periods []NumberingPeriod
+	for _, counter := range counters {
+		periods = append(periods, auditPeriod(counter.Period, counter.LastValue, issued[counter.Period]))
+	}
+	return newNumberingAudit(key, periods), nil
+}
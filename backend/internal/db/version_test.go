@@ -0,0 +1,59 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
+)
+
+// TestStaleVersion checks that a write naming a version the record has moved
+// past is refused and changes nothing, while one naming the current version or
+// none applies
+func TestStaleVersion(t *testing.T) {
+	s := newTestStore(t)
+	c := testCustomer(t, s)
+	stale := c.Version
+
+	c, err := s.UpdateCustomer(c.ID, stale, "Acme Ltd", c.Email, "", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("UpdateCustomer: %v", err)
+	}
+	if c.Version != stale+1 {
+		t.Errorf("version after update = %d, want %d", c.Version, stale+1)
+	}
+	if _, err := s.UpdateCustomer(c.ID, stale, "Acme Inc", c.Email, "", "", "", "", "", ""); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("UpdateCustomer at a stale version: err = %v, want ErrVersionConflict", err)
+	}
+	if err := s.DeleteCustomer(c.ID, stale, false); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("DeleteCustomer at a stale version: err = %v, want ErrVersionConflict", err)
+	}
+	got, err := s.GetCustomer(c.ID)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	if got.Name != "Acme Ltd" || got.Version != c.Version {
+		t.Errorf("customer = %s at version %d, want Acme Ltd at %d", got.Name, got.Version, c.Version)
+	}
+
+	inv := testInvoice(t, s, c.ID, money.FromInt(100), lifecycle.Issued)
+	if _, err := s.UpdateInvoice(inv.ID, inv.Version, "", "First note", ""); err != nil {
+		t.Fatalf("UpdateInvoice: %v", err)
+	}
+	if _, err := s.UpdateInvoice(inv.ID, inv.Version, "", "Second note", ""); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("UpdateInvoice at a stale version: err = %v, want ErrVersionConflict", err)
+	}
+	if _, err := s.UpdateInvoice(inv.ID, 0, "", "Any version", ""); err != nil {
+		t.Errorf("UpdateInvoice at any version: %v", err)
+	}
+
+	// A missing record is not found rather than conflicting
+	if _, err := s.UpdateCustomer("6f1c1b4e-3f0a-4a55-9c53-5b0e8f7a2d10", 1, "Acme", c.Email, "", "", "", "", "", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateCustomer of a missing customer: err = %v, want ErrNotFound", err)
+	}
+
+	if err := s.DeleteCustomer(c.ID, c.Version, true); err != nil {
+		t.Errorf("DeleteCustomer at the current version: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SQL renders the expression as a SQL condition. Placeholders are $N,
+// numbered from n+1 so the condition can follow n existing arguments; the
+// arguments are returned in order. Text columns are compared with NULL read
+// as the empty string.
+func (e *Expr) SQL(n int) (string, []interface{}) {
+	c := &sqlCompiler{n: n}
+	return c.node(e.node), c.args
+}
+
+type sqlCompiler struct {
+	n    int
+	args []interface{}
+}
+
+func (c *sqlCompiler) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", c.n+len(c.args))
+}
+
+func (c *sqlCompiler) node(n node) string {
+	switch n := n.(type) {
+	case *logical:
+		parts := make([]string, len(n.terms))
+		for i, t := range n.terms {
+			parts[i] = c.node(t)
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(n.op)+" ") + ")"
+	case *negation:
+		return "NOT " + c.node(n.term)
+	case *condition:
+		return c.condition(n)
+	}
+	panic(fmt.Sprintf("filter: unknown node %T", n))
+}
+
+func (c *sqlCompiler) condition(cond *condition) string {
+	col := cond.field.Column
+	if cond.field.Type == String || cond.field.Type == Enum {
+		col = "COALESCE(" + col + ", '')"
+	}
+
+	switch cond.op {
+	case "in", "not in":
+		placeholders := make([]string, len(cond.values))
+		for i, v := range cond.values {
+			placeholders[i] = c.arg(v)
+		}
+		return fmt.Sprintf("%s %s (%s)", col, strings.ToUpper(cond.op), strings.Join(placeholders, ", "))
+	case "~":
+		return fmt.Sprintf(`LOWER(%s) LIKE %s ESCAPE '\'`, col, c.arg("%"+escapeLike(strings.ToLower(cond.values[0]))+"%"))
+	}
+
+	v := cond.values[0]
+	if cond.day {
+		// A date compared with a timestamp covers the whole day
+		switch cond.op {
+		case "=":
+			return fmt.Sprintf("(%s >= %s AND %s < %s)", col, c.arg(v), col, c.arg(nextDay(v)))
+		case "!=":
+			return fmt.Sprintf("(%s < %s OR %s >= %s)", col, c.arg(v), col, c.arg(nextDay(v)))
+		case ">":
+			return fmt.Sprintf("%s >= %s", col, c.arg(nextDay(v)))
+		case "<=":
+			return fmt.Sprintf("%s < %s", col, c.arg(nextDay(v)))
+		}
+	}
+
+	op := cond.op
+	if op == "!=" {
+		op = "<>"
+	}
+	return fmt.Sprintf("%s %s %s", col, op, c.arg(v))
+}
+
+// escapeLike escapes the LIKE wildcards in s, using \ as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// PostgREST renders the expression in PostgREST's logic-tree syntax, for use
+// as the and=(...) query parameter
+func (e *Expr) PostgREST() string {
+	return postgrestNode(e.node)
+}
+
+var postgrestOps = map[string]string{"=": "eq", "!=": "neq", ">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+
+func postgrestNode(n node) string {
+	switch n := n.(type) {
+	case *logical:
+		parts := make([]string, len(n.terms))
+		for i, t := range n.terms {
+			parts[i] = postgrestNode(t)
+		}
+		return n.op + "(" + strings.Join(parts, ",") + ")"
+	case *negation:
+		return "not.and(" + postgrestNode(n.term) + ")"
+	case *condition:
+		return postgrestCondition(n)
+	}
+	panic(fmt.Sprintf("filter: unknown node %T", n))
+}
+
+func postgrestCondition(cond *condition) string {
+	col := cond.field.Column
+	switch cond.op {
+	case "in", "not in":
+		quoted := make([]string, len(cond.values))
+		for i, v := range cond.values {
+			quoted[i] = quote(v)
+		}
+		op := "in"
+		if cond.op == "not in" {
+			op = "not.in"
+		}
+		return fmt.Sprintf("%s.%s.(%s)", col, op, strings.Join(quoted, ","))
+	case "~":
+		return fmt.Sprintf("%s.ilike.%s", col, quote("*"+cond.values[0]+"*"))
+	}
+
+	v := cond.values[0]
+	if cond.day {
+		day, next := quote(v), quote(nextDay(v))
+		switch cond.op {
+		case "=":
+			return fmt.Sprintf("and(%s.gte.%s,%s.lt.%s)", col, day, col, next)
+		case "!=":
+			return fmt.Sprintf("or(%s.lt.%s,%s.gte.%s)", col, day, col, next)
+		case ">":
+			return fmt.Sprintf("%s.gte.%s", col, next)
+		case "<=":
+			return fmt.Sprintf("%s.lt.%s", col, next)
+		}
+	}
+	return fmt.Sprintf("%s.%s.%s", col, postgrestOps[cond.op], quote(v))
+}
+
+// quote double-quotes a value for a PostgREST logic tree, where commas, dots
+// and parentheses are otherwise reserved
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
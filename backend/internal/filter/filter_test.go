@@ -0,0 +1,206 @@
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testSchema = Schema{
+	"status":      {Column: "status", Type: Enum, Values: []string{"draft", "sent", "paid"}},
+	"total":       {Column: "total", Type: Number},
+	"currency":    {Column: "currency", Type: String},
+	"notes":       {Column: "notes", Type: String},
+	"customer_id": {Column: "customer_id", Type: ID},
+	"due_date":    {Column: "due_date", Type: Date},
+	"created_at":  {Column: "created_at", Type: Timestamp},
+}
+
+const (
+	uuid1 = "6f9ca976-cb9b-4602-8b4f-8ceb7470de12"
+	uuid2 = "c6848b21-15e0-4e6d-9e3d-c40d0f5ab661"
+)
+
+func mustParse(t *testing.T, src string) *Expr {
+	t.Helper()
+	e, err := Parse(src, testSchema)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", src, err)
+	}
+	return e
+}
+
+func TestParseBlank(t *testing.T) {
+	for _, src := range []string{"", "  \t\n"} {
+		e, err := Parse(src, testSchema)
+		if e != nil || err != nil {
+			t.Errorf("Parse(%q) = %v, %v; want nil, nil", src, e, err)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		src  string
+		n    int
+		want string
+		args []interface{}
+	}{
+		// and binds tighter than or
+		{
+			"status=draft or status=sent and total>10", 0,
+			"(COALESCE(status, '') = $1 OR (COALESCE(status, '') = $2 AND total > $3))",
+			[]interface{}{"draft", "sent", "10"},
+		},
+		{
+			"(status=draft or status=sent) and total>10", 0,
+			"((COALESCE(status, '') = $1 OR COALESCE(status, '') = $2) AND total > $3)",
+			[]interface{}{"draft", "sent", "10"},
+		},
+		// not binds tighter than and
+		{
+			"not status=draft and total>=1.50", 0,
+			"(NOT COALESCE(status, '') = $1 AND total >= $2)",
+			[]interface{}{"draft", "1.50"},
+		},
+		{
+			"not (status=draft or status=sent)", 0,
+			"NOT (COALESCE(status, '') = $1 OR COALESCE(status, '') = $2)",
+			[]interface{}{"draft", "sent"},
+		},
+		// Keywords and field names are case-insensitive, enum values and
+		// numbers canonical
+		{
+			"STATUS = Draft AND Total > 1e3", 0,
+			"(COALESCE(status, '') = $1 AND total > $2)",
+			[]interface{}{"draft", "1000"},
+		},
+		{"status<>SENT", 0, "COALESCE(status, '') <> $1", []interface{}{"sent"}},
+		// Placeholders continue after existing arguments
+		{
+			"currency in (IDR, usd) and total<5", 2,
+			"(COALESCE(currency, '') IN ($3, $4) AND total < $5)",
+			[]interface{}{"IDR", "usd", "5"},
+		},
+		{
+			"customer_id not in (" + uuid1 + ", " + uuid2 + ")", 1,
+			"customer_id NOT IN ($2, $3)",
+			[]interface{}{uuid1, uuid2},
+		},
+		// LIKE wildcards in the value are escaped
+		{
+			`notes~"50%_Off\\"`, 0,
+			`LOWER(COALESCE(notes, '')) LIKE $1 ESCAPE '\'`,
+			[]interface{}{`%50\%\_off\\%`},
+		},
+		{"due_date<2026-11-01", 0, "due_date < $1", []interface{}{"2026-11-01"}},
+		// A date compared with a timestamp covers the whole day
+		{
+			"created_at=2026-01-31", 0,
+			"(created_at >= $1 AND created_at < $2)",
+			[]interface{}{"2026-01-31", "2026-02-01"},
+		},
+		{
+			"created_at!=2026-12-31", 0,
+			"(created_at < $1 OR created_at >= $2)",
+			[]interface{}{"2026-12-31", "2027-01-01"},
+		},
+		{"created_at>2026-02-28", 0, "created_at >= $1", []interface{}{"2026-03-01"}},
+		{"created_at<=2026-02-28", 0, "created_at < $1", []interface{}{"2026-03-01"}},
+		{"created_at<2026-02-28", 0, "created_at < $1", []interface{}{"2026-02-28"}},
+		{"created_at>=2026-03-01T10:00:00Z", 0, "created_at >= $1", []interface{}{"2026-03-01T10:00:00Z"}},
+	}
+	for _, tt := range tests {
+		got, args := mustParse(t, tt.src).SQL(tt.n)
+		if got != tt.want {
+			t.Errorf("Parse(%q).SQL(%d)\n got %s\nwant %s", tt.src, tt.n, got, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Parse(%q).SQL(%d) args = %q, want %q", tt.src, tt.n, args, tt.args)
+		}
+	}
+}
+
+func TestPostgREST(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"status=draft or status=sent and total>10", `or(status.eq."draft",and(status.eq."sent",total.gt."10"))`},
+		{"(status=draft or status=sent) and total>10", `and(or(status.eq."draft",status.eq."sent"),total.gt."10")`},
+		{"not status=draft", `not.and(status.eq."draft")`},
+		{"not (status=draft or total<=0)", `not.and(or(status.eq."draft",total.lte."0"))`},
+		// Reserved characters are quoted, quotes and backslashes escaped
+		{`notes="a,b.(c)"`, `notes.eq."a,b.(c)"`},
+		{`notes="say \"hi\" \\ bye"`, `notes.eq."say \"hi\" \\ bye"`},
+		{`notes='it\'s'`, `notes.eq."it's"`},
+		{`currency not in (IDR, "U,SD")`, `currency.not.in.("IDR","U,SD")`},
+		{"currency in (IDR)", `currency.in.("IDR")`},
+		{`notes~"50%"`, `notes.ilike."*50%*"`},
+		{"total!=1.5", `total.neq."1.5"`},
+		{"created_at=2026-01-31", `and(created_at.gte."2026-01-31",created_at.lt."2026-02-01")`},
+		{"created_at!=2026-01-31", `or(created_at.lt."2026-01-31",created_at.gte."2026-02-01")`},
+		{"created_at>2026-01-31", `created_at.gte."2026-02-01"`},
+		{"created_at<=2026-01-31", `created_at.lt."2026-02-01"`},
+		{"created_at>=2026-01-31", `created_at.gte."2026-01-31"`},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.src).PostgREST(); got != tt.want {
+			t.Errorf("Parse(%q).PostgREST()\n got %s\nwant %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src   string
+		pos   int
+		token string
+	}{
+		{"total>", 6, ""},
+		{"status=draft and", 16, ""},
+		{"(status=draft", 13, ""},
+		{"status=draft)", 12, ")"},
+		{"status=draft status=sent", 13, "status"},
+		{"bogus=1", 0, "bogus"},
+		{"and=1", 0, "and"},
+		{"total~5", 5, "~"},
+		{"total=abc", 6, "abc"},
+		{`notes="open`, 6, `"open`},
+		{"status!draft", 6, "!"},
+		{"customer_id>" + uuid1, 11, ">"},
+		{"customer_id=42", 12, "42"},
+		{"created_at in (2026-01-01)", 11, "in"},
+		{"created_at>yesterday", 11, "yesterday"},
+		{"currency in IDR", 12, "IDR"},
+		{"currency in (IDR USD)", 17, "USD"},
+		{"currency in (IDR,", 17, ""},
+		{"due_date=2026-13-01", 9, "2026-13-01"},
+		{"status=archived", 7, "archived"},
+		{"currency between 1", 9, "between"},
+		{"total>1 or (status=draft and (notes~x or notes=))", 47, ")"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src, testSchema)
+		var ferr *Error
+		if !errors.As(err, &ferr) {
+			t.Errorf("Parse(%q) error = %v, want *Error", tt.src, err)
+			continue
+		}
+		if ferr.Pos != tt.pos || ferr.Token != tt.token {
+			t.Errorf("Parse(%q) error at %d %q, want %d %q (%v)", tt.src, ferr.Pos, ferr.Token, tt.pos, tt.token, err)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := map[string]string{
+		"total=abc": `invalid filter at position 7 ("abc"): not a number`,
+		"total>":    "invalid filter at position 7: expected a value, found end of filter",
+	}
+	for src, want := range tests {
+		if _, err := Parse(src, testSchema); err == nil || err.Error() != want {
+			t.Errorf("Parse(%q) error = %v, want %s", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// token is a lexeme of a filter expression. pos is its byte offset.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// display is how a token is quoted in error messages
+func (t token) display() string {
+	if t.kind == tokEOF {
+		return "end of filter"
+	}
+	return `"` + t.text + `"`
+}
+
+// keyword reports whether t is the bare word kw, ignoring case
+func (t token) keyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+// operators, longest first so ">=" is not read as ">" "="
+var operators = []string{"!=", "<>", ">=", "<=", "=", ">", "<", "~"}
+
+// lex splits a filter expression into tokens. Words run until whitespace or
+// one of ( ) , = ! < > ~ " '; quoted strings may contain anything, with a
+// backslash escaping the quote character or another backslash.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(src) {
+				if src[i] == '\\' && i+1 < len(src) && (src[i+1] == c || src[i+1] == '\\') {
+					b.WriteByte(src[i+1])
+					i += 2
+					continue
+				}
+				if src[i] == c {
+					closed = true
+					i++
+					break
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			if !closed {
+				return nil, &Error{Pos: start, Token: src[start:], Msg: "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, b.String(), start})
+		default:
+			if op := matchOperator(src[i:]); op != "" {
+				tokens = append(tokens, token{tokOp, op, i})
+				i += len(op)
+				continue
+			}
+			if c == '!' {
+				return nil, &Error{Pos: i, Token: "!", Msg: `unexpected "!" (did you mean "!="?)`}
+			}
+			start := i
+			for i < len(src) && !strings.ContainsRune(" \t\n\r(),=!<>~\"'", rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, src[start:i], start})
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src)}), nil
+}
+
+func matchOperator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
@@ -1,6 +1,11 @@
 package invoice
 
 import (
+	"strconv"
+	"strings"
+
+	"invoice-backend/internal/money"
+
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
@@ -25,28 +30,27 @@ func GetCurrencySymbol(currencyCode string) string {
 	return currencyCode + " "
 }
 
-// FormatCurrency formats amount based on currency type
-func FormatCurrency(amount float64, currencyCode string) string {
-	// Currencies without decimals
-	noDecimalCurrencies := map[string]bool{
-		"IDR": true,
-		"JPY": true,
-		"CNY": true,
+// FormatCurrency formats amount with thousands separators and the number of
+// decimals the currency is rounded to (none for IDR and JPY, two for USD)
+func FormatCurrency(amount money.Amount, currencyCode string) string {
+	fixed := amount.StringFixed(money.Decimals(currencyCode))
+	sign := ""
+	if strings.HasPrefix(fixed, "-") {
+		sign, fixed = "-", fixed[1:]
 	}
-	
+	whole, frac, hasFrac := strings.Cut(fixed, ".")
+
 	p := message.NewPrinter(language.English)
-	
-	if noDecimalCurrencies[currencyCode] {
-		// Round and format without decimals
-		return p.Sprintf("%.0f", amount)
+	n, _ := strconv.ParseInt(whole, 10, 64)
+	formatted := sign + p.Sprintf("%d", n)
+	if hasFrac {
+		formatted += "." + frac
 	}
-	
-	// Format with 2 decimals
-	return p.Sprintf("%.2f", amount)
+	return formatted
 }
 
 // FormatCurrencyWithSymbol formats amount with currency symbol
-func FormatCurrencyWithSymbol(amount float64, currencyCode string) string {
+func FormatCurrencyWithSymbol(amount money.Amount, currencyCode string) string {
 	symbol := GetCurrencySymbol(currencyCode)
 	formatted := FormatCurrency(amount, currencyCode)
 	return symbol + formatted
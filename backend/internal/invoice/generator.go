@@ -3,6 +3,7 @@ package invoice
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jung-kurt/gofpdf"
@@ -14,7 +15,7 @@ func GeneratePDF(inv Invoice) ([]byte, error) {
 	pdf.AddPage()
 
 	// Header section
-	addHeader(pdf)
+	addHeader(pdf, inv.Company)
 
 	// Invoice title and line
 	pdf.SetDrawColor(25, 103, 210)
@@ -33,14 +34,19 @@ func GeneratePDF(inv Invoice) ([]byte, error) {
 	// From/To section
 	addFromToSection(pdf, inv)
 
-	// Items table - always uses USD in PDF
-	addItemsTable(pdf, inv.Items)
+	// Amounts are printed in the invoice currency
+	if inv.Currency == "" {
+		inv.Currency = "USD"
+	}
+
+	// Items table
+	addItemsTable(pdf, inv.Items, inv.Currency)
 
-	// Totals section - always uses USD in PDF
+	// Totals section
 	addTotalsSection(pdf, inv)
 
 	// Notes and footer
-	addNotesAndFooter(pdf)
+	addNotesAndFooter(pdf, inv.Company)
 
 	// Generate PDF bytes
 	var buf bytes.Buffer
@@ -86,17 +92,37 @@ func addFromToSection(pdf *gofpdf.Fpdf, inv Invoice) {
 	pdf.Ln(8)
 
 	// Company details (FROM)
+	company := inv.Company
+	name := company.LegalName
+	if name == "" {
+		name = company.Name
+	}
 	pdf.SetFont("Helvetica", "B", 11)
 	pdf.SetTextColor(44, 62, 80)
-	pdf.Cell(95, 6, "InvoicePro Systems")
+	pdf.Cell(95, 6, name)
 	pdf.Ln(6)
 
 	pdf.SetFont("Helvetica", "", 9)
 	pdf.SetTextColor(100, 100, 100)
 	startY := pdf.GetY()
 
+	companyLines := []string{company.Address}
+	if place := joinNonEmpty(", ", company.City, company.Country); place != "" || company.PostalCode != "" {
+		companyLines = append(companyLines, joinNonEmpty(" ", place, company.PostalCode))
+	}
+	if company.TaxID != "" {
+		companyLines = append(companyLines, "Tax ID: "+company.TaxID)
+	}
+	if company.Phone != "" {
+		companyLines = append(companyLines, "Phone: "+company.Phone)
+	}
+	if company.Email != "" {
+		companyLines = append(companyLines, "Email: "+company.Email)
+	}
+
 	pdf.SetX(20)
-	pdf.MultiCell(90, 5, "Jl. Merdeka No. 123\nJakarta, Indonesia 12345\nPhone: +62 812 3456 7890\nEmail: billing@invoicepro.com", "", "L", false)
+	pdf.MultiCell(90, 5, joinNonEmpty("\n", companyLines...), "", "L", false)
+	companyEndY := pdf.GetY()
 
 	// Customer details (BILL TO)
 	customerY := startY
@@ -125,21 +151,48 @@ func addFromToSection(pdf *gofpdf.Fpdf, inv Invoice) {
 	}
 
 	pdf.MultiCell(80, 5, addressLines, "", "L", false)
+	if pdf.GetY() < companyEndY {
+		pdf.SetY(companyEndY)
+	}
 	pdf.Ln(8)
 }
 
 // addNotesAndFooter adds notes section and page footer
-func addNotesAndFooter(pdf *gofpdf.Fpdf) {
+func addNotesAndFooter(pdf *gofpdf.Fpdf, c Company) {
 	pdf.SetTextColor(44, 62, 80)
 	pdf.SetFont("Helvetica", "B", 10)
 	pdf.Cell(0, 6, "Notes & Terms:")
 	pdf.Ln(6)
 
+	notes := []string{"Thank you for your business! Payment is due within 30 days of invoice date."}
+	if c.BankName != "" || c.BankAccountNumber != "" {
+		notes = append(notes, "Please make payment to:")
+		for _, line := range [][2]string{
+			{"Bank", c.BankName},
+			{"Account name", c.BankAccountName},
+			{"Account number", c.BankAccountNumber},
+			{"SWIFT/BIC", c.BankSwift},
+		} {
+			if line[1] != "" {
+				notes = append(notes, "    "+line[0]+": "+line[1])
+			}
+		}
+	} else {
+		notes = append(notes, "Please make payment to the bank details provided separately.")
+	}
+	if c.Email != "" {
+		notes = append(notes, "For inquiries, please contact us at "+c.Email)
+	}
+
 	pdf.SetFont("Helvetica", "", 9)
 	pdf.SetTextColor(100, 100, 100)
-	pdf.MultiCell(0, 5, "Thank you for your business! Payment is due within 30 days of invoice date.\nPlease make payment to the bank details provided separately.\nFor inquiries, please contact us at billing@invoicepro.com", "", "L", false)
+	pdf.MultiCell(0, 5, strings.Join(notes, "\n"), "", "L", false)
+
+	addFooter(pdf, c)
+}
 
-	// Bottom footer
+// addFooter adds the page footer
+func addFooter(pdf *gofpdf.Fpdf, c Company) {
 	pdf.SetY(-30)
 	pdf.SetFont("Helvetica", "", 8)
 	pdf.SetTextColor(150, 150, 150)
@@ -147,7 +200,18 @@ func addNotesAndFooter(pdf *gofpdf.Fpdf) {
 	pdf.Line(20, pdf.GetY()-5, 190, pdf.GetY()-5)
 
 	pdf.SetX(20)
-	pdf.Cell(0, 5, "InvoicePro Systems | www.invoicepro.com | billing@invoicepro.com")
+	pdf.Cell(0, 5, joinNonEmpty(" | ", c.Name, c.Website, c.Email))
 	pdf.Ln(5)
 	pdf.Cell(0, 5, fmt.Sprintf("Generated on %s | Page %d", time.Now().Format("02-01-2006 15:04"), pdf.PageNo()))
 }
+
+// joinNonEmpty joins the parts that are not empty with sep
+func joinNonEmpty(sep string, parts ...string) string {
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, sep)
+}
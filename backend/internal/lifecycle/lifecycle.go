@@ -0,0 +1,181 @@
+// Package lifecycle is the status state machine of an invoice, shared by
+// every codepath that creates, changes or pays invoices.
+//
+// An invoice starts as a draft, which is not yet owed. Issuing it makes it
+// payable, and sending it to the customer marks it sent. From then on its
+// payments move it: paying part of the total makes it partially_paid,
+// paying all of it makes it paid, and an invoice with a balance left after
+// its due date is overdue. An invoice that will not be paid is closed by
+// voiding it, when nothing was paid, or by writing off its balance. Paid,
+// void and written_off invoices are final.
+//
+// Credit notes lower the balance like payments do: an invoice whose
+// payments and credits cover its total is paid. A credited invoice is not
+// void; what is left of it is written off.
+//
+// Draft, issued, sent, void and written_off are set by hand (Transition);
+// partially_paid, paid and overdue follow from the payments, credits and
+// the due date (Settle) and cannot be.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+
+	"invoice-backend/internal/money"
+)
+
+// Invoice statuses
+const (
+	Draft         = "draft"
+	Issued        = "issued"
+	Sent          = "sent"
+	PartiallyPaid = "partially_paid"
+	Paid          = "paid"
+	Overdue       = "overdue"
+	Void          = "void"
+	WrittenOff    = "written_off"
+)
+
+// Statuses lists every invoice status in lifecycle order
+var Statuses = []string{Draft, Issued, Sent, PartiallyPaid, Paid, Overdue, Void, WrittenOff}
+
+var (
+	// ErrUnknownStatus is returned for a status that is not in Statuses
+	ErrUnknownStatus = errors.New("unknown invoice status")
+	// ErrNotAllowed is returned for a status change or payment the
+	// invoice's status does not allow
+	ErrNotAllowed = errors.New("not allowed by the invoice status")
+)
+
+// manual lists the statuses an invoice can be moved to by hand from each
+// status. Sending a sent, overdue or partially paid invoice again is
+// allowed; it only records when it was sent.
+var manual = map[string][]string{
+	Draft:         {Issued, Sent, Void},
+	Issued:        {Sent, Void, WrittenOff},
+	Sent:          {Sent, Void, WrittenOff},
+	Overdue:       {Sent, Void, WrittenOff},
+	PartiallyPaid: {Sent, WrittenOff},
+}
+
+// Invoice is what an invoice's status follows from
+type Invoice struct {
+	Status   string
+	Total    money.Amount
+	Paid     money.Amount
+	Credited money.Amount // Total of the invoice's credit notes
+	DueDate  string       // A date or timestamp; only the date counts
+	Sent     bool         // Whether the invoice was sent to the customer
+}
+
+// Valid reports whether status is an invoice status
+func Valid(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Initial checks the status an invoice is created with: a draft, or an
+// invoice issued or sent straight away. An empty status is a draft.
+func Initial(status string) (string, error) {
+	switch status {
+	case "":
+		return Draft, nil
+	case Draft, Issued, Sent:
+		return status, nil
+	}
+	if !Valid(status) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownStatus, status)
+	}
+	return "", fmt.Errorf("%w: a new invoice is %s, %s or %s, not %s", ErrNotAllowed, Draft, Issued, Sent, status)
+}
+
+// Transition checks that inv can be moved to status to by hand
+func Transition(inv Invoice, to string) error {
+	if !Valid(to) {
+		return fmt.Errorf("%w: %s", ErrUnknownStatus, to)
+	}
+	if to == Void && inv.Paid.Sign() > 0 {
+		return fmt.Errorf("%w: the invoice has payments; write it off instead of voiding it", ErrNotAllowed)
+	}
+	if to == Void && inv.Credited.Sign() > 0 {
+		return fmt.Errorf("%w: the invoice has credit notes; write it off instead of voiding it", ErrNotAllowed)
+	}
+	for _, s := range manual[inv.Status] {
+		if s == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the invoice is %s and cannot become %s", ErrNotAllowed, inv.Status, to)
+}
+
+// Payable checks that an invoice in status accepts payments
+func Payable(status string) error {
+	switch status {
+	case Issued, Sent, PartiallyPaid, Overdue:
+		return nil
+	case Draft:
+		return fmt.Errorf("%w: the invoice is a draft and does not accept payments; issue it first", ErrNotAllowed)
+	}
+	return fmt.Errorf("%w: the invoice is %s and does not accept payments", ErrNotAllowed, status)
+}
+
+// Creditable checks that an invoice in status accepts credit notes: only an
+// open invoice has a balance to credit
+func Creditable(status string) error {
+	switch status {
+	case Issued, Sent, PartiallyPaid, Overdue:
+		return nil
+	case Draft:
+		return fmt.Errorf("%w: the invoice is a draft; change it instead of crediting it", ErrNotAllowed)
+	}
+	return fmt.Errorf("%w: the invoice is %s and has no balance to credit", ErrNotAllowed, status)
+}
+
+// Settle returns the status inv has on the day today (YYYY-MM-DD) given its
+// payments, credits and due date. Draft, void and written_off invoices keep
+// their status. A balance past the due date makes an invoice overdue even
+// when part of it was paid or credited.
+func Settle(inv Invoice, today string) string {
+	switch inv.Status {
+	case Draft, Void, WrittenOff:
+		return inv.Status
+	}
+	switch {
+	case inv.Paid.Add(inv.Credited).Cmp(inv.Total) >= 0:
+		return Paid
+	case inv.DueDate != "" && date(inv.DueDate) < today:
+		return Overdue
+	case inv.Paid.Sign() > 0:
+		return PartiallyPaid
+	case inv.Sent:
+		return Sent
+	}
+	return Issued
+}
+
+// PaymentStatus returns the payment_status that goes with an invoice's
+// status and paid amount: unpaid, partially_paid, paid or overdue
+func PaymentStatus(status string, paid money.Amount) string {
+	switch {
+	case status == Paid:
+		return "paid"
+	case status == Overdue:
+		return "overdue"
+	case paid.Sign() > 0:
+		return "partially_paid"
+	}
+	return "unpaid"
+}
+
+// date returns the date part of a date or timestamp
+func date(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s
+}
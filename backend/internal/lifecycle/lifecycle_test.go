@@ -0,0 +1,168 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+
+	"invoice-backend/internal/money"
+)
+
+// TestTransition checks every edge of the manual transition matrix: each
+// status to each status, of an invoice without payments or credits
+func TestTransition(t *testing.T) {
+	allowed := map[string][]string{
+		Draft:         {Issued, Sent, Void},
+		Issued:        {Sent, Void, WrittenOff},
+		Sent:          {Sent, Void, WrittenOff},
+		PartiallyPaid: {Sent, WrittenOff},
+		Paid:          {},
+		Overdue:       {Sent, Void, WrittenOff},
+		Void:          {},
+		WrittenOff:    {},
+	}
+	if len(allowed) != len(Statuses) {
+		t.Fatalf("the matrix covers %d statuses, want %d", len(allowed), len(Statuses))
+	}
+	for _, from := range Statuses {
+		for _, to := range Statuses {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			err := Transition(Invoice{Status: from}, to)
+			switch {
+			case want && err != nil:
+				t.Errorf("%s -> %s: %v, want allowed", from, to, err)
+			case !want && !errors.Is(err, ErrNotAllowed):
+				t.Errorf("%s -> %s: err = %v, want ErrNotAllowed", from, to, err)
+			}
+		}
+	}
+}
+
+func TestTransitionVoid(t *testing.T) {
+	tests := []struct {
+		name string
+		inv  Invoice
+		to   string
+		ok   bool
+	}{
+		{"overdue with nothing paid", Invoice{Status: Overdue, Total: money.FromInt(10)}, Void, true},
+		{"paid in part", Invoice{Status: Sent, Total: money.FromInt(10), Paid: money.FromInt(1)}, Void, false},
+		{"credited in part", Invoice{Status: Sent, Total: money.FromInt(10), Credited: money.FromInt(1)}, Void, false},
+		{"paid in part, written off", Invoice{Status: PartiallyPaid, Total: money.FromInt(10), Paid: money.FromInt(1)}, WrittenOff, true},
+		{"credited in part, written off", Invoice{Status: Sent, Total: money.FromInt(10), Credited: money.FromInt(1)}, WrittenOff, true},
+	}
+	for _, tt := range tests {
+		err := Transition(tt.inv, tt.to)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("%s: err = %v, want ErrNotAllowed", tt.name, err)
+		}
+	}
+}
+
+func TestTransitionUnknown(t *testing.T) {
+	for _, to := range []string{"", "cancelled", "Paid"} {
+		if err := Transition(Invoice{Status: Draft}, to); !errors.Is(err, ErrUnknownStatus) {
+			t.Errorf("draft -> %q: err = %v, want ErrUnknownStatus", to, err)
+		}
+	}
+}
+
+func TestInitial(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"", Draft, nil},
+		{Draft, Draft, nil},
+		{Issued, Issued, nil},
+		{Sent, Sent, nil},
+		{PartiallyPaid, "", ErrNotAllowed},
+		{Paid, "", ErrNotAllowed},
+		{Overdue, "", ErrNotAllowed},
+		{Void, "", ErrNotAllowed},
+		{WrittenOff, "", ErrNotAllowed},
+		{"pending", "", ErrUnknownStatus},
+	}
+	for _, tt := range tests {
+		got, err := Initial(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Initial(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// TestPayableCreditable checks which statuses accept payments and credit
+// notes: the open ones, and no others
+func TestPayableCreditable(t *testing.T) {
+	open := map[string]bool{Issued: true, Sent: true, PartiallyPaid: true, Overdue: true}
+	for _, status := range Statuses {
+		for name, check := range map[string]func(string) error{"Payable": Payable, "Creditable": Creditable} {
+			err := check(status)
+			if open[status] && err != nil {
+				t.Errorf("%s(%s): %v", name, status, err)
+			}
+			if !open[status] && !errors.Is(err, ErrNotAllowed) {
+				t.Errorf("%s(%s): err = %v, want ErrNotAllowed", name, status, err)
+			}
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	const today = "2026-05-10"
+	tests := []struct {
+		name string
+		inv  Invoice
+		want string
+	}{
+		{"draft stays", Invoice{Status: Draft, Total: money.FromInt(10), DueDate: "2026-01-01"}, Draft},
+		{"void stays", Invoice{Status: Void, Total: money.FromInt(10), DueDate: "2026-01-01"}, Void},
+		{"written off stays", Invoice{Status: WrittenOff, Total: money.FromInt(10), Paid: money.FromInt(10)}, WrittenOff},
+		{"issued", Invoice{Status: Issued, Total: money.FromInt(10), DueDate: "2026-06-01"}, Issued},
+		{"sent", Invoice{Status: Issued, Total: money.FromInt(10), Sent: true}, Sent},
+		{"paid in part", Invoice{Status: Sent, Total: money.FromInt(10), Paid: money.FromInt(4), Sent: true}, PartiallyPaid},
+		{"paid in full", Invoice{Status: Sent, Total: money.FromInt(10), Paid: money.FromInt(10)}, Paid},
+		{"overpaid", Invoice{Status: Sent, Total: money.FromInt(10), Paid: money.FromInt(12)}, Paid},
+		{"paid and credited", Invoice{Status: PartiallyPaid, Total: money.FromInt(10), Paid: money.FromInt(6), Credited: money.FromInt(4)}, Paid},
+		{"credited only is not partially paid", Invoice{Status: Sent, Total: money.FromInt(10), Credited: money.FromInt(4), Sent: true}, Sent},
+		{"due today is not overdue", Invoice{Status: Sent, Total: money.FromInt(10), DueDate: today, Sent: true}, Sent},
+		{"past due", Invoice{Status: Sent, Total: money.FromInt(10), DueDate: "2026-05-09T23:59:59Z"}, Overdue},
+		{"past due, paid in part", Invoice{Status: PartiallyPaid, Total: money.FromInt(10), Paid: money.FromInt(4), DueDate: "2026-05-09"}, Overdue},
+		{"past due, paid in full", Invoice{Status: Overdue, Total: money.FromInt(10), Paid: money.FromInt(10), DueDate: "2026-05-09"}, Paid},
+		{"refunded back to open", Invoice{Status: Paid, Total: money.FromInt(10), Paid: money.FromInt(3)}, PartiallyPaid},
+		{"refunded in full", Invoice{Status: Paid, Total: money.FromInt(10)}, Issued},
+	}
+	for _, tt := range tests {
+		if got := Settle(tt.inv, today); got != tt.want {
+			t.Errorf("%s: Settle = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		paid   money.Amount
+		want   string
+	}{
+		{Paid, money.FromInt(10), "paid"},
+		{Overdue, 0, "overdue"},
+		{Overdue, money.FromInt(4), "overdue"},
+		{PartiallyPaid, money.FromInt(4), "partially_paid"},
+		{WrittenOff, money.FromInt(4), "partially_paid"},
+		{Sent, 0, "unpaid"},
+		{Draft, 0, "unpaid"},
+		{Void, 0, "unpaid"},
+	}
+	for _, tt := range tests {
+		if got := PaymentStatus(tt.status, tt.paid); got != tt.want {
+			t.Errorf("PaymentStatus(%s, %s) = %s, want %s", tt.status, tt.paid, got, tt.want)
+		}
+	}
+}
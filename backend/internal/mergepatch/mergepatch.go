@@ -0,0 +1,118 @@
+// Package mergepatch applies JSON Merge Patches (RFC 7396) to the editable
+// fields of a record.
+//
+// A patch is a JSON object naming the fields to change: a field set to a
+// value is replaced, a field set to null is cleared, and a field the patch
+// leaves out keeps its value. Nested objects are merged the same way.
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ContentType is the media type of a merge patch
+const ContentType = "application/merge-patch+json"
+
+// ErrInvalid is returned for a patch that is not a JSON object, names a field
+// the target does not have, or gives a field a value of the wrong type
+var ErrInvalid = errors.New("invalid merge patch")
+
+// ErrUnsupportedMediaType is returned by Read for a request body that is
+// neither a merge patch nor plain JSON
+var ErrUnsupportedMediaType = errors.New("unsupported media type: send " + ContentType)
+
+// Read reads a merge patch from the body of r. Plain application/json is
+// accepted too, and read as a merge patch.
+func Read(r *http.Request) ([]byte, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != ContentType && mediaType != "application/json") {
+			return nil, ErrUnsupportedMediaType
+		}
+	}
+	var patch bytes.Buffer
+	if _, err := patch.ReadFrom(r.Body); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// Apply applies patch to target, a pointer to a struct holding the current
+// values of the fields the patch may change. A field cleared with null
+// becomes its zero value.
+func Apply(target interface{}, patch []byte) error {
+	changes, err := object(patch)
+	if err != nil || changes == nil {
+		return fmt.Errorf("%w: the patch must be a JSON object", ErrInvalid)
+	}
+
+	raw, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	doc, err := object(raw)
+	if err != nil {
+		return err
+	}
+
+	merged, err := json.Marshal(merge(doc, changes))
+	if err != nil {
+		return err
+	}
+
+	// Decode into a zeroed target so cleared fields become zero values, and
+	// refuse fields the target does not have
+	v := reflect.ValueOf(target).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(target); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("%w: %s cannot be a %s", ErrInvalid, typeErr.Field, typeErr.Value)
+		}
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
+// object decodes a JSON object, keeping numbers as written so amounts do not
+// pass through float64
+func object(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data after the object")
+	}
+	return obj, nil
+}
+
+// merge applies the patch object to doc following RFC 7396
+func merge(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(doc, key)
+		case map[string]interface{}:
+			current, _ := doc[key].(map[string]interface{})
+			doc[key] = merge(current, v)
+		default:
+			doc[key] = v
+		}
+	}
+	return doc
+}
@@ -0,0 +1,157 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMerge runs the examples of RFC 7396, appendix A, that patch an object
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		// null deletes a field
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		// arrays are replaced, never merged
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":[1,2,3]}`, `{"a":[]}`, `{"a":[]}`},
+		{`{"a":[{"b":"c","d":"e"}]}`, `{"a":[{"b":null}]}`, `{"a":[{"b":null}]}`},
+		// nested objects merge
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":{"b":"c","d":"e"}}`, `{"a":{"d":null,"f":"g"}}`, `{"a":{"b":"c","f":"g"}}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"a":"b"}`, `{"a":{"c":"d"}}`, `{"a":{"c":"d"}}`},
+		{`{"a":{"b":1}}`, `{}`, `{"a":{"b":1}}`},
+	}
+	for _, tt := range tests {
+		doc, err := object([]byte(tt.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch, err := object([]byte(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(merge(doc, patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("merge(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+type address struct {
+	Street string `json:"street"`
+	City   string `json:"city"`
+}
+
+type record struct {
+	Name    string   `json:"name"`
+	Notes   *string  `json:"notes"`
+	Total   float64  `json:"total"`
+	Tags    []string `json:"tags"`
+	Address address  `json:"address"`
+}
+
+func TestApply(t *testing.T) {
+	notes := "keep"
+	current := func() record {
+		return record{
+			Name:    "Acme",
+			Notes:   &notes,
+			Total:   12.5,
+			Tags:    []string{"a", "b"},
+			Address: address{Street: "Jl. Sudirman 1", City: "Jakarta"},
+		}
+	}
+	tests := []struct {
+		name  string
+		patch string
+		want  func(*record)
+	}{
+		{"empty patch keeps everything", `{}`, func(*record) {}},
+		{"value replaces", `{"name":"Acme Ltd","total":7}`, func(r *record) { r.Name = "Acme Ltd"; r.Total = 7 }},
+		{"null clears to the zero value", `{"notes":null,"name":null}`, func(r *record) { r.Notes = nil; r.Name = "" }},
+		{"array is replaced", `{"tags":["c"]}`, func(r *record) { r.Tags = []string{"c"} }},
+		{"empty array is replaced", `{"tags":[]}`, func(r *record) { r.Tags = []string{} }},
+		{"null clears an array", `{"tags":null}`, func(r *record) { r.Tags = nil }},
+		{"nested object merges", `{"address":{"city":"Bandung"}}`, func(r *record) { r.Address.City = "Bandung" }},
+		{"null inside a nested object", `{"address":{"street":null}}`, func(r *record) { r.Address.Street = "" }},
+		{"null clears a nested object", `{"address":null}`, func(r *record) { r.Address = address{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := current()
+			if err := Apply(&got, []byte(tt.patch)); err != nil {
+				t.Fatal(err)
+			}
+			want := current()
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Apply(%s) = %+v, want %+v", tt.patch, got, want)
+			}
+		})
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`null`,
+		`[]`,
+		`"name"`,
+		`{"name":"a"} {}`,
+		`{"unknown":1}`,
+		`{"address":{"zip":"40111"}}`,
+		`{"total":"ten"}`,
+		`{"tags":"a"}`,
+		`{"address":"Jakarta"}`,
+	}
+	for _, patch := range tests {
+		r := record{Name: "Acme"}
+		if err := Apply(&r, []byte(patch)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Apply(%s) error = %v, want ErrInvalid", patch, err)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		contentType string
+		err         error
+	}{
+		{"", nil},
+		{ContentType, nil},
+		{"application/merge-patch+json; charset=utf-8", nil},
+		{"application/json", nil},
+		{"application/json-patch+json", ErrUnsupportedMediaType},
+		{"text/plain", ErrUnsupportedMediaType},
+		{"not a media type", ErrUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PATCH", "/invoices/1", strings.NewReader(`{"a":1}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		patch, err := Read(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Read(%q) error = %v, want %v", tt.contentType, err, tt.err)
+			continue
+		}
+		if err == nil && string(patch) != `{"a":1}` {
+			t.Errorf("Read(%q) = %s", tt.contentType, patch)
+		}
+	}
+}
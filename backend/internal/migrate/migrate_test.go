@@ -0,0 +1,235 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// queryRows runs a query and returns its rows as text, NULL as ""
+func queryRows(t *testing.T, db *sql.DB, query string, args ...interface{}) [][]string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+
+	var result [][]string
+	for rows.Next() {
+		row := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatalf("read schema: %v", err)
+		}
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = v.String
+		}
+		result = append(result, values)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	return result
+}
+
+// sqliteSchema describes every table, index, view and trigger of the
+// database but schema_migrations: the columns, foreign keys and indexes of
+// tables, and the names of views and triggers. It describes what the schema
+// is rather than the SQL that created it, which ALTER TABLE rewrites, and
+// lists columns by name, since a column added back by a down comes last.
+func sqliteSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var b strings.Builder
+	objects := queryRows(t, db, `
+		SELECT type, name, tbl_name FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND tbl_name <> 'schema_migrations'
+		ORDER BY type, name`)
+	for _, o := range objects {
+		typ, name, table := o[0], o[1], o[2]
+		fmt.Fprintf(&b, "%s %s on %s\n", typ, name, table)
+		switch typ {
+		case "table":
+			columns := queryRows(t, db, `
+				SELECT name, type, "notnull", COALESCE(dflt_value, ''), pk FROM pragma_table_info(?) ORDER BY name`, name)
+			for _, c := range columns {
+				fmt.Fprintf(&b, "  column %s %s notnull=%s default=%s pk=%s\n", c[0], c[1], c[2], c[3], c[4])
+			}
+			keys := queryRows(t, db, `
+				SELECT "table", "from", "to", on_update, on_delete FROM pragma_foreign_key_list(?) ORDER BY "from"`, name)
+			for _, fk := range keys {
+				fmt.Fprintf(&b, "  foreign key %s -> %s(%s) on update %s on delete %s\n", fk[1], fk[0], fk[2], fk[3], fk[4])
+			}
+		case "index":
+			for _, c := range queryRows(t, db, `SELECT name FROM pragma_index_info(?) ORDER BY seqno`, name) {
+				fmt.Fprintf(&b, "  on %s\n", c[0])
+			}
+			for _, i := range queryRows(t, db, `SELECT "unique", partial FROM pragma_index_list(?) WHERE name = ?`, table, name) {
+				fmt.Fprintf(&b, "  unique=%s partial=%s\n", i[0], i[1])
+			}
+		}
+	}
+	return b.String()
+}
+
+// postgresSchema describes the public schema but schema_migrations: the
+// columns of tables and views by name, indexes, constraints, triggers and
+// function signatures
+func postgresSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var b strings.Builder
+	sections := []string{
+		`SELECT table_name, column_name, data_type, is_nullable, COALESCE(column_default, '')
+		FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name <> 'schema_migrations'
+		ORDER BY table_name, column_name`,
+		`SELECT tablename, indexname, indexdef FROM pg_indexes
+		WHERE schemaname = 'public' AND tablename <> 'schema_migrations'
+		ORDER BY tablename, indexname`,
+		`SELECT conrelid::regclass::text, conname, pg_get_constraintdef(oid) FROM pg_constraint
+		WHERE connamespace = 'public'::regnamespace AND conrelid <> 0 AND conrelid::regclass::text <> 'schema_migrations'
+		ORDER BY 1, 2`,
+		`SELECT tgrelid::regclass::text, tgname FROM pg_trigger
+		WHERE NOT tgisinternal AND tgrelid::regclass::text NOT LIKE 'pg_%'
+		ORDER BY 1, 2`,
+		`SELECT p.proname, pg_get_function_identity_arguments(p.oid) FROM pg_proc p
+		WHERE p.pronamespace = 'public'::regnamespace
+		ORDER BY 1, 2`,
+	}
+	for _, q := range sections {
+		for _, row := range queryRows(t, db, q) {
+			b.WriteString(strings.Join(row, " ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// sqliteDrift maps what a SQLite down cannot restore exactly to what it
+// restores. SQLite cannot add a NOT NULL column without a default, so
+// 0009_invoice_items puts invoices.items back with DEFAULT '[]'.
+var sqliteDrift = strings.NewReplacer(
+	"column items TEXT notnull=1 default='[]'", "column items TEXT notnull=1 default=",
+)
+
+// roundTrip applies the migrations one at a time, then rolls them back one
+// at a time, checking that each down restores the schema its up started
+// from (as describe reads it, with drift replaced), and applies them all
+// again
+func roundTrip(t *testing.T, db *sql.DB, dialect string, describe func(*testing.T, *sql.DB) string, drift *strings.Replacer) {
+	m, err := New(db, dialect)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	before := []string{describe(t, db)}
+	for _, mig := range m.migrations {
+		done, err := m.Up(1)
+		if err != nil {
+			t.Fatalf("up: %v", err)
+		}
+		if len(done) != 1 || done[0].Version != mig.Version {
+			t.Fatalf("up applied %v, want %04d_%s", done, mig.Version, mig.Name)
+		}
+		before = append(before, describe(t, db))
+	}
+	up := before[len(before)-1]
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("%04d_%s is not applied", s.Version, s.Name)
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, err := m.Down(1); err != nil {
+			t.Fatalf("down: %v", err)
+		}
+		if got := drift.Replace(describe(t, db)); got != before[i] {
+			t.Errorf("down %04d_%s left a schema other than the one its up started from:\n%s",
+				mig.Version, mig.Name, diffLines(before[i], got))
+		}
+	}
+
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+	if got := describe(t, db); got != up {
+		t.Errorf("applying the migrations again gave another schema:\n%s", diffLines(up, got))
+	}
+}
+
+// diffLines lists the lines only a has, prefixed with -, and then those only
+// b has, prefixed with +
+func diffLines(a, b string) string {
+	lines := func(s string) map[string]bool {
+		set := make(map[string]bool)
+		for _, l := range strings.Split(s, "\n") {
+			set[l] = true
+		}
+		return set
+	}
+	inA, inB := lines(a), lines(b)
+	var diff []string
+	for _, l := range strings.Split(a, "\n") {
+		if !inB[l] {
+			diff = append(diff, "- "+l)
+		}
+	}
+	for _, l := range strings.Split(b, "\n") {
+		if !inA[l] {
+			diff = append(diff, "+ "+l)
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "migrate.db")+"?_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	if got := sqliteSchema(t, db); got != "" {
+		t.Fatalf("new database has a schema:\n%s", got)
+	}
+	roundTrip(t, db, SQLite, sqliteSchema, sqliteDrift)
+}
+
+// TestPostgresRoundTrip runs the Postgres migrations against the empty
+// database TEST_DATABASE_URL names, and is skipped without it
+func TestPostgresRoundTrip(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	if got := postgresSchema(t, db); got != "" {
+		t.Fatalf("TEST_DATABASE_URL must name an empty database; it has:\n%s", got)
+	}
+	roundTrip(t, db, Postgres, postgresSchema, strings.NewReplacer())
+}
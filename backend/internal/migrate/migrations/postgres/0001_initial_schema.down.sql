@@ -0,0 +1,13 @@
+-- Drops the whole schema created by 0001_initial_schema.up.sql, data included
+DROP TRIGGER IF EXISTS trigger_update_payment_status ON invoices;
+DROP FUNCTION IF EXISTS update_payment_status();
+
+DROP VIEW IF EXISTS payment_summary;
+DROP VIEW IF EXISTS invoice_analytics;
+
+DROP TABLE IF EXISTS currency_rates;
+DROP TABLE IF EXISTS payment_reminders;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS company_info;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS customers;
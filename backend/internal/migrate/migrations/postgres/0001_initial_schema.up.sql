@@ -0,0 +1,241 @@
+-- =====================================================
+-- INITIAL SCHEMA
+-- Consolidates setup_database.sql, add_tax_discount.sql,
+-- add_filter_currency_features.sql and add_advanced_features.sql.
+-- Written with IF NOT EXISTS throughout so it can also be applied to a
+-- database that was set up by hand from those scripts.
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS customers (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT DEFAULT 'Indonesia',
+    company_name TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    subtotal DECIMAL(10,2) NOT NULL,
+    tax DECIMAL(10,2) DEFAULT 0,
+    discount DECIMAL(10,2) DEFAULT 0,
+    total DECIMAL(10,2) NOT NULL,
+    items JSONB NOT NULL,
+    pdf_url TEXT,
+    status TEXT DEFAULT 'pending',
+    notes TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    due_date TIMESTAMP WITH TIME ZONE
+);
+
+-- Columns added after the first release (add_tax_discount.sql,
+-- add_filter_currency_features.sql, add_advanced_features.sql)
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS subtotal DECIMAL(10,2),
+    ADD COLUMN IF NOT EXISTS tax DECIMAL(10,2) DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS discount DECIMAL(10,2) DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS invoice_number VARCHAR(50) UNIQUE,
+    ADD COLUMN IF NOT EXISTS payment_status VARCHAR(20) DEFAULT 'unpaid',
+    ADD COLUMN IF NOT EXISTS paid_amount DECIMAL(20,2) DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS payment_date TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS last_reminder_sent TIMESTAMP;
+
+UPDATE invoices SET subtotal = total WHERE subtotal IS NULL;
+ALTER TABLE invoices ALTER COLUMN subtotal SET NOT NULL;
+
+UPDATE invoices
+SET payment_status = CASE
+    WHEN status = 'paid' THEN 'paid'
+    WHEN due_date < NOW() THEN 'overdue'
+    ELSE 'unpaid'
+END
+WHERE payment_status IS NULL;
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS check_payment_status;
+ALTER TABLE invoices
+ADD CONSTRAINT check_payment_status
+CHECK (payment_status IN ('unpaid', 'partially_paid', 'paid', 'overdue'));
+
+CREATE TABLE IF NOT EXISTS company_info (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT,
+    website TEXT,
+    tax_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_id UUID REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,2) NOT NULL,
+    payment_method VARCHAR(50) NOT NULL,
+    payment_date TIMESTAMP NOT NULL DEFAULT NOW(),
+    reference_number VARCHAR(100),
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    created_by VARCHAR(100)
+);
+
+CREATE TABLE IF NOT EXISTS payment_reminders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_id UUID REFERENCES invoices(id) ON DELETE CASCADE,
+    reminder_type VARCHAR(50) NOT NULL, -- 'before_due', 'on_due', 'after_due'
+    days_offset INTEGER NOT NULL, -- -3, 0, 3, 7, etc.
+    sent_at TIMESTAMP,
+    status VARCHAR(20) DEFAULT 'pending', -- 'pending', 'sent', 'failed'
+    email_to VARCHAR(255),
+    error_message TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS currency_rates (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    from_currency VARCHAR(3) NOT NULL,
+    to_currency VARCHAR(3) NOT NULL,
+    rate DECIMAL(20,6) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(from_currency, to_currency)
+);
+
+-- =====================================================
+-- INDEXES
+-- =====================================================
+CREATE INDEX IF NOT EXISTS idx_customers_email ON customers(email);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_customer_id ON invoices(customer_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_created_at ON invoices(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
+CREATE INDEX IF NOT EXISTS idx_invoices_currency ON invoices(currency);
+CREATE INDEX IF NOT EXISTS idx_invoices_due_date ON invoices(due_date);
+CREATE INDEX IF NOT EXISTS idx_invoices_invoice_number ON invoices(invoice_number);
+CREATE INDEX IF NOT EXISTS idx_invoices_payment_status ON invoices(payment_status);
+CREATE INDEX IF NOT EXISTS idx_payments_invoice_id ON payments(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_payments_payment_date ON payments(payment_date);
+CREATE INDEX IF NOT EXISTS idx_payment_reminders_invoice_id ON payment_reminders(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_payment_reminders_status ON payment_reminders(status);
+CREATE INDEX IF NOT EXISTS idx_currency_rates_pair ON currency_rates(from_currency, to_currency);
+
+-- =====================================================
+-- VIEWS
+-- =====================================================
+CREATE OR REPLACE VIEW invoice_analytics AS
+SELECT
+    DATE_TRUNC('day', created_at) as date,
+    COUNT(*) as invoice_count,
+    SUM(total) as total_amount,
+    SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END) as paid_amount,
+    SUM(CASE WHEN payment_status = 'unpaid' THEN total ELSE 0 END) as unpaid_amount,
+    SUM(CASE WHEN payment_status = 'overdue' THEN total ELSE 0 END) as overdue_amount,
+    currency
+FROM invoices
+GROUP BY DATE_TRUNC('day', created_at), currency;
+
+CREATE OR REPLACE VIEW payment_summary AS
+SELECT
+    i.id as invoice_id,
+    i.invoice_number,
+    i.total,
+    i.paid_amount,
+    i.total - i.paid_amount as outstanding_amount,
+    i.payment_status,
+    i.due_date,
+    c.name as customer_name,
+    c.email as customer_email,
+    CASE
+        WHEN i.due_date < NOW() AND i.payment_status != 'paid' THEN 'overdue'
+        ELSE i.payment_status
+    END as current_status
+FROM invoices i
+LEFT JOIN customers c ON i.customer_id = c.id;
+
+-- =====================================================
+-- TRIGGERS
+-- =====================================================
+CREATE OR REPLACE FUNCTION update_payment_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.due_date < NOW() AND NEW.payment_status NOT IN ('paid', 'partially_paid') THEN
+        NEW.payment_status := 'overdue';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_update_payment_status ON invoices;
+CREATE TRIGGER trigger_update_payment_status
+BEFORE INSERT OR UPDATE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION update_payment_status();
+
+-- =====================================================
+-- ROW LEVEL SECURITY (allow all operations for development)
+-- =====================================================
+ALTER TABLE customers ENABLE ROW LEVEL SECURITY;
+ALTER TABLE invoices ENABLE ROW LEVEL SECURITY;
+ALTER TABLE company_info ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payment_reminders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE currency_rates ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Allow all operations on customers" ON customers;
+CREATE POLICY "Allow all operations on customers" ON customers FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on invoices" ON invoices;
+CREATE POLICY "Allow all operations on invoices" ON invoices FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on company_info" ON company_info;
+CREATE POLICY "Allow all operations on company_info" ON company_info FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on payments" ON payments;
+CREATE POLICY "Allow all operations on payments" ON payments FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on payment_reminders" ON payment_reminders;
+CREATE POLICY "Allow all operations on payment_reminders" ON payment_reminders FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on currency_rates" ON currency_rates;
+CREATE POLICY "Allow all operations on currency_rates" ON currency_rates FOR ALL USING (true);
+
+-- =====================================================
+-- DEFAULT DATA
+-- =====================================================
+-- Exchange rates (base currency: USD)
+INSERT INTO currency_rates (from_currency, to_currency, rate) VALUES
+    ('USD', 'USD', 1.000000),
+    ('USD', 'IDR', 15800.000000),
+    ('USD', 'EUR', 0.920000),
+    ('USD', 'GBP', 0.790000),
+    ('USD', 'JPY', 148.500000),
+    ('USD', 'CNY', 7.240000),
+    ('USD', 'SGD', 1.340000),
+    ('USD', 'MYR', 4.720000),
+    ('IDR', 'USD', 0.000063),
+    ('IDR', 'IDR', 1.000000),
+    ('IDR', 'EUR', 0.000058),
+    ('IDR', 'GBP', 0.000050),
+    ('EUR', 'USD', 1.087000),
+    ('EUR', 'IDR', 17174.000000),
+    ('EUR', 'EUR', 1.000000),
+    ('EUR', 'GBP', 0.859000),
+    ('GBP', 'USD', 1.266000),
+    ('GBP', 'IDR', 20003.000000),
+    ('GBP', 'EUR', 1.164000),
+    ('GBP', 'GBP', 1.000000)
+ON CONFLICT (from_currency, to_currency) DO NOTHING;
+
+COMMENT ON TABLE payments IS 'Stores payment history for invoices - supports partial payments';
+COMMENT ON TABLE payment_reminders IS 'Tracks payment reminder emails sent to customers';
+COMMENT ON TABLE currency_rates IS 'Exchange rates for currency conversion';
+COMMENT ON COLUMN invoices.currency IS 'Currency code (ISO 4217) for the invoice';
+COMMENT ON COLUMN invoices.invoice_number IS 'Unique invoice number (e.g., INV-2026-0001)';
+COMMENT ON COLUMN invoices.payment_status IS 'Current payment status: unpaid, partially_paid, paid, overdue';
+COMMENT ON COLUMN invoices.paid_amount IS 'Total amount paid so far (for partial payments)';
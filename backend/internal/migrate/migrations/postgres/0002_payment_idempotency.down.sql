@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS record_payment(UUID, DECIMAL, TEXT, TIMESTAMP, TEXT, TEXT, TEXT, TEXT);
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+ALTER TABLE payments DROP COLUMN IF EXISTS idempotency_key;
@@ -0,0 +1,78 @@
+-- ============================================
+-- Atomic & Idempotent Payment Recording
+-- ============================================
+
+-- 1. Idempotency key supplied by the client (Idempotency-Key header).
+--    Unique so a retried request can never insert a second payment.
+ALTER TABLE payments
+ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency_key
+ON payments(idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- 2. record_payment inserts a payment and updates the invoice's paid_amount
+--    and payment_status in one transaction. The invoice row is locked first so
+--    concurrent payments on the same invoice are applied one after another.
+--    Called through PostgREST (POST /rest/v1/rpc/record_payment) by the
+--    Supabase backend and the payment service.
+--
+--    Errors use PostgREST's PTxxx codes so they map to HTTP statuses:
+--      PT404 - invoice does not exist
+--      PT409 - idempotency key already used for a different payment
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_paid DECIMAL;
+    v_status TEXT;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    v_paid := COALESCE(v_invoice.paid_amount, 0) + p_amount;
+    v_status := 'partially_paid';
+    IF v_paid >= v_invoice.total THEN
+        v_status := 'paid';
+        v_paid := v_invoice.total;
+    END IF;
+
+    UPDATE invoices
+    SET paid_amount = v_paid,
+        payment_status = v_status,
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON COLUMN payments.idempotency_key IS 'Client-supplied Idempotency-Key; retries with the same key return the original payment';
+COMMENT ON FUNCTION record_payment IS 'Records a payment and updates the invoice payment status atomically';
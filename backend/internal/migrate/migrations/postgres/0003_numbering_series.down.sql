@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS trigger_assign_receipt_number ON payments;
+DROP TRIGGER IF EXISTS trigger_assign_invoice_number ON invoices;
+DROP FUNCTION IF EXISTS assign_receipt_number();
+DROP FUNCTION IF EXISTS assign_invoice_number();
+DROP FUNCTION IF EXISTS next_document_number(TEXT, UUID);
+
+ALTER TABLE payments DROP COLUMN IF EXISTS receipt_number;
+
+DROP TABLE IF EXISTS document_numbers;
+DROP TABLE IF EXISTS number_series_counters;
+DROP TABLE IF EXISTS number_series;
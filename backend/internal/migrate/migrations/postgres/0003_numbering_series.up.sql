@@ -0,0 +1,160 @@
+-- ============================================
+-- Document Numbering Series
+-- Replaces the count-based invoice numbers and the invoice_settings counter
+-- with per-series, per-period counters that are incremented in the same
+-- transaction as the document insert.
+-- ============================================
+
+-- The invoice_settings counter and generate_invoice_number() from the old
+-- add_advanced_features.sql script are superseded
+DROP FUNCTION IF EXISTS generate_invoice_number();
+DROP TABLE IF EXISTS invoice_settings;
+
+-- 1. Series configuration. Pattern tokens: {PREFIX} {YEAR} {YY} {MONTH} {NUMBER}
+CREATE TABLE IF NOT EXISTS number_series (
+    series_key VARCHAR(50) PRIMARY KEY,
+    prefix VARCHAR(20) NOT NULL DEFAULT '',
+    pattern VARCHAR(100) NOT NULL,
+    reset VARCHAR(10) NOT NULL DEFAULT 'yearly' CHECK (reset IN ('never', 'yearly', 'monthly')),
+    padding INTEGER NOT NULL DEFAULT 4 CHECK (padding BETWEEN 1 AND 12),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO number_series (series_key, prefix, pattern, reset, padding) VALUES
+    ('invoice', 'INV', '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4),
+    ('credit_note', 'CN', '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4),
+    ('receipt', 'RCP', '{PREFIX}-{YEAR}{MONTH}-{NUMBER}', 'monthly', 4)
+ON CONFLICT (series_key) DO NOTHING;
+
+-- 2. One counter per series and period ('' for never, '2026' for yearly,
+--    '2026-01' for monthly). The upsert in next_document_number row-locks the
+--    counter until the surrounding transaction ends.
+CREATE TABLE IF NOT EXISTS number_series_counters (
+    series_key VARCHAR(50) NOT NULL REFERENCES number_series(series_key),
+    period VARCHAR(7) NOT NULL,
+    last_value INTEGER NOT NULL,
+    PRIMARY KEY (series_key, period)
+);
+
+-- 3. Log of every number handed out, used by the gap audit
+CREATE TABLE IF NOT EXISTS document_numbers (
+    series_key VARCHAR(50) NOT NULL REFERENCES number_series(series_key),
+    period VARCHAR(7) NOT NULL,
+    sequence INTEGER NOT NULL,
+    number VARCHAR(50) NOT NULL,
+    document_id UUID,
+    issued_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (series_key, period, sequence),
+    UNIQUE (series_key, number)
+);
+
+-- 4. Receipt numbers for payments
+ALTER TABLE payments
+ADD COLUMN IF NOT EXISTS receipt_number VARCHAR(50) UNIQUE;
+
+-- 5. Allocate the next number of a series. Must run inside the transaction
+--    that inserts the document so a rollback also gives the number back.
+CREATE OR REPLACE FUNCTION next_document_number(p_series TEXT, p_document_id UUID)
+RETURNS TEXT AS $$
+DECLARE
+    v_series number_series%ROWTYPE;
+    v_period TEXT;
+    v_seq INTEGER;
+    v_number TEXT;
+BEGIN
+    SELECT * INTO v_series FROM number_series WHERE series_key = p_series;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'number series % not found', p_series USING ERRCODE = 'PT404';
+    END IF;
+
+    v_period := CASE v_series.reset
+        WHEN 'yearly' THEN TO_CHAR(NOW(), 'YYYY')
+        WHEN 'monthly' THEN TO_CHAR(NOW(), 'YYYY-MM')
+        ELSE ''
+    END;
+
+    INSERT INTO number_series_counters (series_key, period, last_value)
+    VALUES (p_series, v_period, 1)
+    ON CONFLICT (series_key, period)
+    DO UPDATE SET last_value = number_series_counters.last_value + 1
+    RETURNING last_value INTO v_seq;
+
+    v_number := v_series.pattern;
+    v_number := REPLACE(v_number, '{PREFIX}', v_series.prefix);
+    v_number := REPLACE(v_number, '{YEAR}', TO_CHAR(NOW(), 'YYYY'));
+    v_number := REPLACE(v_number, '{YY}', TO_CHAR(NOW(), 'YY'));
+    v_number := REPLACE(v_number, '{MONTH}', TO_CHAR(NOW(), 'MM'));
+    v_number := REPLACE(v_number, '{NUMBER}', LPAD(v_seq::TEXT, v_series.padding, '0'));
+
+    INSERT INTO document_numbers (series_key, period, sequence, number, document_id)
+    VALUES (p_series, v_period, v_seq, v_number, p_document_id);
+
+    RETURN v_number;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 6. Number invoices and payments on insert when the caller did not, so the
+--    PostgREST clients (Supabase backend, invoice and payment services) get
+--    gap-free numbers without a separate round trip.
+CREATE OR REPLACE FUNCTION assign_invoice_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.invoice_number IS NULL OR NEW.invoice_number = '' THEN
+        NEW.invoice_number := next_document_number('invoice', NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_assign_invoice_number ON invoices;
+CREATE TRIGGER trigger_assign_invoice_number
+BEFORE INSERT ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION assign_invoice_number();
+
+CREATE OR REPLACE FUNCTION assign_receipt_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.receipt_number IS NULL OR NEW.receipt_number = '' THEN
+        NEW.receipt_number := next_document_number('receipt', NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_assign_receipt_number ON payments;
+CREATE TRIGGER trigger_assign_receipt_number
+BEFORE INSERT ON payments
+FOR EACH ROW
+EXECUTE FUNCTION assign_receipt_number();
+
+-- 7. Carry existing INV-YYYY-NNNN numbers over so the counters continue
+--    after them and the audit sees them as issued
+INSERT INTO document_numbers (series_key, period, sequence, number, document_id, issued_at)
+SELECT 'invoice', SUBSTRING(invoice_number FROM 5 FOR 4), SUBSTRING(invoice_number FROM 10)::INTEGER,
+       invoice_number, id, created_at
+FROM invoices
+WHERE invoice_number ~ '^INV-[0-9]{4}-[0-9]+$'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO number_series_counters (series_key, period, last_value)
+SELECT series_key, period, MAX(sequence)
+FROM document_numbers
+GROUP BY series_key, period
+ON CONFLICT (series_key, period)
+DO UPDATE SET last_value = GREATEST(number_series_counters.last_value, EXCLUDED.last_value);
+
+-- 8. RLS, matching the other tables
+ALTER TABLE number_series ENABLE ROW LEVEL SECURITY;
+ALTER TABLE number_series_counters ENABLE ROW LEVEL SECURITY;
+ALTER TABLE document_numbers ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Allow all operations on number_series" ON number_series;
+CREATE POLICY "Allow all operations on number_series" ON number_series FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on number_series_counters" ON number_series_counters;
+CREATE POLICY "Allow all operations on number_series_counters" ON number_series_counters FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on document_numbers" ON document_numbers;
+CREATE POLICY "Allow all operations on document_numbers" ON document_numbers FOR ALL USING (true);
+
+COMMENT ON TABLE number_series IS 'Numbering series configuration for invoices, credit notes and receipts';
+COMMENT ON TABLE document_numbers IS 'Every document number ever issued; audited for gaps';
@@ -0,0 +1,42 @@
+-- Views depend on the column types and have to be recreated
+DROP VIEW IF EXISTS payment_summary;
+DROP VIEW IF EXISTS invoice_analytics;
+
+ALTER TABLE invoices
+    ALTER COLUMN subtotal TYPE DECIMAL(10,2),
+    ALTER COLUMN discount TYPE DECIMAL(10,2),
+    ALTER COLUMN total TYPE DECIMAL(10,2),
+    ALTER COLUMN paid_amount TYPE DECIMAL(20,2);
+
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE DECIMAL(20,2);
+
+CREATE OR REPLACE VIEW invoice_analytics AS
+SELECT
+    DATE_TRUNC('day', created_at) as date,
+    COUNT(*) as invoice_count,
+    SUM(total) as total_amount,
+    SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END) as paid_amount,
+    SUM(CASE WHEN payment_status = 'unpaid' THEN total ELSE 0 END) as unpaid_amount,
+    SUM(CASE WHEN payment_status = 'overdue' THEN total ELSE 0 END) as overdue_amount,
+    currency
+FROM invoices
+GROUP BY DATE_TRUNC('day', created_at), currency;
+
+CREATE OR REPLACE VIEW payment_summary AS
+SELECT
+    i.id as invoice_id,
+    i.invoice_number,
+    i.total,
+    i.paid_amount,
+    i.total - i.paid_amount as outstanding_amount,
+    i.payment_status,
+    i.due_date,
+    c.name as customer_name,
+    c.email as customer_email,
+    CASE
+        WHEN i.due_date < NOW() AND i.payment_status != 'paid' THEN 'overdue'
+        ELSE i.payment_status
+    END as current_status
+FROM invoices i
+LEFT JOIN customers c ON i.customer_id = c.id;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_invoices_search_vector;
+
+DROP TRIGGER IF EXISTS trigger_reindex_customer_invoices ON customers;
+DROP TRIGGER IF EXISTS trigger_update_search_vector ON invoices;
+DROP FUNCTION IF EXISTS reindex_customer_invoices();
+DROP FUNCTION IF EXISTS update_invoice_search_vector();
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS search_vector;
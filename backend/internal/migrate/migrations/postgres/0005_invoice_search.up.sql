@@ -0,0 +1,60 @@
+-- =====================================================
+-- INVOICE FULL-TEXT SEARCH
+-- Every invoice carries a search_vector built from its number, its
+-- customer's name, company and email, its item descriptions and its notes,
+-- weighted in that order. Text is unaccented and indexed with the 'simple'
+-- configuration (no stemming), since invoices mix languages.
+-- =====================================================
+
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION update_invoice_search_vector()
+RETURNS TRIGGER AS $$
+DECLARE
+    customer RECORD;
+    descriptions TEXT;
+BEGIN
+    SELECT name, company_name, email INTO customer
+    FROM customers WHERE id = NEW.customer_id;
+
+    SELECT string_agg(item->>'description', ' ') INTO descriptions
+    FROM jsonb_array_elements(COALESCE(NEW.items, '[]'::jsonb)) AS item;
+
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.invoice_number, ''))), 'A') ||
+        setweight(to_tsvector('simple', unaccent(concat_ws(' ', customer.name, customer.company_name, customer.email))), 'B') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(descriptions, ''))), 'C') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.notes, ''))), 'D');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Named to sort after trigger_assign_invoice_number, so the number is set
+-- before it is indexed
+DROP TRIGGER IF EXISTS trigger_update_search_vector ON invoices;
+CREATE TRIGGER trigger_update_search_vector
+BEFORE INSERT OR UPDATE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION update_invoice_search_vector();
+
+-- Re-index a customer's invoices when the customer's searchable fields change
+CREATE OR REPLACE FUNCTION reindex_customer_invoices()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE invoices SET search_vector = NULL WHERE customer_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_reindex_customer_invoices ON customers;
+CREATE TRIGGER trigger_reindex_customer_invoices
+AFTER UPDATE OF name, company_name, email ON customers
+FOR EACH ROW
+EXECUTE FUNCTION reindex_customer_invoices();
+
+-- Index the existing invoices
+UPDATE invoices SET search_vector = NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_search_vector ON invoices USING GIN (search_vector);
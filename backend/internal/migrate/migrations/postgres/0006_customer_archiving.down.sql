@@ -0,0 +1,8 @@
+DROP FUNCTION IF EXISTS delete_customer(UUID, BOOLEAN);
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_customer_id_fkey;
+ALTER TABLE invoices ADD CONSTRAINT invoices_customer_id_fkey
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_customers_archived_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,46 @@
+-- =====================================================
+-- CUSTOMER ARCHIVING
+-- Customers are archived instead of deleted: an archived customer is hidden
+-- from the customer list but still resolves on its invoices. Deleting a
+-- customer no longer cascades to its invoices and payments; delete_customer
+-- removes them only when asked to.
+-- =====================================================
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_customers_archived_at ON customers(archived_at);
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_customer_id_fkey;
+ALTER TABLE invoices ADD CONSTRAINT invoices_customer_id_fkey
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE RESTRICT;
+
+-- delete_customer deletes a customer that has no invoices, or, with
+-- p_force, the customer together with its invoices and their payments.
+-- Returns the number of invoices deleted. Called through PostgREST by the
+-- Supabase backend and the customer service.
+--
+--   PT404 - customer does not exist
+--   PT409 - customer has invoices and p_force is false
+CREATE OR REPLACE FUNCTION delete_customer(p_customer_id UUID, p_force BOOLEAN DEFAULT FALSE)
+RETURNS INTEGER AS $$
+DECLARE
+    v_invoices INTEGER;
+BEGIN
+    PERFORM 1 FROM customers WHERE id = p_customer_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+
+    SELECT COUNT(*) INTO v_invoices FROM invoices WHERE customer_id = p_customer_id;
+    IF v_invoices > 0 AND NOT p_force THEN
+        RAISE EXCEPTION 'customer % has % invoices', p_customer_id, v_invoices USING ERRCODE = 'PT409';
+    END IF;
+
+    DELETE FROM invoices WHERE customer_id = p_customer_id;
+    DELETE FROM customers WHERE id = p_customer_id;
+    RETURN v_invoices;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON COLUMN customers.archived_at IS 'When the customer was archived; NULL for active customers';
+COMMENT ON FUNCTION delete_customer IS 'Deletes a customer, refusing when it has invoices unless forced';
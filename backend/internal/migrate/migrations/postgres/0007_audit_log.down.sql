@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS audit_number_series ON number_series;
+DROP TRIGGER IF EXISTS audit_payments ON payments;
+DROP TRIGGER IF EXISTS audit_invoices ON invoices;
+DROP TRIGGER IF EXISTS audit_customers ON customers;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+
+DROP FUNCTION IF EXISTS audit_log_append_only();
+DROP FUNCTION IF EXISTS audit_row();
+DROP FUNCTION IF EXISTS audit_actor();
+
+DROP TABLE IF EXISTS audit_log;
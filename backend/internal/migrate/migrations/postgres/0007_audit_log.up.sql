@@ -0,0 +1,107 @@
+-- =====================================================
+-- AUDIT LOG
+-- Every insert, update and delete of a customer, invoice, payment or
+-- numbering series is recorded by a trigger, in the same transaction as the
+-- change, with the actor, the action and the changed fields' values before
+-- and after. The log is append-only.
+--
+-- The actor is taken from, in order:
+--   app.actor        - set per transaction by the native Postgres backend
+--   request.headers  - the X-Actor header of a PostgREST request (Supabase
+--                      backend and the services)
+--   current_user     - changes made directly in the database
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    actor TEXT NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity_type, entity_id, id);
+
+CREATE OR REPLACE FUNCTION audit_actor()
+RETURNS TEXT AS $$
+    SELECT COALESCE(
+        NULLIF(current_setting('app.actor', true), ''),
+        NULLIF(NULLIF(current_setting('request.headers', true), '')::json->>'x-actor', ''),
+        current_user
+    );
+$$ LANGUAGE sql STABLE;
+
+-- audit_row records the row change that fired it. Arguments: the entity type
+-- and the name of the row's key column. Bookkeeping columns are left out of
+-- the diff, and an update that changes nothing else is not recorded.
+CREATE OR REPLACE FUNCTION audit_row()
+RETURNS TRIGGER AS $$
+DECLARE
+    ignored TEXT[] := ARRAY['created_at', 'updated_at', 'search_vector', 'idempotency_key'];
+    v_old JSONB := '{}';
+    v_new JSONB := '{}';
+    v_changes JSONB;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := to_jsonb(OLD) - ignored;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := to_jsonb(NEW) - ignored;
+    END IF;
+
+    SELECT jsonb_object_agg(key, jsonb_build_object('from', v_old->key, 'to', v_new->key)) INTO v_changes
+    FROM (SELECT jsonb_object_keys(v_old || v_new) AS key) AS keys
+    WHERE COALESCE(v_old->key, 'null') IS DISTINCT FROM COALESCE(v_new->key, 'null');
+
+    IF TG_OP = 'UPDATE' AND v_changes IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    VALUES (
+        TG_ARGV[0],
+        CASE TG_OP WHEN 'DELETE' THEN v_old ELSE v_new END->>TG_ARGV[1],
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        audit_actor(),
+        COALESCE(v_changes, '{}')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_customers ON customers;
+CREATE TRIGGER audit_customers
+AFTER INSERT OR UPDATE OR DELETE ON customers
+FOR EACH ROW EXECUTE FUNCTION audit_row('customer', 'id');
+
+DROP TRIGGER IF EXISTS audit_invoices ON invoices;
+CREATE TRIGGER audit_invoices
+AFTER INSERT OR UPDATE OR DELETE ON invoices
+FOR EACH ROW EXECUTE FUNCTION audit_row('invoice', 'id');
+
+DROP TRIGGER IF EXISTS audit_payments ON payments;
+CREATE TRIGGER audit_payments
+AFTER INSERT OR UPDATE OR DELETE ON payments
+FOR EACH ROW EXECUTE FUNCTION audit_row('payment', 'id');
+
+DROP TRIGGER IF EXISTS audit_number_series ON number_series;
+CREATE TRIGGER audit_number_series
+AFTER INSERT OR UPDATE OR DELETE ON number_series
+FOR EACH ROW EXECUTE FUNCTION audit_row('number_series', 'series_key');
+
+-- Entries can be added but never changed or removed
+CREATE OR REPLACE FUNCTION audit_log_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+COMMENT ON TABLE audit_log IS 'Append-only record of every change to customers, invoices, payments and numbering series';
@@ -0,0 +1,63 @@
+DROP FUNCTION IF EXISTS delete_customer(UUID, BOOLEAN, INTEGER);
+CREATE OR REPLACE FUNCTION delete_customer(p_customer_id UUID, p_force BOOLEAN DEFAULT FALSE)
+RETURNS INTEGER AS $$
+DECLARE
+    v_invoices INTEGER;
+BEGIN
+    PERFORM 1 FROM customers WHERE id = p_customer_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+
+    SELECT COUNT(*) INTO v_invoices FROM invoices WHERE customer_id = p_customer_id;
+    IF v_invoices > 0 AND NOT p_force THEN
+        RAISE EXCEPTION 'customer % has % invoices', p_customer_id, v_invoices USING ERRCODE = 'PT409';
+    END IF;
+
+    DELETE FROM invoices WHERE customer_id = p_customer_id;
+    DELETE FROM customers WHERE id = p_customer_id;
+    RETURN v_invoices;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_row()
+RETURNS TRIGGER AS $$
+DECLARE
+    ignored TEXT[] := ARRAY['created_at', 'updated_at', 'search_vector', 'idempotency_key'];
+    v_old JSONB := '{}';
+    v_new JSONB := '{}';
+    v_changes JSONB;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := to_jsonb(OLD) - ignored;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := to_jsonb(NEW) - ignored;
+    END IF;
+
+    SELECT jsonb_object_agg(key, jsonb_build_object('from', v_old->key, 'to', v_new->key)) INTO v_changes
+    FROM (SELECT jsonb_object_keys(v_old || v_new) AS key) AS keys
+    WHERE COALESCE(v_old->key, 'null') IS DISTINCT FROM COALESCE(v_new->key, 'null');
+
+    IF TG_OP = 'UPDATE' AND v_changes IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    VALUES (
+        TG_ARGV[0],
+        CASE TG_OP WHEN 'DELETE' THEN v_old ELSE v_new END->>TG_ARGV[1],
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        audit_actor(),
+        COALESCE(v_changes, '{}')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS bump_invoices_version ON invoices;
+DROP TRIGGER IF EXISTS bump_customers_version ON customers;
+DROP FUNCTION IF EXISTS bump_version();
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS version;
+ALTER TABLE customers DROP COLUMN IF EXISTS version;
@@ -0,0 +1,108 @@
+-- =====================================================
+-- ROW VERSIONS
+-- Customers and invoices carry a version that goes up with every change,
+-- for optimistic concurrency: clients send the version they read (as the
+-- ETag in If-Match) and a write to a row that has moved on is refused.
+-- =====================================================
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- bump_version sets the version of an updated row, so writes through
+-- PostgREST, which cannot increment a column, are versioned too. An update
+-- that changes nothing but bookkeeping columns keeps the version.
+CREATE OR REPLACE FUNCTION bump_version()
+RETURNS TRIGGER AS $$
+DECLARE
+    ignored TEXT[] := ARRAY['version', 'updated_at', 'search_vector'];
+BEGIN
+    IF to_jsonb(NEW) - ignored IS DISTINCT FROM to_jsonb(OLD) - ignored THEN
+        NEW.version := OLD.version + 1;
+    ELSE
+        NEW.version := OLD.version;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS bump_customers_version ON customers;
+CREATE TRIGGER bump_customers_version
+BEFORE UPDATE ON customers
+FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+DROP TRIGGER IF EXISTS bump_invoices_version ON invoices;
+CREATE TRIGGER bump_invoices_version
+BEFORE UPDATE ON invoices
+FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+-- Version changes are implied by every audited update; keep them out of the
+-- audit diffs
+CREATE OR REPLACE FUNCTION audit_row()
+RETURNS TRIGGER AS $$
+DECLARE
+    ignored TEXT[] := ARRAY['created_at', 'updated_at', 'search_vector', 'idempotency_key', 'version'];
+    v_old JSONB := '{}';
+    v_new JSONB := '{}';
+    v_changes JSONB;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := to_jsonb(OLD) - ignored;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := to_jsonb(NEW) - ignored;
+    END IF;
+
+    SELECT jsonb_object_agg(key, jsonb_build_object('from', v_old->key, 'to', v_new->key)) INTO v_changes
+    FROM (SELECT jsonb_object_keys(v_old || v_new) AS key) AS keys
+    WHERE COALESCE(v_old->key, 'null') IS DISTINCT FROM COALESCE(v_new->key, 'null');
+
+    IF TG_OP = 'UPDATE' AND v_changes IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    VALUES (
+        TG_ARGV[0],
+        CASE TG_OP WHEN 'DELETE' THEN v_old ELSE v_new END->>TG_ARGV[1],
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        audit_actor(),
+        COALESCE(v_changes, '{}')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- delete_customer takes the version the caller read; a customer that has
+-- moved on is not deleted.
+--
+--   PT404 - customer does not exist
+--   PT409 - customer has invoices and p_force is false
+--   PT412 - customer is no longer at p_version
+DROP FUNCTION IF EXISTS delete_customer(UUID, BOOLEAN);
+CREATE OR REPLACE FUNCTION delete_customer(p_customer_id UUID, p_force BOOLEAN DEFAULT FALSE, p_version INTEGER DEFAULT NULL)
+RETURNS INTEGER AS $$
+DECLARE
+    v_version INTEGER;
+    v_invoices INTEGER;
+BEGIN
+    SELECT version INTO v_version FROM customers WHERE id = p_customer_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+    IF p_version IS NOT NULL AND p_version <> v_version THEN
+        RAISE EXCEPTION 'customer % is at version %, not %', p_customer_id, v_version, p_version USING ERRCODE = 'PT412';
+    END IF;
+
+    SELECT COUNT(*) INTO v_invoices FROM invoices WHERE customer_id = p_customer_id;
+    IF v_invoices > 0 AND NOT p_force THEN
+        RAISE EXCEPTION 'customer % has % invoices', p_customer_id, v_invoices USING ERRCODE = 'PT409';
+    END IF;
+
+    DELETE FROM invoices WHERE customer_id = p_customer_id;
+    DELETE FROM customers WHERE id = p_customer_id;
+    RETURN v_invoices;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON COLUMN customers.version IS 'Goes up with every change; the ETag of the customer';
+COMMENT ON COLUMN invoices.version IS 'Goes up with every change; the ETag of the invoice';
@@ -0,0 +1,45 @@
+DROP VIEW IF EXISTS item_sales;
+DROP FUNCTION IF EXISTS create_invoice(JSONB, JSONB);
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS items JSONB;
+
+UPDATE invoices i
+SET items = COALESCE((
+    SELECT jsonb_agg(jsonb_build_object(
+            'description', it.description,
+            'quantity', it.quantity,
+            'unit_price', it.unit_price,
+            'total', it.total) ORDER BY it.position)
+    FROM invoice_items it
+    WHERE it.invoice_id = i.id), '[]'::jsonb);
+
+ALTER TABLE invoices ALTER COLUMN items SET NOT NULL;
+
+DROP TRIGGER IF EXISTS audit_invoice_items ON invoice_items;
+DROP TRIGGER IF EXISTS trigger_reindex_item_invoice ON invoice_items;
+DROP FUNCTION IF EXISTS reindex_item_invoice();
+
+CREATE OR REPLACE FUNCTION update_invoice_search_vector()
+RETURNS TRIGGER AS $$
+DECLARE
+    customer RECORD;
+    descriptions TEXT;
+BEGIN
+    SELECT name, company_name, email INTO customer
+    FROM customers WHERE id = NEW.customer_id;
+
+    SELECT string_agg(item->>'description', ' ') INTO descriptions
+    FROM jsonb_array_elements(COALESCE(NEW.items, '[]'::jsonb)) AS item;
+
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.invoice_number, ''))), 'A') ||
+        setweight(to_tsvector('simple', unaccent(concat_ws(' ', customer.name, customer.company_name, customer.email))), 'B') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(descriptions, ''))), 'C') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.notes, ''))), 'D');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS invoice_items;
+
+UPDATE invoices SET search_vector = NULL;
@@ -0,0 +1,140 @@
+-- =====================================================
+-- INVOICE LINE ITEMS
+-- Line items move from the invoices.items JSONB column to their own table,
+-- one row per line in position order, with an optional SKU and unit, a
+-- per-line tax rate (NULL for the invoice's rate) and discount, and the
+-- line total (quantity x unit price less the discount, rounded to the
+-- invoice's currency).
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position > 0),
+    sku TEXT,
+    description TEXT NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit TEXT,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    discount DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    total DECIMAL(20,4) NOT NULL,
+    UNIQUE (invoice_id, position)
+);
+
+-- Move the JSON items. Items written by invoice-service carry their total;
+-- the others are totalled here, rounded like the money package does.
+INSERT INTO invoice_items (invoice_id, position, description, quantity, unit_price, total)
+SELECT i.id,
+    e.position,
+    COALESCE(e.item->>'description', ''),
+    GREATEST(COALESCE((e.item->>'quantity')::DECIMAL, 1), 1)::INTEGER,
+    GREATEST(COALESCE((e.item->>'unit_price')::DECIMAL, 0), 0),
+    COALESCE((e.item->>'total')::DECIMAL,
+        ROUND(COALESCE((e.item->>'quantity')::DECIMAL, 1) * COALESCE((e.item->>'unit_price')::DECIMAL, 0),
+            CASE
+                WHEN UPPER(i.currency) IN ('IDR', 'JPY', 'KRW', 'VND', 'CLP') THEN 0
+                WHEN UPPER(i.currency) IN ('BHD', 'JOD', 'KWD', 'OMR', 'TND') THEN 3
+                ELSE 2
+            END))
+FROM invoices i
+CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(i.items) = 'array' THEN i.items ELSE '[]'::jsonb END
+) WITH ORDINALITY AS e(item, position)
+WHERE NOT EXISTS (SELECT 1 FROM invoice_items x WHERE x.invoice_id = i.id);
+
+-- Search indexes the descriptions of the invoice's lines
+CREATE OR REPLACE FUNCTION update_invoice_search_vector()
+RETURNS TRIGGER AS $$
+DECLARE
+    customer RECORD;
+    descriptions TEXT;
+BEGIN
+    SELECT name, company_name, email INTO customer
+    FROM customers WHERE id = NEW.customer_id;
+
+    SELECT string_agg(concat_ws(' ', sku, description), ' ' ORDER BY position) INTO descriptions
+    FROM invoice_items WHERE invoice_id = NEW.id;
+
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.invoice_number, ''))), 'A') ||
+        setweight(to_tsvector('simple', unaccent(concat_ws(' ', customer.name, customer.company_name, customer.email))), 'B') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(descriptions, ''))), 'C') ||
+        setweight(to_tsvector('simple', unaccent(COALESCE(NEW.notes, ''))), 'D');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Re-index an invoice when its lines change. Only search_vector is touched,
+-- so neither the version nor the audit log see the update.
+CREATE OR REPLACE FUNCTION reindex_item_invoice()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE invoices SET search_vector = NULL
+    WHERE id = CASE TG_OP WHEN 'DELETE' THEN OLD.invoice_id ELSE NEW.invoice_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_reindex_item_invoice ON invoice_items;
+CREATE TRIGGER trigger_reindex_item_invoice
+AFTER INSERT OR UPDATE OR DELETE ON invoice_items
+FOR EACH ROW EXECUTE FUNCTION reindex_item_invoice();
+
+-- Line changes are logged under the invoice they belong to
+DROP TRIGGER IF EXISTS audit_invoice_items ON invoice_items;
+CREATE TRIGGER audit_invoice_items
+AFTER INSERT OR UPDATE OR DELETE ON invoice_items
+FOR EACH ROW EXECUTE FUNCTION audit_row('invoice_item', 'invoice_id');
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS items;
+
+UPDATE invoices SET search_vector = NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice_id ON invoice_items(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_invoice_items_sku ON invoice_items(sku) WHERE sku IS NOT NULL;
+
+-- create_invoice inserts an invoice and its lines in one transaction, for
+-- the Supabase backend and invoice-service (POST /rest/v1/rpc/create_invoice).
+-- p_invoice holds the invoice columns and p_items the lines, both as JSON
+-- objects keyed by column name.
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, sku, description, quantity, unit, unit_price,
+        tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+-- item_sales totals what each item brought in, per currency. Lines with a
+-- SKU are grouped by SKU, the others by description.
+CREATE OR REPLACE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), i.currency;
+
+COMMENT ON TABLE invoice_items IS 'Invoice lines, in position order';
+COMMENT ON COLUMN invoice_items.tax_rate IS 'Tax percentage of the line; NULL applies the invoice tax rate';
+COMMENT ON COLUMN invoice_items.total IS 'Quantity x unit price less the line discount, rounded to the invoice currency';
+COMMENT ON FUNCTION create_invoice IS 'Creates an invoice with its lines atomically';
@@ -0,0 +1,19 @@
+DROP VIEW IF EXISTS item_sales;
+
+-- Fractional quantities are rounded, keeping at least one
+ALTER TABLE invoice_items ALTER COLUMN quantity TYPE INTEGER USING GREATEST(ROUND(quantity), 1);
+
+CREATE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), i.currency;
+
+COMMENT ON COLUMN invoice_items.quantity IS NULL;
+COMMENT ON COLUMN invoice_items.unit IS NULL;
@@ -0,0 +1,27 @@
+-- =====================================================
+-- FRACTIONAL QUANTITIES
+-- Line quantities become decimals with up to four places, so a line can bill
+-- 7.5 hours or 2.35 kg. Item sales are totalled per unit of measure as well,
+-- so hours and days of the same item are not added up.
+-- =====================================================
+
+-- item_sales reads quantity, so it is recreated around the type change
+DROP VIEW IF EXISTS item_sales;
+
+ALTER TABLE invoice_items ALTER COLUMN quantity TYPE DECIMAL(20,4);
+
+CREATE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), it.unit, i.currency;
+
+COMMENT ON COLUMN invoice_items.quantity IS 'Decimal quantity, up to four places; whole for pcs';
+COMMENT ON COLUMN invoice_items.unit IS 'Unit of measure: hour, day, kg, pcs or a custom unit; NULL for none';
@@ -0,0 +1,29 @@
+DROP FUNCTION IF EXISTS save_product(UUID, JSONB, JSONB, INTEGER);
+
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, sku, description, quantity, unit, unit_price,
+        tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS idx_invoice_items_product_id;
+ALTER TABLE invoice_items DROP COLUMN IF EXISTS product_id;
+
+DROP TABLE IF EXISTS product_prices;
+DROP TABLE IF EXISTS products;
@@ -0,0 +1,137 @@
+-- =====================================================
+-- PRODUCT CATALOG
+-- Products and services that invoice lines can reference: a unique SKU, a
+-- name and description, the unit of measure and default tax rate, and a
+-- default unit price per currency. Inactive products stay in the catalog
+-- but cannot be put on new invoices. A line that references a product
+-- keeps its own copy of the SKU, description, unit, tax rate and price, so
+-- changing or deleting the product never alters an issued invoice.
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS products (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    sku TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    description TEXT,
+    unit TEXT,
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS product_prices (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    currency TEXT NOT NULL,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    PRIMARY KEY (product_id, currency)
+);
+
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS product_id UUID REFERENCES products(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_products_active ON products(active);
+CREATE INDEX IF NOT EXISTS idx_invoice_items_product_id ON invoice_items(product_id) WHERE product_id IS NOT NULL;
+
+-- Price changes are logged under the product they belong to
+DROP TRIGGER IF EXISTS audit_products ON products;
+CREATE TRIGGER audit_products
+AFTER INSERT OR UPDATE OR DELETE ON products
+FOR EACH ROW EXECUTE FUNCTION audit_row('product', 'id');
+
+DROP TRIGGER IF EXISTS audit_product_prices ON product_prices;
+CREATE TRIGGER audit_product_prices
+AFTER INSERT OR UPDATE OR DELETE ON product_prices
+FOR EACH ROW EXECUTE FUNCTION audit_row('product_price', 'product_id');
+
+ALTER TABLE products ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_prices ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Allow all operations on products" ON products;
+CREATE POLICY "Allow all operations on products" ON products FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on product_prices" ON product_prices;
+CREATE POLICY "Allow all operations on product_prices" ON product_prices FOR ALL USING (true);
+
+-- save_product creates (p_id NULL) or updates a product and replaces its
+-- prices in one transaction, for the Supabase backend and invoice-service.
+-- p_product holds the product columns and p_prices the prices, as JSON
+-- objects keyed by column name. The version goes up with every save, price
+-- changes included, so it is raised here rather than by bump_version.
+--
+--   PT404 - product p_id does not exist
+--   PT409 - another product has the SKU
+--   PT412 - product is no longer at p_version
+CREATE OR REPLACE FUNCTION save_product(p_id UUID, p_product JSONB, p_prices JSONB, p_version INTEGER DEFAULT NULL)
+RETURNS products AS $$
+DECLARE
+    v_product products%ROWTYPE;
+    v_version INTEGER;
+BEGIN
+    IF EXISTS (SELECT 1 FROM products WHERE sku = p_product->>'sku' AND id IS DISTINCT FROM p_id) THEN
+        RAISE EXCEPTION 'SKU % is already used by another product', p_product->>'sku' USING ERRCODE = 'PT409';
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO products (sku, name, description, unit, tax_rate, active)
+        SELECT r.sku, r.name, NULLIF(r.description, ''), NULLIF(r.unit, ''), r.tax_rate, COALESCE(r.active, TRUE)
+        FROM jsonb_populate_record(NULL::products, p_product) AS r
+        RETURNING * INTO v_product;
+    ELSE
+        SELECT version INTO v_version FROM products WHERE id = p_id FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'product % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'product % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+
+        UPDATE products p
+        SET sku = r.sku, name = r.name, description = NULLIF(r.description, ''), unit = NULLIF(r.unit, ''),
+            tax_rate = r.tax_rate, active = COALESCE(r.active, TRUE), version = p.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::products, p_product) AS r
+        WHERE p.id = p_id
+        RETURNING p.* INTO v_product;
+    END IF;
+
+    DELETE FROM product_prices
+    WHERE product_id = v_product.id
+      AND currency NOT IN (SELECT r.currency FROM jsonb_populate_recordset(NULL::product_prices, p_prices) AS r);
+
+    INSERT INTO product_prices (product_id, currency, unit_price)
+    SELECT v_product.id, r.currency, r.unit_price
+    FROM jsonb_populate_recordset(NULL::product_prices, p_prices) AS r
+    ON CONFLICT (product_id, currency) DO UPDATE SET unit_price = excluded.unit_price
+    WHERE product_prices.unit_price <> excluded.unit_price;
+
+    RETURN v_product;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_invoice stores the product a line was taken from
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE products IS 'Catalog of products and services invoice lines can reference';
+COMMENT ON TABLE product_prices IS 'Default unit price of a product per currency';
+COMMENT ON COLUMN products.version IS 'Goes up with every change to the product or its prices; the ETag of the product';
+COMMENT ON COLUMN invoice_items.product_id IS 'Catalog product the line was taken from; the line keeps its own copy of the product''s details';
+COMMENT ON FUNCTION save_product IS 'Creates or updates a product with its prices atomically';
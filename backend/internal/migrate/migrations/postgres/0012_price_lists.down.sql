@@ -0,0 +1,30 @@
+DROP FUNCTION IF EXISTS save_price_list(UUID, JSONB, JSONB, INTEGER);
+
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS idx_customers_price_list_id;
+ALTER TABLE invoice_items DROP COLUMN IF EXISTS price_rule;
+ALTER TABLE customers DROP COLUMN IF EXISTS price_list_id;
+
+DROP TABLE IF EXISTS price_list_rules;
+DROP TABLE IF EXISTS price_lists;
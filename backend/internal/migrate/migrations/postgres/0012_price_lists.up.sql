@@ -0,0 +1,148 @@
+-- =====================================================
+-- PRICE LISTS
+-- Negotiated prices for B2B customers. A price list holds rules for catalog
+-- products in one currency; each rule applies from a minimum quantity up
+-- and sets either a fixed unit price or a percentage off the catalog price,
+-- so a list can carry volume breaks. A customer has at most one price list,
+-- applied to the lines of its invoices that reference a product. The line
+-- records the rule that priced it in price_rule.
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS price_lists (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    currency TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS price_list_rules (
+    price_list_id UUID NOT NULL REFERENCES price_lists(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    min_quantity DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (min_quantity >= 0),
+    unit_price DECIMAL(20,4) CHECK (unit_price >= 0),
+    discount_percent DECIMAL(7,4) CHECK (discount_percent BETWEEN 0 AND 100),
+    PRIMARY KEY (price_list_id, product_id, min_quantity),
+    CHECK ((unit_price IS NULL) <> (discount_percent IS NULL))
+);
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS price_list_id UUID REFERENCES price_lists(id) ON DELETE SET NULL;
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS price_rule TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_price_list_rules_product_id ON price_list_rules(product_id);
+CREATE INDEX IF NOT EXISTS idx_customers_price_list_id ON customers(price_list_id) WHERE price_list_id IS NOT NULL;
+
+-- Rule changes are logged under the price list they belong to
+DROP TRIGGER IF EXISTS audit_price_lists ON price_lists;
+CREATE TRIGGER audit_price_lists
+AFTER INSERT OR UPDATE OR DELETE ON price_lists
+FOR EACH ROW EXECUTE FUNCTION audit_row('price_list', 'id');
+
+DROP TRIGGER IF EXISTS audit_price_list_rules ON price_list_rules;
+CREATE TRIGGER audit_price_list_rules
+AFTER INSERT OR UPDATE OR DELETE ON price_list_rules
+FOR EACH ROW EXECUTE FUNCTION audit_row('price_list_rule', 'price_list_id');
+
+ALTER TABLE price_lists ENABLE ROW LEVEL SECURITY;
+ALTER TABLE price_list_rules ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Allow all operations on price_lists" ON price_lists;
+CREATE POLICY "Allow all operations on price_lists" ON price_lists FOR ALL USING (true);
+DROP POLICY IF EXISTS "Allow all operations on price_list_rules" ON price_list_rules;
+CREATE POLICY "Allow all operations on price_list_rules" ON price_list_rules FOR ALL USING (true);
+
+-- save_price_list creates (p_id NULL) or updates a price list and replaces
+-- its rules in one transaction, for the Supabase backend and
+-- invoice-service. p_list holds the price list columns and p_rules the
+-- rules, as JSON objects keyed by column name. Like save_product it raises
+-- the version itself, so rule changes count as changes to the list.
+--
+--   PT404 - price list p_id does not exist
+--   PT409 - a rule names a product that does not exist
+--   PT412 - price list is no longer at p_version
+CREATE OR REPLACE FUNCTION save_price_list(p_id UUID, p_list JSONB, p_rules JSONB, p_version INTEGER DEFAULT NULL)
+RETURNS price_lists AS $$
+DECLARE
+    v_list price_lists%ROWTYPE;
+    v_version INTEGER;
+    v_product UUID;
+BEGIN
+    SELECT r.product_id INTO v_product
+    FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r
+    WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.id = r.product_id)
+    LIMIT 1;
+    IF FOUND THEN
+        RAISE EXCEPTION 'product % not found', v_product USING ERRCODE = 'PT409';
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO price_lists (name, description, currency)
+        SELECT r.name, NULLIF(r.description, ''), r.currency
+        FROM jsonb_populate_record(NULL::price_lists, p_list) AS r
+        RETURNING * INTO v_list;
+    ELSE
+        SELECT version INTO v_version FROM price_lists WHERE id = p_id FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'price list % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'price list % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+
+        UPDATE price_lists l
+        SET name = r.name, description = NULLIF(r.description, ''), currency = r.currency,
+            version = l.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::price_lists, p_list) AS r
+        WHERE l.id = p_id
+        RETURNING l.* INTO v_list;
+    END IF;
+
+    DELETE FROM price_list_rules
+    WHERE price_list_id = v_list.id
+      AND (product_id, min_quantity) NOT IN (
+          SELECT r.product_id, r.min_quantity
+          FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r);
+
+    INSERT INTO price_list_rules (price_list_id, product_id, min_quantity, unit_price, discount_percent)
+    SELECT v_list.id, r.product_id, r.min_quantity, r.unit_price, r.discount_percent
+    FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r
+    ON CONFLICT (price_list_id, product_id, min_quantity) DO UPDATE
+    SET unit_price = excluded.unit_price, discount_percent = excluded.discount_percent
+    WHERE price_list_rules.unit_price IS DISTINCT FROM excluded.unit_price
+       OR price_list_rules.discount_percent IS DISTINCT FROM excluded.discount_percent;
+
+    RETURN v_list;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_invoice stores the price list rule that priced a line
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE price_lists IS 'Customer-specific prices for catalog products in one currency';
+COMMENT ON TABLE price_list_rules IS 'Price of a product on a price list from a minimum quantity up';
+COMMENT ON COLUMN price_lists.version IS 'Goes up with every change to the price list or its rules; the ETag of the price list';
+COMMENT ON COLUMN customers.price_list_id IS 'Price list applied to the customer''s invoice lines that reference a product';
+COMMENT ON COLUMN invoice_items.price_rule IS 'Price list rule that set the line''s unit price, as shown on the invoice';
+COMMENT ON FUNCTION save_price_list IS 'Creates or updates a price list with its rules atomically';
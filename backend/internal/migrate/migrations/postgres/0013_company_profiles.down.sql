@@ -0,0 +1,51 @@
+DROP FUNCTION IF EXISTS save_company_profile(UUID, JSONB, TEXT, INTEGER);
+DROP TRIGGER IF EXISTS audit_company_info ON company_info;
+
+CREATE OR REPLACE FUNCTION assign_invoice_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.invoice_number IS NULL OR NEW.invoice_number = '' THEN
+        NEW.invoice_number := next_document_number('invoice', NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+-- The numbering series of the profiles are kept, so the numbers they issued
+-- stay auditable
+DROP INDEX IF EXISTS idx_invoices_company_id;
+ALTER TABLE invoices DROP COLUMN IF EXISTS company_id;
+
+DROP INDEX IF EXISTS idx_company_info_default;
+ALTER TABLE company_info
+    DROP COLUMN IF EXISTS version,
+    DROP COLUMN IF EXISTS is_default,
+    DROP COLUMN IF EXISTS invoice_series,
+    DROP COLUMN IF EXISTS logo,
+    DROP COLUMN IF EXISTS bank_swift,
+    DROP COLUMN IF EXISTS bank_account_number,
+    DROP COLUMN IF EXISTS bank_account_name,
+    DROP COLUMN IF EXISTS bank_name,
+    DROP COLUMN IF EXISTS legal_name;
@@ -0,0 +1,167 @@
+-- =====================================================
+-- COMPANY PROFILES
+-- The companies invoices are issued from. company_info, unused since the
+-- initial schema, becomes the profile table: besides the address it holds
+-- the legal name, tax ID, bank details and logo printed on the invoice, and
+-- the numbering series of the company's invoices. Exactly one profile is the
+-- default, used for invoices that do not name one. An invoice records the
+-- profile it was issued from in company_id.
+-- =====================================================
+
+ALTER TABLE company_info
+    ADD COLUMN IF NOT EXISTS legal_name TEXT,
+    ADD COLUMN IF NOT EXISTS bank_name TEXT,
+    ADD COLUMN IF NOT EXISTS bank_account_name TEXT,
+    ADD COLUMN IF NOT EXISTS bank_account_number TEXT,
+    ADD COLUMN IF NOT EXISTS bank_swift TEXT,
+    ADD COLUMN IF NOT EXISTS logo TEXT,
+    ADD COLUMN IF NOT EXISTS invoice_series VARCHAR(50) NOT NULL DEFAULT 'invoice' REFERENCES number_series(series_key),
+    ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_info_default ON company_info(is_default) WHERE is_default;
+
+-- The details that were printed on every invoice become the default profile
+INSERT INTO company_info (name, email, phone, address, city, postal_code, country, website)
+SELECT 'InvoicePro Systems', 'billing@invoicepro.com', '+62 812 3456 7890', 'Jl. Merdeka No. 123',
+    'Jakarta', '12345', 'Indonesia', 'www.invoicepro.com'
+WHERE NOT EXISTS (SELECT 1 FROM company_info);
+
+UPDATE company_info SET is_default = TRUE
+WHERE id = (SELECT id FROM company_info ORDER BY created_at, id LIMIT 1)
+  AND NOT EXISTS (SELECT 1 FROM company_info WHERE is_default);
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS company_id UUID REFERENCES company_info(id);
+CREATE INDEX IF NOT EXISTS idx_invoices_company_id ON invoices(company_id);
+
+-- Existing invoices were issued by the default company; recording that is
+-- not a change to the invoice, so it neither raises versions nor is audited
+ALTER TABLE invoices DISABLE TRIGGER bump_invoices_version;
+ALTER TABLE invoices DISABLE TRIGGER audit_invoices;
+UPDATE invoices SET company_id = (SELECT id FROM company_info WHERE is_default) WHERE company_id IS NULL;
+ALTER TABLE invoices ENABLE TRIGGER audit_invoices;
+ALTER TABLE invoices ENABLE TRIGGER bump_invoices_version;
+
+DROP TRIGGER IF EXISTS audit_company_info ON company_info;
+CREATE TRIGGER audit_company_info
+AFTER INSERT OR UPDATE OR DELETE ON company_info
+FOR EACH ROW EXECUTE FUNCTION audit_row('company_profile', 'id');
+
+-- Invoices inserted without a company are issued by the default one, and
+-- are numbered from the series of the company that issues them
+CREATE OR REPLACE FUNCTION assign_invoice_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.company_id IS NULL THEN
+        SELECT id INTO NEW.company_id FROM company_info WHERE is_default;
+    END IF;
+    IF NEW.invoice_number IS NULL OR NEW.invoice_number = '' THEN
+        NEW.invoice_number := next_document_number(
+            COALESCE((SELECT invoice_series FROM company_info WHERE id = NEW.company_id), 'invoice'), NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- save_company_profile creates (p_id NULL) or updates a company profile, for
+-- the Supabase backend and invoice-service. p_profile holds the profile
+-- columns as a JSON object keyed by column name. A new profile gets its own
+-- invoice numbering series, 'invoice:' followed by its ID, whose prefix is
+-- p_prefix; p_prefix is ignored on update, where the series is edited like
+-- any other. Making a profile the default takes that from the previous
+-- default; the default itself cannot be unset, only moved.
+--
+--   PT404 - company profile p_id does not exist
+--   PT409 - another company's invoice series already uses p_prefix
+--   PT412 - company profile is no longer at p_version
+CREATE OR REPLACE FUNCTION save_company_profile(p_id UUID, p_profile JSONB, p_prefix TEXT DEFAULT NULL, p_version INTEGER DEFAULT NULL)
+RETURNS company_info AS $$
+DECLARE
+    v_profile company_info%ROWTYPE;
+    v_id UUID := COALESCE(p_id, gen_random_uuid());
+    v_version INTEGER;
+BEGIN
+    IF p_id IS NULL THEN
+        IF EXISTS (
+            SELECT 1 FROM number_series
+            WHERE (series_key = 'invoice' OR series_key LIKE 'invoice:%') AND upper(prefix) = upper(p_prefix)
+        ) THEN
+            RAISE EXCEPTION 'invoice number prefix % is already used', p_prefix USING ERRCODE = 'PT409';
+        END IF;
+        INSERT INTO number_series (series_key, prefix, pattern, reset, padding)
+        VALUES ('invoice:' || v_id, p_prefix, '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4);
+    ELSE
+        SELECT version INTO v_version FROM company_info WHERE id = p_id FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'company profile % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'company profile % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+    END IF;
+
+    IF COALESCE((p_profile->>'is_default')::BOOLEAN, FALSE) THEN
+        UPDATE company_info SET is_default = FALSE, version = version + 1, updated_at = NOW()
+        WHERE is_default AND id <> v_id;
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO company_info (id, name, legal_name, tax_id, address, city, postal_code, country,
+            email, phone, website, bank_name, bank_account_name, bank_account_number, bank_swift, logo,
+            invoice_series, is_default)
+        SELECT v_id, r.name, NULLIF(r.legal_name, ''), NULLIF(r.tax_id, ''), NULLIF(r.address, ''),
+            NULLIF(r.city, ''), NULLIF(r.postal_code, ''), NULLIF(r.country, ''), NULLIF(r.email, ''),
+            NULLIF(r.phone, ''), NULLIF(r.website, ''), NULLIF(r.bank_name, ''), NULLIF(r.bank_account_name, ''),
+            NULLIF(r.bank_account_number, ''), NULLIF(r.bank_swift, ''), NULLIF(r.logo, ''),
+            'invoice:' || v_id, COALESCE(r.is_default, FALSE)
+        FROM jsonb_populate_record(NULL::company_info, p_profile) AS r
+        RETURNING * INTO v_profile;
+    ELSE
+        UPDATE company_info c
+        SET name = r.name, legal_name = NULLIF(r.legal_name, ''), tax_id = NULLIF(r.tax_id, ''),
+            address = NULLIF(r.address, ''), city = NULLIF(r.city, ''), postal_code = NULLIF(r.postal_code, ''),
+            country = NULLIF(r.country, ''), email = NULLIF(r.email, ''), phone = NULLIF(r.phone, ''),
+            website = NULLIF(r.website, ''), bank_name = NULLIF(r.bank_name, ''),
+            bank_account_name = NULLIF(r.bank_account_name, ''), bank_account_number = NULLIF(r.bank_account_number, ''),
+            bank_swift = NULLIF(r.bank_swift, ''), logo = NULLIF(r.logo, ''),
+            is_default = c.is_default OR COALESCE(r.is_default, FALSE),
+            version = c.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::company_info, p_profile) AS r
+        WHERE c.id = p_id
+        RETURNING c.* INTO v_profile;
+    END IF;
+
+    RETURN v_profile;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_invoice stores the company the invoice is issued from
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+BEGIN
+    INSERT INTO invoices (company_id, customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT r.company_id, r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE company_info IS 'Company profiles: the companies invoices are issued from';
+COMMENT ON COLUMN company_info.logo IS 'PNG or JPEG logo printed on the invoice, as a data URL';
+COMMENT ON COLUMN company_info.invoice_series IS 'Numbering series of the invoices the company issues';
+COMMENT ON COLUMN company_info.is_default IS 'Issues the invoices that do not name a company; set on exactly one profile';
+COMMENT ON COLUMN company_info.version IS 'Goes up with every change to the profile; the ETag of the profile';
+COMMENT ON COLUMN invoices.company_id IS 'Company profile the invoice was issued from';
+COMMENT ON FUNCTION save_company_profile IS 'Creates or updates a company profile, with the numbering series of a new one';
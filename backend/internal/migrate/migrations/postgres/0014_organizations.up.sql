@@ -0,0 +1,646 @@
+-- =====================================================
+-- ORGANIZATIONS
+-- Several businesses share one installation. Every customer, invoice,
+-- payment, currency rate, company profile, product, price list and
+-- numbering series belongs to an organization, in org_id, and row level
+-- security only lets a request see and change the rows of its own
+-- organization; invoice lines, payment reminders, product prices and price
+-- list rules follow their invoice, product or price list. Each organization
+-- numbers its documents from its own series, so document numbers are unique
+-- within an organization.
+--
+-- The organization of a request is taken from, in order:
+--   app.org_id          - set per transaction by the native Postgres backend
+--   request.jwt.claims  - the org_id claim of the caller's JWT, at the top
+--                         level or in app_metadata
+--   request.headers     - the X-Org-ID header, which the API gateway, the
+--                         services and the Supabase backend set to the
+--                         organization they resolved for the request
+--
+-- Everything that existed before belongs to the default organization.
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO organizations (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'Default organization')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION current_org()
+RETURNS UUID AS $$
+    SELECT NULLIF(COALESCE(
+        NULLIF(current_setting('app.org_id', true), ''),
+        NULLIF(current_setting('request.jwt.claims', true), '')::jsonb->>'org_id',
+        NULLIF(current_setting('request.jwt.claims', true), '')::jsonb#>>'{app_metadata,org_id}',
+        NULLIF(current_setting('request.headers', true), '')::json->>'x-org-id'
+    ), '')::UUID;
+$$ LANGUAGE sql STABLE;
+
+-- A constant default fills existing rows without rewriting them or firing
+-- their triggers; new rows then default to the request's organization
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE currency_rates ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE company_info ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE price_lists ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE number_series ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE number_series_counters ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE document_numbers ADD COLUMN IF NOT EXISTS org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+
+ALTER TABLE customers ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE invoices ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE payments ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE currency_rates ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE company_info ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE products ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE price_lists ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE number_series ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE number_series_counters ALTER COLUMN org_id SET DEFAULT current_org();
+ALTER TABLE document_numbers ALTER COLUMN org_id SET DEFAULT current_org();
+
+-- E-mail addresses, currency pairs and idempotency keys are unique within
+-- an organization
+ALTER TABLE customers DROP CONSTRAINT IF EXISTS customers_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_org_email ON customers(org_id, email);
+
+ALTER TABLE currency_rates DROP CONSTRAINT IF EXISTS currency_rates_from_currency_to_currency_key;
+DROP INDEX IF EXISTS idx_currency_rates_pair;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_currency_rates_org_pair ON currency_rates(org_id, from_currency, to_currency);
+
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency_key
+ON payments(org_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_org_id ON invoices(org_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_payments_org_id ON payments(org_id, payment_date);
+
+-- Series keys, document numbers, SKUs and the default company profile are
+-- unique within an organization too
+ALTER TABLE company_info DROP CONSTRAINT IF EXISTS company_info_invoice_series_fkey;
+ALTER TABLE number_series_counters DROP CONSTRAINT IF EXISTS number_series_counters_series_key_fkey;
+ALTER TABLE document_numbers DROP CONSTRAINT IF EXISTS document_numbers_series_key_fkey;
+
+ALTER TABLE number_series DROP CONSTRAINT IF EXISTS number_series_pkey;
+ALTER TABLE number_series ADD CONSTRAINT number_series_pkey PRIMARY KEY (org_id, series_key);
+ALTER TABLE number_series_counters DROP CONSTRAINT IF EXISTS number_series_counters_pkey;
+ALTER TABLE number_series_counters ADD CONSTRAINT number_series_counters_pkey PRIMARY KEY (org_id, series_key, period);
+ALTER TABLE document_numbers DROP CONSTRAINT IF EXISTS document_numbers_pkey;
+ALTER TABLE document_numbers DROP CONSTRAINT IF EXISTS document_numbers_series_key_number_key;
+ALTER TABLE document_numbers ADD CONSTRAINT document_numbers_pkey PRIMARY KEY (org_id, series_key, period, sequence);
+ALTER TABLE document_numbers ADD CONSTRAINT document_numbers_series_key_number_key UNIQUE (org_id, series_key, number);
+
+ALTER TABLE company_info ADD CONSTRAINT company_info_invoice_series_fkey
+    FOREIGN KEY (org_id, invoice_series) REFERENCES number_series(org_id, series_key);
+ALTER TABLE number_series_counters ADD CONSTRAINT number_series_counters_series_key_fkey
+    FOREIGN KEY (org_id, series_key) REFERENCES number_series(org_id, series_key);
+ALTER TABLE document_numbers ADD CONSTRAINT document_numbers_series_key_fkey
+    FOREIGN KEY (org_id, series_key) REFERENCES number_series(org_id, series_key);
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_invoice_number_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_org_invoice_number ON invoices(org_id, invoice_number);
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_receipt_number_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_org_receipt_number ON payments(org_id, receipt_number);
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_sku_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_org_sku ON products(org_id, sku);
+CREATE INDEX IF NOT EXISTS idx_price_lists_org_id ON price_lists(org_id, name);
+DROP INDEX IF EXISTS idx_company_info_default;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_info_default ON company_info(org_id) WHERE is_default;
+
+-- Row level security: a request reaches the rows of its organization only
+ALTER TABLE organizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE invoice_items ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Members read their organization" ON organizations;
+CREATE POLICY "Members read their organization" ON organizations FOR SELECT USING (id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on customers" ON customers;
+DROP POLICY IF EXISTS "Organization customers" ON customers;
+CREATE POLICY "Organization customers" ON customers FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on invoices" ON invoices;
+DROP POLICY IF EXISTS "Organization invoices" ON invoices;
+CREATE POLICY "Organization invoices" ON invoices FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on payments" ON payments;
+DROP POLICY IF EXISTS "Organization payments" ON payments;
+CREATE POLICY "Organization payments" ON payments FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on currency_rates" ON currency_rates;
+DROP POLICY IF EXISTS "Organization currency rates" ON currency_rates;
+CREATE POLICY "Organization currency rates" ON currency_rates FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Organization invoice items" ON invoice_items;
+CREATE POLICY "Organization invoice items" ON invoice_items FOR ALL
+    USING (EXISTS (SELECT 1 FROM invoices i WHERE i.id = invoice_id AND i.org_id = current_org()))
+    WITH CHECK (EXISTS (SELECT 1 FROM invoices i WHERE i.id = invoice_id AND i.org_id = current_org()));
+
+DROP POLICY IF EXISTS "Allow all operations on payment_reminders" ON payment_reminders;
+DROP POLICY IF EXISTS "Organization payment reminders" ON payment_reminders;
+CREATE POLICY "Organization payment reminders" ON payment_reminders FOR ALL
+    USING (EXISTS (SELECT 1 FROM invoices i WHERE i.id = invoice_id AND i.org_id = current_org()))
+    WITH CHECK (EXISTS (SELECT 1 FROM invoices i WHERE i.id = invoice_id AND i.org_id = current_org()));
+
+DROP POLICY IF EXISTS "Allow all operations on company_info" ON company_info;
+DROP POLICY IF EXISTS "Organization company profiles" ON company_info;
+CREATE POLICY "Organization company profiles" ON company_info FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on products" ON products;
+DROP POLICY IF EXISTS "Organization products" ON products;
+CREATE POLICY "Organization products" ON products FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on product_prices" ON product_prices;
+DROP POLICY IF EXISTS "Organization product prices" ON product_prices;
+CREATE POLICY "Organization product prices" ON product_prices FOR ALL
+    USING (EXISTS (SELECT 1 FROM products p WHERE p.id = product_id AND p.org_id = current_org()))
+    WITH CHECK (EXISTS (SELECT 1 FROM products p WHERE p.id = product_id AND p.org_id = current_org()));
+
+DROP POLICY IF EXISTS "Allow all operations on price_lists" ON price_lists;
+DROP POLICY IF EXISTS "Organization price lists" ON price_lists;
+CREATE POLICY "Organization price lists" ON price_lists FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on price_list_rules" ON price_list_rules;
+DROP POLICY IF EXISTS "Organization price list rules" ON price_list_rules;
+CREATE POLICY "Organization price list rules" ON price_list_rules FOR ALL
+    USING (EXISTS (SELECT 1 FROM price_lists l WHERE l.id = price_list_id AND l.org_id = current_org()))
+    WITH CHECK (EXISTS (SELECT 1 FROM price_lists l WHERE l.id = price_list_id AND l.org_id = current_org())
+        AND EXISTS (SELECT 1 FROM products p WHERE p.id = product_id AND p.org_id = current_org()));
+
+DROP POLICY IF EXISTS "Allow all operations on number_series" ON number_series;
+DROP POLICY IF EXISTS "Organization number series" ON number_series;
+CREATE POLICY "Organization number series" ON number_series FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on number_series_counters" ON number_series_counters;
+DROP POLICY IF EXISTS "Organization number series counters" ON number_series_counters;
+CREATE POLICY "Organization number series counters" ON number_series_counters FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Allow all operations on document_numbers" ON document_numbers;
+DROP POLICY IF EXISTS "Organization document numbers" ON document_numbers;
+CREATE POLICY "Organization document numbers" ON document_numbers FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+-- Views run with the caller's rights, so the policies above apply to them
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales WITH (security_invoker = true) AS
+SELECT
+    i.org_id,
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY i.org_id, COALESCE(it.sku, it.description), it.unit, i.currency;
+
+ALTER VIEW invoice_analytics SET (security_invoker = true);
+ALTER VIEW payment_summary SET (security_invoker = true);
+
+-- Audit log: every entry records the organization it was written in, and
+-- is visible to that organization only.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS org_id UUID DEFAULT '00000000-0000-0000-0000-000000000001';
+ALTER TABLE audit_log ALTER COLUMN org_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS idx_audit_log_org_id ON audit_log(org_id, entity_type, entity_id, id);
+
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS "Audited changes are appended" ON audit_log;
+CREATE POLICY "Audited changes are appended" ON audit_log FOR INSERT WITH CHECK (true);
+DROP POLICY IF EXISTS "Organization history" ON audit_log;
+CREATE POLICY "Organization history" ON audit_log FOR SELECT USING (org_id = current_org());
+
+-- audit_row records the organization of the changed row, or of the request
+-- for rows that follow a parent, like invoice lines. org_id is left out of
+-- the diff.
+CREATE OR REPLACE FUNCTION audit_row()
+RETURNS TRIGGER AS $$
+DECLARE
+    ignored TEXT[] := ARRAY['created_at', 'updated_at', 'search_vector', 'idempotency_key', 'version', 'org_id'];
+    v_old JSONB := '{}';
+    v_new JSONB := '{}';
+    v_changes JSONB;
+    v_row JSONB;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := to_jsonb(OLD) - ignored;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := to_jsonb(NEW) - ignored;
+    END IF;
+
+    SELECT jsonb_object_agg(key, jsonb_build_object('from', v_old->key, 'to', v_new->key)) INTO v_changes
+    FROM (SELECT jsonb_object_keys(v_old || v_new) AS key) AS keys
+    WHERE COALESCE(v_old->key, 'null') IS DISTINCT FROM COALESCE(v_new->key, 'null');
+
+    IF TG_OP = 'UPDATE' AND v_changes IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        v_row := to_jsonb(OLD);
+    ELSE
+        v_row := to_jsonb(NEW);
+    END IF;
+
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes, org_id)
+    VALUES (
+        TG_ARGV[0],
+        v_row->>TG_ARGV[1],
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        audit_actor(),
+        COALESCE(v_changes, '{}'),
+        COALESCE((v_row->>'org_id')::UUID, current_org())
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- record_payment only records payments on invoices of the caller's
+-- organization, and looks idempotency keys up within it
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_paid DECIMAL;
+    v_status TEXT;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    v_paid := COALESCE(v_invoice.paid_amount, 0) + p_amount;
+    v_status := 'partially_paid';
+    IF v_paid >= v_invoice.total THEN
+        v_status := 'paid';
+        v_paid := v_invoice.total;
+    END IF;
+
+    UPDATE invoices
+    SET paid_amount = v_paid,
+        payment_status = v_status,
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- delete_customer only deletes customers of the caller's organization
+CREATE OR REPLACE FUNCTION delete_customer(p_customer_id UUID, p_force BOOLEAN DEFAULT FALSE, p_version INTEGER DEFAULT NULL)
+RETURNS INTEGER AS $$
+DECLARE
+    v_version INTEGER;
+    v_invoices INTEGER;
+BEGIN
+    SELECT version INTO v_version FROM customers WHERE id = p_customer_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+    IF p_version IS NOT NULL AND p_version <> v_version THEN
+        RAISE EXCEPTION 'customer % is at version %, not %', p_customer_id, v_version, p_version USING ERRCODE = 'PT412';
+    END IF;
+
+    SELECT COUNT(*) INTO v_invoices FROM invoices WHERE customer_id = p_customer_id;
+    IF v_invoices > 0 AND NOT p_force THEN
+        RAISE EXCEPTION 'customer % has % invoices', p_customer_id, v_invoices USING ERRCODE = 'PT409';
+    END IF;
+
+    DELETE FROM invoices WHERE customer_id = p_customer_id;
+    DELETE FROM customers WHERE id = p_customer_id;
+    RETURN v_invoices;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_invoice only bills customers of the caller's organization, and
+-- files the invoice under it
+--
+--   PT404 - customer does not exist in the caller's organization
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_org UUID;
+BEGIN
+    SELECT org_id INTO v_org FROM customers
+    WHERE id = (p_invoice->>'customer_id')::UUID AND org_id = current_org();
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_invoice->>'customer_id' USING ERRCODE = 'PT404';
+    END IF;
+
+    INSERT INTO invoices (org_id, company_id, customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT v_org, r.company_id, r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+-- next_document_number allocates from the series of the organization p_org
+DROP FUNCTION IF EXISTS next_document_number(TEXT, UUID);
+CREATE OR REPLACE FUNCTION next_document_number(p_org UUID, p_series TEXT, p_document_id UUID)
+RETURNS TEXT AS $$
+DECLARE
+    v_series number_series%ROWTYPE;
+    v_period TEXT;
+    v_seq INTEGER;
+    v_number TEXT;
+BEGIN
+    SELECT * INTO v_series FROM number_series WHERE org_id = p_org AND series_key = p_series;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'number series % not found', p_series USING ERRCODE = 'PT404';
+    END IF;
+
+    v_period := CASE v_series.reset
+        WHEN 'yearly' THEN TO_CHAR(NOW(), 'YYYY')
+        WHEN 'monthly' THEN TO_CHAR(NOW(), 'YYYY-MM')
+        ELSE ''
+    END;
+
+    INSERT INTO number_series_counters (org_id, series_key, period, last_value)
+    VALUES (p_org, p_series, v_period, 1)
+    ON CONFLICT (org_id, series_key, period)
+    DO UPDATE SET last_value = number_series_counters.last_value + 1
+    RETURNING last_value INTO v_seq;
+
+    v_number := v_series.pattern;
+    v_number := REPLACE(v_number, '{PREFIX}', v_series.prefix);
+    v_number := REPLACE(v_number, '{YEAR}', TO_CHAR(NOW(), 'YYYY'));
+    v_number := REPLACE(v_number, '{YY}', TO_CHAR(NOW(), 'YY'));
+    v_number := REPLACE(v_number, '{MONTH}', TO_CHAR(NOW(), 'MM'));
+    v_number := REPLACE(v_number, '{NUMBER}', LPAD(v_seq::TEXT, v_series.padding, '0'));
+
+    INSERT INTO document_numbers (org_id, series_key, period, sequence, number, document_id)
+    VALUES (p_org, p_series, v_period, v_seq, v_number, p_document_id);
+
+    RETURN v_number;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Documents are numbered from their organization's series, and an invoice
+-- is issued by a company profile of its organization
+--
+--   PT404 - the invoice names a company profile of another organization
+CREATE OR REPLACE FUNCTION assign_invoice_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.company_id IS NULL THEN
+        SELECT id INTO NEW.company_id FROM company_info WHERE org_id = NEW.org_id AND is_default;
+    ELSIF NOT EXISTS (SELECT 1 FROM company_info WHERE id = NEW.company_id AND org_id = NEW.org_id) THEN
+        RAISE EXCEPTION 'company profile % not found', NEW.company_id USING ERRCODE = 'PT404';
+    END IF;
+    IF NEW.invoice_number IS NULL OR NEW.invoice_number = '' THEN
+        NEW.invoice_number := next_document_number(NEW.org_id,
+            COALESCE((SELECT invoice_series FROM company_info WHERE id = NEW.company_id), 'invoice'), NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION assign_receipt_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.receipt_number IS NULL OR NEW.receipt_number = '' THEN
+        NEW.receipt_number := next_document_number(NEW.org_id, 'receipt', NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- save_product only sees the products of the caller's organization, and
+-- looks SKUs up within it
+CREATE OR REPLACE FUNCTION save_product(p_id UUID, p_product JSONB, p_prices JSONB, p_version INTEGER DEFAULT NULL)
+RETURNS products AS $$
+DECLARE
+    v_product products%ROWTYPE;
+    v_version INTEGER;
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM products
+        WHERE org_id = current_org() AND sku = p_product->>'sku' AND id IS DISTINCT FROM p_id
+    ) THEN
+        RAISE EXCEPTION 'SKU % is already used by another product', p_product->>'sku' USING ERRCODE = 'PT409';
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO products (sku, name, description, unit, tax_rate, active)
+        SELECT r.sku, r.name, NULLIF(r.description, ''), NULLIF(r.unit, ''), r.tax_rate, COALESCE(r.active, TRUE)
+        FROM jsonb_populate_record(NULL::products, p_product) AS r
+        RETURNING * INTO v_product;
+    ELSE
+        SELECT version INTO v_version FROM products WHERE id = p_id AND org_id = current_org() FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'product % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'product % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+
+        UPDATE products p
+        SET sku = r.sku, name = r.name, description = NULLIF(r.description, ''), unit = NULLIF(r.unit, ''),
+            tax_rate = r.tax_rate, active = COALESCE(r.active, TRUE), version = p.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::products, p_product) AS r
+        WHERE p.id = p_id
+        RETURNING p.* INTO v_product;
+    END IF;
+
+    DELETE FROM product_prices
+    WHERE product_id = v_product.id
+      AND currency NOT IN (SELECT r.currency FROM jsonb_populate_recordset(NULL::product_prices, p_prices) AS r);
+
+    INSERT INTO product_prices (product_id, currency, unit_price)
+    SELECT v_product.id, r.currency, r.unit_price
+    FROM jsonb_populate_recordset(NULL::product_prices, p_prices) AS r
+    ON CONFLICT (product_id, currency) DO UPDATE SET unit_price = excluded.unit_price
+    WHERE product_prices.unit_price <> excluded.unit_price;
+
+    RETURN v_product;
+END;
+$$ LANGUAGE plpgsql;
+
+-- save_price_list only sees the price lists and products of the caller's
+-- organization
+CREATE OR REPLACE FUNCTION save_price_list(p_id UUID, p_list JSONB, p_rules JSONB, p_version INTEGER DEFAULT NULL)
+RETURNS price_lists AS $$
+DECLARE
+    v_list price_lists%ROWTYPE;
+    v_version INTEGER;
+    v_product UUID;
+BEGIN
+    SELECT r.product_id INTO v_product
+    FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r
+    WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.id = r.product_id AND p.org_id = current_org())
+    LIMIT 1;
+    IF FOUND THEN
+        RAISE EXCEPTION 'product % not found', v_product USING ERRCODE = 'PT409';
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO price_lists (name, description, currency)
+        SELECT r.name, NULLIF(r.description, ''), r.currency
+        FROM jsonb_populate_record(NULL::price_lists, p_list) AS r
+        RETURNING * INTO v_list;
+    ELSE
+        SELECT version INTO v_version FROM price_lists WHERE id = p_id AND org_id = current_org() FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'price list % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'price list % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+
+        UPDATE price_lists l
+        SET name = r.name, description = NULLIF(r.description, ''), currency = r.currency,
+            version = l.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::price_lists, p_list) AS r
+        WHERE l.id = p_id
+        RETURNING l.* INTO v_list;
+    END IF;
+
+    DELETE FROM price_list_rules
+    WHERE price_list_id = v_list.id
+      AND (product_id, min_quantity) NOT IN (
+          SELECT r.product_id, r.min_quantity
+          FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r);
+
+    INSERT INTO price_list_rules (price_list_id, product_id, min_quantity, unit_price, discount_percent)
+    SELECT v_list.id, r.product_id, r.min_quantity, r.unit_price, r.discount_percent
+    FROM jsonb_populate_recordset(NULL::price_list_rules, p_rules) AS r
+    ON CONFLICT (price_list_id, product_id, min_quantity) DO UPDATE
+    SET unit_price = excluded.unit_price, discount_percent = excluded.discount_percent
+    WHERE price_list_rules.unit_price IS DISTINCT FROM excluded.unit_price
+       OR price_list_rules.discount_percent IS DISTINCT FROM excluded.discount_percent;
+
+    RETURN v_list;
+END;
+$$ LANGUAGE plpgsql;
+
+-- save_company_profile only sees the profiles and series of the caller's
+-- organization: the prefix of a new profile's series and the default are
+-- unique within it
+CREATE OR REPLACE FUNCTION save_company_profile(p_id UUID, p_profile JSONB, p_prefix TEXT DEFAULT NULL, p_version INTEGER DEFAULT NULL)
+RETURNS company_info AS $$
+DECLARE
+    v_profile company_info%ROWTYPE;
+    v_id UUID := COALESCE(p_id, gen_random_uuid());
+    v_version INTEGER;
+BEGIN
+    IF p_id IS NULL THEN
+        IF EXISTS (
+            SELECT 1 FROM number_series
+            WHERE org_id = current_org()
+              AND (series_key = 'invoice' OR series_key LIKE 'invoice:%') AND upper(prefix) = upper(p_prefix)
+        ) THEN
+            RAISE EXCEPTION 'invoice number prefix % is already used', p_prefix USING ERRCODE = 'PT409';
+        END IF;
+        INSERT INTO number_series (series_key, prefix, pattern, reset, padding)
+        VALUES ('invoice:' || v_id, p_prefix, '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4);
+    ELSE
+        SELECT version INTO v_version FROM company_info WHERE id = p_id AND org_id = current_org() FOR UPDATE;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'company profile % not found', p_id USING ERRCODE = 'PT404';
+        END IF;
+        IF p_version IS NOT NULL AND p_version <> v_version THEN
+            RAISE EXCEPTION 'company profile % is at version %, not %', p_id, v_version, p_version USING ERRCODE = 'PT412';
+        END IF;
+    END IF;
+
+    IF COALESCE((p_profile->>'is_default')::BOOLEAN, FALSE) THEN
+        UPDATE company_info SET is_default = FALSE, version = version + 1, updated_at = NOW()
+        WHERE org_id = current_org() AND is_default AND id <> v_id;
+    END IF;
+
+    IF p_id IS NULL THEN
+        INSERT INTO company_info (id, name, legal_name, tax_id, address, city, postal_code, country,
+            email, phone, website, bank_name, bank_account_name, bank_account_number, bank_swift, logo,
+            invoice_series, is_default)
+        SELECT v_id, r.name, NULLIF(r.legal_name, ''), NULLIF(r.tax_id, ''), NULLIF(r.address, ''),
+            NULLIF(r.city, ''), NULLIF(r.postal_code, ''), NULLIF(r.country, ''), NULLIF(r.email, ''),
+            NULLIF(r.phone, ''), NULLIF(r.website, ''), NULLIF(r.bank_name, ''), NULLIF(r.bank_account_name, ''),
+            NULLIF(r.bank_account_number, ''), NULLIF(r.bank_swift, ''), NULLIF(r.logo, ''),
+            'invoice:' || v_id, COALESCE(r.is_default, FALSE)
+        FROM jsonb_populate_record(NULL::company_info, p_profile) AS r
+        RETURNING * INTO v_profile;
+    ELSE
+        UPDATE company_info c
+        SET name = r.name, legal_name = NULLIF(r.legal_name, ''), tax_id = NULLIF(r.tax_id, ''),
+            address = NULLIF(r.address, ''), city = NULLIF(r.city, ''), postal_code = NULLIF(r.postal_code, ''),
+            country = NULLIF(r.country, ''), email = NULLIF(r.email, ''), phone = NULLIF(r.phone, ''),
+            website = NULLIF(r.website, ''), bank_name = NULLIF(r.bank_name, ''),
+            bank_account_name = NULLIF(r.bank_account_name, ''), bank_account_number = NULLIF(r.bank_account_number, ''),
+            bank_swift = NULLIF(r.bank_swift, ''), logo = NULLIF(r.logo, ''),
+            is_default = c.is_default OR COALESCE(r.is_default, FALSE),
+            version = c.version + 1, updated_at = NOW()
+        FROM jsonb_populate_record(NULL::company_info, p_profile) AS r
+        WHERE c.id = p_id
+        RETURNING c.* INTO v_profile;
+    END IF;
+
+    RETURN v_profile;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE organizations IS 'Tenants: each organization sees only its own records';
+COMMENT ON FUNCTION current_org IS 'Organization of the current transaction or PostgREST request';
+COMMENT ON COLUMN customers.org_id IS 'Organization the customer belongs to';
+COMMENT ON COLUMN invoices.org_id IS 'Organization the invoice belongs to';
+COMMENT ON COLUMN payments.org_id IS 'Organization the payment belongs to';
+COMMENT ON COLUMN currency_rates.org_id IS 'Organization the rate belongs to';
+COMMENT ON COLUMN audit_log.org_id IS 'Organization the change was made in';
+COMMENT ON COLUMN company_info.org_id IS 'Organization the company profile belongs to';
+COMMENT ON COLUMN products.org_id IS 'Organization the product belongs to';
+COMMENT ON COLUMN price_lists.org_id IS 'Organization the price list belongs to';
+COMMENT ON COLUMN number_series.org_id IS 'Organization the series numbers documents for';
+COMMENT ON COLUMN number_series_counters.org_id IS 'Organization of the counter''s series';
+COMMENT ON COLUMN document_numbers.org_id IS 'Organization the number was issued in';
+COMMENT ON COLUMN company_info.is_default IS 'Issues the invoices that do not name a company; set on exactly one profile of each organization';
+COMMENT ON FUNCTION next_document_number IS 'Allocates the next number of an organization''s series';
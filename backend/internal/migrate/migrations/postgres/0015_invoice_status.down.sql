@@ -0,0 +1,135 @@
+-- Statuses become free text again and keep the values the lifecycle gave
+-- them; payment_status goes back to the payment status trigger.
+
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_org UUID;
+BEGIN
+    SELECT org_id INTO v_org FROM customers
+    WHERE id = (p_invoice->>'customer_id')::UUID AND org_id = current_org();
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_invoice->>'customer_id' USING ERRCODE = 'PT404';
+    END IF;
+
+    INSERT INTO invoices (org_id, company_id, customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT v_org, r.company_id, r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'pending'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_paid DECIMAL;
+    v_status TEXT;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    v_paid := COALESCE(v_invoice.paid_amount, 0) + p_amount;
+    v_status := 'partially_paid';
+    IF v_paid >= v_invoice.total THEN
+        v_status := 'paid';
+        v_paid := v_invoice.total;
+    END IF;
+
+    UPDATE invoices
+    SET paid_amount = v_paid,
+        payment_status = v_status,
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales WITH (security_invoker = true) AS
+SELECT
+    i.org_id,
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY i.org_id, COALESCE(it.sku, it.description), it.unit, i.currency;
+
+DROP FUNCTION IF EXISTS mark_overdue_invoices();
+DROP TRIGGER IF EXISTS invoice_status ON invoices;
+DROP FUNCTION IF EXISTS check_invoice_status();
+DROP FUNCTION IF EXISTS invoice_transitions(TEXT);
+DROP FUNCTION IF EXISTS invoice_status(TEXT, DECIMAL, DECIMAL, TIMESTAMP WITH TIME ZONE, TIMESTAMP WITH TIME ZONE);
+
+DROP INDEX IF EXISTS idx_invoices_status;
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS check_invoice_status;
+ALTER TABLE invoices ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE invoices ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE OR REPLACE FUNCTION update_payment_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.due_date < NOW() AND NEW.payment_status NOT IN ('paid', 'partially_paid') THEN
+        NEW.payment_status := 'overdue';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_update_payment_status ON invoices;
+CREATE TRIGGER trigger_update_payment_status
+BEFORE INSERT OR UPDATE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION update_payment_status();
+
+ALTER TABLE invoices
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS sent_at,
+    DROP COLUMN IF EXISTS issued_at;
@@ -0,0 +1,287 @@
+-- =====================================================
+-- INVOICE STATUS LIFECYCLE
+-- An invoice starts as a draft, is issued, and optionally sent to the
+-- customer. From then on its payments and due date move it between sent,
+-- partially_paid, paid and overdue; an invoice that will not be paid is
+-- void (nothing was paid) or written_off. Paid, void and written_off are
+-- final.
+--
+-- The invoice_status trigger enforces this for every write, whichever
+-- backend makes it: a status set by hand must be a transition the current
+-- status allows, and the status is then settled from the payments and due
+-- date. Refused changes raise PT422, which the backends report as a
+-- conflict.
+--
+-- Manual transitions (internal/lifecycle):
+--   draft          -> issued, sent, void
+--   issued         -> sent, void, written_off
+--   sent, overdue  -> sent, void, written_off
+--   partially_paid -> sent, written_off
+-- An invoice with payments cannot be void.
+-- =====================================================
+
+ALTER TABLE invoices
+    ADD COLUMN IF NOT EXISTS issued_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS sent_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS status_reason TEXT;
+
+-- The lifecycle trigger replaces the payment status trigger
+DROP TRIGGER IF EXISTS trigger_update_payment_status ON invoices;
+DROP FUNCTION IF EXISTS update_payment_status();
+
+-- Statuses were free text. Drafts stay drafts and cancelled invoices are
+-- void, or written off when something was paid on them. Every other invoice
+-- was issued when it was created, sent if its status said so, and from now
+-- on follows its payments and due date; an invoice marked paid by hand with
+-- nothing recorded against it is open again. The audit and version triggers
+-- are left out of the conversion.
+ALTER TABLE invoices DISABLE TRIGGER USER;
+
+UPDATE invoices SET issued_at = created_at WHERE status IS DISTINCT FROM 'draft';
+UPDATE invoices SET sent_at = created_at WHERE status = 'sent';
+UPDATE invoices SET status = CASE
+    WHEN status = 'draft' THEN 'draft'
+    WHEN status IN ('cancelled', 'canceled', 'void') AND COALESCE(paid_amount, 0) > 0 THEN 'written_off'
+    WHEN status IN ('cancelled', 'canceled', 'void') THEN 'void'
+    WHEN COALESCE(paid_amount, 0) >= total THEN 'paid'
+    WHEN (due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE THEN 'overdue'
+    WHEN COALESCE(paid_amount, 0) > 0 THEN 'partially_paid'
+    WHEN status = 'sent' THEN 'sent'
+    ELSE 'issued'
+END;
+UPDATE invoices SET payment_status = CASE
+    WHEN status IN ('paid', 'overdue') THEN status
+    WHEN COALESCE(paid_amount, 0) > 0 THEN 'partially_paid'
+    ELSE 'unpaid'
+END;
+
+ALTER TABLE invoices ENABLE TRIGGER USER;
+
+ALTER TABLE invoices ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE invoices ALTER COLUMN status SET NOT NULL;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS check_invoice_status;
+ALTER TABLE invoices ADD CONSTRAINT check_invoice_status
+CHECK (status IN ('draft', 'issued', 'sent', 'partially_paid', 'paid', 'overdue', 'void', 'written_off'));
+
+DROP INDEX IF EXISTS idx_invoices_status;
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(org_id, status, due_date);
+
+-- invoice_status returns the status an invoice in p_status has today given
+-- its payments and due date (lifecycle.Settle)
+CREATE OR REPLACE FUNCTION invoice_status(
+    p_status TEXT,
+    p_total DECIMAL,
+    p_paid DECIMAL,
+    p_due_date TIMESTAMP WITH TIME ZONE,
+    p_sent_at TIMESTAMP WITH TIME ZONE
+)
+RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN p_status IN ('draft', 'void', 'written_off') THEN p_status
+        WHEN COALESCE(p_paid, 0) >= p_total THEN 'paid'
+        WHEN (p_due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE THEN 'overdue'
+        WHEN COALESCE(p_paid, 0) > 0 THEN 'partially_paid'
+        WHEN p_sent_at IS NOT NULL THEN 'sent'
+        ELSE 'issued'
+    END;
+$$ LANGUAGE sql STABLE;
+
+-- invoice_transitions returns the statuses an invoice in p_status can be
+-- moved to by hand
+CREATE OR REPLACE FUNCTION invoice_transitions(p_status TEXT)
+RETURNS TEXT[] AS $$
+    SELECT CASE p_status
+        WHEN 'draft' THEN ARRAY['issued', 'sent', 'void']
+        WHEN 'issued' THEN ARRAY['sent', 'void', 'written_off']
+        WHEN 'sent' THEN ARRAY['sent', 'void', 'written_off']
+        WHEN 'overdue' THEN ARRAY['sent', 'void', 'written_off']
+        WHEN 'partially_paid' THEN ARRAY['sent', 'written_off']
+        ELSE ARRAY[]::TEXT[]
+    END;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- check_invoice_status refuses status changes the lifecycle does not allow,
+-- records when an invoice was issued and sent, and settles its status and
+-- payment status. A status that only follows from the payments and due
+-- date, as record_payment and mark_overdue_invoices write it, needs no
+-- transition; nor does a new invoice written with its settled status, as
+-- the native backend writes them.
+CREATE OR REPLACE FUNCTION check_invoice_status()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_allowed BOOLEAN;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.status := COALESCE(NEW.status, 'draft');
+        IF NEW.status NOT IN ('draft', 'issued', 'sent')
+            AND NEW.status IS DISTINCT FROM invoice_status('issued', NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) THEN
+            RAISE EXCEPTION 'a new invoice is draft, issued or sent, not %', NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF NEW.status <> 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    ELSIF NEW.status IS DISTINCT FROM OLD.status
+        AND NEW.status IS DISTINCT FROM invoice_status(OLD.status, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) THEN
+        IF NEW.status = 'void' AND COALESCE(NEW.paid_amount, 0) > 0 THEN
+            RAISE EXCEPTION 'the invoice has payments; write it off instead of voiding it' USING ERRCODE = 'PT422';
+        END IF;
+        SELECT EXISTS (
+            SELECT 1 FROM unnest(invoice_transitions(OLD.status)) AS t
+            WHERE t = NEW.status
+               OR invoice_status(t, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) = NEW.status
+        ) INTO v_allowed;
+        IF NOT v_allowed THEN
+            RAISE EXCEPTION 'the invoice is % and cannot become %', OLD.status, NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF OLD.status = 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    END IF;
+
+    NEW.status := invoice_status(NEW.status, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at);
+    NEW.payment_status := CASE
+        WHEN NEW.status IN ('paid', 'overdue') THEN NEW.status
+        WHEN COALESCE(NEW.paid_amount, 0) > 0 THEN 'partially_paid'
+        ELSE 'unpaid'
+    END;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS invoice_status ON invoices;
+CREATE TRIGGER invoice_status
+BEFORE INSERT OR UPDATE ON invoices
+FOR EACH ROW EXECUTE FUNCTION check_invoice_status();
+
+-- mark_overdue_invoices marks the open invoices of every organization whose
+-- due date has passed as overdue and returns how many it marked. It runs
+-- with its owner's rights so one call covers every organization.
+CREATE OR REPLACE FUNCTION mark_overdue_invoices()
+RETURNS INTEGER AS $$
+DECLARE
+    v_marked INTEGER;
+BEGIN
+    UPDATE invoices SET status = 'overdue'
+    WHERE status IN ('issued', 'sent', 'partially_paid')
+      AND (due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE
+      AND paid_amount < total;
+    GET DIAGNOSTICS v_marked = ROW_COUNT;
+    RETURN v_marked;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public;
+
+-- record_payment only takes payments on issued, unpaid invoices; the
+-- invoice_status trigger settles the status
+--
+--   PT422 - the invoice is a draft, paid, void or written off
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    UPDATE invoices
+    SET paid_amount = LEAST(COALESCE(v_invoice.paid_amount, 0) + p_amount, v_invoice.total),
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_invoice creates drafts unless told otherwise
+CREATE OR REPLACE FUNCTION create_invoice(p_invoice JSONB, p_items JSONB)
+RETURNS invoices AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_org UUID;
+BEGIN
+    SELECT org_id INTO v_org FROM customers
+    WHERE id = (p_invoice->>'customer_id')::UUID AND org_id = current_org();
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_invoice->>'customer_id' USING ERRCODE = 'PT404';
+    END IF;
+
+    INSERT INTO invoices (org_id, company_id, customer_id, subtotal, tax, discount, total, status, notes, due_date,
+        currency, payment_status, paid_amount)
+    SELECT v_org, r.company_id, r.customer_id, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), r.total,
+        COALESCE(r.status, 'draft'), r.notes, r.due_date, COALESCE(r.currency, 'USD'), 'unpaid', 0
+    FROM jsonb_populate_record(NULL::invoices, p_invoice) AS r
+    RETURNING * INTO v_invoice;
+
+    INSERT INTO invoice_items (invoice_id, position, product_id, sku, description, quantity, unit, unit_price,
+        price_rule, tax_rate, discount, total)
+    SELECT v_invoice.id, r.position, r.product_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, NULLIF(r.price_rule, ''), r.tax_rate, COALESCE(r.discount, 0), r.total
+    FROM jsonb_populate_recordset(NULL::invoice_items, p_items) AS r;
+
+    RETURN v_invoice;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drafts and void invoices did not sell anything
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales WITH (security_invoker = true) AS
+SELECT
+    i.org_id,
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+WHERE i.status NOT IN ('draft', 'void')
+GROUP BY i.org_id, COALESCE(it.sku, it.description), it.unit, i.currency;
+
+COMMENT ON COLUMN invoices.status IS 'Lifecycle status: draft, issued, sent, partially_paid, paid, overdue, void, written_off';
+COMMENT ON COLUMN invoices.issued_at IS 'When the invoice left draft';
+COMMENT ON COLUMN invoices.sent_at IS 'When the invoice was last sent to the customer';
+COMMENT ON COLUMN invoices.status_reason IS 'Why the invoice was voided or written off';
+COMMENT ON FUNCTION invoice_status IS 'Status of an invoice given its payments and due date';
+COMMENT ON FUNCTION invoice_transitions IS 'Statuses an invoice can be moved to by hand';
+COMMENT ON FUNCTION mark_overdue_invoices IS 'Marks open invoices past their due date as overdue, in every organization';
@@ -0,0 +1,144 @@
+-- Credits are forgotten: invoices keep the status their credits gave them.
+
+DROP FUNCTION IF EXISTS create_credit_note(UUID, JSONB, JSONB, INTEGER);
+
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    UPDATE invoices
+    SET paid_amount = LEAST(COALESCE(v_invoice.paid_amount, 0) + p_amount, v_invoice.total),
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION mark_overdue_invoices()
+RETURNS INTEGER AS $$
+DECLARE
+    v_marked INTEGER;
+BEGIN
+    UPDATE invoices SET status = 'overdue'
+    WHERE status IN ('issued', 'sent', 'partially_paid')
+      AND (due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE
+      AND paid_amount < total;
+    GET DIAGNOSTICS v_marked = ROW_COUNT;
+    RETURN v_marked;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public;
+
+CREATE OR REPLACE FUNCTION invoice_status(
+    p_status TEXT,
+    p_total DECIMAL,
+    p_paid DECIMAL,
+    p_due_date TIMESTAMP WITH TIME ZONE,
+    p_sent_at TIMESTAMP WITH TIME ZONE
+)
+RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN p_status IN ('draft', 'void', 'written_off') THEN p_status
+        WHEN COALESCE(p_paid, 0) >= p_total THEN 'paid'
+        WHEN (p_due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE THEN 'overdue'
+        WHEN COALESCE(p_paid, 0) > 0 THEN 'partially_paid'
+        WHEN p_sent_at IS NOT NULL THEN 'sent'
+        ELSE 'issued'
+    END;
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION check_invoice_status()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_allowed BOOLEAN;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.status := COALESCE(NEW.status, 'draft');
+        IF NEW.status NOT IN ('draft', 'issued', 'sent')
+            AND NEW.status IS DISTINCT FROM invoice_status('issued', NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) THEN
+            RAISE EXCEPTION 'a new invoice is draft, issued or sent, not %', NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF NEW.status <> 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    ELSIF NEW.status IS DISTINCT FROM OLD.status
+        AND NEW.status IS DISTINCT FROM invoice_status(OLD.status, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) THEN
+        IF NEW.status = 'void' AND COALESCE(NEW.paid_amount, 0) > 0 THEN
+            RAISE EXCEPTION 'the invoice has payments; write it off instead of voiding it' USING ERRCODE = 'PT422';
+        END IF;
+        SELECT EXISTS (
+            SELECT 1 FROM unnest(invoice_transitions(OLD.status)) AS t
+            WHERE t = NEW.status
+               OR invoice_status(t, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at) = NEW.status
+        ) INTO v_allowed;
+        IF NOT v_allowed THEN
+            RAISE EXCEPTION 'the invoice is % and cannot become %', OLD.status, NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF OLD.status = 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    END IF;
+
+    NEW.status := invoice_status(NEW.status, NEW.total, NEW.paid_amount, NEW.due_date, NEW.sent_at);
+    NEW.payment_status := CASE
+        WHEN NEW.status IN ('paid', 'overdue') THEN NEW.status
+        WHEN COALESCE(NEW.paid_amount, 0) > 0 THEN 'partially_paid'
+        ELSE 'unpaid'
+    END;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS invoice_status(TEXT, DECIMAL, DECIMAL, DECIMAL, TIMESTAMP WITH TIME ZONE, TIMESTAMP WITH TIME ZONE);
+
+COMMENT ON FUNCTION invoice_status IS 'Status of an invoice given its payments and due date';
+
+DROP TABLE IF EXISTS credit_note_items;
+DROP TABLE IF EXISTS credit_notes;
+DROP FUNCTION IF EXISTS assign_credit_note_number();
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS credited_amount;
@@ -0,0 +1,299 @@
+-- =====================================================
+-- CREDIT NOTES
+-- A credit note credits part or all of an issued invoice back to the
+-- customer: whole lines, a quantity of a line or an amount off it, with
+-- the tax on them. It is a document of its own, numbered from the
+-- credit_note series, and never changed once issued.
+--
+-- The credited amount of an invoice lowers its balance like its payments
+-- do: an invoice whose payments and credits cover its total is paid, and
+-- its revenue is its total less what was credited. An invoice with credit
+-- notes cannot be void.
+--
+-- create_credit_note issues a note priced by the application (see
+-- internal/pricing Credit) and raises
+--   PT404 - the invoice does not exist in the caller's organization
+--   PT412 - the invoice is no longer at p_version
+--   PT422 - the invoice is not open, or the note exceeds its balance
+-- =====================================================
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS credited_amount DECIMAL(20,4) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS credit_notes (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    org_id UUID NOT NULL DEFAULT current_org() REFERENCES organizations(id),
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    credit_note_number TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    subtotal DECIMAL(20,4) NOT NULL CHECK (subtotal >= 0),
+    tax DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (tax >= 0),
+    discount DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    total DECIMAL(20,4) NOT NULL CHECK (total > 0),
+    currency TEXT NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created_by TEXT,
+    UNIQUE (org_id, credit_note_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_notes_invoice_id ON credit_notes(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_credit_notes_org_id ON credit_notes(org_id, created_at);
+
+-- A line credited by amount has no quantity
+CREATE TABLE IF NOT EXISTS credit_note_items (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    credit_note_id UUID NOT NULL REFERENCES credit_notes(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position > 0),
+    invoice_item_id UUID NOT NULL REFERENCES invoice_items(id) ON DELETE CASCADE,
+    sku TEXT,
+    description TEXT NOT NULL,
+    quantity DECIMAL(20,4) CHECK (quantity > 0),
+    unit TEXT,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    total DECIMAL(20,4) NOT NULL CHECK (total > 0),
+    UNIQUE (credit_note_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_note_items_invoice_item_id ON credit_note_items(invoice_item_id);
+
+-- Number credit notes on insert when the caller did not
+CREATE OR REPLACE FUNCTION assign_credit_note_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.credit_note_number IS NULL OR NEW.credit_note_number = '' THEN
+        NEW.credit_note_number := next_document_number(NEW.org_id, 'credit_note', NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_assign_credit_note_number ON credit_notes;
+CREATE TRIGGER trigger_assign_credit_note_number
+BEFORE INSERT ON credit_notes
+FOR EACH ROW
+EXECUTE FUNCTION assign_credit_note_number();
+
+-- Audit
+DROP TRIGGER IF EXISTS audit_credit_notes ON credit_notes;
+CREATE TRIGGER audit_credit_notes
+AFTER INSERT OR UPDATE OR DELETE ON credit_notes
+FOR EACH ROW EXECUTE FUNCTION audit_row('credit_note', 'id');
+
+DROP TRIGGER IF EXISTS audit_credit_note_items ON credit_note_items;
+CREATE TRIGGER audit_credit_note_items
+AFTER INSERT OR UPDATE OR DELETE ON credit_note_items
+FOR EACH ROW EXECUTE FUNCTION audit_row('credit_note_item', 'credit_note_id');
+
+-- Row level security
+ALTER TABLE credit_notes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_note_items ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Organization credit notes" ON credit_notes;
+CREATE POLICY "Organization credit notes" ON credit_notes FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Organization credit note items" ON credit_note_items;
+CREATE POLICY "Organization credit note items" ON credit_note_items FOR ALL
+    USING (EXISTS (SELECT 1 FROM credit_notes n WHERE n.id = credit_note_id AND n.org_id = current_org()))
+    WITH CHECK (EXISTS (SELECT 1 FROM credit_notes n WHERE n.id = credit_note_id AND n.org_id = current_org()));
+
+-- invoice_status returns the status an invoice in p_status has today given
+-- its payments, credits and due date (lifecycle.Settle)
+CREATE OR REPLACE FUNCTION invoice_status(
+    p_status TEXT,
+    p_total DECIMAL,
+    p_paid DECIMAL,
+    p_credited DECIMAL,
+    p_due_date TIMESTAMP WITH TIME ZONE,
+    p_sent_at TIMESTAMP WITH TIME ZONE
+)
+RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN p_status IN ('draft', 'void', 'written_off') THEN p_status
+        WHEN COALESCE(p_paid, 0) + COALESCE(p_credited, 0) >= p_total THEN 'paid'
+        WHEN (p_due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE THEN 'overdue'
+        WHEN COALESCE(p_paid, 0) > 0 THEN 'partially_paid'
+        WHEN p_sent_at IS NOT NULL THEN 'sent'
+        ELSE 'issued'
+    END;
+$$ LANGUAGE sql STABLE;
+
+-- check_invoice_status settles the status from the payments and credits
+CREATE OR REPLACE FUNCTION check_invoice_status()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_allowed BOOLEAN;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.status := COALESCE(NEW.status, 'draft');
+        IF NEW.status NOT IN ('draft', 'issued', 'sent')
+            AND NEW.status IS DISTINCT FROM invoice_status('issued', NEW.total, NEW.paid_amount, NEW.credited_amount, NEW.due_date, NEW.sent_at) THEN
+            RAISE EXCEPTION 'a new invoice is draft, issued or sent, not %', NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF NEW.status <> 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    ELSIF NEW.status IS DISTINCT FROM OLD.status
+        AND NEW.status IS DISTINCT FROM invoice_status(OLD.status, NEW.total, NEW.paid_amount, NEW.credited_amount, NEW.due_date, NEW.sent_at) THEN
+        IF NEW.status = 'void' AND COALESCE(NEW.paid_amount, 0) > 0 THEN
+            RAISE EXCEPTION 'the invoice has payments; write it off instead of voiding it' USING ERRCODE = 'PT422';
+        END IF;
+        IF NEW.status = 'void' AND NEW.credited_amount > 0 THEN
+            RAISE EXCEPTION 'the invoice has credit notes; write it off instead of voiding it' USING ERRCODE = 'PT422';
+        END IF;
+        SELECT EXISTS (
+            SELECT 1 FROM unnest(invoice_transitions(OLD.status)) AS t
+            WHERE t = NEW.status
+               OR invoice_status(t, NEW.total, NEW.paid_amount, NEW.credited_amount, NEW.due_date, NEW.sent_at) = NEW.status
+        ) INTO v_allowed;
+        IF NOT v_allowed THEN
+            RAISE EXCEPTION 'the invoice is % and cannot become %', OLD.status, NEW.status USING ERRCODE = 'PT422';
+        END IF;
+        IF OLD.status = 'draft' THEN
+            NEW.issued_at := COALESCE(NEW.issued_at, NOW());
+        END IF;
+        IF NEW.status = 'sent' THEN
+            NEW.sent_at := COALESCE(NEW.sent_at, NOW());
+        END IF;
+    END IF;
+
+    NEW.status := invoice_status(NEW.status, NEW.total, NEW.paid_amount, NEW.credited_amount, NEW.due_date, NEW.sent_at);
+    NEW.payment_status := CASE
+        WHEN NEW.status IN ('paid', 'overdue') THEN NEW.status
+        WHEN COALESCE(NEW.paid_amount, 0) > 0 THEN 'partially_paid'
+        ELSE 'unpaid'
+    END;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS invoice_status(TEXT, DECIMAL, DECIMAL, TIMESTAMP WITH TIME ZONE, TIMESTAMP WITH TIME ZONE);
+
+-- mark_overdue_invoices leaves invoices their credits settled alone
+CREATE OR REPLACE FUNCTION mark_overdue_invoices()
+RETURNS INTEGER AS $$
+DECLARE
+    v_marked INTEGER;
+BEGIN
+    UPDATE invoices SET status = 'overdue'
+    WHERE status IN ('issued', 'sent', 'partially_paid')
+      AND (due_date AT TIME ZONE 'UTC')::DATE < (NOW() AT TIME ZONE 'UTC')::DATE
+      AND paid_amount + credited_amount < total;
+    GET DIAGNOSTICS v_marked = ROW_COUNT;
+    RETURN v_marked;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public;
+
+-- record_payment caps what is paid at what was not credited
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    UPDATE invoices
+    SET paid_amount = LEAST(COALESCE(v_invoice.paid_amount, 0) + p_amount, v_invoice.total - v_invoice.credited_amount),
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_credit_note issues a credit note on an open invoice of the
+-- caller's organization and lowers the invoice's balance by its total; the
+-- invoice_status trigger settles the status
+CREATE OR REPLACE FUNCTION create_credit_note(
+    p_invoice_id UUID,
+    p_credit_note JSONB,
+    p_items JSONB,
+    p_version INTEGER DEFAULT NULL
+)
+RETURNS credit_notes AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_note credit_notes%ROWTYPE;
+    v_total DECIMAL := (p_credit_note->>'total')::DECIMAL;
+    v_balance DECIMAL;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+    IF p_version IS NOT NULL AND p_version <> v_invoice.version THEN
+        RAISE EXCEPTION 'invoice % is at version %, not %', p_invoice_id, v_invoice.version, p_version USING ERRCODE = 'PT412';
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft; change it instead of crediting it' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and has no balance to credit', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    v_balance := v_invoice.total - COALESCE(v_invoice.paid_amount, 0) - v_invoice.credited_amount;
+    IF v_total > v_balance THEN
+        RAISE EXCEPTION 'the credit of % exceeds the invoice balance of %', v_total, v_balance USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO credit_notes (org_id, invoice_id, reason, subtotal, tax, discount, total, currency, created_by)
+    SELECT v_invoice.org_id, p_invoice_id, r.reason, r.subtotal, COALESCE(r.tax, 0), COALESCE(r.discount, 0), v_total,
+        v_invoice.currency, r.created_by
+    FROM jsonb_populate_record(NULL::credit_notes, p_credit_note) AS r
+    RETURNING * INTO v_note;
+
+    INSERT INTO credit_note_items (credit_note_id, position, invoice_item_id, sku, description, quantity, unit,
+        unit_price, tax_rate, total)
+    SELECT v_note.id, r.position, r.invoice_item_id, NULLIF(r.sku, ''), r.description, r.quantity, NULLIF(r.unit, ''),
+        r.unit_price, r.tax_rate, r.total
+    FROM jsonb_populate_recordset(NULL::credit_note_items, p_items) AS r;
+
+    UPDATE invoices SET credited_amount = credited_amount + v_total WHERE id = p_invoice_id;
+
+    RETURN v_note;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE credit_notes IS 'Credit notes against issued invoices; they lower the invoice balance';
+COMMENT ON TABLE credit_note_items IS 'Invoice lines credited by a credit note, by quantity or amount';
+COMMENT ON COLUMN invoices.credited_amount IS 'Total of the invoice''s credit notes';
+COMMENT ON FUNCTION invoice_status IS 'Status of an invoice given its payments, credits and due date';
+COMMENT ON FUNCTION create_credit_note IS 'Issues a credit note and lowers the invoice balance by its total';
@@ -0,0 +1,8 @@
+-- Refunds are forgotten: invoices keep the paid amount their refunds left.
+
+DROP FUNCTION IF EXISTS record_refund(UUID, DECIMAL, TEXT, TIMESTAMP, TEXT, TEXT, TEXT);
+DROP FUNCTION IF EXISTS invoice_paid_amount(invoices);
+
+DROP TABLE IF EXISTS refunds;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded_amount;
@@ -0,0 +1,109 @@
+-- =====================================================
+-- REFUNDS
+-- A refund returns part or all of a payment to the customer. It is linked
+-- to the payment it returns money of, which keeps the total refunded of it
+-- in refunded_amount; no payment is refunded beyond its amount.
+--
+-- What is paid on an invoice is recomputed from its payment rows when a
+-- payment is refunded: the payments less their refunds, up to what was not
+-- credited. A refunded paid invoice is owed again.
+--
+-- record_refund records a refund and raises
+--   PT404 - the payment does not exist in the caller's organization
+--   PT409 - the refund exceeds what is left of the payment
+-- =====================================================
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(20,4) NOT NULL DEFAULT 0
+    CHECK (refunded_amount >= 0 AND refunded_amount <= amount);
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    org_id UUID NOT NULL DEFAULT current_org() REFERENCES organizations(id),
+    payment_id UUID NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    refund_method TEXT NOT NULL,
+    refund_date TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    reference_number TEXT,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created_by TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_org_id ON refunds(org_id, refund_date);
+
+-- Audit, under the refunded payment's ID
+DROP TRIGGER IF EXISTS audit_refunds ON refunds;
+CREATE TRIGGER audit_refunds
+AFTER INSERT OR UPDATE OR DELETE ON refunds
+FOR EACH ROW EXECUTE FUNCTION audit_row('refund', 'payment_id');
+
+-- Row level security
+ALTER TABLE refunds ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Organization refunds" ON refunds;
+CREATE POLICY "Organization refunds" ON refunds FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+-- invoice_paid_amount returns what is paid on an invoice from its payment
+-- rows: the payments less their refunds, up to what was not credited
+CREATE OR REPLACE FUNCTION invoice_paid_amount(p_invoice invoices)
+RETURNS DECIMAL AS $$
+    SELECT LEAST(COALESCE(SUM(p.amount - p.refunded_amount), 0), p_invoice.total - p_invoice.credited_amount)
+    FROM payments p
+    WHERE p.invoice_id = p_invoice.id;
+$$ LANGUAGE sql STABLE;
+
+-- record_refund returns part or all of a payment of the caller's
+-- organization and recomputes the paid amount of its invoice; the
+-- invoice_status trigger settles the status
+CREATE OR REPLACE FUNCTION record_refund(
+    p_payment_id UUID,
+    p_amount DECIMAL,
+    p_refund_method TEXT DEFAULT NULL,
+    p_refund_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_reason TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL
+)
+RETURNS refunds AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_refund refunds%ROWTYPE;
+BEGIN
+    -- Lock the invoice like record_payment does, so payments and refunds on
+    -- it are recorded one at a time
+    SELECT i.* INTO v_invoice FROM invoices i
+    WHERE i.id = (SELECT invoice_id FROM payments WHERE id = p_payment_id AND org_id = current_org())
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id;
+
+    IF p_amount > v_payment.amount - v_payment.refunded_amount THEN
+        RAISE EXCEPTION '% of the payment of % is left to refund', v_payment.amount - v_payment.refunded_amount, v_payment.amount
+            USING ERRCODE = 'PT409';
+    END IF;
+
+    INSERT INTO refunds (org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number, reason, created_by)
+    VALUES (v_payment.org_id, p_payment_id, v_invoice.id, p_amount, COALESCE(p_refund_method, v_payment.payment_method),
+        COALESCE(p_refund_date, NOW()), p_reference_number, p_reason, p_created_by)
+    RETURNING * INTO v_refund;
+
+    UPDATE payments SET refunded_amount = refunded_amount + p_amount WHERE id = p_payment_id;
+
+    SELECT * INTO v_invoice FROM invoices WHERE id = v_invoice.id;
+    UPDATE invoices SET paid_amount = invoice_paid_amount(v_invoice) WHERE id = v_invoice.id;
+
+    RETURN v_refund;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON TABLE refunds IS 'Money returned to customers out of their payments';
+COMMENT ON COLUMN payments.refunded_amount IS 'Total of the payment''s refunds';
+COMMENT ON FUNCTION invoice_paid_amount IS 'What is paid on an invoice: its payments less their refunds';
+COMMENT ON FUNCTION record_refund IS 'Refunds a payment and recomputes the paid amount of its invoice';
@@ -0,0 +1,118 @@
+-- Payments can no longer be voided or corrected. Void payments are kept
+-- but count again once the invoices are next paid or refunded.
+
+DROP FUNCTION IF EXISTS void_payment(UUID, TEXT, INTEGER);
+DROP FUNCTION IF EXISTS correct_payment(UUID, JSONB, INTEGER);
+
+-- record_payment and record_refund as of 0017_refunds
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    UPDATE invoices
+    SET paid_amount = LEAST(COALESCE(v_invoice.paid_amount, 0) + p_amount, v_invoice.total - v_invoice.credited_amount),
+        payment_date = v_payment.payment_date
+    WHERE id = p_invoice_id;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION invoice_paid_amount(p_invoice invoices)
+RETURNS DECIMAL AS $$
+    SELECT LEAST(COALESCE(SUM(p.amount - p.refunded_amount), 0), p_invoice.total - p_invoice.credited_amount)
+    FROM payments p
+    WHERE p.invoice_id = p_invoice.id;
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION record_refund(
+    p_payment_id UUID,
+    p_amount DECIMAL,
+    p_refund_method TEXT DEFAULT NULL,
+    p_refund_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_reason TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL
+)
+RETURNS refunds AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_refund refunds%ROWTYPE;
+BEGIN
+    -- Lock the invoice like record_payment does, so payments and refunds on
+    -- it are recorded one at a time
+    SELECT i.* INTO v_invoice FROM invoices i
+    WHERE i.id = (SELECT invoice_id FROM payments WHERE id = p_payment_id AND org_id = current_org())
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id;
+
+    IF p_amount > v_payment.amount - v_payment.refunded_amount THEN
+        RAISE EXCEPTION '% of the payment of % is left to refund', v_payment.amount - v_payment.refunded_amount, v_payment.amount
+            USING ERRCODE = 'PT409';
+    END IF;
+
+    INSERT INTO refunds (org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number, reason, created_by)
+    VALUES (v_payment.org_id, p_payment_id, v_invoice.id, p_amount, COALESCE(p_refund_method, v_payment.payment_method),
+        COALESCE(p_refund_date, NOW()), p_reference_number, p_reason, p_created_by)
+    RETURNING * INTO v_refund;
+
+    UPDATE payments SET refunded_amount = refunded_amount + p_amount WHERE id = p_payment_id;
+
+    SELECT * INTO v_invoice FROM invoices WHERE id = v_invoice.id;
+    UPDATE invoices SET paid_amount = invoice_paid_amount(v_invoice) WHERE id = v_invoice.id;
+
+    RETURN v_refund;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS lock_payment(UUID);
+DROP FUNCTION IF EXISTS settle_invoice_payments(UUID);
+
+DROP TRIGGER IF EXISTS bump_payments_version ON payments;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS version;
+ALTER TABLE payments DROP COLUMN IF EXISTS correction_reason;
+ALTER TABLE payments DROP COLUMN IF EXISTS void_reason;
+ALTER TABLE payments DROP COLUMN IF EXISTS voided_at;
@@ -0,0 +1,230 @@
+-- =====================================================
+-- PAYMENT CORRECTIONS
+-- A payment recorded by mistake is voided, with the reason, rather than
+-- deleted: it keeps its receipt number and history but no longer counts.
+-- A payment recorded wrong is corrected in place, with the reason; the
+-- audit log keeps what it was. Payments carry a version for optimistic
+-- concurrency like customers and invoices do.
+--
+-- What is paid on an invoice is no longer accumulated payment by payment
+-- but recomputed from its payment rows on every payment, refund,
+-- correction and void: the payments that are not void less their refunds,
+-- up to what was not credited.
+--
+-- void_payment and correct_payment raise
+--   PT404 - the payment does not exist in the caller's organization
+--   PT409 - the corrected amount is below what was refunded of the payment
+--   PT410 - the payment is void
+--   PT412 - the payment is no longer at p_version
+-- and record_refund raises PT410 for a void payment.
+-- =====================================================
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS void_reason TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS correction_reason TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+DROP TRIGGER IF EXISTS bump_payments_version ON payments;
+CREATE TRIGGER bump_payments_version
+BEFORE UPDATE ON payments
+FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+-- settle_invoice_payments recomputes what is paid on an invoice from its
+-- payment rows, and when it was last paid; the invoice_status trigger
+-- settles the status
+CREATE OR REPLACE FUNCTION settle_invoice_payments(p_invoice_id UUID)
+RETURNS VOID AS $$
+    UPDATE invoices i
+    SET paid_amount = LEAST(COALESCE(p.paid, 0), i.total - i.credited_amount),
+        payment_date = p.last_paid
+    FROM (
+        SELECT SUM(amount - refunded_amount) AS paid, MAX(payment_date) AS last_paid
+        FROM payments
+        WHERE invoice_id = p_invoice_id AND voided_at IS NULL
+    ) p
+    WHERE i.id = p_invoice_id;
+$$ LANGUAGE sql;
+
+DROP FUNCTION IF EXISTS invoice_paid_amount(invoices);
+
+-- record_payment settles the invoice from its payment rows
+CREATE OR REPLACE FUNCTION record_payment(
+    p_invoice_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_invoice.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id <> p_invoice_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    INSERT INTO payments (org_id, invoice_id, amount, payment_method, payment_date, reference_number, notes, created_by, idempotency_key)
+    VALUES (v_invoice.org_id, p_invoice_id, p_amount, p_payment_method, COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    PERFORM settle_invoice_payments(p_invoice_id);
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- lock_payment locks the invoice of a payment of the caller's organization,
+-- so payments, refunds and corrections on an invoice are made one at a time,
+-- and returns the payment read under the lock
+CREATE OR REPLACE FUNCTION lock_payment(p_payment_id UUID)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+BEGIN
+    PERFORM 1 FROM invoices
+    WHERE id = (SELECT invoice_id FROM payments WHERE id = p_payment_id AND org_id = current_org())
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id;
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- record_refund refuses void payments and settles the invoice from its
+-- payment rows
+CREATE OR REPLACE FUNCTION record_refund(
+    p_payment_id UUID,
+    p_amount DECIMAL,
+    p_refund_method TEXT DEFAULT NULL,
+    p_refund_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_reason TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL
+)
+RETURNS refunds AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+    v_refund refunds%ROWTYPE;
+BEGIN
+    v_payment := lock_payment(p_payment_id);
+    IF v_payment.voided_at IS NOT NULL THEN
+        RAISE EXCEPTION 'payment % is void', p_payment_id USING ERRCODE = 'PT410';
+    END IF;
+
+    IF p_amount > v_payment.amount - v_payment.refunded_amount THEN
+        RAISE EXCEPTION '% of the payment of % is left to refund', v_payment.amount - v_payment.refunded_amount, v_payment.amount
+            USING ERRCODE = 'PT409';
+    END IF;
+
+    INSERT INTO refunds (org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number, reason, created_by)
+    VALUES (v_payment.org_id, p_payment_id, v_payment.invoice_id, p_amount, COALESCE(p_refund_method, v_payment.payment_method),
+        COALESCE(p_refund_date, NOW()), p_reference_number, p_reason, p_created_by)
+    RETURNING * INTO v_refund;
+
+    UPDATE payments SET refunded_amount = refunded_amount + p_amount WHERE id = p_payment_id;
+    PERFORM settle_invoice_payments(v_payment.invoice_id);
+
+    RETURN v_refund;
+END;
+$$ LANGUAGE plpgsql;
+
+-- correct_payment replaces the amount, method, date, reference and notes of
+-- a payment at p_version, with the reason, and settles its invoice. A null
+-- payment_date keeps the payment's date.
+CREATE OR REPLACE FUNCTION correct_payment(
+    p_payment_id UUID,
+    p_payment JSONB,
+    p_version INTEGER DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+    v_amount DECIMAL := (p_payment->>'amount')::DECIMAL;
+BEGIN
+    v_payment := lock_payment(p_payment_id);
+    IF p_version IS NOT NULL AND p_version <> v_payment.version THEN
+        RAISE EXCEPTION 'payment % is at version %, not %', p_payment_id, v_payment.version, p_version USING ERRCODE = 'PT412';
+    END IF;
+    IF v_payment.voided_at IS NOT NULL THEN
+        RAISE EXCEPTION 'payment % is void', p_payment_id USING ERRCODE = 'PT410';
+    END IF;
+    IF v_amount < v_payment.refunded_amount THEN
+        RAISE EXCEPTION '% of the payment was refunded; correct it to at least that', v_payment.refunded_amount
+            USING ERRCODE = 'PT409';
+    END IF;
+
+    UPDATE payments
+    SET amount = v_amount,
+        payment_method = p_payment->>'payment_method',
+        payment_date = COALESCE((p_payment->>'payment_date')::TIMESTAMP WITH TIME ZONE, payment_date),
+        reference_number = p_payment->>'reference_number',
+        notes = p_payment->>'notes',
+        correction_reason = p_payment->>'correction_reason'
+    WHERE id = p_payment_id
+    RETURNING * INTO v_payment;
+
+    PERFORM settle_invoice_payments(v_payment.invoice_id);
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- void_payment voids a payment at p_version, with the reason, and settles
+-- its invoice. What was refunded of the payment no longer counts either.
+CREATE OR REPLACE FUNCTION void_payment(
+    p_payment_id UUID,
+    p_reason TEXT,
+    p_version INTEGER DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+BEGIN
+    v_payment := lock_payment(p_payment_id);
+    IF p_version IS NOT NULL AND p_version <> v_payment.version THEN
+        RAISE EXCEPTION 'payment % is at version %, not %', p_payment_id, v_payment.version, p_version USING ERRCODE = 'PT412';
+    END IF;
+    IF v_payment.voided_at IS NOT NULL THEN
+        RAISE EXCEPTION 'payment % is void', p_payment_id USING ERRCODE = 'PT410';
+    END IF;
+
+    UPDATE payments SET voided_at = NOW(), void_reason = p_reason
+    WHERE id = p_payment_id
+    RETURNING * INTO v_payment;
+
+    PERFORM settle_invoice_payments(v_payment.invoice_id);
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON COLUMN payments.voided_at IS 'When the payment was voided; a void payment does not count towards its invoice';
+COMMENT ON COLUMN payments.correction_reason IS 'Why the payment was last corrected';
+COMMENT ON FUNCTION settle_invoice_payments IS 'Recomputes what is paid on an invoice from its payments that are not void';
+COMMENT ON FUNCTION correct_payment IS 'Corrects a payment and recomputes the paid amount of its invoice';
+COMMENT ON FUNCTION void_payment IS 'Voids a payment and recomputes the paid amount of its invoice';
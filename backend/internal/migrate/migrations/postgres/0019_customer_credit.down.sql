@@ -0,0 +1,67 @@
+-- Receipts and their refunds are forgotten with the customer credit; credit
+-- applied to invoices stays as their payments, of method 'other', each
+-- numbered as a receipt.
+
+DROP TRIGGER IF EXISTS trigger_apply_credit_on_issue ON invoices;
+DROP FUNCTION IF EXISTS apply_credit_on_issue();
+DROP FUNCTION IF EXISTS void_credit_application(UUID, TEXT, INTEGER);
+DROP FUNCTION IF EXISTS apply_customer_credit(UUID, DECIMAL, TEXT);
+DROP FUNCTION IF EXISTS record_receipt(UUID, DECIMAL, TEXT, TEXT, TIMESTAMP, TEXT, TEXT, TEXT, TEXT);
+
+DROP TRIGGER IF EXISTS trigger_credit_application_credit ON credit_applications;
+DROP FUNCTION IF EXISTS credit_application_credit();
+DROP TRIGGER IF EXISTS trigger_payment_credit ON payments;
+DROP FUNCTION IF EXISTS payment_credit();
+DROP FUNCTION IF EXISTS settle_customer_credit(UUID, TEXT);
+DROP FUNCTION IF EXISTS customer_credit(UUID, TEXT);
+DROP TABLE IF EXISTS customer_credits;
+
+-- settle_invoice_payments as of 0018_payment_corrections
+CREATE OR REPLACE FUNCTION settle_invoice_payments(p_invoice_id UUID)
+RETURNS VOID AS $$
+    UPDATE invoices i
+    SET paid_amount = LEAST(COALESCE(p.paid, 0), i.total - i.credited_amount),
+        payment_date = p.last_paid
+    FROM (
+        SELECT SUM(amount - refunded_amount) AS paid, MAX(payment_date) AS last_paid
+        FROM payments
+        WHERE invoice_id = p_invoice_id AND voided_at IS NULL
+    ) p
+    WHERE i.id = p_invoice_id;
+$$ LANGUAGE sql;
+
+INSERT INTO payments (id, org_id, invoice_id, customer_id, currency, amount, payment_method, payment_date, created_at,
+    created_by, voided_at, void_reason)
+SELECT id, org_id, invoice_id, customer_id, currency, amount, 'other', applied_at, created_at, created_by, voided_at,
+    void_reason
+FROM credit_applications;
+
+DROP TABLE IF EXISTS credit_applications;
+
+DELETE FROM refunds WHERE invoice_id IS NULL;
+DELETE FROM payments WHERE invoice_id IS NULL;
+ALTER TABLE refunds ALTER COLUMN invoice_id SET NOT NULL;
+
+-- lock_payment as of 0018_payment_corrections
+CREATE OR REPLACE FUNCTION lock_payment(p_payment_id UUID)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+BEGIN
+    PERFORM 1 FROM invoices
+    WHERE id = (SELECT invoice_id FROM payments WHERE id = p_payment_id AND org_id = current_org())
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id;
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_payment_customer ON payments;
+DROP FUNCTION IF EXISTS payment_customer();
+
+DROP INDEX IF EXISTS idx_payments_customer_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS customer_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,402 @@
+-- =====================================================
+-- CUSTOMER CREDIT
+-- What is paid beyond the balance of an invoice is no longer discarded but
+-- kept as its customer's credit, and so is all of a receipt: a payment
+-- recorded against a customer, in a currency, without an invoice. Credit is
+-- applied to the customer's open invoices in the same currency, by hand, or
+-- by itself when an invoice is created issued or issued from a draft.
+--
+-- Applied credit is not a payment: nothing is received when it is applied,
+-- so it takes no receipt number and is not listed among the payments. Each
+-- application is a row of credit_applications that settles its invoice like
+-- a payment does and lowers its customer's credit. An application made by
+-- mistake is voided, with the reason, which gives the credit back.
+--
+-- Payments carry who paid and in what currency. customer_credits keeps each
+-- customer's credit per currency, recomputed on every payment, refund,
+-- correction, application and void: the overpayments of their invoices and
+-- their receipts, less what was applied of it. What is paid on an invoice is
+-- its payments that are not void, less their refunds, and its credit
+-- applications that are not void, up to what was not credited.
+--
+-- record_receipt, apply_customer_credit, and any change of a payment that
+-- would take back credit that was already applied, raise
+--   PT402 - there is not enough credit
+-- apply_customer_credit raises
+--   PT404 - the invoice does not exist in the caller's organization
+--   PT409 - more is applied than is owed on the invoice
+--   PT422 - the invoice does not accept payments
+-- and void_credit_application raises
+--   PT404 - the credit application does not exist in the caller's
+--           organization
+--   PT409 - the credit application is void
+--   PT412 - the credit application is no longer at p_version
+-- =====================================================
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS customer_id UUID REFERENCES customers(id) ON DELETE CASCADE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+
+UPDATE payments p
+SET customer_id = i.customer_id, currency = COALESCE(i.currency, 'USD')
+FROM invoices i
+WHERE i.id = p.invoice_id;
+
+CREATE INDEX IF NOT EXISTS idx_payments_customer_id ON payments(customer_id, currency);
+
+-- A payment on an invoice is the invoice's customer's, in its currency
+CREATE OR REPLACE FUNCTION payment_customer()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.invoice_id IS NOT NULL THEN
+        SELECT customer_id, COALESCE(currency, 'USD') INTO NEW.customer_id, NEW.currency
+        FROM invoices WHERE id = NEW.invoice_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_payment_customer ON payments;
+CREATE TRIGGER trigger_payment_customer
+BEFORE INSERT ON payments
+FOR EACH ROW EXECUTE FUNCTION payment_customer();
+
+-- A refund of a receipt has no invoice
+ALTER TABLE refunds ALTER COLUMN invoice_id DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS customer_credits (
+    org_id UUID NOT NULL DEFAULT current_org() REFERENCES organizations(id),
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    currency VARCHAR(3) NOT NULL,
+    balance DECIMAL(20,4) NOT NULL CHECK (balance > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (customer_id, currency)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_credits_org_id ON customer_credits(org_id);
+
+CREATE TABLE IF NOT EXISTS credit_applications (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    org_id UUID NOT NULL DEFAULT current_org() REFERENCES organizations(id),
+    invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created_by TEXT,
+    voided_at TIMESTAMP WITH TIME ZONE,
+    void_reason TEXT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_applications_invoice_id ON credit_applications(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_credit_applications_customer_id ON credit_applications(customer_id, currency);
+CREATE INDEX IF NOT EXISTS idx_credit_applications_org_id ON credit_applications(org_id, applied_at);
+
+DROP TRIGGER IF EXISTS bump_credit_applications_version ON credit_applications;
+CREATE TRIGGER bump_credit_applications_version
+BEFORE UPDATE ON credit_applications
+FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+-- Audit, under the invoice's ID
+DROP TRIGGER IF EXISTS audit_credit_applications ON credit_applications;
+CREATE TRIGGER audit_credit_applications
+AFTER INSERT OR UPDATE OR DELETE ON credit_applications
+FOR EACH ROW EXECUTE FUNCTION audit_row('credit_application', 'invoice_id');
+
+-- Row level security
+ALTER TABLE customer_credits ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_applications ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "Organization customer credits" ON customer_credits;
+CREATE POLICY "Organization customer credits" ON customer_credits FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+DROP POLICY IF EXISTS "Organization credit applications" ON credit_applications;
+CREATE POLICY "Organization credit applications" ON credit_applications FOR ALL
+    USING (org_id = current_org()) WITH CHECK (org_id = current_org());
+
+-- customer_credit computes a customer's credit in a currency: what was
+-- received on their invoices beyond what is owed, from payments that are not
+-- void, less their refunds, and credit applications that are not void, and
+-- their receipts, less what was applied. It is negative when more was
+-- applied than there is.
+CREATE OR REPLACE FUNCTION customer_credit(p_customer_id UUID, p_currency TEXT)
+RETURNS DECIMAL AS $$
+    SELECT
+        COALESCE((SELECT SUM(received - (total - credited_amount)) FROM (
+            SELECT i.total, i.credited_amount, SUM(r.amount) AS received
+            FROM (
+                SELECT invoice_id, amount - refunded_amount AS amount FROM payments
+                WHERE customer_id = p_customer_id AND currency = p_currency AND voided_at IS NULL
+                UNION ALL
+                SELECT invoice_id, amount FROM credit_applications
+                WHERE customer_id = p_customer_id AND currency = p_currency AND voided_at IS NULL
+            ) r JOIN invoices i ON i.id = r.invoice_id
+            GROUP BY i.id, i.total, i.credited_amount
+        ) paid WHERE received > total - credited_amount), 0)
+        + COALESCE((SELECT SUM(amount - refunded_amount) FROM payments
+            WHERE customer_id = p_customer_id AND currency = p_currency AND invoice_id IS NULL AND voided_at IS NULL), 0)
+        - COALESCE((SELECT SUM(amount) FROM credit_applications
+            WHERE customer_id = p_customer_id AND currency = p_currency AND voided_at IS NULL), 0);
+$$ LANGUAGE sql STABLE;
+
+-- settle_invoice_payments counts the invoice's credit applications as paid
+CREATE OR REPLACE FUNCTION settle_invoice_payments(p_invoice_id UUID)
+RETURNS VOID AS $$
+    UPDATE invoices i
+    SET paid_amount = LEAST(COALESCE(p.paid, 0), i.total - i.credited_amount),
+        payment_date = p.last_paid
+    FROM (
+        SELECT SUM(amount) AS paid, MAX(paid_at) AS last_paid FROM (
+            SELECT amount - refunded_amount AS amount, payment_date AS paid_at FROM payments
+            WHERE invoice_id = p_invoice_id AND voided_at IS NULL
+            UNION ALL
+            SELECT amount, applied_at FROM credit_applications
+            WHERE invoice_id = p_invoice_id AND voided_at IS NULL
+        ) r
+    ) p
+    WHERE i.id = p_invoice_id;
+$$ LANGUAGE sql;
+
+-- What was overpaid so far
+INSERT INTO customer_credits (org_id, customer_id, currency, balance)
+SELECT c.org_id, c.id, p.currency, customer_credit(c.id, p.currency)
+FROM customers c JOIN (SELECT DISTINCT customer_id, currency FROM payments) p ON p.customer_id = c.id
+WHERE customer_credit(c.id, p.currency) > 0
+ON CONFLICT (customer_id, currency) DO NOTHING;
+
+-- settle_customer_credit recomputes a customer's credit in a currency under
+-- the customer's lock and stores it
+CREATE OR REPLACE FUNCTION settle_customer_credit(p_customer_id UUID, p_currency TEXT)
+RETURNS VOID AS $$
+DECLARE
+    v_org_id UUID;
+    v_balance DECIMAL;
+BEGIN
+    SELECT org_id INTO v_org_id FROM customers WHERE id = p_customer_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RETURN;
+    END IF;
+
+    v_balance := customer_credit(p_customer_id, p_currency);
+    IF v_balance < 0 THEN
+        RAISE EXCEPTION '% % of the credit was already applied; void what was applied from it first', -v_balance, p_currency
+            USING ERRCODE = 'PT402';
+    ELSIF v_balance = 0 THEN
+        DELETE FROM customer_credits WHERE customer_id = p_customer_id AND currency = p_currency;
+    ELSE
+        INSERT INTO customer_credits (org_id, customer_id, currency, balance, updated_at)
+        VALUES (v_org_id, p_customer_id, p_currency, v_balance, NOW())
+        ON CONFLICT (customer_id, currency) DO UPDATE SET balance = excluded.balance, updated_at = NOW();
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Every payment, refund, correction and void settles the customer's credit,
+-- and so does every credit application and its void
+CREATE OR REPLACE FUNCTION payment_credit()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.customer_id IS NOT NULL THEN
+        PERFORM settle_customer_credit(NEW.customer_id, NEW.currency);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_payment_credit ON payments;
+CREATE TRIGGER trigger_payment_credit
+AFTER INSERT OR UPDATE ON payments
+FOR EACH ROW EXECUTE FUNCTION payment_credit();
+
+CREATE OR REPLACE FUNCTION credit_application_credit()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM settle_customer_credit(NEW.customer_id, NEW.currency);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_credit_application_credit ON credit_applications;
+CREATE TRIGGER trigger_credit_application_credit
+AFTER INSERT OR UPDATE ON credit_applications
+FOR EACH ROW EXECUTE FUNCTION credit_application_credit();
+
+-- lock_payment locks the customer of a receipt, which has no invoice
+CREATE OR REPLACE FUNCTION lock_payment(p_payment_id UUID)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id AND org_id = current_org();
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+    IF v_payment.invoice_id IS NOT NULL THEN
+        PERFORM 1 FROM invoices WHERE id = v_payment.invoice_id FOR UPDATE;
+    ELSE
+        PERFORM 1 FROM customers WHERE id = v_payment.customer_id FOR UPDATE;
+    END IF;
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id;
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- record_receipt records a payment of a customer of the caller's
+-- organization without an invoice, all of which goes to their credit
+CREATE OR REPLACE FUNCTION record_receipt(
+    p_customer_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_currency TEXT DEFAULT 'USD',
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_customer customers%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_customer FROM customers WHERE id = p_customer_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_customer.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id IS NOT NULL OR v_payment.customer_id <> p_customer_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (org_id, customer_id, currency, amount, payment_method, payment_date, reference_number, notes,
+        created_by, idempotency_key)
+    VALUES (v_customer.org_id, p_customer_id, COALESCE(p_currency, 'USD'), p_amount, p_payment_method,
+        COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- apply_customer_credit pays an invoice of the caller's organization from its
+-- customer's credit: p_amount of it, or as much as there is credit and is
+-- owed, with a credit application
+CREATE OR REPLACE FUNCTION apply_customer_credit(
+    p_invoice_id UUID,
+    p_amount DECIMAL DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL
+)
+RETURNS credit_applications AS $$
+DECLARE
+    v_invoice invoices%ROWTYPE;
+    v_application credit_applications%ROWTYPE;
+    v_credit DECIMAL;
+    v_owed DECIMAL;
+    v_amount DECIMAL := p_amount;
+BEGIN
+    SELECT * INTO v_invoice FROM invoices WHERE id = p_invoice_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'invoice % not found', p_invoice_id USING ERRCODE = 'PT404';
+    END IF;
+    IF v_invoice.status = 'draft' THEN
+        RAISE EXCEPTION 'the invoice is a draft and does not accept payments; issue it first' USING ERRCODE = 'PT422';
+    ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+        RAISE EXCEPTION 'the invoice is % and does not accept payments', v_invoice.status USING ERRCODE = 'PT422';
+    END IF;
+
+    v_credit := customer_credit(v_invoice.customer_id, COALESCE(v_invoice.currency, 'USD'));
+    v_owed := v_invoice.total - v_invoice.paid_amount - v_invoice.credited_amount;
+    IF v_amount IS NULL THEN
+        v_amount := LEAST(v_credit, v_owed);
+    ELSIF v_amount > v_owed THEN
+        RAISE EXCEPTION '% is owed on the invoice', v_owed USING ERRCODE = 'PT409';
+    END IF;
+    IF v_amount <= 0 OR v_amount > v_credit THEN
+        RAISE EXCEPTION 'the customer has % % of credit', GREATEST(v_credit, 0), COALESCE(v_invoice.currency, 'USD')
+            USING ERRCODE = 'PT402';
+    END IF;
+
+    INSERT INTO credit_applications (org_id, invoice_id, customer_id, currency, amount, created_by)
+    VALUES (v_invoice.org_id, p_invoice_id, v_invoice.customer_id, COALESCE(v_invoice.currency, 'USD'), v_amount,
+        p_created_by)
+    RETURNING * INTO v_application;
+
+    PERFORM settle_invoice_payments(p_invoice_id);
+
+    RETURN v_application;
+END;
+$$ LANGUAGE plpgsql;
+
+-- void_credit_application voids a credit application of the caller's
+-- organization under its invoice's lock, giving the credit back
+CREATE OR REPLACE FUNCTION void_credit_application(
+    p_id UUID,
+    p_reason TEXT,
+    p_version INTEGER DEFAULT NULL
+)
+RETURNS credit_applications AS $$
+DECLARE
+    v_application credit_applications%ROWTYPE;
+BEGIN
+    PERFORM 1 FROM invoices
+    WHERE id = (SELECT invoice_id FROM credit_applications WHERE id = p_id AND org_id = current_org())
+    FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'credit application % not found', p_id USING ERRCODE = 'PT404';
+    END IF;
+    SELECT * INTO v_application FROM credit_applications WHERE id = p_id;
+    IF p_version IS NOT NULL AND p_version <> v_application.version THEN
+        RAISE EXCEPTION 'credit application % is at version %, not %', p_id, v_application.version, p_version
+            USING ERRCODE = 'PT412';
+    END IF;
+    IF v_application.voided_at IS NOT NULL THEN
+        RAISE EXCEPTION 'credit application % is void', p_id USING ERRCODE = 'PT409';
+    END IF;
+
+    UPDATE credit_applications SET voided_at = NOW(), void_reason = p_reason
+    WHERE id = p_id
+    RETURNING * INTO v_application;
+
+    PERFORM settle_invoice_payments(v_application.invoice_id);
+
+    RETURN v_application;
+END;
+$$ LANGUAGE plpgsql;
+
+-- An invoice created issued or sent, or issued from a draft, is paid from
+-- its customer's credit as far as it goes
+CREATE OR REPLACE FUNCTION apply_credit_on_issue()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.status IN ('issued', 'sent') AND (TG_OP = 'INSERT' OR OLD.status = 'draft')
+        AND NEW.total - NEW.paid_amount - NEW.credited_amount > 0
+        AND customer_credit(NEW.customer_id, COALESCE(NEW.currency, 'USD')) > 0 THEN
+        PERFORM apply_customer_credit(NEW.id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_apply_credit_on_issue ON invoices;
+CREATE TRIGGER trigger_apply_credit_on_issue
+AFTER INSERT OR UPDATE OF status ON invoices
+FOR EACH ROW EXECUTE FUNCTION apply_credit_on_issue();
+
+COMMENT ON TABLE customer_credits IS 'Customers'' credit per currency: overpayments and receipts less what was applied';
+COMMENT ON TABLE credit_applications IS 'Customer credit applied to invoices; not payments, so without a receipt number';
+COMMENT ON COLUMN payments.customer_id IS 'Who paid: the invoice''s customer, or the customer of a receipt without an invoice';
+COMMENT ON COLUMN credit_applications.voided_at IS 'When the application was voided; a void application gives the credit back';
+COMMENT ON FUNCTION customer_credit IS 'Computes a customer''s credit in a currency from their payments and credit applications';
+COMMENT ON FUNCTION record_receipt IS 'Records a payment without an invoice as its customer''s credit';
+COMMENT ON FUNCTION apply_customer_credit IS 'Pays an invoice from its customer''s credit with a credit application';
+COMMENT ON FUNCTION void_credit_application IS 'Voids a credit application, giving the credit back to its customer';
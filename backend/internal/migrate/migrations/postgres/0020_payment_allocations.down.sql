@@ -0,0 +1,60 @@
+-- Allocations stay as credit applications of their invoices, no longer tied
+-- to the receipts they were allocated from.
+
+DROP FUNCTION IF EXISTS record_receipt(UUID, DECIMAL, TEXT, TEXT, TIMESTAMP, TEXT, TEXT, TEXT, TEXT, JSONB, BOOLEAN);
+DROP FUNCTION IF EXISTS allocate_payment(UUID, JSONB, TEXT);
+DROP FUNCTION IF EXISTS allocate_payment_rows(payments, JSONB, TEXT);
+DROP FUNCTION IF EXISTS lock_allocation_invoices(UUID, TEXT, JSONB);
+
+DROP TRIGGER IF EXISTS trigger_payment_allocations_kept ON payments;
+DROP FUNCTION IF EXISTS payment_allocations_kept();
+DROP FUNCTION IF EXISTS payment_allocated(UUID);
+
+DROP INDEX IF EXISTS idx_credit_applications_payment_id;
+ALTER TABLE credit_applications DROP COLUMN IF EXISTS payment_id;
+
+-- record_receipt as of 0019_customer_credit
+CREATE OR REPLACE FUNCTION record_receipt(
+    p_customer_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_currency TEXT DEFAULT 'USD',
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_customer customers%ROWTYPE;
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_customer FROM customers WHERE id = p_customer_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_customer.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id IS NOT NULL OR v_payment.customer_id <> p_customer_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (org_id, customer_id, currency, amount, payment_method, payment_date, reference_number, notes,
+        created_by, idempotency_key)
+    VALUES (v_customer.org_id, p_customer_id, COALESCE(p_currency, 'USD'), p_amount, p_payment_method,
+        COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION record_receipt IS 'Records a payment without an invoice as its customer''s credit';
@@ -0,0 +1,244 @@
+-- =====================================================
+-- PAYMENT ALLOCATIONS
+-- One payment can settle several invoices of its customer: the payment is
+-- recorded once, as a receipt without an invoice, and allocated across
+-- the customer's open invoices in its currency, either split explicitly
+-- or oldest due first. Only the receipt is a payment, numbered and listed
+-- once; each allocation of it is a credit application of its invoice that
+-- points back to it with payment_id and is dated like it, so the invoice is
+-- settled like any other credit application and what is not allocated stays
+-- the customer's credit. An allocation made by mistake is voided like any
+-- credit application, with void_credit_application.
+--
+-- What is allocated of a payment can not be voided, refunded or corrected
+-- away: void the allocations first. allocate_payment and record_receipt
+-- raise
+--   PT404 - the payment, or an invoice of its customer in its currency,
+--           does not exist in the caller's organization
+--   PT410 - the payment is void
+--   PT413 - more is allocated than is left of the payment or owed on an
+--           invoice
+--   PT422 - an invoice does not accept payments
+--   PT423 - the payment was recorded on an invoice
+-- and void_payment, correct_payment and record_refund raise PT423 for a
+-- payment whose allocations they would take back.
+-- =====================================================
+
+ALTER TABLE credit_applications ADD COLUMN IF NOT EXISTS payment_id UUID REFERENCES payments(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_credit_applications_payment_id ON credit_applications(payment_id);
+
+-- payment_allocated is what is allocated of a payment: its credit
+-- applications that are not void
+CREATE OR REPLACE FUNCTION payment_allocated(p_payment_id UUID)
+RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(amount), 0) FROM credit_applications
+    WHERE payment_id = p_payment_id AND voided_at IS NULL;
+$$ LANGUAGE sql STABLE;
+
+-- A payment keeps at least what is allocated of it
+CREATE OR REPLACE FUNCTION payment_allocations_kept()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_allocated DECIMAL;
+BEGIN
+    IF NEW.invoice_id IS NULL THEN
+        v_allocated := payment_allocated(NEW.id);
+        IF v_allocated > 0 AND (NEW.voided_at IS NOT NULL OR NEW.amount - NEW.refunded_amount < v_allocated) THEN
+            RAISE EXCEPTION '% of payment % is allocated to invoices; void its allocations first', v_allocated, NEW.id
+                USING ERRCODE = 'PT423';
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_payment_allocations_kept ON payments;
+CREATE TRIGGER trigger_payment_allocations_kept
+BEFORE UPDATE ON payments
+FOR EACH ROW EXECUTE FUNCTION payment_allocations_kept();
+
+-- lock_allocation_invoices locks the invoices a payment of a customer in a
+-- currency would be allocated to: those in p_allocations, or else all of
+-- the customer's with a balance in the currency. They are locked before the
+-- customer, as a payment on an invoice locks them.
+CREATE OR REPLACE FUNCTION lock_allocation_invoices(p_customer_id UUID, p_currency TEXT, p_allocations JSONB)
+RETURNS VOID AS $$
+BEGIN
+    IF jsonb_array_length(COALESCE(p_allocations, '[]')) > 0 THEN
+        PERFORM 1 FROM invoices
+        WHERE id IN (SELECT (a->>'invoice_id')::UUID FROM jsonb_array_elements(p_allocations) a)
+            AND org_id = current_org()
+        ORDER BY id
+        FOR UPDATE;
+    ELSE
+        PERFORM 1 FROM invoices
+        WHERE customer_id = p_customer_id AND COALESCE(currency, 'USD') = p_currency AND org_id = current_org()
+            AND total - paid_amount - credited_amount > 0
+        ORDER BY id
+        FOR UPDATE;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- allocate_payment_rows allocates what is left of a locked receipt to the
+-- invoices in p_allocations, [{"invoice_id", "amount"}], or with none to the
+-- customer's open invoices in its currency, oldest due first, each as a
+-- credit application of the receipt
+CREATE OR REPLACE FUNCTION allocate_payment_rows(p_payment payments, p_allocations JSONB, p_created_by TEXT)
+RETURNS VOID AS $$
+DECLARE
+    v_left DECIMAL;
+    v_allocation JSONB;
+    v_invoice invoices%ROWTYPE;
+    v_owed DECIMAL;
+    v_amount DECIMAL;
+BEGIN
+    IF p_payment.invoice_id IS NOT NULL THEN
+        RAISE EXCEPTION 'payment % was recorded on invoice %', p_payment.id, p_payment.invoice_id USING ERRCODE = 'PT423';
+    END IF;
+    IF p_payment.voided_at IS NOT NULL THEN
+        RAISE EXCEPTION 'payment % is void', p_payment.id USING ERRCODE = 'PT410';
+    END IF;
+    v_left := p_payment.amount - p_payment.refunded_amount - payment_allocated(p_payment.id);
+
+    IF jsonb_array_length(COALESCE(p_allocations, '[]')) > 0 THEN
+        FOR v_allocation IN SELECT * FROM jsonb_array_elements(p_allocations) LOOP
+            SELECT * INTO v_invoice FROM invoices
+            WHERE id = (v_allocation->>'invoice_id')::UUID AND org_id = p_payment.org_id
+                AND customer_id = p_payment.customer_id AND COALESCE(currency, 'USD') = p_payment.currency;
+            IF NOT FOUND THEN
+                RAISE EXCEPTION 'invoice % of the customer in % not found', v_allocation->>'invoice_id', p_payment.currency
+                    USING ERRCODE = 'PT404';
+            END IF;
+            IF v_invoice.status = 'draft' THEN
+                RAISE EXCEPTION 'invoice % is a draft and does not accept payments; issue it first', v_invoice.invoice_number
+                    USING ERRCODE = 'PT422';
+            ELSIF v_invoice.status NOT IN ('issued', 'sent', 'partially_paid', 'overdue') THEN
+                RAISE EXCEPTION 'invoice % is % and does not accept payments', v_invoice.invoice_number, v_invoice.status
+                    USING ERRCODE = 'PT422';
+            END IF;
+
+            v_amount := (v_allocation->>'amount')::DECIMAL;
+            v_owed := v_invoice.total - v_invoice.paid_amount - v_invoice.credited_amount;
+            IF v_amount > v_owed THEN
+                RAISE EXCEPTION '% is owed on invoice %', v_owed, v_invoice.invoice_number USING ERRCODE = 'PT413';
+            ELSIF v_amount > v_left THEN
+                RAISE EXCEPTION '% of the payment is left to allocate', GREATEST(v_left, 0) USING ERRCODE = 'PT413';
+            END IF;
+
+            INSERT INTO credit_applications (org_id, invoice_id, payment_id, customer_id, currency, amount, applied_at,
+                created_by)
+            VALUES (p_payment.org_id, v_invoice.id, p_payment.id, p_payment.customer_id, p_payment.currency, v_amount,
+                p_payment.payment_date, p_created_by);
+            PERFORM settle_invoice_payments(v_invoice.id);
+            v_left := v_left - v_amount;
+        END LOOP;
+    ELSE
+        FOR v_invoice IN
+            SELECT * FROM invoices
+            WHERE org_id = p_payment.org_id AND customer_id = p_payment.customer_id
+                AND COALESCE(currency, 'USD') = p_payment.currency
+                AND status IN ('issued', 'sent', 'partially_paid', 'overdue')
+                AND total - paid_amount - credited_amount > 0
+            ORDER BY due_date IS NULL, due_date, created_at
+        LOOP
+            EXIT WHEN v_left <= 0;
+            v_amount := LEAST(v_left, v_invoice.total - v_invoice.paid_amount - v_invoice.credited_amount);
+
+            INSERT INTO credit_applications (org_id, invoice_id, payment_id, customer_id, currency, amount, applied_at,
+                created_by)
+            VALUES (p_payment.org_id, v_invoice.id, p_payment.id, p_payment.customer_id, p_payment.currency, v_amount,
+                p_payment.payment_date, p_created_by);
+            PERFORM settle_invoice_payments(v_invoice.id);
+            v_left := v_left - v_amount;
+        END LOOP;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- allocate_payment allocates what is left of a receipt of the caller's
+-- organization, see allocate_payment_rows
+CREATE OR REPLACE FUNCTION allocate_payment(
+    p_payment_id UUID,
+    p_allocations JSONB DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL
+)
+RETURNS payments AS $$
+DECLARE
+    v_payment payments%ROWTYPE;
+BEGIN
+    SELECT * INTO v_payment FROM payments WHERE id = p_payment_id AND org_id = current_org();
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'payment % not found', p_payment_id USING ERRCODE = 'PT404';
+    END IF;
+
+    PERFORM lock_allocation_invoices(v_payment.customer_id, v_payment.currency, p_allocations);
+    v_payment := lock_payment(p_payment_id);
+    PERFORM allocate_payment_rows(v_payment, p_allocations, p_created_by);
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+-- record_receipt allocates the receipt it records when given allocations, or
+-- asked to allocate it oldest due first
+DROP FUNCTION IF EXISTS record_receipt(UUID, DECIMAL, TEXT, TEXT, TIMESTAMP, TEXT, TEXT, TEXT, TEXT);
+CREATE OR REPLACE FUNCTION record_receipt(
+    p_customer_id UUID,
+    p_amount DECIMAL,
+    p_payment_method TEXT,
+    p_currency TEXT DEFAULT 'USD',
+    p_payment_date TIMESTAMP DEFAULT NULL,
+    p_reference_number TEXT DEFAULT NULL,
+    p_notes TEXT DEFAULT NULL,
+    p_created_by TEXT DEFAULT NULL,
+    p_idempotency_key TEXT DEFAULT NULL,
+    p_allocations JSONB DEFAULT NULL,
+    p_auto_allocate BOOLEAN DEFAULT FALSE
+)
+RETURNS payments AS $$
+DECLARE
+    v_customer customers%ROWTYPE;
+    v_payment payments%ROWTYPE;
+    v_allocate BOOLEAN := p_auto_allocate OR jsonb_array_length(COALESCE(p_allocations, '[]')) > 0;
+BEGIN
+    IF v_allocate THEN
+        PERFORM lock_allocation_invoices(p_customer_id, COALESCE(p_currency, 'USD'), p_allocations);
+    END IF;
+
+    SELECT * INTO v_customer FROM customers WHERE id = p_customer_id AND org_id = current_org() FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'customer % not found', p_customer_id USING ERRCODE = 'PT404';
+    END IF;
+
+    -- A retry of a request that already succeeded returns the original payment
+    IF p_idempotency_key IS NOT NULL THEN
+        SELECT * INTO v_payment FROM payments WHERE org_id = v_customer.org_id AND idempotency_key = p_idempotency_key;
+        IF FOUND THEN
+            IF v_payment.invoice_id IS NOT NULL OR v_payment.customer_id <> p_customer_id OR v_payment.amount <> p_amount THEN
+                RAISE EXCEPTION 'idempotency key % was already used for a different payment', p_idempotency_key
+                    USING ERRCODE = 'PT409';
+            END IF;
+            RETURN v_payment;
+        END IF;
+    END IF;
+
+    INSERT INTO payments (org_id, customer_id, currency, amount, payment_method, payment_date, reference_number, notes,
+        created_by, idempotency_key)
+    VALUES (v_customer.org_id, p_customer_id, COALESCE(p_currency, 'USD'), p_amount, p_payment_method,
+        COALESCE(p_payment_date, NOW()), p_reference_number, p_notes, p_created_by, p_idempotency_key)
+    RETURNING * INTO v_payment;
+
+    IF v_allocate THEN
+        PERFORM allocate_payment_rows(v_payment, p_allocations, p_created_by);
+    END IF;
+
+    RETURN v_payment;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON COLUMN credit_applications.payment_id IS 'The receipt an allocation was allocated from';
+COMMENT ON FUNCTION payment_allocated IS 'What is allocated of a payment to invoices';
+COMMENT ON FUNCTION allocate_payment IS 'Allocates a payment without an invoice across its customer''s open invoices';
+COMMENT ON FUNCTION record_receipt IS 'Records a payment without an invoice as its customer''s credit, allocated to invoices if asked';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS currency_rates;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS company_info;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS customers;
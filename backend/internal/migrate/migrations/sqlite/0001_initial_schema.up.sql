@@ -0,0 +1,112 @@
+-- =====================================================
+-- INITIAL SCHEMA (embedded SQLite)
+-- Mirrors postgres/0001_initial_schema.up.sql with SQLite types. IDs are
+-- generated by the application instead of gen_random_uuid().
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS customers (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT DEFAULT 'Indonesia',
+    company_name TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    invoice_number TEXT UNIQUE,
+    subtotal DECIMAL(10,2) NOT NULL,
+    tax DECIMAL(10,2) DEFAULT 0,
+    discount DECIMAL(10,2) DEFAULT 0,
+    total DECIMAL(10,2) NOT NULL,
+    items TEXT NOT NULL,
+    pdf_url TEXT,
+    status TEXT DEFAULT 'pending',
+    notes TEXT,
+    currency TEXT DEFAULT 'USD',
+    payment_status TEXT DEFAULT 'unpaid'
+        CHECK (payment_status IN ('unpaid', 'partially_paid', 'paid', 'overdue')),
+    paid_amount DECIMAL(20,2) DEFAULT 0,
+    payment_date TIMESTAMP,
+    last_reminder_sent TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    due_date TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS company_info (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT,
+    website TEXT,
+    tax_id TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id TEXT PRIMARY KEY,
+    invoice_id TEXT REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,2) NOT NULL,
+    payment_method TEXT NOT NULL,
+    payment_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reference_number TEXT,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT
+);
+
+CREATE TABLE IF NOT EXISTS currency_rates (
+    id TEXT PRIMARY KEY,
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate DECIMAL(20,6) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (from_currency, to_currency)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customers_email ON customers(email);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_customer_id ON invoices(customer_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_created_at ON invoices(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
+CREATE INDEX IF NOT EXISTS idx_invoices_payment_status ON invoices(payment_status);
+CREATE INDEX IF NOT EXISTS idx_invoices_currency ON invoices(currency);
+CREATE INDEX IF NOT EXISTS idx_invoices_due_date ON invoices(due_date);
+CREATE INDEX IF NOT EXISTS idx_payments_invoice_id ON payments(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_payments_payment_date ON payments(payment_date);
+
+-- Default exchange rates (base currency: USD)
+INSERT OR IGNORE INTO currency_rates (id, from_currency, to_currency, rate) VALUES
+    ('usd-usd', 'USD', 'USD', 1.000000),
+    ('usd-idr', 'USD', 'IDR', 15800.000000),
+    ('usd-eur', 'USD', 'EUR', 0.920000),
+    ('usd-gbp', 'USD', 'GBP', 0.790000),
+    ('usd-jpy', 'USD', 'JPY', 148.500000),
+    ('usd-cny', 'USD', 'CNY', 7.240000),
+    ('usd-sgd', 'USD', 'SGD', 1.340000),
+    ('usd-myr', 'USD', 'MYR', 4.720000),
+    ('idr-usd', 'IDR', 'USD', 0.000063),
+    ('idr-idr', 'IDR', 'IDR', 1.000000),
+    ('idr-eur', 'IDR', 'EUR', 0.000058),
+    ('idr-gbp', 'IDR', 'GBP', 0.000050),
+    ('eur-usd', 'EUR', 'USD', 1.087000),
+    ('eur-idr', 'EUR', 'IDR', 17174.000000),
+    ('eur-eur', 'EUR', 'EUR', 1.000000),
+    ('eur-gbp', 'EUR', 'GBP', 0.859000),
+    ('gbp-usd', 'GBP', 'USD', 1.266000),
+    ('gbp-idr', 'GBP', 'IDR', 20003.000000),
+    ('gbp-eur', 'GBP', 'EUR', 1.164000),
+    ('gbp-gbp', 'GBP', 'GBP', 1.000000);
+
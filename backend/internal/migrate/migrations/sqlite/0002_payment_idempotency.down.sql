@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+ALTER TABLE payments DROP COLUMN idempotency_key;
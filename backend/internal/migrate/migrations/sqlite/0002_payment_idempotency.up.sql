@@ -0,0 +1,4 @@
+-- Client-supplied Idempotency-Key; retries with the same key return the
+-- original payment instead of recording a second one
+ALTER TABLE payments ADD COLUMN idempotency_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency_key ON payments(idempotency_key);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_payments_receipt_number;
+ALTER TABLE payments DROP COLUMN receipt_number;
+
+DROP TABLE IF EXISTS document_numbers;
+DROP TABLE IF EXISTS number_series_counters;
+DROP TABLE IF EXISTS number_series;
@@ -0,0 +1,38 @@
+-- Document numbering series, see postgres/0003_numbering_series.up.sql.
+-- Numbers are allocated by the application inside the document's transaction.
+
+CREATE TABLE IF NOT EXISTS number_series (
+    series_key TEXT PRIMARY KEY,
+    prefix TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL,
+    reset TEXT NOT NULL DEFAULT 'yearly' CHECK (reset IN ('never', 'yearly', 'monthly')),
+    padding INTEGER NOT NULL DEFAULT 4 CHECK (padding BETWEEN 1 AND 12),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS number_series_counters (
+    series_key TEXT NOT NULL REFERENCES number_series(series_key),
+    period TEXT NOT NULL,
+    last_value INTEGER NOT NULL,
+    PRIMARY KEY (series_key, period)
+);
+
+CREATE TABLE IF NOT EXISTS document_numbers (
+    series_key TEXT NOT NULL REFERENCES number_series(series_key),
+    period TEXT NOT NULL,
+    sequence INTEGER NOT NULL,
+    number TEXT NOT NULL,
+    document_id TEXT,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (series_key, period, sequence),
+    UNIQUE (series_key, number)
+);
+
+ALTER TABLE payments ADD COLUMN receipt_number TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_receipt_number ON payments(receipt_number);
+
+-- Default numbering series
+INSERT OR IGNORE INTO number_series (series_key, prefix, pattern, reset, padding) VALUES
+    ('invoice', 'INV', '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4),
+    ('credit_note', 'CN', '{PREFIX}-{YEAR}-{NUMBER}', 'yearly', 4),
+    ('receipt', 'RCP', '{PREFIX}-{YEAR}{MONTH}-{NUMBER}', 'monthly', 4);
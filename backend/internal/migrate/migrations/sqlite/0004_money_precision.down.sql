@@ -0,0 +1,4 @@
+-- SQLite stores DECIMAL columns with NUMERIC affinity whatever their declared
+-- precision, so there is nothing to widen. Kept so the migration versions
+-- match the Postgres ones.
+SELECT 1;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS customers_search_update;
+DROP TRIGGER IF EXISTS invoices_search_delete;
+DROP TRIGGER IF EXISTS invoices_search_update;
+DROP TRIGGER IF EXISTS invoices_search_insert;
+DROP TABLE IF EXISTS invoice_search;
@@ -0,0 +1,61 @@
+-- Invoice full-text search, see postgres/0005_invoice_search.up.sql. SQLite
+-- keeps the searchable text in an FTS5 table; the unicode61 tokenizer folds
+-- case and strips diacritics.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS invoice_search USING fts5(
+    invoice_id UNINDEXED,
+    invoice_number,
+    customer,
+    items,
+    notes,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(NEW.items)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_update
+AFTER UPDATE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(NEW.items)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_delete
+AFTER DELETE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS customers_search_update
+AFTER UPDATE OF name, company_name, email ON customers
+BEGIN
+    UPDATE invoice_search
+    SET customer = NEW.name || ' ' || COALESCE(NEW.company_name, '') || ' ' || COALESCE(NEW.email, '')
+    WHERE invoice_id IN (SELECT id FROM invoices WHERE customer_id = NEW.id);
+END;
+
+-- Index the existing invoices
+INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+SELECT i.id,
+    COALESCE(i.invoice_number, ''),
+    COALESCE(c.name || ' ' || COALESCE(c.company_name, '') || ' ' || COALESCE(c.email, ''), ''),
+    COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(i.items)), ''),
+    COALESCE(i.notes, '')
+FROM invoices i
+LEFT JOIN customers c ON c.id = i.customer_id;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS customers_restrict_delete;
+DROP INDEX IF EXISTS idx_customers_archived_at;
+ALTER TABLE customers DROP COLUMN archived_at;
@@ -0,0 +1,14 @@
+-- Mirrors postgres/0006_customer_archiving.up.sql. SQLite cannot change the
+-- ON DELETE action of an existing foreign key, so a trigger refuses to delete
+-- a customer that still has invoices instead; a forced delete removes the
+-- invoices first.
+ALTER TABLE customers ADD COLUMN archived_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_customers_archived_at ON customers(archived_at);
+
+CREATE TRIGGER IF NOT EXISTS customers_restrict_delete
+BEFORE DELETE ON customers
+WHEN EXISTS (SELECT 1 FROM invoices WHERE customer_id = OLD.id)
+BEGIN
+    SELECT RAISE(ABORT, 'customer has invoices');
+END;
@@ -0,0 +1,16 @@
+DROP TRIGGER IF EXISTS audit_number_series_delete;
+DROP TRIGGER IF EXISTS audit_number_series_update;
+DROP TRIGGER IF EXISTS audit_number_series_insert;
+DROP TRIGGER IF EXISTS audit_payments_delete;
+DROP TRIGGER IF EXISTS audit_payments_update;
+DROP TRIGGER IF EXISTS audit_payments_insert;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_customers_delete;
+DROP TRIGGER IF EXISTS audit_customers_update;
+DROP TRIGGER IF EXISTS audit_customers_insert;
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+DROP TRIGGER IF EXISTS audit_log_no_update;
+DROP TABLE IF EXISTS audit_actor;
+DROP TABLE IF EXISTS audit_log;
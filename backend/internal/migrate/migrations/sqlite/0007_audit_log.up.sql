@@ -0,0 +1,240 @@
+-- Audit log, see postgres/0007_audit_log.up.sql.
+--
+-- SQLite has no session settings, so the application writes the actor of a
+-- transaction to the single-row audit_actor table at the start of it (SQLite
+-- runs one write transaction at a time) and clears it before committing.
+-- The triggers list the audited columns explicitly and must be recreated
+-- when those tables gain columns.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    actor TEXT NOT NULL,
+    changes TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity_type, entity_id, id);
+
+CREATE TABLE IF NOT EXISTS audit_actor (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    actor TEXT NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete
+BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+-- customers
+CREATE TRIGGER IF NOT EXISTS audit_customers_insert
+AFTER INSERT ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city,
+            'postal_code', NEW.postal_code, 'country', NEW.country,
+            'company_name', NEW.company_name, 'archived_at', NEW.archived_at)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_update
+AFTER UPDATE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city,
+            'postal_code', OLD.postal_code, 'country', OLD.country,
+            'company_name', OLD.company_name, 'archived_at', OLD.archived_at)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city,
+            'postal_code', NEW.postal_code, 'country', NEW.country,
+            'company_name', NEW.company_name, 'archived_at', NEW.archived_at)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_delete
+AFTER DELETE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city,
+            'postal_code', OLD.postal_code, 'country', OLD.country,
+            'company_name', OLD.company_name, 'archived_at', OLD.archived_at)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- invoices (items is JSON text, kept as JSON in the diff)
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'items', json(NEW.items), 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'items', json(OLD.items), 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'items', json(NEW.items), 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'items', json(OLD.items), 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- payments
+CREATE TRIGGER IF NOT EXISTS audit_payments_insert
+AFTER INSERT ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number,
+            'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date,
+            'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_update
+AFTER UPDATE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number,
+            'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date,
+            'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number,
+            'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date,
+            'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_delete
+AFTER DELETE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number,
+            'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date,
+            'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- number_series
+CREATE TRIGGER IF NOT EXISTS audit_number_series_insert
+AFTER INSERT ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_number_series_update
+AFTER UPDATE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    JOIN json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_number_series_delete
+AFTER DELETE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', OLD.series_key, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,2 @@
+ALTER TABLE invoices DROP COLUMN version;
+ALTER TABLE customers DROP COLUMN version;
@@ -0,0 +1,5 @@
+-- Row versions, see postgres/0008_row_versions.up.sql. SQLite triggers
+-- cannot change the row being written, so the application increments the
+-- version in its UPDATE statements.
+ALTER TABLE customers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE invoices ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
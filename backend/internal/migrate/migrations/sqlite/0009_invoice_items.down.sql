@@ -0,0 +1,119 @@
+DROP VIEW IF EXISTS item_sales;
+
+DROP TRIGGER IF EXISTS audit_invoice_items_delete;
+DROP TRIGGER IF EXISTS audit_invoice_items_update;
+DROP TRIGGER IF EXISTS audit_invoice_items_insert;
+DROP TRIGGER IF EXISTS invoice_items_search_delete;
+DROP TRIGGER IF EXISTS invoice_items_search_update;
+DROP TRIGGER IF EXISTS invoice_items_search_insert;
+DROP TRIGGER IF EXISTS invoices_search_update;
+DROP TRIGGER IF EXISTS invoices_search_insert;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+
+ALTER TABLE invoices ADD COLUMN items TEXT NOT NULL DEFAULT '[]';
+
+UPDATE invoices
+SET items = COALESCE((
+    SELECT json_group_array(json_object(
+            'description', description, 'quantity', quantity,
+            'unit_price', unit_price, 'total', total))
+    FROM (SELECT * FROM invoice_items WHERE invoice_id = invoices.id ORDER BY position)), '[]');
+
+DROP TABLE IF EXISTS invoice_items;
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(NEW.items)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_update
+AFTER UPDATE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(NEW.items)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'items', json(NEW.items), 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'items', json(OLD.items), 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'items', json(NEW.items), 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'items', json(OLD.items), 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DELETE FROM invoice_search;
+INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+SELECT i.id,
+    COALESCE(i.invoice_number, ''),
+    COALESCE(c.name || ' ' || COALESCE(c.company_name, '') || ' ' || COALESCE(c.email, ''), ''),
+    COALESCE((SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(i.items)), ''),
+    COALESCE(i.notes, '')
+FROM invoices i
+LEFT JOIN customers c ON c.id = i.customer_id;
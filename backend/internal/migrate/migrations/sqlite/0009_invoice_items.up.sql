@@ -0,0 +1,237 @@
+-- Invoice line items, see postgres/0009_invoice_items.up.sql.
+--
+-- The triggers that read invoices.items (search, audit) are dropped before
+-- the column and recreated to read the lines from invoice_items.
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    id TEXT PRIMARY KEY,
+    invoice_id TEXT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position > 0),
+    sku TEXT,
+    description TEXT NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit TEXT,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    discount DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    total DECIMAL(20,4) NOT NULL,
+    UNIQUE (invoice_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice_id ON invoice_items(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_invoice_items_sku ON invoice_items(sku) WHERE sku IS NOT NULL;
+
+-- Move the JSON items, with random version 4 UUIDs as ids
+INSERT INTO invoice_items (id, invoice_id, position, description, quantity, unit_price, total)
+SELECT lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+        substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+        substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6))),
+    i.id,
+    e.key + 1,
+    COALESCE(json_extract(e.value, '$.description'), ''),
+    MAX(CAST(COALESCE(json_extract(e.value, '$.quantity'), 1) AS INTEGER), 1),
+    MAX(COALESCE(json_extract(e.value, '$.unit_price'), 0), 0),
+    COALESCE(json_extract(e.value, '$.total'),
+        ROUND(COALESCE(json_extract(e.value, '$.quantity'), 1) * COALESCE(json_extract(e.value, '$.unit_price'), 0),
+            CASE
+                WHEN UPPER(i.currency) IN ('IDR', 'JPY', 'KRW', 'VND', 'CLP') THEN 0
+                WHEN UPPER(i.currency) IN ('BHD', 'JOD', 'KWD', 'OMR', 'TND') THEN 3
+                ELSE 2
+            END))
+FROM invoices i, json_each(CASE WHEN json_valid(i.items) AND json_type(i.items) = 'array' THEN i.items ELSE '[]' END) AS e
+WHERE NOT EXISTS (SELECT 1 FROM invoice_items x WHERE x.invoice_id = i.id);
+
+DROP TRIGGER IF EXISTS invoices_search_insert;
+DROP TRIGGER IF EXISTS invoices_search_update;
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+
+ALTER TABLE invoices DROP COLUMN items;
+
+-- Search
+CREATE TRIGGER IF NOT EXISTS invoices_search_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_search_update
+AFTER UPDATE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoice_items_search_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    UPDATE invoice_search SET items = COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.invoice_id ORDER BY position)), '')
+    WHERE invoice_id = NEW.invoice_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoice_items_search_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    UPDATE invoice_search SET items = COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.invoice_id ORDER BY position)), '')
+    WHERE invoice_id = NEW.invoice_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoice_items_search_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    UPDATE invoice_search SET items = COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = OLD.invoice_id ORDER BY position)), '')
+    WHERE invoice_id = OLD.invoice_id;
+END;
+
+-- Audit: invoices without items, and the lines under the invoice they
+-- belong to
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Re-index the existing invoices
+DELETE FROM invoice_search;
+INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+SELECT i.id,
+    COALESCE(i.invoice_number, ''),
+    COALESCE(c.name || ' ' || COALESCE(c.company_name, '') || ' ' || COALESCE(c.email, ''), ''),
+    COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = i.id ORDER BY position)), ''),
+    COALESCE(i.notes, '')
+FROM invoices i
+LEFT JOIN customers c ON c.id = i.customer_id;
+
+-- Item sales, see postgres/0009_invoice_items.up.sql
+CREATE VIEW IF NOT EXISTS item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), i.currency;
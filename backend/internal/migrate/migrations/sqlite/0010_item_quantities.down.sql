@@ -0,0 +1,17 @@
+DROP VIEW IF EXISTS item_sales;
+
+-- Fractional quantities are rounded, keeping at least one
+UPDATE invoice_items SET quantity = MAX(CAST(ROUND(quantity) AS INTEGER), 1)
+WHERE quantity <> CAST(quantity AS INTEGER);
+
+CREATE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), i.currency;
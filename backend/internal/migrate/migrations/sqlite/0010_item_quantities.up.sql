@@ -0,0 +1,20 @@
+-- Fractional quantities, see postgres/0010_item_quantities.up.sql.
+--
+-- SQLite keeps a fractional value in an INTEGER column as a REAL, just as
+-- the NUMERIC affinity of a DECIMAL column would, so the column stays as it
+-- is and only item_sales changes.
+
+DROP VIEW IF EXISTS item_sales;
+
+CREATE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), it.unit, i.currency;
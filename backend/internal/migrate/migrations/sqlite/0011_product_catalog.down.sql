@@ -0,0 +1,64 @@
+DROP TRIGGER IF EXISTS audit_invoice_items_insert;
+DROP TRIGGER IF EXISTS audit_invoice_items_update;
+DROP TRIGGER IF EXISTS audit_invoice_items_delete;
+DROP TRIGGER IF EXISTS products_unlink;
+DROP TRIGGER IF EXISTS audit_product_prices_insert;
+DROP TRIGGER IF EXISTS audit_product_prices_update;
+DROP TRIGGER IF EXISTS audit_product_prices_delete;
+DROP TRIGGER IF EXISTS audit_products_insert;
+DROP TRIGGER IF EXISTS audit_products_update;
+DROP TRIGGER IF EXISTS audit_products_delete;
+
+DROP INDEX IF EXISTS idx_invoice_items_product_id;
+ALTER TABLE invoice_items DROP COLUMN product_id;
+
+DROP TABLE IF EXISTS product_prices;
+DROP TABLE IF EXISTS products;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate, 'discount', NEW.discount,
+            'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate, 'discount', OLD.discount,
+            'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate, 'discount', NEW.discount,
+            'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate, 'discount', OLD.discount,
+            'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,167 @@
+-- Product catalog, see postgres/0011_product_catalog.up.sql.
+--
+-- invoice_items.product_id has no foreign key because SQLite cannot drop a
+-- column that has one; products_unlink clears it when a product is deleted,
+-- as ON DELETE SET NULL does in Postgres. Products are saved by the
+-- application, which raises their version.
+
+CREATE TABLE IF NOT EXISTS products (
+    id TEXT PRIMARY KEY,
+    sku TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    description TEXT,
+    unit TEXT,
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    active BOOLEAN NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS product_prices (
+    product_id TEXT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    currency TEXT NOT NULL,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    PRIMARY KEY (product_id, currency)
+);
+
+ALTER TABLE invoice_items ADD COLUMN product_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_products_active ON products(active);
+CREATE INDEX IF NOT EXISTS idx_invoice_items_product_id ON invoice_items(product_id) WHERE product_id IS NOT NULL;
+
+CREATE TRIGGER IF NOT EXISTS products_unlink
+AFTER DELETE ON products
+BEGIN
+    UPDATE invoice_items SET product_id = NULL WHERE product_id = OLD.id;
+END;
+
+-- Audit: products, their prices under the product, and the lines with the
+-- product they were taken from
+CREATE TRIGGER IF NOT EXISTS audit_products_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_products_update
+AFTER UPDATE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    JOIN json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_products_delete
+AFTER DELETE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_product_prices_insert
+AFTER INSERT ON product_prices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product_price', NEW.product_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'product_id', NEW.product_id, 'currency', NEW.currency, 'unit_price', NEW.unit_price)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_product_prices_update
+AFTER UPDATE ON product_prices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product_price', NEW.product_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'product_id', OLD.product_id, 'currency', OLD.currency, 'unit_price', OLD.unit_price)) AS o
+    JOIN json_each(json_object(
+            'product_id', NEW.product_id, 'currency', NEW.currency, 'unit_price', NEW.unit_price)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_product_prices_delete
+AFTER DELETE ON product_prices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product_price', OLD.product_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'product_id', OLD.product_id, 'currency', OLD.currency, 'unit_price', OLD.unit_price)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DROP TRIGGER IF EXISTS audit_invoice_items_insert;
+DROP TRIGGER IF EXISTS audit_invoice_items_update;
+DROP TRIGGER IF EXISTS audit_invoice_items_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
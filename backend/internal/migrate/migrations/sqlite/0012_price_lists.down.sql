@@ -0,0 +1,117 @@
+DROP TRIGGER IF EXISTS audit_invoice_items_insert;
+DROP TRIGGER IF EXISTS audit_invoice_items_update;
+DROP TRIGGER IF EXISTS audit_invoice_items_delete;
+DROP TRIGGER IF EXISTS audit_customers_insert;
+DROP TRIGGER IF EXISTS audit_customers_update;
+DROP TRIGGER IF EXISTS audit_customers_delete;
+DROP TRIGGER IF EXISTS price_lists_unlink;
+DROP TRIGGER IF EXISTS audit_price_list_rules_insert;
+DROP TRIGGER IF EXISTS audit_price_list_rules_update;
+DROP TRIGGER IF EXISTS audit_price_list_rules_delete;
+DROP TRIGGER IF EXISTS audit_price_lists_insert;
+DROP TRIGGER IF EXISTS audit_price_lists_update;
+DROP TRIGGER IF EXISTS audit_price_lists_delete;
+
+DROP INDEX IF EXISTS idx_customers_price_list_id;
+DROP INDEX IF EXISTS idx_price_list_rules_product_id;
+ALTER TABLE invoice_items DROP COLUMN price_rule;
+ALTER TABLE customers DROP COLUMN price_list_id;
+
+DROP TABLE IF EXISTS price_list_rules;
+DROP TABLE IF EXISTS price_lists;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_insert
+AFTER INSERT ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city,
+            'postal_code', NEW.postal_code, 'country', NEW.country,
+            'company_name', NEW.company_name, 'archived_at', NEW.archived_at)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_update
+AFTER UPDATE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city,
+            'postal_code', OLD.postal_code, 'country', OLD.country,
+            'company_name', OLD.company_name, 'archived_at', OLD.archived_at)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city,
+            'postal_code', NEW.postal_code, 'country', NEW.country,
+            'company_name', NEW.company_name, 'archived_at', NEW.archived_at)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_delete
+AFTER DELETE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city,
+            'postal_code', OLD.postal_code, 'country', OLD.country,
+            'company_name', OLD.company_name, 'archived_at', OLD.archived_at)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate,
+            'discount', NEW.discount, 'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate,
+            'discount', OLD.discount, 'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,220 @@
+-- Price lists, see postgres/0012_price_lists.up.sql.
+--
+-- customers.price_list_id has no foreign key because SQLite cannot drop a
+-- column that has one; price_lists_unlink clears it when a price list is
+-- deleted, as ON DELETE SET NULL does in Postgres. Price lists are saved by
+-- the application, which raises their version.
+
+CREATE TABLE IF NOT EXISTS price_lists (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    currency TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS price_list_rules (
+    price_list_id TEXT NOT NULL REFERENCES price_lists(id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    min_quantity DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (min_quantity >= 0),
+    unit_price DECIMAL(20,4) CHECK (unit_price >= 0),
+    discount_percent DECIMAL(7,4) CHECK (discount_percent BETWEEN 0 AND 100),
+    PRIMARY KEY (price_list_id, product_id, min_quantity),
+    CHECK ((unit_price IS NULL) <> (discount_percent IS NULL))
+);
+
+ALTER TABLE customers ADD COLUMN price_list_id TEXT;
+ALTER TABLE invoice_items ADD COLUMN price_rule TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_price_list_rules_product_id ON price_list_rules(product_id);
+CREATE INDEX IF NOT EXISTS idx_customers_price_list_id ON customers(price_list_id) WHERE price_list_id IS NOT NULL;
+
+CREATE TRIGGER IF NOT EXISTS price_lists_unlink
+AFTER DELETE ON price_lists
+BEGIN
+    UPDATE customers SET price_list_id = NULL WHERE price_list_id = OLD.id;
+END;
+
+-- Audit: price lists, their rules under the price list, and the new columns
+-- of customers and invoice lines
+CREATE TRIGGER IF NOT EXISTS audit_price_lists_insert
+AFTER INSERT ON price_lists
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'description', NEW.description, 'currency', NEW.currency)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_price_lists_update
+AFTER UPDATE ON price_lists
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'description', OLD.description, 'currency', OLD.currency)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'description', NEW.description, 'currency', NEW.currency)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_price_lists_delete
+AFTER DELETE ON price_lists
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'description', OLD.description, 'currency', OLD.currency)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_price_list_rules_insert
+AFTER INSERT ON price_list_rules
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list_rule', NEW.price_list_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'product_id', NEW.product_id, 'min_quantity', NEW.min_quantity, 'unit_price', NEW.unit_price,
+            'discount_percent', NEW.discount_percent)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_price_list_rules_update
+AFTER UPDATE ON price_list_rules
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list_rule', NEW.price_list_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'product_id', OLD.product_id, 'min_quantity', OLD.min_quantity, 'unit_price', OLD.unit_price,
+            'discount_percent', OLD.discount_percent)) AS o
+    JOIN json_each(json_object(
+            'product_id', NEW.product_id, 'min_quantity', NEW.min_quantity, 'unit_price', NEW.unit_price,
+            'discount_percent', NEW.discount_percent)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_price_list_rules_delete
+AFTER DELETE ON price_list_rules
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'price_list_rule', OLD.price_list_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'product_id', OLD.product_id, 'min_quantity', OLD.min_quantity, 'unit_price', OLD.unit_price,
+            'discount_percent', OLD.discount_percent)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DROP TRIGGER IF EXISTS audit_customers_insert;
+DROP TRIGGER IF EXISTS audit_customers_update;
+DROP TRIGGER IF EXISTS audit_customers_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_insert
+AFTER INSERT ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_update
+AFTER UPDATE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_customers_delete
+AFTER DELETE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DROP TRIGGER IF EXISTS audit_invoice_items_insert;
+DROP TRIGGER IF EXISTS audit_invoice_items_update;
+DROP TRIGGER IF EXISTS audit_invoice_items_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_insert
+AFTER INSERT ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'price_rule', NEW.price_rule,
+            'tax_rate', NEW.tax_rate, 'discount', NEW.discount, 'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_update
+AFTER UPDATE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'price_rule', OLD.price_rule,
+            'tax_rate', OLD.tax_rate, 'discount', OLD.discount, 'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'position', NEW.position, 'product_id', NEW.product_id,
+            'sku', NEW.sku, 'description', NEW.description, 'quantity', NEW.quantity,
+            'unit', NEW.unit, 'unit_price', NEW.unit_price, 'price_rule', NEW.price_rule,
+            'tax_rate', NEW.tax_rate, 'discount', NEW.discount, 'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoice_items_delete
+AFTER DELETE ON invoice_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice_item', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'position', OLD.position, 'product_id', OLD.product_id,
+            'sku', OLD.sku, 'description', OLD.description, 'quantity', OLD.quantity,
+            'unit', OLD.unit, 'unit_price', OLD.unit_price, 'price_rule', OLD.price_rule,
+            'tax_rate', OLD.tax_rate, 'discount', OLD.discount, 'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
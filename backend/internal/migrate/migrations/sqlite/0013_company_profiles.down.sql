@@ -0,0 +1,82 @@
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+DROP TRIGGER IF EXISTS audit_company_info_insert;
+DROP TRIGGER IF EXISTS audit_company_info_update;
+DROP TRIGGER IF EXISTS audit_company_info_delete;
+
+-- The numbering series of the profiles are kept, so the numbers they issued
+-- stay auditable
+DROP INDEX IF EXISTS idx_invoices_company_id;
+ALTER TABLE invoices DROP COLUMN company_id;
+
+DROP INDEX IF EXISTS idx_company_info_default;
+ALTER TABLE company_info DROP COLUMN version;
+ALTER TABLE company_info DROP COLUMN is_default;
+ALTER TABLE company_info DROP COLUMN invoice_series;
+ALTER TABLE company_info DROP COLUMN logo;
+ALTER TABLE company_info DROP COLUMN bank_swift;
+ALTER TABLE company_info DROP COLUMN bank_account_number;
+ALTER TABLE company_info DROP COLUMN bank_account_name;
+ALTER TABLE company_info DROP COLUMN bank_name;
+ALTER TABLE company_info DROP COLUMN legal_name;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'customer_id', NEW.customer_id, 'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'customer_id', OLD.customer_id, 'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,165 @@
+-- Company profiles, see postgres/0013_company_profiles.up.sql.
+--
+-- company_info.invoice_series and invoices.company_id have no foreign keys
+-- because SQLite cannot drop a column that has one; the application checks
+-- them, and refuses to delete a profile that issued invoices. Profiles are
+-- saved by the application, which raises their version and creates the
+-- numbering series of a new profile.
+
+ALTER TABLE company_info ADD COLUMN legal_name TEXT;
+ALTER TABLE company_info ADD COLUMN bank_name TEXT;
+ALTER TABLE company_info ADD COLUMN bank_account_name TEXT;
+ALTER TABLE company_info ADD COLUMN bank_account_number TEXT;
+ALTER TABLE company_info ADD COLUMN bank_swift TEXT;
+ALTER TABLE company_info ADD COLUMN logo TEXT;
+ALTER TABLE company_info ADD COLUMN invoice_series TEXT NOT NULL DEFAULT 'invoice';
+ALTER TABLE company_info ADD COLUMN is_default BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE company_info ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_info_default ON company_info(is_default) WHERE is_default;
+
+-- The details that were printed on every invoice become the default profile
+INSERT INTO company_info (id, name, email, phone, address, city, postal_code, country, website)
+SELECT lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+        substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+        substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6))),
+    'InvoicePro Systems', 'billing@invoicepro.com', '+62 812 3456 7890', 'Jl. Merdeka No. 123',
+    'Jakarta', '12345', 'Indonesia', 'www.invoicepro.com'
+WHERE NOT EXISTS (SELECT 1 FROM company_info);
+
+UPDATE company_info SET is_default = TRUE
+WHERE id = (SELECT id FROM company_info ORDER BY created_at, id LIMIT 1)
+  AND NOT EXISTS (SELECT 1 FROM company_info WHERE is_default);
+
+-- Existing invoices were issued by the default company; recording that is
+-- not a change to the invoice, so it is done before the audit triggers
+-- learn about the column
+ALTER TABLE invoices ADD COLUMN company_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_invoices_company_id ON invoices(company_id);
+
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+
+UPDATE invoices SET company_id = (SELECT id FROM company_info WHERE is_default) WHERE company_id IS NULL;
+
+-- Audit: company profiles, and the company of invoices
+CREATE TRIGGER IF NOT EXISTS audit_company_info_insert
+AFTER INSERT ON company_info
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'company_profile', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'legal_name', NEW.legal_name, 'tax_id', NEW.tax_id,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'email', NEW.email, 'phone', NEW.phone,
+            'website', NEW.website, 'bank_name', NEW.bank_name, 'bank_account_name', NEW.bank_account_name,
+            'bank_account_number', NEW.bank_account_number, 'bank_swift', NEW.bank_swift, 'logo', NEW.logo,
+            'invoice_series', NEW.invoice_series, 'is_default', NEW.is_default)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_company_info_update
+AFTER UPDATE ON company_info
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'company_profile', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'legal_name', OLD.legal_name, 'tax_id', OLD.tax_id,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'email', OLD.email, 'phone', OLD.phone,
+            'website', OLD.website, 'bank_name', OLD.bank_name, 'bank_account_name', OLD.bank_account_name,
+            'bank_account_number', OLD.bank_account_number, 'bank_swift', OLD.bank_swift, 'logo', OLD.logo,
+            'invoice_series', OLD.invoice_series, 'is_default', OLD.is_default)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'legal_name', NEW.legal_name, 'tax_id', NEW.tax_id,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'email', NEW.email, 'phone', NEW.phone,
+            'website', NEW.website, 'bank_name', NEW.bank_name, 'bank_account_name', NEW.bank_account_name,
+            'bank_account_number', NEW.bank_account_number, 'bank_swift', NEW.bank_swift, 'logo', NEW.logo,
+            'invoice_series', NEW.invoice_series, 'is_default', NEW.is_default)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_company_info_delete
+AFTER DELETE ON company_info
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'company_profile', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'legal_name', OLD.legal_name, 'tax_id', OLD.tax_id,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'email', OLD.email, 'phone', OLD.phone,
+            'website', OLD.website, 'bank_name', OLD.bank_name, 'bank_account_name', OLD.bank_account_name,
+            'bank_account_number', OLD.bank_account_number, 'bank_swift', OLD.bank_swift, 'logo', OLD.logo,
+            'invoice_series', OLD.invoice_series, 'is_default', OLD.is_default)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,475 @@
+-- Every organization's records are merged into one set again, see
+-- postgres/0014_organizations.down.sql; this fails when two organizations
+-- have a customer with the same e-mail address, a product with the same
+-- SKU or issued the same invoice or receipt number. Only the default
+-- organization's currency rates are kept.
+
+-- Numbering: the default organization's series, and those only other
+-- organizations have
+CREATE TABLE number_series_old (
+    series_key TEXT PRIMARY KEY,
+    prefix TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL,
+    reset TEXT NOT NULL DEFAULT 'yearly' CHECK (reset IN ('never', 'yearly', 'monthly')),
+    padding INTEGER NOT NULL DEFAULT 4 CHECK (padding BETWEEN 1 AND 12),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE number_series_counters_old (
+    series_key TEXT NOT NULL REFERENCES number_series(series_key),
+    period TEXT NOT NULL,
+    last_value INTEGER NOT NULL,
+    PRIMARY KEY (series_key, period)
+);
+
+CREATE TABLE document_numbers_old (
+    series_key TEXT NOT NULL REFERENCES number_series(series_key),
+    period TEXT NOT NULL,
+    sequence INTEGER NOT NULL,
+    number TEXT NOT NULL,
+    document_id TEXT,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (series_key, period, sequence),
+    UNIQUE (series_key, number)
+);
+
+INSERT OR IGNORE INTO number_series_old (series_key, prefix, pattern, reset, padding, updated_at)
+SELECT series_key, prefix, pattern, reset, padding, updated_at
+FROM number_series
+ORDER BY org_id <> '00000000-0000-0000-0000-000000000001';
+
+INSERT INTO number_series_counters_old (series_key, period, last_value)
+SELECT series_key, period, MAX(last_value)
+FROM number_series_counters
+GROUP BY series_key, period;
+
+INSERT INTO document_numbers_old (series_key, period, sequence, number, document_id, issued_at)
+SELECT series_key, period, sequence, number, document_id, issued_at
+FROM document_numbers
+WHERE org_id = '00000000-0000-0000-0000-000000000001';
+
+DROP TABLE document_numbers;
+DROP TABLE number_series_counters;
+DROP TABLE number_series;
+
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE number_series_old RENAME TO number_series;
+ALTER TABLE number_series_counters_old RENAME TO number_series_counters;
+ALTER TABLE document_numbers_old RENAME TO document_numbers;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE TRIGGER audit_number_series_insert
+AFTER INSERT ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_number_series_update
+AFTER UPDATE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    JOIN json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_number_series_delete
+AFTER DELETE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', OLD.series_key, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Company profiles
+DROP INDEX IF EXISTS idx_company_info_default;
+UPDATE company_info SET is_default = FALSE WHERE org_id <> '00000000-0000-0000-0000-000000000001' AND is_default;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_info_default ON company_info(is_default) WHERE is_default;
+ALTER TABLE company_info DROP COLUMN org_id;
+
+-- Catalog
+DROP INDEX IF EXISTS idx_price_lists_org_id;
+ALTER TABLE price_lists DROP COLUMN org_id;
+
+CREATE TABLE products_old (
+    id TEXT PRIMARY KEY,
+    sku TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    description TEXT,
+    unit TEXT,
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    active BOOLEAN NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO products_old (id, sku, name, description, unit, tax_rate, active, version, created_at, updated_at)
+SELECT id, sku, name, description, unit, tax_rate, active, version, created_at, updated_at FROM products;
+
+DROP TABLE products;
+
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE products_old RENAME TO products;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_products_active ON products(active);
+
+CREATE TRIGGER products_unlink
+AFTER DELETE ON products
+BEGIN
+    UPDATE invoice_items SET product_id = NULL WHERE product_id = OLD.id;
+END;
+
+CREATE TRIGGER audit_products_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_products_update
+AFTER UPDATE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    JOIN json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_products_delete
+AFTER DELETE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Document numbers
+DROP INDEX IF EXISTS idx_payments_receipt_number;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_receipt_number ON payments(receipt_number);
+
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales AS
+SELECT
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY COALESCE(it.sku, it.description), it.unit, i.currency;
+
+CREATE TABLE invoices_new (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    invoice_number TEXT UNIQUE,
+    subtotal DECIMAL(10,2) NOT NULL,
+    tax DECIMAL(10,2) DEFAULT 0,
+    discount DECIMAL(10,2) DEFAULT 0,
+    total DECIMAL(10,2) NOT NULL,
+    pdf_url TEXT,
+    status TEXT DEFAULT 'pending',
+    notes TEXT,
+    currency TEXT DEFAULT 'USD',
+    payment_status TEXT DEFAULT 'unpaid'
+        CHECK (payment_status IN ('unpaid', 'partially_paid', 'paid', 'overdue')),
+    paid_amount DECIMAL(20,2) DEFAULT 0,
+    payment_date TIMESTAMP,
+    last_reminder_sent TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    due_date TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    company_id TEXT
+);
+
+INSERT INTO invoices_new (id, customer_id, invoice_number, subtotal, tax, discount, total, pdf_url,
+    status, notes, currency, payment_status, paid_amount, payment_date, last_reminder_sent,
+    created_at, due_date, version, company_id)
+SELECT id, customer_id, invoice_number, subtotal, tax, discount, total, pdf_url, status, notes,
+    currency, payment_status, paid_amount, payment_date, last_reminder_sent, created_at, due_date,
+    version, company_id
+FROM invoices;
+
+DROP TABLE invoices;
+
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE invoices_new RENAME TO invoices;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_customer_id ON invoices(customer_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_created_at ON invoices(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
+CREATE INDEX IF NOT EXISTS idx_invoices_payment_status ON invoices(payment_status);
+CREATE INDEX IF NOT EXISTS idx_invoices_currency ON invoices(currency);
+CREATE INDEX IF NOT EXISTS idx_invoices_due_date ON invoices(due_date);
+CREATE INDEX IF NOT EXISTS idx_invoices_company_id ON invoices(company_id);
+
+CREATE TRIGGER invoices_search_delete
+AFTER DELETE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+END;
+
+CREATE TRIGGER invoices_search_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER invoices_search_update
+AFTER UPDATE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+
+DROP TRIGGER IF EXISTS audit_log_org;
+DROP TRIGGER IF EXISTS audit_log_org_once;
+DROP TRIGGER IF EXISTS audit_log_no_update;
+DROP INDEX IF EXISTS idx_audit_log_org_id;
+ALTER TABLE audit_log DROP COLUMN org_id;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+ALTER TABLE audit_actor DROP COLUMN org_id;
+
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency_key ON payments(idempotency_key);
+
+DROP INDEX IF EXISTS idx_payments_org_id;
+ALTER TABLE payments DROP COLUMN org_id;
+
+CREATE TABLE currency_rates_old (
+    id TEXT PRIMARY KEY,
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate DECIMAL(20,6) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (from_currency, to_currency)
+);
+
+INSERT INTO currency_rates_old (id, from_currency, to_currency, rate, updated_at)
+SELECT id, from_currency, to_currency, rate, updated_at FROM currency_rates
+WHERE org_id = '00000000-0000-0000-0000-000000000001';
+
+DROP TABLE currency_rates;
+ALTER TABLE currency_rates_old RENAME TO currency_rates;
+
+CREATE TABLE customers_old (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT DEFAULT 'Indonesia',
+    company_name TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    price_list_id TEXT
+);
+
+INSERT INTO customers_old (id, name, email, phone, address, city, postal_code, country, company_name,
+    created_at, updated_at, archived_at, version, price_list_id)
+SELECT id, name, email, phone, address, city, postal_code, country, company_name,
+    created_at, updated_at, archived_at, version, price_list_id
+FROM customers;
+
+DROP TABLE customers;
+
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE customers_old RENAME TO customers;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_customers_email ON customers(email);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers(created_at);
+CREATE INDEX IF NOT EXISTS idx_customers_archived_at ON customers(archived_at);
+CREATE INDEX IF NOT EXISTS idx_customers_price_list_id ON customers(price_list_id) WHERE price_list_id IS NOT NULL;
+
+CREATE TRIGGER customers_search_update
+AFTER UPDATE OF name, company_name, email ON customers
+BEGIN
+    UPDATE invoice_search
+    SET customer = NEW.name || ' ' || COALESCE(NEW.company_name, '') || ' ' || COALESCE(NEW.email, '')
+    WHERE invoice_id IN (SELECT id FROM invoices WHERE customer_id = NEW.id);
+END;
+
+CREATE TRIGGER customers_restrict_delete
+BEFORE DELETE ON customers
+WHEN EXISTS (SELECT 1 FROM invoices WHERE customer_id = OLD.id)
+BEGIN
+    SELECT RAISE(ABORT, 'customer has invoices');
+END;
+
+CREATE TRIGGER audit_customers_insert
+AFTER INSERT ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_customers_update
+AFTER UPDATE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_customers_delete
+AFTER DELETE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,523 @@
+-- Organizations, see postgres/0014_organizations.up.sql.
+--
+-- SQLite has no row level security: the application scopes every query on
+-- customers, invoices, payments, currency rates, company profiles, the
+-- catalog and the numbering series to its organization. The organization of
+-- the transaction is kept in audit_actor next to the actor, and stamped on
+-- the audit log entries written in it.
+--
+-- customers, currency_rates, invoices, products and the numbering tables
+-- are rebuilt, since SQLite cannot change a UNIQUE constraint or a primary
+-- key in place; the migration runner turns foreign key enforcement off while
+-- it runs, so the rows referencing them are kept.
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO organizations (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'Default organization');
+
+-- Customers: e-mail addresses are unique within an organization
+CREATE TABLE customers_new (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    phone TEXT,
+    address TEXT,
+    city TEXT,
+    postal_code TEXT,
+    country TEXT DEFAULT 'Indonesia',
+    company_name TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    price_list_id TEXT,
+    UNIQUE (org_id, email)
+);
+
+INSERT INTO customers_new (id, name, email, phone, address, city, postal_code, country, company_name,
+    created_at, updated_at, archived_at, version, price_list_id)
+SELECT id, name, email, phone, address, city, postal_code, country, company_name,
+    created_at, updated_at, archived_at, version, price_list_id
+FROM customers;
+
+DROP TABLE customers;
+
+-- The invoice search triggers name customers, which does not exist until
+-- the rename; the legacy rename leaves them alone
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE customers_new RENAME TO customers;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_customers_email ON customers(email);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers(created_at);
+CREATE INDEX IF NOT EXISTS idx_customers_archived_at ON customers(archived_at);
+CREATE INDEX IF NOT EXISTS idx_customers_price_list_id ON customers(price_list_id) WHERE price_list_id IS NOT NULL;
+
+CREATE TRIGGER customers_search_update
+AFTER UPDATE OF name, company_name, email ON customers
+BEGIN
+    UPDATE invoice_search
+    SET customer = NEW.name || ' ' || COALESCE(NEW.company_name, '') || ' ' || COALESCE(NEW.email, '')
+    WHERE invoice_id IN (SELECT id FROM invoices WHERE customer_id = NEW.id);
+END;
+
+CREATE TRIGGER customers_restrict_delete
+BEFORE DELETE ON customers
+WHEN EXISTS (SELECT 1 FROM invoices WHERE customer_id = OLD.id)
+BEGIN
+    SELECT RAISE(ABORT, 'customer has invoices');
+END;
+
+CREATE TRIGGER audit_customers_insert
+AFTER INSERT ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_customers_update
+AFTER UPDATE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    JOIN json_each(json_object(
+            'name', NEW.name, 'email', NEW.email, 'phone', NEW.phone,
+            'address', NEW.address, 'city', NEW.city, 'postal_code', NEW.postal_code,
+            'country', NEW.country, 'company_name', NEW.company_name, 'archived_at', NEW.archived_at,
+            'price_list_id', NEW.price_list_id)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_customers_delete
+AFTER DELETE ON customers
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'customer', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'name', OLD.name, 'email', OLD.email, 'phone', OLD.phone,
+            'address', OLD.address, 'city', OLD.city, 'postal_code', OLD.postal_code,
+            'country', OLD.country, 'company_name', OLD.company_name, 'archived_at', OLD.archived_at,
+            'price_list_id', OLD.price_list_id)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Currency rates: each organization keeps its own rates, starting from
+-- copies of the default organization's
+CREATE TABLE currency_rates_new (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate DECIMAL(20,6) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, from_currency, to_currency)
+);
+
+INSERT INTO currency_rates_new (id, from_currency, to_currency, rate, updated_at)
+SELECT id, from_currency, to_currency, rate, updated_at FROM currency_rates;
+
+DROP TABLE currency_rates;
+ALTER TABLE currency_rates_new RENAME TO currency_rates;
+
+-- Invoices: numbers are unique within an organization
+CREATE TABLE invoices_new (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    invoice_number TEXT,
+    subtotal DECIMAL(10,2) NOT NULL,
+    tax DECIMAL(10,2) DEFAULT 0,
+    discount DECIMAL(10,2) DEFAULT 0,
+    total DECIMAL(10,2) NOT NULL,
+    pdf_url TEXT,
+    status TEXT DEFAULT 'pending',
+    notes TEXT,
+    currency TEXT DEFAULT 'USD',
+    payment_status TEXT DEFAULT 'unpaid'
+        CHECK (payment_status IN ('unpaid', 'partially_paid', 'paid', 'overdue')),
+    paid_amount DECIMAL(20,2) DEFAULT 0,
+    payment_date TIMESTAMP,
+    last_reminder_sent TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    due_date TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    company_id TEXT,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+    UNIQUE (org_id, invoice_number)
+);
+
+INSERT INTO invoices_new (id, customer_id, invoice_number, subtotal, tax, discount, total, pdf_url,
+    status, notes, currency, payment_status, paid_amount, payment_date, last_reminder_sent,
+    created_at, due_date, version, company_id)
+SELECT id, customer_id, invoice_number, subtotal, tax, discount, total, pdf_url, status, notes,
+    currency, payment_status, paid_amount, payment_date, last_reminder_sent, created_at, due_date,
+    version, company_id
+FROM invoices;
+
+DROP TABLE invoices;
+
+-- The item sales view and the customer triggers name invoices, which does
+-- not exist until the rename; the legacy rename leaves them alone
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE invoices_new RENAME TO invoices;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_customer_id ON invoices(customer_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_created_at ON invoices(created_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
+CREATE INDEX IF NOT EXISTS idx_invoices_payment_status ON invoices(payment_status);
+CREATE INDEX IF NOT EXISTS idx_invoices_currency ON invoices(currency);
+CREATE INDEX IF NOT EXISTS idx_invoices_due_date ON invoices(due_date);
+CREATE INDEX IF NOT EXISTS idx_invoices_company_id ON invoices(company_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_org_id ON invoices(org_id, created_at);
+
+CREATE TRIGGER invoices_search_delete
+AFTER DELETE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+END;
+
+CREATE TRIGGER invoices_search_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER invoices_search_update
+AFTER UPDATE ON invoices
+BEGIN
+    DELETE FROM invoice_search WHERE invoice_id = OLD.id;
+    INSERT INTO invoice_search (invoice_id, invoice_number, customer, items, notes)
+    SELECT NEW.id,
+        COALESCE(NEW.invoice_number, ''),
+        COALESCE((SELECT name || ' ' || COALESCE(company_name, '') || ' ' || COALESCE(email, '')
+                  FROM customers WHERE id = NEW.customer_id), ''),
+        COALESCE((SELECT group_concat(trim(COALESCE(sku, '') || ' ' || description), ' ')
+                  FROM (SELECT sku, description FROM invoice_items WHERE invoice_id = NEW.id ORDER BY position)), ''),
+        COALESCE(NEW.notes, '');
+END;
+
+CREATE TRIGGER audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Payments, company profiles and price lists get org_id in place. It has no
+-- foreign key, because SQLite cannot drop a column that has one.
+ALTER TABLE payments ADD COLUMN org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001';
+CREATE INDEX IF NOT EXISTS idx_payments_org_id ON payments(org_id, payment_date);
+
+-- Idempotency keys and receipt numbers are unique within an organization
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_idempotency_key ON payments(org_id, idempotency_key);
+DROP INDEX IF EXISTS idx_payments_receipt_number;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_receipt_number ON payments(org_id, receipt_number);
+
+ALTER TABLE company_info ADD COLUMN org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001';
+ALTER TABLE price_lists ADD COLUMN org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001';
+CREATE INDEX IF NOT EXISTS idx_price_lists_org_id ON price_lists(org_id, name);
+
+-- The default profile is unique within an organization
+DROP INDEX IF EXISTS idx_company_info_default;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_info_default ON company_info(org_id) WHERE is_default;
+
+-- Numbering: every organization has its own series, counters and log of
+-- issued numbers
+CREATE TABLE number_series_new (
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    series_key TEXT NOT NULL,
+    prefix TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL,
+    reset TEXT NOT NULL DEFAULT 'yearly' CHECK (reset IN ('never', 'yearly', 'monthly')),
+    padding INTEGER NOT NULL DEFAULT 4 CHECK (padding BETWEEN 1 AND 12),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, series_key)
+);
+
+CREATE TABLE number_series_counters_new (
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+    series_key TEXT NOT NULL,
+    period TEXT NOT NULL,
+    last_value INTEGER NOT NULL,
+    PRIMARY KEY (org_id, series_key, period),
+    FOREIGN KEY (org_id, series_key) REFERENCES number_series(org_id, series_key)
+);
+
+CREATE TABLE document_numbers_new (
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+    series_key TEXT NOT NULL,
+    period TEXT NOT NULL,
+    sequence INTEGER NOT NULL,
+    number TEXT NOT NULL,
+    document_id TEXT,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, series_key, period, sequence),
+    UNIQUE (org_id, series_key, number),
+    FOREIGN KEY (org_id, series_key) REFERENCES number_series(org_id, series_key)
+);
+
+INSERT INTO number_series_new (series_key, prefix, pattern, reset, padding, updated_at)
+SELECT series_key, prefix, pattern, reset, padding, updated_at FROM number_series;
+
+INSERT INTO number_series_counters_new (series_key, period, last_value)
+SELECT series_key, period, last_value FROM number_series_counters;
+
+INSERT INTO document_numbers_new (series_key, period, sequence, number, document_id, issued_at)
+SELECT series_key, period, sequence, number, document_id, issued_at FROM document_numbers;
+
+DROP TABLE document_numbers;
+DROP TABLE number_series_counters;
+DROP TABLE number_series;
+
+-- The counters and numbers name number_series, which does not exist until
+-- the rename
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE number_series_new RENAME TO number_series;
+ALTER TABLE number_series_counters_new RENAME TO number_series_counters;
+ALTER TABLE document_numbers_new RENAME TO document_numbers;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE TRIGGER audit_number_series_insert
+AFTER INSERT ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_number_series_update
+AFTER UPDATE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', NEW.series_key, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    JOIN json_each(json_object(
+            'prefix', NEW.prefix, 'pattern', NEW.pattern, 'reset', NEW.reset,
+            'padding', NEW.padding)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_number_series_delete
+AFTER DELETE ON number_series
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'number_series', OLD.series_key, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'prefix', OLD.prefix, 'pattern', OLD.pattern, 'reset', OLD.reset,
+            'padding', OLD.padding)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Products: SKUs are unique within an organization
+CREATE TABLE products_new (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    sku TEXT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    unit TEXT,
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    active BOOLEAN NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, sku)
+);
+
+INSERT INTO products_new (id, sku, name, description, unit, tax_rate, active, version, created_at, updated_at)
+SELECT id, sku, name, description, unit, tax_rate, active, version, created_at, updated_at FROM products;
+
+DROP TABLE products;
+
+-- The prices and price list rules name products
+PRAGMA legacy_alter_table = ON;
+ALTER TABLE products_new RENAME TO products;
+PRAGMA legacy_alter_table = OFF;
+
+CREATE INDEX IF NOT EXISTS idx_products_active ON products(active);
+
+CREATE TRIGGER products_unlink
+AFTER DELETE ON products
+BEGIN
+    UPDATE invoice_items SET product_id = NULL WHERE product_id = OLD.id;
+END;
+
+CREATE TRIGGER audit_products_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER audit_products_update
+AFTER UPDATE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    JOIN json_each(json_object(
+            'sku', NEW.sku, 'name', NEW.name, 'description', NEW.description,
+            'unit', NEW.unit, 'tax_rate', NEW.tax_rate, 'active', NEW.active)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER audit_products_delete
+AFTER DELETE ON products
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'product', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'sku', OLD.sku, 'name', OLD.name, 'description', OLD.description,
+            'unit', OLD.unit, 'tax_rate', OLD.tax_rate, 'active', OLD.active)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Item sales are reported per organization
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales AS
+SELECT
+    i.org_id,
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+GROUP BY i.org_id, COALESCE(it.sku, it.description), it.unit, i.currency;
+
+-- Audit log: every entry records the organization it was written in.
+-- Existing entries belong to the default organization; a new entry is
+-- stamped once, right after it is inserted, and stays append-only otherwise.
+ALTER TABLE audit_actor ADD COLUMN org_id TEXT;
+
+DROP TRIGGER IF EXISTS audit_log_no_update;
+ALTER TABLE audit_log ADD COLUMN org_id TEXT;
+UPDATE audit_log SET org_id = '00000000-0000-0000-0000-000000000001';
+CREATE INDEX IF NOT EXISTS idx_audit_log_org_id ON audit_log(org_id, entity_type, entity_id, id);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+BEFORE UPDATE OF id, entity_type, entity_id, action, actor, changes, created_at ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_org_once
+BEFORE UPDATE OF org_id ON audit_log
+WHEN OLD.org_id IS NOT NULL
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_org
+AFTER INSERT ON audit_log
+WHEN NEW.org_id IS NULL
+BEGIN
+    UPDATE audit_log SET org_id = (SELECT org_id FROM audit_actor) WHERE id = NEW.id;
+END;
@@ -0,0 +1,139 @@
+-- Invoice lifecycle, see postgres/0015_invoice_status.up.sql.
+--
+-- The application checks status transitions and settles the status from the
+-- payments and due date; these triggers only keep status to the known
+-- values, as the CHECK constraint does on Postgres.
+
+ALTER TABLE invoices ADD COLUMN issued_at TIMESTAMP;
+ALTER TABLE invoices ADD COLUMN sent_at TIMESTAMP;
+ALTER TABLE invoices ADD COLUMN status_reason TEXT;
+
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+
+-- Statuses were free text. Drafts stay drafts and cancelled invoices are
+-- void, or written off when something was paid on them. Every other invoice
+-- was issued when it was created, sent if its status said so, and from now
+-- on follows its payments and due date; an invoice marked paid by hand with
+-- nothing recorded against it is open again.
+UPDATE invoices SET issued_at = created_at WHERE status IS NOT 'draft';
+UPDATE invoices SET sent_at = created_at WHERE status = 'sent';
+UPDATE invoices SET status = CASE
+    WHEN status = 'draft' THEN 'draft'
+    WHEN status IN ('cancelled', 'canceled', 'void') AND COALESCE(paid_amount, 0) > 0 THEN 'written_off'
+    WHEN status IN ('cancelled', 'canceled', 'void') THEN 'void'
+    WHEN COALESCE(paid_amount, 0) >= total THEN 'paid'
+    WHEN due_date < date('now') THEN 'overdue'
+    WHEN COALESCE(paid_amount, 0) > 0 THEN 'partially_paid'
+    WHEN status = 'sent' THEN 'sent'
+    ELSE 'issued'
+END;
+UPDATE invoices SET payment_status = CASE
+    WHEN status IN ('paid', 'overdue') THEN status
+    WHEN COALESCE(paid_amount, 0) > 0 THEN 'partially_paid'
+    ELSE 'unpaid'
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_status_insert
+BEFORE INSERT ON invoices
+WHEN NEW.status IS NULL OR NEW.status NOT IN ('draft', 'issued', 'sent', 'partially_paid', 'paid', 'overdue', 'void', 'written_off')
+BEGIN
+    SELECT RAISE(ABORT, 'unknown invoice status');
+END;
+
+CREATE TRIGGER IF NOT EXISTS invoices_status_update
+BEFORE UPDATE OF status ON invoices
+WHEN NEW.status IS NULL OR NEW.status NOT IN ('draft', 'issued', 'sent', 'partially_paid', 'paid', 'overdue', 'void', 'written_off')
+BEGIN
+    SELECT RAISE(ABORT, 'unknown invoice status');
+END;
+
+DROP INDEX IF EXISTS idx_invoices_status;
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(org_id, status, due_date);
+
+-- Drafts and void invoices did not sell anything
+DROP VIEW IF EXISTS item_sales;
+CREATE VIEW item_sales AS
+SELECT
+    i.org_id,
+    MAX(it.sku) AS sku,
+    MIN(it.description) AS description,
+    it.unit,
+    i.currency,
+    SUM(it.quantity) AS quantity,
+    SUM(it.total) AS revenue,
+    COUNT(DISTINCT it.invoice_id) AS invoice_count
+FROM invoice_items it
+JOIN invoices i ON i.id = it.invoice_id
+WHERE i.status NOT IN ('draft', 'void')
+GROUP BY i.org_id, COALESCE(it.sku, it.description), it.unit, i.currency;
+
+-- Audit: the lifecycle columns of invoices
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date, 'issued_at', NEW.issued_at, 'sent_at', NEW.sent_at,
+            'status_reason', NEW.status_reason,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date, 'issued_at', OLD.issued_at, 'sent_at', OLD.sent_at,
+            'status_reason', OLD.status_reason,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount,
+            'payment_date', NEW.payment_date, 'issued_at', NEW.issued_at, 'sent_at', NEW.sent_at,
+            'status_reason', NEW.status_reason,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount,
+            'payment_date', OLD.payment_date, 'issued_at', OLD.issued_at, 'sent_at', OLD.sent_at,
+            'status_reason', OLD.status_reason,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,202 @@
+-- Credit notes, see postgres/0016_credit_notes.up.sql.
+
+ALTER TABLE invoices ADD COLUMN credited_amount DECIMAL(20,4) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS credit_notes (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    invoice_id TEXT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    credit_note_number TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    subtotal DECIMAL(20,4) NOT NULL CHECK (subtotal >= 0),
+    tax DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (tax >= 0),
+    discount DECIMAL(20,4) NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    total DECIMAL(20,4) NOT NULL CHECK (total > 0),
+    currency TEXT NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT,
+    UNIQUE (org_id, credit_note_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_notes_invoice_id ON credit_notes(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_credit_notes_org_id ON credit_notes(org_id, created_at);
+
+-- A line credited by amount has no quantity
+CREATE TABLE IF NOT EXISTS credit_note_items (
+    id TEXT PRIMARY KEY,
+    credit_note_id TEXT NOT NULL REFERENCES credit_notes(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL CHECK (position > 0),
+    invoice_item_id TEXT NOT NULL REFERENCES invoice_items(id) ON DELETE CASCADE,
+    sku TEXT,
+    description TEXT NOT NULL,
+    quantity DECIMAL(20,4) CHECK (quantity > 0),
+    unit TEXT,
+    unit_price DECIMAL(20,4) NOT NULL CHECK (unit_price >= 0),
+    tax_rate DECIMAL(7,4) CHECK (tax_rate BETWEEN 0 AND 100),
+    total DECIMAL(20,4) NOT NULL CHECK (total > 0),
+    UNIQUE (credit_note_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_note_items_invoice_item_id ON credit_note_items(invoice_item_id);
+
+-- Audit
+CREATE TRIGGER IF NOT EXISTS audit_credit_notes_insert
+AFTER INSERT ON credit_notes
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'credit_note_number', NEW.credit_note_number, 'reason', NEW.reason,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'currency', NEW.currency, 'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_notes_update
+AFTER UPDATE ON credit_notes
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'credit_note_number', OLD.credit_note_number, 'reason', OLD.reason,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'currency', OLD.currency, 'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'credit_note_number', NEW.credit_note_number, 'reason', NEW.reason,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'currency', NEW.currency, 'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_notes_delete
+AFTER DELETE ON credit_notes
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'credit_note_number', OLD.credit_note_number, 'reason', OLD.reason,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'currency', OLD.currency, 'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_note_items_insert
+AFTER INSERT ON credit_note_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note_item', NEW.credit_note_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'position', NEW.position, 'invoice_item_id', NEW.invoice_item_id, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate, 'total', NEW.total)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_note_items_update
+AFTER UPDATE ON credit_note_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note_item', NEW.credit_note_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'position', OLD.position, 'invoice_item_id', OLD.invoice_item_id, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate, 'total', OLD.total)) AS o
+    JOIN json_each(json_object(
+            'position', NEW.position, 'invoice_item_id', NEW.invoice_item_id, 'sku', NEW.sku,
+            'description', NEW.description, 'quantity', NEW.quantity, 'unit', NEW.unit,
+            'unit_price', NEW.unit_price, 'tax_rate', NEW.tax_rate, 'total', NEW.total)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_note_items_delete
+AFTER DELETE ON credit_note_items
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_note_item', OLD.credit_note_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'position', OLD.position, 'invoice_item_id', OLD.invoice_item_id, 'sku', OLD.sku,
+            'description', OLD.description, 'quantity', OLD.quantity, 'unit', OLD.unit,
+            'unit_price', OLD.unit_price, 'tax_rate', OLD.tax_rate, 'total', OLD.total)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Audit: the credited amount of invoices
+DROP TRIGGER IF EXISTS audit_invoices_insert;
+DROP TRIGGER IF EXISTS audit_invoices_update;
+DROP TRIGGER IF EXISTS audit_invoices_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_insert
+AFTER INSERT ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END)), '{}')
+    FROM json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount, 'credited_amount', NEW.credited_amount,
+            'payment_date', NEW.payment_date, 'issued_at', NEW.issued_at, 'sent_at', NEW.sent_at,
+            'status_reason', NEW.status_reason,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_update
+AFTER UPDATE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', CASE WHEN n.type IN ('object', 'array') THEN json(n.value) ELSE n.value END))
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount, 'credited_amount', OLD.credited_amount,
+            'payment_date', OLD.payment_date, 'issued_at', OLD.issued_at, 'sent_at', OLD.sent_at,
+            'status_reason', OLD.status_reason,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    JOIN json_each(json_object(
+            'company_id', NEW.company_id, 'customer_id', NEW.customer_id,
+            'invoice_number', NEW.invoice_number,
+            'subtotal', NEW.subtotal, 'tax', NEW.tax, 'discount', NEW.discount,
+            'total', NEW.total, 'pdf_url', NEW.pdf_url,
+            'status', NEW.status, 'notes', NEW.notes, 'currency', NEW.currency,
+            'payment_status', NEW.payment_status, 'paid_amount', NEW.paid_amount, 'credited_amount', NEW.credited_amount,
+            'payment_date', NEW.payment_date, 'issued_at', NEW.issued_at, 'sent_at', NEW.sent_at,
+            'status_reason', NEW.status_reason,
+            'last_reminder_sent', NEW.last_reminder_sent, 'due_date', NEW.due_date)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_invoices_delete
+AFTER DELETE ON invoices
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'invoice', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', CASE WHEN o.type IN ('object', 'array') THEN json(o.value) ELSE o.value END, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'company_id', OLD.company_id, 'customer_id', OLD.customer_id,
+            'invoice_number', OLD.invoice_number,
+            'subtotal', OLD.subtotal, 'tax', OLD.tax, 'discount', OLD.discount,
+            'total', OLD.total, 'pdf_url', OLD.pdf_url,
+            'status', OLD.status, 'notes', OLD.notes, 'currency', OLD.currency,
+            'payment_status', OLD.payment_status, 'paid_amount', OLD.paid_amount, 'credited_amount', OLD.credited_amount,
+            'payment_date', OLD.payment_date, 'issued_at', OLD.issued_at, 'sent_at', OLD.sent_at,
+            'status_reason', OLD.status_reason,
+            'last_reminder_sent', OLD.last_reminder_sent, 'due_date', OLD.due_date)) AS o
+    WHERE o.value IS NOT NULL;
+END;
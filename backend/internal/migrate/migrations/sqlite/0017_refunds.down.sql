@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS audit_refunds_insert;
+DROP TRIGGER IF EXISTS audit_refunds_update;
+DROP TRIGGER IF EXISTS audit_refunds_delete;
+
+DROP TABLE IF EXISTS refunds;
+
+ALTER TABLE payments DROP COLUMN refunded_amount;
@@ -0,0 +1,67 @@
+-- Refunds, see postgres/0017_refunds.up.sql.
+
+ALTER TABLE payments ADD COLUMN refunded_amount DECIMAL(20,4) NOT NULL DEFAULT 0
+    CHECK (refunded_amount >= 0 AND refunded_amount <= amount);
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    payment_id TEXT NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    invoice_id TEXT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    refund_method TEXT NOT NULL,
+    refund_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reference_number TEXT,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_org_id ON refunds(org_id, refund_date);
+
+-- Audit, under the refunded payment's ID
+CREATE TRIGGER IF NOT EXISTS audit_refunds_insert
+AFTER INSERT ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_update
+AFTER UPDATE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_delete
+AFTER DELETE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', OLD.payment_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,60 @@
+DROP TRIGGER IF EXISTS audit_payments_insert;
+DROP TRIGGER IF EXISTS audit_payments_update;
+DROP TRIGGER IF EXISTS audit_payments_delete;
+
+ALTER TABLE payments DROP COLUMN voided_at;
+ALTER TABLE payments DROP COLUMN void_reason;
+ALTER TABLE payments DROP COLUMN correction_reason;
+ALTER TABLE payments DROP COLUMN version;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_insert
+AFTER INSERT ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number,
+            'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date,
+            'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_update
+AFTER UPDATE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number,
+            'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date,
+            'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number,
+            'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date,
+            'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_delete
+AFTER DELETE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number,
+            'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date,
+            'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
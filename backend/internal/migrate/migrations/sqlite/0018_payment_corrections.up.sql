@@ -0,0 +1,59 @@
+-- Payment corrections, see postgres/0018_payment_corrections.up.sql.
+
+ALTER TABLE payments ADD COLUMN voided_at TIMESTAMP;
+ALTER TABLE payments ADD COLUMN void_reason TEXT;
+ALTER TABLE payments ADD COLUMN correction_reason TEXT;
+ALTER TABLE payments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Audit the void and correction reasons
+DROP TRIGGER IF EXISTS audit_payments_insert;
+DROP TRIGGER IF EXISTS audit_payments_update;
+DROP TRIGGER IF EXISTS audit_payments_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_insert
+AFTER INSERT ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number, 'amount', NEW.amount,
+            'payment_method', NEW.payment_method, 'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number,
+            'notes', NEW.notes, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason, 'correction_reason', NEW.correction_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_update
+AFTER UPDATE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number, 'amount', OLD.amount,
+            'payment_method', OLD.payment_method, 'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number,
+            'notes', OLD.notes, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason, 'correction_reason', OLD.correction_reason)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number, 'amount', NEW.amount,
+            'payment_method', NEW.payment_method, 'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number,
+            'notes', NEW.notes, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason, 'correction_reason', NEW.correction_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_delete
+AFTER DELETE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number, 'amount', OLD.amount,
+            'payment_method', OLD.payment_method, 'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number,
+            'notes', OLD.notes, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason, 'correction_reason', OLD.correction_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,143 @@
+-- Receipts and their refunds are forgotten with the customer credit; credit
+-- applied to invoices stays as their payments, of method 'other', without
+-- receipt numbers.
+
+INSERT INTO payments (id, org_id, invoice_id, customer_id, currency, amount, payment_method, payment_date, created_at,
+    created_by, voided_at, void_reason)
+SELECT id, org_id, invoice_id, customer_id, currency, amount, 'other', applied_at, created_at, created_by, voided_at,
+    void_reason
+FROM credit_applications;
+
+DROP TRIGGER IF EXISTS audit_credit_applications_insert;
+DROP TRIGGER IF EXISTS audit_credit_applications_update;
+DROP TRIGGER IF EXISTS audit_credit_applications_delete;
+
+DROP TABLE IF EXISTS credit_applications;
+
+DELETE FROM refunds WHERE invoice_id IS NULL;
+DELETE FROM payments WHERE invoice_id IS NULL;
+
+CREATE TABLE refunds_old (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    payment_id TEXT NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    invoice_id TEXT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    refund_method TEXT NOT NULL,
+    refund_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reference_number TEXT,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT
+);
+
+INSERT INTO refunds_old SELECT id, org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number,
+    reason, created_at, created_by FROM refunds;
+DROP TABLE refunds;
+ALTER TABLE refunds_old RENAME TO refunds;
+
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_org_id ON refunds(org_id, refund_date);
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_insert
+AFTER INSERT ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_update
+AFTER UPDATE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_delete
+AFTER DELETE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', OLD.payment_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+DROP TABLE IF EXISTS customer_credits;
+
+DROP TRIGGER IF EXISTS audit_payments_insert;
+DROP TRIGGER IF EXISTS audit_payments_update;
+DROP TRIGGER IF EXISTS audit_payments_delete;
+
+DROP INDEX IF EXISTS idx_payments_customer_id;
+ALTER TABLE payments DROP COLUMN customer_id;
+ALTER TABLE payments DROP COLUMN currency;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_insert
+AFTER INSERT ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number, 'amount', NEW.amount,
+            'payment_method', NEW.payment_method, 'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number,
+            'notes', NEW.notes, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason, 'correction_reason', NEW.correction_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_update
+AFTER UPDATE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number, 'amount', OLD.amount,
+            'payment_method', OLD.payment_method, 'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number,
+            'notes', OLD.notes, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason, 'correction_reason', OLD.correction_reason)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'receipt_number', NEW.receipt_number, 'amount', NEW.amount,
+            'payment_method', NEW.payment_method, 'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number,
+            'notes', NEW.notes, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason, 'correction_reason', NEW.correction_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_delete
+AFTER DELETE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'receipt_number', OLD.receipt_number, 'amount', OLD.amount,
+            'payment_method', OLD.payment_method, 'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number,
+            'notes', OLD.notes, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason, 'correction_reason', OLD.correction_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,222 @@
+-- Customer credit, see postgres/0019_customer_credit.up.sql.
+
+ALTER TABLE payments ADD COLUMN customer_id TEXT;
+ALTER TABLE payments ADD COLUMN currency TEXT;
+
+UPDATE payments SET
+    customer_id = (SELECT customer_id FROM invoices WHERE invoices.id = payments.invoice_id),
+    currency = (SELECT COALESCE(currency, 'USD') FROM invoices WHERE invoices.id = payments.invoice_id);
+
+CREATE INDEX IF NOT EXISTS idx_payments_customer_id ON payments(customer_id, currency);
+
+CREATE TABLE IF NOT EXISTS customer_credits (
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    customer_id TEXT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    currency TEXT NOT NULL,
+    balance DECIMAL(20,4) NOT NULL CHECK (balance > 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (customer_id, currency)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_credits_org_id ON customer_credits(org_id);
+
+-- What was overpaid so far
+INSERT INTO customer_credits (org_id, customer_id, currency, balance)
+SELECT org_id, customer_id, currency, SUM(overpaid)
+FROM (
+    SELECT i.org_id, i.customer_id, COALESCE(i.currency, 'USD') AS currency,
+        SUM(p.amount - p.refunded_amount) - (i.total - i.credited_amount) AS overpaid
+    FROM invoices i JOIN payments p ON p.invoice_id = i.id AND p.voided_at IS NULL
+    GROUP BY i.id
+)
+WHERE overpaid > 0
+GROUP BY org_id, customer_id, currency;
+
+CREATE TABLE IF NOT EXISTS credit_applications (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    invoice_id TEXT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    customer_id TEXT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    currency TEXT NOT NULL,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT,
+    voided_at TIMESTAMP,
+    void_reason TEXT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_applications_invoice_id ON credit_applications(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_credit_applications_customer_id ON credit_applications(customer_id, currency);
+CREATE INDEX IF NOT EXISTS idx_credit_applications_org_id ON credit_applications(org_id, applied_at);
+
+-- Audit credit applications, under the invoice's ID
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_insert
+AFTER INSERT ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'customer_id', NEW.customer_id, 'currency', NEW.currency, 'amount', NEW.amount,
+            'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_update
+AFTER UPDATE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'customer_id', OLD.customer_id, 'currency', OLD.currency, 'amount', OLD.amount,
+            'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'customer_id', NEW.customer_id, 'currency', NEW.currency, 'amount', NEW.amount,
+            'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_delete
+AFTER DELETE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'customer_id', OLD.customer_id, 'currency', OLD.currency, 'amount', OLD.amount,
+            'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- A refund of a receipt has no invoice
+CREATE TABLE refunds_new (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id),
+    payment_id TEXT NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    invoice_id TEXT REFERENCES invoices(id) ON DELETE CASCADE,
+    amount DECIMAL(20,4) NOT NULL CHECK (amount > 0),
+    refund_method TEXT NOT NULL,
+    refund_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reference_number TEXT,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT
+);
+
+INSERT INTO refunds_new SELECT id, org_id, payment_id, invoice_id, amount, refund_method, refund_date, reference_number,
+    reason, created_at, created_by FROM refunds;
+DROP TABLE refunds;
+ALTER TABLE refunds_new RENAME TO refunds;
+
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_org_id ON refunds(org_id, refund_date);
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_insert
+AFTER INSERT ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_update
+AFTER UPDATE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', NEW.payment_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'invoice_id', NEW.invoice_id, 'amount', NEW.amount,
+            'refund_method', NEW.refund_method, 'refund_date', NEW.refund_date, 'reference_number', NEW.reference_number,
+            'reason', NEW.reason, 'created_by', NEW.created_by)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_refunds_delete
+AFTER DELETE ON refunds
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'refund', OLD.payment_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'invoice_id', OLD.invoice_id, 'amount', OLD.amount,
+            'refund_method', OLD.refund_method, 'refund_date', OLD.refund_date, 'reference_number', OLD.reference_number,
+            'reason', OLD.reason, 'created_by', OLD.created_by)) AS o
+    WHERE o.value IS NOT NULL;
+END;
+
+-- Audit who paid, and in what currency
+DROP TRIGGER IF EXISTS audit_payments_insert;
+DROP TRIGGER IF EXISTS audit_payments_update;
+DROP TRIGGER IF EXISTS audit_payments_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_insert
+AFTER INSERT ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'customer_id', NEW.customer_id, 'currency', NEW.currency,
+            'receipt_number', NEW.receipt_number, 'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by, 'voided_at', NEW.voided_at, 'void_reason', NEW.void_reason,
+            'correction_reason', NEW.correction_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_update
+AFTER UPDATE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', NEW.id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'customer_id', OLD.customer_id, 'currency', OLD.currency,
+            'receipt_number', OLD.receipt_number, 'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by, 'voided_at', OLD.voided_at, 'void_reason', OLD.void_reason,
+            'correction_reason', OLD.correction_reason)) AS o
+    JOIN json_each(json_object(
+            'invoice_id', NEW.invoice_id, 'customer_id', NEW.customer_id, 'currency', NEW.currency,
+            'receipt_number', NEW.receipt_number, 'amount', NEW.amount, 'payment_method', NEW.payment_method,
+            'payment_date', NEW.payment_date, 'reference_number', NEW.reference_number, 'notes', NEW.notes,
+            'created_by', NEW.created_by, 'voided_at', NEW.voided_at, 'void_reason', NEW.void_reason,
+            'correction_reason', NEW.correction_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_payments_delete
+AFTER DELETE ON payments
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'payment', OLD.id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'invoice_id', OLD.invoice_id, 'customer_id', OLD.customer_id, 'currency', OLD.currency,
+            'receipt_number', OLD.receipt_number, 'amount', OLD.amount, 'payment_method', OLD.payment_method,
+            'payment_date', OLD.payment_date, 'reference_number', OLD.reference_number, 'notes', OLD.notes,
+            'created_by', OLD.created_by, 'voided_at', OLD.voided_at, 'void_reason', OLD.void_reason,
+            'correction_reason', OLD.correction_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
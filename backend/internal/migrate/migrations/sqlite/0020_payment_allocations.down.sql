@@ -0,0 +1,54 @@
+-- Allocations stay as credit applications of their invoices, no longer tied
+-- to the receipts they were allocated from.
+
+DROP TRIGGER IF EXISTS audit_credit_applications_insert;
+DROP TRIGGER IF EXISTS audit_credit_applications_update;
+DROP TRIGGER IF EXISTS audit_credit_applications_delete;
+
+DROP INDEX IF EXISTS idx_credit_applications_payment_id;
+ALTER TABLE credit_applications DROP COLUMN payment_id;
+
+-- Audit triggers as of 0019_customer_credit
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_insert
+AFTER INSERT ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'customer_id', NEW.customer_id, 'currency', NEW.currency, 'amount', NEW.amount,
+            'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_update
+AFTER UPDATE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'customer_id', OLD.customer_id, 'currency', OLD.currency, 'amount', OLD.amount,
+            'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'customer_id', NEW.customer_id, 'currency', NEW.currency, 'amount', NEW.amount,
+            'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_delete
+AFTER DELETE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'customer_id', OLD.customer_id, 'currency', OLD.currency, 'amount', OLD.amount,
+            'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
@@ -0,0 +1,54 @@
+-- Payment allocations, see postgres/0020_payment_allocations.up.sql.
+
+ALTER TABLE credit_applications ADD COLUMN payment_id TEXT REFERENCES payments(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_credit_applications_payment_id ON credit_applications(payment_id);
+
+-- Audit what a credit application was allocated from
+DROP TRIGGER IF EXISTS audit_credit_applications_insert;
+DROP TRIGGER IF EXISTS audit_credit_applications_update;
+DROP TRIGGER IF EXISTS audit_credit_applications_delete;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_insert
+AFTER INSERT ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'create', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(n.key, json_object('from', NULL, 'to', n.value)), '{}')
+    FROM json_each(json_object(
+            'id', NEW.id, 'payment_id', NEW.payment_id, 'customer_id', NEW.customer_id, 'currency', NEW.currency,
+            'amount', NEW.amount, 'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n
+    WHERE n.value IS NOT NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_update
+AFTER UPDATE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', NEW.invoice_id, 'update', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        json_group_object(o.key, json_object('from', o.value, 'to', n.value))
+    FROM json_each(json_object(
+            'id', OLD.id, 'payment_id', OLD.payment_id, 'customer_id', OLD.customer_id, 'currency', OLD.currency,
+            'amount', OLD.amount, 'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    JOIN json_each(json_object(
+            'id', NEW.id, 'payment_id', NEW.payment_id, 'customer_id', NEW.customer_id, 'currency', NEW.currency,
+            'amount', NEW.amount, 'applied_at', NEW.applied_at, 'created_by', NEW.created_by, 'voided_at', NEW.voided_at,
+            'void_reason', NEW.void_reason)) AS n ON n.key = o.key
+    WHERE o.value IS NOT n.value
+    HAVING COUNT(*) > 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_credit_applications_delete
+AFTER DELETE ON credit_applications
+BEGIN
+    INSERT INTO audit_log (entity_type, entity_id, action, actor, changes)
+    SELECT 'credit_application', OLD.invoice_id, 'delete', COALESCE((SELECT actor FROM audit_actor), 'system'),
+        COALESCE(json_group_object(o.key, json_object('from', o.value, 'to', NULL)), '{}')
+    FROM json_each(json_object(
+            'id', OLD.id, 'payment_id', OLD.payment_id, 'customer_id', OLD.customer_id, 'currency', OLD.currency,
+            'amount', OLD.amount, 'applied_at', OLD.applied_at, 'created_by', OLD.created_by, 'voided_at', OLD.voided_at,
+            'void_reason', OLD.void_reason)) AS o
+    WHERE o.value IS NOT NULL;
+END;
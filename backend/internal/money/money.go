@@ -0,0 +1,281 @@
+// Package money provides an exact decimal type for monetary amounts and the
+// per-currency rounding rules used when invoices are priced, paid and printed.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places an Amount carries. Four places keep
+// sub-cent unit prices and intermediate results exact; amounts are rounded to
+// the currency's precision (see Round) where they become money owed.
+const Scale = 4
+
+const unit = 10000
+
+// Amount is an exact decimal amount stored as an integer number of
+// ten-thousandths. It encodes to JSON as a plain number (12.5) and to SQL as
+// a decimal string, and decodes from JSON numbers, decimal strings and the
+// numeric column types of Postgres and SQLite.
+type Amount int64
+
+// Zero is the zero amount
+const Zero Amount = 0
+
+// ErrOutOfRange is returned when the result of a multiplication does not fit
+// in an Amount
+var ErrOutOfRange = errors.New("amount out of range")
+
+// FromInt returns the Amount for a whole number of currency units
+func FromInt(n int64) Amount {
+	return Amount(n * unit)
+}
+
+// FromFloat converts f to an Amount, rounding to Scale decimal places. Use it
+// only at boundaries that still hand out floats.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * unit))
+}
+
+// Parse reads a decimal string such as "12.50", "-3" or "1.5e2". Digits
+// beyond Scale decimal places are rounded half away from zero.
+func Parse(s string) (Amount, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r.Mul(r, big.NewRat(unit, 1))
+	v := roundRat(r)
+	if !v.IsInt64() {
+		return 0, fmt.Errorf("amount %q out of range", s)
+	}
+	return Amount(v.Int64()), nil
+}
+
+// roundRat rounds r to the nearest integer, halves away from zero
+func roundRat(r *big.Rat) *big.Int {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	// |2m| >= den means the fraction is at least one half
+	if m.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(m, 1)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// fromRat rounds r to the nearest Amount, halves away from zero
+func fromRat(r *big.Rat) (Amount, error) {
+	v := roundRat(r)
+	if !v.IsInt64() {
+		return 0, ErrOutOfRange
+	}
+	return Amount(v.Int64()), nil
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return a + b
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return a - b
+}
+
+// Neg returns -a
+func (a Amount) Neg() Amount {
+	return -a
+}
+
+// MulInt returns a * n
+func (a Amount) MulInt(n int64) Amount {
+	return a * Amount(n)
+}
+
+// Mul returns a * b, rounded to Scale decimal places. b is usually a
+// quantity rather than money. It returns ErrOutOfRange when the product does
+// not fit in an Amount.
+func (a Amount) Mul(b Amount) (Amount, error) {
+	r := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(b))), big.NewInt(unit))
+	return fromRat(r)
+}
+
+// Prorate returns the share of a that part is of whole, a * part / whole,
+// rounded to Scale decimal places. It returns 0 for a zero whole, and
+// ErrOutOfRange when the share does not fit in an Amount.
+func (a Amount) Prorate(part, whole Amount) (Amount, error) {
+	if whole == 0 {
+		return 0, nil
+	}
+	num := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(part)))
+	return fromRat(new(big.Rat).SetFrac(num, big.NewInt(int64(whole))))
+}
+
+// Percent returns p percent of a, rounded to Scale decimal places, or
+// ErrOutOfRange when it does not fit in an Amount
+func (a Amount) Percent(p float64) (Amount, error) {
+	num := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(FromFloat(p))))
+	r := new(big.Rat).SetFrac(num, big.NewInt(unit*100))
+	return fromRat(r)
+}
+
+// MulFloat multiplies a by a non-monetary factor such as an exchange rate,
+// rounding the result to Scale decimal places
+func (a Amount) MulFloat(f float64) Amount {
+	factor := new(big.Rat)
+	if factor.SetFloat64(f) == nil {
+		return 0
+	}
+	r := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(a)), factor)
+	return Amount(roundRat(r).Int64())
+}
+
+// Cmp compares a and b and returns -1, 0 or +1
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of a
+func (a Amount) Sign() int {
+	return a.Cmp(0)
+}
+
+// IsZero reports whether a is zero
+func (a Amount) IsZero() bool {
+	return a == 0
+}
+
+// Float64 returns a as a float, for display code that needs one
+func (a Amount) Float64() float64 {
+	return float64(a) / unit
+}
+
+// Round rounds a to the number of decimal places used by currency, halves
+// away from zero
+func (a Amount) Round(currency string) Amount {
+	return a.roundTo(Decimals(currency))
+}
+
+func (a Amount) roundTo(places int) Amount {
+	if places >= Scale {
+		return a
+	}
+	factor := Amount(math.Pow10(Scale - places))
+	q, r := a/factor, a%factor
+	if r*2 >= factor {
+		q++
+	} else if r*2 <= -factor {
+		q--
+	}
+	return q * factor
+}
+
+// String formats a with as few decimal places as needed ("12.5", "3")
+func (a Amount) String() string {
+	s := a.StringFixed(Scale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// StringFixed formats a with exactly places decimal places, rounding half
+// away from zero
+func (a Amount) StringFixed(places int) string {
+	if places > Scale {
+		places = Scale
+	}
+	if places < 0 {
+		places = 0
+	}
+	v := int64(a.roundTo(places))
+
+	sign := ""
+	if v < 0 {
+		sign = "-"
+	}
+	abs := uint64(v)
+	if v < 0 {
+		abs = uint64(-v)
+	}
+
+	whole := strconv.FormatUint(abs/unit, 10)
+	if places == 0 {
+		return sign + whole
+	}
+	frac := fmt.Sprintf("%04d", abs%unit)
+	return sign + whole + "." + frac[:places]
+}
+
+// MarshalJSON encodes a as a JSON number
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number, a decimal string or null
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Scan implements sql.Scanner for NUMERIC/DECIMAL columns. NULL scans as zero.
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = FromInt(v)
+	case float64:
+		*a = FromFloat(v)
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into money.Amount", src)
+	}
+	return nil
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value implements driver.Valuer, passing the amount as a decimal string so
+// it reaches the database without a float conversion
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
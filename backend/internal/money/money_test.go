@@ -0,0 +1,287 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// amt parses s or fails the test
+func amt(t *testing.T, s string) Amount {
+	t.Helper()
+	a, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"12.50", 125000},
+		{"-3", -30000},
+		{"0", 0},
+		{" 7 ", 70000},
+		{"1.5e2", 1500000},
+		{"0.1234", 1234},
+		// Beyond four places, halves round away from zero
+		{"0.00005", 1},
+		{"0.00004999", 0},
+		{"-0.00005", -1},
+		{"-0.00004999", 0},
+		{"1.23455", 12346},
+		{"-1.23455", -12346},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", "abc", "1.2.3", "12,50", "1e20"} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     string
+	}{
+		// Two places, halves away from zero
+		{"1.005", "USD", "1.01"},
+		{"1.0049", "USD", "1"},
+		{"-1.005", "USD", "-1.01"},
+		{"-1.0049", "USD", "-1"},
+		{"0.125", "EUR", "0.13"},
+		{"2.5", "XYZ", "2.5"},
+		// Zero-decimal currencies, case-insensitive
+		{"1500.5", "IDR", "1501"},
+		{"1500.4999", "IDR", "1500"},
+		{"-0.5", "JPY", "-1"},
+		{"-0.4999", "JPY", "0"},
+		{"99.5", "jpy", "100"},
+		// Three places
+		{"1.2345", "KWD", "1.235"},
+		{"-1.2345", "BHD", "-1.235"},
+		{"1.2344", "OMR", "1.234"},
+	}
+	for _, tt := range tests {
+		if got := amt(t, tt.in).Round(tt.currency).String(); got != tt.want {
+			t.Errorf("%s.Round(%s) = %s, want %s", tt.in, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestDecimals(t *testing.T) {
+	tests := map[string]int{"USD": 2, "IDR": 0, "jpy": 0, "KWD": 3, "": 2}
+	for currency, want := range tests {
+		if got := Decimals(currency); got != want {
+			t.Errorf("Decimals(%q) = %d, want %d", currency, got, want)
+		}
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"12.5", 2, "12.50"},
+		{"12.5", 0, "13"},
+		{"-12.5", 0, "-13"},
+		{"0.005", 2, "0.01"},
+		{"-0.005", 2, "-0.01"},
+		{"-0.0049", 2, "0.00"},
+		{"1234.5678", 4, "1234.5678"},
+		{"1234.5678", 9, "1234.5678"},
+		{"1.5", -1, "2"},
+	}
+	for _, tt := range tests {
+		if got := amt(t, tt.in).StringFixed(tt.places); got != tt.want {
+			t.Errorf("%s.StringFixed(%d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := map[Amount]string{
+		FromInt(3):      "3",
+		FromFloat(12.5): "12.5",
+		-2500:           "-0.25",
+		1:               "0.0001",
+		0:               "0",
+	}
+	for a, want := range tests {
+		if got := a.String(); got != want {
+			t.Errorf("Amount(%d).String() = %s, want %s", int64(a), got, want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"33.33", "1.5", "49.995"},
+		{"0.0001", "0.5", "0.0001"},
+		{"-0.0001", "0.5", "-0.0001"},
+		{"0.0001", "0.4999", "0"},
+		{"-12.5", "2", "-25"},
+		{"1000000000000", "1000000", "out of range"},
+		{"-1000000000000", "1000000", "out of range"},
+	}
+	for _, tt := range tests {
+		got, err := amt(t, tt.a).Mul(amt(t, tt.b))
+		if err != nil {
+			if !errors.Is(err, ErrOutOfRange) || tt.want != "out of range" {
+				t.Errorf("%s.Mul(%s) error %v, want %s", tt.a, tt.b, err, tt.want)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s.Mul(%s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		a    string
+		p    float64
+		want string
+	}{
+		{"19.99", 10, "1.999"},
+		{"100", 12.5, "12.5"},
+		{"0.0005", 10, "0.0001"},
+		{"-0.0005", 10, "-0.0001"},
+		{"50", 0, "0"},
+		{"900000000000000", 200, "out of range"},
+	}
+	for _, tt := range tests {
+		got, err := amt(t, tt.a).Percent(tt.p)
+		if err != nil {
+			if !errors.Is(err, ErrOutOfRange) || tt.want != "out of range" {
+				t.Errorf("%s.Percent(%v) error %v, want %s", tt.a, tt.p, err, tt.want)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s.Percent(%v) = %s, want %s", tt.a, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestProrate(t *testing.T) {
+	tests := []struct {
+		a, part, whole string
+		want           string
+	}{
+		{"10", "1", "3", "3.3333"},
+		{"10", "2", "3", "6.6667"},
+		{"10", "3", "3", "10"},
+		{"-10", "1", "3", "-3.3333"},
+		{"-10", "2", "3", "-6.6667"},
+		{"0.0001", "1", "2", "0.0001"},
+		{"-0.0001", "1", "2", "-0.0001"},
+		{"10", "1", "0", "0"},
+		{"900000000000000", "2", "1", "out of range"},
+	}
+	for _, tt := range tests {
+		got, err := amt(t, tt.a).Prorate(amt(t, tt.part), amt(t, tt.whole))
+		if err != nil {
+			if !errors.Is(err, ErrOutOfRange) || tt.want != "out of range" {
+				t.Errorf("%s.Prorate(%s, %s) error %v, want %s", tt.a, tt.part, tt.whole, err, tt.want)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s.Prorate(%s, %s) = %s, want %s", tt.a, tt.part, tt.whole, got, tt.want)
+		}
+	}
+}
+
+func TestMoney(t *testing.T) {
+	a := New(amt(t, "10.005"), "USD")
+	if got := a.String(); got != "10.01 USD" {
+		t.Errorf("New(10.005, USD) = %s, want 10.01 USD", got)
+	}
+	if got := a.Add(New(amt(t, "0.004"), "USD")).String(); got != "10.01 USD" {
+		t.Errorf("Add = %s, want 10.01 USD", got)
+	}
+	if got := a.Sub(New(amt(t, "20"), "USD")).String(); got != "-9.99 USD" {
+		t.Errorf("Sub = %s, want -9.99 USD", got)
+	}
+	if got := New(amt(t, "1.5"), "USD").Convert("IDR", 15500.33).String(); got != "23250 IDR" {
+		t.Errorf("Convert = %s, want 23250 IDR", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+		C Amount `json:"c"`
+	}
+	v.C = 5
+	if err := json.Unmarshal([]byte(`{"a": 12.345, "b": "-0.5", "c": null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 123450 || v.B != -5000 || v.C != 5 {
+		t.Errorf("decoded %d, %d, %d; want 123450, -5000, 5", v.A, v.B, v.C)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != `{"a":12.345,"b":-0.5,"c":0.0005}` {
+		t.Errorf("encoded %s", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a": "ten"}`), &v); err == nil {
+		t.Error("decoding a non-number succeeded")
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Amount
+	}{
+		{nil, 0},
+		{int64(7), 70000},
+		{1.25, 12500},
+		{[]byte("3.1415"), 31415},
+		{"-2.50", -25000},
+	}
+	for _, tt := range tests {
+		a := Amount(99)
+		if err := a.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v): %v", tt.src, err)
+			continue
+		}
+		if a != tt.want {
+			t.Errorf("Scan(%v) = %d, want %d", tt.src, a, tt.want)
+		}
+	}
+	var a Amount
+	if err := a.Scan(true); err == nil {
+		t.Error("Scan(bool) succeeded")
+	}
+}
@@ -0,0 +1,358 @@
+// Package pricing prices the lines of an invoice and totals the invoice from
+// them, so every codepath that creates an invoice computes the same amounts.
+//
+// A line's total is quantity x unit price less the line discount, rounded to
+// the invoice currency. Quantities are decimals with up to four places, so
+// lines can bill 7.5 hours or 2.35 kg; a quantity of pieces must be whole. The subtotal is the sum of the line totals. Tax is
+// charged per rate on the lines taxed at that rate, each line at its own
+// rate or, without one, at the invoice's. The invoice discount comes off the
+// total after tax.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/money"
+)
+
+// Units of measure with a fixed meaning. Any other unit is accepted as a
+// custom unit and printed as given.
+const (
+	UnitHour  = "hour"
+	UnitDay   = "day"
+	UnitKg    = "kg"
+	UnitPiece = "pcs"
+)
+
+// maxUnitLength bounds the length of a custom unit
+const maxUnitLength = 20
+
+// unitAliases maps common spellings of the fixed units to the unit
+var unitAliases = map[string]string{
+	"h": UnitHour, "hr": UnitHour, "hrs": UnitHour, "hour": UnitHour, "hours": UnitHour,
+	"d": UnitDay, "day": UnitDay, "days": UnitDay,
+	"kg": UnitKg, "kgs": UnitKg, "kilogram": UnitKg, "kilograms": UnitKg,
+	"pc": UnitPiece, "pcs": UnitPiece, "piece": UnitPiece, "pieces": UnitPiece,
+}
+
+// NormalizeUnit returns the fixed unit that unit spells, such as "hour" for
+// "Hours", or unit itself, trimmed, for a custom unit
+func NormalizeUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if fixed, ok := unitAliases[strings.ToLower(unit)]; ok {
+		return fixed
+	}
+	return unit
+}
+
+// ErrInvalid is returned for lines that cannot be priced, such as a line
+// without a description or with a discount larger than its amount
+var ErrInvalid = errors.New("cannot price invoice")
+
+// TaxLine is the tax charged at one rate
+type TaxLine struct {
+	Rate   float64      `json:"rate"` // Tax percentage
+	Amount money.Amount `json:"amount"`
+}
+
+// Totals are the amounts of a priced invoice
+type Totals struct {
+	Subtotal money.Amount
+	Tax      money.Amount
+	Discount money.Amount
+	Total    money.Amount
+	// Taxes breaks Tax down per rate, lowest rate first
+	Taxes []TaxLine
+}
+
+// Price numbers items from 1, sets each line's total and returns the totals
+// of an invoice in currency with the given tax percentage and discount
+func Price(items []db.Item, tax float64, discount money.Amount, currency string) (*Totals, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: at least one item is required", ErrInvalid)
+	}
+	if err := checkRate(tax); err != nil {
+		return nil, fmt.Errorf("%w: tax %v", ErrInvalid, err)
+	}
+	if discount.Sign() < 0 {
+		return nil, fmt.Errorf("%w: discount cannot be negative", ErrInvalid)
+	}
+
+	totals := &Totals{Discount: discount.Round(currency)}
+	for i := range items {
+		item := &items[i]
+		item.Position = i + 1
+		if err := priceLine(item, currency); err != nil {
+			return nil, fmt.Errorf("%w: item %d: %v", ErrInvalid, item.Position, err)
+		}
+		totals.Subtotal = totals.Subtotal.Add(item.Total)
+	}
+
+	taxes, err := Taxes(items, tax, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tax %v", ErrInvalid, err)
+	}
+	totals.Taxes = taxes
+	for _, t := range totals.Taxes {
+		totals.Tax = totals.Tax.Add(t.Amount)
+	}
+	totals.Total = totals.Subtotal.Add(totals.Tax).Sub(totals.Discount)
+	return totals, nil
+}
+
+// Taxes returns the tax charged on priced items per rate, lowest rate first.
+// Lines without a rate of their own are taxed at the invoice's tax
+// percentage.
+func Taxes(items []db.Item, tax float64, currency string) ([]TaxLine, error) {
+	taxable := make(map[float64]money.Amount)
+	for _, item := range items {
+		rate := tax
+		if item.TaxRate != nil {
+			rate = *item.TaxRate
+		}
+		taxable[rate] = taxable[rate].Add(item.Total)
+	}
+
+	rates := make([]float64, 0, len(taxable))
+	for rate := range taxable {
+		rates = append(rates, rate)
+	}
+	sort.Float64s(rates)
+
+	taxes := make([]TaxLine, len(rates))
+	for i, rate := range rates {
+		amount, err := taxable[rate].Percent(rate)
+		if err != nil {
+			return nil, err
+		}
+		taxes[i] = TaxLine{Rate: rate, Amount: amount.Round(currency)}
+	}
+	return taxes, nil
+}
+
+// priceLine checks a line and sets its total
+func priceLine(item *db.Item, currency string) error {
+	item.Unit = NormalizeUnit(item.Unit)
+	switch {
+	case item.Description == "":
+		return errors.New("description is required")
+	case item.Quantity.Sign() <= 0:
+		return errors.New("quantity must be positive")
+	case item.Unit == UnitPiece && item.Quantity%money.FromInt(1) != 0:
+		return errors.New("quantity in pcs must be a whole number")
+	case len(item.Unit) > maxUnitLength:
+		return fmt.Errorf("unit cannot be longer than %d characters", maxUnitLength)
+	case item.UnitPrice.Sign() < 0:
+		return errors.New("unit_price cannot be negative")
+	case item.Discount.Sign() < 0:
+		return errors.New("discount cannot be negative")
+	}
+	if item.TaxRate != nil {
+		if err := checkRate(*item.TaxRate); err != nil {
+			return fmt.Errorf("tax_rate %v", err)
+		}
+	}
+
+	amount, err := item.UnitPrice.Mul(item.Quantity)
+	if err != nil {
+		return errors.New("quantity x unit_price is out of range")
+	}
+	item.Discount = item.Discount.Round(currency)
+	if item.Discount.Cmp(amount) > 0 {
+		return errors.New("discount exceeds the line amount")
+	}
+	item.Total = amount.Sub(item.Discount).Round(currency)
+	return nil
+}
+
+// Catalog fills the lines that reference a catalog product from the product,
+// before they are priced. lookup returns the product with an ID, or nil if
+// there is none. A line takes the product's SKU and its price in currency
+// and, unless the line gives its own, the product's name and description,
+// unit and tax rate. Inactive products cannot be invoiced.
+//
+// list is the customer's price list, or nil. When it is in currency and has
+// a rule for the product at the line's quantity, the rule sets the unit
+// price and is described in the line's PriceRule. A product without a price
+// in currency can only be invoiced at a fixed price from the list.
+func Catalog(items []db.Item, currency string, list *db.PriceList, lookup func(id string) (*db.Product, error)) error {
+	if list != nil && !strings.EqualFold(list.Currency, currency) {
+		list = nil
+	}
+	for i := range items {
+		item := &items[i]
+		item.PriceRule = ""
+		if item.ProductID == "" {
+			continue
+		}
+		product, err := lookup(item.ProductID)
+		if err != nil {
+			return err
+		}
+		if product == nil {
+			return fmt.Errorf("%w: item %d: product %s not found", ErrInvalid, i+1, item.ProductID)
+		}
+		if !product.Active {
+			return fmt.Errorf("%w: item %d: product %s is inactive", ErrInvalid, i+1, product.SKU)
+		}
+
+		item.SKU = product.SKU
+		if item.Description == "" {
+			item.Description = product.Name
+			if product.Description != "" {
+				item.Description += " - " + product.Description
+			}
+		}
+		if item.Unit == "" {
+			item.Unit = product.Unit
+		}
+		if item.TaxRate == nil && product.TaxRate != nil {
+			rate := *product.TaxRate
+			item.TaxRate = &rate
+		}
+
+		price, ok := product.Price(currency)
+		var rule *db.PriceRule
+		if list != nil {
+			rule = list.Rule(product.ID, item.Quantity)
+		}
+		switch {
+		case rule != nil && rule.UnitPrice != nil:
+			price, ok = *rule.UnitPrice, true
+			item.PriceRule = describeRule(list, rule, NormalizeUnit(item.Unit), currency)
+		case rule != nil && ok:
+			off, err := price.Percent(*rule.DiscountPercent)
+			if err != nil {
+				return fmt.Errorf("%w: item %d: %v", ErrInvalid, i+1, err)
+			}
+			price = price.Sub(off)
+			item.PriceRule = describeRule(list, rule, NormalizeUnit(item.Unit), currency)
+		}
+		if !ok {
+			return fmt.Errorf("%w: item %d: product %s has no %s price", ErrInvalid, i+1, product.SKU, currency)
+		}
+		item.UnitPrice = price
+	}
+	return nil
+}
+
+// describeRule describes a price list rule for the line it priced, such as
+// "Reseller: from 10 hour, 15% off"
+func describeRule(list *db.PriceList, rule *db.PriceRule, unit, currency string) string {
+	var parts []string
+	if rule.MinQuantity.Sign() > 0 {
+		from := "from " + rule.MinQuantity.String()
+		if unit != "" {
+			from += " " + unit
+		}
+		parts = append(parts, from)
+	}
+	if rule.UnitPrice != nil {
+		parts = append(parts, rule.UnitPrice.StringFixed(money.Decimals(currency))+" "+strings.ToUpper(currency))
+	} else {
+		parts = append(parts, strconv.FormatFloat(*rule.DiscountPercent, 'f', -1, 64)+"% off")
+	}
+	return list.Name + ": " + strings.Join(parts, ", ")
+}
+
+// ErrInvalidProduct is returned for a catalog product that cannot be saved
+var ErrInvalidProduct = errors.New("invalid product")
+
+// CheckProduct checks a catalog product before it is saved. It trims the SKU
+// and name, normalizes the unit and upper-cases the price currencies.
+func CheckProduct(product *db.Product) error {
+	product.SKU = strings.TrimSpace(product.SKU)
+	product.Name = strings.TrimSpace(product.Name)
+	product.Unit = NormalizeUnit(product.Unit)
+	switch {
+	case product.SKU == "":
+		return fmt.Errorf("%w: sku is required", ErrInvalidProduct)
+	case product.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidProduct)
+	case len(product.Unit) > maxUnitLength:
+		return fmt.Errorf("%w: unit cannot be longer than %d characters", ErrInvalidProduct, maxUnitLength)
+	}
+	if product.TaxRate != nil {
+		if err := checkRate(*product.TaxRate); err != nil {
+			return fmt.Errorf("%w: tax_rate %v", ErrInvalidProduct, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(product.Prices))
+	for i := range product.Prices {
+		price := &product.Prices[i]
+		price.Currency = strings.ToUpper(strings.TrimSpace(price.Currency))
+		switch {
+		case len(price.Currency) != 3:
+			return fmt.Errorf("%w: price %d: currency must be a three-letter code", ErrInvalidProduct, i+1)
+		case seen[price.Currency]:
+			return fmt.Errorf("%w: more than one %s price", ErrInvalidProduct, price.Currency)
+		case price.UnitPrice.Sign() < 0:
+			return fmt.Errorf("%w: %s unit_price cannot be negative", ErrInvalidProduct, price.Currency)
+		}
+		seen[price.Currency] = true
+	}
+	return nil
+}
+
+// checkRate checks a tax percentage
+func checkRate(rate float64) error {
+	if rate < 0 || rate > 100 {
+		return errors.New("must be between 0 and 100")
+	}
+	return nil
+}
+
+// ErrInvalidPriceList is returned for a price list that cannot be saved
+var ErrInvalidPriceList = errors.New("invalid price list")
+
+// CheckPriceList checks a price list before it is saved. It trims the name
+// and upper-cases the currency. Every rule needs a product and either a unit
+// price or a discount percentage, and a product can have one rule per
+// minimum quantity.
+func CheckPriceList(list *db.PriceList) error {
+	list.Name = strings.TrimSpace(list.Name)
+	list.Currency = strings.ToUpper(strings.TrimSpace(list.Currency))
+	switch {
+	case list.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidPriceList)
+	case len(list.Currency) != 3:
+		return fmt.Errorf("%w: currency must be a three-letter code", ErrInvalidPriceList)
+	}
+
+	type key struct {
+		product     string
+		minQuantity money.Amount
+	}
+	seen := make(map[key]bool, len(list.Rules))
+	for i := range list.Rules {
+		rule := &list.Rules[i]
+		rule.ProductID = strings.TrimSpace(rule.ProductID)
+		switch {
+		case rule.ProductID == "":
+			return fmt.Errorf("%w: rule %d: product_id is required", ErrInvalidPriceList, i+1)
+		case rule.MinQuantity.Sign() < 0:
+			return fmt.Errorf("%w: rule %d: min_quantity cannot be negative", ErrInvalidPriceList, i+1)
+		case (rule.UnitPrice == nil) == (rule.DiscountPercent == nil):
+			return fmt.Errorf("%w: rule %d: give either unit_price or discount_percent", ErrInvalidPriceList, i+1)
+		case rule.UnitPrice != nil && rule.UnitPrice.Sign() < 0:
+			return fmt.Errorf("%w: rule %d: unit_price cannot be negative", ErrInvalidPriceList, i+1)
+		}
+		if rule.DiscountPercent != nil {
+			if err := checkRate(*rule.DiscountPercent); err != nil {
+				return fmt.Errorf("%w: rule %d: discount_percent %v", ErrInvalidPriceList, i+1, err)
+			}
+		}
+		k := key{rule.ProductID, rule.MinQuantity}
+		if seen[k] {
+			return fmt.Errorf("%w: rule %d: more than one rule for product %s from quantity %s", ErrInvalidPriceList, i+1, rule.ProductID, rule.MinQuantity)
+		}
+		seen[k] = true
+	}
+	return nil
+}
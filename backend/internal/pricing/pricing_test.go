@@ -0,0 +1,289 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/money"
+)
+
+// amt parses s or fails the test
+func amt(t *testing.T, s string) money.Amount {
+	t.Helper()
+	a, err := money.Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+func rate(r float64) *float64 {
+	return &r
+}
+
+func TestPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    []db.Item
+		tax      float64
+		discount string
+		currency string
+		// line totals, then subtotal, tax and total
+		lines                     []string
+		subtotal, taxTotal, total string
+	}{
+		{
+			name:     "line total rounds half up",
+			items:    []db.Item{{Description: "Consulting", Quantity: money.FromFloat(1.5), Unit: "hours", UnitPrice: money.FromFloat(33.33)}},
+			tax:      10,
+			currency: "USD",
+			lines:    []string{"50"},
+			subtotal: "50", taxTotal: "5", total: "55",
+		},
+		{
+			name: "line discount and per-line tax rates",
+			items: []db.Item{
+				{Description: "A", Quantity: money.FromInt(2), UnitPrice: money.FromFloat(10.005), Discount: money.FromFloat(0.015)},
+				{Description: "B", Quantity: money.FromInt(1), UnitPrice: money.FromInt(100), TaxRate: rate(0)},
+			},
+			tax:      11,
+			discount: "1.005",
+			currency: "USD",
+			lines:    []string{"19.99", "100"},
+			subtotal: "119.99", taxTotal: "2.2", total: "121.18",
+		},
+		{
+			name:     "zero-decimal currency",
+			items:    []db.Item{{Description: "Kopi", Quantity: money.FromInt(3), Unit: "pcs", UnitPrice: money.FromFloat(333.5)}},
+			tax:      11,
+			discount: "0.5",
+			currency: "IDR",
+			lines:    []string{"1001"},
+			subtotal: "1001", taxTotal: "110", total: "1110",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var discount money.Amount
+			if tt.discount != "" {
+				discount = amt(t, tt.discount)
+			}
+			totals, err := Price(tt.items, tt.tax, discount, tt.currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.lines {
+				if got := tt.items[i].Total.String(); got != want {
+					t.Errorf("line %d total = %s, want %s", i+1, got, want)
+				}
+				if tt.items[i].Position != i+1 {
+					t.Errorf("line %d position = %d", i+1, tt.items[i].Position)
+				}
+			}
+			if got := totals.Subtotal.String(); got != tt.subtotal {
+				t.Errorf("subtotal = %s, want %s", got, tt.subtotal)
+			}
+			if got := totals.Tax.String(); got != tt.taxTotal {
+				t.Errorf("tax = %s, want %s", got, tt.taxTotal)
+			}
+			if got := totals.Total.String(); got != tt.total {
+				t.Errorf("total = %s, want %s", got, tt.total)
+			}
+		})
+	}
+}
+
+func TestPriceInvalid(t *testing.T) {
+	line := func(f func(*db.Item)) []db.Item {
+		item := db.Item{Description: "A", Quantity: money.FromInt(1), UnitPrice: money.FromInt(10)}
+		f(&item)
+		return []db.Item{item}
+	}
+	tests := []struct {
+		name     string
+		items    []db.Item
+		tax      float64
+		discount money.Amount
+	}{
+		{"no items", nil, 0, 0},
+		{"tax above 100", line(func(*db.Item) {}), 101, 0},
+		{"negative discount", line(func(*db.Item) {}), 0, money.FromInt(-1)},
+		{"no description", line(func(i *db.Item) { i.Description = "" }), 0, 0},
+		{"zero quantity", line(func(i *db.Item) { i.Quantity = 0 }), 0, 0},
+		{"negative quantity", line(func(i *db.Item) { i.Quantity = money.FromInt(-1) }), 0, 0},
+		{"fractional pieces", line(func(i *db.Item) { i.Unit = "pieces"; i.Quantity = money.FromFloat(1.5) }), 0, 0},
+		{"negative unit price", line(func(i *db.Item) { i.UnitPrice = money.FromInt(-10) }), 0, 0},
+		{"negative line discount", line(func(i *db.Item) { i.Discount = money.FromInt(-1) }), 0, 0},
+		{"line discount above amount", line(func(i *db.Item) { i.Discount = money.FromFloat(10.01) }), 0, 0},
+		{"negative line tax rate", line(func(i *db.Item) { i.TaxRate = rate(-1) }), 0, 0},
+		{"amount out of range", line(func(i *db.Item) { i.Quantity = money.FromInt(1000000000); i.UnitPrice = money.FromInt(1000000000) }), 0, 0},
+	}
+	for _, tt := range tests {
+		if _, err := Price(tt.items, tt.tax, tt.discount, "USD"); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestTaxes(t *testing.T) {
+	items := []db.Item{
+		{Total: money.FromFloat(10.05)},
+		{Total: money.FromFloat(0.05), TaxRate: rate(10)},
+		{Total: money.FromInt(20), TaxRate: rate(0)},
+		{Total: money.FromFloat(0.05)},
+	}
+	taxes, err := Taxes(items, 10, "USD")
+	if err != nil {
+		t.Fatalf("Taxes: %v", err)
+	}
+	want := []TaxLine{{Rate: 0, Amount: 0}, {Rate: 10, Amount: money.FromFloat(1.02)}}
+	if len(taxes) != len(want) {
+		t.Fatalf("taxes = %v, want %v", taxes, want)
+	}
+	for i := range want {
+		if taxes[i] != want[i] {
+			t.Errorf("taxes[%d] = %v, want %v", i, taxes[i], want[i])
+		}
+	}
+}
+
+func TestNormalizeUnit(t *testing.T) {
+	tests := map[string]string{
+		"Hours":   UnitHour,
+		" hr ":    UnitHour,
+		"DAYS":    UnitDay,
+		"kgs":     UnitKg,
+		"Piece":   UnitPiece,
+		" Box ":   "Box",
+		"":        "",
+		"m²":      "m²",
+		"licence": "licence",
+	}
+	for in, want := range tests {
+		if got := NormalizeUnit(in); got != want {
+			t.Errorf("NormalizeUnit(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// creditInvoice is an issued invoice with one line of quantity 3 that totals
+// total in currency, without tax
+func creditInvoice(t *testing.T, total, currency string) *db.Invoice {
+	return &db.Invoice{
+		ID:       "inv",
+		Currency: currency,
+		Total:    amt(t, total),
+		Items:    []db.Item{{ID: "line", Description: "Hosting", Quantity: money.FromInt(3), Total: amt(t, total)}},
+	}
+}
+
+// TestCreditRemainder credits a line a third at a time. Each share is
+// prorated and rounded, and the last takes what is left of the line, so the
+// credits always add up to exactly the line total; when rounding up has
+// already credited everything, nothing is left for the last.
+func TestCreditRemainder(t *testing.T) {
+	tests := []struct {
+		total, currency string
+		want            []string
+	}{
+		{"10", "USD", []string{"3.33", "3.33", "3.34"}},
+		{"100", "IDR", []string{"33", "33", "34"}},
+		{"0.02", "USD", []string{"0.01", "0.01", "0"}},
+		{"1", "KWD", []string{"0.333", "0.333", "0.334"}},
+	}
+	for _, tt := range tests {
+		inv := creditInvoice(t, tt.total, tt.currency)
+		var notes []db.CreditNote
+		var credited money.Amount
+		for i, want := range tt.want {
+			note, err := Credit(inv, notes, []CreditLine{{InvoiceItemID: "line", Quantity: money.FromInt(1)}})
+			if want == "0" {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("%s %s: credit %d: err = %v, want ErrInvalid once nothing is left", tt.total, tt.currency, i+1, err)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s %s: credit %d: %v", tt.total, tt.currency, i+1, err)
+			}
+			if got := note.Total.String(); got != want {
+				t.Errorf("%s %s: credit %d = %s, want %s", tt.total, tt.currency, i+1, got, want)
+			}
+			notes = append(notes, *note)
+			inv.CreditedAmount = inv.CreditedAmount.Add(note.Total)
+			credited = credited.Add(note.Total)
+		}
+		if credited != inv.Total {
+			t.Errorf("%s %s: credits add up to %s", tt.total, tt.currency, credited)
+		}
+	}
+}
+
+// TestCreditProrate checks that a quantity credits its share of the line
+// total, never more than what is left
+func TestCreditProrate(t *testing.T) {
+	tests := []struct {
+		quantity string
+		want     string
+	}{
+		{"1", "3.33"},
+		{"1.5", "5"},
+		{"2", "6.67"},
+		{"3", "10"},
+		{"0.0001", "0"},
+	}
+	for _, tt := range tests {
+		note, err := Credit(creditInvoice(t, "10", "USD"), nil, []CreditLine{{InvoiceItemID: "line", Quantity: amt(t, tt.quantity)}})
+		if tt.want == "0" {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("quantity %s: err = %v, want ErrInvalid", tt.quantity, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("quantity %s: %v", tt.quantity, err)
+			continue
+		}
+		if got := note.Total.String(); got != tt.want {
+			t.Errorf("quantity %s: credited %s, want %s", tt.quantity, got, tt.want)
+		}
+	}
+}
+
+func TestCreditFull(t *testing.T) {
+	inv := creditInvoice(t, "10", "USD")
+	inv.Tax = 10
+	inv.Total = amt(t, "11")
+	inv.PaidAmount = amt(t, "4")
+
+	note, err := Credit(inv, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 and 1 tax, capped at the 7 still owed
+	if note.Subtotal != money.FromInt(10) || note.Tax != money.FromInt(1) || note.Discount != money.FromInt(4) || note.Total != money.FromInt(7) {
+		t.Errorf("note = subtotal %s, tax %s, discount %s, total %s", note.Subtotal, note.Tax, note.Discount, note.Total)
+	}
+}
+
+func TestCreditInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []CreditLine
+	}{
+		{"unknown line", []CreditLine{{InvoiceItemID: "other", Quantity: money.FromInt(1)}}},
+		{"no line id", []CreditLine{{Quantity: money.FromInt(1)}}},
+		{"line twice", []CreditLine{{InvoiceItemID: "line", Quantity: money.FromInt(1)}, {InvoiceItemID: "line", Quantity: money.FromInt(1)}}},
+		{"quantity and amount", []CreditLine{{InvoiceItemID: "line", Quantity: money.FromInt(1), Amount: money.FromInt(1)}}},
+		{"neither", []CreditLine{{InvoiceItemID: "line"}}},
+		{"negative amount", []CreditLine{{InvoiceItemID: "line", Amount: money.FromInt(-1)}}},
+		{"more than the quantity", []CreditLine{{InvoiceItemID: "line", Quantity: money.FromInt(4)}}},
+		{"more than the total", []CreditLine{{InvoiceItemID: "line", Amount: money.FromFloat(10.01)}}},
+	}
+	for _, tt := range tests {
+		if _, err := Credit(creditInvoice(t, "10", "USD"), nil, tt.lines); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
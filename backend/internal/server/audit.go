@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/tenant"
+
+	"github.com/gorilla/mux"
+)
+
+// anonymousActor is recorded for requests without a verified token
+const anonymousActor = "anonymous"
+
+// actor returns who made a request: the subject of its verified token, as
+// the tenant middleware resolved it
+func actor(r *http.Request) string {
+	if a := strings.TrimSpace(tenant.Actor(r)); a != "" {
+		return a
+	}
+	return anonymousActor
+}
+
+// store returns the database for a request that changes data, scoped to the
+// request's organization, so the audit log attributes the changes to the
+// request's actor
+func (s *Server) store(r *http.Request) db.Store {
+	return s.tenant(r).WithActor(actor(r))
+}
+
+// history returns a handler for GET /{entities}/{id}/history, listing the
+// audit log entries of one entity, oldest first. The entries of every given
+// entity type recorded under the ID are included, such as the changes to an
+// invoice's lines with the invoice's own. Entries outlive the entity, so the
+// history of a deleted record can still be read.
+func (s *Server) history(entityTypes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.db == nil {
+			http.Error(w, "Database not configured", http.StatusInternalServerError)
+			return
+		}
+
+		entries, err := s.tenant(r).GetHistory(mux.Vars(r)["id"], entityTypes...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/invoice"
+
+	"github.com/gorilla/mux"
+)
+
+// companyFields are the fields of a company profile a client writes
+type companyFields struct {
+	Name              string `json:"name"`
+	LegalName         string `json:"legal_name,omitempty"`
+	TaxID             string `json:"tax_id,omitempty"`
+	Address           string `json:"address,omitempty"`
+	City              string `json:"city,omitempty"`
+	PostalCode        string `json:"postal_code,omitempty"`
+	Country           string `json:"country,omitempty"`
+	Email             string `json:"email,omitempty"`
+	Phone             string `json:"phone,omitempty"`
+	Website           string `json:"website,omitempty"`
+	BankName          string `json:"bank_name,omitempty"`
+	BankAccountName   string `json:"bank_account_name,omitempty"`
+	BankAccountNumber string `json:"bank_account_number,omitempty"`
+	BankSwift         string `json:"bank_swift,omitempty"`
+	Logo              string `json:"logo,omitempty"` // PNG or JPEG data URL
+	IsDefault         bool   `json:"is_default,omitempty"`
+}
+
+// profile returns the company profile the fields describe, trimmed and
+// checked
+func (f companyFields) profile() (db.CompanyProfile, error) {
+	profile := db.CompanyProfile{
+		Name:              strings.TrimSpace(f.Name),
+		LegalName:         strings.TrimSpace(f.LegalName),
+		TaxID:             strings.TrimSpace(f.TaxID),
+		Address:           strings.TrimSpace(f.Address),
+		City:              strings.TrimSpace(f.City),
+		PostalCode:        strings.TrimSpace(f.PostalCode),
+		Country:           strings.TrimSpace(f.Country),
+		Email:             strings.TrimSpace(f.Email),
+		Phone:             strings.TrimSpace(f.Phone),
+		Website:           strings.TrimSpace(f.Website),
+		BankName:          strings.TrimSpace(f.BankName),
+		BankAccountName:   strings.TrimSpace(f.BankAccountName),
+		BankAccountNumber: strings.TrimSpace(f.BankAccountNumber),
+		BankSwift:         strings.TrimSpace(f.BankSwift),
+		Logo:              strings.TrimSpace(f.Logo),
+		IsDefault:         f.IsDefault,
+	}
+	return profile, profile.Validate()
+}
+
+// listCompanyProfiles handles GET /company-profiles, default profile first
+func (s *Server) listCompanyProfiles(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	profiles, err := s.tenant(r).ListCompanyProfiles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+// createCompanyProfile handles POST /company-profiles. invoice_prefix starts
+// the numbers of the company's invoices; the series it creates can be
+// changed under /numbering/series like any other.
+func (s *Server) createCompanyProfile(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		companyFields
+		InvoicePrefix string `json:"invoice_prefix"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := req.profile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix := strings.TrimSpace(req.InvoicePrefix)
+	if prefix == "" || len(prefix) > 20 {
+		http.Error(w, "invoice_prefix is required, up to 20 characters", http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.store(r).CreateCompanyProfile(profile, prefix)
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	setETag(w, created.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getCompanyProfile handles GET /company-profiles/{id}
+func (s *Server) getCompanyProfile(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := s.tenant(r).GetCompanyProfile(mux.Vars(r)["id"])
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	setETag(w, profile.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// updateCompanyProfile handles PUT /company-profiles/{id}, replacing every
+// field of the profile. is_default true makes the profile the default; the
+// default cannot be unset, only given to another profile. With If-Match the
+// update only applies to the version named by the ETag.
+func (s *Server) updateCompanyProfile(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Company profile")
+		return
+	}
+
+	var req companyFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := req.profile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.store(r).UpdateCompanyProfile(id, version, profile)
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	setETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteCompanyProfile handles DELETE /company-profiles/{id}. Only a
+// profile that is not the default and never issued an invoice can be
+// deleted. With If-Match the profile is only deleted at the version named by
+// the ETag.
+func (s *Server) deleteCompanyProfile(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Company profile")
+		return
+	}
+
+	if err := s.store(r).DeleteCompanyProfile(mux.Vars(r)["id"], version); err != nil {
+		companyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// companyError answers a failed company profile request
+func companyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Company profile not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Company profile")
+	case errors.Is(err, db.ErrPrefixTaken), errors.Is(err, db.ErrDefaultCompany), errors.Is(err, db.ErrCompanyHasInvoices):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// pdfCompany returns the details of a company profile printed on its
+// invoices. A logo that cannot be decoded is left out.
+func pdfCompany(p *db.CompanyProfile) invoice.Company {
+	logo, logoType, _ := p.LogoImage()
+	return invoice.Company{
+		Name:              p.Name,
+		LegalName:         p.LegalName,
+		TaxID:             p.TaxID,
+		Address:           p.Address,
+		City:              p.City,
+		PostalCode:        p.PostalCode,
+		Country:           p.Country,
+		Email:             p.Email,
+		Phone:             p.Phone,
+		Website:           p.Website,
+		BankName:          p.BankName,
+		BankAccountName:   p.BankAccountName,
+		BankAccountNumber: p.BankAccountNumber,
+		BankSwift:         p.BankSwift,
+		Logo:              logo,
+		LogoType:          logoType,
+	}
+}
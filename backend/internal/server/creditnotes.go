@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/invoice"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/pricing"
+
+	"github.com/gorilla/mux"
+)
+
+// createCreditNote handles POST /invoices/{id}/credit-notes. The body gives
+// the reason and, for a partial credit, the lines to credit by quantity or
+// amount:
+//
+//	{"reason": "2 licences returned",
+//	 "items": [{"invoice_item_id": "...", "quantity": 2}]}
+//
+// Crediting the whole balance of the invoice takes "full": true instead of
+// items; a body with neither is refused, as are fields the request does not
+// have. With If-Match the invoice is only credited at the version named by
+// the ETag.
+func (s *Server) createCreditNote(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Invoice")
+		return
+	}
+
+	var req struct {
+		Reason string               `json:"reason"`
+		Items  []pricing.CreditLine `json:"items,omitempty"`
+		Full   bool                 `json:"full,omitempty"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+strings.TrimPrefix(err.Error(), "json: "), http.StatusBadRequest)
+		return
+	}
+	if msg := creditScope(req.Full, req.Items); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		inv, err := s.tenant(r).GetInvoice(id)
+		if err != nil {
+			creditNoteError(w, err)
+			return
+		}
+		if version != 0 && inv.Version != version {
+			preconditionFailed(w, "Invoice")
+			return
+		}
+		if err := lifecycle.Creditable(inv.Status); err != nil {
+			creditNoteError(w, err)
+			return
+		}
+
+		// Earlier credit notes tell what is left of each line
+		notes, err := s.tenant(r).GetCreditNotesByInvoice(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		note, err := pricing.Credit(inv, notes, req.Items)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		note.Reason = req.Reason
+		note.CreatedBy = actor(r)
+
+		// Credit the version the note was priced from, so a payment or
+		// credit made in between is not credited twice
+		created, err := s.store(r).CreateCreditNote(inv.Version, *note)
+		if errors.Is(err, db.ErrVersionConflict) && version == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			creditNoteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+		return
+	}
+}
+
+// getInvoiceCreditNotes handles GET /invoices/{id}/credit-notes
+func (s *Server) getInvoiceCreditNotes(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	notes, err := s.tenant(r).GetCreditNotesByInvoice(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// listCreditNotes handles GET /credit-notes
+func (s *Server) listCreditNotes(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.CreditNoteFilterFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notes, err := s.tenant(r).ListCreditNotes(where, page)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writePage(w, r, notes)
+}
+
+// getCreditNote handles GET /credit-notes/{id}
+func (s *Server) getCreditNote(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	note, err := s.tenant(r).GetCreditNote(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Credit note not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
+
+// getCreditNotePDF handles GET /credit-notes/{id}/pdf
+func (s *Server) getCreditNotePDF(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	store := s.tenant(r)
+	note, err := store.GetCreditNote(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Credit note not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The credited invoice gives the company and customer printed on it
+	inv, err := store.GetInvoice(note.InvoiceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	customer, err := store.GetCustomer(inv.CustomerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	company, err := store.GetCompanyProfile(inv.CompanyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pdfBytes, err := generateCreditNotePDF(note, inv, company, customer)
+	if err != nil {
+		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=credit-note-%s.pdf", note.CreditNoteNumber))
+	w.Write(pdfBytes)
+}
+
+// creditScope checks that a credit note request either credits the whole
+// balance or names the lines to credit, and returns why not
+func creditScope(full bool, items []pricing.CreditLine) string {
+	switch {
+	case full && len(items) > 0:
+		return "full and items are exclusive"
+	case !full && len(items) == 0:
+		return `items required; send "full": true to credit the whole balance`
+	}
+	return ""
+}
+
+// creditNoteError answers a failed credit note write
+func creditNoteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Invoice")
+	case errors.Is(err, lifecycle.ErrNotAllowed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// generateCreditNotePDF prints a credit note of inv
+func generateCreditNotePDF(note *db.CreditNote, inv *db.Invoice, company *db.CompanyProfile, customer *db.Customer) ([]byte, error) {
+	items := make([]invoice.Item, len(note.Items))
+	taxed := make([]db.Item, len(note.Items))
+	for i, item := range note.Items {
+		items[i] = invoice.Item{
+			SKU:         item.SKU,
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.Total,
+		}
+		taxed[i] = db.Item{TaxRate: item.TaxRate, Total: item.Total}
+	}
+
+	lines, err := pricing.Taxes(taxed, inv.Tax, note.Currency)
+	if err != nil {
+		return nil, err
+	}
+	var taxes []invoice.TaxLine
+	for _, t := range lines {
+		taxes = append(taxes, invoice.TaxLine{Rate: t.Rate, Amount: t.Amount})
+	}
+
+	return invoice.GenerateCreditNotePDF(invoice.CreditNote{
+		Invoice: invoice.Invoice{
+			ID:                 note.CreditNoteNumber,
+			Company:            pdfCompany(company),
+			CustomerName:       customer.Name,
+			CustomerEmail:      customer.Email,
+			CustomerAddress:    customer.Address,
+			CustomerCity:       customer.City,
+			CustomerPostalCode: customer.PostalCode,
+			CustomerPhone:      customer.Phone,
+			Items:              items,
+			Subtotal:           note.Subtotal,
+			Tax:                inv.Tax,
+			Taxes:              taxes,
+			Discount:           note.Discount,
+			Total:              note.Total,
+			Currency:           note.Currency,
+		},
+		Number:        note.CreditNoteNumber,
+		InvoiceNumber: inv.InvoiceNumber,
+		Reason:        note.Reason,
+		IssueDate:     dateOnly(note.CreatedAt),
+	})
+}
@@ -2,11 +2,29 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/mergepatch"
 
 	"github.com/gorilla/mux"
 )
 
+// customerFields are the fields of a customer a client writes
+type customerFields struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone,omitempty"`
+	Address     string `json:"address,omitempty"`
+	City        string `json:"city,omitempty"`
+	PostalCode  string `json:"postal_code,omitempty"`
+	Country     string `json:"country,omitempty"`
+	CompanyName string `json:"company_name,omitempty"`
+}
+
 // listCustomers handles GET /customers
 func (s *Server) listCustomers(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
@@ -14,14 +32,42 @@ func (s *Server) listCustomers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customers, err := s.db.ListCustomers()
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.CustomerFilterFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Archived customers are listed only when asked for
+	scope := db.ActiveCustomers
+	switch r.URL.Query().Get("archived") {
+	case "", "false":
+	case "true":
+		scope = db.ArchivedCustomers
+	case "all":
+		scope = db.AllCustomers
+	default:
+		http.Error(w, `archived must be "true", "false" or "all"`, http.StatusBadRequest)
+		return
+	}
+
+	customers, err := s.tenant(r).ListCustomers(scope, where, page)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	writePage(w, r, customers)
 }
 
 // createCustomer handles POST /customers
@@ -31,16 +77,7 @@ func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Name        string `json:"name"`
-		Email       string `json:"email"`
-		Phone       string `json:"phone,omitempty"`
-		Address     string `json:"address,omitempty"`
-		City        string `json:"city,omitempty"`
-		PostalCode  string `json:"postal_code,omitempty"`
-		Country     string `json:"country,omitempty"`
-		CompanyName string `json:"company_name,omitempty"`
-	}
+	var req customerFields
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
@@ -53,7 +90,7 @@ func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customer, err := s.db.CreateCustomer(req.Name, req.Email, req.Phone, req.Address, req.City, req.PostalCode, req.Country, req.CompanyName)
+	customer, err := s.store(r).CreateCustomer(req.Name, req.Email, req.Phone, req.Address, req.City, req.PostalCode, req.Country, req.CompanyName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -74,17 +111,19 @@ func (s *Server) getCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	customer, err := s.db.GetCustomer(id)
+	customer, err := s.tenant(r).GetCustomer(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
+	setETag(w, customer.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(customer)
 }
 
-// updateCustomer handles PUT /customers/{id}
+// updateCustomer handles PUT /customers/{id}. With If-Match the update only
+// applies to the version named by the ETag.
 func (s *Server) updateCustomer(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
 		http.Error(w, "Database not configured", http.StatusInternalServerError)
@@ -94,17 +133,14 @@ func (s *Server) updateCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var req struct {
-		Name        string `json:"name"`
-		Email       string `json:"email"`
-		Phone       string `json:"phone,omitempty"`
-		Address     string `json:"address,omitempty"`
-		City        string `json:"city,omitempty"`
-		PostalCode  string `json:"postal_code,omitempty"`
-		Country     string `json:"country,omitempty"`
-		CompanyName string `json:"company_name,omitempty"`
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Customer")
+		return
 	}
 
+	var req customerFields
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
@@ -115,17 +151,157 @@ func (s *Server) updateCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customer, err := s.db.UpdateCustomer(id, req.Name, req.Email, req.Phone, req.Address, req.City, req.PostalCode, req.Country, req.CompanyName)
+	customer, err := s.store(r).UpdateCustomer(id, version, req.Name, req.Email, req.Phone, req.Address, req.City, req.PostalCode, req.Country, req.CompanyName)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Customer not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrVersionConflict):
+			preconditionFailed(w, "Customer")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setETag(w, customer.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(customer)
+}
+
+// patchCustomer handles PATCH /customers/{id}. The body is a JSON Merge Patch
+// (RFC 7396): only the fields it names change, and a field set to null is
+// cleared. With If-Match the patch only applies to the version named by the
+// ETag.
+func (s *Server) patchCustomer(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Customer")
+		return
+	}
+
+	patch, ok := readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, err := s.tenant(r).GetCustomer(id)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Customer not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if version != 0 && current.Version != version {
+			preconditionFailed(w, "Customer")
+			return
+		}
+
+		base := customerFields{
+			Name:        current.Name,
+			Email:       current.Email,
+			Phone:       current.Phone,
+			Address:     current.Address,
+			City:        current.City,
+			PostalCode:  current.PostalCode,
+			Country:     current.Country,
+			CompanyName: current.CompanyName,
+		}
+		req := base
+		if err := mergepatch.Apply(&req, patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" || req.Email == "" {
+			http.Error(w, "Name and email are required", http.StatusBadRequest)
+			return
+		}
+
+		if req == base {
+			// Nothing to change
+			setETag(w, current.Version)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(current)
+			return
+		}
+
+		// Write against the version the patch was applied to, so a change
+		// made in between is not overwritten
+		customer, err := s.store(r).UpdateCustomer(id, current.Version, req.Name, req.Email, req.Phone, req.Address, req.City, req.PostalCode, req.Country, req.CompanyName)
+		if errors.Is(err, db.ErrVersionConflict) && version == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, db.ErrNotFound):
+				http.Error(w, "Customer not found", http.StatusNotFound)
+			case errors.Is(err, db.ErrVersionConflict):
+				preconditionFailed(w, "Customer")
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		setETag(w, customer.Version)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(customer)
+		return
+	}
+}
+
+// archiveCustomer handles POST /customers/{id}/archive
+func (s *Server) archiveCustomer(w http.ResponseWriter, r *http.Request) {
+	s.setCustomerArchived(w, r, true)
+}
+
+// restoreCustomer handles POST /customers/{id}/restore
+func (s *Server) restoreCustomer(w http.ResponseWriter, r *http.Request) {
+	s.setCustomerArchived(w, r, false)
+}
+
+func (s *Server) setCustomerArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var customer *db.Customer
+	var err error
+	if archived {
+		customer, err = s.store(r).ArchiveCustomer(id)
+	} else {
+		customer, err = s.store(r).RestoreCustomer(id)
+	}
 	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setETag(w, customer.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(customer)
 }
 
-// deleteCustomer handles DELETE /customers/{id}
+// deleteCustomer handles DELETE /customers/{id}. A customer with invoices is
+// only deleted, together with its invoices and payments, with ?force=true;
+// otherwise it should be archived. With If-Match the customer is only deleted
+// at the version named by the ETag.
 func (s *Server) deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
 		http.Error(w, "Database not configured", http.StatusInternalServerError)
@@ -135,8 +311,32 @@ func (s *Server) deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := s.db.DeleteCustomer(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Customer")
+		return
+	}
+
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "force must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.store(r).DeleteCustomer(id, version, force); err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Customer not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrVersionConflict):
+			preconditionFailed(w, "Customer")
+		case errors.Is(err, db.ErrCustomerHasInvoices):
+			http.Error(w, err.Error()+"; archive the customer instead, or delete with force=true to remove the invoices too", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
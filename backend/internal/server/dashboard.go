@@ -17,7 +17,7 @@ func (s *Server) getDashboardStats(w http.ResponseWriter, r *http.Request) {
 		currency = "ALL"
 	}
 
-	stats, err := s.db.GetDashboardStats(currency)
+	stats, err := s.tenant(r).GetDashboardStats(currency)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -41,7 +41,7 @@ func (s *Server) getRevenueByPeriod(w http.ResponseWriter, r *http.Request) {
 
 	limit := 30 // Default to 30 days
 	
-	revenue, err := s.db.GetRevenueByPeriod(period, limit)
+	revenue, err := s.tenant(r).GetRevenueByPeriod(period, limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -60,7 +60,7 @@ func (s *Server) getTopCustomers(w http.ResponseWriter, r *http.Request) {
 
 	limit := 10 // Top 10 customers
 	
-	customers, err := s.db.GetTopCustomers(limit)
+	customers, err := s.tenant(r).GetTopCustomers(limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -70,6 +70,25 @@ func (s *Server) getTopCustomers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(customers)
 }
 
+// getTopItems handles GET /dashboard/top-items
+func (s *Server) getTopItems(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	limit := 10 // Top 10 items
+
+	items, err := s.tenant(r).GetTopItems(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
 // getOverdueInvoices handles GET /dashboard/overdue
 func (s *Server) getOverdueInvoices(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
@@ -77,7 +96,7 @@ func (s *Server) getOverdueInvoices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invoices, err := s.db.GetOverdueInvoices()
+	invoices, err := s.tenant(r).GetOverdueInvoices()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
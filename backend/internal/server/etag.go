@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a record's version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag tags a response with the version of the record it carries
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// ifMatch returns the version a write is conditional on, from the If-Match
+// header: 0 when there is no header or it is "*", meaning the write applies
+// to whatever version is current. ok is false for a header that names no
+// version of ours, which can never match.
+func ifMatch(r *http.Request) (version int, ok bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, true
+	}
+	v = strings.TrimPrefix(v, "W/")
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v[1 : len(v)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// preconditionFailed answers a write whose If-Match does not match the
+// record's current version
+func preconditionFailed(w http.ResponseWriter, what string) {
+	http.Error(w, what+" was changed by someone else; reload it and try again", http.StatusPreconditionFailed)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int
+		ok      bool
+	}{
+		{"", 0, true},
+		{"*", 0, true},
+		{`"3"`, 3, true},
+		{`W/"3"`, 3, true},
+		{` "12" `, 12, true},
+		{"3", 0, false},
+		{`"0"`, 0, false},
+		{`"-1"`, 0, false},
+		{`"abc"`, 0, false},
+		{`"`, 0, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/customers/1", nil)
+		r.Header.Set("If-Match", tt.header)
+		version, ok := ifMatch(r)
+		if version != tt.version || ok != tt.ok {
+			t.Errorf("If-Match %q: %d, %v; want %d, %v", tt.header, version, ok, tt.version, tt.ok)
+		}
+	}
+}
+
+// newTestServer serves the API from a fresh embedded SQLite database
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "invoice.db"))
+	t.Setenv("DB_AUTO_MIGRATE", "true")
+	t.Setenv("AUTH_JWT_SECRET", "")
+	t.Setenv("TRUST_ORG_HEADER", "")
+	return New()
+}
+
+// do sends a request with a JSON body and an If-Match header, when not
+// empty, and decodes a JSON response into out, when not nil
+func do(t *testing.T, h http.Handler, method, path, ifMatch, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if out != nil && w.Code < 300 {
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return w
+}
+
+// TestStaleIfMatch checks that writes conditional on a version the record
+// has moved past are refused with 412 and change nothing
+func TestStaleIfMatch(t *testing.T) {
+	h := newTestServer(t)
+
+	var customer struct {
+		ID string `json:"id"`
+	}
+	w := do(t, h, "POST", "/customers", "", `{"name": "Acme", "email": "billing@acme.example"}`, &customer)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create customer: %d %s", w.Code, w.Body)
+	}
+	path := "/customers/" + customer.ID
+
+	w = do(t, h, "GET", path, "", "", nil)
+	stale := w.Header().Get("ETag")
+	if stale == "" {
+		t.Fatal("GET customer: no ETag")
+	}
+	w = do(t, h, "PUT", path, stale, `{"name": "Acme Ltd", "email": "billing@acme.example"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT at the current version: %d %s", w.Code, w.Body)
+	}
+	current := w.Header().Get("ETag")
+	if current == stale {
+		t.Errorf("PUT kept ETag %s", stale)
+	}
+
+	for _, tt := range []struct {
+		method, ifMatch, body string
+	}{
+		{"PUT", stale, `{"name": "Acme Inc", "email": "billing@acme.example"}`},
+		{"PATCH", stale, `{"name": "Acme Inc"}`},
+		{"DELETE", stale, ""},
+		{"PUT", `"not a version"`, `{"name": "Acme Inc", "email": "billing@acme.example"}`},
+	} {
+		if w := do(t, h, tt.method, path, tt.ifMatch, tt.body, nil); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s customer with If-Match %s: %d %s, want 412", tt.method, tt.ifMatch, w.Code, w.Body)
+		}
+	}
+	var got struct {
+		Name string `json:"name"`
+	}
+	w = do(t, h, "GET", path, "", "", &got)
+	if got.Name != "Acme Ltd" || w.Header().Get("ETag") != current {
+		t.Errorf("customer = %s with ETag %s, want Acme Ltd with %s", got.Name, w.Header().Get("ETag"), current)
+	}
+
+	var invoice struct {
+		ID string `json:"id"`
+	}
+	w = do(t, h, "POST", "/invoices", "", `{"customer_id": "`+customer.ID+`", "status": "issued",
+		"items": [{"description": "Consulting", "quantity": 1, "unit_price": 100}]}`, &invoice)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create invoice: %d %s", w.Code, w.Body)
+	}
+	path = "/invoices/" + invoice.ID
+	stale = do(t, h, "GET", path, "", "", nil).Header().Get("ETag")
+	if w := do(t, h, "PUT", path, stale, `{"notes": "First"}`, nil); w.Code != http.StatusOK {
+		t.Fatalf("PUT invoice at the current version: %d %s", w.Code, w.Body)
+	}
+	for _, method := range []string{"PUT", "PATCH"} {
+		if w := do(t, h, method, path, stale, `{"notes": "Second"}`, nil); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s invoice with a stale If-Match: %d %s, want 412", method, w.Code, w.Body)
+		}
+	}
+
+	if w := do(t, h, "DELETE", "/customers/"+customer.ID+"?force=true", current, "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE at the current version: %d %s", w.Code, w.Body)
+	}
+}
@@ -2,16 +2,32 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"invoice-backend/internal/db"
+	"invoice-backend/internal/filter"
 	"invoice-backend/internal/invoice"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/mergepatch"
+	"invoice-backend/internal/money"
+	"invoice-backend/internal/pricing"
 
 	"github.com/gorilla/mux"
 )
 
+// invoiceFields are the fields of an invoice a client can change after
+// creating it
+type invoiceFields struct {
+	Status  string `json:"status"`
+	Notes   string `json:"notes,omitempty"`
+	DueDate string `json:"due_date,omitempty"`
+}
+
 // listInvoices handles GET /invoices
 func (s *Server) listInvoices(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
@@ -19,14 +35,29 @@ func (s *Server) listInvoices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invoices, err := s.db.ListInvoices()
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.InvoiceFilterFields)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invoices, err := s.tenant(r).ListInvoices(where, page)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(invoices)
+	writePage(w, r, invoices)
 }
 
 // createInvoice handles POST /invoices
@@ -37,14 +68,15 @@ func (s *Server) createInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		CustomerID string    `json:"customer_id"`
-		Items      []db.Item `json:"items"`
-		Tax        float64   `json:"tax,omitempty"`
-		Discount   float64   `json:"discount,omitempty"`
-		Status     string    `json:"status,omitempty"`
-		Notes      string    `json:"notes,omitempty"`
-		DueDate    string    `json:"due_date,omitempty"`
-		Currency   string    `json:"currency,omitempty"`
+		CompanyID  string       `json:"company_id,omitempty"` // Issuing company; the default profile when empty
+		CustomerID string       `json:"customer_id"`
+		Items      []db.Item    `json:"items"`
+		Tax        float64      `json:"tax,omitempty"` // Tax percentage
+		Discount   money.Amount `json:"discount,omitempty"`
+		Status     string       `json:"status,omitempty"`
+		Notes      string       `json:"notes,omitempty"`
+		DueDate    string       `json:"due_date,omitempty"`
+		Currency   string       `json:"currency,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -57,9 +89,10 @@ func (s *Server) createInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set default status if not provided
-	if req.Status == "" {
-		req.Status = "pending"
+	// A new invoice is a draft unless it is issued or sent straight away
+	if _, err := lifecycle.Initial(req.Status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Set default currency if not provided
@@ -67,32 +100,72 @@ func (s *Server) createInvoice(w http.ResponseWriter, r *http.Request) {
 		req.Currency = "USD"
 	}
 
-	// Calculate subtotal
-	var subtotal float64
-	for _, item := range req.Items {
-		subtotal += float64(item.Quantity) * item.UnitPrice
+	// Get customer for its price list and the PDF
+	customer, err := s.tenant(r).GetCustomer(req.CustomerID)
+	if err != nil {
+		http.Error(w, "Customer not found", http.StatusBadRequest)
+		return
+	}
+	if customer.ArchivedAt != "" {
+		http.Error(w, "Customer is archived; restore it before invoicing", http.StatusConflict)
+		return
+	}
+
+	// The issuing company numbers the invoice and brands its PDF
+	company, err := s.tenant(r).GetCompanyProfile(req.CompanyID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) && req.CompanyID != "" {
+			http.Error(w, "Company profile not found", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var priceList *db.PriceList
+	if customer.PriceListID != "" {
+		priceList, err = s.tenant(r).GetPriceList(customer.PriceListID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Calculate total with tax and discount
-	taxAmount := subtotal * (req.Tax / 100)
-	total := subtotal + taxAmount - req.Discount
+	// Lines that reference the catalog take the product's details and price,
+	// or the customer's price from its price list
+	err = pricing.Catalog(req.Items, req.Currency, priceList, func(id string) (*db.Product, error) {
+		product, err := s.tenant(r).GetProduct(id)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil
+		}
+		return product, err
+	})
+	if err != nil {
+		if errors.Is(err, pricing.ErrInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// Get customer for PDF
-	customer, err := s.db.GetCustomer(req.CustomerID)
+	// Price the lines and total the invoice, rounded to the currency's
+	// precision
+	totals, err := pricing.Price(req.Items, req.Tax, req.Discount, req.Currency)
 	if err != nil {
-		http.Error(w, "Customer not found", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Create invoice record in database
-	invRecord, err := s.db.CreateInvoice(req.CustomerID, subtotal, req.Tax, req.Discount, total, req.Items, req.Status, req.Notes, req.DueDate, req.Currency)
+	invRecord, err := s.store(r).CreateInvoice(company.ID, req.CustomerID, totals.Subtotal, req.Tax, totals.Discount, totals.Total, req.Items, req.Status, req.Notes, req.DueDate, req.Currency)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Generate PDF
-	pdfBytes, err := generateInvoicePDF(invRecord, customer, req.Items, subtotal, req.Tax, req.Discount, total)
+	pdfBytes, err := generateInvoicePDF(invRecord, company, customer, totals)
 	if err != nil {
 		log.Printf("Failed to generate PDF: %v", err)
 		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
@@ -101,13 +174,18 @@ func (s *Server) createInvoice(w http.ResponseWriter, r *http.Request) {
 
 	// Return invoice with PDF data
 	response := map[string]interface{}{
-		"id":          invRecord.ID,
-		"customer_id": invRecord.CustomerID,
-		"total":       invRecord.Total,
-		"items":       invRecord.Items,
-		"currency":    invRecord.Currency,
-		"pdf_data":    pdfBytes,
-		"created_at":  invRecord.CreatedAt,
+		"id":             invRecord.ID,
+		"invoice_number": invRecord.InvoiceNumber,
+		"company_id":     invRecord.CompanyID,
+		"customer_id":    invRecord.CustomerID,
+		"total":          invRecord.Total,
+		"items":          invRecord.Items,
+		"currency":       invRecord.Currency,
+		"status":         invRecord.Status,
+		"payment_status": invRecord.PaymentStatus,
+		"paid_amount":    invRecord.PaidAmount, // Credit applied on creation
+		"pdf_data":       pdfBytes,
+		"created_at":     invRecord.CreatedAt,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -125,17 +203,20 @@ func (s *Server) getInvoice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	invoice, err := s.db.GetInvoice(id)
+	invoice, err := s.tenant(r).GetInvoice(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
+	setETag(w, invoice.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(invoice)
 }
 
-// updateInvoice handles PUT /invoices/{id}
+// updateInvoice handles PUT /invoices/{id}. A status other than the
+// current one moves the invoice to it as the transition endpoints do. With
+// If-Match the update only applies to the version named by the ETag.
 func (s *Server) updateInvoice(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
 		http.Error(w, "Database not configured", http.StatusInternalServerError)
@@ -145,45 +226,197 @@ func (s *Server) updateInvoice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var req struct {
-		Status  string `json:"status"`
-		Notes   string `json:"notes,omitempty"`
-		DueDate string `json:"due_date,omitempty"`
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Invoice")
+		return
 	}
 
+	var req invoiceFields
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	if req.Status == "" {
-		http.Error(w, "status is required", http.StatusBadRequest)
-		return
-	}
-
-	invoice, err := s.db.UpdateInvoice(id, req.Status, req.Notes, req.DueDate)
+	invoice, err := s.store(r).UpdateInvoice(id, version, req.Status, req.Notes, req.DueDate)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		invoiceError(w, err)
 		return
 	}
 
+	setETag(w, invoice.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(invoice)
 }
 
+// patchInvoice handles PATCH /invoices/{id}. The body is a JSON Merge Patch
+// (RFC 7396): only the fields it names change, and a field set to null is
+// cleared. With If-Match the patch only applies to the version named by the
+// ETag.
+func (s *Server) patchInvoice(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Invoice")
+		return
+	}
+
+	patch, ok := readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, err := s.tenant(r).GetInvoice(id)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Invoice not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if version != 0 && current.Version != version {
+			preconditionFailed(w, "Invoice")
+			return
+		}
+
+		base := invoiceFields{
+			Status:  current.Status,
+			Notes:   current.Notes,
+			DueDate: dateOnly(current.DueDate),
+		}
+		req := base
+		if err := mergepatch.Apply(&req, patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req == base {
+			// Nothing to change
+			setETag(w, current.Version)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(current)
+			return
+		}
+
+		// Write against the version the patch was applied to, so a change
+		// made in between is not overwritten
+		invoice, err := s.store(r).UpdateInvoice(id, current.Version, req.Status, req.Notes, req.DueDate)
+		if errors.Is(err, db.ErrVersionConflict) && version == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			invoiceError(w, err)
+			return
+		}
+
+		setETag(w, invoice.Version)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+		return
+	}
+}
+
+// transitionInvoice returns the handler of POST /invoices/{id}/issue,
+// /send, /void and /write-off, which move an invoice to status. The body
+// may give a reason for voiding or writing off the invoice:
+// {"reason": "duplicate of INV-0042"}. With If-Match the invoice is only
+// moved from the version named by the ETag.
+func (s *Server) transitionInvoice(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.db == nil {
+			http.Error(w, "Database not configured", http.StatusInternalServerError)
+			return
+		}
+
+		version, ok := ifMatch(r)
+		if !ok {
+			preconditionFailed(w, "Invoice")
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		invoice, err := s.store(r).TransitionInvoice(mux.Vars(r)["id"], version, status, strings.TrimSpace(req.Reason))
+		if err != nil {
+			invoiceError(w, err)
+			return
+		}
+
+		setETag(w, invoice.Version)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+	}
+}
+
+// markOverdue marks the invoices that passed their due date overdue, now
+// and then every interval
+func (s *Server) markOverdue(interval time.Duration) {
+	for {
+		n, err := s.db.MarkOverdueInvoices()
+		if err != nil {
+			log.Printf("Failed to mark overdue invoices: %v", err)
+		} else if n > 0 {
+			log.Printf("Marked %d invoices overdue", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// invoiceError answers a failed invoice write
+func invoiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Invoice")
+	case errors.Is(err, lifecycle.ErrUnknownStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, lifecycle.ErrNotAllowed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // generateInvoicePDF is a helper function to generate PDF from invoice data
-func generateInvoicePDF(invRecord *db.Invoice, customer *db.Customer, items []db.Item, subtotal, tax, discount, total float64) ([]byte, error) {
-	invItems := make([]invoice.Item, len(items))
-	for i, item := range items {
+func generateInvoicePDF(invRecord *db.Invoice, company *db.CompanyProfile, customer *db.Customer, totals *pricing.Totals) ([]byte, error) {
+	invItems := make([]invoice.Item, len(invRecord.Items))
+	for i, item := range invRecord.Items {
 		invItems[i] = invoice.Item{
+			SKU:         item.SKU,
 			Description: item.Description,
 			Quantity:    item.Quantity,
+			Unit:        item.Unit,
 			UnitPrice:   item.UnitPrice,
+			PriceRule:   item.PriceRule,
+			Discount:    item.Discount,
+			Total:       item.Total,
 		}
 	}
 
+	taxes := make([]invoice.TaxLine, len(totals.Taxes))
+	for i, t := range totals.Taxes {
+		taxes[i] = invoice.TaxLine{Rate: t.Rate, Amount: t.Amount}
+	}
+
 	inv := invoice.Invoice{
 		ID:                 invRecord.ID,
+		Company:            pdfCompany(company),
 		CustomerName:       customer.Name,
 		CustomerEmail:      customer.Email,
 		CustomerAddress:    customer.Address,
@@ -191,10 +424,11 @@ func generateInvoicePDF(invRecord *db.Invoice, customer *db.Customer, items []db
 		CustomerPostalCode: customer.PostalCode,
 		CustomerPhone:      customer.Phone,
 		Items:              invItems,
-		Subtotal:           subtotal,
-		Tax:                tax,
-		Discount:           discount,
-		Total:              total,
+		Subtotal:           totals.Subtotal,
+		Tax:                invRecord.Tax,
+		Taxes:              taxes,
+		Discount:           totals.Discount,
+		Total:              totals.Total,
 		Currency:           invRecord.Currency, // Add currency from invoice record
 	}
 
@@ -214,14 +448,29 @@ func (s *Server) filterInvoices(w http.ResponseWriter, r *http.Request) {
 	startDate := r.URL.Query().Get("start_date")
 	endDate := r.URL.Query().Get("end_date")
 
-	invoices, err := s.db.FilterInvoices(status, searchTerm, startDate, endDate)
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.InvoiceFilterFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invoices, err := s.tenant(r).FilterInvoices(status, searchTerm, startDate, endDate, where, page)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(invoices)
+	writePage(w, r, invoices)
 }
 
 // getCurrencyRates handles GET /currency-rates
@@ -231,7 +480,7 @@ func (s *Server) getCurrencyRates(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rates, err := s.db.ListCurrencyRates()
+	rates, err := s.tenant(r).ListCurrencyRates()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -258,8 +507,8 @@ func (s *Server) convertCurrency(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var amount float64
-	if _, err := fmt.Sscanf(amountStr, "%f", &amount); err != nil {
+	amount, err := money.Parse(amountStr)
+	if err != nil {
 		http.Error(w, "invalid amount", http.StatusBadRequest)
 		return
 	}
@@ -279,13 +528,13 @@ func (s *Server) convertCurrency(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get exchange rate
-	rate, err := s.db.GetCurrencyRate(fromCurrency, toCurrency)
+	rate, err := s.tenant(r).GetCurrencyRate(fromCurrency, toCurrency)
 	if err != nil {
 		http.Error(w, "Currency rate not found", http.StatusNotFound)
 		return
 	}
 
-	convertedAmount := amount * rate.Rate
+	convertedAmount := money.New(amount, fromCurrency).Convert(toCurrency, rate.Rate).Amount
 
 	response := map[string]interface{}{
 		"from":             fromCurrency,
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"invoice-backend/internal/db"
+
+	"github.com/gorilla/mux"
+)
+
+// listNumberSeries handles GET /numbering/series
+func (s *Server) listNumberSeries(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	series, err := s.tenant(r).ListNumberSeries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// updateNumberSeries handles PUT /numbering/series/{key}
+func (s *Server) updateNumberSeries(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Prefix  string `json:"prefix"`
+		Pattern string `json:"pattern"`
+		Reset   string `json:"reset"`
+		Padding int    `json:"padding"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	series := db.NumberSeries{
+		Key:     mux.Vars(r)["key"],
+		Prefix:  req.Prefix,
+		Pattern: req.Pattern,
+		Reset:   req.Reset,
+		Padding: req.Padding,
+	}
+	if err := series.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.store(r).UpdateNumberSeries(series)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Number series not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// auditNumberSeries handles GET /numbering/series/{key}/audit
+func (s *Server) auditNumberSeries(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	audit, err := s.tenant(r).AuditNumberSeries(mux.Vars(r)["key"])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Number series not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audit)
+}
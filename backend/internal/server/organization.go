@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/tenant"
+)
+
+// tenant returns the database for a request, scoped to the organization
+// the tenant middleware resolved for it
+func (s *Server) tenant(r *http.Request) db.Store {
+	return s.db.WithOrg(tenant.Org(r))
+}
+
+// getOrganization handles GET /organization, the organization the request
+// acts for
+func (s *Server) getOrganization(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	org, err := s.tenant(r).GetOrganization()
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// orgExists reports whether the organization org exists, for the tenant
+// middleware
+func (s *Server) orgExists(org string) (bool, error) {
+	_, err := s.db.WithOrg(org).GetOrganization()
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
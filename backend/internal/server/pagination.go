@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"invoice-backend/internal/db"
+)
+
+// pageRequest reads the limit, cursor, sort and order query parameters of a
+// list endpoint
+func pageRequest(r *http.Request) (db.PageRequest, error) {
+	q := r.URL.Query()
+	page := db.PageRequest{
+		Cursor: q.Get("cursor"),
+		Sort:   q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return page, fmt.Errorf("%w: limit must be a positive integer", db.ErrInvalidPage)
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
+
+// writePage encodes the items of a page as the JSON array body and describes
+// the page in headers: X-Total-Count, and X-Next-Cursor plus a Link
+// rel="next" URL when another page follows
+func writePage[T any](w http.ResponseWriter, r *http.Request, page *db.Page[T]) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		next := *r.URL
+		q := next.Query()
+		q.Set("cursor", page.NextCursor)
+		q.Set("limit", strconv.Itoa(page.Limit))
+		q.Set("sort", page.Sort)
+		q.Set("order", page.Order)
+		next.RawQuery = q.Encode()
+
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	json.NewEncoder(w).Encode(page.Items)
+}
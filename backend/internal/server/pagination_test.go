@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"invoice-backend/internal/db"
+)
+
+func TestPageRequest(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    db.PageRequest
+		invalid bool
+	}{
+		{"", db.PageRequest{}, false},
+		{"limit=10&sort=name&order=desc&cursor=abc", db.PageRequest{Limit: 10, Sort: "name", Order: "desc", Cursor: "abc"}, false},
+		{"limit=0", db.PageRequest{}, true},
+		{"limit=-5", db.PageRequest{}, true},
+		{"limit=ten", db.PageRequest{}, true},
+	}
+	for _, tt := range tests {
+		got, err := pageRequest(httptest.NewRequest("GET", "/customers?"+tt.query, nil))
+		if tt.invalid {
+			if !errors.Is(err, db.ErrInvalidPage) {
+				t.Errorf("%q: err = %v, want db.ErrInvalidPage", tt.query, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	r := httptest.NewRequest("GET", "/invoices?status=issued&limit=2", nil)
+	w := httptest.NewRecorder()
+	writePage(w, r, &db.Page[string]{Items: []string{"a", "b"}, Total: 5, Limit: 2, Sort: "created_at", Order: "desc", NextCursor: "next"})
+
+	if got := w.Header().Get("X-Total-Count"); got != "5" {
+		t.Errorf("X-Total-Count = %q, want 5", got)
+	}
+	if got := w.Header().Get("X-Next-Cursor"); got != "next" {
+		t.Errorf("X-Next-Cursor = %q, want next", got)
+	}
+	link := w.Header().Get("Link")
+	if !strings.HasPrefix(link, "</invoices?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Link = %q, want a rel=\"next\" link to /invoices", link)
+	}
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	if err != nil {
+		t.Fatalf("Link URL: %v", err)
+	}
+	want := url.Values{"status": {"issued"}, "cursor": {"next"}, "limit": {"2"}, "sort": {"created_at"}, "order": {"desc"}}
+	if got := next.Query(); got.Encode() != want.Encode() {
+		t.Errorf("Link query = %s, want %s", got.Encode(), want.Encode())
+	}
+	if got := strings.TrimSpace(w.Body.String()); got != `["a","b"]` {
+		t.Errorf("body = %s, want [\"a\",\"b\"]", got)
+	}
+
+	// The last page has a total but no next page
+	w = httptest.NewRecorder()
+	writePage(w, r, &db.Page[string]{Items: []string{"e"}, Total: 5, Limit: 2, Sort: "created_at", Order: "desc"})
+	if got := w.Header().Get("X-Total-Count"); got != "5" {
+		t.Errorf("last page: X-Total-Count = %q, want 5", got)
+	}
+	if w.Header().Get("X-Next-Cursor") != "" || w.Header().Get("Link") != "" {
+		t.Errorf("last page: X-Next-Cursor %q, Link %q; want neither", w.Header().Get("X-Next-Cursor"), w.Header().Get("Link"))
+	}
+}
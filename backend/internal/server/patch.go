@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"invoice-backend/internal/mergepatch"
+)
+
+// patchAttempts bounds how often a PATCH without If-Match is reapplied when
+// the record changes between reading it and writing the patched fields
+const patchAttempts = 3
+
+// readPatch reads the merge patch of a PATCH request, answering the request
+// itself if the body cannot be used
+func readPatch(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	patch, err := mergepatch.Read(r)
+	if err != nil {
+		if errors.Is(err, mergepatch.ErrUnsupportedMediaType) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return nil, false
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return patch, true
+}
+
+// dateOnly returns the YYYY-MM-DD part of a date read back from the
+// database, which may carry a time of day
+func dateOnly(date string) string {
+	if len(date) > 10 {
+		return date[:10]
+	}
+	return date
+}
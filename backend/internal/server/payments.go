@@ -2,9 +2,15 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 
 	"invoice-backend/internal/db"
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/money"
 
 	"github.com/gorilla/mux"
 )
@@ -22,23 +28,230 @@ func (s *Server) recordPayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.InvoiceID == "" || req.Amount <= 0 || req.PaymentMethod == "" {
-		http.Error(w, "invoice_id, amount, and payment_method required", http.StatusBadRequest)
+	if (req.InvoiceID == "" && req.CustomerID == "") || req.Amount <= 0 || req.PaymentMethod == "" {
+		http.Error(w, "invoice_id or customer_id, amount, and payment_method required", http.StatusBadRequest)
 		return
 	}
+	if req.PaymentMethod == db.PaymentMethodCredit {
+		http.Error(w, "Credit is applied with POST /invoices/{id}/apply-credit", http.StatusBadRequest)
+		return
+	}
+	if req.InvoiceID != "" && (len(req.Allocations) > 0 || req.AutoAllocate) {
+		http.Error(w, "A payment is allocated across invoices without invoice_id", http.StatusBadRequest)
+		return
+	}
+	if len(req.Allocations) > 0 && req.AutoAllocate {
+		http.Error(w, "allocations and auto_allocate are exclusive", http.StatusBadRequest)
+		return
+	}
+	if msg := validateAllocations(req.Allocations); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Retries carrying the same Idempotency-Key return the original payment
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	if len(req.IdempotencyKey) > 255 {
+		http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	req.CreatedBy = actor(r)
 
-	payment, err := s.db.RecordPayment(req)
+	payment, err := s.store(r).RecordPayment(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound) && req.InvoiceID == "" && len(req.Allocations) > 0:
+			http.Error(w, "Customer or allocated invoice not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrNotFound) && req.InvoiceID == "":
+			http.Error(w, "Customer not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrIdempotencyConflict), errors.Is(err, lifecycle.ErrNotAllowed),
+			errors.Is(err, db.ErrOverallocated):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(payment)
+}
+
+// applyCredit handles POST /invoices/{id}/apply-credit, paying the invoice
+// from its customer's credit in the invoice's currency with a credit
+// application:
+//
+//	{"amount": 50}
+//
+// Without an amount, as much is applied as there is credit and is owed.
+func (s *Server) applyCredit(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Amount money.Amount `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Amount < 0 {
+		http.Error(w, "amount must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	application, err := s.store(r).ApplyCredit(mux.Vars(r)["id"], req.Amount, actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+		case errors.Is(err, lifecycle.ErrNotAllowed), errors.Is(err, db.ErrInsufficientCredit),
+			errors.Is(err, db.ErrCreditExceedsBalance):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setETag(w, application.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(application)
+}
+
+// getInvoiceCreditApplications handles GET
+// /invoices/{id}/credit-applications
+func (s *Server) getInvoiceCreditApplications(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	applications, err := s.tenant(r).GetCreditApplications(mux.Vars(r)["id"])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+}
+
+// voidCreditApplication handles POST /credit-applications/{id}/void,
+// giving the credit back to the customer; it requires If-Match and a reason
+func (s *Server) voidCreditApplication(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Credit application")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+
+	application, err := s.store(r).VoidCreditApplication(mux.Vars(r)["id"], version, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Credit application not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrVersionConflict):
+			preconditionFailed(w, "Credit application")
+		case errors.Is(err, db.ErrCreditApplicationVoided):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setETag(w, application.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(application)
+}
+
+// allocatePayment handles POST /payments/{id}/allocations, allocating what
+// is left of a payment without an invoice to invoices of its customer in its
+// currency:
+//
+//	{"allocations": [{"invoice_id": "...", "amount": 600}, {"invoice_id": "...", "amount": 400}]}
+//
+// Without allocations, it is allocated oldest due first.
+func (s *Server) allocatePayment(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		Allocations []db.PaymentAllocation `json:"allocations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if msg := validateAllocations(req.Allocations); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	payment, err := s.store(r).AllocatePayment(mux.Vars(r)["id"], req.Allocations, actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Payment or allocated invoice not found", http.StatusNotFound)
+		case errors.Is(err, lifecycle.ErrNotAllowed), errors.Is(err, db.ErrOverallocated):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			paymentError(w, err)
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(payment)
 }
 
-// getInvoicePayments handles GET /invoices/{id}/payments
+// validateAllocations explains what is wrong with allocations, or returns ""
+func validateAllocations(allocations []db.PaymentAllocation) string {
+	seen := make(map[string]bool, len(allocations))
+	for _, a := range allocations {
+		if a.InvoiceID == "" || a.Amount <= 0 {
+			return "Each allocation needs an invoice_id and a positive amount"
+		}
+		if seen[a.InvoiceID] {
+			return "Invoice " + a.InvoiceID + " is allocated more than once"
+		}
+		seen[a.InvoiceID] = true
+	}
+	return ""
+}
+
+// getInvoicePayments handles GET /invoices/{id}/payments, answering 404 for
+// an unknown invoice and [] for one without payments
 func (s *Server) getInvoicePayments(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
 		http.Error(w, "Database not configured", http.StatusInternalServerError)
@@ -48,16 +261,127 @@ func (s *Server) getInvoicePayments(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	invoiceID := vars["id"]
 
-	payments, err := s.db.GetPaymentsByInvoice(invoiceID)
+	store := s.tenant(r)
+	if _, err := store.GetInvoice(invoiceID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	payments, err := store.GetPaymentsByInvoice(invoiceID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if payments == nil {
+		payments = []db.Payment{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(payments)
 }
 
+// getPayment handles GET /payments/{id}
+func (s *Server) getPayment(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := s.tenant(r).GetPayment(mux.Vars(r)["id"])
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	setETag(w, payment.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
+// correctPayment handles PUT /payments/{id}, replacing the details of a
+// payment that was recorded wrong. The body is a payment with the reason for
+// the correction:
+//
+//	{"amount": 150, "payment_method": "bank_transfer", "reason": "Typo in amount"}
+//
+// The invoice's paid amount and status are recomputed from its payments. With
+// If-Match the payment is only corrected at the version named by the ETag.
+func (s *Server) correctPayment(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Payment")
+		return
+	}
+
+	var req db.PaymentCorrection
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Amount <= 0 || req.PaymentMethod == "" || req.Reason == "" {
+		http.Error(w, "amount, payment_method, and reason required", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := s.store(r).CorrectPayment(mux.Vars(r)["id"], version, req)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	setETag(w, payment.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
+// voidPayment handles POST /payments/{id}/void, for a payment recorded by
+// mistake. The body gives the reason: {"reason": "Recorded twice"}. The
+// payment is kept, with its receipt number, but no longer counts towards the
+// invoice's paid amount. With If-Match the payment is only voided at the
+// version named by the ETag.
+func (s *Server) voidPayment(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Payment")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := s.store(r).VoidPayment(mux.Vars(r)["id"], version, req.Reason)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	setETag(w, payment.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
 // getAllPayments handles GET /payments
 func (s *Server) getAllPayments(w http.ResponseWriter, r *http.Request) {
 	if s.db == nil {
@@ -65,12 +389,101 @@ func (s *Server) getAllPayments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	payments, err := s.db.GetAllPayments()
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.PaymentFilterFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payments, err := s.tenant(r).GetAllPayments(where, page)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writePage(w, r, payments)
+}
+
+// refundPayment handles POST /payments/{id}/refunds, returning part or all
+// of a payment to the customer:
+//
+//	{"amount": 50, "refund_method": "bank_transfer", "reason": "Returned goods"}
+//
+// The refund method defaults to the payment's. The invoice's paid amount and
+// status are recomputed, so a refunded paid invoice is owed again.
+func (s *Server) refundPayment(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req db.RefundCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.PaymentID = mux.Vars(r)["id"]
+
+	switch {
+	case req.Amount == 0:
+		http.Error(w, "amount required", http.StatusBadRequest)
+		return
+	case req.Amount < 0:
+		http.Error(w, "amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	req.CreatedBy = actor(r)
+
+	refund, err := s.store(r).RecordRefund(req)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(refund)
+}
+
+// getPaymentRefunds handles GET /payments/{id}/refunds
+func (s *Server) getPaymentRefunds(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	refunds, err := s.tenant(r).GetRefundsByPayment(mux.Vars(r)["id"])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(payments)
+	json.NewEncoder(w).Encode(refunds)
+}
+
+// paymentError answers a failed read or change of a payment
+func paymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Payment not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Payment")
+	case errors.Is(err, db.ErrPaymentVoided), errors.Is(err, db.ErrRefundExceedsPayment), errors.Is(err, db.ErrInsufficientCredit),
+		errors.Is(err, db.ErrPaymentAllocated):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
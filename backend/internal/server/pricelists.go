@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/pricing"
+
+	"github.com/gorilla/mux"
+)
+
+// priceListFields are the fields of a price list a client writes
+type priceListFields struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Currency    string         `json:"currency"`
+	Rules       []db.PriceRule `json:"rules"`
+}
+
+// priceList returns the price list the fields describe, checked and
+// normalized
+func (f priceListFields) priceList() (db.PriceList, error) {
+	list := db.PriceList{
+		Name:        f.Name,
+		Description: f.Description,
+		Currency:    f.Currency,
+		Rules:       f.Rules,
+	}
+	if list.Rules == nil {
+		list.Rules = []db.PriceRule{}
+	}
+	return list, pricing.CheckPriceList(&list)
+}
+
+// listPriceLists handles GET /price-lists
+func (s *Server) listPriceLists(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	lists, err := s.tenant(r).ListPriceLists()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lists == nil {
+		lists = []db.PriceList{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lists)
+}
+
+// createPriceList handles POST /price-lists
+func (s *Server) createPriceList(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req priceListFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	list, err := req.priceList()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.store(r).CreatePriceList(list)
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	setETag(w, created.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getPriceList handles GET /price-lists/{id}
+func (s *Server) getPriceList(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	list, err := s.tenant(r).GetPriceList(mux.Vars(r)["id"])
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	setETag(w, list.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// updatePriceList handles PUT /price-lists/{id}, replacing every field and
+// rule of the price list. With If-Match the update only applies to the
+// version named by the ETag.
+func (s *Server) updatePriceList(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Price list")
+		return
+	}
+
+	var req priceListFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	list, err := req.priceList()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.store(r).UpdatePriceList(id, version, list)
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	setETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deletePriceList handles DELETE /price-lists/{id}. Customers on the price
+// list go back to catalog prices; invoices keep the prices it set. With
+// If-Match the price list is only deleted at the version named by the ETag.
+func (s *Server) deletePriceList(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Price list")
+		return
+	}
+
+	if err := s.store(r).DeletePriceList(mux.Vars(r)["id"], version); err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCustomerPriceList handles PUT /customers/{id}/price-list. The body
+// names the price list, {"price_list_id": "..."}; null or "" removes the
+// customer's price list.
+func (s *Server) setCustomerPriceList(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		PriceListID string `json:"price_list_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	customer, err := s.store(r).SetCustomerPriceList(mux.Vars(r)["id"], req.PriceListID)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			http.Error(w, "Customer not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrUnknownPriceList):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setETag(w, customer.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(customer)
+}
+
+// priceListError answers a failed price list request
+func priceListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Price list not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Price list")
+	case errors.Is(err, db.ErrUnknownProduct):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
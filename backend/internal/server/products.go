@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"invoice-backend/internal/db"
+	"invoice-backend/internal/filter"
+	"invoice-backend/internal/pricing"
+
+	"github.com/gorilla/mux"
+)
+
+// productFields are the fields of a product a client writes
+type productFields struct {
+	SKU         string            `json:"sku"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Unit        string            `json:"unit,omitempty"`
+	TaxRate     *float64          `json:"tax_rate,omitempty"`
+	Prices      []db.ProductPrice `json:"prices"`
+	Active      *bool             `json:"active,omitempty"` // Defaults to true
+}
+
+// product returns the product the fields describe, checked and normalized
+func (f productFields) product() (db.Product, error) {
+	product := db.Product{
+		SKU:         f.SKU,
+		Name:        f.Name,
+		Description: f.Description,
+		Unit:        f.Unit,
+		TaxRate:     f.TaxRate,
+		Prices:      f.Prices,
+		Active:      f.Active == nil || *f.Active,
+	}
+	if product.Prices == nil {
+		product.Prices = []db.ProductPrice{}
+	}
+	return product, pricing.CheckProduct(&product)
+}
+
+// listProducts handles GET /products. active=true or active=false lists only
+// active or inactive products.
+func (s *Server) listProducts(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	where, err := filter.Parse(r.URL.Query().Get("filter"), db.ProductFilterFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var active *bool
+	if v := r.URL.Query().Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "active must be true or false", http.StatusBadRequest)
+			return
+		}
+		active = &b
+	}
+
+	products, err := s.tenant(r).ListProducts(active, where, page)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writePage(w, r, products)
+}
+
+// createProduct handles POST /products
+func (s *Server) createProduct(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	var req productFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	product, err := req.product()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.store(r).CreateProduct(product)
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	setETag(w, created.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getProduct handles GET /products/{id}
+func (s *Server) getProduct(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	product, err := s.tenant(r).GetProduct(mux.Vars(r)["id"])
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	setETag(w, product.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// updateProduct handles PUT /products/{id}, replacing every field and price
+// of the product. With If-Match the update only applies to the version named
+// by the ETag.
+func (s *Server) updateProduct(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Product")
+		return
+	}
+
+	var req productFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	product, err := req.product()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.store(r).UpdateProduct(id, version, product)
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	setETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteProduct handles DELETE /products/{id}. Invoices keep the details of
+// lines taken from the product. With If-Match the product is only deleted at
+// the version named by the ETag.
+func (s *Server) deleteProduct(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusInternalServerError)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		preconditionFailed(w, "Product")
+		return
+	}
+
+	if err := s.store(r).DeleteProduct(mux.Vars(r)["id"], version); err != nil {
+		productError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// productError answers a failed product request
+func productError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrVersionConflict):
+		preconditionFailed(w, "Product")
+	case errors.Is(err, db.ErrSKUTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
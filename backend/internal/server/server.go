@@ -3,8 +3,11 @@ package server
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"invoice-backend/internal/db"
+	"invoice-backend/internal/lifecycle"
+	"invoice-backend/internal/tenant"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -13,7 +16,7 @@ import (
 // Server represents the HTTP server
 type Server struct {
 	mux *mux.Router
-	db  *db.Client
+	db  db.Store
 }
 
 // New creates a new HTTP server with all routes configured
@@ -31,15 +34,34 @@ func New() http.Handler {
 		db:  d,
 	}
 
+	// Open invoices past their due date become overdue within the hour
+	if d != nil {
+		go srv.markOverdue(time.Hour)
+	}
+
+	// Requests for organizations that do not exist are refused
+	resolver := tenant.FromEnv()
+	if d != nil {
+		resolver.WithLookup(srv.orgExists)
+	}
+
 	// Health check
 	r.HandleFunc("/health", srv.health).Methods("GET")
 
+	// Organization endpoints
+	r.HandleFunc("/organization", srv.getOrganization).Methods("GET")
+
 	// Customer endpoints
 	r.HandleFunc("/customers", srv.listCustomers).Methods("GET")
 	r.HandleFunc("/customers", srv.createCustomer).Methods("POST")
 	r.HandleFunc("/customers/{id}", srv.getCustomer).Methods("GET")
 	r.HandleFunc("/customers/{id}", srv.updateCustomer).Methods("PUT")
+	r.HandleFunc("/customers/{id}", srv.patchCustomer).Methods("PATCH")
 	r.HandleFunc("/customers/{id}", srv.deleteCustomer).Methods("DELETE")
+	r.HandleFunc("/customers/{id}/archive", srv.archiveCustomer).Methods("POST")
+	r.HandleFunc("/customers/{id}/restore", srv.restoreCustomer).Methods("POST")
+	r.HandleFunc("/customers/{id}/price-list", srv.setCustomerPriceList).Methods("PUT")
+	r.HandleFunc("/customers/{id}/history", srv.history(db.AuditCustomer)).Methods("GET")
 
 	// Invoice endpoints
 	r.HandleFunc("/invoices", srv.listInvoices).Methods("GET")
@@ -47,28 +69,87 @@ func New() http.Handler {
 	r.HandleFunc("/invoices", srv.createInvoice).Methods("POST")
 	r.HandleFunc("/invoices/{id}", srv.getInvoice).Methods("GET")
 	r.HandleFunc("/invoices/{id}", srv.updateInvoice).Methods("PUT")
+	r.HandleFunc("/invoices/{id}", srv.patchInvoice).Methods("PATCH")
+	r.HandleFunc("/invoices/{id}/issue", srv.transitionInvoice(lifecycle.Issued)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/send", srv.transitionInvoice(lifecycle.Sent)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/void", srv.transitionInvoice(lifecycle.Void)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/write-off", srv.transitionInvoice(lifecycle.WrittenOff)).Methods("POST")
 	r.HandleFunc("/invoices/{id}/payments", srv.getInvoicePayments).Methods("GET")
+	r.HandleFunc("/invoices/{id}/apply-credit", srv.applyCredit).Methods("POST")
+	r.HandleFunc("/invoices/{id}/credit-applications", srv.getInvoiceCreditApplications).Methods("GET")
+	r.HandleFunc("/invoices/{id}/credit-notes", srv.getInvoiceCreditNotes).Methods("GET")
+	r.HandleFunc("/invoices/{id}/credit-notes", srv.createCreditNote).Methods("POST")
+	r.HandleFunc("/invoices/{id}/history", srv.history(db.AuditInvoice, db.AuditInvoiceItem, db.AuditCreditApplication)).Methods("GET")
+
+	// Credit note endpoints
+	r.HandleFunc("/credit-notes", srv.listCreditNotes).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}", srv.getCreditNote).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}/pdf", srv.getCreditNotePDF).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}/history", srv.history(db.AuditCreditNote, db.AuditCreditNoteItem)).Methods("GET")
+
+	// Credit application endpoints
+	r.HandleFunc("/credit-applications/{id}/void", srv.voidCreditApplication).Methods("POST")
+
+	// Product catalog endpoints
+	r.HandleFunc("/products", srv.listProducts).Methods("GET")
+	r.HandleFunc("/products", srv.createProduct).Methods("POST")
+	r.HandleFunc("/products/{id}", srv.getProduct).Methods("GET")
+	r.HandleFunc("/products/{id}", srv.updateProduct).Methods("PUT")
+	r.HandleFunc("/products/{id}", srv.deleteProduct).Methods("DELETE")
+	r.HandleFunc("/products/{id}/history", srv.history(db.AuditProduct, db.AuditProductPrice)).Methods("GET")
+
+	// Price list endpoints
+	r.HandleFunc("/price-lists", srv.listPriceLists).Methods("GET")
+	r.HandleFunc("/price-lists", srv.createPriceList).Methods("POST")
+	r.HandleFunc("/price-lists/{id}", srv.getPriceList).Methods("GET")
+	r.HandleFunc("/price-lists/{id}", srv.updatePriceList).Methods("PUT")
+	r.HandleFunc("/price-lists/{id}", srv.deletePriceList).Methods("DELETE")
+	r.HandleFunc("/price-lists/{id}/history", srv.history(db.AuditPriceList, db.AuditPriceListRule)).Methods("GET")
+
+	// Company profile endpoints
+	r.HandleFunc("/company-profiles", srv.listCompanyProfiles).Methods("GET")
+	r.HandleFunc("/company-profiles", srv.createCompanyProfile).Methods("POST")
+	r.HandleFunc("/company-profiles/{id}", srv.getCompanyProfile).Methods("GET")
+	r.HandleFunc("/company-profiles/{id}", srv.updateCompanyProfile).Methods("PUT")
+	r.HandleFunc("/company-profiles/{id}", srv.deleteCompanyProfile).Methods("DELETE")
+	r.HandleFunc("/company-profiles/{id}/history", srv.history(db.AuditCompany)).Methods("GET")
 
 	// Payment endpoints
 	r.HandleFunc("/payments", srv.recordPayment).Methods("POST")
 	r.HandleFunc("/payments", srv.getAllPayments).Methods("GET")
+	r.HandleFunc("/payments/{id}", srv.getPayment).Methods("GET")
+	r.HandleFunc("/payments/{id}", srv.correctPayment).Methods("PUT")
+	r.HandleFunc("/payments/{id}/void", srv.voidPayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/allocations", srv.allocatePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", srv.getPaymentRefunds).Methods("GET")
+	r.HandleFunc("/payments/{id}/refunds", srv.refundPayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/history", srv.history(db.AuditPayment, db.AuditRefund)).Methods("GET")
 
 	// Dashboard & Analytics endpoints
 	r.HandleFunc("/dashboard/stats", srv.getDashboardStats).Methods("GET")
 	r.HandleFunc("/dashboard/revenue", srv.getRevenueByPeriod).Methods("GET")
 	r.HandleFunc("/dashboard/top-customers", srv.getTopCustomers).Methods("GET")
+	r.HandleFunc("/dashboard/top-items", srv.getTopItems).Methods("GET")
 	r.HandleFunc("/dashboard/overdue", srv.getOverdueInvoices).Methods("GET")
 
 	// Currency endpoints
 	r.HandleFunc("/currency-rates", srv.getCurrencyRates).Methods("GET")
 	r.HandleFunc("/convert", srv.convertCurrency).Methods("GET")
 
-	// Enable CORS for React frontend
+	// Document numbering endpoints
+	r.HandleFunc("/numbering/series", srv.listNumberSeries).Methods("GET")
+	r.HandleFunc("/numbering/series/{key}", srv.updateNumberSeries).Methods("PUT")
+	r.HandleFunc("/numbering/series/{key}/audit", srv.auditNumberSeries).Methods("GET")
+	r.HandleFunc("/numbering/series/{id}/history", srv.history(db.AuditNumberSeries)).Methods("GET")
+
+	// Enable CORS for React frontend; every other request acts for the
+	// organization resolved from its token or X-Org-ID header
 	return handlers.CORS(
 		handlers.AllowedOrigins([]string{"http://localhost:3000"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-	)(r)
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Idempotency-Key", "If-Match", tenant.Header}),
+		handlers.ExposedHeaders([]string{"X-Total-Count", "X-Next-Cursor", "Link", "ETag"}),
+	)(resolver.Middleware(r))
 }
 
 // health is a simple health check endpoint
@@ -0,0 +1,129 @@
+package tenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	orgA    = "6f1c1b4e-3f0a-4a55-9c53-5b0e8f7a2d10"
+	orgB    = "0b7e2c9d-5a41-4f3e-8d2c-7e9f1a6b3c58"
+	unknown = "9d3a5e7f-1b2c-4d6e-8f0a-2b4c6d8e0f12"
+)
+
+// sign returns an HS256 token over the JSON payload
+func sign(secret, payload string) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// exists knows the default organization, orgA and orgB
+func exists(org string) (bool, error) {
+	return org == Default || org == orgA || org == orgB, nil
+}
+
+func TestResolveHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		trust  bool
+		header string
+		org    string
+		status int
+		err    error
+	}{
+		{"no header", false, "", Default, 0, nil},
+		{"default organization", false, Default, Default, 0, nil},
+		{"untrusted header", false, orgA, "", http.StatusForbidden, errUntrusted},
+		{"trusted header", true, orgA, orgA, 0, nil},
+		{"trusted header, no header", true, "", Default, 0, nil},
+		{"not a UUID", true, "acme", "", http.StatusBadRequest, errInvalidOrg},
+		{"unknown organization", true, unknown, "", http.StatusNotFound, errUnknownOrg},
+	}
+	for _, tt := range tests {
+		res := (&Resolver{trustHeader: tt.trust}).WithLookup(exists)
+		r := httptest.NewRequest("GET", "/invoices", nil)
+		if tt.header != "" {
+			r.Header.Set(Header, tt.header)
+		}
+		org, actor, status, err := res.Resolve(r)
+		if org != tt.org || actor != "" || status != tt.status || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %q, %q, %d, %v; want %q, no actor, %d, %v", tt.name, org, actor, status, err, tt.org, tt.status, tt.err)
+		}
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	const secret = "test-secret"
+	tests := []struct {
+		name   string
+		auth   string
+		org    string
+		actor  string
+		status int
+		err    error
+	}{
+		{"org_id claim", "Bearer " + sign(secret, `{"sub":"user-1","org_id":"`+orgA+`"}`), orgA, "user-1", 0, nil},
+		{"app_metadata", "Bearer " + sign(secret, `{"sub":"user-2","app_metadata":{"org_id":"`+orgB+`"}}`), orgB, "user-2", 0, nil},
+		{"no token", "", "", "", http.StatusUnauthorized, errMissingToken},
+		{"other secret", "Bearer " + sign("other", `{"sub":"user-1","org_id":"`+orgA+`"}`), "", "", http.StatusUnauthorized, errInvalidToken},
+		{"expired", "Bearer " + sign(secret, `{"sub":"user-1","org_id":"`+orgA+`","exp":1}`), "", "", http.StatusUnauthorized, errExpiredToken},
+		{"no organization", "Bearer " + sign(secret, `{"sub":"user-1"}`), "", "", http.StatusForbidden, errNoOrg},
+		{"unknown organization", "Bearer " + sign(secret, `{"sub":"user-1","org_id":"`+unknown+`"}`), "", "", http.StatusForbidden, errUnknownOrg},
+	}
+	for _, tt := range tests {
+		res := (&Resolver{secret: []byte(secret)}).WithLookup(exists)
+		r := httptest.NewRequest("GET", "/invoices", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		// The header cannot override the token's organization
+		r.Header.Set(Header, orgB)
+		org, actor, status, err := res.Resolve(r)
+		if org != tt.org || actor != tt.actor || status != tt.status || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %q, %q, %d, %v; want %q, %q, %d, %v", tt.name, org, actor, status, err, tt.org, tt.actor, tt.status, tt.err)
+		}
+	}
+}
+
+// TestMiddleware checks that handlers see the resolved organization and
+// actor, never the ones the client sent
+func TestMiddleware(t *testing.T) {
+	var org, actor string
+	h := resolverFromEnv(t, "", "").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org, actor = Org(r), Actor(r)
+	}))
+
+	r := httptest.NewRequest("GET", "/invoices", nil)
+	r.Header.Set(ActorHeader, "mallory")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || org != Default || actor != "" {
+		t.Errorf("no header: %d, org %q, actor %q; want 200, %s, none", w.Code, org, actor, Default)
+	}
+
+	org = ""
+	r = httptest.NewRequest("GET", "/invoices", nil)
+	r.Header.Set(Header, orgA)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || org != "" {
+		t.Errorf("untrusted header: %d, handler saw %q; want 403 before the handler", w.Code, org)
+	}
+}
+
+// resolverFromEnv returns the resolver FromEnv makes of the given
+// AUTH_JWT_SECRET and TRUST_ORG_HEADER
+func resolverFromEnv(t *testing.T, secret, trust string) *Resolver {
+	t.Helper()
+	t.Setenv("AUTH_JWT_SECRET", secret)
+	t.Setenv("TRUST_ORG_HEADER", trust)
+	return FromEnv()
+}
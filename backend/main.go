@@ -16,6 +16,22 @@ func main() {
         log.Printf("Warning: Error loading .env file: %v", err)
     }
 
+    // invoice-backend migrate status|up|down [steps]
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := runMigrate(os.Args[2:]); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+
+    // invoice-backend org list|create <name>
+    if len(os.Args) > 1 && os.Args[1] == "org" {
+        if err := runOrg(os.Args[2:]); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+
     addr := ":8080"
     if v := os.Getenv("PORT"); v != "" {
         addr = ":" + v
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"invoice-backend/internal/db"
+)
+
+const orgUsage = "usage: invoice-backend org list|create <name>"
+
+// runOrg implements the org subcommand, which lists and creates
+// organizations in the database selected by the environment (see
+// db.OpenSQL). Organizations are not managed through the API.
+func runOrg(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(orgUsage)
+	}
+
+	store, err := db.OpenSQL()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "list":
+		orgs, err := store.ListOrganizations()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED AT")
+		for _, o := range orgs {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", o.ID, o.Name, o.CreatedAt)
+		}
+		return w.Flush()
+
+	case "create":
+		name := strings.TrimSpace(strings.Join(args[1:], " "))
+		if name == "" {
+			return fmt.Errorf(orgUsage)
+		}
+		org, err := store.CreateOrganization(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created organization %s (%s)\n", org.ID, org.Name)
+		return nil
+
+	default:
+		return fmt.Errorf(orgUsage)
+	}
+}
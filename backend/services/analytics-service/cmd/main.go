@@ -9,6 +9,7 @@ import (
 	"invoice-backend/services/analytics-service/internal/repository"
 	"invoice-backend/services/shared/pkg/database"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -33,6 +34,7 @@ func main() {
 	r.HandleFunc("/dashboard/stats", h.GetDashboardStats).Methods("GET")
 	r.HandleFunc("/dashboard/revenue", h.GetRevenueByPeriod).Methods("GET")
 	r.HandleFunc("/dashboard/top-customers", h.GetTopCustomers).Methods("GET")
+	r.HandleFunc("/dashboard/top-items", h.GetTopItems).Methods("GET")
 	r.HandleFunc("/dashboard/overdue", h.GetOverdueInvoices).Methods("GET")
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -40,7 +42,7 @@ func main() {
 	}).Methods("GET")
 
 	// Apply middleware (no CORS - handled by API Gateway)
-	handler := middleware.Recovery(middleware.Logger("ANALYTICS-SERVICE")(r))
+	handler := middleware.Recovery(middleware.Logger("ANALYTICS-SERVICE")(tenant.FromEnv().WithLookup(db.OrgExists).Middleware(r)))
 
 	// Start server
 	port := os.Getenv("ANALYTICS_SERVICE_PORT")
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"invoice-backend/services/analytics-service/internal/repository"
+	"invoice-backend/services/shared/pkg/tenant"
 	"invoice-backend/services/shared/pkg/utils"
 )
 
@@ -16,6 +17,11 @@ func NewAnalyticsHandler(repo *repository.AnalyticsRepository) *AnalyticsHandler
 	return &AnalyticsHandler{repo: repo}
 }
 
+// repoFor returns the repository scoped to the organization of a request
+func (h *AnalyticsHandler) repoFor(r *http.Request) *repository.AnalyticsRepository {
+	return h.repo.WithOrg(tenant.Org(r))
+}
+
 // GetDashboardStats handles GET /dashboard/stats
 func (h *AnalyticsHandler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 	currency := r.URL.Query().Get("currency")
@@ -23,7 +29,7 @@ func (h *AnalyticsHandler) GetDashboardStats(w http.ResponseWriter, r *http.Requ
 		currency = "ALL"
 	}
 
-	stats, err := h.repo.GetDashboardStats(currency)
+	stats, err := h.repoFor(r).GetDashboardStats(currency)
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -47,7 +53,7 @@ func (h *AnalyticsHandler) GetRevenueByPeriod(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	revenue, err := h.repo.GetRevenueByPeriod(period, limit)
+	revenue, err := h.repoFor(r).GetRevenueByPeriod(period, limit)
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -66,7 +72,7 @@ func (h *AnalyticsHandler) GetTopCustomers(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	customers, err := h.repo.GetTopCustomers(limit)
+	customers, err := h.repoFor(r).GetTopCustomers(limit)
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -75,9 +81,28 @@ func (h *AnalyticsHandler) GetTopCustomers(w http.ResponseWriter, r *http.Reques
 	utils.Success(w, customers)
 }
 
+// GetTopItems handles GET /dashboard/top-items
+func (h *AnalyticsHandler) GetTopItems(w http.ResponseWriter, r *http.Request) {
+	limitStr := r.URL.Query().Get("limit")
+	limit := 10 // default top 10
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			limit = l
+		}
+	}
+
+	items, err := h.repoFor(r).GetTopItems(limit)
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+
+	utils.Success(w, items)
+}
+
 // GetOverdueInvoices handles GET /dashboard/overdue
 func (h *AnalyticsHandler) GetOverdueInvoices(w http.ResponseWriter, r *http.Request) {
-	invoices, err := h.repo.GetOverdueInvoices()
+	invoices, err := h.repoFor(r).GetOverdueInvoices()
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -5,7 +5,11 @@ import (
 	"time"
 
 	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/money"
 	"invoice-backend/services/shared/pkg/types"
+
+	"github.com/supabase-community/postgrest-go"
 )
 
 type AnalyticsRepository struct {
@@ -16,10 +20,15 @@ func NewAnalyticsRepository(db *database.Client) *AnalyticsRepository {
 	return &AnalyticsRepository{db: db}
 }
 
+// WithOrg returns a repository scoped to the organization org
+func (r *AnalyticsRepository) WithOrg(org string) *AnalyticsRepository {
+	return &AnalyticsRepository{db: r.db.WithOrg(org)}
+}
+
 // GetDashboardStats returns overall statistics
 func (r *AnalyticsRepository) GetDashboardStats(currency string) (*types.DashboardStats, error) {
 	var invoices []types.Invoice
-	query := r.db.Supabase.From("invoices").Select("*", "", false)
+	query := r.db.From("invoices").Select("*", "", false)
 
 	if currency != "" && currency != "ALL" {
 		query = query.Eq("currency", currency)
@@ -33,29 +42,35 @@ func (r *AnalyticsRepository) GetDashboardStats(currency string) (*types.Dashboa
 	stats := &types.DashboardStats{}
 
 	for _, inv := range invoices {
-		stats.TotalRevenue += inv.Total
+		// Drafts were never issued and void invoices were cancelled
+		if !billed(inv.Status) {
+			continue
+		}
 		stats.TotalInvoices++
+		stats.CreditedAmount += inv.CreditedAmount
 
-		switch inv.PaymentStatus {
-		case "paid":
-			stats.PaidAmount += inv.Total
+		// Credit notes lower what was billed and what is owed
+		switch inv.Status {
+		case lifecycle.WrittenOff:
+			// Only what was paid before the balance was written off
+			stats.TotalRevenue += inv.PaidAmount
+			stats.PaidAmount += inv.PaidAmount
+			continue
+		case lifecycle.Paid:
+			stats.PaidAmount += inv.Total - inv.CreditedAmount
 			stats.PaidInvoices++
-		case "unpaid":
-			stats.UnpaidAmount += inv.Total
-			stats.UnpaidInvoices++
-		case "partially_paid":
+		case lifecycle.PartiallyPaid:
 			stats.PaidAmount += inv.PaidAmount
-			stats.UnpaidAmount += (inv.Total - inv.PaidAmount)
-		}
-
-		// Check if overdue
-		if inv.DueDate != "" && inv.PaymentStatus != "paid" {
-			dueDate, err := time.Parse("2006-01-02", inv.DueDate)
-			if err == nil && dueDate.Before(time.Now()) {
-				stats.OverdueAmount += (inv.Total - inv.PaidAmount)
-				stats.OverdueInvoices++
-			}
+			stats.UnpaidAmount += (inv.Total - inv.PaidAmount - inv.CreditedAmount)
+		case lifecycle.Overdue:
+			stats.PaidAmount += inv.PaidAmount
+			stats.OverdueAmount += (inv.Total - inv.PaidAmount - inv.CreditedAmount)
+			stats.OverdueInvoices++
+		default:
+			stats.UnpaidAmount += (inv.Total - inv.PaidAmount - inv.CreditedAmount)
+			stats.UnpaidInvoices++
 		}
+		stats.TotalRevenue += inv.Total - inv.CreditedAmount
 	}
 
 	return stats, nil
@@ -64,8 +79,8 @@ func (r *AnalyticsRepository) GetDashboardStats(currency string) (*types.Dashboa
 // GetRevenueByPeriod returns revenue data grouped by period
 func (r *AnalyticsRepository) GetRevenueByPeriod(period string, limit int) ([]types.RevenueData, error) {
 	var invoices []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
-		Select("created_at, total, payment_status", "", false).
+	_, err := r.db.From("invoices").
+		Select("created_at, total, credited_amount, payment_status", "", false).
 		Eq("payment_status", "paid").
 		Order("created_at", nil).
 		Limit(limit, "").
@@ -76,7 +91,7 @@ func (r *AnalyticsRepository) GetRevenueByPeriod(period string, limit int) ([]ty
 	}
 
 	// Group by period
-	revenueMap := make(map[string]float64)
+	revenueMap := make(map[string]money.Amount)
 
 	for _, inv := range invoices {
 		// Parse CreatedAt timestamp
@@ -102,7 +117,7 @@ func (r *AnalyticsRepository) GetRevenueByPeriod(period string, limit int) ([]ty
 			periodKey = createdAt.Format("2006-01-02")
 		}
 
-		revenueMap[periodKey] += inv.Total
+		revenueMap[periodKey] += inv.Total - inv.CreditedAmount
 	}
 
 	// Convert map to slice
@@ -120,8 +135,8 @@ func (r *AnalyticsRepository) GetRevenueByPeriod(period string, limit int) ([]ty
 // GetTopCustomers returns top customers by revenue
 func (r *AnalyticsRepository) GetTopCustomers(limit int) ([]types.TopCustomer, error) {
 	var invoices []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
-		Select("customer_id, total", "", false).
+	_, err := r.db.From("invoices").
+		Select("customer_id, total, credited_amount, status", "", false).
 		ExecuteTo(&invoices)
 
 	if err != nil {
@@ -132,6 +147,9 @@ func (r *AnalyticsRepository) GetTopCustomers(limit int) ([]types.TopCustomer, e
 	customerMap := make(map[string]*types.TopCustomer)
 
 	for _, inv := range invoices {
+		if !billed(inv.Status) {
+			continue
+		}
 		if _, exists := customerMap[inv.CustomerID]; !exists {
 			customerMap[inv.CustomerID] = &types.TopCustomer{
 				CustomerID:    inv.CustomerID,
@@ -139,14 +157,14 @@ func (r *AnalyticsRepository) GetTopCustomers(limit int) ([]types.TopCustomer, e
 				InvoiceCount:  0,
 			}
 		}
-		customerMap[inv.CustomerID].TotalRevenue += inv.Total
+		customerMap[inv.CustomerID].TotalRevenue += inv.Total - inv.CreditedAmount
 		customerMap[inv.CustomerID].InvoiceCount++
 	}
 
 	// Fetch customer details for each customer
 	for customerID, topCustomer := range customerMap {
 		var customers []types.Customer
-		_, err := r.db.Supabase.From("customers").
+		_, err := r.db.From("customers").
 			Select("name, email", "", false).
 			Eq("id", customerID).
 			ExecuteTo(&customers)
@@ -180,17 +198,32 @@ func (r *AnalyticsRepository) GetTopCustomers(limit int) ([]types.TopCustomer, e
 	return result, nil
 }
 
-// GetOverdueInvoices returns invoices that are past due date
+// GetTopItems returns the items that brought in the most, from the
+// item_sales view (see migration 0009_invoice_items)
+func (r *AnalyticsRepository) GetTopItems(limit int) ([]types.TopItem, error) {
+	items := []types.TopItem{}
+	_, err := r.db.From("item_sales").
+		Select("*", "", false).
+		Order("revenue", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		ExecuteTo(&items)
+	return items, err
+}
+
+// GetOverdueInvoices returns the overdue invoices, oldest due date first
 func (r *AnalyticsRepository) GetOverdueInvoices() ([]types.Invoice, error) {
 	var invoices []types.Invoice
-	today := time.Now().Format("2006-01-02")
-
-	_, err := r.db.Supabase.From("invoices").
+	_, err := r.db.From("invoices").
 		Select("*, customers(name, email)", "", false).
-		Lt("due_date", today).
-		Neq("payment_status", "paid").
+		Eq("status", lifecycle.Overdue).
 		Order("due_date", nil).
 		ExecuteTo(&invoices)
 
 	return invoices, err
 }
+
+// billed reports whether an invoice in status counts as revenue: drafts
+// were never issued and void invoices were cancelled
+func billed(status string) bool {
+	return status != lifecycle.Draft && status != lifecycle.Void
+}
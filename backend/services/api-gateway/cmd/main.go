@@ -8,6 +8,7 @@ import (
 
 	"invoice-backend/services/api-gateway/internal/proxy"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -36,7 +37,7 @@ func main() {
 		
 		if strings.HasPrefix(path, "/customers") {
 			serviceURL = proxy.GetServiceURL("CUSTOMER_SERVICE")
-		} else if strings.HasPrefix(path, "/invoices") || strings.HasPrefix(path, "/currency-rates") {
+		} else if strings.HasPrefix(path, "/invoices") || strings.HasPrefix(path, "/products") || strings.HasPrefix(path, "/price-lists") || strings.HasPrefix(path, "/company-profiles") || strings.HasPrefix(path, "/credit-notes") || strings.HasPrefix(path, "/credit-applications") || strings.HasPrefix(path, "/currency-rates") {
 			serviceURL = proxy.GetServiceURL("INVOICE_SERVICE")
 		} else if strings.HasPrefix(path, "/payments") {
 			serviceURL = proxy.GetServiceURL("PAYMENT_SERVICE")
@@ -52,8 +53,9 @@ func main() {
 		proxy.ProxyRequest(w, r, serviceURL)
 	})
 
-	// Apply middleware
-	handler := middleware.Recovery(middleware.CORS(middleware.Logger("API-GATEWAY")(r)))
+	// Apply middleware; the organization is resolved here and forwarded to
+	// the services in the X-Org-ID header
+	handler := middleware.Recovery(middleware.CORS(middleware.Logger("API-GATEWAY")(tenant.FromEnv().Middleware(r))))
 
 	// Start server
 	port := os.Getenv("API_GATEWAY_PORT")
@@ -65,6 +67,11 @@ func main() {
 	log.Printf("📡 Routing:")
 	log.Printf("  /customers/*     -> Customer Service  (port %s)", os.Getenv("CUSTOMER_SERVICE_PORT"))
 	log.Printf("  /invoices/*      -> Invoice Service   (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
+	log.Printf("  /products/*      -> Invoice Service   (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
+	log.Printf("  /price-lists/*   -> Invoice Service   (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
+	log.Printf("  /company-profiles/* -> Invoice Service (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
+	log.Printf("  /credit-notes/*  -> Invoice Service   (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
+	log.Printf("  /credit-applications/* -> Invoice Service (port %s)", os.Getenv("INVOICE_SERVICE_PORT"))
 	log.Printf("  /payments/*      -> Payment Service   (port %s)", os.Getenv("PAYMENT_SERVICE_PORT"))
 	log.Printf("  /dashboard/*     -> Analytics Service (port %s)", os.Getenv("ANALYTICS_SERVICE_PORT"))
 	log.Printf("  /notifications/* -> Notification Svc  (port %s)", os.Getenv("NOTIFICATION_SERVICE_PORT"))
@@ -9,6 +9,7 @@ import (
 	"invoice-backend/services/customer-service/internal/repository"
 	"invoice-backend/services/shared/pkg/database"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -34,14 +35,19 @@ func main() {
 	r.HandleFunc("/customers/{id}", h.GetByID).Methods("GET")
 	r.HandleFunc("/customers", h.Create).Methods("POST")
 	r.HandleFunc("/customers/{id}", h.Update).Methods("PUT")
+	r.HandleFunc("/customers/{id}", h.Patch).Methods("PATCH")
 	r.HandleFunc("/customers/{id}", h.Delete).Methods("DELETE")
+	r.HandleFunc("/customers/{id}/archive", h.Archive).Methods("POST")
+	r.HandleFunc("/customers/{id}/restore", h.Restore).Methods("POST")
+	r.HandleFunc("/customers/{id}/price-list", h.SetPriceList).Methods("PUT")
+	r.HandleFunc("/customers/{id}/history", h.History).Methods("GET")
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy","service":"customer-service"}`))
 	}).Methods("GET")
 
 	// Apply middleware (no CORS - handled by API Gateway)
-	handler := middleware.Recovery(middleware.Logger("CUSTOMER-SERVICE")(r))
+	handler := middleware.Recovery(middleware.Logger("CUSTOMER-SERVICE")(tenant.FromEnv().WithLookup(db.OrgExists).Middleware(r)))
 
 	// Start server
 	port := os.Getenv("CUSTOMER_SERVICE_PORT")
@@ -2,15 +2,39 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"invoice-backend/services/customer-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/mergepatch"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/tenant"
 	"invoice-backend/services/shared/pkg/types"
 	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
 
 	"github.com/gorilla/mux"
 )
 
+// errChanged answers a write whose If-Match names a version of the customer
+// that is no longer current
+const errChanged = "customer was changed by someone else; reload it and try again"
+
+// patchAttempts bounds how often a PATCH without If-Match is reapplied when
+// the customer changes between reading it and writing the patched fields
+const patchAttempts = 3
+
+// customerFields are the fields of a customer a client can patch
+type customerFields struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
 type CustomerHandler struct {
 	repo *repository.CustomerRepository
 }
@@ -19,14 +43,48 @@ func NewCustomerHandler(repo *repository.CustomerRepository) *CustomerHandler {
 	return &CustomerHandler{repo: repo}
 }
 
+// repoFor returns the repository scoped to the organization of a request
+func (h *CustomerHandler) repoFor(r *http.Request) *repository.CustomerRepository {
+	return h.repo.WithOrg(tenant.Org(r))
+}
+
 // GetAll handles GET /customers
 func (h *CustomerHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	customers, err := h.repo.GetAll()
+	where, err := filter.Parse(r.URL.Query().Get("filter"), repository.Filter)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := pagination.Parse(r, repository.Sort)
 	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	// Archived customers are listed only when asked for
+	scope := repository.ScopeActive
+	switch r.URL.Query().Get("archived") {
+	case "", "false":
+	case "true":
+		scope = repository.ScopeArchived
+	case "all":
+		scope = repository.ScopeAll
+	default:
+		utils.BadRequest(w, `archived must be "true", "false" or "all"`)
+		return
+	}
+
+	customers, meta, err := h.repoFor(r).GetAll(scope, where, page)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalid) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
 		utils.InternalError(w, err.Error())
 		return
 	}
-	utils.Success(w, customers)
+	utils.Paginated(w, r, customers, meta)
 }
 
 // GetByID handles GET /customers/{id}
@@ -34,11 +92,12 @@ func (h *CustomerHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	customer, err := h.repo.GetByID(id)
+	customer, err := h.repoFor(r).GetByID(id)
 	if err != nil {
 		utils.NotFound(w, err.Error())
 		return
 	}
+	version.SetETag(w, customer.Version)
 	utils.Success(w, customer)
 }
 
@@ -63,7 +122,7 @@ func (h *CustomerHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Address: customerCreate.Address,
 	}
 
-	result, err := h.repo.Create(customer)
+	result, err := h.repoFor(r).WithActor(audit.Actor(r)).Create(customer)
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -71,33 +130,228 @@ func (h *CustomerHandler) Create(w http.ResponseWriter, r *http.Request) {
 	utils.Created(w, result)
 }
 
-// Update handles PUT /customers/{id}
+// Update handles PUT /customers/{id}. With If-Match the update only applies
+// to the version named by the ETag.
 func (h *CustomerHandler) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
 	var customer types.Customer
 	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
 		utils.BadRequest(w, "Invalid JSON")
 		return
 	}
 
-	result, err := h.repo.Update(id, customer)
+	result, err := h.repoFor(r).WithActor(audit.Actor(r)).Update(id, ver, customer)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCustomerNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, version.ErrConflict):
+			utils.PreconditionFailed(w, errChanged)
+		default:
+			utils.InternalError(w, err.Error())
+		}
+		return
+	}
+	version.SetETag(w, result.Version)
+	utils.Success(w, result)
+}
+
+// Patch handles PATCH /customers/{id}. The body is a JSON Merge Patch
+// (RFC 7396): only the fields it names change, and a field set to null is
+// cleared. With If-Match the patch only applies to the version named by the
+// ETag.
+func (h *CustomerHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
+	patch, err := mergepatch.Read(r)
 	if err != nil {
+		if errors.Is(err, mergepatch.ErrUnsupportedMediaType) {
+			utils.Error(w, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+		utils.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	repo := h.repoFor(r).WithActor(audit.Actor(r))
+	for attempt := 1; ; attempt++ {
+		current, err := h.repoFor(r).GetByID(id)
+		if err != nil {
+			if errors.Is(err, repository.ErrCustomerNotFound) {
+				utils.NotFound(w, err.Error())
+				return
+			}
+			utils.InternalError(w, err.Error())
+			return
+		}
+		if ver != 0 && current.Version != ver {
+			utils.PreconditionFailed(w, errChanged)
+			return
+		}
+
+		base := customerFields{Name: current.Name, Email: current.Email, Phone: current.Phone, Address: current.Address}
+		fields := base
+		if err := mergepatch.Apply(&fields, patch); err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		if fields.Name == "" || fields.Email == "" {
+			utils.BadRequest(w, "name and email are required")
+			return
+		}
+		if fields == base {
+			// Nothing to change
+			version.SetETag(w, current.Version)
+			utils.Success(w, current)
+			return
+		}
+
+		customer := *current
+		customer.Name, customer.Email, customer.Phone, customer.Address = fields.Name, fields.Email, fields.Phone, fields.Address
+
+		// Write against the version the patch was applied to, so a change
+		// made in between is not overwritten
+		result, err := repo.Update(id, current.Version, customer)
+		if errors.Is(err, version.ErrConflict) && ver == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, repository.ErrCustomerNotFound):
+				utils.NotFound(w, err.Error())
+			case errors.Is(err, version.ErrConflict):
+				utils.PreconditionFailed(w, errChanged)
+			default:
+				utils.InternalError(w, err.Error())
+			}
+			return
+		}
+		version.SetETag(w, result.Version)
+		utils.Success(w, result)
+		return
+	}
+}
+
+// Archive handles POST /customers/{id}/archive
+func (h *CustomerHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true)
+}
+
+// Restore handles POST /customers/{id}/restore
+func (h *CustomerHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false)
+}
+
+func (h *CustomerHandler) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	repo := h.repoFor(r).WithActor(audit.Actor(r))
+	id := mux.Vars(r)["id"]
+
+	var result *types.Customer
+	var err error
+	if archived {
+		result, err = repo.Archive(id)
+	} else {
+		result, err = repo.Restore(id)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrCustomerNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
 		utils.InternalError(w, err.Error())
 		return
 	}
+	version.SetETag(w, result.Version)
 	utils.Success(w, result)
 }
 
-// Delete handles DELETE /customers/{id}
+// SetPriceList handles PUT /customers/{id}/price-list. The body names the
+// price list, {"price_list_id": "..."}; null or "" removes the customer's
+// price list.
+func (h *CustomerHandler) SetPriceList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PriceListID string `json:"price_list_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	result, err := h.repoFor(r).WithActor(audit.Actor(r)).SetPriceList(mux.Vars(r)["id"], req.PriceListID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCustomerNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, repository.ErrUnknownPriceList):
+			utils.BadRequest(w, err.Error())
+		default:
+			utils.InternalError(w, err.Error())
+		}
+		return
+	}
+	version.SetETag(w, result.Version)
+	utils.Success(w, result)
+}
+
+// Delete handles DELETE /customers/{id}. A customer with invoices is only
+// deleted, together with its invoices and payments, with ?force=true. With
+// If-Match the customer is only deleted at the version named by the ETag.
 func (h *CustomerHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.repo.Delete(id); err != nil {
-		utils.InternalError(w, err.Error())
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			utils.BadRequest(w, "force must be true or false")
+			return
+		}
+	}
+
+	if err := h.repoFor(r).WithActor(audit.Actor(r)).Delete(id, ver, force); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCustomerNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, version.ErrConflict):
+			utils.PreconditionFailed(w, errChanged)
+		case errors.Is(err, repository.ErrCustomerHasInvoices):
+			utils.Error(w, http.StatusConflict, err.Error()+"; archive the customer instead, or delete with force=true to remove the invoices too")
+		default:
+			utils.InternalError(w, err.Error())
+		}
 		return
 	}
 	utils.Success(w, map[string]string{"message": "Customer deleted successfully"})
 }
+
+// History handles GET /customers/{id}/history
+func (h *CustomerHandler) History(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repoFor(r).History(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
@@ -1,10 +1,38 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
+	"invoice-backend/services/shared/pkg/audit"
 	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/pagination"
 	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrCustomerNotFound is returned for an unknown customer ID
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrCustomerHasInvoices is returned when deleting a customer that still
+	// has invoices without forcing the delete
+	ErrCustomerHasInvoices = errors.New("customer has invoices")
+	// ErrUnknownPriceList is returned when assigning a customer a price list
+	// that does not exist
+	ErrUnknownPriceList = errors.New("price list not found")
+)
+
+// Scope selects customers by archive state
+type Scope string
+
+const (
+	ScopeActive   Scope = "active"
+	ScopeArchived Scope = "archived"
+	ScopeAll      Scope = "all"
 )
 
 type CustomerRepository struct {
@@ -15,28 +43,75 @@ func NewCustomerRepository(db *database.Client) *CustomerRepository {
 	return &CustomerRepository{db: db}
 }
 
-// GetAll returns all customers
-func (r *CustomerRepository) GetAll() ([]types.Customer, error) {
-	var customers []types.Customer
-	_, err := r.db.Supabase.From("customers").
-		Select("*", "", false).
-		ExecuteTo(&customers)
-	return customers, err
+// WithActor returns a repository whose changes the audit log attributes to
+// actor
+func (r *CustomerRepository) WithActor(actor string) *CustomerRepository {
+	return &CustomerRepository{db: r.db.WithActor(actor)}
+}
+
+// WithOrg returns a repository scoped to the organization org
+func (r *CustomerRepository) WithOrg(org string) *CustomerRepository {
+	return &CustomerRepository{db: r.db.WithOrg(org)}
+}
+
+// History returns the audit log entries of a customer, oldest first
+func (r *CustomerRepository) History(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.Customer)
+}
+
+// Sort lists the fields customers can be sorted by
+var Sort = pagination.Sort{Fields: []string{"created_at", "name", "email"}, Default: "created_at"}
+
+// Filter lists the fields customers can be filtered by
+var Filter = filter.Schema{
+	"name":         {Column: "name", Type: filter.String},
+	"email":        {Column: "email", Type: filter.String},
+	"phone":        {Column: "phone", Type: filter.String},
+	"address":      {Column: "address", Type: filter.String},
+	"city":         {Column: "city", Type: filter.String},
+	"postal_code":  {Column: "postal_code", Type: filter.String},
+	"country":      {Column: "country", Type: filter.String},
+	"company_name": {Column: "company_name", Type: filter.String},
+	"created_at":   {Column: "created_at", Type: filter.Timestamp},
+	"archived_at":  {Column: "archived_at", Type: filter.Timestamp},
+}
+
+// customerSelect reads customers with their credit balances embedded
+const customerSelect = "*, credit_balances:customer_credits(currency, balance)"
+
+// GetAll returns one page of the customers in scope matching where (nil for
+// all)
+func (r *CustomerRepository) GetAll(scope Scope, where *filter.Expr, page pagination.Request) ([]types.Customer, *pagination.Meta, error) {
+	apply := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		switch scope {
+		case ScopeActive:
+			query = query.Is("archived_at", "null")
+		case ScopeArchived:
+			query = query.Not("archived_at", "is", "null")
+		}
+		if where != nil {
+			query = query.And(where.PostgREST(), "")
+		}
+		return query
+	}
+
+	return pagination.Fetch(r.db, "customers", customerSelect, page, apply,
+		func(c types.Customer) string { return c.ID })
 }
 
 // GetByID returns a customer by ID
 func (r *CustomerRepository) GetByID(id string) (*types.Customer, error) {
 	var customers []types.Customer
-	_, err := r.db.Supabase.From("customers").
-		Select("*", "", false).
+	_, err := r.db.From("customers").
+		Select(customerSelect, "", false).
 		Eq("id", id).
 		ExecuteTo(&customers)
-	
+
 	if err != nil {
 		return nil, err
 	}
 	if len(customers) == 0 {
-		return nil, fmt.Errorf("customer not found")
+		return nil, ErrCustomerNotFound
 	}
 	return &customers[0], nil
 }
@@ -44,10 +119,10 @@ func (r *CustomerRepository) GetByID(id string) (*types.Customer, error) {
 // Create creates a new customer
 func (r *CustomerRepository) Create(customer types.Customer) (*types.Customer, error) {
 	var result []types.Customer
-	_, err := r.db.Supabase.From("customers").
+	_, err := r.db.From("customers").
 		Insert(customer, false, "", "", "").
 		ExecuteTo(&result)
-	
+
 	if err != nil {
 		return nil, err
 	}
@@ -57,28 +132,121 @@ func (r *CustomerRepository) Create(customer types.Customer) (*types.Customer, e
 	return &result[0], nil
 }
 
-// Update updates a customer
-func (r *CustomerRepository) Update(id string, customer types.Customer) (*types.Customer, error) {
+// Update updates a customer at ver, the version the caller read, returning
+// version.ErrConflict if it has changed since; a ver of 0 updates whatever
+// version is current
+func (r *CustomerRepository) Update(id string, ver int, customer types.Customer) (*types.Customer, error) {
+	customer.Version = 0          // raised by the database
+	customer.CreditBalances = nil // kept by the database
+
 	var result []types.Customer
-	_, err := r.db.Supabase.From("customers").
+	_, err := version.Match(r.db.From("customers").
 		Update(customer, "", "").
+		Eq("id", id), ver).
+		ExecuteTo(&result)
+
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, version.Missed(r.db, "customers", id, ErrCustomerNotFound)
+	}
+	return &result[0], nil
+}
+
+// Archive archives a customer, keeping the original archive time of an
+// archived one
+func (r *CustomerRepository) Archive(id string) (*types.Customer, error) {
+	var result []types.Customer
+	_, err := r.db.From("customers").
+		Update(map[string]interface{}{"archived_at": time.Now().UTC().Format(time.RFC3339)}, "", "").
 		Eq("id", id).
+		Is("archived_at", "null").
 		ExecuteTo(&result)
-	
+
 	if err != nil {
 		return nil, err
 	}
 	if len(result) == 0 {
-		return nil, fmt.Errorf("customer not found")
+		return r.GetByID(id)
 	}
 	return &result[0], nil
 }
 
-// Delete deletes a customer
-func (r *CustomerRepository) Delete(id string) error {
-	_, _, err := r.db.Supabase.From("customers").
-		Delete("", "").
+// Restore brings an archived customer back
+func (r *CustomerRepository) Restore(id string) (*types.Customer, error) {
+	var result []types.Customer
+	_, err := r.db.From("customers").
+		Update(map[string]interface{}{"archived_at": nil}, "", "").
 		Eq("id", id).
-		Execute()
-	return err
+		ExecuteTo(&result)
+
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrCustomerNotFound
+	}
+	return &result[0], nil
+}
+
+// SetPriceList assigns a customer a price list, or none for an empty
+// priceListID
+func (r *CustomerRepository) SetPriceList(id, priceListID string) (*types.Customer, error) {
+	var listID interface{}
+	if priceListID != "" {
+		var lists []struct {
+			ID string `json:"id"`
+		}
+		_, err := r.db.From("price_lists").Select("id", "", false).Eq("id", priceListID).ExecuteTo(&lists)
+		if err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPriceList, priceListID)
+		}
+		listID = priceListID
+	}
+
+	var result []types.Customer
+	_, err := r.db.From("customers").
+		Update(map[string]interface{}{"price_list_id": listID}, "", "").
+		Eq("id", id).
+		ExecuteTo(&result)
+
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrCustomerNotFound
+	}
+	return &result[0], nil
+}
+
+// Delete deletes a customer through the delete_customer function. A customer
+// with invoices is refused unless force is set, which deletes the invoices
+// and their payments too. A ver other than 0 only deletes the customer at
+// that version.
+func (r *CustomerRepository) Delete(id string, ver int, force bool) error {
+	args := map[string]interface{}{"p_customer_id": id, "p_force": force}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+	var deleted int
+	err := r.db.RPC("delete_customer", args, &deleted)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return ErrCustomerNotFound
+			case "PT409":
+				return fmt.Errorf("%w: %s", ErrCustomerHasInvoices, rpcErr.Message)
+			case "PT412":
+				return version.ErrConflict
+			}
+		}
+		return err
+	}
+	return nil
 }
@@ -4,11 +4,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"invoice-backend/services/invoice-service/internal/handler"
 	"invoice-backend/services/invoice-service/internal/repository"
 	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/lifecycle"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -34,16 +37,52 @@ func main() {
 	r.HandleFunc("/invoices/{id}", h.GetByID).Methods("GET")
 	r.HandleFunc("/invoices", h.Create).Methods("POST")
 	r.HandleFunc("/invoices/{id}", h.Update).Methods("PUT")
+	r.HandleFunc("/invoices/{id}", h.Patch).Methods("PATCH")
 	r.HandleFunc("/invoices/{id}", h.Delete).Methods("DELETE")
+	r.HandleFunc("/invoices/{id}/issue", h.Transition(lifecycle.Issued)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/send", h.Transition(lifecycle.Sent)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/void", h.Transition(lifecycle.Void)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/write-off", h.Transition(lifecycle.WrittenOff)).Methods("POST")
 	r.HandleFunc("/invoices/{id}/pdf", h.GeneratePDF).Methods("GET")
+	r.HandleFunc("/invoices/{id}/credit-notes", h.GetInvoiceCreditNotes).Methods("GET")
+	r.HandleFunc("/invoices/{id}/credit-notes", h.CreateCreditNote).Methods("POST")
+	r.HandleFunc("/invoices/{id}/apply-credit", h.ApplyCredit).Methods("POST")
+	r.HandleFunc("/invoices/{id}/credit-applications", h.GetInvoiceCreditApplications).Methods("GET")
+	r.HandleFunc("/credit-applications/{id}/void", h.VoidCreditApplication).Methods("POST")
 	r.HandleFunc("/currency-rates", h.GetCurrencyRates).Methods("GET")
+	r.HandleFunc("/invoices/{id}/history", h.History).Methods("GET")
+	r.HandleFunc("/credit-notes", h.GetCreditNotes).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}", h.GetCreditNote).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}/pdf", h.CreditNotePDF).Methods("GET")
+	r.HandleFunc("/credit-notes/{id}/history", h.CreditNoteHistory).Methods("GET")
+	r.HandleFunc("/products", h.GetProducts).Methods("GET")
+	r.HandleFunc("/products", h.CreateProduct).Methods("POST")
+	r.HandleFunc("/products/{id}", h.GetProduct).Methods("GET")
+	r.HandleFunc("/products/{id}", h.UpdateProduct).Methods("PUT")
+	r.HandleFunc("/products/{id}", h.DeleteProduct).Methods("DELETE")
+	r.HandleFunc("/products/{id}/history", h.ProductHistory).Methods("GET")
+	r.HandleFunc("/price-lists", h.GetPriceLists).Methods("GET")
+	r.HandleFunc("/price-lists", h.CreatePriceList).Methods("POST")
+	r.HandleFunc("/price-lists/{id}", h.GetPriceList).Methods("GET")
+	r.HandleFunc("/price-lists/{id}", h.UpdatePriceList).Methods("PUT")
+	r.HandleFunc("/price-lists/{id}", h.DeletePriceList).Methods("DELETE")
+	r.HandleFunc("/price-lists/{id}/history", h.PriceListHistory).Methods("GET")
+	r.HandleFunc("/company-profiles", h.GetCompanies).Methods("GET")
+	r.HandleFunc("/company-profiles", h.CreateCompany).Methods("POST")
+	r.HandleFunc("/company-profiles/{id}", h.GetCompany).Methods("GET")
+	r.HandleFunc("/company-profiles/{id}", h.UpdateCompany).Methods("PUT")
+	r.HandleFunc("/company-profiles/{id}", h.DeleteCompany).Methods("DELETE")
+	r.HandleFunc("/company-profiles/{id}/history", h.CompanyHistory).Methods("GET")
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy","service":"invoice-service"}`))
 	}).Methods("GET")
 
+	// Open invoices past their due date become overdue within the hour
+	go h.MarkOverdue(time.Hour)
+
 	// Apply middleware (no CORS - handled by API Gateway)
-	handler := middleware.Recovery(middleware.Logger("INVOICE-SERVICE")(r))
+	handler := middleware.Recovery(middleware.Logger("INVOICE-SERVICE")(tenant.FromEnv().WithLookup(db.OrgExists).Middleware(r)))
 
 	// Start server
 	port := os.Getenv("INVOICE_SERVICE_PORT")
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"invoice-backend/services/invoice-service/internal/pdf"
+	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/gorilla/mux"
+)
+
+// errCompanyChanged answers a write whose If-Match names a version of the
+// company profile that is no longer current
+const errCompanyChanged = "company profile was changed by someone else; reload it and try again"
+
+// companyFields are the fields of a company profile a client writes
+type companyFields struct {
+	Name              string `json:"name"`
+	LegalName         string `json:"legal_name,omitempty"`
+	TaxID             string `json:"tax_id,omitempty"`
+	Address           string `json:"address,omitempty"`
+	City              string `json:"city,omitempty"`
+	PostalCode        string `json:"postal_code,omitempty"`
+	Country           string `json:"country,omitempty"`
+	Email             string `json:"email,omitempty"`
+	Phone             string `json:"phone,omitempty"`
+	Website           string `json:"website,omitempty"`
+	BankName          string `json:"bank_name,omitempty"`
+	BankAccountName   string `json:"bank_account_name,omitempty"`
+	BankAccountNumber string `json:"bank_account_number,omitempty"`
+	BankSwift         string `json:"bank_swift,omitempty"`
+	Logo              string `json:"logo,omitempty"` // PNG or JPEG data URL
+	IsDefault         bool   `json:"is_default,omitempty"`
+}
+
+// profile returns the company profile the fields describe, trimmed and
+// checked
+func (f companyFields) profile() (types.CompanyProfile, error) {
+	profile := types.CompanyProfile{
+		Name:              strings.TrimSpace(f.Name),
+		LegalName:         strings.TrimSpace(f.LegalName),
+		TaxID:             strings.TrimSpace(f.TaxID),
+		Address:           strings.TrimSpace(f.Address),
+		City:              strings.TrimSpace(f.City),
+		PostalCode:        strings.TrimSpace(f.PostalCode),
+		Country:           strings.TrimSpace(f.Country),
+		Email:             strings.TrimSpace(f.Email),
+		Phone:             strings.TrimSpace(f.Phone),
+		Website:           strings.TrimSpace(f.Website),
+		BankName:          strings.TrimSpace(f.BankName),
+		BankAccountName:   strings.TrimSpace(f.BankAccountName),
+		BankAccountNumber: strings.TrimSpace(f.BankAccountNumber),
+		BankSwift:         strings.TrimSpace(f.BankSwift),
+		Logo:              strings.TrimSpace(f.Logo),
+		IsDefault:         f.IsDefault,
+	}
+	return profile, profile.Validate()
+}
+
+// GetCompanies handles GET /company-profiles, default profile first
+func (h *InvoiceHandler) GetCompanies(w http.ResponseWriter, r *http.Request) {
+	profiles, err := h.repo.GetCompanies()
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, profiles)
+}
+
+// GetCompany handles GET /company-profiles/{id}
+func (h *InvoiceHandler) GetCompany(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.repo.GetCompany(mux.Vars(r)["id"])
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	version.SetETag(w, profile.Version)
+	utils.Success(w, profile)
+}
+
+// CreateCompany handles POST /company-profiles. invoice_prefix starts the
+// numbers of the company's invoices.
+func (h *InvoiceHandler) CreateCompany(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		companyFields
+		InvoicePrefix string `json:"invoice_prefix"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	profile, err := req.profile()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	prefix := strings.TrimSpace(req.InvoicePrefix)
+	if prefix == "" || len(prefix) > 20 {
+		utils.BadRequest(w, "invoice_prefix is required, up to 20 characters")
+		return
+	}
+
+	created, err := h.repo.WithActor(audit.Actor(r)).SaveCompany("", 0, profile, prefix)
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	version.SetETag(w, created.Version)
+	utils.Created(w, created)
+}
+
+// UpdateCompany handles PUT /company-profiles/{id}, replacing every field of
+// the profile. is_default true makes the profile the default; the default
+// cannot be unset, only given to another profile. With If-Match the update
+// only applies to the version named by the ETag.
+func (h *InvoiceHandler) UpdateCompany(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errCompanyChanged)
+		return
+	}
+
+	var req companyFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	profile, err := req.profile()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	updated, err := h.repo.WithActor(audit.Actor(r)).SaveCompany(id, ver, profile, "")
+	if err != nil {
+		companyError(w, err)
+		return
+	}
+
+	version.SetETag(w, updated.Version)
+	utils.Success(w, updated)
+}
+
+// DeleteCompany handles DELETE /company-profiles/{id}. Only a profile that
+// is not the default and never issued an invoice can be deleted. With
+// If-Match the profile is only deleted at the version named by the ETag.
+func (h *InvoiceHandler) DeleteCompany(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errCompanyChanged)
+		return
+	}
+
+	if err := h.repo.WithActor(audit.Actor(r)).DeleteCompany(mux.Vars(r)["id"], ver); err != nil {
+		companyError(w, err)
+		return
+	}
+
+	utils.Success(w, map[string]string{"message": "Company profile deleted successfully"})
+}
+
+// CompanyHistory handles GET /company-profiles/{id}/history
+func (h *InvoiceHandler) CompanyHistory(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repo.CompanyHistory(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// companyError answers a failed company profile request
+func companyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrCompanyNotFound):
+		utils.NotFound(w, err.Error())
+	case errors.Is(err, version.ErrConflict):
+		utils.PreconditionFailed(w, errCompanyChanged)
+	case errors.Is(err, repository.ErrPrefixTaken), errors.Is(err, repository.ErrDefaultCompany),
+		errors.Is(err, repository.ErrCompanyHasInvoices):
+		utils.Error(w, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(w, err.Error())
+	}
+}
+
+// pdfCompany returns the details of a company profile printed on its
+// invoices. A logo that cannot be decoded is left out.
+func pdfCompany(p *types.CompanyProfile) pdf.Company {
+	logo, logoType, _ := p.LogoImage()
+	return pdf.Company{
+		Name:              p.Name,
+		LegalName:         p.LegalName,
+		TaxID:             p.TaxID,
+		Address:           p.Address,
+		City:              p.City,
+		PostalCode:        p.PostalCode,
+		Country:           p.Country,
+		Email:             p.Email,
+		Phone:             p.Phone,
+		Website:           p.Website,
+		BankName:          p.BankName,
+		BankAccountName:   p.BankAccountName,
+		BankAccountNumber: p.BankAccountNumber,
+		BankSwift:         p.BankSwift,
+		Logo:              logo,
+		LogoType:          logoType,
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/gorilla/mux"
+)
+
+// errCreditApplicationChanged answers a void whose If-Match names a version
+// of the credit application that is no longer current
+const errCreditApplicationChanged = "credit application was changed by someone else; reload it and try again"
+
+// ApplyCredit handles POST /invoices/{id}/apply-credit, paying the invoice
+// from its customer's credit in the invoice's currency with a credit
+// application: {"amount": 50}. Without an amount, as much is applied as
+// there is credit and is owed.
+func (h *InvoiceHandler) ApplyCredit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount money.Amount `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+	if req.Amount < 0 {
+		utils.BadRequest(w, "amount must not be negative")
+		return
+	}
+
+	application, err := h.repoFor(r).WithActor(audit.Actor(r)).ApplyCredit(mux.Vars(r)["id"], req.Amount, audit.Actor(r))
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientCredit) || errors.Is(err, repository.ErrCreditExceedsBalance) {
+			utils.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	version.SetETag(w, application.Version)
+	utils.Created(w, application)
+}
+
+// GetInvoiceCreditApplications handles GET
+// /invoices/{id}/credit-applications
+func (h *InvoiceHandler) GetInvoiceCreditApplications(w http.ResponseWriter, r *http.Request) {
+	applications, err := h.repoFor(r).GetCreditApplications(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, applications)
+}
+
+// VoidCreditApplication handles POST /credit-applications/{id}/void, giving
+// the credit back to the customer; it requires If-Match and a reason
+func (h *InvoiceHandler) VoidCreditApplication(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errCreditApplicationChanged)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		utils.BadRequest(w, "reason required")
+		return
+	}
+
+	application, err := h.repoFor(r).WithActor(audit.Actor(r)).VoidCreditApplication(mux.Vars(r)["id"], ver, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCreditApplicationNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, version.ErrConflict):
+			utils.PreconditionFailed(w, errCreditApplicationChanged)
+		case errors.Is(err, repository.ErrCreditApplicationVoided):
+			utils.Error(w, http.StatusConflict, err.Error())
+		default:
+			utils.InternalError(w, err.Error())
+		}
+		return
+	}
+
+	version.SetETag(w, application.Version)
+	utils.Success(w, application)
+}
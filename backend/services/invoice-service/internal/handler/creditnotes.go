@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"invoice-backend/services/invoice-service/internal/pdf"
+	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/pricing"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateCreditNote handles POST /invoices/{id}/credit-notes. The body gives
+// the reason and, for a partial credit, the lines to credit by quantity or
+// amount: {"reason": "...", "items": [{"invoice_item_id": "...",
+// "quantity": 2}]}. Crediting the whole balance takes "full": true instead
+// of items; a body with neither, or with unknown fields, is refused. With
+// If-Match the invoice is only credited at the version named by the ETag.
+func (h *InvoiceHandler) CreateCreditNote(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
+	var req struct {
+		Reason string               `json:"reason"`
+		Items  []pricing.CreditLine `json:"items,omitempty"`
+		Full   bool                 `json:"full,omitempty"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON: "+strings.TrimPrefix(err.Error(), "json: "))
+		return
+	}
+	if msg := creditScope(req.Full, req.Items); msg != "" {
+		utils.BadRequest(w, msg)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		utils.BadRequest(w, "reason is required")
+		return
+	}
+
+	repo := h.repoFor(r).WithActor(audit.Actor(r))
+	for attempt := 1; ; attempt++ {
+		invoice, err := repo.GetByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if ver != 0 && invoice.Version != ver {
+			utils.PreconditionFailed(w, errChanged)
+			return
+		}
+		if err := lifecycle.Creditable(invoice.Status); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Earlier credit notes tell what is left of each line
+		notes, err := repo.GetCreditNotesByInvoice(id)
+		if err != nil {
+			utils.InternalError(w, err.Error())
+			return
+		}
+
+		note, err := pricing.Credit(invoice, notes, req.Items)
+		if err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		note.Reason = req.Reason
+		note.CreatedBy = audit.Actor(r)
+
+		// Credit the version the note was priced from, so a payment or
+		// credit made in between is not credited twice
+		created, err := repo.CreateCreditNote(invoice.Version, *note)
+		if errors.Is(err, version.ErrConflict) && ver == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		utils.Created(w, created)
+		return
+	}
+}
+
+// GetInvoiceCreditNotes handles GET /invoices/{id}/credit-notes
+func (h *InvoiceHandler) GetInvoiceCreditNotes(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.repoFor(r).GetCreditNotesByInvoice(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, notes)
+}
+
+// GetCreditNotes handles GET /credit-notes
+func (h *InvoiceHandler) GetCreditNotes(w http.ResponseWriter, r *http.Request) {
+	where, err := filter.Parse(r.URL.Query().Get("filter"), repository.CreditNoteFilter)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := pagination.Parse(r, repository.CreditNoteSort)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	notes, meta, err := h.repoFor(r).GetCreditNotes(where, page)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalid) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Paginated(w, r, notes, meta)
+}
+
+// GetCreditNote handles GET /credit-notes/{id}
+func (h *InvoiceHandler) GetCreditNote(w http.ResponseWriter, r *http.Request) {
+	note, err := h.repoFor(r).GetCreditNote(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, repository.ErrCreditNoteNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, note)
+}
+
+// CreditNotePDF handles GET /credit-notes/{id}/pdf
+func (h *InvoiceHandler) CreditNotePDF(w http.ResponseWriter, r *http.Request) {
+	repo := h.repoFor(r)
+	note, err := repo.GetCreditNote(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, repository.ErrCreditNoteNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		}
+		utils.InternalError(w, err.Error())
+		return
+	}
+
+	// The credited invoice gives the company and customer printed on it
+	invoice, err := repo.GetByID(note.InvoiceID)
+	if err != nil {
+		utils.InternalError(w, "Failed to get invoice")
+		return
+	}
+	customer, err := repo.GetCustomer(invoice.CustomerID)
+	if err != nil {
+		utils.InternalError(w, "Failed to get customer")
+		return
+	}
+	company, err := repo.GetCompany(invoice.CompanyID)
+	if err != nil {
+		utils.InternalError(w, "Failed to get company profile")
+		return
+	}
+
+	pdfItems := make([]pdf.Item, len(note.Items))
+	taxed := make([]types.Item, len(note.Items))
+	for i, item := range note.Items {
+		pdfItems[i] = pdf.Item{
+			SKU:         item.SKU,
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.Total,
+		}
+		taxed[i] = types.Item{TaxRate: item.TaxRate, Total: item.Total}
+	}
+
+	// Tax per rate, as the credited lines were taxed on the invoice
+	taxes, err := pricing.Taxes(taxed, invoice.Tax, note.Currency)
+	if err != nil {
+		utils.InternalError(w, "Failed to generate PDF: "+err.Error())
+		return
+	}
+	var pdfTaxes []pdf.TaxLine
+	for _, t := range taxes {
+		pdfTaxes = append(pdfTaxes, pdf.TaxLine{Rate: t.Rate, Amount: t.Amount})
+	}
+
+	pdfBytes, err := pdf.GenerateCreditNotePDF(pdf.CreditNote{
+		Invoice: pdf.Invoice{
+			ID:              note.CreditNoteNumber,
+			Company:         pdfCompany(company),
+			CustomerName:    customer.Name,
+			CustomerAddress: customer.Address,
+			CustomerEmail:   customer.Email,
+			CustomerPhone:   customer.Phone,
+			Items:           pdfItems,
+			Subtotal:        note.Subtotal,
+			Tax:             invoice.Tax,
+			Taxes:           pdfTaxes,
+			Discount:        note.Discount,
+			Total:           note.Total,
+			Currency:        note.Currency,
+		},
+		Number:        note.CreditNoteNumber,
+		InvoiceNumber: invoice.InvoiceNumber,
+		Reason:        note.Reason,
+		IssueDate:     dateOnly(note.CreatedAt),
+	})
+	if err != nil {
+		utils.InternalError(w, "Failed to generate PDF: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=credit-note-"+note.CreditNoteNumber+".pdf")
+	w.Write(pdfBytes)
+}
+
+// CreditNoteHistory handles GET /credit-notes/{id}/history
+func (h *InvoiceHandler) CreditNoteHistory(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repoFor(r).CreditNoteHistory(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// dateOnly returns the date part of a date or timestamp
+func dateOnly(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s
+}
+
+// creditScope checks that a credit note request either credits the whole
+// balance or names the lines to credit, and returns why not
+func creditScope(full bool, items []pricing.CreditLine) string {
+	switch {
+	case full && len(items) > 0:
+		return "full and items are exclusive"
+	case !full && len(items) == 0:
+		return `items required; send "full": true to credit the whole balance`
+	}
+	return ""
+}
@@ -2,16 +2,43 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"time"
 
 	"invoice-backend/services/invoice-service/internal/pdf"
 	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/mergepatch"
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/pricing"
+	"invoice-backend/services/shared/pkg/tenant"
 	"invoice-backend/services/shared/pkg/types"
 	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
 
 	"github.com/gorilla/mux"
 )
 
+// errChanged answers a write whose If-Match names a version of the invoice
+// that is no longer current
+const errChanged = "invoice was changed by someone else; reload it and try again"
+
+// patchAttempts bounds how often a PATCH without If-Match is reapplied when
+// the invoice changes between reading it and writing the patched fields
+const patchAttempts = 3
+
+// invoiceFields are the fields of an invoice a client can patch
+type invoiceFields struct {
+	Status string `json:"status,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
 type InvoiceHandler struct {
 	repo *repository.InvoiceRepository
 }
@@ -20,17 +47,38 @@ func NewInvoiceHandler(repo *repository.InvoiceRepository) *InvoiceHandler {
 	return &InvoiceHandler{repo: repo}
 }
 
+// repoFor returns the repository scoped to the organization of a request
+func (h *InvoiceHandler) repoFor(r *http.Request) *repository.InvoiceRepository {
+	return h.repo.WithOrg(tenant.Org(r))
+}
+
 // GetAll handles GET /invoices
 func (h *InvoiceHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	status := r.URL.Query().Get("status")
 	currency := r.URL.Query().Get("currency")
 
-	invoices, err := h.repo.GetAll(status, currency)
+	where, err := filter.Parse(r.URL.Query().Get("filter"), repository.Filter)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := pagination.Parse(r, repository.Sort)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	invoices, meta, err := h.repoFor(r).GetAll(status, currency, where, page)
 	if err != nil {
+		if errors.Is(err, pagination.ErrInvalid) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
 		utils.InternalError(w, err.Error())
 		return
 	}
-	utils.Success(w, invoices)
+	utils.Paginated(w, r, invoices, meta)
 }
 
 // GetByID handles GET /invoices/{id}
@@ -38,25 +86,24 @@ func (h *InvoiceHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	invoice, err := h.repo.GetByID(id)
+	invoice, err := h.repoFor(r).GetByID(id)
 	if err != nil {
 		utils.NotFound(w, err.Error())
 		return
 	}
 
-	// Get items
-	invoice.Items, _ = h.repo.GetItems(invoice.ID)
-	
+	version.SetETag(w, invoice.Version)
 	utils.Success(w, invoice)
 }
 
 // Create handles POST /invoices
 func (h *InvoiceHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req struct {
+		CompanyID  string       `json:"company_id,omitempty"` // Issuing company; the default profile when empty
 		CustomerID string       `json:"customer_id"`
 		Items      []types.Item `json:"items"`
 		Tax        float64      `json:"tax,omitempty"`      // Tax percentage
-		Discount   float64      `json:"discount,omitempty"` // Discount amount
+		Discount   money.Amount `json:"discount,omitempty"` // Discount amount
 		Status     string       `json:"status,omitempty"`
 		Notes      string       `json:"notes,omitempty"`
 		DueDate    string       `json:"due_date,omitempty"`
@@ -73,8 +120,23 @@ func (h *InvoiceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invoice, err := h.repo.Create(req.CustomerID, req.Items, req.Tax, req.Discount, req.Status, req.Notes, req.DueDate, req.Currency)
+	if req.CompanyID != "" {
+		if _, err := h.repoFor(r).GetCompany(req.CompanyID); err != nil {
+			if errors.Is(err, repository.ErrCompanyNotFound) {
+				utils.BadRequest(w, err.Error())
+				return
+			}
+			utils.InternalError(w, err.Error())
+			return
+		}
+	}
+
+	invoice, err := h.repoFor(r).WithActor(audit.Actor(r)).Create(req.CompanyID, req.CustomerID, req.Items, req.Tax, req.Discount, req.Status, req.Notes, req.DueDate, req.Currency)
 	if err != nil {
+		if errors.Is(err, pricing.ErrInvalid) || errors.Is(err, lifecycle.ErrUnknownStatus) || errors.Is(err, lifecycle.ErrNotAllowed) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
 		utils.InternalError(w, err.Error())
 		return
 	}
@@ -82,11 +144,19 @@ func (h *InvoiceHandler) Create(w http.ResponseWriter, r *http.Request) {
 	utils.Created(w, invoice)
 }
 
-// Update handles PUT /invoices/{id}
+// Update handles PUT /invoices/{id}. An empty status keeps the status; any
+// other must be a transition the invoice's status allows. With If-Match the
+// update only applies to the version named by the ETag.
 func (h *InvoiceHandler) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
 	var req struct {
 		Status string `json:"status"`
 		Notes  string `json:"notes"`
@@ -97,22 +167,148 @@ func (h *InvoiceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invoice, err := h.repo.Update(id, req.Status, req.Notes)
+	invoice, err := h.repoFor(r).WithActor(audit.Actor(r)).Update(id, ver, req.Status, req.Notes)
 	if err != nil {
-		utils.InternalError(w, err.Error())
+		writeError(w, err)
 		return
 	}
 
+	version.SetETag(w, invoice.Version)
 	utils.Success(w, invoice)
 }
 
-// Delete handles DELETE /invoices/{id}
+// Patch handles PATCH /invoices/{id}. The body is a JSON Merge Patch
+// (RFC 7396): only the fields it names change, and a field set to null is
+// cleared. With If-Match the patch only applies to the version named by the
+// ETag.
+func (h *InvoiceHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
+	patch, err := mergepatch.Read(r)
+	if err != nil {
+		if errors.Is(err, mergepatch.ErrUnsupportedMediaType) {
+			utils.Error(w, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+		utils.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	repo := h.repoFor(r).WithActor(audit.Actor(r))
+	for attempt := 1; ; attempt++ {
+		current, err := h.repoFor(r).GetByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if ver != 0 && current.Version != ver {
+			utils.PreconditionFailed(w, errChanged)
+			return
+		}
+
+		base := invoiceFields{Status: current.Status, Notes: current.Notes}
+		fields := base
+		if err := mergepatch.Apply(&fields, patch); err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		if fields.Status == "" {
+			fields.Status = current.Status
+		}
+		if fields == base {
+			// Nothing to change
+			version.SetETag(w, current.Version)
+			utils.Success(w, current)
+			return
+		}
+
+		// Write against the version the patch was applied to, so a change
+		// made in between is not overwritten. An unchanged status is left
+		// out, as the trigger would settle it anyway.
+		status := fields.Status
+		if status == current.Status {
+			status = ""
+		}
+		invoice, err := repo.Update(id, current.Version, status, fields.Notes)
+		if errors.Is(err, version.ErrConflict) && ver == 0 && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		version.SetETag(w, invoice.Version)
+		utils.Success(w, invoice)
+		return
+	}
+}
+
+// Transition returns the handler of POST /invoices/{id}/issue, /send, /void
+// and /write-off, which move an invoice to status. The optional body
+// {"reason": "..."} records why an invoice was voided or written off. With
+// If-Match the transition only applies to the version named by the ETag.
+func (h *InvoiceHandler) Transition(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ver, ok := version.IfMatch(r)
+		if !ok {
+			utils.PreconditionFailed(w, errChanged)
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			utils.BadRequest(w, "Invalid JSON")
+			return
+		}
+
+		invoice, err := h.repoFor(r).WithActor(audit.Actor(r)).Transition(mux.Vars(r)["id"], ver, status, req.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		version.SetETag(w, invoice.Version)
+		utils.Success(w, invoice)
+	}
+}
+
+// MarkOverdue marks the invoices that passed their due date overdue, now and
+// then every interval
+func (h *InvoiceHandler) MarkOverdue(interval time.Duration) {
+	for {
+		n, err := h.repo.MarkOverdue()
+		if err != nil {
+			log.Printf("Failed to mark overdue invoices: %v", err)
+		} else if n > 0 {
+			log.Printf("Marked %d invoices overdue", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Delete handles DELETE /invoices/{id}. With If-Match the invoice is only
+// deleted at the version named by the ETag.
 func (h *InvoiceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.repo.Delete(id); err != nil {
-		utils.InternalError(w, err.Error())
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errChanged)
+		return
+	}
+
+	if err := h.repoFor(r).WithActor(audit.Actor(r)).Delete(id, ver); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -125,45 +321,66 @@ func (h *InvoiceHandler) GeneratePDF(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	// Get invoice
-	invoice, err := h.repo.GetByID(id)
+	invoice, err := h.repoFor(r).GetByID(id)
 	if err != nil {
 		utils.NotFound(w, "Invoice not found")
 		return
 	}
 
-	// Get items
-	items, err := h.repo.GetItems(id)
+	// Get customer
+	customer, err := h.repoFor(r).GetCustomer(invoice.CustomerID)
 	if err != nil {
-		utils.InternalError(w, "Failed to get invoice items")
+		utils.InternalError(w, "Failed to get customer")
 		return
 	}
 
-	// Get customer
-	customer, err := h.repo.GetCustomer(invoice.CustomerID)
+	// Get the company that issued the invoice
+	company, err := h.repoFor(r).GetCompany(invoice.CompanyID)
 	if err != nil {
-		utils.InternalError(w, "Failed to get customer")
+		utils.InternalError(w, "Failed to get company profile")
 		return
 	}
 
 	// Convert to PDF invoice type
-	pdfItems := make([]pdf.Item, len(items))
-	for i, item := range items {
+	pdfItems := make([]pdf.Item, len(invoice.Items))
+	for i, item := range invoice.Items {
 		pdfItems[i] = pdf.Item{
+			SKU:         item.SKU,
 			Description: item.Description,
 			Quantity:    item.Quantity,
+			Unit:        item.Unit,
 			UnitPrice:   item.UnitPrice,
+			PriceRule:   item.PriceRule,
+			Discount:    item.Discount,
+			Total:       item.Total,
 		}
 	}
-	
+
+	// Tax per rate, from the lines' own rates and the invoice's
+	taxes, err := pricing.Taxes(invoice.Items, invoice.Tax, invoice.Currency)
+	if err != nil {
+		utils.InternalError(w, "Failed to generate PDF: "+err.Error())
+		return
+	}
+	pdfTaxes := make([]pdf.TaxLine, len(taxes))
+	for i, t := range taxes {
+		pdfTaxes[i] = pdf.TaxLine{Rate: t.Rate, Amount: t.Amount}
+	}
+
 	pdfInvoice := pdf.Invoice{
-		ID:           invoice.InvoiceNumber,
-		CustomerName: customer.Name,
+		ID:              invoice.InvoiceNumber,
+		Company:         pdfCompany(company),
+		CustomerName:    customer.Name,
 		CustomerAddress: customer.Address,
-		CustomerEmail: customer.Email,
-		CustomerPhone: customer.Phone,
-		Items:        pdfItems,
-		Total:        invoice.Total,
-		Currency:     invoice.Currency,
+		CustomerEmail:   customer.Email,
+		CustomerPhone:   customer.Phone,
+		Items:           pdfItems,
+		Subtotal:        invoice.Subtotal,
+		Tax:             invoice.Tax,
+		Taxes:           pdfTaxes,
+		Discount:        invoice.Discount,
+		Total:           invoice.Total,
+		Currency:        invoice.Currency,
 	}
 
 	// Generate PDF
@@ -181,10 +398,36 @@ func (h *InvoiceHandler) GeneratePDF(w http.ResponseWriter, r *http.Request) {
 
 // GetCurrencyRates handles GET /currency-rates
 func (h *InvoiceHandler) GetCurrencyRates(w http.ResponseWriter, r *http.Request) {
-	rates, err := h.repo.GetCurrencyRates()
+	rates, err := h.repoFor(r).GetCurrencyRates()
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
 	}
 	utils.Success(w, rates)
 }
+
+// History handles GET /invoices/{id}/history
+func (h *InvoiceHandler) History(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repoFor(r).History(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// writeError answers a failed write to an invoice
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrInvoiceNotFound):
+		utils.NotFound(w, err.Error())
+	case errors.Is(err, version.ErrConflict):
+		utils.PreconditionFailed(w, errChanged)
+	case errors.Is(err, lifecycle.ErrUnknownStatus):
+		utils.BadRequest(w, err.Error())
+	case errors.Is(err, lifecycle.ErrNotAllowed):
+		utils.Error(w, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(w, err.Error())
+	}
+}
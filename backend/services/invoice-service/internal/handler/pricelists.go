@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/pricing"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/gorilla/mux"
+)
+
+// errPriceListChanged answers a write whose If-Match names a version of the
+// price list that is no longer current
+const errPriceListChanged = "price list was changed by someone else; reload it and try again"
+
+// priceListFields are the fields of a price list a client writes
+type priceListFields struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Currency    string            `json:"currency"`
+	Rules       []types.PriceRule `json:"rules"`
+}
+
+// priceList returns the price list the fields describe, checked and
+// normalized
+func (f priceListFields) priceList() (types.PriceList, error) {
+	list := types.PriceList{
+		Name:        f.Name,
+		Description: f.Description,
+		Currency:    f.Currency,
+		Rules:       f.Rules,
+	}
+	if list.Rules == nil {
+		list.Rules = []types.PriceRule{}
+	}
+	return list, pricing.CheckPriceList(&list)
+}
+
+// GetPriceLists handles GET /price-lists
+func (h *InvoiceHandler) GetPriceLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.repo.GetPriceLists()
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, lists)
+}
+
+// GetPriceList handles GET /price-lists/{id}
+func (h *InvoiceHandler) GetPriceList(w http.ResponseWriter, r *http.Request) {
+	list, err := h.repo.GetPriceList(mux.Vars(r)["id"])
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	version.SetETag(w, list.Version)
+	utils.Success(w, list)
+}
+
+// CreatePriceList handles POST /price-lists
+func (h *InvoiceHandler) CreatePriceList(w http.ResponseWriter, r *http.Request) {
+	var req priceListFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	list, err := req.priceList()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	created, err := h.repo.WithActor(audit.Actor(r)).SavePriceList("", 0, list)
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	version.SetETag(w, created.Version)
+	utils.Created(w, created)
+}
+
+// UpdatePriceList handles PUT /price-lists/{id}, replacing every field and
+// rule of the price list. With If-Match the update only applies to the
+// version named by the ETag.
+func (h *InvoiceHandler) UpdatePriceList(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errPriceListChanged)
+		return
+	}
+
+	var req priceListFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	list, err := req.priceList()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	updated, err := h.repo.WithActor(audit.Actor(r)).SavePriceList(id, ver, list)
+	if err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	version.SetETag(w, updated.Version)
+	utils.Success(w, updated)
+}
+
+// DeletePriceList handles DELETE /price-lists/{id}. Customers on the price
+// list go back to catalog prices; invoices keep the prices it set. With
+// If-Match the price list is only deleted at the version named by the ETag.
+func (h *InvoiceHandler) DeletePriceList(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errPriceListChanged)
+		return
+	}
+
+	if err := h.repo.WithActor(audit.Actor(r)).DeletePriceList(mux.Vars(r)["id"], ver); err != nil {
+		priceListError(w, err)
+		return
+	}
+
+	utils.Success(w, map[string]string{"message": "Price list deleted successfully"})
+}
+
+// PriceListHistory handles GET /price-lists/{id}/history
+func (h *InvoiceHandler) PriceListHistory(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repo.PriceListHistory(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// priceListError answers a failed price list request
+func priceListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrPriceListNotFound):
+		utils.NotFound(w, err.Error())
+	case errors.Is(err, version.ErrConflict):
+		utils.PreconditionFailed(w, errPriceListChanged)
+	case errors.Is(err, repository.ErrUnknownProduct):
+		utils.BadRequest(w, err.Error())
+	default:
+		utils.InternalError(w, err.Error())
+	}
+}
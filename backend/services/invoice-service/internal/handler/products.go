@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"invoice-backend/services/invoice-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/pricing"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/gorilla/mux"
+)
+
+// errProductChanged answers a write whose If-Match names a version of the
+// product that is no longer current
+const errProductChanged = "product was changed by someone else; reload it and try again"
+
+// productFields are the fields of a product a client writes
+type productFields struct {
+	SKU         string               `json:"sku"`
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	Unit        string               `json:"unit,omitempty"`
+	TaxRate     *float64             `json:"tax_rate,omitempty"`
+	Prices      []types.ProductPrice `json:"prices"`
+	Active      *bool                `json:"active,omitempty"` // Defaults to true
+}
+
+// product returns the product the fields describe, checked and normalized
+func (f productFields) product() (types.Product, error) {
+	product := types.Product{
+		SKU:         f.SKU,
+		Name:        f.Name,
+		Description: f.Description,
+		Unit:        f.Unit,
+		TaxRate:     f.TaxRate,
+		Prices:      f.Prices,
+		Active:      f.Active == nil || *f.Active,
+	}
+	if product.Prices == nil {
+		product.Prices = []types.ProductPrice{}
+	}
+	return product, pricing.CheckProduct(&product)
+}
+
+// GetProducts handles GET /products. active=true or active=false lists only
+// active or inactive products.
+func (h *InvoiceHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
+	where, err := filter.Parse(r.URL.Query().Get("filter"), repository.ProductFilter)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := pagination.Parse(r, repository.ProductSort)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	var active *bool
+	if v := r.URL.Query().Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.BadRequest(w, "active must be true or false")
+			return
+		}
+		active = &b
+	}
+
+	products, meta, err := h.repo.GetProducts(active, where, page)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalid) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Paginated(w, r, products, meta)
+}
+
+// GetProduct handles GET /products/{id}
+func (h *InvoiceHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	product, err := h.repo.GetProduct(mux.Vars(r)["id"])
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	version.SetETag(w, product.Version)
+	utils.Success(w, product)
+}
+
+// CreateProduct handles POST /products
+func (h *InvoiceHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	var req productFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	product, err := req.product()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	created, err := h.repo.WithActor(audit.Actor(r)).SaveProduct("", 0, product)
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	version.SetETag(w, created.Version)
+	utils.Created(w, created)
+}
+
+// UpdateProduct handles PUT /products/{id}, replacing every field and price
+// of the product. With If-Match the update only applies to the version named
+// by the ETag.
+func (h *InvoiceHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errProductChanged)
+		return
+	}
+
+	var req productFields
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	product, err := req.product()
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	updated, err := h.repo.WithActor(audit.Actor(r)).SaveProduct(id, ver, product)
+	if err != nil {
+		productError(w, err)
+		return
+	}
+
+	version.SetETag(w, updated.Version)
+	utils.Success(w, updated)
+}
+
+// DeleteProduct handles DELETE /products/{id}. Invoices keep the details of
+// lines taken from the product. With If-Match the product is only deleted at
+// the version named by the ETag.
+func (h *InvoiceHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errProductChanged)
+		return
+	}
+
+	if err := h.repo.WithActor(audit.Actor(r)).DeleteProduct(mux.Vars(r)["id"], ver); err != nil {
+		productError(w, err)
+		return
+	}
+
+	utils.Success(w, map[string]string{"message": "Product deleted successfully"})
+}
+
+// ProductHistory handles GET /products/{id}/history
+func (h *InvoiceHandler) ProductHistory(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repo.ProductHistory(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// productError answers a failed product request
+func productError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrProductNotFound):
+		utils.NotFound(w, err.Error())
+	case errors.Is(err, version.ErrConflict):
+		utils.PreconditionFailed(w, errProductChanged)
+	case errors.Is(err, repository.ErrSKUTaken):
+		utils.Error(w, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(w, err.Error())
+	}
+}
@@ -0,0 +1,89 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// CreditNote represents credit note data for PDF generation. It is printed
+// like an invoice, with the invoice it credits and the reason for it.
+type CreditNote struct {
+	Invoice              // Customer, lines and totals of the credit note
+	Number        string // Credit note number
+	InvoiceNumber string // Number of the invoice it credits
+	Reason        string
+	IssueDate     string // Date the credit note was issued, printed as is
+}
+
+// GenerateCreditNotePDF creates a PDF credit note and returns it as bytes
+func GenerateCreditNotePDF(note CreditNote) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	addHeader(pdf, note.Company)
+
+	pdf.SetDrawColor(25, 103, 210)
+	pdf.SetLineWidth(0.5)
+	pdf.Line(20, 80, 190, 80)
+	pdf.Ln(5)
+
+	pdf.SetFont("Helvetica", "B", 24)
+	pdf.SetTextColor(25, 103, 210)
+	pdf.Cell(0, 10, "CREDIT NOTE")
+	pdf.Ln(15)
+
+	addCreditNoteDetails(pdf, note)
+	addFromToSection(pdf, note.Invoice)
+
+	if note.Currency == "" {
+		note.Currency = "USD"
+	}
+	addItemsTable(pdf, note.Items, note.Currency)
+	addTotalsSection(pdf, note.Invoice)
+
+	pdf.SetTextColor(44, 62, 80)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.Cell(0, 6, "Notes:")
+	pdf.Ln(6)
+
+	notes := []string{"This credit note lowers the balance of invoice " + note.InvoiceNumber + " by the total above."}
+	if note.Company.Email != "" {
+		notes = append(notes, "For inquiries, please contact us at "+note.Company.Email)
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.MultiCell(0, 5, strings.Join(notes, "\n"), "", "L", false)
+
+	addFooter(pdf, note.Company)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addCreditNoteDetails adds the credit note number, the invoice it credits,
+// its date and reason
+func addCreditNoteDetails(pdf *gofpdf.Fpdf, note CreditNote) {
+	pdf.SetTextColor(44, 62, 80)
+	for _, line := range [][2]string{
+		{"Credit Note Number:", note.Number},
+		{"Credited Invoice:", note.InvoiceNumber},
+		{"Issue Date:", note.IssueDate},
+	} {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.Cell(50, 6, line[0])
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.Cell(0, 6, line[1])
+		pdf.Ln(6)
+	}
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.Cell(50, 6, "Reason:")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.MultiCell(0, 6, note.Reason, "", "L", false)
+	pdf.Ln(6)
+}
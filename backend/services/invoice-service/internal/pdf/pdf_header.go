@@ -1,36 +1,70 @@
 package pdf
 
 import (
+	"bytes"
+
 	"github.com/jung-kurt/gofpdf"
 )
 
 // addHeader adds a professional header to the PDF
-func addHeader(pdf *gofpdf.Fpdf) {
+func addHeader(pdf *gofpdf.Fpdf, c Company) {
 	// Header background
 	pdf.SetFillColor(25, 103, 210)
 	pdf.Rect(0, 0, 210, 70, "F")
 
-	// Company Logo placeholder (circle)
-	pdf.SetFillColor(255, 255, 255)
-	pdf.Circle(30, 25, 12, "F")
+	// Company logo, scaled into a 40x24 box; a placeholder circle without one
+	nameX := 48.0
+	if w, ok := addLogo(pdf, c, 18, 13, 40, 24); ok {
+		nameX = 18 + w + 6
+	} else {
+		pdf.SetFillColor(255, 255, 255)
+		pdf.Circle(30, 25, 12, "F")
+	}
 
 	// Company Name
 	pdf.SetTextColor(255, 255, 255)
 	pdf.SetFont("Helvetica", "B", 20)
-	pdf.SetXY(48, 20)
-	pdf.Cell(0, 10, "InvoicePro")
+	pdf.SetXY(nameX, 20)
+	pdf.Cell(0, 10, c.Name)
 
-	// Tagline
-	pdf.SetFont("Helvetica", "", 10)
-	pdf.SetXY(48, 30)
-	pdf.Cell(0, 8, "Professional Invoicing Made Simple")
+	// Legal name, when the company trades under another name
+	if c.LegalName != "" && c.LegalName != c.Name {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetXY(nameX, 30)
+		pdf.Cell(0, 8, c.LegalName)
+	}
 
 	// Contact info (right side)
 	pdf.SetFont("Helvetica", "", 8)
-	pdf.SetXY(140, 20)
-	pdf.Cell(0, 5, "www.invoicepro.com")
-	pdf.SetXY(140, 26)
-	pdf.Cell(0, 5, "billing@invoicepro.com")
-	pdf.SetXY(140, 32)
-	pdf.Cell(0, 5, "+62 812 3456 7890")
+	y := 20.0
+	for _, line := range []string{c.Website, c.Email, c.Phone} {
+		if line == "" {
+			continue
+		}
+		pdf.SetXY(140, y)
+		pdf.Cell(0, 5, line)
+		y += 6
+	}
+}
+
+// addLogo draws the company's logo at x, y, scaled to fit in maxW x maxH,
+// and returns its width. It draws nothing and returns false when the company
+// has no logo or the image cannot be read.
+func addLogo(pdf *gofpdf.Fpdf, c Company, x, y, maxW, maxH float64) (float64, bool) {
+	if len(c.Logo) == 0 {
+		return 0, false
+	}
+	opts := gofpdf.ImageOptions{ImageType: c.LogoType}
+	info := pdf.RegisterImageOptionsReader("logo", opts, bytes.NewReader(c.Logo))
+	if !pdf.Ok() || info == nil || info.Height() == 0 {
+		pdf.ClearError()
+		return 0, false
+	}
+
+	w, h := maxH*info.Width()/info.Height(), maxH
+	if w > maxW {
+		w, h = maxW, maxW*info.Height()/info.Width()
+	}
+	pdf.ImageOptions("logo", x, y+(maxH-h)/2, w, h, false, opts, 0, "")
+	return w, true
 }
@@ -1,22 +1,23 @@
 package pdf
 
 import (
-	"fmt"
+	"invoice-backend/services/shared/pkg/money"
 
 	"github.com/jung-kurt/gofpdf"
 )
 
 // addItemsTable adds the invoice items table to the PDF
-func addItemsTable(pdf *gofpdf.Fpdf, items []Item) {
+func addItemsTable(pdf *gofpdf.Fpdf, items []Item, currency string) {
 	pdf.SetDrawColor(25, 103, 210)
 	pdf.SetFillColor(25, 103, 210)
 	pdf.SetTextColor(255, 255, 255)
 	pdf.SetFont("Helvetica", "B", 10)
 
 	// Table headers
-	pdf.CellFormat(80, 8, "DESCRIPTION", "1", 0, "L", true, 0, "")
-	pdf.CellFormat(20, 8, "QTY", "1", 0, "C", true, 0, "")
+	pdf.CellFormat(65, 8, "DESCRIPTION", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(25, 8, "QTY", "1", 0, "C", true, 0, "")
 	pdf.CellFormat(30, 8, "UNIT PRICE", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(30, 8, "DISCOUNT", "1", 0, "R", true, 0, "")
 	pdf.CellFormat(30, 8, "AMOUNT", "1", 0, "R", true, 0, "")
 	pdf.Ln(-1)
 
@@ -27,14 +28,56 @@ func addItemsTable(pdf *gofpdf.Fpdf, items []Item) {
 
 	fill := false
 	for _, item := range items {
-		lineTotal := float64(item.Quantity) * item.UnitPrice
+		lineTotal := item.Total
+		if lineTotal.IsZero() {
+			if amount, err := item.UnitPrice.Mul(item.Quantity); err == nil {
+				lineTotal = amount.Sub(item.Discount)
+			}
+		}
 
-		pdf.CellFormat(80, 8, item.Description, "1", 0, "L", fill, 0, "")
-		pdf.CellFormat(20, 8, fmt.Sprintf("%d", item.Quantity), "1", 0, "C", fill, 0, "")
-		pdf.CellFormat(30, 8, fmt.Sprintf("$%.2f", item.UnitPrice), "1", 0, "R", fill, 0, "")
-		pdf.CellFormat(30, 8, fmt.Sprintf("$%.2f", lineTotal), "1", 0, "R", fill, 0, "")
+		description := item.Description
+		if item.SKU != "" {
+			description = item.SKU + " - " + description
+		}
+		discount := ""
+		if item.Discount.Sign() > 0 {
+			discount = "-" + FormatCurrencyWithSymbol(item.Discount, currency)
+		}
+
+		// A line credited by amount has no quantity
+		quantity := ""
+		if !item.Quantity.IsZero() {
+			quantity = FormatQuantity(item.Quantity, item.Unit)
+		}
+
+		pdf.CellFormat(65, 8, description, "1", 0, "L", fill, 0, "")
+		pdf.CellFormat(25, 8, quantity, "1", 0, "C", fill, 0, "")
+		pdf.CellFormat(30, 8, FormatCurrencyWithSymbol(item.UnitPrice, currency), "1", 0, "R", fill, 0, "")
+		pdf.CellFormat(30, 8, discount, "1", 0, "R", fill, 0, "")
+		pdf.CellFormat(30, 8, FormatCurrencyWithSymbol(lineTotal, currency), "1", 0, "R", fill, 0, "")
 		pdf.Ln(-1)
 
+		// The price list rule that priced the line goes on a row of its own
+		if item.PriceRule != "" {
+			pdf.SetFont("Helvetica", "I", 8)
+			pdf.CellFormat(180, 6, "    "+item.PriceRule, "1", 0, "L", fill, 0, "")
+			pdf.Ln(-1)
+			pdf.SetFont("Helvetica", "", 10)
+		}
+
 		fill = !fill
 	}
 }
+
+// FormatQuantity formats a quantity with as few decimals as it needs,
+// followed by its unit: "7.5 hours", "1 day", "2.35 kg"
+func FormatQuantity(quantity money.Amount, unit string) string {
+	q := quantity.String()
+	switch {
+	case unit == "":
+		return q
+	case (unit == "hour" || unit == "day") && quantity != money.FromInt(1):
+		return q + " " + unit + "s"
+	}
+	return q + " " + unit
+}
@@ -3,6 +3,8 @@ package pdf
 import (
 	"fmt"
 
+	"invoice-backend/services/shared/pkg/money"
+
 	"github.com/jung-kurt/gofpdf"
 )
 
@@ -14,40 +16,54 @@ func addTotalsSection(pdf *gofpdf.Fpdf, inv Invoice) {
 
 	// Calculate values
 	subtotal := inv.Subtotal
-	if subtotal == 0 {
+	if subtotal.IsZero() {
 		// Fallback calculation if subtotal not provided
 		for _, item := range inv.Items {
-			subtotal += float64(item.Quantity) * item.UnitPrice
+			if amount, err := item.UnitPrice.Mul(item.Quantity); err == nil {
+				subtotal = subtotal.Add(amount.Sub(item.Discount))
+			}
+		}
+		subtotal = subtotal.Round(inv.Currency)
+	}
+	taxes := inv.Taxes
+	if len(taxes) == 0 {
+		if amount, err := subtotal.Percent(inv.Tax); err == nil {
+			taxes = []TaxLine{{Rate: inv.Tax, Amount: amount.Round(inv.Currency)}}
 		}
 	}
-	taxAmount := subtotal * (inv.Tax / 100)
+	var taxAmount money.Amount
+	for _, t := range taxes {
+		taxAmount = taxAmount.Add(t.Amount)
+	}
 	finalTotal := inv.Total
-	if finalTotal == 0 {
-		finalTotal = subtotal + taxAmount - inv.Discount
+	if finalTotal.IsZero() {
+		finalTotal = subtotal.Add(taxAmount).Sub(inv.Discount)
 	}
 
-	// Subtotal - Always display in USD for PDF compatibility
+	// Subtotal
 	pdf.SetFont("Helvetica", "B", 10)
 	pdf.Cell(30, 6, "Subtotal:")
 	pdf.SetFont("Helvetica", "", 10)
-	pdf.Cell(30, 6, fmt.Sprintf("$%.2f", subtotal))
+	pdf.Cell(30, 6, FormatCurrencyWithSymbol(subtotal, inv.Currency))
 	pdf.Ln(6)
 
-	// Tax
-	pdf.SetFont("Helvetica", "B", 10)
-	pdf.Cell(130, 6, "")
-	pdf.Cell(30, 6, fmt.Sprintf("Tax (%.1f%%):", inv.Tax))
-	pdf.SetFont("Helvetica", "", 10)
-	pdf.Cell(30, 6, fmt.Sprintf("$%.2f", taxAmount))
-	pdf.Ln(6)
+	// Tax, one line per rate
+	for _, t := range taxes {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.Cell(130, 6, "")
+		pdf.Cell(30, 6, fmt.Sprintf("Tax (%.1f%%):", t.Rate))
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.Cell(30, 6, FormatCurrencyWithSymbol(t.Amount, inv.Currency))
+		pdf.Ln(6)
+	}
 
 	// Discount (only show if > 0)
-	if inv.Discount > 0 {
+	if inv.Discount.Sign() > 0 {
 		pdf.SetFont("Helvetica", "B", 10)
 		pdf.Cell(130, 6, "")
 		pdf.Cell(30, 6, "Discount:")
 		pdf.SetFont("Helvetica", "", 10)
-		pdf.Cell(30, 6, fmt.Sprintf("-$%.2f", inv.Discount))
+		pdf.Cell(30, 6, "-"+FormatCurrencyWithSymbol(inv.Discount, inv.Currency))
 		pdf.Ln(6)
 	}
 
@@ -57,6 +73,6 @@ func addTotalsSection(pdf *gofpdf.Fpdf, inv Invoice) {
 	pdf.SetFont("Helvetica", "B", 12)
 	pdf.Cell(130, 8, "")
 	pdf.CellFormat(30, 8, "TOTAL:", "0", 0, "R", true, 0, "")
-	pdf.CellFormat(30, 8, fmt.Sprintf("$%.2f", finalTotal), "0", 0, "R", true, 0, "")
+	pdf.CellFormat(30, 8, FormatCurrencyWithSymbol(finalTotal, inv.Currency), "0", 0, "R", true, 0, "")
 	pdf.Ln(12)
 }
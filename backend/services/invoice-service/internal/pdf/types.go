@@ -2,11 +2,14 @@ package pdf
 
 import (
 	"time"
+
+	"invoice-backend/services/shared/pkg/money"
 )
 
 // Invoice represents invoice data for PDF generation
 type Invoice struct {
 	ID                 string
+	Company            Company // Company the invoice is issued from
 	CustomerName       string
 	CustomerEmail      string
 	CustomerAddress    string
@@ -14,17 +17,50 @@ type Invoice struct {
 	CustomerPostalCode string
 	CustomerPhone      string
 	Items              []Item
-	Subtotal           float64
-	Tax                float64 // Tax percentage
-	Discount           float64 // Discount amount
-	Total              float64
+	Subtotal           money.Amount
+	Tax                float64      // Tax percentage
+	Taxes              []TaxLine    // Tax per rate, when lines carry their own rates
+	Discount           money.Amount // Discount amount
+	Total              money.Amount
 	Currency           string    // Currency code (USD, IDR, EUR, etc.)
 	CreatedAt          time.Time
 }
 
 // Item represents a line item in an invoice
 type Item struct {
+	SKU         string
 	Description string
-	Quantity    int
-	UnitPrice   float64
+	Quantity    money.Amount // Decimal quantity, such as 7.5 hours
+	Unit        string       // Unit of measure: hour, day, kg, pcs or a custom unit
+	UnitPrice   money.Amount
+	PriceRule   string       // Price list rule that set the unit price, if any
+	Discount    money.Amount // Line discount amount
+	Total       money.Amount // Line amount after the discount
+}
+
+// TaxLine is the tax charged at one rate
+type TaxLine struct {
+	Rate   float64 // Tax percentage
+	Amount money.Amount
+}
+
+// Company is the company an invoice is issued from, printed in the header,
+// the FROM block, the payment instructions and the footer
+type Company struct {
+	Name              string // Trading name
+	LegalName         string
+	TaxID             string
+	Address           string
+	City              string
+	PostalCode        string
+	Country           string
+	Email             string
+	Phone             string
+	Website           string
+	BankName          string
+	BankAccountName   string
+	BankAccountNumber string
+	BankSwift         string
+	Logo              []byte // PNG or JPEG image; a placeholder is drawn without one
+	LogoType          string // "PNG" or "JPG"
 }
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrCompanyNotFound is returned for an unknown company profile ID
+	ErrCompanyNotFound = errors.New("company profile not found")
+	// ErrDefaultCompany is returned when deleting the default company
+	// profile; make another profile the default first
+	ErrDefaultCompany = errors.New("the default company profile cannot be deleted")
+	// ErrCompanyHasInvoices is returned when deleting a company profile that
+	// issued invoices
+	ErrCompanyHasInvoices = errors.New("company profile has issued invoices")
+	// ErrPrefixTaken is returned when creating a company profile with an
+	// invoice number prefix another company already uses
+	ErrPrefixTaken = errors.New("invoice number prefix is already used by another company")
+)
+
+// CompanyHistory returns the audit log entries of a company profile, oldest
+// first
+func (r *InvoiceRepository) CompanyHistory(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.Company)
+}
+
+// GetCompanies returns every company profile, the default first
+func (r *InvoiceRepository) GetCompanies() ([]types.CompanyProfile, error) {
+	profiles := []types.CompanyProfile{}
+	_, err := r.db.From("company_info").
+		Select("*", "", false).
+		Order("is_default", &postgrest.OrderOpts{Ascending: false}).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&profiles)
+	return profiles, err
+}
+
+// GetCompany returns a company profile, or the default profile for an empty
+// id
+func (r *InvoiceRepository) GetCompany(id string) (*types.CompanyProfile, error) {
+	query := r.db.From("company_info").Select("*", "", false)
+	if id == "" {
+		query = query.Eq("is_default", "true")
+	} else {
+		query = query.Eq("id", id)
+	}
+	var profiles []types.CompanyProfile
+	if _, err := query.ExecuteTo(&profiles); err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, ErrCompanyNotFound
+	}
+	return &profiles[0], nil
+}
+
+// SaveCompany creates (id "") or updates a company profile through the
+// save_company_profile database function (see migration
+// 0013_company_profiles). A new profile gets its own invoice numbering
+// series, whose numbers start with invoicePrefix. A ver other than 0 only
+// updates the profile at that version.
+func (r *InvoiceRepository) SaveCompany(id string, ver int, profile types.CompanyProfile, invoicePrefix string) (*types.CompanyProfile, error) {
+	args := map[string]interface{}{
+		"p_profile": map[string]interface{}{
+			"name":                profile.Name,
+			"legal_name":          profile.LegalName,
+			"tax_id":              profile.TaxID,
+			"address":             profile.Address,
+			"city":                profile.City,
+			"postal_code":         profile.PostalCode,
+			"country":             profile.Country,
+			"email":               profile.Email,
+			"phone":               profile.Phone,
+			"website":             profile.Website,
+			"bank_name":           profile.BankName,
+			"bank_account_name":   profile.BankAccountName,
+			"bank_account_number": profile.BankAccountNumber,
+			"bank_swift":          profile.BankSwift,
+			"logo":                profile.Logo,
+			"is_default":          profile.IsDefault,
+		},
+		"p_id":     nil,
+		"p_prefix": nil,
+	}
+	if id != "" {
+		args["p_id"] = id
+	}
+	if invoicePrefix != "" {
+		args["p_prefix"] = invoicePrefix
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var saved types.CompanyProfile
+	err := r.db.RPC("save_company_profile", args, &saved)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, ErrCompanyNotFound
+			case "PT409":
+				return nil, fmt.Errorf("%w: %s", ErrPrefixTaken, invoicePrefix)
+			case "PT412":
+				return nil, version.ErrConflict
+			}
+		}
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteCompany deletes a company profile that is not the default and never
+// issued an invoice; the foreign key from invoices backs up the check. Its
+// numbering series is kept. A ver other than 0 only deletes the profile at
+// that version.
+func (r *InvoiceRepository) DeleteCompany(id string, ver int) error {
+	profile, err := r.GetCompany(id)
+	if err != nil {
+		return err
+	}
+	if profile.IsDefault {
+		return ErrDefaultCompany
+	}
+	var invoices []types.Invoice
+	_, err = r.db.From("invoices").Select("id", "", false).Eq("company_id", id).Limit(1, "").ExecuteTo(&invoices)
+	if err != nil {
+		return err
+	}
+	if len(invoices) > 0 {
+		return ErrCompanyHasInvoices
+	}
+
+	var deleted []types.CompanyProfile
+	_, err = version.Match(r.db.From("company_info").
+		Delete("", "").
+		Eq("id", id), ver).
+		ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return version.Missed(r.db, "company_info", id, ErrCompanyNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrInsufficientCredit is returned when applying more of a customer's
+	// credit than they have
+	ErrInsufficientCredit = errors.New("not enough customer credit")
+	// ErrCreditExceedsBalance is returned when applying more credit to an
+	// invoice than is owed on it
+	ErrCreditExceedsBalance = errors.New("credit exceeds what is owed on the invoice")
+	// ErrCreditApplicationNotFound is returned for an unknown credit
+	// application ID
+	ErrCreditApplicationNotFound = errors.New("credit application not found")
+	// ErrCreditApplicationVoided is returned when voiding a credit
+	// application that was voided
+	ErrCreditApplicationVoided = errors.New("credit application is void")
+)
+
+// ApplyCredit pays an invoice from its customer's credit in the invoice's
+// currency through the apply_customer_credit database function, which locks
+// the invoice, checks the credit and records a credit application in one
+// transaction (see migration 0019_customer_credit). A zero amount applies
+// as much as there is credit and is owed.
+func (r *InvoiceRepository) ApplyCredit(invoiceID string, amount money.Amount, createdBy string) (*types.CreditApplication, error) {
+	args := map[string]interface{}{
+		"p_invoice_id": invoiceID,
+	}
+	if !amount.IsZero() {
+		args["p_amount"] = amount
+	}
+	if createdBy != "" {
+		args["p_created_by"] = createdBy
+	}
+
+	var application types.CreditApplication
+	if err := r.db.RPC("apply_customer_credit", args, &application); err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT402":
+				return nil, fmt.Errorf("%w: %s", ErrInsufficientCredit, rpcErr.Message)
+			case "PT404":
+				return nil, ErrInvoiceNotFound
+			case "PT409":
+				return nil, fmt.Errorf("%w: %s", ErrCreditExceedsBalance, rpcErr.Message)
+			case "PT422":
+				return nil, fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, rpcErr.Message)
+			}
+		}
+		return nil, err
+	}
+	return &application, nil
+}
+
+// GetCreditApplications returns the credit applications of an invoice,
+// oldest first
+func (r *InvoiceRepository) GetCreditApplications(invoiceID string) ([]types.CreditApplication, error) {
+	applications := []types.CreditApplication{}
+	_, err := r.db.From("credit_applications").
+		Select("*", "", false).
+		Eq("invoice_id", invoiceID).
+		Order("applied_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&applications)
+	if err != nil {
+		return nil, err
+	}
+	return applications, nil
+}
+
+// VoidCreditApplication voids a credit application at ver through the
+// void_credit_application database function, which gives the credit back
+// and settles the invoice in one transaction
+func (r *InvoiceRepository) VoidCreditApplication(id string, ver int, reason string) (*types.CreditApplication, error) {
+	args := map[string]interface{}{
+		"p_id":     id,
+		"p_reason": reason,
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var application types.CreditApplication
+	if err := r.db.RPC("void_credit_application", args, &application); err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, ErrCreditApplicationNotFound
+			case "PT409":
+				return nil, ErrCreditApplicationVoided
+			case "PT412":
+				return nil, version.ErrConflict
+			}
+		}
+		return nil, err
+	}
+	return &application, nil
+}
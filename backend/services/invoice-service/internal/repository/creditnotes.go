@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// ErrCreditNoteNotFound is returned for an unknown credit note ID
+var ErrCreditNoteNotFound = errors.New("credit note not found")
+
+// creditNoteSelect reads credit notes with their lines embedded as items
+const creditNoteSelect = "*, items:credit_note_items(*)"
+
+// CreditNoteSort lists the fields credit notes can be sorted by
+var CreditNoteSort = pagination.Sort{Fields: []string{"created_at", "credit_note_number", "total"}, Default: "created_at"}
+
+// CreditNoteFilter lists the fields credit notes can be filtered by
+var CreditNoteFilter = filter.Schema{
+	"invoice_id":         {Column: "invoice_id", Type: filter.ID},
+	"credit_note_number": {Column: "credit_note_number", Type: filter.String},
+	"reason":             {Column: "reason", Type: filter.String},
+	"currency":           {Column: "currency", Type: filter.String},
+	"subtotal":           {Column: "subtotal", Type: filter.Number},
+	"tax":                {Column: "tax", Type: filter.Number},
+	"total":              {Column: "total", Type: filter.Number},
+	"created_at":         {Column: "created_at", Type: filter.Timestamp},
+}
+
+// CreditNoteHistory returns the audit log entries of a credit note and its
+// lines, oldest first
+func (r *InvoiceRepository) CreditNoteHistory(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.CreditNote, audit.CreditNoteItem)
+}
+
+// GetCreditNotes returns one page of credit notes
+func (r *InvoiceRepository) GetCreditNotes(where *filter.Expr, page pagination.Request) ([]types.CreditNote, *pagination.Meta, error) {
+	filter := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if where != nil {
+			query = query.And(where.PostgREST(), "")
+		}
+		return query
+	}
+	notes, meta, err := pagination.Fetch(r.db, "credit_notes", creditNoteSelect, page, filter,
+		func(n types.CreditNote) string { return n.ID })
+	if err != nil {
+		return nil, nil, err
+	}
+	sortCreditNoteItems(notes)
+	return notes, meta, nil
+}
+
+// GetCreditNotesByInvoice returns the credit notes of an invoice, oldest
+// first
+func (r *InvoiceRepository) GetCreditNotesByInvoice(invoiceID string) ([]types.CreditNote, error) {
+	notes := []types.CreditNote{}
+	_, err := r.db.From("credit_notes").
+		Select(creditNoteSelect, "", false).
+		Eq("invoice_id", invoiceID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&notes)
+	if err != nil {
+		return nil, err
+	}
+	sortCreditNoteItems(notes)
+	return notes, nil
+}
+
+// GetCreditNote returns a credit note with its lines
+func (r *InvoiceRepository) GetCreditNote(id string) (*types.CreditNote, error) {
+	var notes []types.CreditNote
+	_, err := r.db.From("credit_notes").
+		Select(creditNoteSelect, "", false).
+		Eq("id", id).
+		ExecuteTo(&notes)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, ErrCreditNoteNotFound
+	}
+	sortCreditNoteItems(notes)
+	return &notes[0], nil
+}
+
+// CreateCreditNote issues a credit note priced by pricing.Credit against
+// the invoice at ver through the create_credit_note database function,
+// which numbers it and lowers the invoice's balance in one transaction (see
+// migration 0016_credit_notes). It returns lifecycle.ErrNotAllowed if the
+// invoice is not open or the note exceeds its balance.
+func (r *InvoiceRepository) CreateCreditNote(ver int, note types.CreditNote) (*types.CreditNote, error) {
+	fields := map[string]interface{}{
+		"reason":     note.Reason,
+		"subtotal":   note.Subtotal,
+		"tax":        note.Tax,
+		"discount":   note.Discount,
+		"total":      note.Total,
+		"created_by": nil,
+	}
+	if note.CreatedBy != "" {
+		fields["created_by"] = note.CreatedBy
+	}
+	args := map[string]interface{}{
+		"p_invoice_id":  note.InvoiceID,
+		"p_credit_note": fields,
+		"p_items":       note.Items,
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var created types.CreditNote
+	err := r.db.RPC("create_credit_note", args, &created)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, ErrInvoiceNotFound
+			case "PT412":
+				return nil, version.ErrConflict
+			case "PT422":
+				return nil, fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, rpcErr.Message)
+			}
+		}
+		return nil, err
+	}
+	return r.GetCreditNote(created.ID)
+}
+
+// sortCreditNoteItems puts the lines embedded by creditNoteSelect in
+// position order
+func sortCreditNoteItems(notes []types.CreditNote) {
+	for _, n := range notes {
+		sort.Slice(n.Items, func(i, j int) bool { return n.Items[i].Position < n.Items[j].Position })
+	}
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrPriceListNotFound is returned for an unknown price list ID
+	ErrPriceListNotFound = errors.New("price list not found")
+	// ErrUnknownProduct is returned when saving a price list with a rule for
+	// a product that is not in the catalog
+	ErrUnknownProduct = errors.New("product not found")
+)
+
+// priceListSelect reads price lists with their rules embedded
+const priceListSelect = "*, rules:price_list_rules(product_id, min_quantity, unit_price, discount_percent)"
+
+// PriceListHistory returns the audit log entries of a price list and its
+// rules, oldest first
+func (r *InvoiceRepository) PriceListHistory(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.PriceList, audit.PriceListRule)
+}
+
+// GetPriceLists returns every price list, by name
+func (r *InvoiceRepository) GetPriceLists() ([]types.PriceList, error) {
+	var lists []types.PriceList
+	_, err := r.db.From("price_lists").
+		Select(priceListSelect, "", false).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&lists)
+	return lists, err
+}
+
+// GetPriceList returns a price list with its rules
+func (r *InvoiceRepository) GetPriceList(id string) (*types.PriceList, error) {
+	var lists []types.PriceList
+	_, err := r.db.From("price_lists").
+		Select(priceListSelect, "", false).
+		Eq("id", id).
+		ExecuteTo(&lists)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, ErrPriceListNotFound
+	}
+	return &lists[0], nil
+}
+
+// SavePriceList creates (id "") or updates a price list and replaces its
+// rules through the save_price_list database function, which writes them in
+// one transaction (see migration 0012_price_lists). A ver other than 0 only
+// updates the price list at that version.
+func (r *InvoiceRepository) SavePriceList(id string, ver int, list types.PriceList) (*types.PriceList, error) {
+	rules := list.Rules
+	if rules == nil {
+		rules = []types.PriceRule{}
+	}
+	args := map[string]interface{}{
+		"p_list": map[string]interface{}{
+			"name":        list.Name,
+			"description": list.Description,
+			"currency":    list.Currency,
+		},
+		"p_rules": rules,
+		"p_id":    nil,
+	}
+	if id != "" {
+		args["p_id"] = id
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var saved types.PriceList
+	err := r.db.RPC("save_price_list", args, &saved)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, ErrPriceListNotFound
+			case "PT409":
+				return nil, fmt.Errorf("%w: %s", ErrUnknownProduct, rpcErr.Message)
+			case "PT412":
+				return nil, version.ErrConflict
+			}
+		}
+		return nil, err
+	}
+	return r.GetPriceList(saved.ID)
+}
+
+// DeletePriceList deletes a price list; the foreign key unassigns it from
+// its customers. A ver other than 0 only deletes the price list at that
+// version.
+func (r *InvoiceRepository) DeletePriceList(id string, ver int) error {
+	var deleted []types.PriceList
+	_, err := version.Match(r.db.From("price_lists").
+		Delete("", "").
+		Eq("id", id), ver).
+		ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return version.Missed(r.db, "price_lists", id, ErrPriceListNotFound)
+	}
+	return nil
+}
+
+// customerPriceList returns the price list of a customer, or nil if it has
+// none
+func (r *InvoiceRepository) customerPriceList(customerID string) (*types.PriceList, error) {
+	var customers []types.Customer
+	_, err := r.db.From("customers").
+		Select("id, price_list_id", "", false).
+		Eq("id", customerID).
+		ExecuteTo(&customers)
+	if err != nil {
+		return nil, err
+	}
+	if len(customers) == 0 || customers[0].PriceListID == "" {
+		return nil, nil
+	}
+	list, err := r.GetPriceList(customers[0].PriceListID)
+	if errors.Is(err, ErrPriceListNotFound) {
+		return nil, nil
+	}
+	return list, err
+}
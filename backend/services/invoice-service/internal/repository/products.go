@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrProductNotFound is returned for an unknown product ID
+	ErrProductNotFound = errors.New("product not found")
+	// ErrSKUTaken is returned when saving a product with the SKU of another
+	// product
+	ErrSKUTaken = errors.New("SKU is already used by another product")
+)
+
+// productSelect reads products with their prices embedded
+const productSelect = "*, prices:product_prices(currency, unit_price)"
+
+// ProductSort lists the fields products can be sorted by
+var ProductSort = pagination.Sort{Fields: []string{"sku", "name", "created_at"}, Default: "sku"}
+
+// ProductFilter lists the fields products can be filtered by
+var ProductFilter = filter.Schema{
+	"sku":         {Column: "sku", Type: filter.String},
+	"name":        {Column: "name", Type: filter.String},
+	"description": {Column: "description", Type: filter.String},
+	"unit":        {Column: "unit", Type: filter.String},
+	"tax_rate":    {Column: "tax_rate", Type: filter.Number},
+	"created_at":  {Column: "created_at", Type: filter.Timestamp},
+}
+
+// ProductHistory returns the audit log entries of a product and its prices,
+// oldest first
+func (r *InvoiceRepository) ProductHistory(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.Product, audit.ProductPrice)
+}
+
+// GetProducts returns one page of the catalog: active (true) or inactive
+// (false) products, or both when active is nil
+func (r *InvoiceRepository) GetProducts(active *bool, where *filter.Expr, page pagination.Request) ([]types.Product, *pagination.Meta, error) {
+	filter := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if active != nil {
+			query = query.Eq("active", strconv.FormatBool(*active))
+		}
+		if where != nil {
+			query = query.And(where.PostgREST(), "")
+		}
+		return query
+	}
+	return pagination.Fetch(r.db, "products", productSelect, page, filter,
+		func(p types.Product) string { return p.ID })
+}
+
+// GetProduct returns a product with its prices
+func (r *InvoiceRepository) GetProduct(id string) (*types.Product, error) {
+	var products []types.Product
+	_, err := r.db.From("products").
+		Select(productSelect, "", false).
+		Eq("id", id).
+		ExecuteTo(&products)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrProductNotFound
+	}
+	return &products[0], nil
+}
+
+// SaveProduct creates (id "") or updates a product and replaces its prices
+// through the save_product database function, which writes them in one
+// transaction (see migration 0011_product_catalog). A ver other than 0 only
+// updates the product at that version.
+func (r *InvoiceRepository) SaveProduct(id string, ver int, product types.Product) (*types.Product, error) {
+	prices := product.Prices
+	if prices == nil {
+		prices = []types.ProductPrice{}
+	}
+	args := map[string]interface{}{
+		"p_product": map[string]interface{}{
+			"sku":         product.SKU,
+			"name":        product.Name,
+			"description": product.Description,
+			"unit":        product.Unit,
+			"tax_rate":    product.TaxRate,
+			"active":      product.Active,
+		},
+		"p_prices": prices,
+		"p_id":     nil,
+	}
+	if id != "" {
+		args["p_id"] = id
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var saved types.Product
+	err := r.db.RPC("save_product", args, &saved)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, ErrProductNotFound
+			case "PT409":
+				return nil, fmt.Errorf("%w: %s", ErrSKUTaken, product.SKU)
+			case "PT412":
+				return nil, version.ErrConflict
+			}
+		}
+		return nil, err
+	}
+	return r.GetProduct(saved.ID)
+}
+
+// DeleteProduct removes a product from the catalog. Invoice lines taken from
+// it keep their details. A ver other than 0 only deletes the product at that
+// version.
+func (r *InvoiceRepository) DeleteProduct(id string, ver int) error {
+	var deleted []types.Product
+	_, err := version.Match(r.db.From("products").
+		Delete("", "").
+		Eq("id", id), ver).
+		ExecuteTo(&deleted)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return version.Missed(r.db, "products", id, ErrProductNotFound)
+	}
+	return nil
+}
+
+// catalogProduct looks up the product an invoice line references, for
+// pricing.Catalog
+func (r *InvoiceRepository) catalogProduct(id string) (*types.Product, error) {
+	product, err := r.GetProduct(id)
+	if errors.Is(err, ErrProductNotFound) {
+		return nil, nil
+	}
+	return product, err
+}
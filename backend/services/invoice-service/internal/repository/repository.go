@@ -1,13 +1,28 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"invoice-backend/services/shared/pkg/audit"
 	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/pricing"
 	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
 )
 
+// ErrInvoiceNotFound is returned for an unknown invoice ID
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
 type InvoiceRepository struct {
 	db *database.Client
 }
@@ -16,188 +31,292 @@ func NewInvoiceRepository(db *database.Client) *InvoiceRepository {
 	return &InvoiceRepository{db: db}
 }
 
-// GetAll returns all invoices with optional filters
-func (r *InvoiceRepository) GetAll(status, currency string) ([]types.Invoice, error) {
-	query := r.db.Supabase.From("invoices").
-		Select("*, customers(name, email)", "", false).
-		Order("created_at", nil)
+// WithActor returns a repository whose changes the audit log attributes to
+// actor
+func (r *InvoiceRepository) WithActor(actor string) *InvoiceRepository {
+	return &InvoiceRepository{db: r.db.WithActor(actor)}
+}
 
-	if status != "" && status != "all" {
-		query = query.Eq("payment_status", status)
-	}
+// WithOrg returns a repository scoped to the organization org
+func (r *InvoiceRepository) WithOrg(org string) *InvoiceRepository {
+	return &InvoiceRepository{db: r.db.WithOrg(org)}
+}
+
+// History returns the audit log entries of an invoice and its lines, oldest
+// first
+func (r *InvoiceRepository) History(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.Invoice, audit.InvoiceItem, audit.CreditApplication)
+}
 
-	if currency != "" && currency != "all" {
-		query = query.Eq("currency", currency)
+// Sort lists the fields invoices can be sorted by
+var Sort = pagination.Sort{Fields: []string{"created_at", "invoice_number", "total"}, Default: "created_at"}
+
+// Filter lists the fields invoices can be filtered by
+var Filter = filter.Schema{
+	"invoice_number": {Column: "invoice_number", Type: filter.String},
+	"customer_id":    {Column: "customer_id", Type: filter.ID},
+	"status":         {Column: "status", Type: filter.Enum, Values: lifecycle.Statuses},
+	"payment_status": {Column: "payment_status", Type: filter.Enum, Values: []string{"unpaid", "partially_paid", "paid", "overdue"}},
+	"currency":       {Column: "currency", Type: filter.String},
+	"subtotal":       {Column: "subtotal", Type: filter.Number},
+	"tax":            {Column: "tax", Type: filter.Number},
+	"discount":       {Column: "discount", Type: filter.Number},
+	"total":          {Column: "total", Type: filter.Number},
+	"paid_amount":    {Column: "paid_amount", Type: filter.Number},
+	"notes":          {Column: "notes", Type: filter.String},
+	"due_date":       {Column: "due_date", Type: filter.Date},
+	"payment_date":   {Column: "payment_date", Type: filter.Timestamp},
+	"created_at":     {Column: "created_at", Type: filter.Timestamp},
+}
+
+// GetAll returns one page of invoices with optional filters. where is a
+// parsed filter expression (nil for none).
+func (r *InvoiceRepository) GetAll(status, currency string, where *filter.Expr, page pagination.Request) ([]types.Invoice, *pagination.Meta, error) {
+	filter := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if status != "" && status != "all" {
+			query = query.Eq("payment_status", status)
+		}
+
+		if currency != "" && currency != "all" {
+			query = query.Eq("currency", currency)
+		}
+
+		if where != nil {
+			query = query.And(where.PostgREST(), "")
+		}
+		return query
 	}
 
-	var invoices []types.Invoice
-	_, err := query.ExecuteTo(&invoices)
-	return invoices, err
+	invoices, meta, err := pagination.Fetch(r.db, "invoices", "*, customers(name, email), items:invoice_items(*)", page, filter,
+		func(inv types.Invoice) string { return inv.ID })
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, inv := range invoices {
+		sort.Slice(inv.Items, func(i, j int) bool { return inv.Items[i].Position < inv.Items[j].Position })
+	}
+	return invoices, meta, nil
 }
 
-// GetByID returns an invoice by ID
+// GetByID returns an invoice by ID with its items
 func (r *InvoiceRepository) GetByID(id string) (*types.Invoice, error) {
 	var invoices []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
+	_, err := r.db.From("invoices").
 		Select("*, customers(name, email)", "", false).
 		Eq("id", id).
 		ExecuteTo(&invoices)
-	
+
 	if err != nil {
 		return nil, err
 	}
 	if len(invoices) == 0 {
-		return nil, fmt.Errorf("invoice not found")
+		return nil, ErrInvoiceNotFound
 	}
-	return &invoices[0], nil
+
+	invoice := &invoices[0]
+	if invoice.Items, err = r.GetItems(id); err != nil {
+		return nil, err
+	}
+	return invoice, nil
 }
 
-// GetItems returns items for an invoice
+// GetItems returns the items of an invoice in position order
 func (r *InvoiceRepository) GetItems(invoiceID string) ([]types.Item, error) {
-	var items []types.Item
+	items := []types.Item{}
 	_, err := r.db.Supabase.From("invoice_items").
 		Select("*", "", false).
 		Eq("invoice_id", invoiceID).
+		Order("position", &postgrest.OrderOpts{Ascending: true}).
 		ExecuteTo(&items)
 	return items, err
 }
 
-// Create creates a new invoice with items
-func (r *InvoiceRepository) Create(customerID string, items []types.Item, tax, discount float64, status, notes, dueDate, currency string) (*types.Invoice, error) {
-	// Calculate totals
-	var subtotal float64
-	for _, item := range items {
-		item.Total = float64(item.Quantity) * item.UnitPrice
-		subtotal += item.Total
+// Create creates a new invoice with items. The invoice and its items are
+// inserted in one transaction by the create_invoice database function (see
+// migration 0009_invoice_items). status is draft (the default), issued or
+// sent.
+func (r *InvoiceRepository) Create(companyID, customerID string, items []types.Item, tax float64, discount money.Amount, status, notes, dueDate, currency string) (*types.Invoice, error) {
+	// Set defaults
+	status, err := lifecycle.Initial(status)
+	if err != nil {
+		return nil, err
+	}
+	if currency == "" {
+		currency = "USD"
 	}
 
-	// Calculate tax and total
-	taxAmount := subtotal * (tax / 100)
-	total := subtotal + taxAmount - discount
-
-	// Generate invoice number
-	invoiceNumber, err := r.generateInvoiceNumber()
+	// Lines that reference the catalog take the product's details and price,
+	// or the customer's price from its price list
+	priceList, err := r.customerPriceList(customerID)
 	if err != nil {
 		return nil, err
 	}
-
-	// Set defaults
-	if status == "" {
-		status = "pending"
+	if err := pricing.Catalog(items, currency, priceList, r.catalogProduct); err != nil {
+		return nil, err
 	}
-	if currency == "" {
-		currency = "USD"
+
+	// Price the items and total the invoice, rounded to the currency's
+	// precision
+	totals, err := pricing.Price(items, tax, discount, currency)
+	if err != nil {
+		return nil, err
 	}
+
 	if dueDate == "" {
 		dueDate = time.Now().AddDate(0, 0, 30).Format("2006-01-02")
 	}
 
-	// Create invoice
+	// Create invoice. invoice_number is assigned by the database from the
+	// invoice numbering series of the issuing company, the default company
+	// when companyID is empty (see migration 0013_company_profiles).
 	invoiceData := map[string]interface{}{
-		"customer_id":    customerID,
-		"invoice_number": invoiceNumber,
-		"due_date":       dueDate,
-		"status":         status,
-		"payment_status": "unpaid",
-		"subtotal":       subtotal,
-		"tax":            tax,
-		"discount":       discount,
-		"total":          total,
-		"paid_amount":    0,
-		"currency":       currency,
-		"notes":          notes,
-		"items":          items,
+		"company_id":  nil,
+		"customer_id": customerID,
+		"due_date":    dueDate,
+		"status":      status,
+		"subtotal":    totals.Subtotal,
+		"tax":         tax,
+		"discount":    totals.Discount,
+		"total":       totals.Total,
+		"currency":    currency,
+		"notes":       notes,
 	}
 
-	var result []types.Invoice
-	_, err = r.db.Supabase.From("invoices").
-		Insert(invoiceData, false, "", "", "").
-		ExecuteTo(&result)
-	
+	if companyID != "" {
+		invoiceData["company_id"] = companyID
+	}
+
+	var created types.Invoice
+	err = r.db.RPC("create_invoice", map[string]interface{}{"p_invoice": invoiceData, "p_items": items}, &created)
 	if err != nil {
-		return nil, err
+		return nil, rpcStatusError(err)
 	}
-	if len(result) == 0 {
+	if created.ID == "" {
 		return nil, fmt.Errorf("no invoice created")
 	}
 
-	// Items are already stored as JSON in the invoices table
-	// No need to insert into invoice_items table
-
 	// Return the created invoice
-	return &result[0], nil
+	return r.GetByID(created.ID)
 }
 
-// Update updates an invoice
-func (r *InvoiceRepository) Update(id string, status, notes string) (*types.Invoice, error) {
+// Update updates an invoice at ver, the version the caller read, returning
+// version.ErrConflict if it has changed since; a ver of 0 updates whatever
+// version is current. An empty status keeps the status; any other must be a
+// transition the invoice's status allows, which the invoice_status trigger
+// checks (see migration 0015_invoice_status).
+func (r *InvoiceRepository) Update(id string, ver int, status, notes string) (*types.Invoice, error) {
 	updateData := map[string]interface{}{
-		"status": status,
-		"notes":  notes,
+		"notes": notes,
+	}
+	if status != "" {
+		if !lifecycle.Valid(status) {
+			return nil, fmt.Errorf("%w: %s", lifecycle.ErrUnknownStatus, status)
+		}
+		updateData["status"] = status
 	}
+	return r.write(id, ver, updateData)
+}
 
+// Transition moves an invoice at ver to status by hand, recording when it
+// was sent, or why it was voided or written off. The invoice_status trigger
+// checks the transition and settles the status from the payments and due
+// date.
+func (r *InvoiceRepository) Transition(id string, ver int, status, reason string) (*types.Invoice, error) {
+	if !lifecycle.Valid(status) {
+		return nil, fmt.Errorf("%w: %s", lifecycle.ErrUnknownStatus, status)
+	}
+	updateData := map[string]interface{}{"status": status}
+	switch status {
+	case lifecycle.Sent:
+		updateData["sent_at"] = time.Now().UTC().Format(time.RFC3339)
+	case lifecycle.Void, lifecycle.WrittenOff:
+		updateData["status_reason"] = nil
+		if reason != "" {
+			updateData["status_reason"] = reason
+		}
+	}
+	return r.write(id, ver, updateData)
+}
+
+// MarkOverdue marks the open invoices of every organization whose due date
+// has passed as overdue, returning how many it marked
+func (r *InvoiceRepository) MarkOverdue() (int, error) {
+	var marked int
+	if err := r.db.RPC("mark_overdue_invoices", map[string]interface{}{}, &marked); err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+// write applies updateData to an invoice at ver and returns it with its
+// items. The invoice is read back afterwards, since credit applied to it on
+// issue (see migration 0019_customer_credit) is paid after the update
+// returns the row.
+func (r *InvoiceRepository) write(id string, ver int, updateData map[string]interface{}) (*types.Invoice, error) {
 	var result []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
+	_, err := version.Match(r.db.From("invoices").
 		Update(updateData, "", "").
-		Eq("id", id).
+		Eq("id", id), ver).
 		ExecuteTo(&result)
-	
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	if len(result) == 0 {
-		return nil, fmt.Errorf("invoice not found")
+		return nil, version.Missed(r.db, "invoices", id, ErrInvoiceNotFound)
 	}
-	return &result[0], nil
+	return r.GetByID(id)
 }
 
-// Delete deletes an invoice
-func (r *InvoiceRepository) Delete(id string) error {
-	// Delete invoice items first
-	_, _, err := r.db.Supabase.From("invoice_items").
-		Delete("", "").
-		Eq("invoice_id", id).
-		Execute()
-	
-	if err != nil {
-		return err
+// statusError returns lifecycle.ErrNotAllowed for a write the invoice_status
+// trigger refused, which PostgREST reports with the PT422 code, and err
+// otherwise
+func statusError(err error) error {
+	if msg, ok := strings.CutPrefix(err.Error(), "(PT422) "); ok {
+		return fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, msg)
 	}
+	return err
+}
 
-	// Delete invoice
-	_, _, err = r.db.Supabase.From("invoices").
-		Delete("", "").
-		Eq("id", id).
-		Execute()
-	
+// rpcStatusError is statusError for errors of database functions
+func rpcStatusError(err error) error {
+	var rpcErr *database.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == "PT422" {
+		return fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, rpcErr.Message)
+	}
 	return err
 }
 
-// generateInvoiceNumber generates a unique invoice number
-func (r *InvoiceRepository) generateInvoiceNumber() (string, error) {
-	year := time.Now().Year()
-	
-	// Get count of invoices this year
-	var invoices []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
-		Select("id", "", false).
-		Like("invoice_number", fmt.Sprintf("INV-%d-%%", year)).
-		ExecuteTo(&invoices)
-	
-	if err != nil {
-		return "", err
+// Delete deletes an invoice. A ver other than 0 only deletes the invoice at
+// that version.
+func (r *InvoiceRepository) Delete(id string, ver int) error {
+	if err := version.Check(r.db, "invoices", id, ver, ErrInvoiceNotFound); err != nil {
+		return err
 	}
 
-	nextNumber := len(invoices) + 1
-	return fmt.Sprintf("INV-%d-%04d", year, nextNumber), nil
+	// Delete invoice; its items go with it (ON DELETE CASCADE)
+	var deleted []types.Invoice
+	_, err := version.Match(r.db.From("invoices").
+		Delete("", "").
+		Eq("id", id), ver).
+		ExecuteTo(&deleted)
+
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return version.Missed(r.db, "invoices", id, ErrInvoiceNotFound)
+	}
+	return nil
 }
 
 // GetCustomer returns a customer by ID
 func (r *InvoiceRepository) GetCustomer(id string) (*types.Customer, error) {
 	var customers []types.Customer
-	_, err := r.db.Supabase.From("customers").
+	_, err := r.db.From("customers").
 		Select("*", "", false).
 		Eq("id", id).
 		ExecuteTo(&customers)
-	
+
 	if err != nil {
 		return nil, err
 	}
@@ -210,7 +329,7 @@ func (r *InvoiceRepository) GetCustomer(id string) (*types.Customer, error) {
 // GetCurrencyRates returns all currency exchange rates
 func (r *InvoiceRepository) GetCurrencyRates() ([]types.CurrencyRate, error) {
 	var rates []types.CurrencyRate
-	_, err := r.db.Supabase.From("currency_rates").
+	_, err := r.db.From("currency_rates").
 		Select("*", "", false).
 		Order("from_currency", nil).
 		ExecuteTo(&rates)
@@ -6,7 +6,9 @@ import (
 	"os"
 
 	"invoice-backend/services/notification-service/internal/handler"
+	"invoice-backend/services/shared/pkg/database"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -16,8 +18,16 @@ func main() {
 	// Load environment variables
 	godotenv.Load("../../../.env")
 
+	// Company profiles brand the emails; without a database they are sent
+	// unbranded
+	db, err := database.NewClient()
+	if err != nil {
+		log.Printf("Warning: %v; emails are sent without company profiles", err)
+		db = nil
+	}
+
 	// Initialize handler
-	h := handler.NewNotificationHandler()
+	h := handler.NewNotificationHandler(db)
 
 	// Setup routes
 	r := mux.NewRouter()
@@ -29,7 +39,11 @@ func main() {
 	}).Methods("GET")
 
 	// Apply middleware (no CORS - handled by API Gateway)
-	handler := middleware.Recovery(middleware.Logger("NOTIFICATION-SERVICE")(r))
+	resolver := tenant.FromEnv()
+	if db != nil {
+		resolver.WithLookup(db.OrgExists)
+	}
+	handler := middleware.Recovery(middleware.Logger("NOTIFICATION-SERVICE")(resolver.Middleware(r)))
 
 	// Start server
 	port := os.Getenv("NOTIFICATION_SERVICE_PORT")
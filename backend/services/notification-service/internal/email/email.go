@@ -15,20 +15,41 @@ type SimpleEmailService struct {
 	BaseURL string
 }
 
+// Sender is who an email is sent on behalf of: the name shown as its sender
+// and the address replies go to. The zero Sender sends as the invoice
+// generator, with no reply address.
+type Sender struct {
+	Name    string
+	ReplyTo string
+}
+
+// from returns the From header for sending from address
+func (s Sender) from(address string) string {
+	name := s.Name
+	if name == "" {
+		name = "Invoice Generator"
+	}
+	return name + " <" + address + ">"
+}
+
 // SendEmail sends email using available service (console fallback)
-func SendEmail(to, subject, body string, attachmentName string, attachment []byte) error {
+func SendEmail(sender Sender, to, subject, body string, attachmentName string, attachment []byte) error {
 	// Try Resend first
 	if apiKey := os.Getenv("RESEND_API_KEY"); apiKey != "" {
-		return sendWithResend(to, subject, body, attachmentName, attachment)
+		return sendWithResend(sender, to, subject, body, attachmentName, attachment)
 	}
 
 	// Try Mailgun
 	if apiKey := os.Getenv("MAILGUN_API_KEY"); apiKey != "" {
-		return sendWithMailgun(to, subject, body, attachmentName, attachment)
+		return sendWithMailgun(sender, to, subject, body, attachmentName, attachment)
 	}
 
 	// Fallback to console (development mode)
 	fmt.Printf("=== EMAIL SIMULATION (No service configured) ===\n")
+	fmt.Printf("From: %s\n", sender.from("noreply@localhost"))
+	if sender.ReplyTo != "" {
+		fmt.Printf("Reply-To: %s\n", sender.ReplyTo)
+	}
 	fmt.Printf("To: %s\n", to)
 	fmt.Printf("Subject: %s\n", subject)
 	fmt.Printf("Body: %s\n", body)
@@ -41,18 +62,21 @@ func SendEmail(to, subject, body string, attachmentName string, attachment []byt
 }
 
 // sendWithResend sends email using Resend API
-func sendWithResend(to, subject, body, attachmentName string, attachment []byte) error {
+func sendWithResend(sender Sender, to, subject, body, attachmentName string, attachment []byte) error {
 	apiKey := os.Getenv("RESEND_API_KEY")
 	if apiKey == "" {
 		return fmt.Errorf("RESEND_API_KEY not set")
 	}
 
 	payload := map[string]interface{}{
-		"from":    "Invoice Generator <noreply@resend.dev>",
+		"from":    sender.from("noreply@resend.dev"),
 		"to":      []string{to},
 		"subject": subject,
 		"text":    body,
 	}
+	if sender.ReplyTo != "" {
+		payload["reply_to"] = sender.ReplyTo
+	}
 
 	if attachmentName != "" && len(attachment) > 0 {
 		payload["attachments"] = []map[string]interface{}{
@@ -88,7 +112,7 @@ func sendWithResend(to, subject, body, attachmentName string, attachment []byte)
 }
 
 // sendWithMailgun sends email using Mailgun API
-func sendWithMailgun(to, subject, body, attachmentName string, attachment []byte) error {
+func sendWithMailgun(sender Sender, to, subject, body, attachmentName string, attachment []byte) error {
 	apiKey := os.Getenv("MAILGUN_API_KEY")
 	domain := os.Getenv("MAILGUN_DOMAIN")
 	if apiKey == "" || domain == "" {
@@ -96,8 +120,11 @@ func sendWithMailgun(to, subject, body, attachmentName string, attachment []byte
 	}
 
 	// For simplicity, send without attachment first
-	payload := fmt.Sprintf("from=Invoice Generator <noreply@%s>&to=%s&subject=%s&text=%s",
-		domain, to, subject, body)
+	payload := fmt.Sprintf("from=%s&to=%s&subject=%s&text=%s",
+		sender.from("noreply@"+domain), to, subject, body)
+	if sender.ReplyTo != "" {
+		payload += "&h:Reply-To=" + sender.ReplyTo
+	}
 
 	req, err := http.NewRequest("POST", fmt.Sprintf("https://api.mailgun.net/v3/%s/messages", domain), bytes.NewBufferString(payload))
 	if err != nil {
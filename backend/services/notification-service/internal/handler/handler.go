@@ -2,18 +2,72 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
+	"strings"
 
 	"invoice-backend/services/notification-service/internal/email"
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/tenant"
+	"invoice-backend/services/shared/pkg/types"
 	"invoice-backend/services/shared/pkg/utils"
 )
 
 type NotificationHandler struct {
+	db *database.Client // Reads company profiles; nil sends unbranded emails
 }
 
-func NewNotificationHandler() *NotificationHandler {
-	return &NotificationHandler{}
+func NewNotificationHandler(db *database.Client) *NotificationHandler {
+	return &NotificationHandler{db: db}
+}
+
+// company returns the company profile emails about an invoice are sent on
+// behalf of: the company that issued the invoice, looked up in the
+// organization of the request, or the default company when there is no
+// invoice. It returns nil when the service has no database or the profile
+// cannot be read, and the email goes out unbranded.
+func (h *NotificationHandler) company(r *http.Request, invoiceID string) *types.CompanyProfile {
+	if h.db == nil {
+		return nil
+	}
+
+	companyID := ""
+	if invoiceID != "" {
+		var invoices []types.Invoice
+		_, err := h.db.WithOrg(tenant.Org(r)).From("invoices").Select("id, company_id", "", false).Eq("id", invoiceID).ExecuteTo(&invoices)
+		if err != nil {
+			log.Printf("Failed to read invoice %s: %v", invoiceID, err)
+			return nil
+		}
+		if len(invoices) > 0 {
+			companyID = invoices[0].CompanyID
+		}
+	}
+
+	query := h.db.WithOrg(tenant.Org(r)).From("company_info").Select("*", "", false)
+	if companyID == "" {
+		query = query.Eq("is_default", "true")
+	} else {
+		query = query.Eq("id", companyID)
+	}
+	var profiles []types.CompanyProfile
+	if _, err := query.ExecuteTo(&profiles); err != nil {
+		log.Printf("Failed to read company profile: %v", err)
+		return nil
+	}
+	if len(profiles) == 0 {
+		return nil
+	}
+	return &profiles[0]
+}
+
+// sender returns who emails of company are sent as
+func sender(company *types.CompanyProfile) email.Sender {
+	if company == nil {
+		return email.Sender{}
+	}
+	return email.Sender{Name: company.Name, ReplyTo: company.Email}
 }
 
 // SendEmail handles POST /notifications/send
@@ -22,6 +76,9 @@ func (h *NotificationHandler) SendEmail(w http.ResponseWriter, r *http.Request)
 		To      string `json:"to"`
 		Subject string `json:"subject"`
 		Body    string `json:"body"`
+		// InvoiceID sends the email on behalf of the company that issued
+		// the invoice; without it the default company sends it
+		InvoiceID string `json:"invoice_id,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -34,7 +91,7 @@ func (h *NotificationHandler) SendEmail(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := email.SendEmail(req.To, req.Subject, req.Body, "", nil); err != nil {
+	if err := email.SendEmail(sender(h.company(r, req.InvoiceID)), req.To, req.Subject, req.Body, "", nil); err != nil {
 		utils.InternalError(w, "Failed to send email: "+err.Error())
 		return
 	}
@@ -48,12 +105,13 @@ func (h *NotificationHandler) SendEmail(w http.ResponseWriter, r *http.Request)
 // SendPaymentReminder handles POST /notifications/reminder
 func (h *NotificationHandler) SendPaymentReminder(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		InvoiceID     string  `json:"invoice_id"`
-		CustomerEmail string  `json:"customer_email"`
-		CustomerName  string  `json:"customer_name"`
-		InvoiceNumber string  `json:"invoice_number"`
-		Amount        float64 `json:"amount"`
-		DueDate       string  `json:"due_date"`
+		InvoiceID     string       `json:"invoice_id"`
+		CustomerEmail string       `json:"customer_email"`
+		CustomerName  string       `json:"customer_name"`
+		InvoiceNumber string       `json:"invoice_number"`
+		Amount        money.Amount `json:"amount"`
+		Currency      string       `json:"currency"`
+		DueDate       string       `json:"due_date"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -66,11 +124,17 @@ func (h *NotificationHandler) SendPaymentReminder(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Send payment reminder email
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+
+	// Send payment reminder email, signed by the company that issued the
+	// invoice
+	company := h.company(r, req.InvoiceID)
 	subject := "Payment Reminder - Invoice " + req.InvoiceNumber
-	body := h.generateReminderBody(req.CustomerName, req.InvoiceNumber, req.Amount, req.DueDate)
+	body := h.generateReminderBody(company, req.CustomerName, req.InvoiceNumber, money.New(req.Amount, req.Currency), req.DueDate)
 
-	if err := email.SendEmail(req.CustomerEmail, subject, body, "", nil); err != nil {
+	if err := email.SendEmail(sender(company), req.CustomerEmail, subject, body, "", nil); err != nil {
 		utils.InternalError(w, "Failed to send reminder: "+err.Error())
 		return
 	}
@@ -81,24 +145,50 @@ func (h *NotificationHandler) SendPaymentReminder(w http.ResponseWriter, r *http
 	})
 }
 
-// generateReminderBody generates email body for payment reminder
-func (h *NotificationHandler) generateReminderBody(customerName, invoiceNumber string, amount float64, dueDate string) string {
+// generateReminderBody generates email body for payment reminder. The
+// company's bank account is given for the payment and the reminder is
+// signed with its name and contact details.
+func (h *NotificationHandler) generateReminderBody(company *types.CompanyProfile, customerName, invoiceNumber string, amount money.Money, dueDate string) string {
+	payment := "Please process the payment at your earliest convenience."
+	signature := ""
+	if company != nil {
+		if company.BankName != "" || company.BankAccountNumber != "" {
+			payment = "Please process the payment at your earliest convenience to:"
+			for _, line := range [][2]string{
+				{"Bank", company.BankName},
+				{"Account Name", company.BankAccountName},
+				{"Account Number", company.BankAccountNumber},
+				{"SWIFT/BIC", company.BankSwift},
+			} {
+				if line[1] != "" {
+					payment += "\n- " + line[0] + ": " + line[1]
+				}
+			}
+		}
+		lines := []string{company.Name}
+		for _, contact := range []string{company.Email, company.Phone, company.Website} {
+			if contact != "" {
+				lines = append(lines, contact)
+			}
+		}
+		signature = strings.Join(lines, "\n") + "\n"
+	}
+
 	return `Dear ` + customerName + `,
 
 This is a friendly reminder that payment for Invoice ` + invoiceNumber + ` is due.
 
 Invoice Details:
 - Invoice Number: ` + invoiceNumber + `
-- Amount: $` + fmt.Sprintf("%.2f", amount) + `
+- Amount: ` + amount.String() + `
 - Due Date: ` + dueDate + `
 
-Please process the payment at your earliest convenience.
+` + payment + `
 
 If you have already made the payment, please disregard this message.
 
 Thank you for your business!
 
 Best regards,
-InvoicePro Systems
-`
+` + signature
 }
@@ -9,6 +9,7 @@ import (
 	"invoice-backend/services/payment-service/internal/repository"
 	"invoice-backend/services/shared/pkg/database"
 	"invoice-backend/services/shared/pkg/middleware"
+	"invoice-backend/services/shared/pkg/tenant"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -33,13 +34,20 @@ func main() {
 	r.HandleFunc("/payments", h.Create).Methods("POST")
 	r.HandleFunc("/payments/invoice/{id}", h.GetByInvoiceID).Methods("GET")
 	r.HandleFunc("/payments", h.GetAll).Methods("GET")
+	r.HandleFunc("/payments/{id}", h.Get).Methods("GET")
+	r.HandleFunc("/payments/{id}", h.Correct).Methods("PUT")
+	r.HandleFunc("/payments/{id}/void", h.Void).Methods("POST")
+	r.HandleFunc("/payments/{id}/allocations", h.Allocate).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", h.Refund).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", h.GetRefunds).Methods("GET")
+	r.HandleFunc("/payments/{id}/history", h.History).Methods("GET")
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy","service":"payment-service"}`))
 	}).Methods("GET")
 
 	// Apply middleware (no CORS - handled by API Gateway)
-	handler := middleware.Recovery(middleware.Logger("PAYMENT-SERVICE")(r))
+	handler := middleware.Recovery(middleware.Logger("PAYMENT-SERVICE")(tenant.FromEnv().WithLookup(db.OrgExists).Middleware(r)))
 
 	// Start server
 	port := os.Getenv("PAYMENT_SERVICE_PORT")
@@ -2,15 +2,28 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 
 	"invoice-backend/services/payment-service/internal/repository"
+	"invoice-backend/services/shared/pkg/audit"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/pagination"
+	"invoice-backend/services/shared/pkg/tenant"
 	"invoice-backend/services/shared/pkg/types"
 	"invoice-backend/services/shared/pkg/utils"
+	"invoice-backend/services/shared/pkg/version"
 
 	"github.com/gorilla/mux"
 )
 
+// errPaymentChanged answers a write whose If-Match names a version of the
+// payment that is no longer current
+const errPaymentChanged = "payment was changed by someone else; reload it and try again"
+
 type PaymentHandler struct {
 	repo *repository.PaymentRepository
 }
@@ -19,6 +32,11 @@ func NewPaymentHandler(repo *repository.PaymentRepository) *PaymentHandler {
 	return &PaymentHandler{repo: repo}
 }
 
+// repoFor returns the repository scoped to the organization of a request
+func (h *PaymentHandler) repoFor(r *http.Request) *repository.PaymentRepository {
+	return h.repo.WithOrg(tenant.Org(r))
+}
+
 // Create handles POST /payments
 func (h *PaymentHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req types.PaymentCreate
@@ -27,38 +45,52 @@ func (h *PaymentHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.InvoiceID == "" || req.Amount <= 0 || req.PaymentMethod == "" {
-		utils.BadRequest(w, "invoice_id, amount, and payment_method required")
+	if (req.InvoiceID == "" && req.CustomerID == "") || req.Amount <= 0 || req.PaymentMethod == "" {
+		utils.BadRequest(w, "invoice_id or customer_id, amount, and payment_method required")
 		return
 	}
-
-	// Get invoice to update payment status
-	invoice, err := h.repo.GetInvoice(req.InvoiceID)
-	if err != nil {
-		utils.NotFound(w, "Invoice not found")
+	if req.PaymentMethod == types.PaymentMethodCredit {
+		utils.BadRequest(w, "Credit is applied with POST /invoices/{id}/apply-credit")
 		return
 	}
-
-	// Create payment record
-	payment, err := h.repo.Create(req)
-	if err != nil {
-		utils.InternalError(w, err.Error())
+	if req.InvoiceID != "" && (len(req.Allocations) > 0 || req.AutoAllocate) {
+		utils.BadRequest(w, "A payment is allocated across invoices without invoice_id")
+		return
+	}
+	if len(req.Allocations) > 0 && req.AutoAllocate {
+		utils.BadRequest(w, "allocations and auto_allocate are exclusive")
+		return
+	}
+	if msg := validateAllocations(req.Allocations); msg != "" {
+		utils.BadRequest(w, msg)
 		return
 	}
 
-	// Calculate new paid amount
-	newPaidAmount := invoice.PaidAmount + req.Amount
-	
-	// Determine payment status
-	paymentStatus := "partially_paid"
-	if newPaidAmount >= invoice.Total {
-		paymentStatus = "paid"
-		newPaidAmount = invoice.Total // Cap at total
+	// Retries carrying the same Idempotency-Key return the original payment
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		utils.BadRequest(w, "Idempotency-Key must be at most 255 characters")
+		return
 	}
 
-	// Update invoice
-	if err := h.repo.UpdateInvoice(req.InvoiceID, newPaidAmount, paymentStatus, req.PaymentDate); err != nil {
-		utils.InternalError(w, "Failed to update invoice: "+err.Error())
+	req.CreatedBy = audit.Actor(r)
+
+	// Insert the payment and update the invoice atomically
+	payment, err := h.repoFor(r).WithActor(audit.Actor(r)).Record(req, idempotencyKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInvoiceNotFound):
+			utils.NotFound(w, "Invoice not found")
+		case errors.Is(err, repository.ErrCustomerNotFound):
+			utils.NotFound(w, "Customer not found")
+		case errors.Is(err, repository.ErrAllocationNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, repository.ErrIdempotencyConflict), errors.Is(err, lifecycle.ErrNotAllowed),
+			errors.Is(err, repository.ErrOverallocated):
+			utils.Error(w, http.StatusConflict, err.Error())
+		default:
+			utils.InternalError(w, err.Error())
+		}
 		return
 	}
 
@@ -70,7 +102,7 @@ func (h *PaymentHandler) GetByInvoiceID(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	invoiceID := vars["id"]
 
-	payments, err := h.repo.GetByInvoiceID(invoiceID)
+	payments, err := h.repoFor(r).GetByInvoiceID(invoiceID)
 	if err != nil {
 		utils.InternalError(w, err.Error())
 		return
@@ -80,10 +112,218 @@ func (h *PaymentHandler) GetByInvoiceID(w http.ResponseWriter, r *http.Request)
 
 // GetAll handles GET /payments
 func (h *PaymentHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	payments, err := h.repo.GetAll()
+	where, err := filter.Parse(r.URL.Query().Get("filter"), repository.Filter)
 	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := pagination.Parse(r, repository.Sort)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	payments, meta, err := h.repoFor(r).GetAll(where, page)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalid) {
+			utils.BadRequest(w, err.Error())
+			return
+		}
 		utils.InternalError(w, err.Error())
 		return
 	}
-	utils.Success(w, payments)
+	utils.Paginated(w, r, payments, meta)
+}
+
+// Get handles GET /payments/{id}
+func (h *PaymentHandler) Get(w http.ResponseWriter, r *http.Request) {
+	payment, err := h.repoFor(r).GetByID(mux.Vars(r)["id"])
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	version.SetETag(w, payment.Version)
+	utils.Success(w, payment)
+}
+
+// Correct handles PUT /payments/{id}, replacing the details of a payment
+// that was recorded wrong; reason tells why. The invoice's paid_amount and
+// status are recomputed from its payments. With If-Match the correction only
+// applies to the version named by the ETag.
+func (h *PaymentHandler) Correct(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errPaymentChanged)
+		return
+	}
+
+	var req types.PaymentCorrection
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Amount <= 0 || req.PaymentMethod == "" || req.Reason == "" {
+		utils.BadRequest(w, "amount, payment_method, and reason required")
+		return
+	}
+
+	payment, err := h.repoFor(r).WithActor(audit.Actor(r)).Correct(mux.Vars(r)["id"], ver, req)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	version.SetETag(w, payment.Version)
+	utils.Success(w, payment)
+}
+
+// Void handles POST /payments/{id}/void. A void payment keeps its receipt
+// number and history but no longer counts towards its invoice, whose
+// paid_amount and status are recomputed. With If-Match the payment is only
+// voided at the version named by the ETag.
+func (h *PaymentHandler) Void(w http.ResponseWriter, r *http.Request) {
+	ver, ok := version.IfMatch(r)
+	if !ok {
+		utils.PreconditionFailed(w, errPaymentChanged)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		utils.BadRequest(w, "reason required")
+		return
+	}
+
+	payment, err := h.repoFor(r).WithActor(audit.Actor(r)).Void(mux.Vars(r)["id"], ver, reason)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	version.SetETag(w, payment.Version)
+	utils.Success(w, payment)
+}
+
+// Refund handles POST /payments/{id}/refunds. The refund method defaults to
+// the payment's, and the invoice's paid_amount and status are recomputed.
+func (h *PaymentHandler) Refund(w http.ResponseWriter, r *http.Request) {
+	var req types.RefundCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+
+	switch {
+	case req.Amount == 0:
+		utils.BadRequest(w, "amount required")
+		return
+	case req.Amount < 0:
+		utils.BadRequest(w, "amount must be positive")
+		return
+	}
+
+	req.CreatedBy = audit.Actor(r)
+
+	refund, err := h.repoFor(r).WithActor(audit.Actor(r)).Refund(mux.Vars(r)["id"], req)
+	if err != nil {
+		paymentError(w, err)
+		return
+	}
+
+	utils.Created(w, refund)
+}
+
+// Allocate handles POST /payments/{id}/allocations, allocating what is left
+// of a payment without an invoice to invoices of its customer in its
+// currency, {"allocations": [{"invoice_id", "amount"}]}, or without
+// allocations oldest due first
+func (h *PaymentHandler) Allocate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Allocations []types.PaymentAllocation `json:"allocations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.BadRequest(w, "Invalid JSON")
+		return
+	}
+	if msg := validateAllocations(req.Allocations); msg != "" {
+		utils.BadRequest(w, msg)
+		return
+	}
+
+	payment, err := h.repoFor(r).WithActor(audit.Actor(r)).Allocate(mux.Vars(r)["id"], req.Allocations, audit.Actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrAllocationNotFound):
+			utils.NotFound(w, err.Error())
+		case errors.Is(err, lifecycle.ErrNotAllowed), errors.Is(err, repository.ErrOverallocated):
+			utils.Error(w, http.StatusConflict, err.Error())
+		default:
+			paymentError(w, err)
+		}
+		return
+	}
+
+	utils.Created(w, payment)
+}
+
+// validateAllocations explains what is wrong with allocations, or returns ""
+func validateAllocations(allocations []types.PaymentAllocation) string {
+	seen := make(map[string]bool, len(allocations))
+	for _, a := range allocations {
+		if a.InvoiceID == "" || a.Amount <= 0 {
+			return "Each allocation needs an invoice_id and a positive amount"
+		}
+		if seen[a.InvoiceID] {
+			return "Invoice " + a.InvoiceID + " is allocated more than once"
+		}
+		seen[a.InvoiceID] = true
+	}
+	return ""
+}
+
+// GetRefunds handles GET /payments/{id}/refunds
+func (h *PaymentHandler) GetRefunds(w http.ResponseWriter, r *http.Request) {
+	refunds, err := h.repoFor(r).GetRefunds(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, refunds)
+}
+
+// History handles GET /payments/{id}/history
+func (h *PaymentHandler) History(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.repoFor(r).History(mux.Vars(r)["id"])
+	if err != nil {
+		utils.InternalError(w, err.Error())
+		return
+	}
+	utils.Success(w, entries)
+}
+
+// paymentError answers a failed change to a payment
+func paymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrPaymentNotFound):
+		utils.NotFound(w, "Payment not found")
+	case errors.Is(err, version.ErrConflict):
+		utils.PreconditionFailed(w, errPaymentChanged)
+	case errors.Is(err, repository.ErrPaymentVoided), errors.Is(err, repository.ErrRefundExceedsPayment),
+		errors.Is(err, repository.ErrInsufficientCredit), errors.Is(err, repository.ErrPaymentAllocated):
+		utils.Error(w, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(w, err.Error())
+	}
 }
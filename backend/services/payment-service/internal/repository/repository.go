@@ -1,10 +1,51 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 
+	"invoice-backend/services/shared/pkg/audit"
 	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/filter"
+	"invoice-backend/services/shared/pkg/lifecycle"
+	"invoice-backend/services/shared/pkg/pagination"
 	"invoice-backend/services/shared/pkg/types"
+	"invoice-backend/services/shared/pkg/version"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	// ErrInvoiceNotFound is returned when a payment references a missing invoice
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	// ErrIdempotencyConflict is returned when an idempotency key is reused for a different payment
+	ErrIdempotencyConflict = errors.New("idempotency key already used for a different payment")
+	// ErrPaymentNotFound is returned for a missing payment
+	ErrPaymentNotFound = errors.New("payment not found")
+	// ErrPaymentVoided is returned when a void payment is refunded, corrected
+	// or voided again
+	ErrPaymentVoided = errors.New("payment is void")
+	// ErrRefundExceedsPayment is returned when a refund would return more of
+	// a payment than is left of it after its earlier refunds
+	ErrRefundExceedsPayment = errors.New("refund exceeds what is left of the payment")
+	// ErrCustomerNotFound is returned when a receipt references a missing
+	// customer
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrInsufficientCredit is returned when a refund, correction or void
+	// would take back customer credit that was already applied
+	ErrInsufficientCredit = errors.New("not enough customer credit")
+	// ErrAllocationNotFound is returned when a payment allocation references
+	// a missing payment or customer, or an invoice that is not the
+	// customer's in the payment's currency
+	ErrAllocationNotFound = errors.New("payment, customer or invoice to allocate to not found")
+	// ErrOverallocated is returned when allocating more of a payment than is
+	// left of it, or more to an invoice than is owed on it
+	ErrOverallocated = errors.New("allocation exceeds what is left of the payment or owed on the invoice")
+	// ErrPaymentAllocated is returned when allocating a payment recorded on
+	// an invoice, or when a refund, correction or void would take back what
+	// was allocated of a payment
+	ErrPaymentAllocated = errors.New("payment is allocated to invoices")
 )
 
 type PaymentRepository struct {
@@ -15,73 +56,356 @@ func NewPaymentRepository(db *database.Client) *PaymentRepository {
 	return &PaymentRepository{db: db}
 }
 
-// Create records a new payment
-func (r *PaymentRepository) Create(payment types.PaymentCreate) (*types.Payment, error) {
-	var result []types.Payment
-	_, err := r.db.Supabase.From("payments").
-		Insert(payment, false, "", "", "").
-		ExecuteTo(&result)
-	
-	if err != nil {
+// WithActor returns a repository whose changes the audit log attributes to
+// actor
+func (r *PaymentRepository) WithActor(actor string) *PaymentRepository {
+	return &PaymentRepository{db: r.db.WithActor(actor)}
+}
+
+// WithOrg returns a repository scoped to the organization org
+func (r *PaymentRepository) WithOrg(org string) *PaymentRepository {
+	return &PaymentRepository{db: r.db.WithOrg(org)}
+}
+
+// History returns the audit log entries of a payment and its refunds, oldest
+// first
+func (r *PaymentRepository) History(id string) ([]audit.Entry, error) {
+	return audit.History(r.db, id, audit.Payment, audit.Refund)
+}
+
+// Record records a payment through the record_payment database function,
+// which locks the invoice, inserts the payment and recomputes the invoice's
+// paid_amount and status from its payments in one transaction. A non-empty idempotencyKey
+// that was already used returns the original payment. Drafts and closed
+// invoices do not take payments (lifecycle.ErrNotAllowed). What is paid
+// beyond the invoice's balance goes to the customer's credit, and so does
+// all of a payment without an invoice, recorded through record_receipt,
+// that is not allocated to the customer's invoices (see Allocate).
+func (r *PaymentRepository) Record(payment types.PaymentCreate, idempotencyKey string) (*types.Payment, error) {
+	function, notFound := "record_payment", ErrInvoiceNotFound
+	args := map[string]interface{}{
+		"p_amount":         payment.Amount,
+		"p_payment_method": payment.PaymentMethod,
+	}
+	if payment.InvoiceID != "" {
+		args["p_invoice_id"] = payment.InvoiceID
+	} else {
+		function, notFound = "record_receipt", ErrCustomerNotFound
+		args["p_customer_id"] = payment.CustomerID
+		if payment.Currency != "" {
+			args["p_currency"] = payment.Currency
+		}
+		if len(payment.Allocations) > 0 || payment.AutoAllocate {
+			notFound = ErrAllocationNotFound
+			args["p_allocations"] = payment.Allocations
+			args["p_auto_allocate"] = payment.AutoAllocate
+		}
+	}
+	if payment.PaymentDate != "" {
+		args["p_payment_date"] = payment.PaymentDate
+	}
+	if payment.ReferenceNumber != "" {
+		args["p_reference_number"] = payment.ReferenceNumber
+	}
+	if payment.Notes != "" {
+		args["p_notes"] = payment.Notes
+	}
+	if payment.CreatedBy != "" {
+		args["p_created_by"] = payment.CreatedBy
+	}
+	if idempotencyKey != "" {
+		args["p_idempotency_key"] = idempotencyKey
+	}
+
+	var result types.Payment
+	if err := r.db.RPC(function, args, &result); err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, fmt.Errorf("%w: %s", notFound, rpcErr.Message)
+			case "PT409":
+				return nil, ErrIdempotencyConflict
+			case "PT413":
+				return nil, fmt.Errorf("%w: %s", ErrOverallocated, rpcErr.Message)
+			case "PT422":
+				return nil, fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, rpcErr.Message)
+			}
+		}
 		return nil, err
 	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no payment created")
+	if result.InvoiceID == "" {
+		if err := r.withAllocations(&result); err != nil {
+			return nil, err
+		}
 	}
-	return &result[0], nil
+	return &result, nil
 }
 
-// GetByInvoiceID returns all payments for an invoice
-func (r *PaymentRepository) GetByInvoiceID(invoiceID string) ([]types.Payment, error) {
-	var payments []types.Payment
-	_, err := r.db.Supabase.From("payments").
+// Allocate allocates what is left of a payment without an invoice to
+// invoices of its customer in its currency through the allocate_payment
+// database function, which locks the invoices and the customer, checks what
+// is left and owed and records each allocation as a credit application of
+// its invoice, with payment_id set to the payment, in one transaction (see
+// migration 0020_payment_allocations). Without allocations the payment is
+// allocated oldest due first.
+func (r *PaymentRepository) Allocate(id string, allocations []types.PaymentAllocation, createdBy string) (*types.Payment, error) {
+	args := map[string]interface{}{
+		"p_payment_id": id,
+	}
+	if len(allocations) > 0 {
+		args["p_allocations"] = allocations
+	}
+	if createdBy != "" {
+		args["p_created_by"] = createdBy
+	}
+
+	var result types.Payment
+	if err := r.db.RPC("allocate_payment", args, &result); err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT404":
+				return nil, fmt.Errorf("%w: %s", ErrAllocationNotFound, rpcErr.Message)
+			case "PT410":
+				return nil, ErrPaymentVoided
+			case "PT413":
+				return nil, fmt.Errorf("%w: %s", ErrOverallocated, rpcErr.Message)
+			case "PT422":
+				return nil, fmt.Errorf("%w: %s", lifecycle.ErrNotAllowed, rpcErr.Message)
+			case "PT423":
+				return nil, fmt.Errorf("%w: %s", ErrPaymentAllocated, rpcErr.Message)
+			}
+		}
+		return nil, err
+	}
+	return r.GetByID(id)
+}
+
+// withAllocations reads the allocations of a payment into it, and what is
+// allocated of it: the allocations that are not void
+func (r *PaymentRepository) withAllocations(p *types.Payment) error {
+	var allocations []types.CreditApplication
+	_, err := r.db.From("credit_applications").
 		Select("*", "", false).
-		Eq("invoice_id", invoiceID).
-		Order("payment_date", nil).
-		ExecuteTo(&payments)
-	return payments, err
+		Eq("payment_id", p.ID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&allocations)
+	if err != nil {
+		return err
+	}
+	p.Allocations = allocations
+	p.AllocatedAmount = 0
+	for _, a := range allocations {
+		if a.VoidedAt == "" {
+			p.AllocatedAmount = p.AllocatedAmount.Add(a.Amount)
+		}
+	}
+	return nil
 }
 
-// GetAll returns all payments
-func (r *PaymentRepository) GetAll() ([]types.Payment, error) {
+// GetByInvoiceID returns all payments for an invoice, with their refunds
+func (r *PaymentRepository) GetByInvoiceID(invoiceID string) ([]types.Payment, error) {
 	var payments []types.Payment
-	_, err := r.db.Supabase.From("payments").
-		Select("*", "", false).
+	_, err := r.db.From("payments").
+		Select("*, refunds(*)", "", false).
+		Eq("invoice_id", invoiceID).
 		Order("payment_date", nil).
 		ExecuteTo(&payments)
+	for _, p := range payments {
+		sort.Slice(p.Refunds, func(i, j int) bool { return p.Refunds[i].RefundDate < p.Refunds[j].RefundDate })
+	}
 	return payments, err
 }
 
-// GetInvoice returns an invoice by ID
-func (r *PaymentRepository) GetInvoice(id string) (*types.Invoice, error) {
-	var invoices []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
-		Select("*", "", false).
+// GetByID returns a payment with its refunds and allocations
+func (r *PaymentRepository) GetByID(id string) (*types.Payment, error) {
+	var payments []types.Payment
+	_, err := r.db.From("payments").
+		Select("*, refunds(*)", "", false).
 		Eq("id", id).
-		ExecuteTo(&invoices)
-	
+		ExecuteTo(&payments)
 	if err != nil {
 		return nil, err
 	}
-	if len(invoices) == 0 {
-		return nil, fmt.Errorf("invoice not found")
+	if len(payments) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+	p := payments[0]
+	sort.Slice(p.Refunds, func(i, j int) bool { return p.Refunds[i].RefundDate < p.Refunds[j].RefundDate })
+	if err := r.withAllocations(&p); err != nil {
+		return nil, err
 	}
-	return &invoices[0], nil
+	return &p, nil
 }
 
-// UpdateInvoice updates invoice payment status
-func (r *PaymentRepository) UpdateInvoice(id string, paidAmount float64, paymentStatus, paymentDate string) error {
-	updateData := map[string]interface{}{
-		"paid_amount":    paidAmount,
-		"payment_status": paymentStatus,
-		"payment_date":   paymentDate,
+// Correct replaces the details of the payment at ver through the
+// correct_payment database function, which locks the payment's invoice,
+// updates the payment and recomputes the invoice's paid_amount and status
+// from its payments in one transaction (see migration
+// 0018_payment_corrections). A ver of 0 corrects whatever version is
+// current. A payment cannot be corrected below what was refunded of it.
+func (r *PaymentRepository) Correct(id string, ver int, correction types.PaymentCorrection) (*types.Payment, error) {
+	fields := map[string]interface{}{
+		"amount":            correction.Amount,
+		"payment_method":    correction.PaymentMethod,
+		"payment_date":      nil,
+		"reference_number":  nil,
+		"notes":             nil,
+		"correction_reason": correction.Reason,
+	}
+	if correction.PaymentDate != "" {
+		fields["payment_date"] = correction.PaymentDate
+	}
+	if correction.ReferenceNumber != "" {
+		fields["reference_number"] = correction.ReferenceNumber
+	}
+	if correction.Notes != "" {
+		fields["notes"] = correction.Notes
+	}
+	args := map[string]interface{}{
+		"p_payment_id": id,
+		"p_payment":    fields,
+	}
+	if ver != 0 {
+		args["p_version"] = ver
 	}
 
-	var updated []types.Invoice
-	_, err := r.db.Supabase.From("invoices").
-		Update(updateData, "", "").
-		Eq("id", id).
-		ExecuteTo(&updated)
-	
+	var result types.Payment
+	if err := r.db.RPC("correct_payment", args, &result); err != nil {
+		return nil, paymentRPCError(err)
+	}
+	return r.GetByID(id)
+}
+
+// Void voids the payment at ver, with the reason, through the void_payment
+// database function, which recomputes the invoice's paid_amount and status
+// from the payments left in the same transaction. A ver of 0 voids whatever
+// version is current.
+func (r *PaymentRepository) Void(id string, ver int, reason string) (*types.Payment, error) {
+	args := map[string]interface{}{
+		"p_payment_id": id,
+		"p_reason":     reason,
+	}
+	if ver != 0 {
+		args["p_version"] = ver
+	}
+
+	var result types.Payment
+	if err := r.db.RPC("void_payment", args, &result); err != nil {
+		return nil, paymentRPCError(err)
+	}
+	return r.GetByID(id)
+}
+
+// paymentRPCError maps the errors raised by correct_payment and void_payment
+func paymentRPCError(err error) error {
+	var rpcErr *database.RPCError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case "PT402":
+			return fmt.Errorf("%w: %s", ErrInsufficientCredit, rpcErr.Message)
+		case "PT404":
+			return ErrPaymentNotFound
+		case "PT409":
+			return fmt.Errorf("%w: %s", ErrRefundExceedsPayment, rpcErr.Message)
+		case "PT410":
+			return ErrPaymentVoided
+		case "PT412":
+			return version.ErrConflict
+		case "PT423":
+			return fmt.Errorf("%w: %s", ErrPaymentAllocated, rpcErr.Message)
+		}
+	}
 	return err
 }
+
+// Refund records a refund of a payment through the record_refund database
+// function, which locks the payment's invoice, inserts the refund and
+// recomputes the invoice's paid_amount and status from its payments in one
+// transaction
+func (r *PaymentRepository) Refund(paymentID string, refund types.RefundCreate) (*types.Refund, error) {
+	args := map[string]interface{}{
+		"p_payment_id": paymentID,
+		"p_amount":     refund.Amount,
+	}
+	if refund.RefundMethod != "" {
+		args["p_refund_method"] = refund.RefundMethod
+	}
+	if refund.RefundDate != "" {
+		args["p_refund_date"] = refund.RefundDate
+	}
+	if refund.ReferenceNumber != "" {
+		args["p_reference_number"] = refund.ReferenceNumber
+	}
+	if refund.Reason != "" {
+		args["p_reason"] = refund.Reason
+	}
+	if refund.CreatedBy != "" {
+		args["p_created_by"] = refund.CreatedBy
+	}
+
+	var result types.Refund
+	if err := r.db.RPC("record_refund", args, &result); err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch rpcErr.Code {
+			case "PT402":
+				return nil, fmt.Errorf("%w: %s", ErrInsufficientCredit, rpcErr.Message)
+			case "PT404":
+				return nil, ErrPaymentNotFound
+			case "PT409":
+				return nil, fmt.Errorf("%w: %s", ErrRefundExceedsPayment, rpcErr.Message)
+			case "PT410":
+				return nil, ErrPaymentVoided
+			case "PT423":
+				return nil, fmt.Errorf("%w: %s", ErrPaymentAllocated, rpcErr.Message)
+			}
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetRefunds returns the refunds of a payment, oldest first
+func (r *PaymentRepository) GetRefunds(paymentID string) ([]types.Refund, error) {
+	refunds := []types.Refund{}
+	_, err := r.db.From("refunds").
+		Select("*", "", false).
+		Eq("payment_id", paymentID).
+		Order("refund_date", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&refunds)
+	return refunds, err
+}
+
+// Sort lists the fields payments can be sorted by
+var Sort = pagination.Sort{Fields: []string{"payment_date", "amount", "created_at"}, Default: "payment_date"}
+
+// Filter lists the fields payments can be filtered by
+var Filter = filter.Schema{
+	"invoice_id":       {Column: "invoice_id", Type: filter.ID},
+	"customer_id":      {Column: "customer_id", Type: filter.ID},
+	"currency":         {Column: "currency", Type: filter.String},
+	"receipt_number":   {Column: "receipt_number", Type: filter.String},
+	"amount":           {Column: "amount", Type: filter.Number},
+	"refunded_amount":  {Column: "refunded_amount", Type: filter.Number},
+	"payment_method":   {Column: "payment_method", Type: filter.String},
+	"reference_number": {Column: "reference_number", Type: filter.String},
+	"notes":            {Column: "notes", Type: filter.String},
+	"payment_date":     {Column: "payment_date", Type: filter.Timestamp},
+	"created_at":       {Column: "created_at", Type: filter.Timestamp},
+	"voided_at":        {Column: "voided_at", Type: filter.Timestamp},
+}
+
+// GetAll returns one page of the payments matching where (nil for all)
+func (r *PaymentRepository) GetAll(where *filter.Expr, page pagination.Request) ([]types.Payment, *pagination.Meta, error) {
+	apply := func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if where != nil {
+			query = query.And(where.PostgREST(), "")
+		}
+		return query
+	}
+
+	return pagination.Fetch(r.db, "payments", "*", page, apply,
+		func(p types.Payment) string { return p.ID })
+}
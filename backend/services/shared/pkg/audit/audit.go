@@ -0,0 +1,94 @@
+// Package audit reads the audit log and identifies who makes a request.
+//
+// The log itself is written by database triggers (see migration
+// 0007_audit_log), which take the actor from the X-Actor header of the
+// PostgREST request. Repositories send it by using a database client from
+// database.Client.WithActor(audit.Actor(r)).
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"invoice-backend/services/shared/pkg/database"
+	"invoice-backend/services/shared/pkg/tenant"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Entity types recorded in the audit log
+const (
+	Customer       = "customer"
+	Invoice        = "invoice"
+	InvoiceItem    = "invoice_item" // Recorded under the invoice's ID
+	Payment        = "payment"
+	Refund         = "refund" // Recorded under the payment's ID
+	Product        = "product"
+	ProductPrice   = "product_price" // Recorded under the product's ID
+	PriceList      = "price_list"
+	PriceListRule  = "price_list_rule" // Recorded under the price list's ID
+	Company        = "company_profile"
+	CreditNote     = "credit_note"
+	CreditNoteItem = "credit_note_item" // Recorded under the credit note's ID
+	// CreditApplication is recorded under the invoice's ID
+	CreditApplication = "credit_application"
+)
+
+// anonymous is recorded for requests without a verified token
+const anonymous = "anonymous"
+
+// Entry is one change recorded in the audit log
+type Entry struct {
+	ID         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	Action     string `json:"action"` // create, update or delete
+	Actor      string `json:"actor"`
+	// Changes maps each changed field to its value before and after the
+	// change: {"status": {"from": "draft", "to": "sent"}}
+	Changes   json.RawMessage `json:"changes"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// Actor returns who made a request: the subject of its verified token, as
+// the tenant middleware of the gateway and the services resolved it
+func Actor(r *http.Request) string {
+	if a := strings.TrimSpace(tenant.Actor(r)); a != "" {
+		return a
+	}
+	return anonymous
+}
+
+// organizationEntities are the entity types of organization records, whose
+// entries are only read within the organization they were written in
+var organizationEntities = map[string]bool{
+	Customer: true, Invoice: true, InvoiceItem: true, Payment: true, Refund: true,
+	Product: true, ProductPrice: true, PriceList: true, PriceListRule: true, Company: true,
+	CreditNote: true, CreditNoteItem: true, CreditApplication: true,
+}
+
+// History returns the audit log entries recorded under an entity ID for any
+// of the entity types, oldest first. Entries outlive the entity, so the
+// history of a deleted record can still be read.
+func History(db *database.Client, entityID string, entityTypes ...string) ([]Entry, error) {
+	query := db.Supabase.From("audit_log").
+		Select("*", "", false).
+		Eq("entity_id", entityID).
+		In("entity_type", entityTypes)
+	for _, entityType := range entityTypes {
+		if organizationEntities[entityType] && db.Org() != "" {
+			query = query.Eq("org_id", db.Org())
+			break
+		}
+	}
+
+	entries := []Entry{}
+	_, err := query.
+		Order("id", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
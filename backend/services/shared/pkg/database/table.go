@@ -0,0 +1,61 @@
+package database
+
+import "github.com/supabase-community/postgrest-go"
+
+// tenantTables are the tables (and views) whose rows belong to an
+// organization, in their org_id column
+var tenantTables = map[string]bool{
+	"customers":        true,
+	"invoices":         true,
+	"payments":         true,
+	"currency_rates":   true,
+	"credit_notes":     true,
+	"refunds":          true,
+	"item_sales":       true,
+	"customer_credits": true,
+
+	"credit_applications":    true,
+	"products":               true,
+	"price_lists":            true,
+	"company_info":           true,
+	"number_series":          true,
+	"number_series_counters": true,
+	"document_numbers":       true,
+}
+
+// Table is a PostgREST table whose reads, updates and deletes are limited
+// to the client's organization when its rows belong to one. Inserted rows
+// take the organization from the X-Org-ID header.
+type Table struct {
+	*postgrest.QueryBuilder
+	org string
+}
+
+// From starts a query on a table, scoped to the client's organization. Use
+// it instead of Supabase.From for the tables of organization records.
+func (c *Client) From(table string) Table {
+	t := Table{QueryBuilder: c.Supabase.From(table)}
+	if tenantTables[table] {
+		t.org = c.org
+	}
+	return t
+}
+
+func (t Table) scope(query *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	if t.org == "" {
+		return query
+	}
+	return query.Eq("org_id", t.org)
+}
+
+func (t Table) Select(columns, count string, head bool) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Select(columns, count, head))
+}
+
+func (t Table) Update(value interface{}, returning, count string) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Update(value, returning, count))
+}
+
+func (t Table) Delete(returning, count string) *postgrest.FilterBuilder {
+	return t.scope(t.QueryBuilder.Delete(returning, count))
+}
@@ -0,0 +1,354 @@
+// Package filter parses the filter expressions accepted by the list
+// endpoints and translates them into SQL or PostgREST conditions.
+//
+// An expression compares fields with values and combines the comparisons
+// with and, or, not and parentheses:
+//
+//	total>1000 and currency=IDR and due_date<2026-11-01 and payment_status in (unpaid,partially_paid)
+//	(status=draft or status=sent) and not notes~"do not send"
+//
+// Operators are = != > >= < <= and ~ (contains, case-insensitive), plus
+// "in (...)" and "not in (...)". Values are bare words or quoted strings.
+// Keywords are case-insensitive. Dates are written YYYY-MM-DD; compared with
+// a timestamp field a date stands for the whole day.
+package filter
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Type is the type of a filterable field. It decides which values and
+// operators the field accepts.
+type Type int
+
+const (
+	// String fields accept every operator, including ~
+	String Type = iota
+	// ID fields hold UUIDs and only accept =, != and in
+	ID
+	// Number fields accept decimal numbers
+	Number
+	// Date fields hold calendar dates
+	Date
+	// Timestamp fields hold points in time; a date value covers its day
+	Timestamp
+	// Enum fields accept one of a fixed set of values
+	Enum
+)
+
+// Field describes a filterable field
+type Field struct {
+	Column string
+	Type   Type
+	// Values lists the values of an Enum field
+	Values []string
+}
+
+// Schema maps the field names an endpoint accepts to their columns
+type Schema map[string]Field
+
+func (s Schema) names() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Error is a filter that cannot be parsed or does not fit the schema. Pos is
+// the byte offset of the offending token and Token its text.
+type Error struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("invalid filter at position %d: %s", e.Pos+1, e.Msg)
+	}
+	return fmt.Sprintf("invalid filter at position %d (%q): %s", e.Pos+1, e.Token, e.Msg)
+}
+
+func errorAt(t token, format string, args ...interface{}) *Error {
+	return &Error{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Expr is a parsed filter expression, validated against a schema
+type Expr struct {
+	node node
+}
+
+// node is one of *logical, *negation or *condition
+type node interface{}
+
+// logical joins its terms with "and" or "or"
+type logical struct {
+	op    string
+	terms []node
+}
+
+type negation struct {
+	term node
+}
+
+// condition compares a field with one value, or checks it against a list of
+// values for in/not in
+type condition struct {
+	field  Field
+	op     string // =, !=, >, >=, <, <=, ~, in, not in
+	values []string
+	// day is set for a date compared with a Timestamp field
+	day bool
+}
+
+// Parse parses a filter expression and checks its fields, operators and
+// values against schema. A blank expression yields a nil Expr, which
+// matches everything. Errors are *Error.
+func Parse(src string, schema Schema) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, schema: schema}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if t.kind == tokRParen {
+			return nil, errorAt(t, "unbalanced closing parenthesis")
+		}
+		return nil, errorAt(t, "expected \"and\" or \"or\" before %s", t.display())
+	}
+	return &Expr{node: n}, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	schema Schema
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) or() (node, error) {
+	return p.logical("or", p.and)
+}
+
+func (p *parser) and() (node, error) {
+	return p.logical("and", p.unary)
+}
+
+func (p *parser) logical(op string, operand func() (node, error)) (node, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	terms := []node{first}
+	for p.peek().keyword(op) {
+		p.next()
+		n, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return &logical{op: op, terms: terms}, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.peek().keyword("not") {
+		p.next()
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{term: n}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.peek()
+	if t.kind == tokLParen {
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, errorAt(closing, "expected \")\" to close the parenthesis at position %d", t.pos+1)
+		}
+		return n, nil
+	}
+	return p.condition()
+}
+
+func (p *parser) condition() (node, error) {
+	t := p.next()
+	if t.kind != tokWord || t.keyword("and") || t.keyword("or") || t.keyword("in") {
+		return nil, errorAt(t, "expected a field name, found %s", t.display())
+	}
+	field, ok := p.schema[strings.ToLower(t.text)]
+	if !ok {
+		return nil, errorAt(t, "unknown field (use one of %s)", p.schema.names())
+	}
+
+	opTok := p.next()
+	switch {
+	case opTok.kind == tokOp:
+		op := opTok.text
+		if op == "<>" {
+			op = "!="
+		}
+		if err := checkOperator(field, op, opTok); err != nil {
+			return nil, err
+		}
+		v, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		return newCondition(field, op, v), nil
+
+	case opTok.keyword("in"):
+		return p.list(field, "in", opTok)
+
+	case opTok.keyword("not") && p.peek().keyword("in"):
+		p.next()
+		return p.list(field, "not in", opTok)
+	}
+	return nil, errorAt(opTok, "expected an operator (=, !=, >, >=, <, <=, ~, in, not in) after %q, found %s", t.text, opTok.display())
+}
+
+func (p *parser) list(field Field, op string, opTok token) (node, error) {
+	if err := checkOperator(field, op, opTok); err != nil {
+		return nil, err
+	}
+	if t := p.next(); t.kind != tokLParen {
+		return nil, errorAt(t, "expected \"(\" to start the %s list, found %s", op, t.display())
+	}
+	var values []string
+	for {
+		v, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v.text)
+
+		t := p.next()
+		if t.kind == tokRParen {
+			break
+		}
+		if t.kind != tokComma {
+			return nil, errorAt(t, "expected \",\" or \")\" in the %s list, found %s", op, t.display())
+		}
+	}
+	return &condition{field: field, op: op, values: values}, nil
+}
+
+// parsedValue is a validated value in its canonical text form
+type parsedValue struct {
+	text string
+	day  bool
+}
+
+func (p *parser) value(field Field) (parsedValue, error) {
+	t := p.next()
+	if t.kind != tokWord && t.kind != tokString {
+		return parsedValue{}, errorAt(t, "expected a value, found %s", t.display())
+	}
+
+	switch field.Type {
+	case ID:
+		if _, err := uuid.Parse(t.text); err != nil {
+			return parsedValue{}, errorAt(t, "not a valid id")
+		}
+	case Number:
+		r, ok := new(big.Rat).SetString(t.text)
+		if !ok {
+			return parsedValue{}, errorAt(t, "not a number")
+		}
+		return parsedValue{text: r.FloatString(decimals(t.text))}, nil
+	case Date:
+		if _, err := time.Parse("2006-01-02", t.text); err != nil {
+			return parsedValue{}, errorAt(t, "not a date (use YYYY-MM-DD)")
+		}
+	case Timestamp:
+		if _, err := time.Parse("2006-01-02", t.text); err == nil {
+			return parsedValue{text: t.text, day: true}, nil
+		}
+		if _, err := time.Parse(time.RFC3339, t.text); err != nil {
+			return parsedValue{}, errorAt(t, "not a date or time (use YYYY-MM-DD or RFC 3339)")
+		}
+	case Enum:
+		for _, v := range field.Values {
+			if strings.EqualFold(v, t.text) {
+				return parsedValue{text: v}, nil
+			}
+		}
+		return parsedValue{}, errorAt(t, "not a valid value (use one of %s)", strings.Join(field.Values, ", "))
+	}
+	return parsedValue{text: t.text}, nil
+}
+
+// decimals counts the digits after the decimal point of a number literal,
+// so values keep their precision when canonicalized
+func decimals(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		n := 0
+		for _, c := range s[i+1:] {
+			if c < '0' || c > '9' {
+				break
+			}
+			n++
+		}
+		return n
+	}
+	return 0
+}
+
+func checkOperator(field Field, op string, t token) error {
+	switch {
+	case op == "~" && field.Type != String:
+		return errorAt(t, "~ only applies to text fields")
+	case field.Type == ID && op != "=" && op != "!=" && op != "in" && op != "not in":
+		return errorAt(t, "id fields only support =, != and in")
+	case field.Type == Timestamp && (op == "in" || op == "not in"):
+		return errorAt(t, "%s does not apply to date and time fields", op)
+	}
+	return nil
+}
+
+func newCondition(field Field, op string, v parsedValue) *condition {
+	return &condition{field: field, op: op, values: []string{v.text}, day: v.day}
+}
+
+// nextDay returns the date after a YYYY-MM-DD date
+func nextDay(date string) string {
+	d, _ := time.Parse("2006-01-02", date)
+	return d.AddDate(0, 0, 1).Format("2006-01-02")
+}
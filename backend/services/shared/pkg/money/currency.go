@@ -0,0 +1,62 @@
+package money
+
+import "strings"
+
+// decimals lists currencies whose minor unit is not the cent. Amounts in
+// these currencies are rounded to the given number of decimal places;
+// every other currency uses two.
+var decimals = map[string]int{
+	// Rupiah, yen, won, dong and Chilean peso are not divided in practice
+	"IDR": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"CLP": 0,
+	// Dinars and rials with a 1/1000 minor unit
+	"BHD": 3,
+	"JOD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+// Decimals returns the number of decimal places amounts in currency are
+// rounded to
+func Decimals(currency string) int {
+	if d, ok := decimals[strings.ToUpper(currency)]; ok {
+		return d
+	}
+	return 2
+}
+
+// Money is an amount together with the currency it is denominated in
+type Money struct {
+	Amount   Amount `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New returns amount in currency, rounded to the currency's precision
+func New(amount Amount, currency string) Money {
+	return Money{Amount: amount.Round(currency), Currency: currency}
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) Money {
+	return New(m.Amount.Add(o.Amount), m.Currency)
+}
+
+// Sub returns m - o. Both must be in the same currency.
+func (m Money) Sub(o Money) Money {
+	return New(m.Amount.Sub(o.Amount), m.Currency)
+}
+
+// Convert returns m in currency to at the given exchange rate, rounded to
+// the target currency's precision
+func (m Money) Convert(to string, rate float64) Money {
+	return New(m.Amount.MulFloat(rate), to)
+}
+
+// String formats m with the currency's decimal places, e.g. "1234.50 USD"
+func (m Money) String() string {
+	return m.Amount.StringFixed(Decimals(m.Currency)) + " " + m.Currency
+}
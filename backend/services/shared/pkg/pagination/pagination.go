@@ -0,0 +1,237 @@
+// Package pagination implements cursor (keyset) pagination and sorting for
+// the list endpoints of the services.
+//
+// A page is read in the requested sort order with the row id as tie-breaker.
+// The cursor handed out with a page is opaque to clients; it holds the sort
+// value and id of the row the page ended on, and the next page starts right
+// after that position, so pages stay stable while rows are inserted or
+// deleted.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"invoice-backend/services/shared/pkg/database"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Sort orders
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Page sizes used when a request does not ask for one, and the largest page
+// a client may ask for
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// ErrInvalid is returned for a page request with an unknown sort field or
+// order, an out-of-range limit, or a cursor that cannot be used
+var ErrInvalid = errors.New("invalid page request")
+
+// Sort lists the columns a list can be sorted by. Columns must never be NULL.
+type Sort struct {
+	Fields  []string
+	Default string
+}
+
+// newestFirst lists the date sort fields, which default to descending order
+// so that a truncated list drops the oldest rows rather than the newest
+var newestFirst = map[string]bool{"created_at": true, "payment_date": true}
+
+func (s Sort) allows(field string) bool {
+	for _, f := range s.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Request selects one page of a list
+type Request struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Order  string
+
+	after *cursor
+}
+
+// Meta describes a page in API responses
+type Meta struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort"`
+	Order      string `json:"order"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type cursor struct {
+	Sort  string          `json:"s"`
+	Order string          `json:"o"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+// Parse reads the limit, cursor, sort and order query parameters of r and
+// validates them against sort
+func Parse(r *http.Request, sort Sort) (Request, error) {
+	q := r.URL.Query()
+	req := Request{
+		Limit:  DefaultLimit,
+		Cursor: q.Get("cursor"),
+		Sort:   q.Get("sort"),
+		Order:  strings.ToLower(q.Get("order")),
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > MaxLimit {
+			return req, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalid, MaxLimit)
+		}
+		req.Limit = n
+	}
+
+	if req.Sort == "" {
+		req.Sort = sort.Default
+	}
+	if !sort.allows(req.Sort) {
+		return req, fmt.Errorf("%w: cannot sort by %q (use one of %s)", ErrInvalid, req.Sort, strings.Join(sort.Fields, ", "))
+	}
+
+	if req.Order == "" {
+		req.Order = OrderAsc
+		if newestFirst[req.Sort] {
+			req.Order = OrderDesc
+		}
+	}
+	if req.Order != OrderAsc && req.Order != OrderDesc {
+		return req, fmt.Errorf("%w: order must be %q or %q", ErrInvalid, OrderAsc, OrderDesc)
+	}
+
+	if req.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+		var c cursor
+		if err == nil {
+			err = json.Unmarshal(raw, &c)
+		}
+		if err == nil {
+			_, err = uuid.Parse(c.ID)
+		}
+		if err == nil && len(c.Value) == 0 {
+			err = errors.New("no sort value")
+		}
+		if err != nil {
+			return req, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+		}
+		if c.Sort != req.Sort || c.Order != req.Order {
+			return req, fmt.Errorf("%w: cursor was issued for sort=%s&order=%s", ErrInvalid, c.Sort, c.Order)
+		}
+		req.after = &c
+	}
+	return req, nil
+}
+
+// Fetch reads one page of table. columns is the PostgREST select list and
+// filter, which may be nil, applies the list's filters; it is used for both
+// the page and the total count. id returns the id of a row.
+func Fetch[T any](db *database.Client, table, columns string, req Request, filter func(*postgrest.FilterBuilder) *postgrest.FilterBuilder, id func(T) string) ([]T, *Meta, error) {
+	if filter == nil {
+		filter = func(query *postgrest.FilterBuilder) *postgrest.FilterBuilder { return query }
+	}
+
+	_, total, err := filter(db.From(table).Select("id", "exact", true)).Execute()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := filter(db.From(table).Select(columns, "", false))
+	if req.after != nil {
+		op := "gt"
+		if req.Order == OrderDesc {
+			op = "lt"
+		}
+		v := quote(rawText(req.after.Value))
+		query = query.Or(fmt.Sprintf("%s.%s.%s,and(%s.eq.%s,id.%s.%s)", req.Sort, op, v, req.Sort, v, op, req.after.ID), "")
+	}
+
+	ascending := req.Order == OrderAsc
+	body, _, err := query.
+		Order(req.Sort, &postgrest.OrderOpts{Ascending: ascending}).
+		Order("id", &postgrest.OrderOpts{Ascending: ascending}).
+		Limit(req.Limit+1, "").
+		Execute()
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []T
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, nil, err
+	}
+
+	meta := &Meta{Limit: req.Limit, Sort: req.Sort, Order: req.Order, Total: int(total)}
+	if len(rows) > req.Limit {
+		// The sort value of the last row, as the database returned it
+		var values []map[string]json.RawMessage
+		if err := json.Unmarshal(body, &values); err != nil {
+			return nil, nil, err
+		}
+		rows = rows[:req.Limit]
+		last := req.Limit - 1
+		raw, _ := json.Marshal(cursor{Sort: req.Sort, Order: req.Order, Value: values[last][req.Sort], ID: id(rows[last])})
+		meta.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+	return rows, meta, nil
+}
+
+// SetHeaders describes a page in response headers: X-Total-Count, and
+// X-Next-Cursor plus a Link rel="next" URL when another page follows
+func SetHeaders(w http.ResponseWriter, r *http.Request, meta *Meta) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(meta.Total))
+	if meta.NextCursor == "" {
+		return
+	}
+
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", meta.NextCursor)
+	q.Set("limit", strconv.Itoa(meta.Limit))
+	q.Set("sort", meta.Sort)
+	q.Set("order", meta.Order)
+	next.RawQuery = q.Encode()
+
+	w.Header().Set("X-Next-Cursor", meta.NextCursor)
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
+
+// rawText returns a JSON scalar as text: strings unquoted, numbers as written
+func rawText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// quote double-quotes a value for use inside a PostgREST logic tree, where
+// commas, dots and parentheses are otherwise reserved
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
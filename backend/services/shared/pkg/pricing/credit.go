@@ -0,0 +1,159 @@
+package pricing
+
+import (
+	"fmt"
+
+	"invoice-backend/services/shared/pkg/money"
+	"invoice-backend/services/shared/pkg/types"
+)
+
+// CreditLine asks for part of an invoice line to be credited: a quantity of
+// it, or an amount off its total such as a price correction
+type CreditLine struct {
+	InvoiceItemID string       `json:"invoice_item_id"`
+	Quantity      money.Amount `json:"quantity,omitempty"`
+	Amount        money.Amount `json:"amount,omitempty"` // Before tax
+}
+
+// Credit prices a credit note for inv, which notes already credited. Without
+// lines it credits everything still owed on the invoice.
+//
+// A line credits a quantity of an invoice line at the line's price after its
+// discount, or an amount of the line's total, and never more than what is
+// left of the line after earlier credit notes. Crediting all that is left of
+// a line credits the rest of its total, so rounding leaves nothing behind.
+// Tax is credited per rate like Price charges it. The note's total is capped
+// at the invoice's balance, which is how the invoice discount and payments
+// are accounted for; the part of the lines and tax beyond it is the note's
+// discount.
+func Credit(inv *types.Invoice, notes []types.CreditNote, lines []CreditLine) (*types.CreditNote, error) {
+	type left struct {
+		quantity money.Amount
+		amount   money.Amount
+	}
+	remaining := make(map[string]*left, len(inv.Items))
+	for _, item := range inv.Items {
+		remaining[item.ID] = &left{quantity: item.Quantity, amount: item.Total}
+	}
+	for _, note := range notes {
+		for _, credited := range note.Items {
+			if r := remaining[credited.InvoiceItemID]; r != nil {
+				r.quantity = r.quantity.Sub(credited.Quantity)
+				r.amount = r.amount.Sub(credited.Total)
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		for _, item := range inv.Items {
+			if r := remaining[item.ID]; r.amount.Sign() > 0 {
+				lines = append(lines, CreditLine{InvoiceItemID: item.ID, Quantity: r.quantity, Amount: r.amount})
+			}
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("%w: every line of the invoice was already credited", ErrInvalid)
+		}
+	} else if err := checkCreditLines(lines); err != nil {
+		return nil, err
+	}
+
+	note := &types.CreditNote{InvoiceID: inv.ID, Currency: inv.Currency, Items: make([]types.CreditNoteItem, 0, len(lines))}
+	taxed := make([]types.Item, 0, len(lines))
+	for i, line := range lines {
+		item := findItem(inv.Items, line.InvoiceItemID)
+		if item == nil {
+			return nil, fmt.Errorf("%w: line %d: invoice_item_id %s is not a line of the invoice", ErrInvalid, i+1, line.InvoiceItemID)
+		}
+		r := remaining[item.ID]
+
+		credited := types.CreditNoteItem{
+			Position:      i + 1,
+			InvoiceItemID: item.ID,
+			SKU:           item.SKU,
+			Description:   item.Description,
+			Unit:          item.Unit,
+			UnitPrice:     item.UnitPrice,
+			TaxRate:       item.TaxRate,
+		}
+		switch {
+		case line.Quantity.Sign() > 0 && line.Amount.Sign() > 0:
+			// Everything left of the line
+			credited.Quantity, credited.Total = line.Quantity, line.Amount
+		case line.Quantity.Sign() > 0:
+			if line.Quantity.Cmp(r.quantity) > 0 {
+				return nil, fmt.Errorf("%w: line %d: only %s of %q is left to credit", ErrInvalid, i+1, r.quantity, item.Description)
+			}
+			if item.Unit == UnitPiece && line.Quantity%money.FromInt(1) != 0 {
+				return nil, fmt.Errorf("%w: line %d: quantity in pcs must be a whole number", ErrInvalid, i+1)
+			}
+			credited.Quantity = line.Quantity
+			share, err := item.Total.Prorate(line.Quantity, item.Quantity)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalid, i+1, err)
+			}
+			credited.Total = share.Round(inv.Currency)
+			if line.Quantity == r.quantity || credited.Total.Cmp(r.amount) > 0 {
+				credited.Total = r.amount
+			}
+		default:
+			credited.Total = line.Amount.Round(inv.Currency)
+			if credited.Total.Cmp(r.amount) > 0 {
+				return nil, fmt.Errorf("%w: line %d: only %s of %q is left to credit", ErrInvalid, i+1, r.amount, item.Description)
+			}
+		}
+		if credited.Total.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: line %d: nothing of %q is left to credit", ErrInvalid, i+1, item.Description)
+		}
+
+		note.Items = append(note.Items, credited)
+		note.Subtotal = note.Subtotal.Add(credited.Total)
+		taxed = append(taxed, types.Item{TaxRate: credited.TaxRate, Total: credited.Total})
+	}
+
+	taxes, err := Taxes(taxed, inv.Tax, inv.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tax %v", ErrInvalid, err)
+	}
+	for _, t := range taxes {
+		note.Tax = note.Tax.Add(t.Amount)
+	}
+	note.Total = note.Subtotal.Add(note.Tax)
+	if owed := inv.Total.Sub(inv.PaidAmount).Sub(inv.CreditedAmount); note.Total.Cmp(owed) > 0 {
+		if owed.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: nothing is owed on the invoice", ErrInvalid)
+		}
+		note.Discount = note.Total.Sub(owed)
+		note.Total = owed
+	}
+	return note, nil
+}
+
+// checkCreditLines checks that each line credits one invoice line by a
+// quantity or an amount, and that no invoice line is credited twice
+func checkCreditLines(lines []CreditLine) error {
+	seen := make(map[string]bool, len(lines))
+	for i, line := range lines {
+		switch {
+		case line.InvoiceItemID == "":
+			return fmt.Errorf("%w: line %d: invoice_item_id is required", ErrInvalid, i+1)
+		case seen[line.InvoiceItemID]:
+			return fmt.Errorf("%w: line %d: invoice line %s is credited twice", ErrInvalid, i+1, line.InvoiceItemID)
+		case line.Quantity.Sign() < 0 || line.Amount.Sign() < 0:
+			return fmt.Errorf("%w: line %d: quantity and amount cannot be negative", ErrInvalid, i+1)
+		case (line.Quantity.Sign() > 0) == (line.Amount.Sign() > 0):
+			return fmt.Errorf("%w: line %d: give either a quantity or an amount to credit", ErrInvalid, i+1)
+		}
+		seen[line.InvoiceItemID] = true
+	}
+	return nil
+}
+
+// findItem returns the line of items with id, or nil
+func findItem(items []types.Item, id string) *types.Item {
+	for i := range items {
+		if items[i].ID == id {
+			return &items[i]
+		}
+	}
+	return nil
+}
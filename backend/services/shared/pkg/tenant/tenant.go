@@ -0,0 +1,239 @@
+// Package tenant resolves the organization a request acts for.
+//
+// With AUTH_JWT_SECRET set, every request must carry a bearer token signed
+// with that secret (HS256, as Supabase Auth issues them) whose org_id claim,
+// at the top level or in app_metadata, names the organization. Without it,
+// every request acts for the default organization, unless TRUST_ORG_HEADER
+// is set for deployments behind a gateway that resolves the organization
+// itself: then the X-Org-ID header names it, falling back to the default.
+// A resolver given a lookup also refuses organizations that do not exist.
+//
+// Middleware writes the resolved organization back into the X-Org-ID
+// header, so handlers and the requests they forward see the verified value
+// and never the one the client sent. Likewise it replaces the X-Actor header
+// with the subject of the verified token, so the audit log records who
+// really made a change; without a token, or without AUTH_JWT_SECRET, the
+// request has no actor. Repositories scope their database
+// client with database.Client.WithOrg(tenant.Org(r)).
+package tenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"invoice-backend/services/shared/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// Header carries the organization of a request
+const Header = "X-Org-ID"
+
+// ActorHeader carries who made a request: the subject of its verified token
+const ActorHeader = "X-Actor"
+
+// Default is the organization of requests that do not name one, when
+// tokens are not required
+const Default = "00000000-0000-0000-0000-000000000001"
+
+var (
+	errMissingToken = errors.New("missing bearer token")
+	errInvalidToken = errors.New("invalid bearer token")
+	errExpiredToken = errors.New("bearer token has expired")
+	errNoOrg        = errors.New("token does not name an organization")
+	errInvalidOrg   = errors.New("organization ID must be a UUID")
+	errUntrusted    = errors.New("X-Org-ID is only trusted with TRUST_ORG_HEADER set; send a bearer token instead")
+	errUnknownOrg   = errors.New("organization not found")
+	errLookup       = errors.New("failed to look up organization")
+)
+
+// Resolver finds the organization of a request
+type Resolver struct {
+	secret      []byte
+	trustHeader bool
+	exists      func(org string) (bool, error)
+	known       sync.Map // Organizations exists found; they are never deleted
+}
+
+// FromEnv returns a resolver that verifies tokens signed with
+// AUTH_JWT_SECRET. When it is not set, the resolver trusts the X-Org-ID
+// header only with TRUST_ORG_HEADER=true.
+func FromEnv() *Resolver {
+	trust, _ := strconv.ParseBool(os.Getenv("TRUST_ORG_HEADER"))
+	return &Resolver{secret: []byte(os.Getenv("AUTH_JWT_SECRET")), trustHeader: trust}
+}
+
+// WithLookup makes the resolver refuse requests for an organization that
+// exists reports as unknown: with 404 when the X-Org-ID header names it, and
+// 403 when a token does
+func (res *Resolver) WithLookup(exists func(org string) (bool, error)) *Resolver {
+	res.exists = exists
+	return res
+}
+
+// Resolve returns the organization of a request and, when it carries a
+// verified token, the token's subject as its actor; or an error and the
+// HTTP status to answer it with
+func (res *Resolver) Resolve(r *http.Request) (org, actor string, status int, err error) {
+	if len(res.secret) == 0 {
+		org := strings.TrimSpace(r.Header.Get(Header))
+		if org == "" {
+			return Default, "", 0, nil
+		}
+		if _, err := uuid.Parse(org); err != nil {
+			return "", "", http.StatusBadRequest, errInvalidOrg
+		}
+		// Anyone can send the header, so without a gateway vouching for it
+		// only the default organization is reachable
+		if !res.trustHeader && org != Default {
+			return "", "", http.StatusForbidden, errUntrusted
+		}
+		if status, err := res.lookup(org, http.StatusNotFound); err != nil {
+			return "", "", status, err
+		}
+		return org, "", 0, nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", "", http.StatusUnauthorized, errMissingToken
+	}
+	claims, err := res.verify(token)
+	if err != nil {
+		return "", "", http.StatusUnauthorized, err
+	}
+	org = claims.OrgID
+	if org == "" {
+		org = claims.AppMetadata.OrgID
+	}
+	if org == "" {
+		return "", "", http.StatusForbidden, errNoOrg
+	}
+	if _, err := uuid.Parse(org); err != nil {
+		return "", "", http.StatusForbidden, errInvalidOrg
+	}
+	if status, err := res.lookup(org, http.StatusForbidden); err != nil {
+		return "", "", status, err
+	}
+	return org, claims.Sub, 0, nil
+}
+
+// lookup checks that org exists, when the resolver has a lookup, returning
+// the status unknown for an organization that does not
+func (res *Resolver) lookup(org string, unknown int) (int, error) {
+	if res.exists == nil {
+		return 0, nil
+	}
+	if _, ok := res.known.Load(org); ok {
+		return 0, nil
+	}
+	ok, err := res.exists(org)
+	if err != nil {
+		log.Printf("Error looking up organization %s: %v", org, err)
+		return http.StatusInternalServerError, errLookup
+	}
+	if !ok {
+		return unknown, errUnknownOrg
+	}
+	res.known.Store(org, true)
+	return 0, nil
+}
+
+// claims are the token claims the resolver reads
+type claims struct {
+	Exp         *int64 `json:"exp"`
+	Sub         string `json:"sub"`
+	OrgID       string `json:"org_id"`
+	AppMetadata struct {
+		OrgID string `json:"org_id"`
+	} `json:"app_metadata"`
+}
+
+// verify checks a token's HS256 signature and expiry and returns its claims
+func (res *Resolver) verify(token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, res.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, errInvalidToken
+	}
+	if c.Exp != nil && time.Now().Unix() >= *c.Exp {
+		return nil, errExpiredToken
+	}
+	return &c, nil
+}
+
+// decodeSegment decodes one base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Middleware resolves the organization of every request but the health
+// check and stores it in the X-Org-ID header, refusing requests whose
+// organization cannot be resolved. It drops any X-Actor header the client
+// sent and sets it to the subject of the verified token, if any.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(ActorHeader)
+		if r.Method == http.MethodOptions || r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		org, actor, status, err := res.Resolve(r)
+		if err != nil {
+			utils.Error(w, status, err.Error())
+			return
+		}
+		r.Header.Set(Header, org)
+		if actor != "" {
+			r.Header.Set(ActorHeader, actor)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Org returns the organization Middleware resolved for a request
+func Org(r *http.Request) string {
+	return r.Header.Get(Header)
+}
+
+// Actor returns the subject of the request's verified token, as Middleware
+// resolved it, or "" for a request without one
+func Actor(r *http.Request) string {
+	return r.Header.Get(ActorHeader)
+}
@@ -1,32 +1,262 @@
 package types
 
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Logo formats
+	_ "image/png"
+	"strings"
+
+	"invoice-backend/services/shared/pkg/money"
+)
+
 // Invoice represents an invoice record
 type Invoice struct {
-	ID              string  `json:"id"`
-	CustomerID      string  `json:"customer_id"`
-	InvoiceNumber   string  `json:"invoice_number"`
-	Date            string  `json:"date"`
-	DueDate         string  `json:"due_date"`
-	Status          string  `json:"status"`
-	PaymentStatus   string  `json:"payment_status"`
-	Total           float64 `json:"total"`
-	PaidAmount      float64 `json:"paid_amount"`
-	Currency        string  `json:"currency"`
-	Notes           string  `json:"notes,omitempty"`
-	Items           []Item  `json:"items,omitempty"`
-	CustomerName    string  `json:"customer_name,omitempty"`
-	CustomerEmail   string  `json:"customer_email,omitempty"`
-	PaymentDate     string  `json:"payment_date,omitempty"`
-	CreatedAt       string  `json:"created_at,omitempty"`
-	CreatedBy       string  `json:"created_by,omitempty"`
-}
-
-// Item represents an invoice line item
+	ID            string       `json:"id"`
+	CompanyID     string       `json:"company_id,omitempty"` // Company profile the invoice was issued from
+	CustomerID    string       `json:"customer_id"`
+	InvoiceNumber string       `json:"invoice_number"`
+	Date          string       `json:"date"`
+	DueDate       string       `json:"due_date"`
+	Status        string       `json:"status"`         // Lifecycle status (see package lifecycle)
+	PaymentStatus string       `json:"payment_status"` // unpaid, partially_paid, paid or overdue
+	Subtotal      money.Amount `json:"subtotal"`
+	Tax           float64      `json:"tax"` // Tax percentage
+	Discount      money.Amount `json:"discount"`
+	Total         money.Amount `json:"total"`
+	PaidAmount    money.Amount `json:"paid_amount"`
+	// CreditedAmount is the total of the invoice's credit notes, which lower
+	// its balance like payments do
+	CreditedAmount money.Amount `json:"credited_amount,omitempty"`
+	Currency       string       `json:"currency"`
+	Notes          string       `json:"notes,omitempty"`
+	Items          []Item       `json:"items,omitempty"`
+	CustomerName   string       `json:"customer_name,omitempty"`
+	CustomerEmail  string       `json:"customer_email,omitempty"`
+	PaymentDate    string       `json:"payment_date,omitempty"`
+	IssuedAt       string       `json:"issued_at,omitempty"`     // When the invoice left draft
+	SentAt         string       `json:"sent_at,omitempty"`       // When the invoice was last sent
+	StatusReason   string       `json:"status_reason,omitempty"` // Why the invoice was voided or written off
+	CreatedAt      string       `json:"created_at,omitempty"`
+	CreatedBy      string       `json:"created_by,omitempty"`
+	Version        int          `json:"version,omitempty"`
+}
+
+// Item represents an invoice line item, stored in the invoice_items table
 type Item struct {
-	Description string  `json:"description"`
-	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-	Total       float64 `json:"total"`
+	ID          string       `json:"id,omitempty"`
+	Position    int          `json:"position"`             // Line number, from 1
+	ProductID   string       `json:"product_id,omitempty"` // Catalog product the line was taken from
+	SKU         string       `json:"sku,omitempty"`
+	Description string       `json:"description"`
+	Quantity    money.Amount `json:"quantity"` // Decimal, up to four places
+	// Unit is the unit of measure: hour, day, kg, pcs or a custom unit
+	Unit      string       `json:"unit,omitempty"`
+	UnitPrice money.Amount `json:"unit_price"`
+	// PriceRule describes the price list rule that set the unit price
+	PriceRule string `json:"price_rule,omitempty"`
+	// TaxRate is the line's tax percentage; nil applies the invoice's tax
+	TaxRate  *float64     `json:"tax_rate,omitempty"`
+	Discount money.Amount `json:"discount,omitempty"` // Line discount amount
+	// Total is quantity x unit price less the discount, rounded to the
+	// invoice currency
+	Total money.Amount `json:"total"`
+}
+
+// CreditNote credits part or all of an invoice back to the customer. It is a
+// document of its own, numbered from the credit_note series, and lowers the
+// balance of the invoice it credits by its total. Credit notes are never
+// changed once issued.
+type CreditNote struct {
+	ID               string `json:"id"`
+	InvoiceID        string `json:"invoice_id"`
+	CreditNoteNumber string `json:"credit_note_number,omitempty"`
+	Reason           string `json:"reason"`
+	// Subtotal is the sum of the credited lines and Tax the tax on them, at
+	// the rates of the invoice lines
+	Subtotal money.Amount `json:"subtotal"`
+	Tax      money.Amount `json:"tax"`
+	// Discount is what the lines and their tax come to beyond the invoice's
+	// balance, which a credit note never exceeds
+	Discount  money.Amount     `json:"discount,omitempty"`
+	Total     money.Amount     `json:"total"`
+	Currency  string           `json:"currency"`
+	Items     []CreditNoteItem `json:"items"`
+	CreatedAt string           `json:"created_at,omitempty"`
+	CreatedBy string           `json:"created_by,omitempty"`
+}
+
+// CreditNoteItem is one credited line of an invoice, in the
+// credit_note_items table
+type CreditNoteItem struct {
+	ID            string `json:"id,omitempty"`
+	Position      int    `json:"position"` // Line number, from 1
+	InvoiceItemID string `json:"invoice_item_id"`
+	SKU           string `json:"sku,omitempty"`
+	Description   string `json:"description"`
+	// Quantity is how much of the invoice line is credited; zero for a line
+	// credited by amount, such as a price correction
+	Quantity  money.Amount `json:"quantity,omitempty"`
+	Unit      string       `json:"unit,omitempty"`
+	UnitPrice money.Amount `json:"unit_price"`
+	// TaxRate is the invoice line's tax percentage; nil for the invoice's
+	TaxRate *float64     `json:"tax_rate,omitempty"`
+	Total   money.Amount `json:"total"` // Amount credited, before tax
+}
+
+// Product is an entry of the product and service catalog. An invoice line
+// that references a product copies the product's details when the invoice
+// is created, so later catalog changes never alter an issued invoice.
+type Product struct {
+	ID          string `json:"id"`
+	SKU         string `json:"sku"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	// TaxRate is the default tax percentage of the product's lines; nil
+	// applies the invoice's tax
+	TaxRate *float64 `json:"tax_rate,omitempty"`
+	// Prices are the default unit prices, one per currency
+	Prices    []ProductPrice `json:"prices"`
+	Active    bool           `json:"active"` // Inactive products cannot be invoiced
+	CreatedAt string         `json:"created_at,omitempty"`
+	Version   int            `json:"version,omitempty"`
+}
+
+// ProductPrice is the default unit price of a product in one currency
+type ProductPrice struct {
+	Currency  string       `json:"currency"`
+	UnitPrice money.Amount `json:"unit_price"`
+}
+
+// Price returns the product's unit price in currency
+func (p Product) Price(currency string) (money.Amount, bool) {
+	for _, price := range p.Prices {
+		if strings.EqualFold(price.Currency, currency) {
+			return price.UnitPrice, true
+		}
+	}
+	return 0, false
+}
+
+// PriceList holds the prices negotiated with customers for catalog
+// products. Its rules apply to the invoices of the customers it is assigned
+// to, in the price list's currency only.
+type PriceList struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Currency    string      `json:"currency"`
+	Rules       []PriceRule `json:"rules"`
+	CreatedAt   string      `json:"created_at,omitempty"`
+	Version     int         `json:"version"`
+}
+
+// PriceRule prices a product on a price list from a minimum quantity up,
+// at either a fixed unit price or a percentage off the catalog price. Rules
+// for the same product at higher minimum quantities are volume breaks.
+type PriceRule struct {
+	ProductID   string       `json:"product_id"`
+	MinQuantity money.Amount `json:"min_quantity"` // Zero applies to any quantity
+	// UnitPrice is the fixed unit price, in the price list's currency
+	UnitPrice *money.Amount `json:"unit_price,omitempty"`
+	// DiscountPercent is the percentage off the product's catalog price
+	DiscountPercent *float64 `json:"discount_percent,omitempty"`
+}
+
+// Rule returns the rule of the price list that prices quantity of a
+// product: the one with the highest minimum quantity the quantity reaches,
+// or nil if there is none
+func (l PriceList) Rule(productID string, quantity money.Amount) *PriceRule {
+	var best *PriceRule
+	for i := range l.Rules {
+		rule := &l.Rules[i]
+		if rule.ProductID != productID || rule.MinQuantity.Cmp(quantity) > 0 {
+			continue
+		}
+		if best == nil || rule.MinQuantity.Cmp(best.MinQuantity) > 0 {
+			best = rule
+		}
+	}
+	return best
+}
+
+// MaxLogoSize is the largest logo image a company profile accepts, in bytes
+const MaxLogoSize = 256 << 10
+
+// CompanyProfile is a company invoices are issued from. Its details, bank
+// account and logo are printed on the invoices it issues and sign the emails
+// about them, and its invoices are numbered from its own series. One profile
+// is the default, which issues the invoices that do not name a company.
+type CompanyProfile struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"` // Trading name, in the invoice header
+	LegalName  string `json:"legal_name,omitempty"`
+	TaxID      string `json:"tax_id,omitempty"`
+	Address    string `json:"address,omitempty"`
+	City       string `json:"city,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Phone      string `json:"phone,omitempty"`
+	Website    string `json:"website,omitempty"`
+	// Bank account invoices are paid into
+	BankName          string `json:"bank_name,omitempty"`
+	BankAccountName   string `json:"bank_account_name,omitempty"`
+	BankAccountNumber string `json:"bank_account_number,omitempty"`
+	BankSwift         string `json:"bank_swift,omitempty"`
+	// Logo is a PNG or JPEG image as a data URL (data:image/png;base64,...)
+	Logo string `json:"logo,omitempty"`
+	// InvoiceSeries is the numbering series of the company's invoices
+	InvoiceSeries string `json:"invoice_series"`
+	IsDefault     bool   `json:"is_default"`
+	CreatedAt     string `json:"created_at,omitempty"`
+	Version       int    `json:"version"`
+}
+
+// Validate checks that the profile can be printed on an invoice
+func (p CompanyProfile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, _, err := p.LogoImage(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LogoImage decodes the profile's logo, returning the image and its type as
+// gofpdf names it ("PNG" or "JPG"), or no image if the profile has no logo
+func (p CompanyProfile) LogoImage() ([]byte, string, error) {
+	if p.Logo == "" {
+		return nil, "", nil
+	}
+	header, data, ok := strings.Cut(p.Logo, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, "", fmt.Errorf("logo must be a base64 data URL")
+	}
+	var imageType string
+	switch strings.TrimSuffix(header, ";base64") {
+	case "data:image/png":
+		imageType = "PNG"
+	case "data:image/jpeg", "data:image/jpg":
+		imageType = "JPG"
+	default:
+		return nil, "", fmt.Errorf("logo must be a PNG or JPEG image")
+	}
+	img, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("logo is not valid base64")
+	}
+	if len(img) > MaxLogoSize {
+		return nil, "", fmt.Errorf("logo must be at most %d KB", MaxLogoSize>>10)
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(img)); err != nil {
+		return nil, "", fmt.Errorf("logo is not a valid image: %v", err)
+	}
+	return img, imageType, nil
 }
 
 // Customer represents a customer record
@@ -37,55 +267,200 @@ type Customer struct {
 	Phone     string `json:"phone,omitempty"`
 	Address   string `json:"address,omitempty"`
 	CreatedAt string `json:"created_at,omitempty"`
+	// ArchivedAt is set while the customer is archived
+	ArchivedAt string `json:"archived_at,omitempty"`
+	// PriceListID is the price list applied to the customer's invoices
+	PriceListID string `json:"price_list_id,omitempty"`
+	Version     int    `json:"version,omitempty"`
+	// CreditBalances is the customer's credit, per currency, where the
+	// customer is read with it
+	CreditBalances []CreditBalance `json:"credit_balances,omitempty"`
+}
+
+// PaymentMethodCredit is reserved: credit is applied with credit
+// applications, so payments may not use it
+const PaymentMethodCredit = "credit"
+
+// CreditBalance is what a customer has in credit in one currency: what was
+// paid beyond the balance of their invoices and received from them without
+// an invoice, less what was applied of it to invoices
+type CreditBalance struct {
+	Currency string       `json:"currency"`
+	Balance  money.Amount `json:"balance"`
+}
+
+// CreditApplication applies part of a customer's credit to one of their
+// invoices. It settles the invoice as a payment does but is not one: it has
+// no receipt number and is not listed among payments. A wrong one is voided,
+// which gives the credit back. An allocation of a receipt is a credit
+// application with the receipt's ID in PaymentID.
+type CreditApplication struct {
+	ID         string       `json:"id"`
+	InvoiceID  string       `json:"invoice_id"`
+	PaymentID  string       `json:"payment_id,omitempty"`
+	CustomerID string       `json:"customer_id"`
+	Currency   string       `json:"currency"`
+	Amount     money.Amount `json:"amount"`
+	AppliedAt  string       `json:"applied_at"`
+	CreatedAt  string       `json:"created_at,omitempty"`
+	CreatedBy  string       `json:"created_by,omitempty"`
+	VoidedAt   string       `json:"voided_at,omitempty"`
+	VoidReason string       `json:"void_reason,omitempty"`
+	Version    int          `json:"version,omitempty"`
+}
+
+// CustomerCreate is the struct for creating a new customer
+type CustomerCreate struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone,omitempty"`
+	Address string `json:"address,omitempty"`
 }
 
 // Payment represents a payment record
 type Payment struct {
-	ID              string  `json:"id"`
-	InvoiceID       string  `json:"invoice_id"`
-	Amount          float64 `json:"amount"`
-	PaymentMethod   string  `json:"payment_method"`
-	PaymentDate     string  `json:"payment_date"`
-	ReferenceNumber string  `json:"reference_number,omitempty"`
-	Notes           string  `json:"notes,omitempty"`
-	CreatedAt       string  `json:"created_at,omitempty"`
-	CreatedBy       string  `json:"created_by,omitempty"`
-}
-
-// PaymentCreate is the struct for creating a new payment
+	ID        string `json:"id"`
+	InvoiceID string `json:"invoice_id"` // Empty for a receipt kept as credit
+	// CustomerID is who paid, and Currency what in: the invoice's, or the
+	// receipt's
+	CustomerID      string       `json:"customer_id,omitempty"`
+	Currency        string       `json:"currency,omitempty"`
+	ReceiptNumber   string       `json:"receipt_number,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	CreatedAt       string       `json:"created_at,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+	// RefundedAmount is what was returned of the payment; Refunds lists the
+	// refunds where a payment is read with them
+	RefundedAmount money.Amount `json:"refunded_amount,omitempty"`
+	Refunds        []Refund     `json:"refunds,omitempty"`
+	// AllocatedAmount is what was allocated of a receipt to invoices, not
+	// void; Allocations lists the allocations, credit applications of the
+	// receipt, where a payment is read with them
+	AllocatedAmount money.Amount        `json:"allocated_amount,omitempty"`
+	Allocations     []CreditApplication `json:"allocations,omitempty"`
+	// VoidedAt is set once the payment is voided, with why in VoidReason;
+	// a void payment no longer counts towards its invoice. CorrectionReason
+	// tells why it was last corrected.
+	VoidedAt         string `json:"voided_at,omitempty"`
+	VoidReason       string `json:"void_reason,omitempty"`
+	CorrectionReason string `json:"correction_reason,omitempty"`
+	Version          int    `json:"version,omitempty"`
+}
+
+// PaymentCreate is the struct for creating a new payment. A payment on an
+// invoice is the invoice's customer's, in its currency; without InvoiceID it
+// is a receipt of CustomerID in Currency (USD if empty), all of which goes
+// to the customer's credit unless it is allocated to their invoices, by
+// Allocations or, with AutoAllocate, oldest due first.
 type PaymentCreate struct {
-	InvoiceID       string  `json:"invoice_id"`
-	Amount          float64 `json:"amount"`
-	PaymentMethod   string  `json:"payment_method"`
-	PaymentDate     string  `json:"payment_date"`
-	ReferenceNumber string  `json:"reference_number,omitempty"`
-	Notes           string  `json:"notes,omitempty"`
-	CreatedBy       string  `json:"created_by,omitempty"`
+	InvoiceID       string       `json:"invoice_id,omitempty"`
+	CustomerID      string       `json:"customer_id,omitempty"`
+	Currency        string       `json:"currency,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+
+	Allocations  []PaymentAllocation `json:"allocations,omitempty"`
+	AutoAllocate bool                `json:"auto_allocate,omitempty"`
+}
+
+// PaymentAllocation is part of a payment allocated to one invoice
+type PaymentAllocation struct {
+	InvoiceID string       `json:"invoice_id"`
+	Amount    money.Amount `json:"amount"`
+}
+
+// PaymentCorrection replaces the details of a payment that was recorded
+// wrong. An empty PaymentDate keeps the payment's date.
+type PaymentCorrection struct {
+	Amount          money.Amount `json:"amount"`
+	PaymentMethod   string       `json:"payment_method"`
+	PaymentDate     string       `json:"payment_date,omitempty"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	Reason          string       `json:"reason"` // Why the payment is corrected
+}
+
+// Refund returns part or all of a payment to the customer. It lowers what was
+// paid on the payment's invoice, so a paid invoice may be owed again, or the
+// customer's credit for a receipt.
+type Refund struct {
+	ID              string       `json:"id"`
+	PaymentID       string       `json:"payment_id"`
+	InvoiceID       string       `json:"invoice_id,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	RefundMethod    string       `json:"refund_method"`
+	RefundDate      string       `json:"refund_date"`
+	ReferenceNumber string       `json:"reference_number,omitempty"`
+	Reason          string       `json:"reason,omitempty"`
+	CreatedAt       string       `json:"created_at,omitempty"`
+	CreatedBy       string       `json:"created_by,omitempty"`
+}
+
+// RefundCreate is the struct for refunding a payment
+type RefundCreate struct {
+	Amount money.Amount `json:"amount"`
+	// RefundMethod is how the money was returned; empty returns it the way
+	// it was paid
+	RefundMethod    string `json:"refund_method,omitempty"`
+	RefundDate      string `json:"refund_date,omitempty"`
+	ReferenceNumber string `json:"reference_number,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	CreatedBy       string `json:"created_by,omitempty"`
 }
 
 // DashboardStats represents dashboard analytics data
 type DashboardStats struct {
-	TotalRevenue    float64 `json:"total_revenue"`
-	PaidAmount      float64 `json:"paid_amount"`
-	UnpaidAmount    float64 `json:"unpaid_amount"`
-	OverdueAmount   float64 `json:"overdue_amount"`
-	TotalInvoices   int     `json:"total_invoices"`
-	PaidInvoices    int     `json:"paid_invoices"`
-	UnpaidInvoices  int     `json:"unpaid_invoices"`
-	OverdueInvoices int     `json:"overdue_invoices"`
+	TotalRevenue    money.Amount `json:"total_revenue"`
+	PaidAmount      money.Amount `json:"paid_amount"`
+	CreditedAmount  money.Amount `json:"credited_amount"` // Credited on invoices, already left out of revenue
+	UnpaidAmount    money.Amount `json:"unpaid_amount"`
+	OverdueAmount   money.Amount `json:"overdue_amount"`
+	TotalInvoices   int          `json:"total_invoices"`
+	PaidInvoices    int          `json:"paid_invoices"`
+	UnpaidInvoices  int          `json:"unpaid_invoices"`
+	OverdueInvoices int          `json:"overdue_invoices"`
 }
 
 // RevenueData represents revenue data for charts
 type RevenueData struct {
-	Period string  `json:"period"`
-	Amount float64 `json:"amount"`
+	Period string       `json:"period"`
+	Amount money.Amount `json:"amount"`
 }
 
 // TopCustomer represents top customer by revenue
 type TopCustomer struct {
-	CustomerID    string  `json:"customer_id"`
-	CustomerName  string  `json:"customer_name"`
-	CustomerEmail string  `json:"customer_email"`
-	TotalRevenue  float64 `json:"total_revenue"`
-	InvoiceCount  int     `json:"invoice_count"`
+	CustomerID    string       `json:"customer_id"`
+	CustomerName  string       `json:"customer_name"`
+	CustomerEmail string       `json:"customer_email"`
+	TotalRevenue  money.Amount `json:"total_revenue"`
+	InvoiceCount  int          `json:"invoice_count"`
+}
+
+// TopItem represents an item with what it brought in. Items are grouped by
+// SKU, or by description when they have none, and by unit of measure.
+type TopItem struct {
+	SKU          string       `json:"sku,omitempty"`
+	Description  string       `json:"description"`
+	Quantity     money.Amount `json:"quantity"`
+	Unit         string       `json:"unit,omitempty"` // Quantities are summed per unit
+	Revenue      money.Amount `json:"revenue"`
+	InvoiceCount int          `json:"invoice_count"`
+	Currency     string       `json:"currency"`
+}
+
+// CurrencyRate represents exchange rate data
+type CurrencyRate struct {
+	ID           string  `json:"id"`
+	FromCurrency string  `json:"from_currency"`
+	ToCurrency   string  `json:"to_currency"`
+	Rate         float64 `json:"rate"`
+	UpdatedAt    string  `json:"updated_at"`
 }
@@ -3,6 +3,8 @@ package utils
 import (
 	"encoding/json"
 	"net/http"
+
+	"invoice-backend/services/shared/pkg/pagination"
 )
 
 // Response represents a standard API response
@@ -11,6 +13,8 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
 	Message string      `json:"message,omitempty"`
+
+	Pagination *pagination.Meta `json:"pagination,omitempty"`
 }
 
 // JSON writes a JSON response
@@ -28,6 +32,17 @@ func Success(w http.ResponseWriter, data interface{}) {
 	})
 }
 
+// Paginated writes a success JSON response holding one page of a list. The
+// page is described in the pagination field and in response headers.
+func Paginated(w http.ResponseWriter, r *http.Request, data interface{}, meta *pagination.Meta) {
+	pagination.SetHeaders(w, r, meta)
+	JSON(w, http.StatusOK, Response{
+		Success:    true,
+		Data:       data,
+		Pagination: meta,
+	})
+}
+
 // Created writes a created JSON response
 func Created(w http.ResponseWriter, data interface{}) {
 	JSON(w, http.StatusCreated, Response{
@@ -58,3 +73,8 @@ func InternalError(w http.ResponseWriter, message string) {
 func NotFound(w http.ResponseWriter, message string) {
 	Error(w, http.StatusNotFound, message)
 }
+
+// PreconditionFailed writes a 412 error response
+func PreconditionFailed(w http.ResponseWriter, message string) {
+	Error(w, http.StatusPreconditionFailed, message)
+}
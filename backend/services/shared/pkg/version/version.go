@@ -0,0 +1,90 @@
+// Package version implements optimistic concurrency for the services.
+//
+// Customers and invoices carry a version that the database raises with every
+// change (see migration 0008_row_versions). Reads hand it out as the ETag of
+// the record; a write sent with If-Match only applies to the version the
+// client read, and is refused with 412 Precondition Failed when the record
+// has changed since.
+package version
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"invoice-backend/services/shared/pkg/database"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// ErrConflict is returned for a write based on a version of a record that is
+// no longer current
+var ErrConflict = errors.New("record was changed since it was read")
+
+// ETag is the entity tag of a version
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag tags a response with the version of the record it carries
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+// IfMatch returns the version a write is conditional on, from the If-Match
+// header: 0 when there is no header or it is "*", meaning the write applies
+// to whatever version is current. ok is false for a header that names no
+// version, which can never match.
+func IfMatch(r *http.Request) (version int, ok bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, true
+	}
+	v = strings.TrimPrefix(v, "W/")
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v[1 : len(v)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Match restricts a write to rows at version; a version of 0 matches any
+func Match(query *postgrest.FilterBuilder, version int) *postgrest.FilterBuilder {
+	if version == 0 {
+		return query
+	}
+	return query.Eq("version", strconv.Itoa(version))
+}
+
+// Check compares the current version of row id of table with version. It
+// returns notFound when there is no such row and ErrConflict when the row is
+// at another version; a version of 0 only checks that the row exists.
+func Check(db *database.Client, table, id string, version int, notFound error) error {
+	var rows []struct {
+		Version int `json:"version"`
+	}
+	_, err := db.From(table).Select("version", "", false).Eq("id", id).ExecuteTo(&rows)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return notFound
+	}
+	if version != 0 && rows[0].Version != version {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Missed explains a write matched with Match that changed no row: notFound
+// when row id of table does not exist, ErrConflict when it does
+func Missed(db *database.Client, table, id string, notFound error) error {
+	if err := Check(db, table, id, 0, notFound); err != nil {
+		return err
+	}
+	return ErrConflict
+}
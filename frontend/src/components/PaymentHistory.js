@@ -12,14 +12,23 @@ const PaymentHistory = ({ invoice }) => {
   const fetchPayments = async () => {
     try {
       setLoading(true);
-      const response = await fetch(`http://localhost:8080/invoices/${invoice.id}/payments`);
+      const [response, creditResponse] = await Promise.all([
+        fetch(`http://localhost:8080/invoices/${invoice.id}/payments`),
+        fetch(`http://localhost:8080/invoices/${invoice.id}/credit-applications`)
+      ]);
       
-      if (!response.ok) {
+      if (!response.ok || !creditResponse.ok) {
         throw new Error('Failed to fetch payments');
       }
 
       const data = await response.json();
-      setPayments(data || []);
+      // Credit applied to the invoice is not a payment, but settles it like one
+      const credits = ((await creditResponse.json()) || []).map((application) => ({
+        ...application,
+        payment_method: 'credit',
+        payment_date: application.applied_at
+      }));
+      setPayments([...(data || []), ...credits].sort((a, b) => new Date(a.payment_date) - new Date(b.payment_date)));
     } catch (err) {
       console.error('Error fetching payments:', err);
       setError(err.message);
@@ -62,6 +71,7 @@ const PaymentHistory = ({ invoice }) => {
       'cash': '💵',
       'check': '📝',
       'paypal': '🅿️',
+      'credit': '🎟️',
       'other': '🔄'
     };
     return icons[method] || '💰';
@@ -74,6 +84,7 @@ const PaymentHistory = ({ invoice }) => {
       'cash': 'Cash',
       'check': 'Check',
       'paypal': 'PayPal',
+      'credit': 'Customer Credit',
       'other': 'Other'
     };
     return labels[method] || method;
@@ -126,7 +137,7 @@ const PaymentHistory = ({ invoice }) => {
             {payments.map((payment, index) => (
               <div
                 key={payment.id}
-                className="border border-gray-200 dark:border-gray-700 rounded-lg p-4 hover:bg-gray-50 dark:hover:bg-gray-700/50 transition-colors"
+                className={`border border-gray-200 dark:border-gray-700 rounded-lg p-4 hover:bg-gray-50 dark:hover:bg-gray-700/50 transition-colors ${payment.voided_at ? 'opacity-60' : ''}`}
               >
                 <div className="flex items-start justify-between">
                   {/* Left side - Payment info */}
@@ -159,17 +170,70 @@ const PaymentHistory = ({ invoice }) => {
                       </div>
                     )}
 
+                    {/* Part of a payment allocated across invoices */}
+                    {payment.payment_id && (
+                      <div className="flex items-center gap-2 mb-1">
+                        <span className="px-2 py-0.5 bg-indigo-100 dark:bg-indigo-900/50 text-indigo-700 dark:text-indigo-300 rounded text-xs font-semibold">
+                          ALLOCATED
+                        </span>
+                        <span className="text-xs text-gray-500 dark:text-gray-400" title={payment.payment_id}>
+                          Part of a payment split across invoices
+                        </span>
+                      </div>
+                    )}
+
+                    {/* Void / correction */}
+                    {payment.voided_at && (
+                      <div className="flex items-center gap-2 mb-1">
+                        <span className="px-2 py-0.5 bg-red-100 dark:bg-red-900/50 text-red-700 dark:text-red-300 rounded text-xs font-semibold">
+                          VOID
+                        </span>
+                        {payment.void_reason && (
+                          <span className="text-xs text-gray-500 dark:text-gray-400 italic">{payment.void_reason}</span>
+                        )}
+                      </div>
+                    )}
+                    {payment.correction_reason && (
+                      <div className="flex items-center gap-2 mb-1">
+                        <span className="text-xs text-gray-500 dark:text-gray-400">Corrected:</span>
+                        <span className="text-xs text-gray-500 dark:text-gray-400 italic">{payment.correction_reason}</span>
+                      </div>
+                    )}
+
                     {/* Notes */}
                     {payment.notes && (
                       <div className="mt-2 text-sm text-gray-600 dark:text-gray-400 italic">
                         "{payment.notes}"
                       </div>
                     )}
+
+                    {/* Refunds */}
+                    {payment.refunds && payment.refunds.length > 0 && (
+                      <div className="mt-3 space-y-1">
+                        {payment.refunds.map((refund) => (
+                          <div key={refund.id} className="flex items-center justify-between text-sm text-red-600 dark:text-red-400">
+                            <span>
+                              ↩ Refunded {new Date(refund.refund_date).toLocaleDateString('en-US', {
+                                year: 'numeric',
+                                month: 'short',
+                                day: 'numeric'
+                              })}
+                              {refund.reason && (
+                                <span className="italic text-gray-500 dark:text-gray-400"> - "{refund.reason}"</span>
+                              )}
+                            </span>
+                            <span className="font-semibold">
+                              -{getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(refund.amount, invoice.currency)}
+                            </span>
+                          </div>
+                        ))}
+                      </div>
+                    )}
                   </div>
 
                   {/* Right side - Amount */}
                   <div className="text-right ml-4">
-                    <div className="text-2xl font-bold text-green-600 dark:text-green-400">
+                    <div className={`text-2xl font-bold ${payment.voided_at ? 'text-gray-400 dark:text-gray-500 line-through' : 'text-green-600 dark:text-green-400'}`}>
                       +{getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(payment.amount, invoice.currency)}
                     </div>
                     <div className="text-xs text-gray-500 dark:text-gray-400 mt-1">
@@ -191,7 +255,7 @@ const PaymentHistory = ({ invoice }) => {
               <div className="flex justify-between items-center mt-2">
                 <span className="text-sm font-semibold text-gray-700 dark:text-gray-300">Remaining Balance:</span>
                 <span className="text-xl font-bold text-red-600 dark:text-red-400">
-                  {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.total - (invoice.paid_amount || 0), invoice.currency)}
+                  {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.total - (invoice.paid_amount || 0) - (invoice.credited_amount || 0), invoice.currency)}
                 </span>
               </div>
             </div>
@@ -10,7 +10,8 @@ const PaymentModal = ({ invoice, onClose, onPaymentRecorded }) => {
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
 
-  const remainingAmount = invoice.total - (invoice.paid_amount || 0);
+  // Credit notes lower the balance like payments do
+  const remainingAmount = invoice.total - (invoice.paid_amount || 0) - (invoice.credited_amount || 0);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
@@ -164,6 +165,14 @@ const PaymentModal = ({ invoice, onClose, onPaymentRecorded }) => {
                 {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.paid_amount || 0, invoice.currency)}
               </span>
             </div>
+            {invoice.credited_amount > 0 && (
+              <div className="flex justify-between">
+                <span className="text-sm text-gray-600 dark:text-gray-400">Credited Amount:</span>
+                <span className="text-sm font-semibold text-blue-600 dark:text-blue-400">
+                  {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.credited_amount, invoice.currency)}
+                </span>
+              </div>
+            )}
             <div className="flex justify-between pt-2 border-t border-gray-300 dark:border-gray-600">
               <span className="text-sm font-semibold text-gray-900 dark:text-gray-100">Remaining Balance:</span>
               <span className="text-lg font-bold text-red-600 dark:text-red-400">
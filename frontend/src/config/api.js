@@ -27,4 +27,28 @@ export const API_ENDPOINTS = {
   sendReminder: `${API_BASE_URL}/notifications/reminder`,
 };
 
+// Largest page the list endpoints return
+export const MAX_PAGE_LIMIT = 200;
+
+// Fetches every page of a list endpoint, following the X-Next-Cursor header,
+// and returns the rows of all pages in order
+export const fetchAll = async (url) => {
+  const next = new URL(url);
+  next.searchParams.set('limit', MAX_PAGE_LIMIT);
+  const rows = [];
+  for (;;) {
+    const response = await fetch(next);
+    if (!response.ok) {
+      throw new Error(`${response.status} ${response.statusText}`);
+    }
+    const data = await response.json();
+    rows.push(...((Array.isArray(data) ? data : data?.data) || []));
+    const cursor = response.headers.get('X-Next-Cursor');
+    if (!cursor) {
+      return rows;
+    }
+    next.searchParams.set('cursor', cursor);
+  }
+};
+
 export default API_BASE_URL;
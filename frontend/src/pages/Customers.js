@@ -1,4 +1,5 @@
 import React, { useState, useEffect } from 'react';
+import { fetchAll } from '../config/api';
 
 const API_BASE = 'http://localhost:8080';
 
@@ -24,9 +25,7 @@ function Customers() {
 
   const loadCustomers = async () => {
     try {
-      const response = await fetch(`${API_BASE}/customers`);
-      const data = await response.json();
-      setCustomers(data || []);
+      setCustomers(await fetchAll(`${API_BASE}/customers`));
     } catch (error) {
       console.error('Error loading customers:', error);
     }
@@ -66,17 +65,25 @@ function Customers() {
     setLoading(false);
   };
 
-  const deleteCustomer = async (id) => {
+  const deleteCustomer = async (customer) => {
     if (!window.confirm('Are you sure you want to delete this customer?')) return;
     
     try {
-      const response = await fetch(`${API_BASE}/customers/${id}`, {
-        method: 'DELETE'
+      const response = await fetch(`${API_BASE}/customers/${customer.id}`, {
+        method: 'DELETE',
+        headers: customer.version ? { 'If-Match': `"${customer.version}"` } : {}
       });
       if (response.ok) {
         loadCustomers();
         setMessage({ type: 'success', text: '✓ Customer deleted successfully!' });
         setTimeout(() => setMessage(null), 3000);
+      } else if (response.status === 412) {
+        // Someone else changed the customer since it was loaded
+        loadCustomers();
+        setMessage({ type: 'error', text: '✗ This customer was changed by someone else. Please review it before deleting.' });
+      } else {
+        const errorText = await response.text();
+        setMessage({ type: 'error', text: `✗ ${errorText}` });
       }
     } catch (error) {
       setMessage({ type: 'error', text: '✗ Error deleting customer' });
@@ -261,6 +268,11 @@ function Customers() {
                         {customer.company_name && (
                           <span className="px-2 py-1 bg-gray-100 dark:bg-gray-700 text-gray-700 dark:text-gray-300 text-xs rounded-full">{customer.company_name}</span>
                         )}
+                        {(customer.credit_balances || []).map(credit => (
+                          <span key={credit.currency} className="px-2 py-1 bg-green-100 dark:bg-green-900/30 text-green-700 dark:text-green-400 text-xs font-semibold rounded-full" title="Overpayments and receipts not yet applied to invoices">
+                            Credit {credit.currency} {Number(credit.balance).toLocaleString('en-US', { minimumFractionDigits: 2, maximumFractionDigits: 2 })}
+                          </span>
+                        ))}
                       </div>
                       <div className="mt-2 space-y-1">
                         <p className="text-sm text-gray-600 dark:text-gray-400 flex items-center">
@@ -290,7 +302,7 @@ function Customers() {
                     </div>
                   </div>
                   <button
-                    onClick={() => deleteCustomer(customer.id)}
+                    onClick={() => deleteCustomer(customer)}
                     className="ml-4 p-2 text-red-600 hover:bg-red-50 dark:hover:bg-red-900/20 rounded-lg transition-colors"
                     title="Delete customer"
                   >
@@ -1,9 +1,35 @@
 import React, { useState, useEffect } from 'react';
+import { fetchAll } from '../config/api';
 import PaymentModal from '../components/PaymentModal';
 import PaymentHistory from '../components/PaymentHistory';
 
 const API_BASE = 'http://localhost:8080';
 
+// Invoice lifecycle statuses. Partially paid, paid and overdue follow from
+// the payments and the due date; the others are set by hand.
+const STATUS_LABELS = {
+  draft: 'Draft',
+  issued: 'Issued',
+  sent: 'Sent',
+  partially_paid: 'Partially Paid',
+  paid: 'Paid',
+  overdue: 'Overdue',
+  void: 'Void',
+  written_off: 'Written Off'
+};
+
+// Statuses an invoice can be moved to by hand from each status
+const STATUS_TRANSITIONS = {
+  draft: ['issued', 'sent', 'void'],
+  issued: ['sent', 'void', 'written_off'],
+  sent: ['void', 'written_off'],
+  overdue: ['sent', 'void', 'written_off'],
+  partially_paid: ['sent', 'written_off']
+};
+
+// Statuses that accept payments
+const PAYABLE_STATUSES = ['issued', 'sent', 'partially_paid', 'overdue'];
+
 function Invoices() {
   const [customers, setCustomers] = useState([]);
   const [invoices, setInvoices] = useState([]);
@@ -15,10 +41,10 @@ function Invoices() {
   const [selectedInvoice, setSelectedInvoice] = useState(null);
   const [invoiceData, setInvoiceData] = useState({
     customer_id: '',
-    items: [{ description: '', quantity: 1, unit_price: 0 }],
+    items: [{ description: '', quantity: 1, unit: 'pcs', unit_price: 0 }],
     tax: 0,
     discount: 0,
-    status: 'pending',
+    status: 'draft',
     notes: '',
     due_date: '',
     currency: 'USD'
@@ -93,9 +119,7 @@ function Invoices() {
 
   const loadCustomers = async () => {
     try {
-      const response = await fetch(`${API_BASE}/customers`);
-      const data = await response.json();
-      setCustomers(data || []);
+      setCustomers(await fetchAll(`${API_BASE}/customers`));
     } catch (error) {
       console.error('Error loading customers:', error);
     }
@@ -103,9 +127,7 @@ function Invoices() {
 
   const loadInvoices = async () => {
     try {
-      const response = await fetch(`${API_BASE}/invoices`);
-      const data = await response.json();
-      setInvoices(data || []);
+      setInvoices(await fetchAll(`${API_BASE}/invoices`));
     } catch (error) {
       console.error('Error loading invoices:', error);
     }
@@ -190,7 +212,7 @@ function Invoices() {
   const addItem = () => {
     setInvoiceData({
       ...invoiceData,
-      items: [...invoiceData.items, { description: '', quantity: 1, unit_price: 0 }]
+      items: [...invoiceData.items, { description: '', quantity: 1, unit: 'pcs', unit_price: 0 }]
     });
   };
 
@@ -244,10 +266,10 @@ function Invoices() {
         const data = await response.json();
         setInvoiceData({
           customer_id: '',
-          items: [{ description: '', quantity: 1, unit_price: 0 }],
+          items: [{ description: '', quantity: 1, unit: 'pcs', unit_price: 0 }],
           tax: 0,
           discount: 0,
-          status: 'pending',
+          status: 'draft',
           notes: '',
           due_date: '',
           currency: 'USD'
@@ -283,7 +305,7 @@ function Invoices() {
   const openEditModal = (invoice) => {
     setSelectedInvoice(invoice);
     setEditData({
-      status: invoice.status || 'pending',
+      status: invoice.status || 'draft',
       notes: invoice.notes || '',
       due_date: invoice.due_date ? invoice.due_date.split('T')[0] : ''
     });
@@ -296,7 +318,11 @@ function Invoices() {
     try {
       const response = await fetch(`${API_BASE}/invoices/${selectedInvoice.id}`, {
         method: 'PUT',
-        headers: { 'Content-Type': 'application/json' },
+        headers: {
+          'Content-Type': 'application/json',
+          // Only apply the edit to the version that was loaded
+          ...(selectedInvoice.version && { 'If-Match': `"${selectedInvoice.version}"` })
+        },
         body: JSON.stringify(editData)
       });
       if (response.ok) {
@@ -304,6 +330,11 @@ function Invoices() {
         setMessage({ type: 'success', text: '✓ Invoice updated successfully!' });
         setTimeout(() => setMessage(null), 3000);
         loadInvoices();
+      } else if (response.status === 412) {
+        // Someone else changed the invoice since it was loaded
+        setShowEditModal(false);
+        setMessage({ type: 'error', text: '✗ This invoice was changed by someone else. It has been reloaded; please edit it again.' });
+        loadInvoices();
       } else {
         const errorText = await response.text();
         setMessage({ type: 'error', text: `✗ ${errorText}` });
@@ -402,6 +433,12 @@ function Invoices() {
                   + Add Item
                 </button>
               </div>
+              <datalist id="item-units">
+                <option value="pcs" />
+                <option value="hour" />
+                <option value="day" />
+                <option value="kg" />
+              </datalist>
               <div className="space-y-3">
                 {invoiceData.items.map((item, index) => (
                   <div key={index} className="flex gap-3 p-3 bg-gray-50 dark:bg-gray-700/50 rounded-lg border border-gray-200 dark:border-gray-700">
@@ -421,11 +458,22 @@ function Invoices() {
                         placeholder="Qty"
                         value={item.quantity}
                         onChange={(e) => updateItem(index, 'quantity', e.target.value)}
-                        min="1"
+                        step={item.unit === 'pcs' ? '1' : 'any'}
+                        min="0"
                         required
                         className="w-full px-2 py-2 bg-white dark:bg-gray-700 border border-gray-300 dark:border-gray-600 rounded-md text-sm focus:ring-2 focus:ring-purple-500 focus:border-purple-500 dark:placeholder-gray-400 dark:text-white"
                       />
                     </div>
+                    <div className="w-20">
+                      <input
+                        type="text"
+                        placeholder="Unit"
+                        list="item-units"
+                        value={item.unit || ''}
+                        onChange={(e) => updateItem(index, 'unit', e.target.value)}
+                        className="w-full px-2 py-2 bg-white dark:bg-gray-700 border border-gray-300 dark:border-gray-600 rounded-md text-sm focus:ring-2 focus:ring-purple-500 focus:border-purple-500 dark:placeholder-gray-400 dark:text-white"
+                      />
+                    </div>
                     <div className="w-24">
                       <input
                         type="number"
@@ -463,9 +511,9 @@ function Invoices() {
                   required
                   className="w-full px-4 py-2.5 bg-white dark:bg-gray-700 border border-gray-300 dark:border-gray-600 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-purple-500 dark:placeholder-gray-400 dark:text-white"
                 >
-                  <option value="pending">Pending</option>
-                  <option value="paid">Paid</option>
-                  <option value="cancelled">Cancelled</option>
+                  <option value="draft">Draft</option>
+                  <option value="issued">Issued</option>
+                  <option value="sent">Sent</option>
                 </select>
               </div>
               <div>
@@ -562,10 +610,10 @@ function Invoices() {
                   setPreviousCurrency('USD'); // Reset previous currency on cancel
                   setInvoiceData({
                     customer_id: '',
-                    items: [{ description: '', quantity: 1, unit_price: 0 }],
+                    items: [{ description: '', quantity: 1, unit: 'pcs', unit_price: 0 }],
                     tax: 0,
                     discount: 0,
-                    status: 'pending',
+                    status: 'draft',
                     notes: '',
                     due_date: '',
                     currency: 'USD'
@@ -593,9 +641,9 @@ function Invoices() {
               className="w-full px-4 py-2.5 bg-white dark:bg-gray-700 border border-gray-300 dark:border-gray-600 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-purple-500 dark:text-white"
             >
               <option value="all">All Status</option>
-              <option value="pending">Pending</option>
-              <option value="paid">Paid</option>
-              <option value="cancelled">Cancelled</option>
+              {Object.entries(STATUS_LABELS).map(([value, label]) => (
+                <option key={value} value={value}>{label}</option>
+              ))}
             </select>
           </div>
           <div>
@@ -662,7 +710,7 @@ function Invoices() {
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Invoice #</th>
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Customer</th>
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Total</th>
-                    <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Payment Status</th>
+                    <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Status</th>
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Items</th>
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Created</th>
                     <th className="px-6 py-3 text-left text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Actions</th>
@@ -686,16 +734,15 @@ function Invoices() {
                       </td>
                       <td className="px-6 py-4 whitespace-nowrap">
                         <span className={`px-2 py-1 text-xs font-semibold rounded-full ${
-                          invoice.payment_status === 'paid' ? 'bg-green-100 text-green-800 dark:bg-green-900/50 dark:text-green-300' :
-                          invoice.payment_status === 'overdue' ? 'bg-red-100 text-red-800 dark:bg-red-900/50 dark:text-red-300' :
-                          invoice.payment_status === 'partially_paid' ? 'bg-blue-100 text-blue-800 dark:bg-blue-900/50 dark:text-blue-300' :
+                          invoice.status === 'paid' ? 'bg-green-100 text-green-800 dark:bg-green-900/50 dark:text-green-300' :
+                          invoice.status === 'overdue' ? 'bg-red-100 text-red-800 dark:bg-red-900/50 dark:text-red-300' :
+                          invoice.status === 'partially_paid' ? 'bg-blue-100 text-blue-800 dark:bg-blue-900/50 dark:text-blue-300' :
+                          ['draft', 'void', 'written_off'].includes(invoice.status) ? 'bg-gray-100 text-gray-700 dark:bg-gray-700 dark:text-gray-300' :
                           'bg-yellow-100 text-yellow-800 dark:bg-yellow-900/50 dark:text-yellow-300'
                         }`}>
-                          {invoice.payment_status ? 
-                            invoice.payment_status.split('_').map(word => word.charAt(0).toUpperCase() + word.slice(1)).join(' ') 
-                            : 'Unpaid'}
+                          {STATUS_LABELS[invoice.status] || invoice.status}
                         </span>
-                        {invoice.payment_status === 'partially_paid' && (
+                        {invoice.paid_amount > 0 && invoice.status !== 'paid' && (
                           <div className="text-xs text-gray-500 dark:text-gray-400 mt-1">
                             {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.paid_amount || 0, invoice.currency)} / {getCurrencySymbol(invoice.currency)}{formatCurrencyAmount(invoice.total, invoice.currency)}
                           </div>
@@ -715,8 +762,8 @@ function Invoices() {
                       </td>
                       <td className="px-6 py-4 whitespace-nowrap text-sm">
                         <div className="flex items-center gap-2">
-                          {/* Record Payment Button - Only for unpaid/partially_paid/overdue */}
-                          {(invoice.payment_status === 'unpaid' || invoice.payment_status === 'partially_paid' || invoice.payment_status === 'overdue') && (
+                          {/* Record Payment Button - Only for issued invoices with a balance */}
+                          {PAYABLE_STATUSES.includes(invoice.status) && (
                             <button
                               onClick={() => openPaymentModal(invoice)}
                               className="px-3 py-1.5 bg-green-600 hover:bg-green-700 text-white rounded-lg font-medium transition-colors text-xs"
@@ -788,9 +835,9 @@ function Invoices() {
                   required
                   className="w-full px-4 py-2.5 bg-white dark:bg-gray-700 border border-gray-300 dark:border-gray-600 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-purple-500 dark:placeholder-gray-400 dark:text-white"
                 >
-                  <option value="pending">Pending</option>
-                  <option value="paid">Paid</option>
-                  <option value="cancelled">Cancelled</option>
+                  {[selectedInvoice.status, ...(STATUS_TRANSITIONS[selectedInvoice.status] || [])].map(value => (
+                    <option key={value} value={value}>{STATUS_LABELS[value] || value}</option>
+                  ))}
                 </select>
               </div>
               <div>